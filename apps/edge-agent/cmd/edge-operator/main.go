@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/operator"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentURL   string
+	namespace  string
+	allNS      bool
+	resync     time.Duration
+	kubeAPI    string
+	kubeToken  string
+	healthAddr string
+	logLevel   string
+)
+
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:   "edge-operator",
+		Short: "FusionFlow Edge Operator",
+		Long:  `A Kubernetes operator reconciling Flow and Connector resources into a FusionFlow edge agent`,
+		RunE:  run,
+	}
+
+	rootCmd.Flags().StringVar(&agentURL, "agent-url", "http://edge-agent:8080", "base URL of the edge agent")
+	rootCmd.Flags().StringVar(&namespace, "namespace", "", "namespace to watch (default is the operator's namespace)")
+	rootCmd.Flags().BoolVar(&allNS, "all-namespaces", false, "watch resources in all namespaces")
+	rootCmd.Flags().DurationVar(&resync, "resync", 30*time.Second, "interval between full reconciles")
+	rootCmd.Flags().StringVar(&kubeAPI, "kube-api", "", "Kubernetes API server URL, e.g. from kubectl proxy (default is in-cluster)")
+	rootCmd.Flags().StringVar(&kubeToken, "kube-token", "", "bearer token for --kube-api")
+	rootCmd.Flags().StringVar(&healthAddr, "health-addr", ":8081", "address serving /healthz")
+	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "log level")
+
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	logger := logrus.New()
+	level, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	logger.SetLevel(level)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	kube, err := kubeClient()
+	if err != nil {
+		return err
+	}
+	ns := namespace
+	if allNS {
+		ns = ""
+	} else if ns == "" {
+		if ns = operator.PodNamespace(); ns == "" {
+			return errors.New("--namespace or --all-namespaces is required outside a cluster")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := &http.Server{Addr: healthAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Health server failed: %v", err)
+		}
+	}()
+
+	op := operator.New(kube, operator.NewAgent(agentURL), logger, operator.Options{Namespace: ns, Resync: resync})
+	err = op.Run(ctx)
+
+	logger.Info("Shutting down edge operator...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("Health server forced to shutdown: %v", err)
+	}
+	return err
+}
+
+// kubeClient connects to the API server given by flags, or to the cluster
+// the operator runs in
+func kubeClient() (*operator.Kube, error) {
+	if kubeAPI != "" {
+		return operator.NewKube(kubeAPI, kubeToken), nil
+	}
+	kube, err := operator.InCluster()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure Kubernetes client: %w", err)
+	}
+	return kube, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/fusionflow/edge-agent/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// completionTimeout bounds the API requests completing IDs, so that a
+// down agent does not hang the shell
+const completionTimeout = 3 * time.Second
+
+// newCompletionCmd builds the `completion` command, which writes the shell
+// completion script of the CLI
+func newCompletionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish|powershell",
+		Short: "Write the shell completion script",
+		Long: `Write the completion script of edge-agent for a shell to stdout. Commands,
+flags and flag values complete in every shell; the ctl commands also complete
+flow and execution IDs from the agent they manage.
+
+  bash:        source <(edge-agent completion bash)
+               or save it to /etc/bash_completion.d/edge-agent
+  zsh:         edge-agent completion zsh > "${fpath[1]}/_edge-agent"
+  fish:        edge-agent completion fish > ~/.config/fish/completions/edge-agent.fish
+  powershell:  edge-agent completion powershell | Out-String | Invoke-Expression`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			case "fish":
+				return root.GenFishCompletion(os.Stdout, true)
+			case "powershell":
+				return root.GenPowerShellCompletionWithDesc(os.Stdout)
+			}
+			return cmd.Help()
+		},
+	}
+}
+
+// completionFunc completes the arguments or a flag of a command
+type completionFunc = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeFlowIDs completes the IDs of the agent's flows, described by
+// their names
+func completeFlowIDs(newClient ctlClient) completionFunc {
+	return completeIDs(newClient, func(ctx context.Context, c *client.Client) ([]string, error) {
+		flows, err := c.ListFlows(&client.ListOptions{PageSize: 100, Sort: "name"}).All(ctx)
+		ids := make([]string, len(flows))
+		for i, f := range flows {
+			ids[i] = f.ID + "\t" + f.Name
+		}
+		return ids, err
+	})
+}
+
+// completeExecutionIDs completes the IDs of the agent's latest
+// executions, described by their flow and status
+func completeExecutionIDs(newClient ctlClient) completionFunc {
+	return completeIDs(newClient, func(ctx context.Context, c *client.Client) ([]string, error) {
+		var ids []string
+		it := c.ListExecutions(&client.ListOptions{PageSize: 50, Sort: "-createdAt"})
+		for len(ids) < 50 && it.Next(ctx) {
+			e := it.Item()
+			ids = append(ids, e.ID+"\t"+e.FlowID+" "+e.Status)
+		}
+		return ids, it.Err()
+	})
+}
+
+// completeIDs completes arguments with the IDs list returns from the agent
+func completeIDs(newClient ctlClient, list func(ctx context.Context, c *client.Client) ([]string, error)) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		c, err := newClient()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+		ids, err := list(ctx, c)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// firstArg completes only the first argument, with complete
+func firstArg(complete completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(cmd, args, toComplete)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// serverEnv and tokenEnv supply the agent's API address and token when
+// --server and --token are not set
+const (
+	serverEnv = "FUSIONFLOW_EDGE_AGENT_SERVER"
+	tokenEnv  = "FUSIONFLOW_EDGE_AGENT_TOKEN"
+)
+
+// ctlClient returns a client of the agent the ctl commands manage
+type ctlClient func() (*client.Client, error)
+
+// newCtlCmd builds the `ctl` command tree, which manages a running agent
+// through its API
+func newCtlCmd() *cobra.Command {
+	var server, token string
+
+	ctlCmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Manage a running agent through its API",
+		Long: `Manage a running agent, local or remote, through its management API. The
+agent is reached at --server, or ` + serverEnv + `, and defaults to
+http://localhost:8080. Listeners requiring a bearer token get --token, or
+` + tokenEnv + `.`,
+	}
+	ctlCmd.PersistentFlags().StringVar(&server, "server", "", "URL of the agent's API")
+	ctlCmd.PersistentFlags().StringVar(&token, "token", "", "bearer token of the agent's API")
+	out := addOutputFlag(ctlCmd)
+
+	newClient := func() (*client.Client, error) {
+		if server == "" {
+			server = os.Getenv(serverEnv)
+		}
+		if server == "" {
+			server = "http://localhost:8080"
+		}
+		if token == "" {
+			token = os.Getenv(tokenEnv)
+		}
+		var opts []client.Option
+		if token != "" {
+			opts = append(opts, client.WithToken(token))
+		}
+		return client.New(server, append(opts, client.WithUserAgent("edge-agent-ctl"))...)
+	}
+
+	ctlCmd.AddCommand(newCtlFlowsCmd(newClient, out), newCtlExecutionsCmd(newClient, out), newCtlDiagnosticsCmd(newClient, out))
+	return ctlCmd
+}
+
+// newCtlFlowsCmd builds `ctl flows`
+func newCtlFlowsCmd(newClient ctlClient, out *outputFormat) *cobra.Command {
+	var status, name string
+
+	flowsCmd := &cobra.Command{
+		Use:   "flows",
+		Short: "List, inspect, activate and deactivate flows",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List flows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				opts := &client.ListOptions{PageSize: 100, Sort: "name", Filter: map[string]string{}}
+				if status != "" {
+					opts.Filter["status"] = status
+				}
+				if name != "" {
+					opts.Filter["name"] = name
+				}
+				flows, err := c.ListFlows(opts).All(ctx)
+				if err != nil {
+					return err
+				}
+				return out.print(flows, func(w io.Writer) {
+					fmt.Fprintln(w, "ID\tNAME\tSTATUS\tVERSION\tUPDATED AT")
+					for _, f := range flows {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", f.ID, f.Name, f.Status, f.Version, f.UpdatedAt.Format(time.RFC3339))
+					}
+				})
+			})
+		},
+	}
+	listCmd.Flags().StringVar(&status, "status", "", "only flows with this status (draft|active|inactive)")
+	listCmd.Flags().StringVar(&name, "name", "", "only flows whose name contains this text")
+	_ = listCmd.RegisterFlagCompletionFunc("status", cobra.FixedCompletions(
+		[]string{model.FlowStatusDraft, model.FlowStatusActive, model.FlowStatusInactive}, cobra.ShellCompDirectiveNoFileComp))
+
+	getCmd := &cobra.Command{
+		Use:               "get ID",
+		Short:             "Show a flow; its whole definition with --output json or yaml",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(completeFlowIDs(newClient)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				flow, err := c.GetFlow(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				return out.print(flow, func(w io.Writer) {
+					fmt.Fprintf(w, "ID:\t%s\nName:\t%s\nStatus:\t%s\nVersion:\t%d of %d\n", flow.ID, flow.Name, flow.Status, flow.Version, flow.Versions)
+					if flow.DraftVersion != 0 {
+						fmt.Fprintf(w, "Draft version:\t%d\n", flow.DraftVersion)
+					}
+					fmt.Fprintf(w, "Triggers:\t%d\nSteps:\t%d\nUpdated at:\t%s\n", len(flow.Triggers), len(flow.Steps), flow.UpdatedAt.Format(time.RFC3339))
+				})
+			})
+		},
+	}
+
+	flowsCmd.AddCommand(listCmd, getCmd,
+		newCtlFlowActionCmd(newClient, out, "activate", "Activate a flow, starting its triggers", (*client.Client).ActivateFlow),
+		newCtlFlowActionCmd(newClient, out, "deactivate", "Deactivate a flow, stopping its triggers", (*client.Client).DeactivateFlow))
+	return flowsCmd
+}
+
+// newCtlFlowActionCmd builds a `ctl flows` command changing the status of
+// the flows given
+func newCtlFlowActionCmd(newClient ctlClient, out *outputFormat, use, short string, action func(*client.Client, context.Context, string) (*client.FlowState, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:               use + " ID...",
+		Short:             short,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeFlowIDs(newClient),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				states := make([]*client.FlowState, 0, len(args))
+				var err error
+				for _, id := range args {
+					state, actionErr := action(c, ctx, id)
+					if actionErr != nil {
+						err = fmt.Errorf("failed to %s flow %s: %w", use, id, actionErr)
+						break
+					}
+					states = append(states, state)
+				}
+				// The flows changed before a failure are reported too
+				if printErr := out.print(states, func(w io.Writer) {
+					for _, state := range states {
+						fmt.Fprintf(w, "Flow %s is %s (version %d)\n", state.ID, state.Status, state.Version)
+					}
+				}); err == nil {
+					err = printErr
+				}
+				return err
+			})
+		},
+	}
+}
+
+// newCtlExecutionsCmd builds `ctl executions`
+func newCtlExecutionsCmd(newClient ctlClient, out *outputFormat) *cobra.Command {
+	var (
+		flowID string
+		status string
+		limit  int
+		input  string
+		follow bool
+		reason string
+	)
+
+	executionsCmd := &cobra.Command{
+		Use:   "executions",
+		Short: "Run, inspect and cancel executions and follow their logs",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the latest executions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				opts := &client.ListOptions{PageSize: limit, Sort: "-createdAt", Filter: map[string]string{}}
+				if flowID != "" {
+					opts.Filter["flowId"] = flowID
+				}
+				if status != "" {
+					opts.Filter["status"] = status
+				}
+				it := c.ListExecutions(opts)
+				execs := make([]client.Execution, 0, limit)
+				for len(execs) < limit && it.Next(ctx) {
+					execs = append(execs, it.Item())
+				}
+				if err := it.Err(); err != nil {
+					return err
+				}
+				return out.print(execs, func(w io.Writer) {
+					fmt.Fprintln(w, "ID\tFLOW\tSTATUS\tQUEUED AT\tERROR")
+					for _, e := range execs {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.ID, e.FlowID, e.Status, e.QueuedAt.Format(time.RFC3339), e.Error)
+					}
+				})
+			})
+		},
+	}
+	listCmd.Flags().StringVar(&flowID, "flow", "", "only executions of this flow")
+	listCmd.Flags().StringVar(&status, "status", "", "only executions with this status")
+	listCmd.Flags().IntVar(&limit, "limit", 20, "number of executions to list")
+	_ = listCmd.RegisterFlagCompletionFunc("flow", completeFlowIDs(newClient))
+	_ = listCmd.RegisterFlagCompletionFunc("status", cobra.FixedCompletions([]string{
+		model.ExecutionQueued, model.ExecutionRunning, model.ExecutionWaiting, model.ExecutionSucceeded,
+		model.ExecutionFailed, model.ExecutionCancelled, model.ExecutionBudgetExceeded,
+	}, cobra.ShellCompDirectiveNoFileComp))
+
+	getCmd := &cobra.Command{
+		Use:               "get ID",
+		Short:             "Show an execution and its steps",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(completeExecutionIDs(newClient)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				exec, err := c.GetExecution(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				return out.print(exec, func(w io.Writer) {
+					fmt.Fprintf(w, "ID:\t%s\nFlow:\t%s (version %d)\nStatus:\t%s\nQueued at:\t%s\n",
+						exec.ID, exec.FlowID, exec.FlowVersion, exec.Status, exec.QueuedAt.Format(time.RFC3339))
+					if exec.EndTime != nil {
+						fmt.Fprintf(w, "Ended at:\t%s\n", exec.EndTime.Format(time.RFC3339))
+					}
+					if exec.Error != "" {
+						fmt.Fprintf(w, "Error:\t%s\n", exec.Error)
+					}
+					if len(exec.Steps) > 0 {
+						fmt.Fprintln(w, "\nSTEP\tSTATUS\tERROR")
+						for _, step := range exec.Steps {
+							fmt.Fprintf(w, "%s\t%s\t%s\n", step.ID, step.Status, step.Error)
+						}
+					}
+				})
+			})
+		},
+	}
+
+	runCmd := &cobra.Command{
+		Use:   "run FLOW_ID",
+		Short: "Run a flow on an input read from a JSON file",
+		Long: `Run a flow on the JSON document in --input, "-" for stdin, or on an empty
+object. With --follow the execution's logs are printed until it finishes,
+and the command fails unless it succeeded.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(completeFlowIDs(newClient)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			payload := json.RawMessage("{}")
+			if input != "" {
+				data, err := readInput(input)
+				if err != nil {
+					return err
+				}
+				if !json.Valid(data) {
+					return fmt.Errorf("input %s is not valid JSON", input)
+				}
+				payload = data
+			}
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				exec, err := c.Execute(ctx, args[0], payload, nil)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Started execution %s of flow %s\n", exec.ID, exec.FlowID)
+				if !follow {
+					return out.print(exec, func(w io.Writer) { fmt.Fprintln(w, exec.ID) })
+				}
+				return tailLogs(ctx, c, *out, exec.ID)
+			})
+		},
+	}
+	runCmd.Flags().StringVarP(&input, "input", "i", "", "JSON file holding the input, - for stdin")
+	runCmd.Flags().BoolVarP(&follow, "follow", "f", false, "print the execution's logs until it finishes")
+
+	logsCmd := &cobra.Command{
+		Use:   "logs ID",
+		Short: "Print the log entries of an execution, one per line or document",
+		Long: `Print the log entries of an execution: a line each in table format, a
+line of JSON each in json and a document each in yaml. With --follow new
+entries are printed until the execution finishes, and the command fails
+unless it succeeded.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(completeExecutionIDs(newClient)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				if follow {
+					return tailLogs(ctx, c, *out, args[0])
+				}
+				it := c.ExecutionLogs(args[0], &client.ListOptions{PageSize: 100})
+				for it.Next(ctx) {
+					log := it.Item()
+					if err := printLog(*out, &log); err != nil {
+						return err
+					}
+				}
+				return it.Err()
+			})
+		},
+	}
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing new entries until the execution finishes")
+
+	cancelCmd := &cobra.Command{
+		Use:               "cancel ID",
+		Short:             "Cancel an execution",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(completeExecutionIDs(newClient)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				cancellation, err := c.CancelExecution(ctx, args[0], reason, os.Getenv("USER"))
+				if err != nil {
+					return err
+				}
+				return out.print(cancellation, func(w io.Writer) { fmt.Fprintln(w, cancellation.Message) })
+			})
+		},
+	}
+	cancelCmd.Flags().StringVar(&reason, "reason", "", "reason recorded with the cancellation")
+
+	executionsCmd.AddCommand(listCmd, getCmd, runCmd, logsCmd, cancelCmd)
+	return executionsCmd
+}
+
+// newCtlDiagnosticsCmd builds `ctl diagnostics`
+func newCtlDiagnosticsCmd(newClient ctlClient, out *outputFormat) *cobra.Command {
+	diagnosticsCmd := &cobra.Command{
+		Use:   "diagnostics",
+		Short: "Collect diagnostics for support",
+	}
+
+	dumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Have the agent write a diagnostic dump for a support bundle",
+		Long: `Have the agent write its goroutine stacks and the state of its components
+to a file in its diagnostics directory, on the agent's host, and print where.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withCtl(newClient, func(ctx context.Context, c *client.Client) error {
+				dump, err := c.WriteDiagnosticDump(ctx)
+				if err != nil {
+					return err
+				}
+				return out.print(dump, func(w io.Writer) {
+					fmt.Fprintf(w, "Wrote diagnostic dump of %d goroutine(s) to %s (%d bytes)\n", dump.Goroutines, dump.Path, dump.Size)
+				})
+			})
+		},
+	}
+
+	diagnosticsCmd.AddCommand(dumpCmd)
+	return diagnosticsCmd
+}
+
+// withCtl runs fn with a client, until it returns or is interrupted
+func withCtl(newClient ctlClient, fn func(ctx context.Context, c *client.Client) error) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return fn(ctx, c)
+}
+
+// tailLogs prints the log entries of an execution until it finishes,
+// failing unless it succeeded
+func tailLogs(ctx context.Context, c *client.Client, out outputFormat, id string) error {
+	status, err := c.TailExecutionLogs(ctx, id, 0, func(log *client.ExecutionLog) error {
+		return printLog(out, log)
+	})
+	if err != nil {
+		return err
+	}
+	if status != model.ExecutionSucceeded {
+		return fmt.Errorf("execution %s finished as %s", id, status)
+	}
+	fmt.Fprintf(os.Stderr, "Execution %s %s\n", id, status)
+	return nil
+}
+
+// printLog prints a log entry, as one line in table format
+func printLog(out outputFormat, log *client.ExecutionLog) error {
+	return out.printItem(log, func() string {
+		step := ""
+		if log.StepID != "" {
+			step = " [" + log.StepID + "]"
+		}
+		fields := ""
+		if len(log.Fields) > 0 {
+			if data, err := json.Marshal(log.Fields); err == nil {
+				fields = " " + string(data)
+			}
+		}
+		return fmt.Sprintf("%s %-5s%s %s%s", log.Time.Format(time.RFC3339Nano), log.Level, step, log.Message, fields)
+	})
+}
+
+// readInput reads a file, or stdin for "-"
+func readInput(name string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/crypto"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// newEncryptionCmd builds the `encryption` command tree for managing
+// encryption at rest of the store
+func newEncryptionCmd() *cobra.Command {
+	encryptionCmd := &cobra.Command{
+		Use:   "encryption",
+		Short: "Manage encryption at rest of the local store",
+	}
+	out := addOutputFlag(encryptionCmd)
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Re-encrypt records under the primary key",
+		Long: `Rotate rewrites every record that is not encrypted under the primary key
+of storage.encryption: records sealed under an older key, records written
+before encryption was enabled, and records of buckets no longer encrypted,
+which are decrypted. Once it has run, keys other than the primary can be
+removed from the configuration.
+
+Rewritten records get a new updatedAt, which postpones their archiving.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if err := secrets.ExpandConfig(cfg); err != nil {
+				return fmt.Errorf("failed to resolve config secrets: %w", err)
+			}
+			if !cfg.Storage.Encryption.Enabled {
+				return fmt.Errorf("storage encryption is not enabled")
+			}
+
+			st, err := openStore(cfg.Storage)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+
+			counts, err := st.(*crypto.Store).Rotate(context.Background())
+			if printErr := out.print(map[string]interface{}{"records": counts}, func(w io.Writer) {
+				fmt.Fprintf(w, "Re-encrypted %s\n", describeRecords(counts))
+			}); err == nil {
+				err = printErr
+			}
+			return err
+		},
+	}
+
+	encryptionCmd.AddCommand(rotateCmd)
+	return encryptionCmd
+}
+
+// openStore opens the configured store, encrypting and decrypting records
+// when storage encryption is enabled
+func openStore(cfg config.StorageConfig) (store.Store, error) {
+	st, err := store.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	if !cfg.Encryption.Enabled {
+		return st, nil
+	}
+	keys, err := crypto.NewKeyring(cfg.Encryption)
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("failed to load storage encryption keys: %w", err)
+	}
+	return crypto.NewStore(st, keys, cfg.Encryption.Buckets), nil
+}
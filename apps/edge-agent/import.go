@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/importer"
+	"github.com/spf13/cobra"
+)
+
+// newImportCmd builds the `import` command, which converts legacy flow
+// definitions into FusionFlow flows
+func newImportCmd() *cobra.Command {
+	var (
+		format string
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Convert external definitions into FusionFlow flows or connectors",
+		Long: `Convert a Node-RED flow export (JSON) or Camel YAML DSL routes into
+FusionFlow flow definitions, or generate connectors from an OpenAPI 3 or
+AsyncAPI document. Use "-" to read from stdin.
+
+Flows are written as a JSON array of definitions for /api/v1/flows and
+connectors as one for /api/v1/connectors. Nodes that cannot be converted are
+reported on stderr and kept as "unsupported" placeholder steps.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				data []byte
+				err  error
+			)
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", args[0], err)
+			}
+
+			result, err := importer.Import(format, data)
+			if err != nil {
+				return err
+			}
+			for _, warning := range result.Warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+			}
+
+			var out interface{} = result.Flows
+			if slices.Contains(importer.ConnectorFormats(), format) {
+				out = result.Connectors
+			}
+			encoded, err := json.MarshalIndent(out, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode definitions: %w", err)
+			}
+			encoded = append(encoded, '\n')
+			if output == "" {
+				_, err = os.Stdout.Write(encoded)
+				return err
+			}
+			if err := os.WriteFile(output, encoded, 0o644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", output, err)
+			}
+			fmt.Fprintf(os.Stderr, "Converted %d flow(s) and %d connector(s) with %d warning(s) to %s\n",
+				len(result.Flows), len(result.Connectors), len(result.Warnings), output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "", "source format ("+strings.Join(importer.Formats(), "|")+")")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write definitions to this file instead of stdout")
+	_ = cmd.MarkFlagRequired("format")
+	_ = cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(importer.Formats(), cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader is the header holding an API key; keys may also be sent as
+// bearer tokens
+const APIKeyHeader = "X-API-Key"
+
+// Methods of Principal
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// ErrNoCredentials is returned for requests presenting no API key or token
+var ErrNoCredentials = errors.New("credentials required")
+
+// ErrInvalidCredentials is returned for requests whose API key or token
+// does not verify
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is who a request is authenticated as: the name of its API key,
+// or the subject of its JWT
+type Principal struct {
+	Name   string
+	Method string
+}
+
+// apiKey is an API key of the configuration, by the digest of the key
+type apiKey struct {
+	name   string
+	digest []byte
+}
+
+// Authenticator checks the API keys and JWT bearer tokens of requests
+type Authenticator struct {
+	keys []apiKey
+	// jwt verifies bearer tokens that are JWTs; nil without a JWKS URL
+	jwt *verifier
+}
+
+// New creates the authenticator of cfg, whose API key hashes were checked
+// when the configuration was loaded
+func New(cfg config.AuthConfig, logger *logrus.Logger) *Authenticator {
+	a := &Authenticator{}
+	for _, key := range cfg.APIKeys {
+		digest, _ := hex.DecodeString(strings.TrimPrefix(key.Hash, config.APIKeyHashPrefix))
+		a.keys = append(a.keys, apiKey{name: key.Name, digest: digest})
+	}
+	if cfg.JWT.JWKSURL != "" {
+		a.jwt = newVerifier(cfg.JWT, logger)
+	}
+	return a
+}
+
+// Authenticate returns who r is authenticated as by its API key or bearer
+// token. Bearer tokens shaped like a JWT are verified as one; others are
+// taken as API keys.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.apiKey(key)
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrNoCredentials
+	}
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		return a.jwt.verify(r.Context(), token, time.Now())
+	}
+	return a.apiKey(token)
+}
+
+// apiKey returns the principal of the API key key. Every configured key is
+// compared, so that the time taken does not tell which one nearly matched.
+func (a *Authenticator) apiKey(key string) (*Principal, error) {
+	digest := sha256.Sum256([]byte(key))
+	var name string
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], k.digest) == 1 {
+			name = k.name
+		}
+	}
+	if name == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: name, Method: MethodAPIKey}, nil
+}
+
+// Refresh fetches the JWT keys until ctx is cancelled, so that rotated
+// keys are known before the first token signed with them arrives
+func (a *Authenticator) Refresh(ctx context.Context) {
+	if a.jwt != nil {
+		a.jwt.keys.run(ctx)
+	}
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refetchInterval is how long a token naming an unknown key waits before
+// it fetches the keys again, so that forged key IDs cannot flood the
+// identity provider
+const refetchInterval = time.Minute
+
+// curves are the curves of the ES algorithms
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// jwk is a JSON Web Key of a key set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a verification key of a key set
+type publicKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// keySet is the verification keys a JWKS URL serves, fetched again every
+// refresh interval and when tokens name unknown keys. Keys that cannot be
+// fetched again stay in use.
+type keySet struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	logger  *logrus.Logger
+
+	mu        sync.Mutex
+	keys      []publicKey
+	fetched   time.Time
+	attempted time.Time
+	// fetching is closed when the fetch in progress ends, and nil when
+	// none is; err is the failure of the last fetch
+	fetching chan struct{}
+	err      error
+}
+
+func newKeySet(url string, refresh time.Duration, logger *logrus.Logger) *keySet {
+	return &keySet{url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}, logger: logger}
+}
+
+// key returns the key kid verifying tokens signed with alg; tokens without
+// a kid are verified with the first key suiting alg. Keys are fetched
+// without holding mu, so that tokens signed with known keys are verified
+// while a fetch is in progress.
+func (s *keySet) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	now := time.Now()
+	s.mu.Lock()
+	if now.Sub(s.fetched) >= s.refresh && now.Sub(s.attempted) >= refetchInterval {
+		s.refetch(now)
+	}
+	key, fetching := s.find(kid, alg), s.fetching
+	// The provider may have rotated to a key signing before it was
+	// fetched
+	if key == nil && fetching == nil && now.Sub(s.attempted) >= refetchInterval {
+		fetching = s.refetch(now)
+	}
+	s.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	if fetching != nil {
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key := s.find(kid, alg); key != nil {
+		return key, nil
+	}
+	if len(s.keys) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, errors.New("no JWT keys fetched from " + s.url)
+	}
+	return nil, fmt.Errorf("%w: unknown token key %q", ErrInvalidCredentials, kid)
+}
+
+// find returns the key kid suiting alg, or nil
+func (s *keySet) find(kid, alg string) crypto.PublicKey {
+	for _, k := range s.keys {
+		if (kid == "" || k.kid == kid) && (k.alg == "" || k.alg == alg) && suits(k.key, alg) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// suits reports whether key can verify signatures of alg
+func suits(key crypto.PublicKey, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return curves[alg] == k.Curve
+	}
+	return false
+}
+
+// refetch starts fetching the key set again unless a fetch is in progress,
+// which concurrent requests share, and returns the channel closed when it
+// ends. The caller holds mu.
+func (s *keySet) refetch(now time.Time) chan struct{} {
+	if s.fetching == nil {
+		s.attempted = now
+		s.fetching = make(chan struct{})
+		go s.fetch(s.fetching, now)
+	}
+	return s.fetching
+}
+
+// fetch reads the key set and installs it, closing done. It does not run
+// on behalf of one request, so that requests giving up on the fetch do not
+// cancel it for the others.
+func (s *keySet) fetch(done chan struct{}, now time.Time) {
+	keys, err := s.load(context.Background())
+	s.mu.Lock()
+	if err == nil {
+		s.keys, s.fetched = keys, now
+	}
+	s.err = err
+	s.fetching = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+// load reads the keys of the key set from the URL
+func (s *keySet) load(ctx context.Context) ([]publicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWT keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWT keys: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWT keys: %w", err)
+	}
+
+	var keys []publicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			s.logger.Warnf("Ignoring JWT key %q of %s: %v", k.Kid, s.url, err)
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable JWT keys at " + s.url)
+	}
+	return keys, nil
+}
+
+// publicKey decodes the RSA or EC public key of k
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeInt decodes a base64url big-endian integer of a JWK
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// run fetches the keys every refresh interval until ctx is cancelled
+func (s *keySet) run(ctx context.Context) {
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		fetching := s.refetch(time.Now())
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-fetching:
+		}
+		s.mu.Lock()
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			s.logger.Warnf("Keeping the current JWT keys: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// algorithms are the JWT signature algorithms accepted, by their hash;
+// none and the HMAC algorithms are refused, as the agent holds no shared
+// secret
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// verifier verifies JWTs against the keys of a JWKS URL
+type verifier struct {
+	keys     *keySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+func newVerifier(cfg config.JWTConfig, logger *logrus.Logger) *verifier {
+	return &verifier{
+		keys:     newKeySet(cfg.JWKSURL, time.Duration(cfg.RefreshInterval)*time.Second, logger),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		leeway:   time.Duration(cfg.Leeway) * time.Second,
+	}
+}
+
+// header is the JOSE header of a JWT
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// claims are the registered claims of a JWT the agent checks
+type claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *numeric `json:"exp"`
+	NotBefore *numeric `json:"nbf"`
+}
+
+// audience is the aud claim, a string or an array of strings
+type audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// numeric is a NumericDate claim, seconds since the epoch that may have a
+// fraction
+type numeric float64
+
+func (n numeric) time() time.Time {
+	return time.Unix(0, int64(float64(n)*float64(time.Second)))
+}
+
+// verify checks the signature and claims of token at now
+func (v *verifier) verify(ctx context.Context, token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrInvalidCredentials)
+	}
+	hash, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported token algorithm %q", ErrInvalidCredentials, h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrInvalidCredentials)
+	}
+	key, err := v.keys.key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key, h.Alg, hash, digest.Sum(nil), sig) {
+		return nil, fmt.Errorf("%w: bad token signature", ErrInvalidCredentials)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrInvalidCredentials)
+	}
+	if c.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	}
+	if now.After(c.ExpiresAt.time().Add(v.leeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+	if c.NotBefore != nil && now.Add(v.leeway).Before(c.NotBefore.time()) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidCredentials)
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return nil, fmt.Errorf("%w: token issuer %q not trusted", ErrInvalidCredentials, c.Issuer)
+	}
+	if v.audience != "" && !containsString(c.Audience, v.audience) {
+		return nil, fmt.Errorf("%w: token not issued for this audience", ErrInvalidCredentials)
+	}
+	return &Principal{Name: c.Subject, Method: MethodJWT}, nil
+}
+
+// verifySignature reports whether sig is the signature of digest under key
+// with the algorithm alg
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS signatures are r and s as fixed size big-endian integers
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT into v
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
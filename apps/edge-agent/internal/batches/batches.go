@@ -0,0 +1,383 @@
+package batches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/connector"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrClosed is returned for messages added once the manager is closed
+var ErrClosed = errors.New("batches are closed")
+
+const (
+	// checkInterval is how often open batches are checked for their gap
+	checkInterval = time.Second
+	// maxRetryDelay caps the delay between attempts to write a batch
+	maxRetryDelay = 5 * time.Minute
+)
+
+// Writer writes batches through connectors
+type Writer interface {
+	Write(ctx context.Context, connectorID string, req connector.Request) error
+}
+
+// Manager implements engine.Batches. Each batching step has one open batch,
+// a file in dir that every message is appended and synced to before Add
+// returns. Full batches are sealed and written to their connector in the
+// background, retried with backoff until the connector accepts them, and
+// removed once written. Batches still staged when the agent stops are
+// written after it starts again.
+type Manager struct {
+	dir    string
+	retry  time.Duration
+	writer Writer
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	open   map[string]*openBatch
+	sealed []*sealedBatch
+	closed bool
+
+	// writing serialises the writing of sealed batches
+	writing sync.Mutex
+	wake    chan struct{}
+}
+
+// staged describes a staged batch, kept in a metadata file next to its data
+type staged struct {
+	ID string `json:"id"`
+	engine.Batch
+	OpenedAt time.Time `json:"openedAt"`
+	SealedAt time.Time `json:"sealedAt"`
+}
+
+// openBatch is a batch messages are still added to
+type openBatch struct {
+	staged
+	file  *os.File
+	count int
+	bytes int64
+	last  time.Time
+}
+
+// sealedBatch is a full batch waiting to be written
+type sealedBatch struct {
+	staged
+	attempts int
+	next     time.Time
+}
+
+// NewManager stages batches in dir, retrying failed writes after retry, and
+// picks up the batches staged by a previous run
+func NewManager(dir string, retry time.Duration, w Writer, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create batch directory: %w", err)
+	}
+	m := &Manager{
+		dir:    dir,
+		retry:  retry,
+		writer: w,
+		logger: logger,
+		open:   make(map[string]*openBatch),
+		wake:   make(chan struct{}, 1),
+	}
+	if err := m.recover(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// recover seals the batches staged by a previous run, open or not
+func (m *Manager) recover() error {
+	metas, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list staged batches: %w", err)
+	}
+	now := time.Now().UTC()
+	for _, name := range metas {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read staged batch: %w", err)
+		}
+		var st staged
+		if err := json.Unmarshal(data, &st); err != nil {
+			m.logger.Errorf("Skipping unreadable staged batch %s: %v", name, err)
+			continue
+		}
+		if st.SealedAt.IsZero() {
+			st.SealedAt = now
+			if err := m.writeMeta(st); err != nil {
+				return err
+			}
+		}
+		m.sealed = append(m.sealed, &sealedBatch{staged: st})
+	}
+	if len(m.sealed) > 0 {
+		m.logger.Infof("Recovered %d staged batches", len(m.sealed))
+	}
+	return nil
+}
+
+// Add appends body and the batch separator to the step's open batch,
+// starting one when needed. The batch is sealed once full; a message that
+// would take it over MaxBytes, or new settings of the step after its flow
+// changed, seal it first.
+func (m *Manager) Add(ctx context.Context, b engine.Batch, body []byte) error {
+	record := append(body[:len(body):len(body)], b.Separator...)
+	key := b.FlowID + "/" + b.StepID
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	ob := m.open[key]
+	if ob != nil && (ob.Batch != b || b.MaxBytes > 0 && ob.bytes+int64(len(record)) > b.MaxBytes) {
+		m.seal(key, ob, now)
+		ob = nil
+	}
+	if ob == nil {
+		var err error
+		if ob, err = m.start(b, now); err != nil {
+			return err
+		}
+		m.open[key] = ob
+	}
+
+	_, err := ob.file.Write(record)
+	if err == nil {
+		err = ob.file.Sync()
+	}
+	if err != nil {
+		// Drop what was written of the message so the batch stays whole
+		ob.file.Truncate(ob.bytes)
+		return fmt.Errorf("failed to stage batch message: %w", err)
+	}
+	ob.count++
+	ob.bytes += int64(len(record))
+	ob.last = now
+	if b.MaxCount > 0 && ob.count >= b.MaxCount || b.MaxBytes > 0 && ob.bytes >= b.MaxBytes {
+		m.seal(key, ob, now)
+	}
+	return nil
+}
+
+// start stages a new batch, recording its settings before its data
+func (m *Manager) start(b engine.Batch, now time.Time) (*openBatch, error) {
+	st := staged{ID: ids.New("batch"), Batch: b, OpenedAt: now}
+	if err := m.writeMeta(st); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(m.dataPath(st.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		os.Remove(m.metaPath(st.ID))
+		return nil, fmt.Errorf("failed to create batch file: %w", err)
+	}
+	return &openBatch{staged: st, file: f, last: now}, nil
+}
+
+// seal closes an open batch and queues it to be written. Its messages are
+// already staged, so failing to record the seal only delays it until the
+// next start.
+func (m *Manager) seal(key string, ob *openBatch, now time.Time) {
+	delete(m.open, key)
+	if err := ob.file.Close(); err != nil {
+		m.logger.Errorf("Failed to close batch %s: %v", ob.ID, err)
+	}
+	ob.SealedAt = now
+	if err := m.writeMeta(ob.staged); err != nil {
+		m.logger.Errorf("Failed to seal batch %s: %v", ob.ID, err)
+	}
+	m.sealed = append(m.sealed, &sealedBatch{staged: ob.staged})
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run seals batches whose gap has passed and writes sealed batches until
+// ctx is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.sealIdle(now.UTC())
+		case <-m.wake:
+		}
+		m.flush(ctx, false)
+	}
+}
+
+// sealIdle seals the batches no message was added to for their gap
+func (m *Manager) sealIdle(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, ob := range m.open {
+		if ob.Gap > 0 && now.Sub(ob.last) >= ob.Gap {
+			m.seal(key, ob, now)
+		}
+	}
+}
+
+// Close stops accepting messages, seals the open batches, however partial,
+// and tries to write every sealed batch before ctx is done. Batches that
+// could not be written stay staged for the next start.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	now := time.Now().UTC()
+	for key, ob := range m.open {
+		m.seal(key, ob, now)
+	}
+	m.mu.Unlock()
+
+	if n := m.flush(ctx, true); n > 0 {
+		return fmt.Errorf("%d batches could not be written and stay staged", n)
+	}
+	return nil
+}
+
+// flush writes the sealed batches due for an attempt, or all of them, and
+// returns how many are left
+func (m *Manager) flush(ctx context.Context, all bool) int {
+	m.writing.Lock()
+	defer m.writing.Unlock()
+
+	now := time.Now().UTC()
+	m.mu.Lock()
+	var due []*sealedBatch
+	for _, sb := range m.sealed {
+		if all || !now.Before(sb.next) {
+			due = append(due, sb)
+		}
+	}
+	m.mu.Unlock()
+
+	written := make(map[*sealedBatch]bool, len(due))
+	for _, sb := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := m.write(ctx, sb); err != nil {
+			sb.attempts++
+			delay := m.retry << min(sb.attempts-1, 10)
+			if delay <= 0 || delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			sb.next = time.Now().Add(delay)
+			m.logger.WithFields(logrus.Fields{
+				"batch_id":  sb.ID,
+				"flow_id":   sb.FlowID,
+				"step_id":   sb.StepID,
+				"connector": sb.Connector,
+				"attempts":  sb.attempts,
+			}).Warnf("Failed to write batch, retrying in %s: %v", delay, err)
+			continue
+		}
+		written[sb] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	left := m.sealed[:0]
+	for _, sb := range m.sealed {
+		if !written[sb] {
+			left = append(left, sb)
+		}
+	}
+	clear(m.sealed[len(left):])
+	m.sealed = left
+	return len(left)
+}
+
+// write writes a sealed batch to its connector and removes it from staging
+func (m *Manager) write(ctx context.Context, sb *sealedBatch) error {
+	data, err := os.ReadFile(m.dataPath(sb.ID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read batch: %w", err)
+	}
+	// A batch whose agent stopped before its first message has nothing to
+	// write
+	if len(data) > 0 {
+		req := connector.Request{
+			Operation:   sb.Operation,
+			Params:      map[string]interface{}{"path": sb.name()},
+			Body:        data,
+			ContentType: sb.ContentType,
+		}
+		if err := m.writer.Write(ctx, sb.Connector, req); err != nil {
+			return fmt.Errorf("failed to write batch to connector %s: %w", sb.Connector, err)
+		}
+	}
+	if err := os.Remove(m.dataPath(sb.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove batch: %w", err)
+	}
+	if err := os.Remove(m.metaPath(sb.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove batch: %w", err)
+	}
+	return nil
+}
+
+// name expands the {flowId}, {stepId}, {batchId} and {timestamp}
+// placeholders of the batch path, the timestamp being when it was sealed
+func (st staged) name() string {
+	return strings.NewReplacer(
+		"{flowId}", st.FlowID,
+		"{stepId}", st.StepID,
+		"{batchId}", st.ID,
+		"{timestamp}", st.SealedAt.Format("20060102T150405Z"),
+	).Replace(st.Path)
+}
+
+// writeMeta atomically replaces the metadata file of a batch
+func (m *Manager) writeMeta(st staged) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	path := m.metaPath(st.ID)
+	f, err := os.CreateTemp(m.dir, "."+st.ID+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to stage batch: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to stage batch: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) dataPath(id string) string {
+	return filepath.Join(m.dir, id+".data")
+}
+
+func (m *Manager) metaPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
@@ -0,0 +1,236 @@
+package clock
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers. Components that wait for
+// wall-clock time, such as schedule triggers and delay steps, use a Clock so
+// that tests can run them on virtual time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single-shot timer created by a Clock
+type Timer interface {
+	// C delivers the time once the timer fires
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, reporting whether it was pending
+	Stop() bool
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// ErrBackwards is returned when a virtual clock is asked to move back in time
+var ErrBackwards = errors.New("virtual clock cannot move backwards")
+
+// settleQuiet is how long Advance waits without timer activity before it
+// treats the agent as idle after firing timers, and settleMax bounds that
+// wait for busy agents
+const (
+	settleQuiet = 5 * time.Millisecond
+	settleMax   = time.Second
+)
+
+// Virtual is a clock that only moves when advanced. Advancing fires the
+// timers that come due in order, setting the time to each timer's deadline
+// as it fires and giving the woken components a moment to re-arm, so an
+// interval trigger advanced by a day fires once per interval.
+type Virtual struct {
+	// advanceMu serializes Advance calls
+	advanceMu sync.Mutex
+
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*virtualTimer
+	// activity is signalled when timers are created or stopped
+	activity chan struct{}
+}
+
+type virtualTimer struct {
+	v   *Virtual
+	at  time.Time
+	seq uint64
+	c   chan time.Time
+}
+
+// NewVirtual creates a virtual clock reading start
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start, activity: make(chan struct{}, 1)}
+}
+
+// Now implements Clock
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// NewTimer implements Clock. A timer for a non-positive duration fires on
+// the next Advance.
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	v.mu.Lock()
+	v.seq++
+	t := &virtualTimer{v: v, at: v.now.Add(d), seq: v.seq, c: make(chan time.Time, 1)}
+	v.timers = append(v.timers, t)
+	v.mu.Unlock()
+
+	v.signal()
+	return t
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTimer) Stop() bool {
+	v := t.v
+	v.mu.Lock()
+	stopped := v.remove(t)
+	v.mu.Unlock()
+
+	if stopped {
+		v.signal()
+	}
+	return stopped
+}
+
+// remove drops t from the pending timers. Callers must hold v.mu.
+func (v *Virtual) remove(t *virtualTimer) bool {
+	for i, p := range v.timers {
+		if p == t {
+			v.timers = append(v.timers[:i], v.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Virtual) signal() {
+	select {
+	case v.activity <- struct{}{}:
+	default:
+	}
+}
+
+// Advance moves the clock forward by d, firing the timers that come due,
+// and returns the number fired
+func (v *Virtual) Advance(d time.Duration) (int, error) {
+	if d < 0 {
+		return 0, ErrBackwards
+	}
+	v.advanceMu.Lock()
+	defer v.advanceMu.Unlock()
+	return v.advanceTo(v.Now().Add(d)), nil
+}
+
+// AdvanceTo moves the clock forward to t, firing the timers that come due,
+// and returns the number fired
+func (v *Virtual) AdvanceTo(t time.Time) (int, error) {
+	v.advanceMu.Lock()
+	defer v.advanceMu.Unlock()
+
+	if t.Before(v.Now()) {
+		return 0, ErrBackwards
+	}
+	return v.advanceTo(t), nil
+}
+
+func (v *Virtual) advanceTo(target time.Time) int {
+	fired := 0
+	for {
+		v.mu.Lock()
+		next := v.due(target)
+		if next == nil {
+			v.now = target
+			v.mu.Unlock()
+			return fired
+		}
+		v.remove(next)
+		if next.at.After(v.now) {
+			v.now = next.at
+		}
+		now := v.now
+		v.mu.Unlock()
+
+		next.c <- now
+		fired++
+		v.settle()
+	}
+}
+
+// due returns the earliest timer due by target, ties going to the timer
+// created first. Callers must hold v.mu.
+func (v *Virtual) due(target time.Time) *virtualTimer {
+	var next *virtualTimer
+	for _, t := range v.timers {
+		if t.at.After(target) {
+			continue
+		}
+		if next == nil || t.at.Before(next.at) || (t.at.Equal(next.at) && t.seq < next.seq) {
+			next = t
+		}
+	}
+	return next
+}
+
+// settle waits until timers have been left alone for settleQuiet, so that a
+// woken trigger can run its flow and re-arm before the clock moves on
+func (v *Virtual) settle() {
+	deadline := time.NewTimer(settleMax)
+	defer deadline.Stop()
+	for {
+		quiet := time.NewTimer(settleQuiet)
+		select {
+		case <-v.activity:
+			quiet.Stop()
+		case <-quiet.C:
+			return
+		case <-deadline.C:
+			quiet.Stop()
+			return
+		}
+	}
+}
+
+// Pending returns the deadlines of the timers waiting to fire, earliest
+// first
+func (v *Virtual) Pending() []time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	pending := make([]time.Time, len(v.timers))
+	for i, t := range v.timers {
+		pending[i] = t.at
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Before(pending[j]) })
+	return pending
+}
@@ -0,0 +1,472 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Buckets shared by the replicas of a cluster
+const (
+	BucketInstances = "cluster.instances"
+	BucketClaims    = "cluster.claims"
+	BucketCancels   = "cluster.cancels"
+)
+
+// Instance is one agent process of a cluster. Its lease is renewed on every
+// heartbeat; an instance whose lease expired is considered gone.
+type Instance struct {
+	ID             string    `json:"id"`
+	Hostname       string    `json:"hostname"`
+	StartedAt      time.Time `json:"startedAt"`
+	HeartbeatAt    time.Time `json:"heartbeatAt"`
+	LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+}
+
+// Alive reports whether the instance's lease is current at now
+func (i *Instance) Alive(now time.Time) bool {
+	return now.Before(i.LeaseExpiresAt)
+}
+
+// claim records the instance that owns a piece of singleton work
+type claim struct {
+	Owner     string    `json:"owner"`
+	ClaimedAt time.Time `json:"claimedAt"`
+}
+
+// Executions recovers the executions of instances that are gone, taking
+// over those it queues again for owner
+type Executions interface {
+	RecoverOrphans(ctx context.Context, owner string, alive func(owner string) bool) (failed, requeued int, err error)
+}
+
+// Canceller cancels executions running in this process
+type Canceller interface {
+	Cancel(id string, req model.ExecutionCancellation) error
+	Active() []*model.Execution
+}
+
+// Cluster is this process's membership of a cluster of agents sharing a
+// store. Executions are owned by the instance that started them: peers
+// queue again the executions an instance whose lease expires left queued
+// and fail those it left running, and relay cancellations to the owner
+// through the store. Leases compare wall clock times, so replicas need
+// synchronised clocks. The Cluster implements engine.Lease: once its lease
+// lapses, executions started under it are cancelled and no longer
+// recorded, so they cannot overwrite the records peers recovered.
+type Cluster struct {
+	store     store.Store
+	execs     Executions
+	canceller Canceller
+	logger    *logrus.Logger
+
+	id        string
+	hostname  string
+	startedAt time.Time
+	interval  time.Duration
+	ttl       time.Duration
+
+	mu sync.Mutex
+	// epoch counts the times the lease was taken, and expires is when it
+	// lapses unless renewed
+	epoch   uint64
+	expires time.Time
+}
+
+// New creates the membership of this process. Its instance ID is the
+// configured one, or the hostname, with a random suffix so that a restarted
+// process never inherits the executions of its previous incarnation.
+func New(st store.Store, cfg config.ClusterConfig, execs Executions, canceller Canceller, logger *logrus.Logger) *Cluster {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "edge-agent"
+	}
+	base := cfg.InstanceID
+	if base == "" {
+		base = hostname
+	}
+	return &Cluster{
+		store:     st,
+		execs:     execs,
+		canceller: canceller,
+		logger:    logger,
+		id:        ids.New(base),
+		hostname:  hostname,
+		startedAt: time.Now().UTC(),
+		interval:  time.Duration(cfg.HeartbeatInterval) * time.Second,
+		ttl:       time.Duration(cfg.LeaseTTL) * time.Second,
+	}
+}
+
+// ID returns the instance ID of this process
+func (c *Cluster) ID() string {
+	return c.id
+}
+
+// Join registers this instance with its first lease
+func (c *Cluster) Join(ctx context.Context) error {
+	if _, err := c.heartbeat(ctx); err != nil {
+		return fmt.Errorf("failed to join cluster: %w", err)
+	}
+	c.logger.WithField("instance", c.id).Info("Joined cluster")
+	return nil
+}
+
+// Leave removes this instance, once its executions have stopped
+func (c *Cluster) Leave(ctx context.Context) error {
+	err := c.store.Delete(ctx, BucketInstances, c.id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to leave cluster: %w", err)
+	}
+	return nil
+}
+
+// Run renews the lease, relays cancellations and recovers the executions of
+// instances that are gone, every heartbeat interval until ctx is cancelled
+func (c *Cluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lapsed, err := c.heartbeat(ctx)
+			if err != nil {
+				c.logger.Errorf("Failed to renew cluster lease: %v", err)
+				if _, held := c.Epoch(); !held {
+					c.stopWork()
+				}
+				continue
+			}
+			if lapsed {
+				c.logger.Warnf("Cluster lease of instance %s lapsed; cancelling the executions peers may have recovered as orphaned", c.id)
+				c.stopWork()
+			}
+			if err := c.relayCancels(ctx); err != nil {
+				c.logger.Errorf("Failed to process cancellation requests: %v", err)
+			}
+			if err := c.recover(ctx); err != nil {
+				c.logger.Errorf("Failed to recover orphaned executions: %v", err)
+			}
+		}
+	}
+}
+
+// Epoch implements engine.Lease
+func (c *Cluster) Epoch() (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch, time.Now().Before(c.expires)
+}
+
+// stopWork cancels the executions this instance runs under a lapsed lease
+func (c *Cluster) stopWork() {
+	req := model.ExecutionCancellation{Reason: "cluster lease lapsed", By: c.id}
+	for _, exec := range c.canceller.Active() {
+		if exec.Owner != c.id {
+			continue
+		}
+		if err := c.canceller.Cancel(exec.ID, req); err != nil && !errors.Is(err, engine.ErrNotActive) {
+			c.logger.Errorf("Failed to cancel execution %s: %v", exec.ID, err)
+		}
+	}
+}
+
+// heartbeat renews the lease, reporting whether it had lapsed. A lease
+// taken again after lapsing starts a new epoch.
+func (c *Cluster) heartbeat(ctx context.Context) (lapsed bool, err error) {
+	// The lease runs from before the renewal, so that this instance never
+	// counts on it for longer than its peers do
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			c.mu.Lock()
+			if lapsed || c.epoch == 0 {
+				c.epoch++
+			}
+			c.expires = start.Add(c.ttl)
+			c.mu.Unlock()
+		}
+	}()
+	err = c.store.Update(ctx, func(tx store.Tx) error {
+		now := time.Now().UTC()
+		inst := &Instance{ID: c.id, Hostname: c.hostname, StartedAt: c.startedAt}
+		rec, err := tx.Get(BucketInstances, c.id)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			// Joining, or removed by a peer after the lease expired
+			lapsed = now.After(c.startedAt.Add(c.ttl))
+		case err != nil:
+			return err
+		default:
+			var prev Instance
+			if err := json.Unmarshal(rec.Value, &prev); err != nil {
+				return fmt.Errorf("failed to decode instance %s: %w", c.id, err)
+			}
+			lapsed = !prev.Alive(now)
+		}
+		inst.HeartbeatAt = now
+		inst.LeaseExpiresAt = now.Add(c.ttl)
+		value, err := json.Marshal(inst)
+		if err != nil {
+			return fmt.Errorf("failed to encode instance: %w", err)
+		}
+		return tx.Put(BucketInstances, &store.Record{Key: c.id, Value: value})
+	})
+	return lapsed, err
+}
+
+// Instances returns the registered instances, oldest first. Instances
+// whose lease expired are listed until a peer removes them.
+func (c *Cluster) Instances(ctx context.Context) ([]*Instance, error) {
+	records, err := c.store.List(ctx, BucketInstances, store.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	list := make([]*Instance, 0, len(records))
+	for _, rec := range records {
+		var inst Instance
+		if err := json.Unmarshal(rec.Value, &inst); err != nil {
+			c.logger.Errorf("Failed to decode instance %s: %v", rec.Key, err)
+			continue
+		}
+		list = append(list, &inst)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list, nil
+}
+
+// alive returns whether each listed instance is alive at now
+func (c *Cluster) alive(ctx context.Context, now time.Time) (map[string]bool, error) {
+	list, err := c.Instances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alive := make(map[string]bool, len(list))
+	for _, inst := range list {
+		alive[inst.ID] = inst.Alive(now)
+	}
+	alive[c.id] = true
+	return alive, nil
+}
+
+// recover recovers the executions of instances that are gone, then removes
+// what those instances left behind
+func (c *Cluster) recover(ctx context.Context) error {
+	alive, err := c.alive(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	failed, requeued, err := c.execs.RecoverOrphans(ctx, c.id, func(owner string) bool {
+		if ok, listed := alive[owner]; listed {
+			return ok
+		}
+		// The owner may have joined since the instances were listed
+		ok, err := c.instanceAlive(func(bucket, key string) (*store.Record, error) {
+			return c.store.Get(ctx, bucket, key)
+		}, owner)
+		if err != nil {
+			c.logger.Errorf("Failed to check instance %s: %v", owner, err)
+			return true
+		}
+		return ok
+	})
+	if failed > 0 {
+		c.logger.WithField("count", failed).Warn("Failed executions orphaned by stopped instances")
+	}
+	if requeued > 0 {
+		c.logger.WithField("count", requeued).Info("Requeued executions orphaned by stopped instances")
+	}
+	if err != nil {
+		return err
+	}
+
+	for id, ok := range alive {
+		if ok {
+			continue
+		}
+		removed, err := c.remove(ctx, id)
+		if err != nil {
+			return err
+		}
+		if removed {
+			c.logger.WithField("instance", id).Info("Removed stopped cluster instance")
+		}
+	}
+	return nil
+}
+
+// remove deletes the stopped instance id with its claims and cancellation
+// requests, reporting whether it did. The instance is checked again within
+// the transaction, so that one whose lease was renewed since it was listed
+// keeps its record and claims.
+func (c *Cluster) remove(ctx context.Context, id string) (bool, error) {
+	owned := make(map[string][]string)
+	for _, bucket := range []string{BucketClaims, BucketCancels} {
+		records, err := c.store.List(ctx, bucket, store.ListOptions{Labels: map[string]string{"owner": id}})
+		if err != nil {
+			return false, fmt.Errorf("failed to list %s: %w", bucket, err)
+		}
+		for _, rec := range records {
+			owned[bucket] = append(owned[bucket], rec.Key)
+		}
+	}
+
+	removed := false
+	err := c.store.Update(ctx, func(tx store.Tx) error {
+		removed = false
+		alive, err := c.instanceAlive(tx.Get, id)
+		if err != nil || alive {
+			return err
+		}
+		for bucket, keys := range owned {
+			for _, key := range keys {
+				// A key claimed by a peer since it was listed is kept
+				rec, err := tx.Get(bucket, key)
+				if errors.Is(err, store.ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if rec.Labels["owner"] != id {
+					continue
+				}
+				if err := tx.Delete(bucket, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+					return fmt.Errorf("failed to delete %s/%s: %w", bucket, key, err)
+				}
+			}
+		}
+		if err := tx.Delete(BucketInstances, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		removed = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove instance %s: %w", id, err)
+	}
+	return removed, nil
+}
+
+// Claim takes key for this instance unless a live peer holds it, reporting
+// whether this instance holds it. Claims are sticky: the holder keeps a key
+// until it stops. Replicas racing for a free key may all fail with a
+// transaction conflict, in which case none holds it until the next attempt.
+func (c *Cluster) Claim(ctx context.Context, key string) (bool, error) {
+	held := false
+	err := c.store.Update(ctx, func(tx store.Tx) error {
+		held = false
+		rec, err := tx.Get(BucketClaims, key)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return err
+		default:
+			var cur claim
+			if err := json.Unmarshal(rec.Value, &cur); err != nil {
+				return fmt.Errorf("failed to decode claim %s: %w", key, err)
+			}
+			if cur.Owner == c.id {
+				held = true
+				return nil
+			}
+			alive, err := c.instanceAlive(tx.Get, cur.Owner)
+			if err != nil || alive {
+				return err
+			}
+		}
+		value, err := json.Marshal(claim{Owner: c.id, ClaimedAt: time.Now().UTC()})
+		if err != nil {
+			return fmt.Errorf("failed to encode claim: %w", err)
+		}
+		held = true
+		return tx.Put(BucketClaims, &store.Record{Key: key, Value: value, Labels: map[string]string{"owner": c.id}})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to claim %s: %w", key, err)
+	}
+	return held, nil
+}
+
+// instanceAlive reads whether an instance is alive through get, which reads
+// from the store or a transaction
+func (c *Cluster) instanceAlive(get func(bucket, key string) (*store.Record, error), id string) (bool, error) {
+	rec, err := get(BucketInstances, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var inst Instance
+	if err := json.Unmarshal(rec.Value, &inst); err != nil {
+		return false, fmt.Errorf("failed to decode instance %s: %w", id, err)
+	}
+	return inst.Alive(time.Now().UTC()), nil
+}
+
+// cancelRequest is a stored request for a peer to cancel an execution
+type cancelRequest struct {
+	ExecutionID string `json:"executionId"`
+	// RequestedBy is the instance that took the request
+	RequestedBy  string                      `json:"requestedBy"`
+	Cancellation model.ExecutionCancellation `json:"cancellation"`
+}
+
+// RequestCancel asks the instance owning exec to cancel it with req. The
+// owner acts on the request by its next heartbeat.
+func (c *Cluster) RequestCancel(ctx context.Context, exec *model.Execution, req model.ExecutionCancellation) error {
+	if req.RequestedAt.IsZero() {
+		req.RequestedAt = time.Now().UTC()
+	}
+	value, err := json.Marshal(cancelRequest{ExecutionID: exec.ID, RequestedBy: c.id, Cancellation: req})
+	if err != nil {
+		return fmt.Errorf("failed to encode cancellation request: %w", err)
+	}
+	rec := &store.Record{Key: exec.ID, Value: value, Labels: map[string]string{"owner": exec.Owner}}
+	if err := c.store.Put(ctx, BucketCancels, rec); err != nil {
+		return fmt.Errorf("failed to request cancellation of %s: %w", exec.ID, err)
+	}
+	return nil
+}
+
+// relayCancels cancels the executions peers asked this instance to cancel
+func (c *Cluster) relayCancels(ctx context.Context) error {
+	records, err := c.store.List(ctx, BucketCancels, store.ListOptions{Labels: map[string]string{"owner": c.id}})
+	if err != nil {
+		return fmt.Errorf("failed to list cancellation requests: %w", err)
+	}
+	for _, rec := range records {
+		// Requests that cannot be decoded still cancel, without a reason
+		var req cancelRequest
+		if err := json.Unmarshal(rec.Value, &req); err != nil {
+			c.logger.Warnf("Failed to decode cancellation request %s: %v", rec.Key, err)
+		}
+		err := c.canceller.Cancel(rec.Key, req.Cancellation)
+		switch {
+		case err == nil:
+			c.logger.Infof("Cancelling execution %s at the request of a peer", rec.Key)
+		case !errors.Is(err, engine.ErrNotActive):
+			c.logger.Errorf("Failed to cancel execution %s: %v", rec.Key, err)
+		}
+		if err := c.store.Delete(ctx, BucketCancels, rec.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("failed to delete cancellation request %s: %w", rec.Key, err)
+		}
+	}
+	return nil
+}
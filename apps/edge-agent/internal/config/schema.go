@@ -0,0 +1,236 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaError is a value of the configuration file that does not fit the
+// configuration, at Line of the file and Path in its keys, such as
+// "server.listeners[0].port"
+type SchemaError struct {
+	Line    int
+	Path    string
+	Problem string
+}
+
+func (e SchemaError) String() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Problem)
+}
+
+// SchemaErrors are every problem found in a configuration file
+type SchemaErrors struct {
+	File   string
+	Errors []SchemaError
+}
+
+func (e *SchemaErrors) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = "  " + e.File + ": " + err.String()
+	}
+	return fmt.Sprintf("%d problem(s) in config file:\n%s", len(e.Errors), strings.Join(lines, "\n"))
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// checkSchema checks the keys and values of a configuration file against
+// the mapstructure tags of Config, which viper alone does not: it ignores
+// unknown keys, so a typo silently leaves a setting at its default.
+func checkSchema(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	s := &SchemaErrors{File: file}
+	s.check(doc.Content[0], reflect.TypeOf(Config{}), "")
+	if len(s.Errors) > 0 {
+		return s
+	}
+	return nil
+}
+
+func (s *SchemaErrors) add(node *yaml.Node, path, format string, args ...interface{}) {
+	s.Errors = append(s.Errors, SchemaError{Line: node.Line, Path: path, Problem: fmt.Sprintf(format, args...)})
+}
+
+// check checks node as a value of type t at path
+func (s *SchemaErrors) check(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	if path == "" {
+		path = "(root)"
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshaler) {
+		s.scalar(node, path, "a string")
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			s.add(node, path, "expected a mapping, got %s", describe(node))
+			return
+		}
+		fields := structKeys(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				s.check(value, t, path)
+				continue
+			}
+			name := strings.ToLower(key.Value)
+			field, ok := fields[name]
+			if !ok {
+				problem := "unknown key"
+				if near := nearest(name, fields); near != "" {
+					problem += fmt.Sprintf(" (did you mean %q?)", near)
+				}
+				s.add(key, join(path, key.Value), "%s", problem)
+				continue
+			}
+			s.check(value, field, join(path, key.Value))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			s.add(node, path, "expected a mapping, got %s", describe(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			s.check(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value))
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.ScalarNode && t.Elem().Kind() == reflect.String {
+			// Comma-separated lists are accepted, as from environment
+			// variables
+			return
+		}
+		if node.Kind != yaml.SequenceNode {
+			s.add(node, path, "expected a list, got %s", describe(node))
+			return
+		}
+		for i, item := range node.Content {
+			s.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Bool:
+		if s.scalar(node, path, "true or false") && node.Tag != "!!bool" {
+			s.add(node, path, "expected true or false, got %q", node.Value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s.scalar(node, path, "an integer") && node.Tag != "!!int" {
+			s.add(node, path, "expected an integer, got %q", node.Value)
+		}
+	case reflect.Float32, reflect.Float64:
+		if s.scalar(node, path, "a number") && node.Tag != "!!int" && node.Tag != "!!float" {
+			s.add(node, path, "expected a number, got %q", node.Value)
+		}
+	case reflect.String:
+		s.scalar(node, path, "a string")
+	}
+}
+
+// scalar reports whether node is a scalar, adding a problem when not
+func (s *SchemaErrors) scalar(node *yaml.Node, path, want string) bool {
+	if node.Kind != yaml.ScalarNode {
+		s.add(node, path, "expected %s, got %s", want, describe(node))
+		return false
+	}
+	return true
+}
+
+// structKeys returns the types of the fields of t by key, including those
+// of squashed embedded structs
+func structKeys(t reflect.Type) map[string]reflect.Type {
+	keys := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		if opts == "squash" {
+			for k, v := range structKeys(f.Type) {
+				keys[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		keys[strings.ToLower(name)] = f.Type
+	}
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "(root)" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
+
+// nearest returns the key within two edits of name, if any
+func nearest(name string, keys map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for key := range keys {
+		if d := distance(name, key); d < bestDist || (d == bestDist && key < best) {
+			best, bestDist = key, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,160 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+var (
+	// ErrUnsupported is returned for connector types without an
+	// implementation
+	ErrUnsupported = errors.New("unsupported connector type")
+
+	// ErrOperationNotFound is returned for requests naming an operation the
+	// connector does not define
+	ErrOperationNotFound = errors.New("operation not found")
+)
+
+// Record is one item read from an external system
+type Record = map[string]interface{}
+
+// Request is a call to an external system, naming one of the connector's
+// operations when it defines them
+type Request struct {
+	Operation   string
+	Params      map[string]interface{}
+	Body        []byte
+	ContentType string
+}
+
+// Connector is a live connection to an external system. Implementations
+// are safe for concurrent use once connected; Close lets calls in progress
+// finish.
+type Connector interface {
+	// Connect establishes the connection, failing when the system cannot be
+	// reached or the configuration is incomplete
+	Connect(ctx context.Context) error
+	// TestConnection checks that the system is reachable and accepts the
+	// connector's credentials
+	TestConnection(ctx context.Context) error
+	// Read fetches records; nothing matching is an empty result, not an
+	// error
+	Read(ctx context.Context, req Request) ([]Record, error)
+	// Write sends the request body
+	Write(ctx context.Context, req Request) error
+	Close() error
+}
+
+// CommitWriter is implemented by connectors that can record the
+// idempotency key of a write in the same transaction, for the sinks of
+// exactly-once flows
+type CommitWriter interface {
+	// WriteCommitted writes the request body and records key with it;
+	// nothing is written when key is already recorded. An empty key writes
+	// as Write does.
+	WriteCommitted(ctx context.Context, req Request, key string) error
+	// Committed reports whether key was recorded by WriteCommitted
+	Committed(ctx context.Context, key string) (bool, error)
+}
+
+// Factory builds a connector from its definition without connecting,
+// returning an error when the configuration is malformed
+type Factory func(def *model.Connector) (Connector, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a connector type available. Plugins register their types
+// from an init function and are compiled in with a blank import. It panics
+// if the type is registered twice.
+func Register(connectorType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[connectorType]; dup {
+		panic("connector: connector type registered twice: " + connectorType)
+	}
+	registry[connectorType] = factory
+}
+
+// Types returns the registered connector types, sorted
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New builds a connector of a registered type
+func New(def *model.Connector) (Connector, error) {
+	registryMu.RLock()
+	factory, ok := registry[def.Type]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, def.Type)
+	}
+	return factory(def)
+}
+
+// Resolve returns a copy of def with the ${secret:...} references of its
+// config resolved, for connecting with. Definitions keep their references,
+// so that credentials are never stored or returned by the API.
+func Resolve(def *model.Connector) (*model.Connector, error) {
+	if def.Config == nil {
+		return def, nil
+	}
+	config, err := secrets.ExpandMap(def.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve connector secrets: %w", err)
+	}
+	resolved := *def
+	resolved.Config = config
+	return &resolved, nil
+}
+
+// Validate checks the configuration of a connector of a registered type.
+// Definitions of other types, such as imported messaging APIs, are stored
+// as descriptions and not checked.
+func Validate(def *model.Connector) error {
+	c, err := New(def)
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return &model.ValidationError{Problems: []string{err.Error()}}
+	}
+	_ = c.Close()
+	return nil
+}
+
+// Test connects with a definition, tests the connection and closes it
+func Test(ctx context.Context, def *model.Connector) error {
+	resolved, err := Resolve(def)
+	if err != nil {
+		return err
+	}
+	c, err := New(resolved)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	return c.TestConnection(ctx)
+}
@@ -0,0 +1,169 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("file", newFile)
+}
+
+// maxFileBytes bounds the files a file connector reads into records
+const maxFileBytes = 64 << 20
+
+// fileConfig configures a file connector rooted at a directory
+type fileConfig struct {
+	Path string `json:"path"`
+}
+
+// fileConnector reads and writes files under its root directory. Requests
+// name a file relative to the root with the "path" parameter, or with the
+// path of the requested operation.
+type fileConnector struct {
+	def  *model.Connector
+	root string
+}
+
+func newFile(def *model.Connector) (Connector, error) {
+	var cfg fileConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	c := &fileConnector{def: def}
+	if cfg.Path != "" {
+		c.root = filepath.Clean(cfg.Path)
+	}
+	return c, nil
+}
+
+// Connect checks that the root is a directory. Connectors without one may
+// still be named by file steps, e.g. to share bandwidth caps.
+func (c *fileConnector) Connect(ctx context.Context) error {
+	if c.root == "" {
+		return errors.New("config.path is required")
+	}
+	info, err := os.Stat(c.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", c.root)
+	}
+	return nil
+}
+
+// TestConnection checks that files can be created in the root
+func (c *fileConnector) TestConnection(ctx context.Context) error {
+	f, err := os.CreateTemp(c.root, ".connection-test-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// Read decodes a JSON file holding an array or object, or a file of JSON
+// lines. A missing file is an empty result.
+func (c *fileConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	path, err := c.resolve(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxFileBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, maxFileBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFileRecords(path, data)
+}
+
+// decodeFileRecords decodes a JSON array or object, or JSON lines
+func decodeFileRecords(name string, data []byte) ([]Record, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == '[' {
+		return decodeRecords(trimmed)
+	}
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 64<<10), maxFileBytes)
+	for scanner.Scan() {
+		line, err := decodeRecords(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		records = append(records, line...)
+	}
+	return records, scanner.Err()
+}
+
+// Write replaces the file with the request body, atomically
+func (c *fileConnector) Write(ctx context.Context, r Request) error {
+	path, err := c.resolve(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = f.Write(r.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *fileConnector) Close() error {
+	return nil
+}
+
+// resolve returns the file a request names, which must lie under the root
+func (c *fileConnector) resolve(r Request) (string, error) {
+	if c.root == "" {
+		return "", errors.New("config.path is required")
+	}
+	name, _ := r.Params["path"].(string)
+	if name == "" && r.Operation != "" {
+		op, ok := c.def.Operation(r.Operation)
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+		name = op.Path
+	}
+	if name == "" {
+		return "", errors.New("no file given: set the path parameter")
+	}
+	// Cleaning the name as an absolute path drops any leading "..", so it
+	// cannot climb out of the root
+	return filepath.Join(c.root, filepath.Clean("/"+name)), nil
+}
@@ -0,0 +1,372 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("http", newHTTP)
+}
+
+// maxResponseBytes bounds the response bodies an HTTP connector reads
+const maxResponseBytes = 32 << 20
+
+// httpConfig configures an HTTP connector. Credentials for the connector's
+// auth scheme are read from username/password (basic), token (bearer),
+// apiKey (apiKey) or clientId/clientSecret (oauth2 client credentials).
+// TestPath is requested by connection tests and defaults to the base URL.
+type httpConfig struct {
+	BaseURL      string            `json:"baseUrl"`
+	Timeout      string            `json:"timeout"`
+	Headers      map[string]string `json:"headers"`
+	TestPath     string            `json:"testPath"`
+	Username     string            `json:"username"`
+	Password     string            `json:"password"`
+	Token        string            `json:"token"`
+	APIKey       string            `json:"apiKey"`
+	ClientID     string            `json:"clientId"`
+	ClientSecret string            `json:"clientSecret"`
+}
+
+// httpConnector calls the operations of a request/response API
+type httpConnector struct {
+	def    *model.Connector
+	cfg    httpConfig
+	base   *url.URL
+	client *http.Client
+
+	// The cached oauth2 access token
+	tokenMu sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newHTTP(def *model.Connector) (Connector, error) {
+	var cfg httpConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid config: timeout %q is not a positive duration", cfg.Timeout)
+		}
+		timeout = d
+	}
+	c := &httpConnector{def: def, cfg: cfg, client: &http.Client{Timeout: timeout}}
+	if cfg.BaseURL != "" {
+		base, err := url.Parse(cfg.BaseURL)
+		if err != nil || base.Scheme == "" || base.Host == "" {
+			return nil, fmt.Errorf("invalid config: baseUrl %q is not an absolute URL", cfg.BaseURL)
+		}
+		c.base = base
+	}
+	if def.Auth != nil {
+		switch def.Auth.Type {
+		case "basic", "bearer", "oauth2":
+		case "apiKey":
+			if def.Auth.Name == "" {
+				return nil, errors.New("invalid auth: apiKey requires a name")
+			}
+			if in := def.Auth.In; in != "" && in != "header" && in != "query" {
+				return nil, fmt.Errorf("invalid auth: apiKey in %q is not supported", in)
+			}
+		default:
+			return nil, fmt.Errorf("invalid auth: type %q is not supported", def.Auth.Type)
+		}
+	}
+	return c, nil
+}
+
+// Connect checks that the connector can be used; HTTP connections are
+// opened per request
+func (c *httpConnector) Connect(ctx context.Context) error {
+	if c.base == nil {
+		return errors.New("config.baseUrl is required")
+	}
+	if c.def.Auth != nil && c.def.Auth.Type == "oauth2" {
+		_, err := c.accessToken(ctx)
+		return err
+	}
+	return nil
+}
+
+// TestConnection requests the test path, treating server errors and
+// rejected credentials as failures
+func (c *httpConnector) TestConnection(ctx context.Context) error {
+	u := *c.base
+	if c.cfg.TestPath != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(c.cfg.TestPath, "/")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("credentials rejected: %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("server error: %s", resp.Status)
+	}
+	return nil
+}
+
+// Read calls an operation and decodes its JSON response: an array yields
+// one record per element and an object a single record. A 404 is an empty
+// result.
+func (c *httpConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	resp, err := c.call(ctx, r, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return decodeRecords(data)
+}
+
+// Write calls an operation with the request body
+func (c *httpConnector) Write(ctx context.Context, r Request) error {
+	resp, err := c.call(ctx, r, http.MethodPost)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	return checkStatus(resp)
+}
+
+func (c *httpConnector) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// call sends a request for an operation. Parameters fill the path
+// placeholders, go in headers when the operation declares them there, and
+// are otherwise sent in the query string.
+func (c *httpConnector) call(ctx context.Context, r Request, defaultMethod string) (*http.Response, error) {
+	if c.base == nil {
+		return nil, errors.New("config.baseUrl is required")
+	}
+	op, ok := c.def.Operation(r.Operation)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+	}
+
+	headerParams := make(map[string]bool)
+	for _, p := range op.Parameters {
+		if p.In == "header" {
+			headerParams[p.Name] = true
+		}
+	}
+	path := op.Path
+	query := url.Values{}
+	header := http.Header{}
+	for name, value := range r.Params {
+		s := fmt.Sprint(value)
+		placeholder := "{" + name + "}"
+		switch {
+		case strings.Contains(path, placeholder):
+			path = strings.ReplaceAll(path, placeholder, url.PathEscape(s))
+		case headerParams[name]:
+			header.Set(name, s)
+		default:
+			query.Set(name, s)
+		}
+	}
+	if strings.Contains(path, "{") {
+		return nil, fmt.Errorf("operation %s: path %s has unfilled parameters", op.ID, path)
+	}
+
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	method := strings.ToUpper(op.Method)
+	if method == "" {
+		method = defaultMethod
+	}
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if r.Body != nil {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = op.ContentType
+		}
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.send(ctx, req)
+}
+
+// send adds the configured headers and credentials and sends req
+func (c *httpConnector) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for k, v := range c.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if auth := c.def.Auth; auth != nil {
+		switch auth.Type {
+		case "basic":
+			req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+		case "apiKey":
+			if auth.In == "query" {
+				q := req.URL.Query()
+				q.Set(auth.Name, c.cfg.APIKey)
+				req.URL.RawQuery = q.Encode()
+			} else {
+				req.Header.Set(auth.Name, c.cfg.APIKey)
+			}
+		case "oauth2":
+			token, err := c.accessToken(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// accessToken returns an oauth2 token from the client credentials grant,
+// fetching a new one shortly before the cached one expires
+func (c *httpConnector) accessToken(ctx context.Context) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	if c.def.Auth.TokenURL == "" {
+		return "", errors.New("auth.tokenUrl is required for oauth2")
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.def.Auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.def.Auth.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.def.Auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %w", err)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token endpoint returned no access token")
+	}
+	lifetime := time.Hour
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+	c.token = token.AccessToken
+	// Refresh early so that a token does not expire in flight
+	c.expires = time.Now().Add(lifetime - min(lifetime/10, time.Minute))
+	return c.token, nil
+}
+
+// checkStatus turns an error response into an error, quoting its start
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if detail := strings.TrimSpace(string(data)); detail != "" {
+		return fmt.Errorf("%s: %s", resp.Status, detail)
+	}
+	return errors.New(resp.Status)
+}
+
+// decodeRecords decodes a JSON array or object into records. Array
+// elements that are not objects are wrapped as {"value": element}.
+func decodeRecords(data []byte) ([]Record, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		records := make([]Record, 0, len(v))
+		for _, item := range v {
+			rec, ok := item.(map[string]interface{})
+			if !ok {
+				rec = Record{"value": item}
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	case map[string]interface{}:
+		return []Record{v}, nil
+	default:
+		return []Record{{"value": v}}, nil
+	}
+}
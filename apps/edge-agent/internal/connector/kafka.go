@@ -0,0 +1,100 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/kafka"
+	"github.com/fusionflow/edge-agent/internal/model"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+func init() {
+	Register("kafka", newKafka)
+}
+
+// kafkaConnectorConfig configures a Kafka connector
+type kafkaConnectorConfig struct {
+	kafka.Config
+	Acks        string `json:"acks"`
+	Compression string `json:"compression"`
+}
+
+// kafkaConnector produces to the topics of a Kafka cluster. Writes send the
+// request body to the "topic" parameter or the channel of the requested
+// operation, keyed by the "key" parameter. Topics are consumed with the
+// kafka trigger rather than read on demand.
+type kafkaConnector struct {
+	def      *model.Connector
+	cfg      kafkaConnectorConfig
+	producer *kafkago.Writer
+}
+
+func newKafka(def *model.Connector) (Connector, error) {
+	var cfg kafkaConnectorConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &kafkaConnector{def: def, cfg: cfg}, nil
+}
+
+func (c *kafkaConnector) Connect(ctx context.Context) error {
+	if err := kafka.Ping(ctx, c.cfg.Config); err != nil {
+		return err
+	}
+	producer, err := kafka.Producer(c.cfg.Config, kafka.ProducerOptions{Acks: c.cfg.Acks, Compression: c.cfg.Compression})
+	if err != nil {
+		return err
+	}
+	c.producer = producer
+	return nil
+}
+
+// TestConnection checks the brokers and that the topics of the connector's
+// operations exist
+func (c *kafkaConnector) TestConnection(ctx context.Context) error {
+	var topics []string
+	for _, op := range c.def.Operations {
+		if op.Channel != "" {
+			topics = append(topics, op.Channel)
+		}
+	}
+	return kafka.Ping(ctx, c.cfg.Config, topics...)
+}
+
+func (c *kafkaConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	return nil, errors.New("kafka topics cannot be read on demand: consume them with a kafka trigger")
+}
+
+func (c *kafkaConnector) Write(ctx context.Context, r Request) error {
+	topic, _ := r.Params["topic"].(string)
+	if topic == "" && r.Operation != "" {
+		op, ok := c.def.Operation(r.Operation)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+		topic = op.Channel
+	}
+	if topic == "" {
+		return errors.New("no topic given: set the topic parameter")
+	}
+	msg := kafkago.Message{Topic: topic, Value: r.Body}
+	if key, _ := r.Params["key"].(string); key != "" {
+		msg.Key = []byte(key)
+	}
+	if err := c.producer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to produce to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Close leaves the producer open, since it is shared with the steps and
+// connectors using the same cluster; they are closed at shutdown
+func (c *kafkaConnector) Close() error {
+	return nil
+}
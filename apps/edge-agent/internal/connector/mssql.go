@@ -0,0 +1,146 @@
+package connector
+
+import (
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("mssql", newMSSQL)
+}
+
+// mssqlDriver is the database/sql driver name registered by go-mssqldb
+const mssqlDriver = "sqlserver"
+
+// mssqlConfig configures a SQL Server connector. Encrypt is the driver's
+// encrypt setting: true, false, strict or disable; TrustServerCertificate
+// skips the verification of the server's certificate.
+type mssqlConfig struct {
+	sqlConfig
+	Instance               string `json:"instance"`
+	Encrypt                string `json:"encrypt"`
+	TrustServerCertificate bool   `json:"trustServerCertificate"`
+}
+
+// newMSSQL creates a connector for a SQL Server database. Queries take @p1,
+// @p2, ... placeholders, and writes update conflicting rows with MERGE.
+func newMSSQL(def *model.Connector) (Connector, error) {
+	var cfg mssqlConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	return newSQLConnector(def, cfg.sqlConfig, mssqlDialect{}, func(connectTimeout time.Duration) string {
+		u := url.URL{Scheme: "sqlserver", Host: cfg.Host}
+		if cfg.Port != 0 {
+			u.Host = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+		}
+		if cfg.Instance != "" {
+			u.Path = "/" + cfg.Instance
+		}
+		if cfg.Username != "" {
+			u.User = url.UserPassword(cfg.Username, cfg.Password)
+		}
+		q := url.Values{}
+		if cfg.Database != "" {
+			q.Set("database", cfg.Database)
+		}
+		if cfg.Encrypt != "" {
+			q.Set("encrypt", cfg.Encrypt)
+		}
+		if cfg.TrustServerCertificate {
+			q.Set("TrustServerCertificate", "true")
+		}
+		if connectTimeout > 0 {
+			q.Set("dial timeout", strconv.Itoa(int(connectTimeout.Seconds()+0.5)))
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	})
+}
+
+type mssqlDialect struct{}
+
+func (mssqlDialect) driverName() string {
+	return mssqlDriver
+}
+
+func (mssqlDialect) quoteIdent(name string) string {
+	return quoteWith(name, "[", "]")
+}
+
+func (mssqlDialect) placeholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+func (mssqlDialect) selectQuery(table, where string, limit int) string {
+	query := "SELECT TOP (" + strconv.Itoa(limit) + ") * FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query
+}
+
+func (d mssqlDialect) insertQuery(table string, cols, conflict []string) string {
+	quoted := make([]string, len(cols))
+	placeholders := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = d.quoteIdent(col)
+		placeholders[i] = d.placeholder(i + 1)
+	}
+	if len(conflict) == 0 {
+		return "INSERT INTO " + table + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	isKey := make(map[string]bool, len(conflict))
+	on := make([]string, len(conflict))
+	for i, col := range conflict {
+		isKey[col] = true
+		key := d.quoteIdent(col)
+		on[i] = "target." + key + " = source." + key
+	}
+	var updates []string
+	sourced := make([]string, len(cols))
+	for i, col := range cols {
+		sourced[i] = "source." + quoted[i]
+		if !isKey[col] {
+			updates = append(updates, quoted[i]+" = source."+quoted[i])
+		}
+	}
+	// HOLDLOCK keeps concurrent merges of the same key from both inserting
+	query := "MERGE INTO " + table + " WITH (HOLDLOCK) AS target" +
+		" USING (VALUES (" + strings.Join(placeholders, ", ") + ")) AS source (" + strings.Join(quoted, ", ") + ")" +
+		" ON " + strings.Join(on, " AND ")
+	if len(updates) > 0 {
+		query += " WHEN MATCHED THEN UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return query + " WHEN NOT MATCHED THEN INSERT (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(sourced, ", ") + ");"
+}
+
+// columnValue also formats unique identifiers, which the driver scans as
+// bytes in SQL Server's mixed-endian order
+func (mssqlDialect) columnValue(dbType string, v interface{}) interface{} {
+	if data, ok := v.([]byte); ok && dbType == "UNIQUEIDENTIFIER" && len(data) == 16 {
+		b := make([]byte, 16)
+		copy(b, data)
+		b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+		b[4], b[5] = b[5], b[4]
+		b[6], b[7] = b[7], b[6]
+		s := hex.EncodeToString(b)
+		return strings.ToUpper(s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:])
+	}
+	binary := dbType == "BINARY" || dbType == "VARBINARY" || dbType == "IMAGE"
+	return textValue(v, false, binary)
+}
+
+// createCommitsQuery checks for the table first, as SQL Server has no
+// CREATE TABLE IF NOT EXISTS
+func (mssqlDialect) createCommitsQuery() string {
+	return "IF OBJECT_ID(N'" + commitsTable + "', N'U') IS NULL CREATE TABLE " + commitsTable + " (commit_key CHAR(64) PRIMARY KEY, committed_at DATETIME2 NOT NULL)"
+}
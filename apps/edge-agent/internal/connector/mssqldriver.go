@@ -0,0 +1,4 @@
+package connector
+
+// Register the database/sql driver used by mssql connectors
+import _ "github.com/microsoft/go-mssqldb"
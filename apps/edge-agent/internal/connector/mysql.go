@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("mysql", newMySQL)
+}
+
+// mysqlDriver is the database/sql driver name registered by go-sql-driver
+const mysqlDriver = "mysql"
+
+// mysqlConfig configures a MySQL or MariaDB connector. TLS is the driver's
+// tls setting: true, false, skip-verify or preferred.
+type mysqlConfig struct {
+	sqlConfig
+	TLS string `json:"tls"`
+}
+
+// newMySQL creates a connector for a MySQL or MariaDB database. Queries
+// take ? placeholders, and writes given conflict columns update the row
+// that conflicts on any unique key with INSERT ... ON DUPLICATE KEY UPDATE.
+func newMySQL(def *model.Connector) (Connector, error) {
+	var cfg mysqlConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	return newSQLConnector(def, cfg.sqlConfig, mysqlDialect{}, func(connectTimeout time.Duration) string {
+		port := cfg.Port
+		if port == 0 {
+			port = 3306
+		}
+		var dsn strings.Builder
+		if cfg.Username != "" {
+			dsn.WriteString(cfg.Username)
+			if cfg.Password != "" {
+				dsn.WriteString(":" + cfg.Password)
+			}
+			dsn.WriteString("@")
+		}
+		dsn.WriteString("tcp(" + net.JoinHostPort(cfg.Host, strconv.Itoa(port)) + ")/" + cfg.Database)
+		// Scan dates and times as time.Time rather than text
+		q := url.Values{"parseTime": {"true"}}
+		if cfg.TLS != "" {
+			q.Set("tls", cfg.TLS)
+		}
+		if connectTimeout > 0 {
+			q.Set("timeout", connectTimeout.String())
+		}
+		dsn.WriteString("?" + q.Encode())
+		return dsn.String()
+	})
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) driverName() string {
+	return mysqlDriver
+}
+
+func (mysqlDialect) quoteIdent(name string) string {
+	return quoteWith(name, "`", "`")
+}
+
+func (mysqlDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) selectQuery(table, where string, limit int) string {
+	query := "SELECT * FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query + " LIMIT " + strconv.Itoa(limit)
+}
+
+func (d mysqlDialect) insertQuery(table string, cols, conflict []string) string {
+	isKey := make(map[string]bool, len(conflict))
+	for _, col := range conflict {
+		isKey[col] = true
+	}
+	quoted := make([]string, len(cols))
+	placeholders := make([]string, len(cols))
+	var updates []string
+	for i, col := range cols {
+		quoted[i] = d.quoteIdent(col)
+		placeholders[i] = "?"
+		if !isKey[col] {
+			updates = append(updates, quoted[i]+" = VALUES("+quoted[i]+")")
+		}
+	}
+
+	query := "INSERT INTO " + table + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	if len(conflict) > 0 {
+		if len(updates) == 0 {
+			// Leave the conflicting row as it is
+			key := d.quoteIdent(conflict[0])
+			updates = append(updates, key+" = "+key)
+		}
+		query += " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+	return query
+}
+
+// columnValue also parses the numbers of queries without arguments, which
+// the driver runs on the text protocol and scans as bytes
+func (mysqlDialect) columnValue(dbType string, v interface{}) interface{} {
+	if data, ok := v.([]byte); ok {
+		switch dbType {
+		case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+			if n, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+				return n
+			}
+		case "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT":
+			if n, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+				return n
+			}
+		case "FLOAT", "DOUBLE":
+			if f, err := strconv.ParseFloat(string(data), 64); err == nil {
+				return f
+			}
+		}
+	}
+	binary := strings.HasSuffix(dbType, "BLOB") || strings.HasSuffix(dbType, "BINARY")
+	return textValue(v, dbType == "JSON", binary)
+}
+
+func (mysqlDialect) createCommitsQuery() string {
+	return "CREATE TABLE IF NOT EXISTS " + commitsTable + " (commit_key CHAR(64) PRIMARY KEY, committed_at DATETIME(6) NOT NULL)"
+}
@@ -0,0 +1,4 @@
+package connector
+
+// Register the database/sql driver used by mysql connectors
+import _ "github.com/go-sql-driver/mysql"
@@ -0,0 +1,4 @@
+package connector
+
+// Register the pgx database/sql driver used by postgresql connectors
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,409 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("plugin", newPlugin)
+}
+
+// ErrPluginDown is returned for calls to a plugin that has used up its
+// restart budget
+var ErrPluginDown = errors.New("plugin exceeded its restart budget")
+
+// Sandbox is where plugin connectors run: the executables of Dir, each in a
+// child process held to CPU cores and MemoryBytes (0 for no limit), and
+// restarted after a crash at most MaxRestarts times per RestartWindow.
+// Limits use cgroups under CgroupRoot on Linux and job objects on Windows;
+// elsewhere plugins run without them.
+type Sandbox struct {
+	Dir           string
+	CPU           float64
+	MemoryBytes   int64
+	MaxRestarts   int
+	RestartWindow time.Duration
+	CgroupRoot    string
+}
+
+var (
+	sandboxMu sync.RWMutex
+	sandbox   Sandbox
+)
+
+// SetSandbox sets the sandbox of plugins started from now on
+func SetSandbox(s Sandbox) {
+	sandboxMu.Lock()
+	defer sandboxMu.Unlock()
+	sandbox = s
+}
+
+func currentSandbox() Sandbox {
+	sandboxMu.RLock()
+	defer sandboxMu.RUnlock()
+	return sandbox
+}
+
+// maxPluginFrame bounds the replies a plugin may send
+const maxPluginFrame = 64 << 20
+
+// stderrTail is how much of a plugin's standard error is kept to explain
+// why it exited
+const stderrTail = 4 << 10
+
+// pluginConfig configures a plugin connector. Plugin names an executable of
+// the sandbox directory; Config is handed to it on connect.
+type pluginConfig struct {
+	Plugin string          `json:"plugin"`
+	Args   []string        `json:"args"`
+	Config json.RawMessage `json:"config"`
+}
+
+// pluginCall is a request to a plugin process, one JSON object per line on
+// its standard input. Method is connect, test, read or write.
+type pluginCall struct {
+	ID          uint64                 `json:"id"`
+	Method      string                 `json:"method"`
+	Config      json.RawMessage        `json:"config,omitempty"`
+	Operation   string                 `json:"operation,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Body        []byte                 `json:"body,omitempty"`
+	ContentType string                 `json:"contentType,omitempty"`
+}
+
+// pluginReply answers the call with the same ID, one JSON object per line
+// on the plugin's standard output
+type pluginReply struct {
+	ID      uint64   `json:"id"`
+	Records []Record `json:"records,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// pluginConnector runs a third-party connector out of process, so that a
+// crash, a leak or a busy loop costs only its own calls. A crashed plugin is
+// restarted on the next call while its restart budget lasts.
+type pluginConnector struct {
+	def *model.Connector
+	cfg pluginConfig
+
+	mu       sync.Mutex
+	proc     *pluginProcess
+	restarts []time.Time
+	closed   bool
+}
+
+func newPlugin(def *model.Connector) (Connector, error) {
+	var cfg pluginConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Plugin == "" {
+		return nil, errors.New("config.plugin is required")
+	}
+	// Plugins are only run from the sandbox directory
+	if cfg.Plugin != filepath.Base(cfg.Plugin) || strings.HasPrefix(cfg.Plugin, ".") {
+		return nil, fmt.Errorf("invalid plugin name %q", cfg.Plugin)
+	}
+	return &pluginConnector{def: def, cfg: cfg}, nil
+}
+
+// Connect starts the plugin process and hands it the configuration
+func (c *pluginConnector) Connect(ctx context.Context) error {
+	_, err := c.process(ctx)
+	return err
+}
+
+// TestConnection asks the plugin to test its connection
+func (c *pluginConnector) TestConnection(ctx context.Context) error {
+	_, err := c.call(ctx, &pluginCall{Method: "test"})
+	return err
+}
+
+// Read asks the plugin for records
+func (c *pluginConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	reply, err := c.call(ctx, &pluginCall{Method: "read", Operation: r.Operation, Params: r.Params})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+// Write hands the request body to the plugin
+func (c *pluginConnector) Write(ctx context.Context, r Request) error {
+	_, err := c.call(ctx, &pluginCall{Method: "write", Operation: r.Operation, Params: r.Params, Body: r.Body, ContentType: r.ContentType})
+	return err
+}
+
+// Close stops the plugin process
+func (c *pluginConnector) Close() error {
+	c.mu.Lock()
+	proc := c.proc
+	c.proc, c.closed = nil, true
+	c.mu.Unlock()
+	if proc != nil {
+		proc.stop()
+	}
+	return nil
+}
+
+// call sends a call to the running plugin
+func (c *pluginConnector) call(ctx context.Context, call *pluginCall) (*pluginReply, error) {
+	proc, err := c.process(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return proc.call(ctx, call)
+}
+
+// process returns the running plugin process, starting it when it is not
+// running yet or has exited and the restart budget allows
+func (c *pluginConnector) process(ctx context.Context) (*pluginProcess, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errors.New("connector is closed")
+	}
+	if c.proc != nil && !c.proc.exited() {
+		return c.proc, nil
+	}
+	sb := currentSandbox()
+	if c.proc != nil {
+		// A crash: restart within the budget
+		now := time.Now()
+		kept := c.restarts[:0]
+		for _, at := range c.restarts {
+			if now.Sub(at) < sb.RestartWindow {
+				kept = append(kept, at)
+			}
+		}
+		c.restarts = kept
+		if len(c.restarts) >= sb.MaxRestarts {
+			return nil, fmt.Errorf("plugin %s: %w (%d restarts in %s; last exit: %v)", c.cfg.Plugin, ErrPluginDown, len(c.restarts), sb.RestartWindow, c.proc.err)
+		}
+		c.restarts = append(c.restarts, now)
+		c.proc = nil
+	}
+
+	proc, err := startPlugin(sb, c.def.ID, filepath.Join(sb.Dir, c.cfg.Plugin), c.cfg.Args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", c.cfg.Plugin, err)
+	}
+	if _, err := proc.call(ctx, &pluginCall{Method: "connect", Config: c.cfg.Config}); err != nil {
+		proc.stop()
+		return nil, err
+	}
+	c.proc = proc
+	return proc, nil
+}
+
+// pluginProcess is one run of a plugin. Calls are matched to replies by ID,
+// so several may be in progress at once.
+type pluginProcess struct {
+	name string
+	cmd  *exec.Cmd
+	jail *jail
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+	stderr  *tailBuffer
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *pluginReply
+	done    chan struct{}
+	err     error
+}
+
+// startPlugin starts the executable at path in the sandbox
+func startPlugin(sb Sandbox, connectorID, path string, args []string) (*pluginProcess, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Dir = sb.Dir
+	// Do not wait on descendants still holding the plugin's output
+	cmd.WaitDelay = 5 * time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &pluginProcess{
+		name:    filepath.Base(path),
+		cmd:     cmd,
+		stdin:   stdin,
+		stderr:  &tailBuffer{max: stderrTail},
+		pending: make(map[uint64]chan *pluginReply),
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = p.stderr
+
+	if p.jail, err = newJail(cmd, sb, connectorID); err != nil {
+		return nil, fmt.Errorf("failed to set up sandbox: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		p.jail.release()
+		return nil, err
+	}
+	if err := p.jail.attach(cmd.Process); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		p.jail.release()
+		return nil, fmt.Errorf("failed to confine plugin: %w", err)
+	}
+	go p.read(stdout)
+	return p, nil
+}
+
+// read dispatches replies until the plugin closes its output, then waits
+// for it to exit and fails the calls still pending
+func (p *pluginProcess) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), maxPluginFrame)
+	for scanner.Scan() {
+		var reply pluginReply
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			// Not speaking the protocol: stop it rather than guess
+			p.cmd.Process.Kill()
+			break
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[reply.ID]
+		delete(p.pending, reply.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- &reply
+		}
+	}
+
+	err := p.cmd.Wait()
+	p.jail.release()
+	if err == nil {
+		err = errors.New("exited")
+	}
+	if tail := p.stderr.String(); tail != "" {
+		err = fmt.Errorf("%w: %s", err, tail)
+	}
+	p.mu.Lock()
+	p.err = fmt.Errorf("plugin %s: %w", p.name, err)
+	for id, ch := range p.pending {
+		delete(p.pending, id)
+		close(ch)
+	}
+	p.mu.Unlock()
+	close(p.done)
+}
+
+// exited reports whether the process has exited
+func (p *pluginProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// call sends a call and waits for its reply, the process to exit or ctx
+func (p *pluginProcess) call(ctx context.Context, call *pluginCall) (*pluginReply, error) {
+	ch := make(chan *pluginReply, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.nextID++
+	call.ID = p.nextID
+	p.pending[call.ID] = ch
+	p.mu.Unlock()
+
+	line, err := json.Marshal(call)
+	if err != nil {
+		p.forget(call.ID)
+		return nil, fmt.Errorf("failed to encode plugin call: %w", err)
+	}
+	p.writeMu.Lock()
+	_, err = p.stdin.Write(append(line, '\n'))
+	p.writeMu.Unlock()
+	if err != nil {
+		// The plugin stopped reading, most likely as it is exiting
+		p.forget(call.ID)
+		select {
+		case <-p.done:
+			return nil, p.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return nil, p.err
+		}
+		if reply.Error != "" {
+			return nil, errors.New(reply.Error)
+		}
+		return reply, nil
+	case <-ctx.Done():
+		p.forget(call.ID)
+		return nil, ctx.Err()
+	}
+}
+
+func (p *pluginProcess) forget(id uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+// stop closes the plugin's input, asking it to exit, and kills it when it
+// has not after a grace period
+func (p *pluginProcess) stop() {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, data...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(data), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
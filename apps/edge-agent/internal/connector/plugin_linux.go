@@ -0,0 +1,104 @@
+package connector
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cgroup CPU accounting period, in microseconds
+const cpuPeriod = 100000
+
+// jail is the cgroup v2 of a plugin process. The process starts in it, so
+// nothing it spawns escapes the limits, and everything left in it is killed
+// on release. CgroupRoot must be writable by the agent, such as a cgroup
+// delegated by its systemd unit.
+type jail struct {
+	dir string
+	fd  *os.File
+}
+
+func newJail(cmd *exec.Cmd, sb Sandbox, connectorID string) (*jail, error) {
+	// A plugin must not outlive the agent
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if sb.CPU <= 0 && sb.MemoryBytes <= 0 {
+		return &jail{}, nil
+	}
+
+	if err := os.MkdirAll(sb.CgroupRoot, 0o755); err != nil {
+		return nil, err
+	}
+	// Enable the controllers for the plugin cgroups; they may already be
+	_ = os.WriteFile(filepath.Join(sb.CgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory"), 0)
+	dir, err := os.MkdirTemp(sb.CgroupRoot, "plugin-"+filepath.Base(connectorID)+"-")
+	if err != nil {
+		return nil, err
+	}
+	j := &jail{dir: dir}
+	if sb.CPU > 0 {
+		quota := int64(sb.CPU * cpuPeriod)
+		if quota < 1000 {
+			quota = 1000
+		}
+		if err := j.set("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			j.release()
+			return nil, err
+		}
+	}
+	if sb.MemoryBytes > 0 {
+		if err := j.set("memory.max", strconv.FormatInt(sb.MemoryBytes, 10)); err != nil {
+			j.release()
+			return nil, err
+		}
+		// Without swap accounting the memory limit still applies
+		_ = j.set("memory.swap.max", "0")
+	}
+	if j.fd, err = os.Open(dir); err != nil {
+		j.release()
+		return nil, err
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(j.fd.Fd())
+	return j, nil
+}
+
+// set writes a cgroup interface file
+func (j *jail) set(file, value string) error {
+	if err := os.WriteFile(filepath.Join(j.dir, file), []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to set %s: %w", file, err)
+	}
+	return nil
+}
+
+// attach has nothing left to do, as the process started in the cgroup
+func (j *jail) attach(p *os.Process) error {
+	j.closeFD()
+	return nil
+}
+
+// release kills whatever the plugin left behind and removes its cgroup
+func (j *jail) release() {
+	j.closeFD()
+	if j.dir == "" {
+		return
+	}
+	_ = j.set("cgroup.kill", "1")
+	// The cgroup is busy until the killed processes are gone
+	for i := 0; i < 20; i++ {
+		if err := os.Remove(j.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (j *jail) closeFD() {
+	if j.fd != nil {
+		j.fd.Close()
+		j.fd = nil
+	}
+}
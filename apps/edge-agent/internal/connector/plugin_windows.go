@@ -0,0 +1,81 @@
+package connector
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// CPU rate control of job objects, which golang.org/x/sys/windows lacks
+const (
+	jobObjectCPURateControlInformation = 15
+	jobObjectCPURateControlEnable      = 0x1
+	jobObjectCPURateControlHardCap     = 0x4
+)
+
+type jobObjectCPURateControl struct {
+	ControlFlags uint32
+	// CPURate is the share of all processors, in hundredths of a percent
+	CPURate uint32
+}
+
+// jail is the job object of a plugin process. Processes it spawns join the
+// job too, and closing the job kills them all.
+type jail struct {
+	job windows.Handle
+}
+
+func newJail(cmd *exec.Cmd, sb Sandbox, connectorID string) (*jail, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &jail{job: job}
+
+	limits := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	limits.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if sb.MemoryBytes > 0 {
+		limits.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		limits.JobMemoryLimit = uintptr(sb.MemoryBytes)
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), uint32(unsafe.Sizeof(limits))); err != nil {
+		j.release()
+		return nil, err
+	}
+	if sb.CPU > 0 {
+		rate := uint32(sb.CPU / float64(runtime.NumCPU()) * 10000)
+		if rate < 1 {
+			rate = 1
+		}
+		if rate > 10000 {
+			rate = 10000
+		}
+		control := jobObjectCPURateControl{ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap, CPURate: rate}
+		if _, err := windows.SetInformationJobObject(job, jobObjectCPURateControlInformation, uintptr(unsafe.Pointer(&control)), uint32(unsafe.Sizeof(control))); err != nil {
+			j.release()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// attach assigns the started process to the job
+func (j *jail) attach(p *os.Process) error {
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.Pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	return windows.AssignProcessToJobObject(j.job, h)
+}
+
+// release closes the job, killing whatever the plugin left behind
+func (j *jail) release() {
+	if j.job != 0 {
+		windows.CloseHandle(j.job)
+		j.job = 0
+	}
+}
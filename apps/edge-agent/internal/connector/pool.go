@@ -0,0 +1,285 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Source provides connector definitions by ID
+type Source interface {
+	Get(ctx context.Context, id string) (*model.Connector, error)
+}
+
+// EgressGuard decides whether a connector may connect to the hosts its
+// definition names, returning an error when it may not
+type EgressGuard interface {
+	CheckConnector(ctx context.Context, def *model.Connector) error
+}
+
+// Pool keeps one live connection per connector, opened on first use. It
+// implements engine.Lookuper, so steps resolve reference data through
+// connector operations, and connectors.ChangeHook, so an edited or deleted
+// connector is reconnected with its new definition.
+type Pool struct {
+	source Source
+	egress EgressGuard
+
+	mu    sync.Mutex
+	conns map[string]*pooled
+}
+
+// pooled is a connection being opened or in use. ready is closed once
+// connecting finishes, successfully or not.
+type pooled struct {
+	ready chan struct{}
+	conn  Connector
+	err   error
+}
+
+// NewPool creates a pool of connections to the connectors of source
+func NewPool(source Source) *Pool {
+	return &Pool{source: source, conns: make(map[string]*pooled)}
+}
+
+// SetEgress makes the pool check the hosts of connectors with g before
+// connecting them
+func (p *Pool) SetEgress(g EgressGuard) {
+	p.egress = g
+}
+
+// Get returns the live connection of a connector, connecting if needed.
+// A failed connection is retried on the next call.
+func (p *Pool) Get(ctx context.Context, id string) (Connector, error) {
+	p.mu.Lock()
+	entry, ok := p.conns[id]
+	if !ok {
+		entry = &pooled{ready: make(chan struct{})}
+		p.conns[id] = entry
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		entry.conn, entry.err = p.connect(ctx, id)
+		close(entry.ready)
+		if entry.err != nil {
+			p.remove(id, entry)
+		}
+	}
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return entry.conn, entry.err
+}
+
+func (p *Pool) connect(ctx context.Context, id string) (Connector, error) {
+	def, err := p.source.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connector %s: %w", id, err)
+	}
+	if p.egress != nil {
+		if err := p.egress.CheckConnector(ctx, def); err != nil {
+			return nil, fmt.Errorf("connector %s: %w", id, err)
+		}
+	}
+	if def, err = Resolve(def); err != nil {
+		return nil, fmt.Errorf("connector %s: %w", id, err)
+	}
+	conn, err := New(def)
+	if err != nil {
+		return nil, fmt.Errorf("connector %s: %w", id, err)
+	}
+	if err := conn.Connect(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect connector %s: %w", id, err)
+	}
+	return conn, nil
+}
+
+// Connection states reported by Status
+const (
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+)
+
+// ConnectionStatus is the state of a pooled connection
+type ConnectionStatus struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+// Status returns the state of each pooled connection, sorted by connector.
+// Connectors without one have not been used, or failed to connect.
+func (p *Pool) Status() []ConnectionStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]ConnectionStatus, 0, len(p.conns))
+	for id, entry := range p.conns {
+		state := StateConnected
+		select {
+		case <-entry.ready:
+			if entry.err != nil {
+				continue
+			}
+		default:
+			state = StateConnecting
+		}
+		list = append(list, ConnectionStatus{ID: id, State: state})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Lookup reads records through a connector operation
+func (p *Pool) Lookup(ctx context.Context, connectorID, operation string, params map[string]interface{}) ([]map[string]interface{}, error) {
+	conn, err := p.Get(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Read(ctx, Request{Operation: operation, Params: params})
+}
+
+// Write sends a request body through a connector
+func (p *Pool) Write(ctx context.Context, connectorID string, req Request) error {
+	conn, err := p.Get(ctx, connectorID)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, req)
+}
+
+// WriteCommitted implements engine.Committer for connectors that are
+// CommitWriters
+func (p *Pool) WriteCommitted(ctx context.Context, connectorID string, w engine.CommittedWrite) error {
+	cw, err := p.commitWriter(ctx, connectorID)
+	if err != nil {
+		return err
+	}
+	return cw.WriteCommitted(ctx, Request{Operation: w.Operation, Params: w.Params, Body: w.Body, ContentType: w.ContentType}, w.Key)
+}
+
+// Committed implements engine.Committer
+func (p *Pool) Committed(ctx context.Context, connectorID, key string) (bool, error) {
+	cw, err := p.commitWriter(ctx, connectorID)
+	if err != nil {
+		return false, err
+	}
+	return cw.Committed(ctx, key)
+}
+
+func (p *Pool) commitWriter(ctx context.Context, connectorID string) (CommitWriter, error) {
+	conn, err := p.Get(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	cw, ok := conn.(CommitWriter)
+	if !ok {
+		return nil, fmt.Errorf("connector %s does not support transactional writes", connectorID)
+	}
+	return cw, nil
+}
+
+// Rotate reconnects a connector in use with its current definition and
+// credentials. The new connection replaces the old one only once it is
+// connected, so a failed rotation leaves the old one in use; calls in
+// progress on the old one finish before it closes. It returns false when
+// the connector is not in use, and connects anew on first use anyway.
+func (p *Pool) Rotate(ctx context.Context, id string) (bool, error) {
+	p.mu.Lock()
+	old, ok := p.conns[id]
+	p.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	conn, err := p.connect(ctx, id)
+	if err != nil {
+		return true, err
+	}
+	entry := &pooled{ready: make(chan struct{}), conn: conn}
+	close(entry.ready)
+
+	p.mu.Lock()
+	current, ok := p.conns[id]
+	if ok && current == old {
+		p.conns[id] = entry
+	}
+	p.mu.Unlock()
+	if !ok || current != old {
+		// Dropped or replaced meanwhile; the new connection is not needed
+		conn.Close()
+		return ok, nil
+	}
+	go func() {
+		<-old.ready
+		if old.conn != nil {
+			old.conn.Close()
+		}
+	}()
+	return true, nil
+}
+
+// ConnectorSaved drops the connection of an edited connector
+func (p *Pool) ConnectorSaved(def *model.Connector) {
+	p.drop(def.ID)
+}
+
+// ConnectorDeleted drops the connection of a deleted connector
+func (p *Pool) ConnectorDeleted(id string) {
+	p.drop(id)
+}
+
+// drop closes and forgets a connection
+func (p *Pool) drop(id string) {
+	p.mu.Lock()
+	entry, ok := p.conns[id]
+	delete(p.conns, id)
+	p.mu.Unlock()
+
+	if ok {
+		go func() {
+			<-entry.ready
+			if entry.conn != nil {
+				entry.conn.Close()
+			}
+		}()
+	}
+}
+
+// remove forgets entry if it is still the connection of id
+func (p *Pool) remove(id string, entry *pooled) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns[id] == entry {
+		delete(p.conns, id)
+	}
+}
+
+// Close closes every connection
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = make(map[string]*pooled)
+	p.mu.Unlock()
+
+	var errs []error
+	for id, entry := range conns {
+		<-entry.ready
+		if entry.conn == nil {
+			continue
+		}
+		if err := entry.conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close connector %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
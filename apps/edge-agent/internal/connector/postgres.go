@@ -0,0 +1,116 @@
+package connector
+
+import (
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	Register("postgresql", newPostgres)
+}
+
+// pgxDriver is the database/sql driver name registered by pgx
+const pgxDriver = "pgx"
+
+// postgresConfig configures a PostgreSQL connector
+type postgresConfig struct {
+	sqlConfig
+	SSLMode string `json:"sslmode"`
+}
+
+// newPostgres creates a connector for a PostgreSQL database. Queries take
+// $1, $2, ... placeholders, and writes update conflicting rows with
+// INSERT ... ON CONFLICT.
+func newPostgres(def *model.Connector) (Connector, error) {
+	var cfg postgresConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	return newSQLConnector(def, cfg.sqlConfig, postgresDialect{}, func(connectTimeout time.Duration) string {
+		u := url.URL{Scheme: "postgres", Host: cfg.Host, Path: "/" + cfg.Database}
+		if cfg.Port != 0 {
+			u.Host = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+		}
+		if cfg.Username != "" {
+			u.User = url.UserPassword(cfg.Username, cfg.Password)
+		}
+		q := url.Values{}
+		if cfg.SSLMode != "" {
+			q.Set("sslmode", cfg.SSLMode)
+		}
+		if connectTimeout > 0 {
+			q.Set("connect_timeout", strconv.Itoa(int(math.Ceil(connectTimeout.Seconds()))))
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	})
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string {
+	return pgxDriver
+}
+
+func (postgresDialect) quoteIdent(name string) string {
+	return quoteWith(name, `"`, `"`)
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) selectQuery(table, where string, limit int) string {
+	query := "SELECT * FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	return query + " LIMIT " + strconv.Itoa(limit)
+}
+
+func (d postgresDialect) insertQuery(table string, cols, conflict []string) string {
+	isKey := make(map[string]bool, len(conflict))
+	for _, col := range conflict {
+		isKey[col] = true
+	}
+	quoted := make([]string, len(cols))
+	placeholders := make([]string, len(cols))
+	var updates []string
+	for i, col := range cols {
+		quoted[i] = d.quoteIdent(col)
+		placeholders[i] = d.placeholder(i + 1)
+		if !isKey[col] {
+			updates = append(updates, quoted[i]+" = EXCLUDED."+quoted[i])
+		}
+	}
+
+	query := "INSERT INTO " + table + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	if len(conflict) > 0 {
+		keys := make([]string, len(conflict))
+		for i, col := range conflict {
+			keys[i] = d.quoteIdent(col)
+		}
+		query += " ON CONFLICT (" + strings.Join(keys, ", ") + ")"
+		if len(updates) > 0 {
+			query += " DO UPDATE SET " + strings.Join(updates, ", ")
+		} else {
+			query += " DO NOTHING"
+		}
+	}
+	return query
+}
+
+func (postgresDialect) columnValue(dbType string, v interface{}) interface{} {
+	return textValue(v, dbType == "JSON" || dbType == "JSONB", dbType == "BYTEA")
+}
+
+func (postgresDialect) createCommitsQuery() string {
+	return "CREATE TABLE IF NOT EXISTS " + commitsTable + " (commit_key CHAR(64) PRIMARY KEY, committed_at TIMESTAMP NOT NULL)"
+}
@@ -0,0 +1,192 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/remotefs"
+)
+
+func init() {
+	for _, protocol := range []string{remotefs.ProtocolSFTP, remotefs.ProtocolFTP, remotefs.ProtocolFTPS} {
+		protocol := protocol
+		Register(protocol, func(def *model.Connector) (Connector, error) {
+			return newRemoteFS(protocol, def)
+		})
+	}
+}
+
+// remoteFSConfig configures an sftp, ftp or ftps connector
+type remoteFSConfig struct {
+	remotefs.Config
+	// Directory is where relative paths start, the login directory when empty
+	Directory string `json:"directory"`
+}
+
+// remoteFSConnector reads and writes files on an SFTP or FTP server.
+// Requests name a file with the "path" parameter or the path of the
+// requested operation. Reads decode JSON like the file connector, or list
+// the directory when the "list" parameter is true or the operation's action
+// is "list". A failed call drops the connection, which is redialed on the
+// next one.
+type remoteFSConnector struct {
+	def      *model.Connector
+	protocol string
+	cfg      remoteFSConfig
+
+	mu sync.Mutex
+	fs remotefs.FS
+}
+
+func newRemoteFS(protocol string, def *model.Connector) (Connector, error) {
+	var cfg remoteFSConfig
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Config.Validate(protocol); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &remoteFSConnector{def: def, protocol: protocol, cfg: cfg}, nil
+}
+
+func (c *remoteFSConnector) Connect(ctx context.Context) error {
+	return c.do(ctx, func(fs remotefs.FS) error { return nil })
+}
+
+// TestConnection checks that the directory can be listed
+func (c *remoteFSConnector) TestConnection(ctx context.Context) error {
+	return c.do(ctx, func(fs remotefs.FS) error {
+		_, err := fs.List(ctx, c.dir())
+		return err
+	})
+}
+
+func (c *remoteFSConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	name, op, err := c.resolve(r)
+	if err != nil {
+		return nil, err
+	}
+	list, _ := r.Params["list"].(bool)
+	if op != nil && op.Action == "list" {
+		list = true
+	}
+
+	var records []Record
+	err = c.do(ctx, func(fs remotefs.FS) error {
+		if list {
+			files, err := fs.List(ctx, name)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				records = append(records, Record{"name": f.Name, "path": f.Path, "size": f.Size, "modTime": f.ModTime, "isDir": f.IsDir})
+			}
+			return nil
+		}
+		rc, err := fs.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxFileBytes+1))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if len(data) > maxFileBytes {
+			return fmt.Errorf("%s is larger than %d bytes", name, maxFileBytes)
+		}
+		records, err = decodeFileRecords(name, data)
+		return err
+	})
+	return records, err
+}
+
+// Write uploads the request body, creating the file's directory
+func (c *remoteFSConnector) Write(ctx context.Context, r Request) error {
+	name, _, err := c.resolve(r)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, func(fs remotefs.FS) error {
+		if dir := path.Dir(name); dir != "." && dir != "/" {
+			if err := fs.MkdirAll(ctx, dir); err != nil {
+				return err
+			}
+		}
+		if err := fs.Put(ctx, name, bytes.NewReader(r.Body)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func (c *remoteFSConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fs == nil {
+		return nil
+	}
+	err := c.fs.Close()
+	c.fs = nil
+	return err
+}
+
+// do runs fn with the connection, dialing it first when needed
+func (c *remoteFSConnector) do(ctx context.Context, fn func(fs remotefs.FS) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fs == nil {
+		fs, err := remotefs.Dial(ctx, c.protocol, c.cfg.Config)
+		if err != nil {
+			return err
+		}
+		c.fs = fs
+	}
+	if err := fn(c.fs); err != nil {
+		c.fs.Close()
+		c.fs = nil
+		return err
+	}
+	return nil
+}
+
+func (c *remoteFSConnector) dir() string {
+	if c.cfg.Directory == "" {
+		return "."
+	}
+	return c.cfg.Directory
+}
+
+// resolve returns the remote path a request names and its operation, if any
+func (c *remoteFSConnector) resolve(r Request) (string, *model.Operation, error) {
+	var op *model.Operation
+	name, _ := r.Params["path"].(string)
+	if r.Operation != "" {
+		found, ok := c.def.Operation(r.Operation)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+		op = found
+		if name == "" {
+			name = op.Path
+		}
+	}
+	if name == "" {
+		list, _ := r.Params["list"].(bool)
+		if !list && (op == nil || op.Action != "list") {
+			return "", nil, errors.New("no file given: set the path parameter")
+		}
+		name = "."
+	}
+	if !path.IsAbs(name) {
+		name = path.Join(c.dir(), name)
+	}
+	return name, op, nil
+}
@@ -0,0 +1,133 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/snmp"
+)
+
+func init() {
+	Register("snmp", newSNMP)
+}
+
+// snmpConnector reads the objects of an SNMP agent. Requests name them with
+// the "oids" parameter, a list or comma-separated names or OIDs, or the
+// path of the requested operation. The "walk" action, given as a parameter
+// or the operation's action, reads the subtrees under them; the default
+// "get" reads the objects themselves. Each variable is a record.
+type snmpConnector struct {
+	def *model.Connector
+	cfg snmp.Config
+}
+
+func newSNMP(def *model.Connector) (Connector, error) {
+	var cfg snmp.Config
+	if err := engine.DecodeConfig(def.Config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Target == "" {
+		return nil, errors.New("invalid config: target is required")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &snmpConnector{def: def, cfg: cfg}, nil
+}
+
+// Connect loads the MIBs; SNMP over UDP has no connection to check
+func (c *snmpConnector) Connect(ctx context.Context) error {
+	_, err := snmp.LoadMIB(c.cfg.MIBs)
+	return err
+}
+
+// TestConnection reads the agent's sysUpTime
+func (c *snmpConnector) TestConnection(ctx context.Context) error {
+	client, err := snmp.NewClient(ctx, c.cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.Get([]string{"sysUpTime.0"})
+	return err
+}
+
+func (c *snmpConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	action, _ := r.Params["action"].(string)
+	var oids []string
+	switch v := r.Params["oids"].(type) {
+	case string:
+		oids = splitList(v)
+	case []interface{}:
+		for _, item := range v {
+			oids = append(oids, fmt.Sprint(item))
+		}
+	}
+	if r.Operation != "" {
+		op, ok := c.def.Operation(r.Operation)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+		if len(oids) == 0 {
+			oids = splitList(op.Path)
+		}
+		if action == "" {
+			action = op.Action
+		}
+	}
+	if len(oids) == 0 {
+		return nil, errors.New("no objects given: set the oids parameter")
+	}
+
+	client, err := snmp.NewClient(ctx, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	var vars []snmp.Variable
+	switch action {
+	case "", "get":
+		if vars, err = client.Get(oids); err != nil {
+			return nil, err
+		}
+	case "walk":
+		for _, oid := range oids {
+			subtree, err := client.Walk(oid)
+			if err != nil {
+				return nil, err
+			}
+			vars = append(vars, subtree...)
+		}
+	default:
+		return nil, fmt.Errorf("invalid action %q: must be get or walk", action)
+	}
+
+	records := make([]Record, 0, len(vars))
+	for _, v := range vars {
+		records = append(records, Record{"oid": v.OID, "name": v.Name, "type": v.Type, "value": v.Value})
+	}
+	return records, nil
+}
+
+func (c *snmpConnector) Write(ctx context.Context, r Request) error {
+	return errors.New("snmp connectors are read-only")
+}
+
+func (c *snmpConnector) Close() error {
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,497 @@
+package connector
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// sqlConfig configures a database connector, either with a DSN or with its
+// parts. Connections are pooled: up to MaxConnections are open at once,
+// MaxIdleConnections are kept between calls, and each is replaced after
+// ConnMaxLifetime. Reads return at most MaxRows rows.
+type sqlConfig struct {
+	DSN                string `json:"dsn"`
+	Host               string `json:"host"`
+	Port               int    `json:"port"`
+	Database           string `json:"database"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	ConnectTimeout     string `json:"connectTimeout"`
+	QueryTimeout       string `json:"queryTimeout"`
+	MaxConnections     int    `json:"maxConnections"`
+	MaxIdleConnections int    `json:"maxIdleConnections"`
+	ConnMaxLifetime    string `json:"connMaxLifetime"`
+	MaxRows            int    `json:"maxRows"`
+}
+
+// sqlDialect is what differs between the databases of SQL connectors
+type sqlDialect interface {
+	// driverName is the database/sql driver the connector opens
+	driverName() string
+	quoteIdent(name string) string
+	// placeholder is the marker of the nth query argument, counting from 1
+	placeholder(n int) string
+	// selectQuery selects at most limit rows of table, filtered by where
+	// unless it is empty
+	selectQuery(table, where string, limit int) string
+	// insertQuery inserts a row of cols, updating the row that conflicts on
+	// the conflict columns, if any. Arguments follow the order of cols.
+	insertQuery(table string, cols, conflict []string) string
+	// columnValue converts a scanned column of type dbType to a record value
+	columnValue(dbType string, v interface{}) interface{}
+	// createCommitsQuery creates the commits table unless it exists
+	createCommitsQuery() string
+}
+
+// commitsTable records the idempotency keys of the writes of exactly-once
+// sinks, in the transaction of each write. Keys are stored as their SHA-256
+// digest, so that they fit an indexed column in every database.
+const commitsTable = "fusionflow_commits"
+
+// sqlConnector queries and writes tables of a database through
+// database/sql. Reads run the SQL of the requested operation, set in its
+// "query" binding with the dialect's placeholders filled from the
+// operation's parameters in order; without one they select the rows of a
+// table matching the request parameters. Writes insert the records of the
+// request body into a table, updating rows that conflict on the columns of
+// the "conflict" binding or parameter. Tables are named by the "table"
+// parameter or the operation's path.
+type sqlConnector struct {
+	def          *model.Connector
+	dialect      sqlDialect
+	dsn          string
+	cfg          sqlConfig
+	queryTimeout time.Duration
+	lifetime     time.Duration
+	db           *sql.DB
+
+	// commitsMu guards creating the commits table on first use
+	commitsMu sync.Mutex
+	commits   bool
+}
+
+// newSQLConnector checks the common settings of cfg. dsn builds the DSN
+// from the parts of cfg, with the connect timeout, when it has no DSN.
+func newSQLConnector(def *model.Connector, cfg sqlConfig, dialect sqlDialect, dsn func(connectTimeout time.Duration) string) (*sqlConnector, error) {
+	c := &sqlConnector{def: def, dialect: dialect, cfg: cfg}
+	if cfg.MaxConnections == 0 {
+		c.cfg.MaxConnections = 10
+	}
+	if cfg.MaxIdleConnections == 0 {
+		c.cfg.MaxIdleConnections = 2
+	}
+	if cfg.MaxRows == 0 {
+		c.cfg.MaxRows = 10000
+	}
+	if c.cfg.MaxConnections < 0 || c.cfg.MaxIdleConnections < 0 || c.cfg.MaxRows < 0 {
+		return nil, errors.New("invalid config: maxConnections, maxIdleConnections and maxRows must be positive")
+	}
+
+	var err error
+	if c.queryTimeout, err = parseDuration("queryTimeout", cfg.QueryTimeout); err != nil {
+		return nil, err
+	}
+	if c.lifetime, err = parseDuration("connMaxLifetime", cfg.ConnMaxLifetime); err != nil {
+		return nil, err
+	}
+	connectTimeout, err := parseDuration("connectTimeout", cfg.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range def.Operations {
+		if q, ok := op.Bindings["query"]; ok {
+			if s, ok := q.(string); !ok || strings.TrimSpace(s) == "" {
+				return nil, fmt.Errorf("invalid operation %s: query must be a SQL statement", op.ID)
+			}
+		}
+	}
+
+	switch {
+	case cfg.DSN != "":
+		c.dsn = cfg.DSN
+	case cfg.Host != "":
+		c.dsn = dsn(connectTimeout)
+	}
+	return c, nil
+}
+
+// parseDuration parses an optional positive duration setting
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid config: %s %q is not a positive duration", name, value)
+	}
+	return d, nil
+}
+
+// Connect opens the connection pool and checks that the database accepts
+// the connector's credentials
+func (c *sqlConnector) Connect(ctx context.Context) error {
+	if c.dsn == "" {
+		return errors.New("config.dsn or config.host is required")
+	}
+	db, err := sql.Open(c.dialect.driverName(), c.dsn)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(c.cfg.MaxConnections)
+	db.SetMaxIdleConns(c.cfg.MaxIdleConnections)
+	db.SetConnMaxLifetime(c.lifetime)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+	c.db = db
+	return nil
+}
+
+// TestConnection runs a trivial query, which fails when the database is
+// unreachable or rejects the credentials
+func (c *sqlConnector) TestConnection(ctx context.Context) error {
+	var one int
+	if err := c.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	return nil
+}
+
+// Read runs the operation's query, or selects from a table, and returns
+// one record per row. JSON columns are decoded.
+func (c *sqlConnector) Read(ctx context.Context, r Request) ([]Record, error) {
+	query, args, err := c.readQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for rows.Next() {
+		if len(records) == c.cfg.MaxRows {
+			return nil, fmt.Errorf("query returned more than %d rows", c.cfg.MaxRows)
+		}
+		values := make([]interface{}, len(types))
+		ptrs := make([]interface{}, len(types))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		rec := make(Record, len(types))
+		for i, col := range types {
+			rec[col.Name()] = c.dialect.columnValue(strings.ToUpper(col.DatabaseTypeName()), values[i])
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return records, nil
+}
+
+// readQuery returns the statement and arguments of a read
+func (c *sqlConnector) readQuery(r Request) (string, []interface{}, error) {
+	var op *model.Operation
+	if r.Operation != "" {
+		var ok bool
+		if op, ok = c.def.Operation(r.Operation); !ok {
+			return "", nil, fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+		if query, ok := op.Bindings["query"].(string); ok {
+			args := make([]interface{}, len(op.Parameters))
+			for i, p := range op.Parameters {
+				value, ok := r.Params[p.Name]
+				if !ok && p.Required {
+					return "", nil, fmt.Errorf("operation %s: parameter %s is required", op.ID, p.Name)
+				}
+				args[i] = sqlValue(value)
+			}
+			return query, args, nil
+		}
+	}
+
+	table, err := c.table(r, op)
+	if err != nil {
+		return "", nil, err
+	}
+	names := make([]string, 0, len(r.Params))
+	for name := range r.Params {
+		if name != "table" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	conds := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		conds[i] = c.dialect.quoteIdent(name) + " = " + c.dialect.placeholder(i+1)
+		args[i] = sqlValue(r.Params[name])
+	}
+	// Fetch one row past the limit so that Read can tell it was exceeded
+	return c.dialect.selectQuery(table, strings.Join(conds, " AND "), c.cfg.MaxRows+1), args, nil
+}
+
+// Write inserts the records of the request body, a JSON object or array,
+// into a table in one transaction
+func (c *sqlConnector) Write(ctx context.Context, r Request) error {
+	return c.write(ctx, r, "")
+}
+
+// WriteCommitted implements CommitWriter: the commits table records key in
+// the transaction inserting the records, and a key already recorded skips
+// the write
+func (c *sqlConnector) WriteCommitted(ctx context.Context, r Request, key string) error {
+	if key != "" {
+		if err := c.ensureCommits(ctx); err != nil {
+			return err
+		}
+	}
+	return c.write(ctx, r, key)
+}
+
+// Committed implements CommitWriter
+func (c *sqlConnector) Committed(ctx context.Context, key string) (bool, error) {
+	if err := c.ensureCommits(ctx); err != nil {
+		return false, err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	var n int
+	err := c.db.QueryRowContext(ctx, c.commitQuery(), commitDigest(key)).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to read commits: %w", err)
+	}
+	return n > 0, nil
+}
+
+// write inserts the records of r, and records key in the commits table
+// unless it is empty
+func (c *sqlConnector) write(ctx context.Context, r Request, key string) error {
+	var op *model.Operation
+	if r.Operation != "" {
+		var ok bool
+		if op, ok = c.def.Operation(r.Operation); !ok {
+			return fmt.Errorf("%w: %s", ErrOperationNotFound, r.Operation)
+		}
+	}
+	table, err := c.table(r, op)
+	if err != nil {
+		return err
+	}
+	records, err := decodeRecords(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 && key == "" {
+		return nil
+	}
+	var conflict []string
+	if op != nil {
+		conflict = stringList(op.Bindings["conflict"])
+	}
+	if v, ok := r.Params["conflict"]; ok {
+		conflict = stringList(v)
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if key != "" {
+		// A concurrent delivery inserting the same key fails on the primary
+		// key instead, and is retried to find it committed
+		var n int
+		if err := tx.QueryRowContext(ctx, c.commitQuery(), commitDigest(key)).Scan(&n); err != nil {
+			return fmt.Errorf("failed to read commits: %w", err)
+		}
+		if n > 0 {
+			return nil
+		}
+		insert := "INSERT INTO " + c.dialect.quoteIdent(commitsTable) + " (commit_key, committed_at) VALUES (" + c.dialect.placeholder(1) + ", " + c.dialect.placeholder(2) + ")"
+		if _, err := tx.ExecContext(ctx, insert, commitDigest(key), time.Now().UTC()); err != nil {
+			return fmt.Errorf("failed to record commit: %w", err)
+		}
+	}
+	for i, rec := range records {
+		cols := make([]string, 0, len(rec))
+		for col := range rec {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		args := make([]interface{}, len(cols))
+		for j, col := range cols {
+			args[j] = sqlValue(rec[col])
+		}
+		if _, err := tx.ExecContext(ctx, c.dialect.insertQuery(table, cols, conflict), args...); err != nil {
+			return fmt.Errorf("failed to write record %d: %w", i, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// ensureCommits creates the commits table the first time it is used
+func (c *sqlConnector) ensureCommits(ctx context.Context) error {
+	c.commitsMu.Lock()
+	defer c.commitsMu.Unlock()
+	if c.commits {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	if _, err := c.db.ExecContext(ctx, c.dialect.createCommitsQuery()); err != nil {
+		return fmt.Errorf("failed to create table %s: %w", commitsTable, err)
+	}
+	c.commits = true
+	return nil
+}
+
+// commitQuery counts the rows of the commits table with a key digest
+func (c *sqlConnector) commitQuery() string {
+	return "SELECT COUNT(*) FROM " + c.dialect.quoteIdent(commitsTable) + " WHERE commit_key = " + c.dialect.placeholder(1)
+}
+
+// commitDigest is the value of the commits table recording key
+func commitDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *sqlConnector) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// table returns the quoted table a request names
+func (c *sqlConnector) table(r Request, op *model.Operation) (string, error) {
+	name, _ := r.Params["table"].(string)
+	if name == "" && op != nil {
+		name = op.Path
+	}
+	if name == "" {
+		return "", errors.New("no table given: set the table parameter")
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "" {
+			return "", fmt.Errorf("invalid table name %q", name)
+		}
+		parts[i] = c.dialect.quoteIdent(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+func (c *sqlConnector) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.queryTimeout > 0 {
+		return context.WithTimeout(ctx, c.queryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// sqlValue converts a decoded JSON value to a query argument: whole numbers
+// become integers and objects and arrays JSON text
+func sqlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return string(data)
+	}
+	return v
+}
+
+// textValue converts a scanned column: JSON types are decoded, binary types
+// kept as bytes and other bytes read as text, such as that of decimals
+func textValue(v interface{}, isJSON, isBinary bool) interface{} {
+	var data []byte
+	switch v := v.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return v
+	}
+	if isJSON {
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err == nil {
+			return decoded
+		}
+	}
+	if isBinary {
+		return data
+	}
+	return string(data)
+}
+
+// quoteWith quotes an identifier between open and close, doubling close
+// within it
+func quoteWith(name, open, close string) string {
+	return open + strings.ReplaceAll(name, close, close+close) + close
+}
+
+// stringList converts a string or list of strings binding to a list
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		var list []string
+		for _, col := range strings.Split(v, ",") {
+			if col = strings.TrimSpace(col); col != "" {
+				list = append(list, col)
+			}
+		}
+		return list
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	case []string:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package connectors
+
+import (
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+// Redacted replaces the values of secret config fields in connectors
+// returned by the API
+const Redacted = "********"
+
+// secretFields are the endings, lower-cased, of the names of config fields
+// holding credentials, so that clientSecret and authPassphrase match too
+var secretFields = []string{"password", "passphrase", "secret", "token", "apikey", "privatekey", "credentials", "dsn"}
+
+// secretHeaders are parts, lower-cased, of the names of headers holding
+// credentials, such as Authorization, X-API-Key and Cookie
+var secretHeaders = []string{"auth", "key", "token", "cookie", "secret", "password"}
+
+// headersField is the config field, at any depth, naming request headers
+const headersField = "headers"
+
+// secretField reports whether the config field name holds a credential.
+// Within headers, names containing any of secretHeaders do too.
+func secretField(name string, header bool) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range secretFields {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	if header {
+		for _, part := range secretHeaders {
+			if strings.Contains(name, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of conn whose secret config fields and headers, at
+// any depth, are replaced by Redacted. Fields holding only ${secret:REF} references
+// are kept, as they name the secret without revealing it.
+func Redact(conn *model.Connector) *model.Connector {
+	if conn == nil || conn.Config == nil {
+		return conn
+	}
+	copied := *conn
+	copied.Config = redactMap(conn.Config, false)
+	return &copied
+}
+
+// RedactAll redacts each connector of list
+func RedactAll(list []*model.Connector) []*model.Connector {
+	redacted := make([]*model.Connector, len(list))
+	for i, conn := range list {
+		redacted[i] = Redact(conn)
+	}
+	return redacted
+}
+
+// redactMap redacts the secret fields of m, which holds headers when
+// header is set
+func redactMap(m map[string]interface{}, header bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); secretField(k, header) && v != nil && !(ok && (s == "" || secrets.OnlyReferences(s))) {
+			out[k] = Redacted
+			continue
+		}
+		out[k] = redactValue(v, strings.EqualFold(k, headersField))
+	}
+	return out
+}
+
+func redactValue(v interface{}, header bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return redactMap(v, header)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactValue(item, false)
+		}
+		return out
+	}
+	return v
+}
+
+// unredact puts the stored values back into the fields and headers of cfg
+// a client left Redacted, as when it updates a connector it read from the
+// API
+func unredact(cfg, stored map[string]interface{}) {
+	unredactMap(cfg, stored, false)
+}
+
+// unredactMap unredacts m, which holds headers when header is set
+func unredactMap(m, stored map[string]interface{}, header bool) {
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			if v == Redacted && secretField(k, header) {
+				if prev, ok := stored[k]; ok {
+					m[k] = prev
+				}
+			}
+		default:
+			unredactValue(v, stored[k], strings.EqualFold(k, headersField))
+		}
+	}
+}
+
+// unredactValue unredacts the maps within v from those within stored at
+// the same place
+func unredactValue(v, stored interface{}, header bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if prev, ok := stored.(map[string]interface{}); ok {
+			unredactMap(v, prev, header)
+		}
+	case []interface{}:
+		if prev, ok := stored.([]interface{}); ok && len(prev) == len(v) {
+			for i := range v {
+				unredactValue(v[i], prev[i], false)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package connectors
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Outbox events auditing credential rotations
+const (
+	EventRotated        = "connector.credentials_rotated"
+	EventRotationFailed = "connector.rotation_failed"
+)
+
+// Causes of rotations
+const (
+	RotationScheduled = "schedule"
+	RotationSecret    = "secret"
+	RotationManual    = "manual"
+)
+
+// rotationCheckInterval is how often connectors are checked for due
+// rotations and changed secrets
+const rotationCheckInterval = 30 * time.Second
+
+// Reconnector reconnects connectors in use, as connector.Pool does
+type Reconnector interface {
+	Rotate(ctx context.Context, id string) (bool, error)
+}
+
+// Rotation is the audit record of a credential rotation. Secret is the
+// reference whose change caused it, never its value.
+type Rotation struct {
+	ConnectorID string `json:"connectorId"`
+	Name        string `json:"name"`
+	Cause       string `json:"cause"`
+	Secret      string `json:"secret,omitempty"`
+	// Reconnected is false when the connector was not in use, so there was
+	// nothing to reconnect
+	Reconnected bool      `json:"reconnected"`
+	Error       string    `json:"error,omitempty"`
+	RotatedAt   time.Time `json:"rotatedAt"`
+}
+
+// Rotator rotates the credentials of connectors with a rotation policy:
+// on schedule, when a secret they read changes, and on demand. Each
+// rotation reconnects the connector and is recorded as an outbox event.
+type Rotator struct {
+	svc    *Service
+	pool   Reconnector
+	store  store.Store
+	logger *logrus.Logger
+
+	mu sync.Mutex
+	// due is when each connector's next scheduled rotation is
+	due map[string]time.Time
+	// digests are the hashes of the watched secrets as last read
+	digests map[string][sha256.Size]byte
+}
+
+// NewRotator creates a rotator reconnecting the connectors of svc in pool
+func NewRotator(svc *Service, pool Reconnector, st store.Store, logger *logrus.Logger) *Rotator {
+	return &Rotator{
+		svc:     svc,
+		pool:    pool,
+		store:   st,
+		logger:  logger,
+		due:     make(map[string]time.Time),
+		digests: make(map[string][sha256.Size]byte),
+	}
+}
+
+// Run checks for due rotations and changed secrets until ctx is cancelled
+func (r *Rotator) Run(ctx context.Context) {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		r.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check rotates the connectors whose secrets changed or whose rotation is
+// due at once, rather than at the next periodic check, such as after the
+// secrets directory moved
+func (r *Rotator) Check(ctx context.Context) {
+	r.check(ctx, time.Now())
+}
+
+// check rotates the connectors whose rotation is due at now or whose
+// secrets changed since the last check
+func (r *Rotator) check(ctx context.Context, now time.Time) {
+	list, err := r.svc.List(ctx)
+	if err != nil {
+		r.logger.Errorf("Failed to list connectors for credential rotation: %v", err)
+		return
+	}
+	for _, conn := range list {
+		if conn.Rotation == nil {
+			continue
+		}
+		if ref, changed := r.secretChanged(conn); changed {
+			r.rotate(ctx, conn, RotationSecret, ref)
+			r.schedule(conn, now)
+			continue
+		}
+		if r.scheduled(conn, now) {
+			r.rotate(ctx, conn, RotationScheduled, "")
+			r.schedule(conn, now)
+		}
+	}
+}
+
+// secretChanged reports the first watched secret of conn whose value
+// changed since it was last read. Secrets are not rotated on first read.
+func (r *Rotator) secretChanged(conn *model.Connector) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := ""
+	for _, ref := range conn.Rotation.Secrets {
+		value, err := secrets.Resolve(ref)
+		if err != nil {
+			r.logger.WithField("connector_id", conn.ID).Warnf("Failed to read secret for credential rotation: %v", err)
+			continue
+		}
+		digest := sha256.Sum256(value)
+		key := conn.ID + "/" + ref
+		last, seen := r.digests[key]
+		r.digests[key] = digest
+		if seen && last != digest && changed == "" {
+			changed = ref
+		}
+	}
+	return changed, changed != ""
+}
+
+// scheduled reports whether the scheduled rotation of conn is due at now.
+// Schedules start when a connector is first seen, so restarts do not
+// rotate every connector at once.
+func (r *Rotator) scheduled(conn *model.Connector, now time.Time) bool {
+	if conn.Rotation.Interval == "" {
+		return false
+	}
+	r.mu.Lock()
+	due, ok := r.due[conn.ID]
+	r.mu.Unlock()
+	if !ok {
+		r.schedule(conn, now)
+		return false
+	}
+	return !now.Before(due)
+}
+
+// schedule sets the next scheduled rotation of conn from now
+func (r *Rotator) schedule(conn *model.Connector, now time.Time) {
+	interval, err := time.ParseDuration(conn.Rotation.Interval)
+	if err != nil || interval <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.due[conn.ID] = now.Add(interval)
+	r.mu.Unlock()
+}
+
+// Notify rotates the connectors reading the secret ref at once, for
+// secrets providers that announce new versions, such as Vault issuing new
+// dynamic credentials. Connectors read it when their rotation policy
+// watches it or their config references it.
+func (r *Rotator) Notify(ctx context.Context, ref string) {
+	list, err := r.svc.List(ctx)
+	if err != nil {
+		r.logger.Errorf("Failed to list connectors for credential rotation: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, conn := range list {
+		if !reads(conn, ref) {
+			continue
+		}
+		r.rotate(ctx, conn, RotationSecret, ref)
+		if conn.Rotation != nil {
+			// Record the new values, so that the periodic check does not
+			// rotate again
+			r.secretChanged(conn)
+			r.schedule(conn, now)
+		}
+	}
+}
+
+// reads reports whether conn reads the secret ref
+func reads(conn *model.Connector, ref string) bool {
+	var refs []string
+	if conn.Rotation != nil {
+		refs = append(refs, conn.Rotation.Secrets...)
+	}
+	for _, value := range conn.Config {
+		refs = append(refs, secrets.References(value)...)
+	}
+	for _, other := range refs {
+		if secrets.SameSecret(other, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rotate rotates the credentials of a connector on demand
+func (r *Rotator) Rotate(ctx context.Context, id string) (*Rotation, error) {
+	conn, err := r.svc.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rot, err := r.rotate(ctx, conn, RotationManual, "")
+	if conn.Rotation != nil {
+		r.schedule(conn, time.Now())
+	}
+	return rot, err
+}
+
+// rotate reconnects conn and records the rotation
+func (r *Rotator) rotate(ctx context.Context, conn *model.Connector, cause, ref string) (*Rotation, error) {
+	rot := &Rotation{ConnectorID: conn.ID, Name: conn.Name, Cause: cause, Secret: ref, RotatedAt: time.Now().UTC()}
+	inUse, err := r.pool.Rotate(ctx, conn.ID)
+	if !inUse {
+		// Nothing to reconnect; the next use connects with the current
+		// credentials
+		return rot, nil
+	}
+
+	logger := r.logger.WithFields(logrus.Fields{"connector_id": conn.ID, "cause": cause})
+	event := EventRotated
+	if err != nil {
+		rot.Error = err.Error()
+		event = EventRotationFailed
+		logger.Errorf("Failed to rotate connector credentials; the previous connection stays in use: %v", err)
+	} else {
+		rot.Reconnected = true
+		logger.Info("Rotated connector credentials")
+	}
+	if recErr := r.store.Update(context.WithoutCancel(ctx), func(tx store.Tx) error {
+		return outbox.Enqueue(tx, event, conn.ID, rot)
+	}); recErr != nil {
+		logger.Errorf("Failed to record credential rotation: %v", recErr)
+	}
+	if err != nil {
+		return rot, fmt.Errorf("failed to rotate connector %s: %w", conn.ID, err)
+	}
+	return rot, nil
+}
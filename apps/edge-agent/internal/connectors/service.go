@@ -0,0 +1,372 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/connector"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/schema"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+var (
+	// ErrNotFound is returned when a connector does not exist
+	ErrNotFound = errors.New("connector not found")
+
+	// ErrOperationNotFound is returned when a connector has no such operation
+	ErrOperationNotFound = errors.New("operation not found")
+)
+
+// ChangeHook is notified after connectors are saved or deleted, e.g. to
+// apply their bandwidth caps
+type ChangeHook interface {
+	ConnectorSaved(conn *model.Connector)
+	ConnectorDeleted(id string)
+}
+
+// Service manages connector definitions in the store
+type Service struct {
+	store  store.Store
+	hooks  []ChangeHook
+	egress connector.EgressGuard
+}
+
+// NewService creates a connector service
+func NewService(st store.Store) *Service {
+	return &Service{store: st}
+}
+
+// SetEgress makes connection tests check the hosts of connectors with g
+// first
+func (s *Service) SetEgress(g connector.EgressGuard) {
+	s.egress = g
+}
+
+// AddHook registers a change hook
+func (s *Service) AddHook(hook ChangeHook) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// saved notifies the hooks of saved connectors
+func (s *Service) saved(list ...*model.Connector) {
+	for _, hook := range s.hooks {
+		for _, conn := range list {
+			hook.ConnectorSaved(conn)
+		}
+	}
+}
+
+// List returns all connectors
+func (s *Service) List(ctx context.Context) ([]*model.Connector, error) {
+	return s.list(ctx, store.ListOptions{})
+}
+
+// Page returns the connectors matching opts, which may filter on the type
+// and name labels, along with how many connectors match it in all
+func (s *Service) Page(ctx context.Context, opts store.ListOptions) ([]*model.Connector, int, error) {
+	total, err := s.store.Count(ctx, store.BucketConnectors, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count connectors: %w", err)
+	}
+	list, err := s.list(ctx, opts)
+	return list, total, err
+}
+
+// list returns the connectors matching opts
+func (s *Service) list(ctx context.Context, opts store.ListOptions) ([]*model.Connector, error) {
+	records, err := s.store.List(ctx, store.BucketConnectors, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connectors: %w", err)
+	}
+	list := make([]*model.Connector, 0, len(records))
+	for _, rec := range records {
+		conn, err := decode(rec)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, conn)
+	}
+	return list, nil
+}
+
+// Get returns the connector with the given ID
+func (s *Service) Get(ctx context.Context, id string) (*model.Connector, error) {
+	rec, err := s.store.Get(ctx, store.BucketConnectors, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connector %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// Create validates and stores a new connector
+func (s *Service) Create(ctx context.Context, conn *model.Connector) error {
+	return s.CreateAll(ctx, []*model.Connector{conn})
+}
+
+// CreateAll validates and stores several new connectors atomically
+func (s *Service) CreateAll(ctx context.Context, list []*model.Connector) error {
+	for _, conn := range list {
+		if err := Validate(conn); err != nil {
+			return err
+		}
+	}
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		for _, conn := range list {
+			conn.ID = ids.New("conn")
+			conn.CreatedAt = time.Time{}
+			if err := save(tx, conn, "connector.created"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.saved(list...)
+	return nil
+}
+
+// Validate checks a connector's definition and, for types with an
+// implementation, its configuration
+func Validate(conn *model.Connector) error {
+	if err := conn.Validate(); err != nil {
+		return err
+	}
+	v := &model.ValidationError{}
+	if conn.Rotation != nil {
+		for i, ref := range conn.Rotation.Secrets {
+			if err := secrets.Validate(ref); err != nil {
+				v.Add(fmt.Sprintf("rotation.secrets[%d]", i), model.ProblemInvalid, "%v", err)
+			}
+		}
+	}
+	keys := make([]string, 0, len(conn.Config))
+	for key := range conn.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := secrets.Check(conn.Config[key]); err != nil {
+			v.Add("config."+key, model.ProblemInvalid, "%v", err)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return err
+	}
+	return connector.Validate(conn)
+}
+
+// Test connects to the connector's system and tests the connection
+func (s *Service) Test(ctx context.Context, id string) error {
+	conn, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if s.egress != nil {
+		if err := s.egress.CheckConnector(ctx, conn); err != nil {
+			return err
+		}
+	}
+	return connector.Test(ctx, conn)
+}
+
+// ValidatePayload checks payload against the message schema of one of the
+// connector's operations, returning a *model.ValidationError listing every
+// mismatch. Operations without a schema accept any payload.
+func (s *Service) ValidatePayload(ctx context.Context, id, operationID string, payload interface{}) error {
+	conn, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	op, ok := conn.Operation(operationID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrOperationNotFound, operationID)
+	}
+	if op.Message == nil {
+		return nil
+	}
+	if problems := schema.Validate(op.Message, payload); len(problems) > 0 {
+		return &model.ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Update validates and replaces an existing connector
+func (s *Service) Update(ctx context.Context, conn *model.Connector) error {
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		rec, err := tx.Get(store.BucketConnectors, conn.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get connector %s: %w", conn.ID, err)
+		}
+		if err := replace(conn, rec); err != nil {
+			return err
+		}
+		return save(tx, conn, "connector.updated")
+	})
+	if err != nil {
+		return err
+	}
+	s.saved(conn)
+	return nil
+}
+
+// replace prepares conn to replace the stored connector rec, keeping the
+// stored values of the secret fields the client left redacted, and
+// validates it
+func replace(conn *model.Connector, rec *store.Record) error {
+	stored, err := decode(rec)
+	if err != nil {
+		return err
+	}
+	if conn.Config != nil {
+		unredact(conn.Config, stored.Config)
+	}
+	conn.CreatedAt = rec.CreatedAt
+	return Validate(conn)
+}
+
+// Upsert validates and stores conn under its ID, creating it or replacing
+// the existing connector, and reports whether it was created
+func (s *Service) Upsert(ctx context.Context, conn *model.Connector) (bool, error) {
+	created := false
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		created = false
+		rec, err := tx.Get(store.BucketConnectors, conn.ID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			if err := Validate(conn); err != nil {
+				return err
+			}
+			created = true
+			conn.CreatedAt = time.Time{}
+			return save(tx, conn, "connector.created")
+		case err != nil:
+			return fmt.Errorf("failed to get connector %s: %w", conn.ID, err)
+		}
+		if err := replace(conn, rec); err != nil {
+			return err
+		}
+		return save(tx, conn, "connector.updated")
+	})
+	if err != nil {
+		return false, err
+	}
+	s.saved(conn)
+	return created, nil
+}
+
+// Delete removes a connector
+func (s *Service) Delete(ctx context.Context, id string) error {
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		err := tx.Delete(store.BucketConnectors, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete connector %s: %w", id, err)
+		}
+		return outbox.Enqueue(tx, "connector.deleted", id, map[string]string{"id": id})
+	})
+	if err != nil {
+		return err
+	}
+	for _, hook := range s.hooks {
+		hook.ConnectorDeleted(id)
+	}
+	return nil
+}
+
+// PutTx stores a validated connector within the caller's tx, creating it or
+// replacing the connector with its ID, for connectors that commit along
+// with other records. Call Committed once tx commits.
+func PutTx(tx store.Tx, conn *model.Connector) error {
+	rec, err := tx.Get(store.BucketConnectors, conn.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		conn.CreatedAt = time.Time{}
+		return save(tx, conn, "connector.created")
+	case err != nil:
+		return fmt.Errorf("failed to get connector %s: %w", conn.ID, err)
+	}
+	conn.CreatedAt = rec.CreatedAt
+	return save(tx, conn, "connector.updated")
+}
+
+// DeleteTx removes a connector within the caller's tx, if it exists. Call
+// Committed once tx commits.
+func DeleteTx(tx store.Tx, id string) error {
+	err := tx.Delete(store.BucketConnectors, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete connector %s: %w", id, err)
+	}
+	return outbox.Enqueue(tx, "connector.deleted", id, map[string]string{"id": id})
+}
+
+// Committed notifies the hooks of connectors saved and deleted with PutTx
+// and DeleteTx
+func (s *Service) Committed(saved []*model.Connector, deleted []string) {
+	s.saved(saved...)
+	for _, hook := range s.hooks {
+		for _, id := range deleted {
+			hook.ConnectorDeleted(id)
+		}
+	}
+}
+
+// save writes conn and an outbox event of eventType within tx
+func save(tx store.Tx, conn *model.Connector, eventType string) error {
+	now := time.Now().UTC()
+	if conn.CreatedAt.IsZero() {
+		conn.CreatedAt = now
+	}
+	conn.UpdatedAt = now
+
+	value, err := json.Marshal(conn)
+	if err != nil {
+		return fmt.Errorf("failed to encode connector: %w", err)
+	}
+	rec := &store.Record{
+		Key:       conn.ID,
+		Value:     value,
+		Labels:    map[string]string{"type": conn.Type, "name": conn.Name},
+		CreatedAt: conn.CreatedAt,
+	}
+	if err := tx.Put(store.BucketConnectors, rec); err != nil {
+		return fmt.Errorf("failed to store connector: %w", err)
+	}
+	// Events carry a summary only; connector config may hold credentials
+	return outbox.Enqueue(tx, eventType, conn.ID, map[string]string{
+		"id":   conn.ID,
+		"name": conn.Name,
+		"type": conn.Type,
+	})
+}
+
+// decode unmarshals a stored connector
+func decode(rec *store.Record) (*model.Connector, error) {
+	var conn model.Connector
+	if err := json.Unmarshal(rec.Value, &conn); err != nil {
+		return nil, fmt.Errorf("failed to decode connector %s: %w", rec.Key, err)
+	}
+	conn.CreatedAt = rec.CreatedAt
+	conn.UpdatedAt = rec.UpdatedAt
+	return &conn, nil
+}
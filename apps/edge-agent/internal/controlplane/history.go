@@ -0,0 +1,292 @@
+package controlplane
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/netlimit"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// HistoryBucket journals the execution changes not yet acknowledged by the
+// control plane, keyed by their zero-padded sequence number
+const HistoryBucket = "controlplane.history"
+
+// Keys of the history sequence numbers in Bucket
+const (
+	nextSeqKey  = "history.next"
+	ackedSeqKey = "history.acked"
+)
+
+// Change is one state change of an execution. Steps lists only the steps
+// whose status changed since the previous change of the execution sent in
+// this run of the agent, so after a restart the first change of an
+// execution lists all of them again.
+type Change struct {
+	Seq         uint64       `json:"seq"`
+	ExecutionID string       `json:"executionId"`
+	FlowID      string       `json:"flowId"`
+	FlowVersion int          `json:"flowVersion,omitempty"`
+	Status      string       `json:"status"`
+	QueuedAt    time.Time    `json:"queuedAt"`
+	StartTime   *time.Time   `json:"startTime,omitempty"`
+	EndTime     *time.Time   `json:"endTime,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Steps       []StepChange `json:"steps,omitempty"`
+	ChangedAt   time.Time    `json:"changedAt"`
+}
+
+// StepChange is the new status of a step
+type StepChange struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HistoryBatch is the body of a history upload, gzip-compressed. AckedSeq
+// is the last change the agent saw acknowledged, so the control plane can
+// tell a batch it already has from a gap.
+type HistoryBatch struct {
+	AgentID  string    `json:"agentId"`
+	AckedSeq uint64    `json:"ackedSeq"`
+	Changes  []*Change `json:"changes"`
+}
+
+// HistoryAck acknowledges the changes up to and including AckedSeq. The
+// control plane may acknowledge fewer changes than it was sent; the rest
+// are sent again.
+type HistoryAck struct {
+	AckedSeq uint64 `json:"ackedSeq"`
+}
+
+// History journals the state changes of executions and ships them to the
+// control plane in order. It implements executions.Journal.
+type History struct {
+	cfg    config.ControlPlaneConfig
+	url    string
+	client *http.Client
+	store  store.Store
+	logger *logrus.Logger
+
+	mu sync.Mutex
+	// steps are the step statuses last journaled for each unfinished
+	// execution
+	steps map[string]map[string]string
+}
+
+// NewHistory creates the history journal of the agent cfg.AgentID, which
+// must be set, uploading within the budget of uplink
+func NewHistory(cfg config.ControlPlaneConfig, st store.Store, uplink *netlimit.Link, logger *logrus.Logger) *History {
+	return &History{
+		cfg:    cfg,
+		url:    strings.TrimSuffix(cfg.URL, "/") + "/api/v1/agents/" + url.PathEscape(cfg.AgentID) + "/history",
+		client: &http.Client{Timeout: time.Minute, Transport: uplink.Transport(nil)},
+		store:  st,
+		logger: logger,
+		steps:  make(map[string]map[string]string),
+	}
+}
+
+// Change implements executions.Journal
+func (h *History) Change(exec *model.Execution) func(tx store.Tx) error {
+	c := &Change{
+		ExecutionID: exec.ID,
+		FlowID:      exec.FlowID,
+		FlowVersion: exec.FlowVersion,
+		Status:      exec.Status,
+		QueuedAt:    exec.QueuedAt,
+		StartTime:   exec.StartTime,
+		EndTime:     exec.EndTime,
+		Error:       exec.Error,
+		ChangedAt:   time.Now().UTC(),
+	}
+
+	h.mu.Lock()
+	last := h.steps[exec.ID]
+	if last == nil {
+		last = make(map[string]string)
+		h.steps[exec.ID] = last
+	}
+	for _, step := range exec.Steps {
+		if last[step.ID] != step.Status {
+			last[step.ID] = step.Status
+			c.Steps = append(c.Steps, StepChange{ID: step.ID, Status: step.Status, Error: step.Error})
+		}
+	}
+	if exec.Finished() {
+		delete(h.steps, exec.ID)
+	}
+	h.mu.Unlock()
+
+	return func(tx store.Tx) error {
+		seq, err := readSeq(tx, nextSeqKey)
+		if err != nil {
+			return err
+		}
+		seq++
+		c.Seq = seq
+		value, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to encode execution change: %w", err)
+		}
+		if err := tx.Put(HistoryBucket, &store.Record{Key: seqKey(seq), Value: value, CreatedAt: c.ChangedAt}); err != nil {
+			return fmt.Errorf("failed to journal execution change: %w", err)
+		}
+		return writeSeq(tx, nextSeqKey, seq)
+	}
+}
+
+// Run ships the journaled changes every interval until ctx is cancelled
+func (h *History) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.cfg.History.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Send full batches back to back to catch up after an outage
+		for {
+			n, err := h.ship(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					h.logger.Warnf("Failed to sync execution history: %v", err)
+				}
+				break
+			}
+			if n < h.cfg.History.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// ship sends the oldest batch of unacknowledged changes and drops those the
+// control plane acknowledges, returning how many it sent when it
+// acknowledged them all
+func (h *History) ship(ctx context.Context) (int, error) {
+	records, err := h.store.List(ctx, HistoryBucket, store.ListOptions{Sort: store.SortKey, Limit: h.cfg.History.BatchSize})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list execution changes: %w", err)
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	var acked uint64
+	if rec, err := h.store.Get(ctx, Bucket, ackedSeqKey); err == nil {
+		acked, _ = strconv.ParseUint(string(rec.Value), 10, 64)
+	}
+	b := &HistoryBatch{AgentID: h.cfg.AgentID, AckedSeq: acked, Changes: make([]*Change, 0, len(records))}
+	for _, rec := range records {
+		var c Change
+		if err := json.Unmarshal(rec.Value, &c); err != nil {
+			return 0, fmt.Errorf("failed to decode execution change %s: %w", rec.Key, err)
+		}
+		b.Changes = append(b.Changes, &c)
+	}
+
+	ack, err := h.send(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.acknowledge(ctx, records, ack.AckedSeq); err != nil {
+		return 0, err
+	}
+	if ack.AckedSeq < b.Changes[len(b.Changes)-1].Seq {
+		// Partly acknowledged: send the rest at the next interval
+		return 0, nil
+	}
+	return len(records), nil
+}
+
+// send uploads a batch and returns the control plane's acknowledgement
+func (h *History) send(ctx context.Context, b *HistoryBatch) (*HistoryAck, error) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return nil, fmt.Errorf("failed to encode execution changes: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress execution changes: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept", "application/json")
+	if h.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.cfg.Token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp)
+	}
+	var ack HistoryAck
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return nil, fmt.Errorf("failed to decode history acknowledgement: %w", err)
+	}
+	return &ack, nil
+}
+
+// acknowledge drops the sent changes up to acked and records it
+func (h *History) acknowledge(ctx context.Context, sent []*store.Record, acked uint64) error {
+	return h.store.Update(ctx, func(tx store.Tx) error {
+		for _, rec := range sent {
+			seq, err := strconv.ParseUint(rec.Key, 10, 64)
+			if err != nil || seq > acked {
+				continue
+			}
+			if err := tx.Delete(HistoryBucket, rec.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+		prev, err := readSeq(tx, ackedSeqKey)
+		if err != nil || acked <= prev {
+			return err
+		}
+		return writeSeq(tx, ackedSeqKey, acked)
+	})
+}
+
+// seqKey is the journal key of a sequence number, sorting in order
+func seqKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// readSeq reads a sequence number of Bucket, zero when unset
+func readSeq(tx store.Tx, key string) (uint64, error) {
+	rec, err := tx.Get(Bucket, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return strconv.ParseUint(string(rec.Value), 10, 64)
+}
+
+// writeSeq writes a sequence number of Bucket
+func writeSeq(tx store.Tx, key string, seq uint64) error {
+	return tx.Put(Bucket, &store.Record{Key: key, Value: []byte(strconv.FormatUint(seq, 10)), CreatedAt: time.Now().UTC()})
+}
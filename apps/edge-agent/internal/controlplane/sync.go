@@ -0,0 +1,341 @@
+package controlplane
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/connectors"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/netlimit"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Bucket holds the state of the deployment last applied
+const Bucket = "controlplane"
+
+// stateKey is the key of the deployment state
+const stateKey = "deployment"
+
+// Deployment states reported to the control plane
+const (
+	StateApplied = "applied"
+	StateFailed  = "failed"
+)
+
+// Deployment is the set of flows and connectors the control plane assigns
+// to an agent. Flows are applied and activated; flows and connectors of
+// earlier deployments that it no longer lists are deleted.
+type Deployment struct {
+	Revision   string             `json:"revision"`
+	Flows      []*model.Flow      `json:"flows"`
+	Connectors []*model.Connector `json:"connectors"`
+}
+
+// Status reports the outcome of applying a deployment
+type Status struct {
+	AgentID    string       `json:"agentId"`
+	Revision   string       `json:"revision"`
+	State      string       `json:"state"`
+	Error      string       `json:"error,omitempty"`
+	Flows      []FlowStatus `json:"flows,omitempty"`
+	ReportedAt time.Time    `json:"reportedAt"`
+}
+
+// FlowStatus is the state of a deployed flow
+type FlowStatus struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+}
+
+// state is what the agent keeps of the deployment last applied: the
+// digests of the definitions it deployed, so that unchanged ones are not
+// re-applied and removed ones can be deleted
+type state struct {
+	Revision   string            `json:"revision"`
+	Flows      map[string]string `json:"flows"`
+	Connectors map[string]string `json:"connectors"`
+}
+
+// Syncer keeps the agent's flows and connectors in line with the
+// deployment the control plane assigns it. It long-polls for new
+// revisions, applies each one atomically and reports its status back.
+type Syncer struct {
+	cfg        config.ControlPlaneConfig
+	base       string
+	client     *http.Client
+	store      store.Store
+	flows      *flows.Service
+	connectors *connectors.Service
+	logger     *logrus.Logger
+}
+
+// NewSyncer creates a syncer for the agent cfg.AgentID, which must be set,
+// uploading within the budget of uplink
+func NewSyncer(cfg config.ControlPlaneConfig, st store.Store, flowSvc *flows.Service, connectorSvc *connectors.Service, uplink *netlimit.Link, logger *logrus.Logger) *Syncer {
+	return &Syncer{
+		cfg:  cfg,
+		base: strings.TrimSuffix(cfg.URL, "/") + "/api/v1/agents/" + url.PathEscape(cfg.AgentID) + "/deployment",
+		// Polls are held open for up to the poll timeout
+		client:     &http.Client{Timeout: time.Duration(cfg.PollTimeout)*time.Second + 30*time.Second, Transport: uplink.Transport(nil)},
+		store:      st,
+		flows:      flowSvc,
+		connectors: connectorSvc,
+		logger:     logger,
+	}
+}
+
+// Run polls for and applies deployments until ctx is cancelled
+func (s *Syncer) Run(ctx context.Context) {
+	retry := time.Duration(s.cfg.RetryInterval) * time.Second
+	for {
+		if err := s.sync(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warnf("Control plane sync failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retry):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// sync polls once and applies the deployment received, if any
+func (s *Syncer) sync(ctx context.Context) error {
+	st, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	d, err := s.poll(ctx, st.Revision)
+	if err != nil || d == nil {
+		return err
+	}
+
+	status := &Status{AgentID: s.cfg.AgentID, Revision: d.Revision, State: StateApplied}
+	if err := s.apply(ctx, d, st); err != nil {
+		s.logger.WithField("revision", d.Revision).Errorf("Failed to apply deployment: %v", err)
+		status.State = StateFailed
+		status.Error = err.Error()
+	} else {
+		s.logger.WithField("revision", d.Revision).Infof("Applied deployment of %d flows and %d connectors", len(d.Flows), len(d.Connectors))
+	}
+	for _, f := range d.Flows {
+		current, err := s.flows.Get(ctx, f.ID)
+		if err != nil {
+			continue
+		}
+		status.Flows = append(status.Flows, FlowStatus{ID: current.ID, Version: current.Version, Status: current.Status})
+	}
+	status.ReportedAt = time.Now().UTC()
+	if err := s.report(ctx, status); err != nil {
+		return err
+	}
+	if status.State == StateFailed {
+		// Keep the revision applied last, so the failed one is not
+		// delivered again until the control plane revises it
+		return s.save(ctx, &state{Revision: d.Revision, Flows: st.Flows, Connectors: st.Connectors})
+	}
+	return nil
+}
+
+// poll waits for a deployment newer than revision, returning nil when none
+// came within the poll timeout
+func (s *Syncer) poll(ctx context.Context, revision string) (*Deployment, error) {
+	q := url.Values{"wait": {strconv.Itoa(s.cfg.PollTimeout)}}
+	if revision != "" {
+		q.Set("revision", revision)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified, http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, responseError(resp)
+	}
+	var d Deployment
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment: %w", err)
+	}
+	if d.Revision == "" {
+		return nil, errors.New("deployment has no revision")
+	}
+	return &d, nil
+}
+
+// report sends the status of a deployment to the control plane
+func (s *Syncer) report(ctx context.Context, status *Status) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.base+"/status", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to report deployment status: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to report deployment status: %w", responseError(resp))
+	}
+	return nil
+}
+
+func (s *Syncer) do(req *http.Request) (*http.Response, error) {
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+	return s.client.Do(req)
+}
+
+// responseError describes an unexpected response
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("control plane answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// apply brings the local flows and connectors in line with d, in one
+// transaction along with the new state. Definitions unchanged since the
+// last deployment are left alone, so the triggers of their flows keep
+// running.
+func (s *Syncer) apply(ctx context.Context, d *Deployment, prev *state) error {
+	next := &state{Revision: d.Revision, Flows: make(map[string]string), Connectors: make(map[string]string)}
+
+	var saved []*model.Connector
+	for _, conn := range d.Connectors {
+		if conn.ID == "" {
+			return fmt.Errorf("connector %q has no ID", conn.Name)
+		}
+		digest, err := digest(conn)
+		if err != nil {
+			return err
+		}
+		next.Connectors[conn.ID] = digest
+		if prev.Connectors[conn.ID] == digest {
+			continue
+		}
+		if err := connectors.Validate(conn); err != nil {
+			return fmt.Errorf("connector %s: %w", conn.ID, err)
+		}
+		saved = append(saved, conn)
+	}
+	var removed []string
+	for id := range prev.Connectors {
+		if _, ok := next.Connectors[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+
+	b := flows.Batch{Connectors: saved}
+	for _, flow := range d.Flows {
+		if flow.ID == "" {
+			return fmt.Errorf("flow %q has no ID", flow.Name)
+		}
+		digest, err := digest(flow)
+		if err != nil {
+			return err
+		}
+		next.Flows[flow.ID] = digest
+		if prev.Flows[flow.ID] != digest {
+			b.Apply = append(b.Apply, flow)
+		}
+	}
+	for id := range prev.Flows {
+		if _, ok := next.Flows[id]; !ok {
+			b.Delete = append(b.Delete, id)
+		}
+	}
+	b.Stage = func(tx store.Tx) error {
+		for _, conn := range saved {
+			if err := connectors.PutTx(tx, conn); err != nil {
+				return err
+			}
+		}
+		for _, id := range removed {
+			if err := connectors.DeleteTx(tx, id); err != nil {
+				return err
+			}
+		}
+		return put(tx, next)
+	}
+	if err := s.flows.ApplyBatch(ctx, b); err != nil {
+		return err
+	}
+	s.connectors.Committed(saved, removed)
+	return nil
+}
+
+// digest hashes a definition as the control plane sent it
+func digest(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode definition: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// load reads the state of the deployment last applied
+func (s *Syncer) load(ctx context.Context) (*state, error) {
+	rec, err := s.store.Get(ctx, Bucket, stateKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(rec.Value, &st); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment state: %w", err)
+	}
+	return &st, nil
+}
+
+// save writes the deployment state
+func (s *Syncer) save(ctx context.Context, st *state) error {
+	return s.store.Update(ctx, func(tx store.Tx) error {
+		return put(tx, st)
+	})
+}
+
+// put writes the deployment state within tx
+func put(tx store.Tx, st *state) error {
+	value, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment state: %w", err)
+	}
+	if err := tx.Put(Bucket, &store.Record{Key: stateKey, Value: value, CreatedAt: time.Now().UTC()}); err != nil {
+		return fmt.Errorf("failed to store deployment state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+// Package crypto encrypts store records at rest. Values are sealed with
+// AES-256-GCM under data keys generated by the agent; each data key is
+// wrapped by a key-encryption key, a local key from config or an AWS KMS
+// key, and stored with the records it sealed (envelope encryption).
+package crypto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+const (
+	// envelopeVersion is the first byte of every envelope
+	envelopeVersion = 1
+
+	// dataKeyLifetime and dataKeyUses bound how long a data key seals new
+	// records before another one is generated
+	dataKeyLifetime = time.Hour
+	dataKeyUses     = 1 << 20
+
+	// maxOpenKeys bounds the unwrapped data keys kept for reading
+	maxOpenKeys = 256
+)
+
+// ErrUnknownKey is returned when an envelope was sealed under a
+// key-encryption key that is not configured
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyWrapper wraps data keys with a key-encryption key
+type KeyWrapper interface {
+	// ID names the key-encryption key in envelopes
+	ID() string
+	Wrap(ctx context.Context, dek []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Keyring seals values under a data key wrapped by its primary key and
+// opens values sealed under any of its keys
+type Keyring struct {
+	primary  KeyWrapper
+	wrappers map[string]KeyWrapper
+	kms      KeyWrapper
+
+	mu     sync.Mutex
+	dek    *dataKey
+	opened map[string]cipher.AEAD
+}
+
+// dataKey is the data key new values are sealed under
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+	created time.Time
+	uses    int
+}
+
+// NewKeyring creates a keyring from the encryption settings of the store
+func NewKeyring(cfg config.EncryptionConfig) (*Keyring, error) {
+	k := &Keyring{
+		wrappers: make(map[string]KeyWrapper, len(cfg.Keys)),
+		opened:   make(map[string]cipher.AEAD),
+	}
+	for _, key := range cfg.Keys {
+		w, err := newLocalKey(key)
+		if err != nil {
+			return nil, err
+		}
+		k.wrappers[w.ID()] = w
+	}
+	if cfg.KMS.KeyID != "" {
+		w, err := newKMS(cfg.KMS)
+		if err != nil {
+			return nil, err
+		}
+		k.kms = w
+		k.wrappers[w.ID()] = w
+	}
+	for id := range k.wrappers {
+		// Envelopes hold the ID behind a one-byte length
+		if len(id) > 255 {
+			return nil, fmt.Errorf("encryption key id %.32s... is longer than 255 bytes", id)
+		}
+	}
+
+	if cfg.Primary == config.EncryptionKMS {
+		k.primary = k.kms
+	} else {
+		k.primary = k.wrappers[cfg.Primary]
+	}
+	if k.primary == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, cfg.Primary)
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key new data keys are wrapped by
+func (k *Keyring) Primary() string {
+	return k.primary.ID()
+}
+
+// Seal encrypts plaintext, binding it to aad, and returns the envelope
+func (k *Keyring) Seal(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	dek, err := k.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, dek.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	id := k.primary.ID()
+	env := make([]byte, 0, 4+len(id)+len(dek.wrapped)+len(nonce)+len(plaintext)+dek.aead.Overhead())
+	env = append(env, envelopeVersion, byte(len(id)))
+	env = append(env, id...)
+	env = binary.BigEndian.AppendUint16(env, uint16(len(dek.wrapped)))
+	env = append(env, dek.wrapped...)
+	env = append(env, nonce...)
+	return dek.aead.Seal(env, nonce, plaintext, aad), nil
+}
+
+// Open decrypts an envelope sealed with aad
+func (k *Keyring) Open(ctx context.Context, env, aad []byte) ([]byte, error) {
+	e, err := parseEnvelope(env)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := k.unwrap(ctx, e.keyID, e.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.sealed) < aead.NonceSize() {
+		return nil, errors.New("truncated envelope")
+	}
+	nonce, sealed := e.sealed[:aead.NonceSize()], e.sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, aad)
+}
+
+// KeyID returns the ID of the key an envelope's data key is wrapped by
+func KeyID(env []byte) (string, error) {
+	e, err := parseEnvelope(env)
+	if err != nil {
+		return "", err
+	}
+	return e.keyID, nil
+}
+
+// current returns the data key to seal with, generating one when there is
+// none yet or the current one is used up. The new key is wrapped without
+// holding mu, so that a slow KMS does not hold up unwrapping, and installed
+// only if no other seal replaced the key meanwhile.
+func (k *Keyring) current(ctx context.Context) (*dataKey, error) {
+	k.mu.Lock()
+	if k.usable() {
+		k.dek.uses++
+		dek := k.dek
+		k.mu.Unlock()
+		return dek, nil
+	}
+	stale := k.dek
+	k.mu.Unlock()
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(raw)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := k.primary.Wrap(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with %s: %w", k.primary.ID(), err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.dek != stale && k.usable() {
+		k.dek.uses++
+		return k.dek, nil
+	}
+	k.dek = &dataKey{aead: aead, wrapped: wrapped, created: time.Now(), uses: 1}
+	k.remember(k.primary.ID(), wrapped, aead)
+	return k.dek, nil
+}
+
+// usable reports whether the current data key may seal another value. The
+// caller holds mu.
+func (k *Keyring) usable() bool {
+	return k.dek != nil && k.dek.uses < dataKeyUses && time.Since(k.dek.created) < dataKeyLifetime
+}
+
+// unwrap returns the data key wrapped under keyID, unwrapping it unless
+// it was seen before
+func (k *Keyring) unwrap(ctx context.Context, keyID string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := keyID + "\x00" + string(wrapped)
+	k.mu.Lock()
+	aead, ok := k.opened[cacheKey]
+	k.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	w := k.wrappers[keyID]
+	// KMS decrypts data keys wrapped by any key the credentials may use,
+	// so records survive a change of kms.key_id until rotated
+	if w == nil && k.kms != nil && strings.HasPrefix(keyID, config.EncryptionKMS+":") {
+		w = k.kms
+	}
+	if w == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	raw, err := w.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %s: %w", keyID, err)
+	}
+	if aead, err = newGCM(raw); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	k.remember(keyID, wrapped, aead)
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// remember caches an unwrapped data key. Callers must hold k.mu.
+func (k *Keyring) remember(keyID string, wrapped []byte, aead cipher.AEAD) {
+	if len(k.opened) >= maxOpenKeys {
+		k.opened = make(map[string]cipher.AEAD)
+	}
+	k.opened[keyID+"\x00"+string(wrapped)] = aead
+}
+
+// envelope is a parsed sealed value:
+//
+//	version | len(keyID) | keyID | uint16 len(wrapped) | wrapped | nonce | ciphertext
+type envelope struct {
+	keyID   string
+	wrapped []byte
+	sealed  []byte
+}
+
+func parseEnvelope(env []byte) (envelope, error) {
+	if len(env) < 2 || env[0] != envelopeVersion {
+		return envelope{}, errors.New("not an encrypted value")
+	}
+	n := int(env[1])
+	rest := env[2:]
+	if len(rest) < n+2 {
+		return envelope{}, errors.New("truncated envelope")
+	}
+	e := envelope{keyID: string(rest[:n])}
+	rest = rest[n:]
+	m := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < m {
+		return envelope{}, errors.New("truncated envelope")
+	}
+	e.wrapped, e.sealed = rest[:m], rest[m:]
+	return e, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// localKey wraps data keys with AES-256-GCM under a key from config
+type localKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newLocalKey(cfg config.EncryptionKey) (*localKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.Key))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("encryption key %s must be 32 bytes, base64 encoded", cfg.ID)
+	}
+	aead, err := newGCM(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &localKey{id: cfg.ID, aead: aead}, nil
+}
+
+func (l *localKey) ID() string {
+	return l.id
+}
+
+func (l *localKey) Wrap(ctx context.Context, dek []byte) ([]byte, error) {
+	nonce := make([]byte, l.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return l.aead.Seal(nonce, nonce, dek, []byte(l.id)), nil
+}
+
+func (l *localKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < l.aead.NonceSize() {
+		return nil, errors.New("truncated data key")
+	}
+	nonce := wrapped[:l.aead.NonceSize()]
+	return l.aead.Open(nil, nonce, wrapped[len(nonce):], []byte(l.id))
+}
@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/awssig"
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// kms wraps data keys with a key in AWS KMS. The data keys themselves are
+// generated locally, so KMS only sees the 32 bytes it wraps.
+type kms struct {
+	keyID    string
+	region   string
+	endpoint string
+	client   *http.Client
+}
+
+func newKMS(cfg config.KMSConfig) (*kms, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", cfg.Region)
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid storage encryption kms endpoint: %w", err)
+	}
+	return &kms{
+		keyID:    cfg.KeyID,
+		region:   cfg.Region,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (k *kms) ID() string {
+	return config.EncryptionKMS + ":" + k.keyID
+}
+
+func (k *kms) Wrap(ctx context.Context, dek []byte) ([]byte, error) {
+	var out struct {
+		CiphertextBlob []byte `json:"CiphertextBlob"`
+	}
+	in := map[string]interface{}{"KeyId": k.keyID, "Plaintext": dek}
+	if err := k.call(ctx, "TrentService.Encrypt", in, &out); err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+// Unwrap leaves out the key ID: KMS finds the key in the blob, which may
+// be one other than the configured key
+func (k *kms) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	in := map[string]interface{}{"CiphertextBlob": wrapped}
+	if err := k.call(ctx, "TrentService.Decrypt", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// call posts a KMS JSON API action
+func (k *kms) call(ctx context.Context, target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	creds, err := awssig.FromEnv()
+	if err != nil {
+		return err
+	}
+	awssig.Sign(req, body, creds, k.region, "kms")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		return fmt.Errorf("kms returned %s: %s %s", resp.Status, failure.Type, failure.Message)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid kms response: %w", err)
+	}
+	return nil
+}
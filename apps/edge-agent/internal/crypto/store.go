@@ -0,0 +1,258 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// EncodingAESGCM marks a record whose Value is an envelope. The encoding
+// the value had before it was sealed, such as gzip, follows after a "+".
+const EncodingAESGCM = "aes-gcm"
+
+// rotateBatch is the number of records Rotate reads at a time
+const rotateBatch = 500
+
+// Store encrypts the values of records written to its buckets and decrypts
+// those read from any bucket, so records written before encryption was
+// enabled stay readable. Each value is bound to its bucket and key, which
+// stay in the clear along with the labels and timestamps.
+type Store struct {
+	store.Store
+
+	keys    *Keyring
+	buckets map[string]bool
+}
+
+// NewStore wraps st, encrypting the records of buckets with keys
+func NewStore(st store.Store, keys *Keyring, buckets []string) *Store {
+	s := &Store{Store: st, keys: keys, buckets: make(map[string]bool, len(buckets))}
+	for _, bucket := range buckets {
+		// The schema version must stay readable without the keys
+		if bucket != store.BucketMeta {
+			s.buckets[bucket] = true
+		}
+	}
+	return s
+}
+
+// Get implements store.Store
+func (s *Store) Get(ctx context.Context, bucket, key string) (*store.Record, error) {
+	rec, err := s.Store.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, bucket, rec)
+}
+
+// Put implements store.Store
+func (s *Store) Put(ctx context.Context, bucket string, rec *store.Record) error {
+	sealed, err := s.seal(ctx, bucket, rec)
+	if err != nil {
+		return err
+	}
+	err = s.Store.Put(ctx, bucket, sealed)
+	rec.CreatedAt, rec.UpdatedAt = sealed.CreatedAt, sealed.UpdatedAt
+	return err
+}
+
+// List implements store.Store
+func (s *Store) List(ctx context.Context, bucket string, opts store.ListOptions) ([]*store.Record, error) {
+	records, err := s.Store.List(ctx, bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, rec := range records {
+		if records[i], err = s.open(ctx, bucket, rec); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Update implements store.Store, sealing the records the transaction
+// writes and opening those it reads
+func (s *Store) Update(ctx context.Context, fn func(tx store.Tx) error) error {
+	var written []sealedWrite
+	err := s.Store.Update(ctx, func(tx store.Tx) error {
+		written = written[:0]
+		return fn(&sealingTx{Tx: tx, s: s, ctx: ctx, written: &written})
+	})
+	if err == nil {
+		// Stores stamp the timestamps of committed records on the sealed copies
+		for _, w := range written {
+			w.orig.CreatedAt, w.orig.UpdatedAt = w.sealed.CreatedAt, w.sealed.UpdatedAt
+		}
+	}
+	return err
+}
+
+// Unwrap returns the underlying store
+func (s *Store) Unwrap() store.Store {
+	return s.Store
+}
+
+// Rotate re-encrypts every record not sealed under the primary key, and
+// decrypts those in buckets no longer encrypted, returning the number of
+// records rewritten per bucket. Rewritten records get a new updatedAt.
+func (s *Store) Rotate(ctx context.Context) (map[string]int, error) {
+	lister, ok := store.As[store.BucketLister](s.Store)
+	if !ok {
+		return nil, errors.New("the store cannot list its buckets")
+	}
+	names, err := lister.Buckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, bucket := range names {
+		if bucket == store.BucketMeta {
+			continue
+		}
+		// Rewriting never changes keys, so key order pages stably
+		for offset := 0; ; offset += rotateBatch {
+			records, err := s.Store.List(ctx, bucket, store.ListOptions{Sort: store.SortKey, Offset: offset, Limit: rotateBatch})
+			if err != nil {
+				return counts, fmt.Errorf("failed to list %s: %w", bucket, err)
+			}
+			for _, rec := range records {
+				if !s.stale(bucket, rec) {
+					continue
+				}
+				rewritten, err := s.rewrite(ctx, bucket, rec.Key)
+				if err != nil {
+					return counts, err
+				}
+				if rewritten {
+					counts[bucket]++
+				}
+			}
+			if len(records) < rotateBatch {
+				break
+			}
+		}
+	}
+	return counts, nil
+}
+
+// rewrite re-reads a record in a transaction and, if it is still stale,
+// writes it back sealed under the primary key
+func (s *Store) rewrite(ctx context.Context, bucket, key string) (bool, error) {
+	rewritten := false
+	err := s.Store.Update(ctx, func(tx store.Tx) error {
+		rec, err := tx.Get(bucket, key)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		if err != nil || !s.stale(bucket, rec) {
+			return err
+		}
+		if rec, err = s.open(ctx, bucket, rec); err != nil {
+			return err
+		}
+		if rec, err = s.seal(ctx, bucket, rec); err != nil {
+			return err
+		}
+		rewritten = true
+		return tx.Put(bucket, rec)
+	})
+	return rewritten, err
+}
+
+// stale reports whether rec, as stored, is not sealed the way its bucket
+// now asks for
+func (s *Store) stale(bucket string, rec *store.Record) bool {
+	if _, encrypted := innerEncoding(rec.Encoding); !encrypted {
+		return s.buckets[bucket]
+	}
+	id, err := KeyID(rec.Value)
+	return err != nil || !s.buckets[bucket] || id != s.keys.Primary()
+}
+
+// seal returns a copy of rec with its value sealed, or rec itself when
+// its bucket is not encrypted
+func (s *Store) seal(ctx context.Context, bucket string, rec *store.Record) (*store.Record, error) {
+	if !s.buckets[bucket] {
+		return rec, nil
+	}
+	if _, encrypted := innerEncoding(rec.Encoding); encrypted {
+		return nil, fmt.Errorf("record %s/%s is already encrypted", bucket, rec.Key)
+	}
+	env, err := s.keys.Seal(ctx, rec.Value, aad(bucket, rec.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt record %s/%s: %w", bucket, rec.Key, err)
+	}
+	sealed := *rec
+	sealed.Value = env
+	sealed.Encoding = EncodingAESGCM
+	if rec.Encoding != "" {
+		sealed.Encoding += "+" + rec.Encoding
+	}
+	return &sealed, nil
+}
+
+// open decrypts rec in place when it is sealed, restoring its encoding
+func (s *Store) open(ctx context.Context, bucket string, rec *store.Record) (*store.Record, error) {
+	inner, encrypted := innerEncoding(rec.Encoding)
+	if !encrypted {
+		return rec, nil
+	}
+	value, err := s.keys.Open(ctx, rec.Value, aad(bucket, rec.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record %s/%s: %w", bucket, rec.Key, err)
+	}
+	rec.Value, rec.Encoding = value, inner
+	return rec, nil
+}
+
+// innerEncoding returns the encoding of a sealed value before it was
+// sealed, and whether encoding is that of a sealed value at all
+func innerEncoding(encoding string) (string, bool) {
+	if encoding == EncodingAESGCM {
+		return "", true
+	}
+	return strings.CutPrefix(encoding, EncodingAESGCM+"+")
+}
+
+// aad binds a sealed value to where it is stored, so that it cannot be
+// copied over another record
+func aad(bucket, key string) []byte {
+	return []byte(bucket + "\x00" + key)
+}
+
+// sealedWrite pairs a record written in a transaction with the sealed
+// copy passed to the store
+type sealedWrite struct {
+	orig, sealed *store.Record
+}
+
+// sealingTx seals the records written through it and opens those read
+type sealingTx struct {
+	store.Tx
+	s       *Store
+	ctx     context.Context
+	written *[]sealedWrite
+}
+
+// Get implements store.Tx
+func (tx *sealingTx) Get(bucket, key string) (*store.Record, error) {
+	rec, err := tx.Tx.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.s.open(tx.ctx, bucket, rec)
+}
+
+// Put implements store.Tx
+func (tx *sealingTx) Put(bucket string, rec *store.Record) error {
+	sealed, err := tx.s.seal(tx.ctx, bucket, rec)
+	if err != nil {
+		return err
+	}
+	*tx.written = append(*tx.written, sealedWrite{orig: rec, sealed: sealed})
+	return tx.Tx.Put(bucket, sealed)
+}
@@ -0,0 +1,60 @@
+package debugger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// Manager keeps the sessions of debug executions for inspection
+type Manager struct {
+	pauseTimeout time.Duration
+	retention    time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewManager creates a manager whose sessions fail after pauseTimeout paused
+// and are forgotten retention after they finish
+func NewManager(pauseTimeout, retention time.Duration) *Manager {
+	return &Manager{
+		pauseTimeout: pauseTimeout,
+		retention:    retention,
+		sessions:     make(map[string]*Session),
+	}
+}
+
+// Open registers a new session pausing before the breakpoint steps. The
+// session intercepts a run once passed to it as its engine.Debugger.
+func (m *Manager) Open(executionID, flowID string, breakpoints []string) *Session {
+	s := NewSession(executionID, flowID, breakpoints, m.pauseTimeout)
+	m.mu.Lock()
+	m.sessions[executionID] = s
+	m.mu.Unlock()
+	return s
+}
+
+// Close records the outcome of the session's run. The session stays
+// available for inspection until the retention period has passed.
+func (m *Manager) Close(s *Session, result *engine.Result, err error) {
+	s.finish(result, err)
+	time.AfterFunc(m.retention, func() { m.forget(s.executionID) })
+}
+
+// Get returns the session of a debug execution
+func (m *Manager) Get(executionID string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[executionID]
+	return s, ok
+}
+
+func (m *Manager) forget(executionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[executionID]; ok && s.done() {
+		delete(m.sessions, executionID)
+	}
+}
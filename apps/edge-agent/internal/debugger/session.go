@@ -0,0 +1,294 @@
+package debugger
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// Session statuses
+const (
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Commands accepted by a paused session
+const (
+	// CommandResume continues to the next breakpoint
+	CommandResume = "resume"
+	// CommandStep continues to the next step
+	CommandStep = "step"
+	// CommandModify replaces the payload or variables without resuming
+	CommandModify = "modify"
+	// CommandAbort fails the execution
+	CommandAbort = "abort"
+)
+
+var (
+	// ErrNotPaused is returned for commands sent while the session runs
+	ErrNotPaused = errors.New("execution is not paused")
+	// ErrUnknownCommand is returned for unsupported commands
+	ErrUnknownCommand = errors.New("unknown debug command")
+	// ErrAborted fails an execution aborted from the debugger
+	ErrAborted = errors.New("execution aborted by debugger")
+	// ErrPauseTimeout fails an execution left paused too long
+	ErrPauseTimeout = errors.New("execution was paused for too long")
+)
+
+// Command controls a paused session. Body, ContentType and Variables apply
+// to modify; Body is raw JSON, and a JSON string is used verbatim for
+// non-JSON content types.
+type Command struct {
+	Command     string            `json:"command"`
+	Body        json.RawMessage   `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// State is a snapshot of a session
+type State struct {
+	ExecutionID string            `json:"executionId"`
+	FlowID      string            `json:"flowId"`
+	Status      string            `json:"status"`
+	Breakpoints []string          `json:"breakpoints"`
+	StepID      string            `json:"stepId,omitempty"`
+	Payload     *Payload          `json:"payload,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+	Outputs     []Payload         `json:"outputs,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	PausedAt    *time.Time        `json:"pausedAt,omitempty"`
+}
+
+// Payload renders a message body for inspection. JSON bodies are embedded
+// as is, other text as a string and binary data base64 encoded.
+type Payload struct {
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Encoding    string          `json:"encoding,omitempty"`
+	Size        int64           `json:"size"`
+}
+
+// Session pauses a plan run at breakpoints. It implements engine.Debugger.
+type Session struct {
+	executionID string
+	flowID      string
+	breakpoints map[string]bool
+	order       []string
+	timeout     time.Duration
+
+	mu       sync.Mutex
+	status   string
+	stepping bool
+	stepID   string
+	msg      *engine.Message
+	pausedAt time.Time
+	outputs  []*engine.Message
+	err      error
+	wake     chan string
+}
+
+// NewSession creates a session pausing before the given steps. Paused runs
+// fail after timeout.
+func NewSession(executionID, flowID string, breakpoints []string, timeout time.Duration) *Session {
+	s := &Session{
+		executionID: executionID,
+		flowID:      flowID,
+		breakpoints: make(map[string]bool, len(breakpoints)),
+		order:       make([]string, 0, len(breakpoints)),
+		timeout:     timeout,
+		status:      StatusRunning,
+		wake:        make(chan string, 1),
+	}
+	for _, id := range breakpoints {
+		if !s.breakpoints[id] {
+			s.breakpoints[id] = true
+			s.order = append(s.order, id)
+		}
+	}
+	return s
+}
+
+// Before implements engine.Debugger
+func (s *Session) Before(ctx context.Context, sc *engine.StepContext, in *engine.Message) (*engine.Message, error) {
+	s.mu.Lock()
+	if !s.stepping && !s.breakpoints[sc.StepID] {
+		s.mu.Unlock()
+		return in, nil
+	}
+	s.status = StatusPaused
+	s.stepID = sc.StepID
+	s.msg = in
+	s.pausedAt = time.Now().UTC()
+	s.mu.Unlock()
+	sc.Logger.Info("Execution paused at breakpoint")
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	var cmd string
+	var err error
+	select {
+	case cmd = <-s.wake:
+	case <-timer.C:
+		err = ErrPauseTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Drop a command that raced the timeout
+	select {
+	case <-s.wake:
+	default:
+	}
+	msg := s.msg
+	s.status = StatusRunning
+	s.stepID = ""
+	s.msg = nil
+	if err == nil && cmd == CommandAbort {
+		err = ErrAborted
+	}
+	if err != nil {
+		msg.Release()
+		return nil, err
+	}
+	s.stepping = cmd == CommandStep
+	return msg, nil
+}
+
+// Command applies cmd to the paused session
+func (s *Session) Command(cmd Command) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != StatusPaused {
+		return ErrNotPaused
+	}
+	switch cmd.Command {
+	case CommandResume, CommandStep, CommandAbort:
+		// Mark the session running so a second command is rejected until
+		// the next pause
+		s.status = StatusRunning
+		s.wake <- cmd.Command
+		return nil
+	case CommandModify:
+		return s.modify(cmd)
+	}
+	return ErrUnknownCommand
+}
+
+func (s *Session) modify(cmd Command) error {
+	msg := s.msg
+	if cmd.Body == nil && cmd.ContentType != "" {
+		msg.ContentType = cmd.ContentType
+	}
+	if cmd.Body != nil {
+		contentType := msg.ContentType
+		if cmd.ContentType != "" {
+			contentType = cmd.ContentType
+		}
+		// The replaced stream, if any, is no longer needed
+		msg.Release()
+		msg = msg.WithBody(decodeBody(cmd.Body, contentType), contentType)
+	}
+	for k, v := range cmd.Variables {
+		msg.SetHeader(k, v)
+	}
+	s.msg = msg
+	return nil
+}
+
+// finish records the outcome of the run
+func (s *Session) finish(result *engine.Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = StatusSucceeded
+	s.msg = nil
+	if err != nil {
+		s.status = StatusFailed
+		s.err = err
+	}
+	if result != nil {
+		s.outputs = result.Outputs
+	}
+}
+
+// State returns a snapshot of the session
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := State{
+		ExecutionID: s.executionID,
+		FlowID:      s.flowID,
+		Status:      s.status,
+		Breakpoints: s.order,
+	}
+	if s.status == StatusPaused {
+		pausedAt := s.pausedAt
+		st.StepID = s.stepID
+		st.PausedAt = &pausedAt
+		p := render(s.msg)
+		st.Payload = &p
+		st.Variables = make(map[string]string, len(s.msg.Headers))
+		for k, v := range s.msg.Headers {
+			st.Variables[k] = v
+		}
+	}
+	for _, out := range s.outputs {
+		st.Outputs = append(st.Outputs, render(out))
+	}
+	if s.err != nil {
+		st.Error = s.err.Error()
+	}
+	return st
+}
+
+// done reports whether the run has finished
+func (s *Session) done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status == StatusSucceeded || s.status == StatusFailed
+}
+
+func render(msg *engine.Message) Payload {
+	p := Payload{ContentType: msg.ContentType, Size: msg.Size()}
+	switch {
+	case msg.IsStream():
+		p.Encoding = "stream"
+	case json.Valid(msg.Body):
+		p.Body = msg.Body
+	case utf8.Valid(msg.Body):
+		p.Body, _ = json.Marshal(string(msg.Body))
+	default:
+		p.Body, _ = json.Marshal(base64.StdEncoding.EncodeToString(msg.Body))
+		p.Encoding = "base64"
+	}
+	return p
+}
+
+// decodeBody turns a modify command's body into message bytes
+func decodeBody(raw json.RawMessage, contentType string) []byte {
+	var s string
+	if !isJSON(contentType) && json.Unmarshal(raw, &s) == nil {
+		return []byte(s)
+	}
+	return raw
+}
+
+func isJSON(contentType string) bool {
+	mt, _, _ := strings.Cut(contentType, ";")
+	mt = strings.TrimSpace(mt)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
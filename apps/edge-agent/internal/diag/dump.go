@@ -0,0 +1,178 @@
+package diag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Source reports one part of the agent's state for a dump
+type Source func() interface{}
+
+// Dumper writes diagnostic dumps: a snapshot of the agent's state and the
+// stacks of all goroutines, for finding out what a hung agent is doing
+type Dumper struct {
+	cfg     config.DiagnosticsConfig
+	logger  *logrus.Logger
+	started time.Time
+
+	mu      sync.Mutex
+	names   []string
+	sources map[string]Source
+}
+
+// Dump describes a written dump
+type Dump struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Goroutines int       `json:"goroutines"`
+	Time       time.Time `json:"time"`
+}
+
+// NewDumper creates a dumper writing to the configured directory
+func NewDumper(cfg config.DiagnosticsConfig, logger *logrus.Logger) *Dumper {
+	return &Dumper{cfg: cfg, logger: logger, started: time.Now().UTC(), sources: make(map[string]Source)}
+}
+
+// Add includes the state reported by source in dumps, in the order added
+func (d *Dumper) Add(name string, source Source) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.sources[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.sources[name] = source
+}
+
+// Watch writes a dump on every SIGQUIT until ctx is cancelled. Receiving
+// the signal replaces the runtime's default of dumping stacks and exiting.
+func (d *Dumper) Watch(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			dump, err := d.Write()
+			if err != nil {
+				d.logger.Errorf("Failed to write diagnostic dump: %v", err)
+				continue
+			}
+			d.logger.WithField("path", dump.Path).Warn("Wrote diagnostic dump on SIGQUIT")
+		}
+	}
+}
+
+// Write writes a dump to a new timestamped file
+func (d *Dumper) Write() (*Dump, error) {
+	dir := d.cfg.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	now := time.Now().UTC()
+	path := filepath.Join(dir, fmt.Sprintf("edge-agent-dump-%s-%d.txt", now.Format("20060102T150405.000Z"), os.Getpid()))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dump file: %w", err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	w := bufio.NewWriter(f)
+	err = d.write(w, now, goroutines)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write dump %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Dump{Path: path, Size: info.Size(), Goroutines: goroutines, Time: now}, nil
+}
+
+// write writes the header, each source's state and the goroutine stacks
+func (d *Dumper) write(w *bufio.Writer, now time.Time, goroutines int) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	hostname, _ := os.Hostname()
+
+	fmt.Fprintf(w, "FusionFlow edge agent diagnostic dump\n")
+	fmt.Fprintf(w, "time:        %s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "host:        %s\n", hostname)
+	fmt.Fprintf(w, "pid:         %d\n", os.Getpid())
+	fmt.Fprintf(w, "uptime:      %s\n", now.Sub(d.started).Round(time.Second))
+	fmt.Fprintf(w, "go:          %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "goroutines:  %d\n", goroutines)
+	fmt.Fprintf(w, "heap:        %d bytes in use, %d objects\n", mem.HeapInuse, mem.HeapObjects)
+	fmt.Fprintf(w, "gc:          %d cycles, last pause %s\n", mem.NumGC, time.Duration(mem.PauseNs[(mem.NumGC+255)%256]))
+
+	d.mu.Lock()
+	names := append([]string(nil), d.names...)
+	sources := make([]Source, len(names))
+	for i, name := range names {
+		sources[i] = d.sources[name]
+	}
+	d.mu.Unlock()
+
+	for i, name := range names {
+		fmt.Fprintf(w, "\n== %s ==\n", name)
+		data, err := json.MarshalIndent(collect(sources[i]), "", "  ")
+		if err != nil {
+			fmt.Fprintf(w, "error: failed to encode: %v\n", err)
+			continue
+		}
+		w.Write(data)
+		w.WriteString("\n")
+	}
+
+	fmt.Fprintf(w, "\n== goroutines ==\n")
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// collectTimeout bounds how long a source may take, since a hung agent may
+// hold the locks a source needs
+const collectTimeout = 2 * time.Second
+
+// collect runs source, reporting a panic or a timeout as its state so that
+// the dump of a misbehaving agent still completes
+func collect(source Source) interface{} {
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- map[string]string{"error": fmt.Sprintf("panic: %v", r)}
+			}
+		}()
+		done <- source()
+	}()
+	select {
+	case state := <-done:
+		return state
+	case <-time.After(collectTimeout):
+		return map[string]string{"error": "timed out; the state may be locked by a stuck goroutine, see the stacks below"}
+	}
+}
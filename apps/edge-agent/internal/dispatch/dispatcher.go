@@ -0,0 +1,298 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// DefaultTenant owns executions of flows without a tenant
+const DefaultTenant = "default"
+
+// ErrQueueFull is returned when a tenant already has MaxQueued executions
+// waiting for a slot
+var ErrQueueFull = errors.New("tenant execution queue is full")
+
+// waitSamples is the number of recent queue waits kept per tenant for
+// percentiles
+const waitSamples = 1024
+
+// Dispatcher limits the executions running at once and hands out free slots
+// by weighted fair queuing across tenants. Each tenant's virtual pass
+// advances by 1/weight per dispatched execution, and the waiting tenant with
+// the lowest pass goes next, so under contention tenants receive slots in
+// proportion to their weight regardless of how many executions each queued.
+type Dispatcher struct {
+	capacity      int
+	defaultWeight int
+	limits        map[string]config.TenantConfig
+
+	mu      sync.Mutex
+	running int
+	// vtime is the pass of the last dispatched execution; tenants that were
+	// idle restart from it instead of redeeming the time they did not use
+	vtime   float64
+	tenants map[string]*tenant
+}
+
+// tenant is the queue and accounting of one tenant
+type tenant struct {
+	name          string
+	weight        int
+	maxConcurrent int
+	maxQueued     int
+
+	pass    float64
+	running int
+	queue   []*waiter
+
+	dispatched uint64
+	rejected   uint64
+	waitTotal  time.Duration
+	waitMax    time.Duration
+	waits      []time.Duration
+	next       int
+}
+
+// waiter is an execution waiting for a slot
+type waiter struct {
+	queuedAt time.Time
+	ready    chan struct{}
+	granted  bool
+}
+
+// NewDispatcher creates a dispatcher from the scheduler configuration
+func NewDispatcher(cfg config.SchedulerConfig) *Dispatcher {
+	limits := make(map[string]config.TenantConfig, len(cfg.Tenants))
+	for _, t := range cfg.Tenants {
+		limits[t.Name] = t
+	}
+	return &Dispatcher{
+		capacity:      cfg.MaxConcurrent,
+		defaultWeight: cfg.DefaultWeight,
+		limits:        limits,
+		tenants:       make(map[string]*tenant),
+	}
+}
+
+// Acquire waits for an execution slot for name and returns the function
+// that frees it. It fails with ErrQueueFull when the tenant's queue is at
+// its limit, or with the context's error when ctx ends first.
+func (d *Dispatcher) Acquire(ctx context.Context, name string) (release func(), err error) {
+	if name == "" {
+		name = DefaultTenant
+	}
+
+	d.mu.Lock()
+	t := d.tenant(name)
+	if len(t.queue) == 0 && d.running < d.capacity && t.canRun() {
+		d.catchUp(t)
+		d.grant(t, &waiter{queuedAt: time.Now(), ready: make(chan struct{})})
+		d.mu.Unlock()
+		return d.releaser(t), nil
+	}
+	if t.maxQueued > 0 && len(t.queue) >= t.maxQueued {
+		t.rejected++
+		d.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	if len(t.queue) == 0 {
+		d.catchUp(t)
+	}
+	w := &waiter{queuedAt: time.Now(), ready: make(chan struct{})}
+	t.queue = append(t.queue, w)
+	d.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return d.releaser(t), nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if w.granted {
+			// The slot was handed over as ctx ended; pass it on
+			d.free(t)
+			return nil, ctx.Err()
+		}
+		for i, q := range t.queue {
+			if q == w {
+				t.queue = append(t.queue[:i], t.queue[i+1:]...)
+				break
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (d *Dispatcher) releaser(t *tenant) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.free(t)
+			d.mu.Unlock()
+		})
+	}
+}
+
+// tenant returns the state of name, creating it on first use
+func (d *Dispatcher) tenant(name string) *tenant {
+	t, ok := d.tenants[name]
+	if ok {
+		return t
+	}
+	t = &tenant{name: name, weight: d.defaultWeight}
+	if limit, ok := d.limits[name]; ok {
+		if limit.Weight > 0 {
+			t.weight = limit.Weight
+		}
+		t.maxConcurrent = limit.MaxConcurrent
+		t.maxQueued = limit.MaxQueued
+	}
+	d.tenants[name] = t
+	return t
+}
+
+// catchUp moves an idle tenant's pass up to the current virtual time
+func (d *Dispatcher) catchUp(t *tenant) {
+	if t.pass < d.vtime {
+		t.pass = d.vtime
+	}
+}
+
+func (t *tenant) canRun() bool {
+	return t.maxConcurrent == 0 || t.running < t.maxConcurrent
+}
+
+// grant gives w a slot on behalf of t
+func (d *Dispatcher) grant(t *tenant, w *waiter) {
+	d.vtime = t.pass
+	t.pass += 1 / float64(t.weight)
+	t.running++
+	d.running++
+	t.dispatched++
+
+	wait := time.Since(w.queuedAt)
+	t.waitTotal += wait
+	if wait > t.waitMax {
+		t.waitMax = wait
+	}
+	if len(t.waits) < waitSamples {
+		t.waits = append(t.waits, wait)
+	} else {
+		t.waits[t.next] = wait
+		t.next = (t.next + 1) % waitSamples
+	}
+
+	w.granted = true
+	close(w.ready)
+}
+
+// free returns t's slot and dispatches waiting executions into free slots
+func (d *Dispatcher) free(t *tenant) {
+	t.running--
+	d.running--
+	for d.running < d.capacity {
+		var next *tenant
+		for _, c := range d.tenants {
+			if len(c.queue) == 0 || !c.canRun() {
+				continue
+			}
+			if next == nil || c.pass < next.pass || (c.pass == next.pass && c.name < next.name) {
+				next = c
+			}
+		}
+		if next == nil {
+			return
+		}
+		w := next.queue[0]
+		next.queue[0] = nil
+		next.queue = next.queue[1:]
+		d.grant(next, w)
+	}
+}
+
+// Stats reports the dispatcher's load and the queue of every tenant seen
+// so far, ordered by tenant
+type Stats struct {
+	MaxConcurrent int           `json:"maxConcurrent"`
+	Running       int           `json:"running"`
+	Queued        int           `json:"queued"`
+	Tenants       []TenantStats `json:"tenants"`
+}
+
+// TenantStats reports one tenant's share and queue
+type TenantStats struct {
+	Tenant        string    `json:"tenant"`
+	Weight        int       `json:"weight"`
+	MaxConcurrent int       `json:"maxConcurrent,omitempty"`
+	MaxQueued     int       `json:"maxQueued,omitempty"`
+	Running       int       `json:"running"`
+	Queued        int       `json:"queued"`
+	Dispatched    uint64    `json:"dispatched"`
+	Rejected      uint64    `json:"rejected"`
+	Wait          WaitStats `json:"wait"`
+}
+
+// WaitStats summarizes how long executions waited for a slot, in
+// milliseconds. Oldest is the age of the longest waiting execution;
+// percentiles cover the most recent dispatches.
+type WaitStats struct {
+	OldestMs float64 `json:"oldestMs"`
+	AvgMs    float64 `json:"avgMs"`
+	P50Ms    float64 `json:"p50Ms"`
+	P95Ms    float64 `json:"p95Ms"`
+	P99Ms    float64 `json:"p99Ms"`
+	MaxMs    float64 `json:"maxMs"`
+}
+
+// Stats returns a snapshot of the dispatcher
+func (d *Dispatcher) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := Stats{MaxConcurrent: d.capacity, Running: d.running, Tenants: make([]TenantStats, 0, len(d.tenants))}
+	now := time.Now()
+	for _, t := range d.tenants {
+		ts := TenantStats{
+			Tenant:        t.name,
+			Weight:        t.weight,
+			MaxConcurrent: t.maxConcurrent,
+			MaxQueued:     t.maxQueued,
+			Running:       t.running,
+			Queued:        len(t.queue),
+			Dispatched:    t.dispatched,
+			Rejected:      t.rejected,
+		}
+		if len(t.queue) > 0 {
+			ts.Wait.OldestMs = ms(now.Sub(t.queue[0].queuedAt))
+		}
+		if t.dispatched > 0 {
+			ts.Wait.AvgMs = ms(t.waitTotal / time.Duration(t.dispatched))
+			ts.Wait.MaxMs = ms(t.waitMax)
+		}
+		if len(t.waits) > 0 {
+			sorted := append([]time.Duration(nil), t.waits...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			ts.Wait.P50Ms = ms(percentile(sorted, 0.50))
+			ts.Wait.P95Ms = ms(percentile(sorted, 0.95))
+			ts.Wait.P99Ms = ms(percentile(sorted, 0.99))
+		}
+		stats.Queued += ts.Queued
+		stats.Tenants = append(stats.Tenants, ts)
+	}
+	sort.Slice(stats.Tenants, func(i, j int) bool { return stats.Tenants[i].Tenant < stats.Tenants[j].Tenant })
+	return stats
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
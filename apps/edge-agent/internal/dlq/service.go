@@ -0,0 +1,325 @@
+package dlq
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Bucket holds the dead letters, keyed by flow ID and dead letter ID
+const Bucket = "deadletters"
+
+var (
+	// ErrNotFound is returned when a dead letter does not exist
+	ErrNotFound = errors.New("dead letter not found")
+
+	// ErrNoPayload is returned when re-driving a dead letter whose input
+	// was not kept
+	ErrNoPayload = errors.New("dead letter has no payload to re-drive")
+)
+
+// PlanFunc returns the plan a flow's re-drives run on
+type PlanFunc func(ctx context.Context, flowID string) (*engine.Plan, error)
+
+// Service keeps the input of failed executions per flow, and re-drives
+// them as new executions. It implements engine.DeadLetters.
+type Service struct {
+	store    store.Store
+	cfg      config.DeadLetterConfig
+	executor *engine.Executor
+	plan     PlanFunc
+	logger   *logrus.Logger
+}
+
+// NewService creates a dead-letter queue re-driving through executor, on
+// the plans returned by plan
+func NewService(st store.Store, cfg config.DeadLetterConfig, executor *engine.Executor, plan PlanFunc, logger *logrus.Logger) *Service {
+	return &Service{store: st, cfg: cfg, executor: executor, plan: plan, logger: logger}
+}
+
+// Add implements engine.DeadLetters, dead-lettering the failed execution
+// exec with its input in
+func (s *Service) Add(ctx context.Context, exec *model.Execution, in *engine.Message) error {
+	dl := &model.DeadLetter{
+		ID:          ids.New("dlq"),
+		FlowID:      exec.FlowID,
+		FlowVersion: exec.FlowVersion,
+		Tenant:      exec.Tenant,
+		ExecutionID: exec.ID,
+		Status:      model.DeadLetterPending,
+		Error:       exec.Error,
+		DebugLog:    exec.DebugLog,
+		FailedAt:    time.Now().UTC(),
+	}
+	if exec.EndTime != nil {
+		dl.FailedAt = *exec.EndTime
+	}
+	for i := len(exec.Steps) - 1; i >= 0; i-- {
+		step := exec.Steps[i]
+		if step.Status != model.StepFailed {
+			continue
+		}
+		dl.StepID = step.ID
+		dl.Attempts = 1
+		if n := len(step.Attempts); n > 0 {
+			dl.Attempts = step.Attempts[n-1].Attempt
+			dl.ErrorClass = step.Attempts[n-1].ErrorClass
+		}
+		break
+	}
+	if in != nil && len(in.Body) <= s.cfg.MaxPayloadBytes {
+		dl.Payload = Render(in)
+	}
+	if err := s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, dl)
+	}); err != nil {
+		return err
+	}
+	s.logger.WithFields(logrus.Fields{"flow_id": dl.FlowID, "execution_id": dl.ExecutionID, "dead_letter": dl.ID}).Warn("Dead-lettered failed execution")
+	return nil
+}
+
+// Page returns the dead letters of a flow matching opts, which may filter
+// on the status, error_class and step_id labels, along with how many match
+// it in all
+func (s *Service) Page(ctx context.Context, flowID string, opts store.ListOptions) ([]*model.DeadLetter, int, error) {
+	opts.Prefix = flowID + "/"
+	total, err := s.store.Count(ctx, Bucket, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	list, err := s.list(ctx, opts)
+	return list, total, err
+}
+
+// list returns the dead letters matching opts
+func (s *Service) list(ctx context.Context, opts store.ListOptions) ([]*model.DeadLetter, error) {
+	records, err := s.store.List(ctx, Bucket, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	list := make([]*model.DeadLetter, 0, len(records))
+	for _, rec := range records {
+		dl, err := decode(rec)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, dl)
+	}
+	return list, nil
+}
+
+// Get returns a dead letter of a flow
+func (s *Service) Get(ctx context.Context, flowID, id string) (*model.DeadLetter, error) {
+	rec, err := s.store.Get(ctx, Bucket, key(flowID, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// Redrive runs the flow again on the input of a dead letter, on its current
+// plan, and marks the dead letter as re-driven. Should the re-drive fail,
+// its input is dead-lettered anew.
+func (s *Service) Redrive(ctx context.Context, flowID, id string) (*model.Execution, error) {
+	dl, err := s.Get(ctx, flowID, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.redrive(ctx, dl)
+}
+
+// RedriveAll re-drives every pending dead letter of a flow, returning the
+// executions started
+func (s *Service) RedriveAll(ctx context.Context, flowID string) ([]*model.Execution, error) {
+	pending, err := s.list(ctx, store.ListOptions{
+		Prefix: flowID + "/",
+		Labels: map[string]string{"status": model.DeadLetterPending},
+	})
+	if err != nil {
+		return nil, err
+	}
+	execs := make([]*model.Execution, 0, len(pending))
+	for _, dl := range pending {
+		if dl.Payload == nil {
+			continue
+		}
+		exec, err := s.redrive(ctx, dl)
+		if err != nil {
+			return execs, err
+		}
+		execs = append(execs, exec)
+	}
+	return execs, nil
+}
+
+// redrive submits an execution on the input of dl and records it
+func (s *Service) redrive(ctx context.Context, dl *model.DeadLetter) (*model.Execution, error) {
+	if dl.Payload == nil {
+		return nil, ErrNoPayload
+	}
+	in, err := Restore(dl.Payload)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := s.plan(ctx, dl.FlowID)
+	if err != nil {
+		return nil, err
+	}
+	exec, err := s.executor.Submit(plan, in, engine.ExecuteOptions{
+		Tenant: dl.Tenant,
+		Cause:  &model.Cause{Type: model.CauseDeadLetter, ParentID: dl.ExecutionID},
+		Debug:  dl.DebugLog || logging.IsDebug(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-drive dead letter %s: %w", dl.ID, err)
+	}
+
+	now := time.Now().UTC()
+	dl.Status = model.DeadLetterRedriven
+	dl.Redrives++
+	dl.RedriveExecutionID = exec.ID
+	dl.RedrivenAt = &now
+	if err := s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, dl)
+	}); err != nil {
+		return exec, err
+	}
+	return exec, nil
+}
+
+// Delete removes a dead letter of a flow
+func (s *Service) Delete(ctx context.Context, flowID, id string) error {
+	err := s.store.Delete(ctx, Bucket, key(flowID, id))
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter %s: %w", id, err)
+	}
+	return nil
+}
+
+// Purge removes the dead letters of a flow, only those with status unless
+// it is empty, and returns how many were removed
+func (s *Service) Purge(ctx context.Context, flowID, status string) (int, error) {
+	opts := store.ListOptions{Prefix: flowID + "/"}
+	if status != "" {
+		opts.Labels = map[string]string{"status": status}
+	}
+	records, err := s.store.List(ctx, Bucket, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	err = s.store.Update(ctx, func(tx store.Tx) error {
+		for _, rec := range records {
+			if err := tx.Delete(Bucket, rec.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return fmt.Errorf("failed to delete dead letter %s: %w", rec.Key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// save writes dl within tx
+func save(tx store.Tx, dl *model.DeadLetter) error {
+	value, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	labels := map[string]string{
+		"flow_id":      dl.FlowID,
+		"status":       dl.Status,
+		"execution_id": dl.ExecutionID,
+	}
+	if dl.StepID != "" {
+		labels["step_id"] = dl.StepID
+	}
+	if dl.ErrorClass != "" {
+		labels["error_class"] = dl.ErrorClass
+	}
+	rec := &store.Record{
+		Key:       key(dl.FlowID, dl.ID),
+		Value:     value,
+		Labels:    labels,
+		CreatedAt: dl.FailedAt,
+	}
+	if err := tx.Put(Bucket, rec); err != nil {
+		return fmt.Errorf("failed to store dead letter: %w", err)
+	}
+	return nil
+}
+
+// decode unmarshals a stored dead letter
+func decode(rec *store.Record) (*model.DeadLetter, error) {
+	var dl model.DeadLetter
+	if err := json.Unmarshal(rec.Value, &dl); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letter %s: %w", rec.Key, err)
+	}
+	return &dl, nil
+}
+
+// key is the key of a dead letter, grouping those of a flow
+func key(flowID, id string) string {
+	return flowID + "/" + id
+}
+
+// Render keeps msg as a payload, such as that of a dead letter
+func Render(msg *engine.Message) *model.DeadLetterPayload {
+	p := &model.DeadLetterPayload{ContentType: msg.ContentType, Headers: msg.Headers, Size: len(msg.Body)}
+	switch {
+	case len(msg.Body) == 0:
+	case json.Valid(msg.Body):
+		p.Body = msg.Body
+	case utf8.Valid(msg.Body):
+		p.Body, _ = json.Marshal(string(msg.Body))
+		p.Encoding = "text"
+	default:
+		p.Body, _ = json.Marshal(base64.StdEncoding.EncodeToString(msg.Body))
+		p.Encoding = "base64"
+	}
+	return p
+}
+
+// Restore rebuilds the message kept in p by Render
+func Restore(p *model.DeadLetterPayload) (*engine.Message, error) {
+	body := []byte(p.Body)
+	if p.Encoding != "" {
+		var text string
+		if err := json.Unmarshal(p.Body, &text); err != nil {
+			return nil, fmt.Errorf("failed to decode dead letter payload: %w", err)
+		}
+		body = []byte(text)
+		if p.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode dead letter payload: %w", err)
+			}
+			body = decoded
+		}
+	}
+	msg := engine.NewMessage(body, p.ContentType)
+	for k, v := range p.Headers {
+		msg.SetHeader(k, v)
+	}
+	return msg, nil
+}
@@ -0,0 +1,220 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDenied is returned for connections an egress policy does not allow
+var ErrDenied = errors.New("egress denied")
+
+// EventDenied is the outbox event auditing a denied connection
+const EventDenied = "egress.denied"
+
+// Policy names of denials
+const (
+	PolicyGlobal    = "global"
+	PolicyNamespace = "namespace"
+)
+
+// Attempt is a connection a step or connector is about to make
+type Attempt struct {
+	Host string `json:"host"`
+	// Port is zero when the connection is on the protocol's default port
+	Port int `json:"port,omitempty"`
+	// Allow is the egress allowlist of the flow's namespace, checked along
+	// with the global one
+	Allow       []string `json:"-"`
+	FlowID      string   `json:"flowId,omitempty"`
+	ExecutionID string   `json:"executionId,omitempty"`
+	StepID      string   `json:"stepId,omitempty"`
+	ConnectorID string   `json:"connectorId,omitempty"`
+}
+
+// Denial is the audit record of a denied attempt
+type Denial struct {
+	Attempt
+	Policy   string    `json:"policy"`
+	DeniedAt time.Time `json:"deniedAt"`
+}
+
+// Guard enforces the global egress allowlist, and the allowlists of
+// namespaces passed with each attempt, before connections are made. Every
+// denial is logged and recorded as an outbox event for audit.
+type Guard struct {
+	rules    Rules
+	resolver *net.Resolver
+	store    store.Store
+	logger   *logrus.Logger
+}
+
+// NewGuard creates a guard enforcing the global allowlist patterns
+func NewGuard(patterns []string, st store.Store, logger *logrus.Logger) (*Guard, error) {
+	rules, err := Parse(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Guard{rules: rules, resolver: net.DefaultResolver, store: st, logger: logger}, nil
+}
+
+// Check returns an error wrapping ErrDenied unless both the namespace and
+// the global allowlist admit the attempt. Host names are resolved for
+// allowlists of CIDR ranges.
+func (g *Guard) Check(ctx context.Context, a Attempt) error {
+	if len(a.Allow) > 0 {
+		ns, err := Parse(a.Allow)
+		if err != nil {
+			return err
+		}
+		if err := g.check(ctx, a, ns, PolicyNamespace); err != nil {
+			return err
+		}
+	}
+	return g.check(ctx, a, g.rules, PolicyGlobal)
+}
+
+// CheckDialled is Check for a connection to the attempt's host that is
+// about to be made to ip: host names are admitted by the address actually
+// dialled rather than by resolving them again
+func (g *Guard) CheckDialled(ctx context.Context, a Attempt, ip net.IP) error {
+	if len(a.Allow) > 0 {
+		ns, err := Parse(a.Allow)
+		if err != nil {
+			return err
+		}
+		if err := g.checkDialled(ctx, a, ip, ns, PolicyNamespace); err != nil {
+			return err
+		}
+	}
+	return g.checkDialled(ctx, a, ip, g.rules, PolicyGlobal)
+}
+
+func (g *Guard) check(ctx context.Context, a Attempt, rules Rules, policy string) error {
+	if rules.allowsResolved(ctx, g.resolver, a.Host, a.Port) {
+		return nil
+	}
+	return g.deny(ctx, a, policy)
+}
+
+func (g *Guard) checkDialled(ctx context.Context, a Attempt, ip net.IP, rules Rules, policy string) error {
+	if rules.allowsDialled(a.Host, ip, a.Port) {
+		return nil
+	}
+	return g.deny(ctx, a, policy)
+}
+
+// deny audits a denied attempt and returns its error
+func (g *Guard) deny(ctx context.Context, a Attempt, policy string) error {
+	g.audit(ctx, Denial{Attempt: a, Policy: policy, DeniedAt: time.Now().UTC()})
+	addr := a.Host
+	if a.Port != 0 {
+		addr = net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+	}
+	return fmt.Errorf("%w: %s is not in the %s allowlist", ErrDenied, addr, policy)
+}
+
+// audit logs a denial and records its event. Failing to record it does not
+// change the outcome of the check.
+func (g *Guard) audit(ctx context.Context, d Denial) {
+	g.logger.WithFields(logrus.Fields{
+		"host":         d.Host,
+		"port":         d.Port,
+		"policy":       d.Policy,
+		"flow_id":      d.FlowID,
+		"execution_id": d.ExecutionID,
+		"step_id":      d.StepID,
+		"connector_id": d.ConnectorID,
+	}).Warn("Denied egress connection")
+	err := g.store.Update(context.WithoutCancel(ctx), func(tx store.Tx) error {
+		return outbox.Enqueue(tx, EventDenied, d.Host, d)
+	})
+	if err != nil {
+		g.logger.Errorf("Failed to record egress denial: %v", err)
+	}
+}
+
+// CheckConnector implements connector.EgressGuard, checking every host the
+// definition of a connector connects to against the global allowlist
+func (g *Guard) CheckConnector(ctx context.Context, def *model.Connector) error {
+	for _, addr := range Hosts(def) {
+		addr.ConnectorID = def.ID
+		if err := g.Check(ctx, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hosts returns the hosts a connector's config connects to, from the
+// settings connectors name them with. A port is taken from the address,
+// the port setting or the URL scheme, and left zero when the driver's
+// default applies.
+func Hosts(def *model.Connector) []Attempt {
+	var hosts []Attempt
+	port := 0
+	switch p := def.Config["port"].(type) {
+	case float64:
+		port = int(p)
+	case int:
+		port = p
+	case string:
+		port = parsePort(p)
+	}
+	add := func(v interface{}, isURL bool) {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return
+		}
+		if isURL || strings.Contains(s, "://") {
+			if u, err := url.Parse(s); err == nil && u.Host != "" {
+				hosts = append(hosts, Attempt{Host: strings.ToLower(u.Hostname()), Port: URLPort(u)})
+			}
+			return
+		}
+		host, p := SplitAddress(s)
+		if p == 0 {
+			p = port
+		}
+		hosts = append(hosts, Attempt{Host: host, Port: p})
+	}
+	add(def.Config["host"], false)
+	add(def.Config["address"], false)
+	add(def.Config["url"], true)
+	add(def.Config["baseUrl"], true)
+	switch brokers := def.Config["brokers"].(type) {
+	case string:
+		for _, b := range strings.Split(brokers, ",") {
+			add(strings.TrimSpace(b), false)
+		}
+	case []interface{}:
+		for _, b := range brokers {
+			add(b, false)
+		}
+	}
+	return hosts
+}
+
+// URLPort returns the port a request to u connects to, defaulting by scheme
+func URLPort(u *url.URL) int {
+	if p := parsePort(u.Port()); p != 0 {
+		return p
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		return 443
+	case "http", "ws":
+		return 80
+	}
+	return 0
+}
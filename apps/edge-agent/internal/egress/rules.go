@@ -0,0 +1,174 @@
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Rules is a parsed egress allowlist. Each rule is a host name, a
+// "*.suffix" wildcard, an IP address or a CIDR range, optionally followed
+// by ":port" ("[...]:port" for IPv6 addresses and ranges). Empty rules
+// allow every host.
+type Rules []rule
+
+// rule admits one host pattern, on any port when port is zero
+type rule struct {
+	host   string
+	suffix string
+	cidr   *net.IPNet
+	port   int
+}
+
+// Parse parses allowlist patterns
+func Parse(patterns []string) (Rules, error) {
+	rules := make(Rules, 0, len(patterns))
+	for _, pattern := range patterns {
+		r, err := parseRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseRule(pattern string) (rule, error) {
+	var r rule
+	host := strings.ToLower(strings.TrimSpace(pattern))
+	if rest, ok := strings.CutPrefix(host, "["); ok {
+		inner, after, found := strings.Cut(rest, "]")
+		if !found {
+			return r, fmt.Errorf("invalid egress pattern %q", pattern)
+		}
+		host = inner
+		if after != "" {
+			port, ok := strings.CutPrefix(after, ":")
+			if !ok {
+				return r, fmt.Errorf("invalid egress pattern %q", pattern)
+			}
+			if r.port = parsePort(port); r.port == 0 {
+				return r, fmt.Errorf("invalid port in egress pattern %q", pattern)
+			}
+		}
+	} else if h, port, found := strings.Cut(host, ":"); found && !strings.Contains(port, ":") {
+		// A single colon separates the port; more make an IPv6 address
+		host = h
+		if r.port = parsePort(port); r.port == 0 {
+			return r, fmt.Errorf("invalid port in egress pattern %q", pattern)
+		}
+	}
+
+	switch {
+	case host == "":
+		return r, fmt.Errorf("invalid egress pattern %q", pattern)
+	case strings.Contains(host, "/"):
+		_, cidr, err := net.ParseCIDR(host)
+		if err != nil {
+			return r, fmt.Errorf("egress pattern %q is not a valid CIDR range", pattern)
+		}
+		r.cidr = cidr
+	case net.ParseIP(host) != nil:
+		ip := net.ParseIP(host)
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		r.cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	case strings.HasPrefix(host, "*."):
+		r.suffix = host[1:]
+	default:
+		r.host = host
+	}
+	return r, nil
+}
+
+// parsePort returns a port number, or zero when s is not one
+func parsePort(s string) int {
+	port, err := strconv.Atoi(s)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0
+	}
+	return port
+}
+
+// SplitAddress splits "host", "host:port" or "[ipv6]:port" into the host
+// and its port, which is zero when absent
+func SplitAddress(addr string) (string, int) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return strings.ToLower(host), parsePort(port)
+	}
+	return strings.ToLower(strings.Trim(addr, "[]")), 0
+}
+
+// Allows reports whether the rules admit host on port without resolving
+// it: names match name rules, addresses match address and range rules. A
+// rule with a port only admits connections known to be on it.
+func (rs Rules) Allows(host string, port int) bool {
+	if len(rs) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, r := range rs {
+		if r.port != 0 && r.port != port {
+			continue
+		}
+		switch {
+		case r.cidr != nil:
+			if ip != nil && r.cidr.Contains(ip) {
+				return true
+			}
+		case r.suffix != "":
+			if strings.HasSuffix(host, r.suffix) {
+				return true
+			}
+		case r.host == host:
+			return true
+		}
+	}
+	return false
+}
+
+// allowsResolved is Allows, but a name no name rule admits is resolved,
+// and admitted when every address it resolves to is. Names that do not
+// resolve are not admitted.
+func (rs Rules) allowsResolved(ctx context.Context, resolver *net.Resolver, host string, port int) bool {
+	if rs.Allows(host, port) {
+		return true
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil || !rs.hasRanges() {
+		return false
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !rs.Allows(addr.IP.String(), port) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowsDialled is Allows, but a name no name rule admits is admitted when
+// ip, the address a connection to it is being made to, is. Checking the
+// address dialled rather than resolving the name separately keeps a name
+// that resolves elsewhere by the time of the connection from getting past
+// range rules.
+func (rs Rules) allowsDialled(host string, ip net.IP, port int) bool {
+	return rs.Allows(host, port) || rs.Allows(ip.String(), port)
+}
+
+// hasRanges reports whether any rule admits addresses
+func (rs Rules) hasRanges() bool {
+	for _, r := range rs {
+		if r.cidr != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"io"
+)
+
+// Bandwidth caps the traffic steps exchange with connectors. Limits are
+// shared by every run, so concurrent executions split a connector's cap.
+type Bandwidth interface {
+	// Reader limits r to the connector's read rate
+	Reader(ctx context.Context, connectorID string, r io.Reader) io.Reader
+	// Writer limits w to the connector's write rate
+	Writer(ctx context.Context, connectorID string, w io.Writer) io.Writer
+}
+
+// WithBandwidth makes steps that name a connector honour its bandwidth caps
+func WithBandwidth(b Bandwidth) Option {
+	return func(p *Plan) {
+		p.bandwidth = b
+	}
+}
+
+// LimitReader caps reads from r at the connector's read rate, counting the
+// read as a connector call. It returns r unchanged without a connector or
+// when caps are not enforced.
+func (sc *StepContext) LimitReader(ctx context.Context, connectorID string, r io.Reader) io.Reader {
+	if connectorID == "" {
+		return r
+	}
+	// A spent budget fails the run once the step returns
+	_ = sc.CountCall()
+	if sc.bandwidth == nil {
+		return r
+	}
+	return sc.bandwidth.Reader(ctx, connectorID, r)
+}
+
+// LimitWriter caps writes to w at the connector's write rate, counting the
+// write as a connector call and its bytes as egress. It returns w unchanged
+// without a connector.
+func (sc *StepContext) LimitWriter(ctx context.Context, connectorID string, w io.Writer) io.Writer {
+	if connectorID == "" {
+		return w
+	}
+	_ = sc.CountCall()
+	if sc.meter != nil && sc.meter.budget.MaxEgressBytes > 0 {
+		w = &meteredWriter{w: w, meter: sc.meter}
+	}
+	if sc.bandwidth == nil {
+		return w
+	}
+	return sc.bandwidth.Writer(ctx, connectorID, w)
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoBatches is returned by StepContext.AddToBatch when the plan was
+// compiled without Batches
+var ErrNoBatches = errors.New("no batching available")
+
+// Batch configures the batch a step accumulates messages in and the
+// connector each full batch is written to
+type Batch struct {
+	FlowID string `json:"flowId"`
+	StepID string `json:"stepId"`
+	// Connector writes the batches, with Operation when set and the
+	// expanded Path as its path parameter
+	Connector   string `json:"connector"`
+	Operation   string `json:"operation,omitempty"`
+	Path        string `json:"path"`
+	ContentType string `json:"contentType,omitempty"`
+	// Separator follows every message in the batch
+	Separator string `json:"separator"`
+	// A batch is full at MaxCount messages or MaxBytes, or once no message
+	// was added for Gap; zero disables a limit
+	MaxCount int           `json:"maxCount,omitempty"`
+	MaxBytes int64         `json:"maxBytes,omitempty"`
+	Gap      time.Duration `json:"gap,omitempty"`
+}
+
+// Batches accumulates the messages of batching steps across executions,
+// staging them durably until each batch has been written to its connector
+type Batches interface {
+	Add(ctx context.Context, b Batch, body []byte) error
+}
+
+// WithBatches makes batching available to the plan's steps
+func WithBatches(b Batches) Option {
+	return func(p *Plan) {
+		p.batches = b
+	}
+}
+
+// AddToBatch adds body to the step's current batch, returning once it is
+// staged
+func (sc *StepContext) AddToBatch(ctx context.Context, b Batch, body []byte) error {
+	if sc.batches == nil {
+		return ErrNoBatches
+	}
+	b.FlowID, b.StepID = sc.FlowID, sc.StepID
+	return sc.batches.Add(ctx, b, body)
+}
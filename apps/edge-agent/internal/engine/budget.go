@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// ErrBudgetExceeded is returned when an execution exceeds its flow's budget
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// errOutOfTime cancels runs that used up the budget's duration
+var errOutOfTime = errors.New("execution ran out of its budgeted time")
+
+// Budget caps what one execution consumes across its runs; zero fields
+// are unlimited
+type Budget struct {
+	MaxConnectorCalls int64
+	MaxDuration       time.Duration
+	MaxEgressBytes    int64
+}
+
+// ParseBudget converts a flow's budget, which may be nil
+func ParseBudget(b *model.Budget) (Budget, error) {
+	var budget Budget
+	if b == nil {
+		return budget, nil
+	}
+	if b.MaxConnectorCalls < 0 || b.MaxEgressBytes < 0 {
+		return budget, errors.New("maxConnectorCalls and maxEgressBytes must not be negative")
+	}
+	budget.MaxConnectorCalls = b.MaxConnectorCalls
+	budget.MaxEgressBytes = b.MaxEgressBytes
+	if b.MaxDuration != "" {
+		d, err := time.ParseDuration(b.MaxDuration)
+		if err != nil || d <= 0 {
+			return budget, fmt.Errorf("maxDuration %q is not a positive duration", b.MaxDuration)
+		}
+		budget.MaxDuration = d
+	}
+	return budget, nil
+}
+
+// meter counts what a run consumes against the plan's budget, starting
+// from the usage of the runs before a suspension. The first limit crossed
+// is kept, so that the run fails with it whatever error a step returns.
+type meter struct {
+	budget Budget
+	start  time.Time
+
+	mu       sync.Mutex
+	usage    model.ExecutionUsage
+	exceeded error
+}
+
+// reset starts metering a run that continues from usage
+func (m *meter) reset(budget Budget, usage model.ExecutionUsage) {
+	m.budget = budget
+	m.start = time.Now()
+	m.usage = usage
+	m.exceeded = nil
+}
+
+// remaining returns the time the run may take, and false when unlimited
+func (m *meter) remaining() (time.Duration, bool) {
+	if m.budget.MaxDuration <= 0 {
+		return 0, false
+	}
+	return m.budget.MaxDuration - time.Duration(m.usage.DurationMs)*time.Millisecond, true
+}
+
+// count adds calls and egressed bytes, returning the exceeded limit once
+// either is spent
+func (m *meter) count(calls, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage.ConnectorCalls += calls
+	m.usage.EgressBytes += bytes
+	switch {
+	case m.exceeded != nil:
+	case m.budget.MaxConnectorCalls > 0 && m.usage.ConnectorCalls > m.budget.MaxConnectorCalls:
+		m.exceeded = fmt.Errorf("%w: more than %d connector calls", ErrBudgetExceeded, m.budget.MaxConnectorCalls)
+	case m.budget.MaxEgressBytes > 0 && m.usage.EgressBytes > m.budget.MaxEgressBytes:
+		m.exceeded = fmt.Errorf("%w: more than %d bytes egressed", ErrBudgetExceeded, m.budget.MaxEgressBytes)
+	}
+	return m.exceeded
+}
+
+// exceed records that the run ran out of time, unless a limit was already
+// crossed, and returns the limit the run fails with
+func (m *meter) exceed() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.exceeded == nil {
+		m.exceeded = fmt.Errorf("%w: ran longer than %s", ErrBudgetExceeded, m.budget.MaxDuration)
+	}
+	return m.exceeded
+}
+
+// err returns the limit the run crossed, if any
+func (m *meter) err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.exceeded
+}
+
+// snapshot returns the usage so far, including the time the run took
+func (m *meter) snapshot() model.ExecutionUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := m.usage
+	usage.DurationMs += time.Since(m.start).Milliseconds()
+	return usage
+}
+
+// CountCall records a call to an external system, such as a request or a
+// produced message. It fails with ErrBudgetExceeded once the execution has
+// spent its budget of calls, and the step should then give up.
+func (sc *StepContext) CountCall() error {
+	if sc.meter == nil {
+		return nil
+	}
+	return sc.meter.count(1, 0)
+}
+
+// CountEgress records n bytes sent to an external system, failing with
+// ErrBudgetExceeded once the execution has spent its budget of bytes
+func (sc *StepContext) CountEgress(n int64) error {
+	if sc.meter == nil {
+		return nil
+	}
+	return sc.meter.count(0, n)
+}
+
+// meteredWriter counts the bytes written through it as egress
+type meteredWriter struct {
+	w     io.Writer
+	meter *meter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	if err := w.meter.count(0, int64(len(p))); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
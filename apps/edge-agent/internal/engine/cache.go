@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// PlanCache keeps the compiled plan of each flow so that executions reuse
+// the built steps, compiled mappings and schema validators instead of
+// compiling the definition on every run. Plans are keyed by a hash of the
+// definition, so an edited flow is recompiled even if Invalidate is missed.
+type PlanCache struct {
+	opts []Option
+
+	mu    sync.Mutex
+	plans map[string]*cachedPlan
+}
+
+// cachedPlan is the plan compiled for one version of a flow
+type cachedPlan struct {
+	version string
+	plan    *Plan
+	// ready is closed once plan or err is set, so concurrent callers
+	// compile a version only once
+	ready chan struct{}
+	err   error
+}
+
+// NewPlanCache creates a plan cache compiling flows with opts
+func NewPlanCache(opts ...Option) *PlanCache {
+	return &PlanCache{opts: opts, plans: make(map[string]*cachedPlan)}
+}
+
+// Plan returns the compiled plan of flow, compiling it on first use of
+// this version of the definition
+func (c *PlanCache) Plan(flow *model.Flow) (*Plan, error) {
+	version, err := planVersion(flow)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.plans[flow.ID]
+	if ok && entry.version == version {
+		c.mu.Unlock()
+		<-entry.ready
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return entry.plan, nil
+	}
+	entry = &cachedPlan{version: version, ready: make(chan struct{})}
+	c.plans[flow.ID] = entry
+	c.mu.Unlock()
+
+	entry.plan, entry.err = Compile(flow, c.opts...)
+	close(entry.ready)
+	if entry.err != nil {
+		// Do not cache failures; the next call reports the current error
+		c.mu.Lock()
+		if c.plans[flow.ID] == entry {
+			delete(c.plans, flow.ID)
+		}
+		c.mu.Unlock()
+		return nil, entry.err
+	}
+	return entry.plan, nil
+}
+
+// Invalidate drops the cached plan of a flow, e.g. after it was updated or
+// deleted
+func (c *PlanCache) Invalidate(flowID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.plans, flowID)
+}
+
+// planVersion hashes the parts of a flow that determine its plan
+func planVersion(flow *model.Flow) (string, error) {
+	delivery := flow.Delivery
+	if delivery == "" {
+		delivery = model.DeliveryAtLeastOnce
+	}
+	b, err := json.Marshal(struct {
+		Steps     []model.Step  `json:"steps"`
+		Edges     []model.Edge  `json:"edges"`
+		Delivery  string        `json:"delivery"`
+		Namespace string        `json:"namespace"`
+		Policy    *model.Policy `json:"policy"`
+		Budget    *model.Budget `json:"budget"`
+	}{flow.Steps, flow.Edges, delivery, flow.Namespace, flow.Policy, flow.Budget})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash flow %s: %w", flow.ID, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
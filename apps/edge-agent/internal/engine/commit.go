@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoCommitter is returned by StepContext.WriteCommitted and
+// StepContext.Committed when the plan was compiled without a Committer
+var ErrNoCommitter = errors.New("no transactional connector writes available")
+
+// CommittedWrite is a write through a connector. Key, when set, is recorded
+// by the connector in the same transaction as the write.
+type CommittedWrite struct {
+	Operation   string
+	Params      map[string]interface{}
+	Body        []byte
+	ContentType string
+	Key         string
+}
+
+// Committer writes through connectors that record the idempotency key of a
+// write atomically with it, such as in the same database transaction, so
+// that the sinks of exactly-once flows can tell which writes were committed
+type Committer interface {
+	WriteCommitted(ctx context.Context, connectorID string, w CommittedWrite) error
+	Committed(ctx context.Context, connectorID, key string) (bool, error)
+}
+
+// WithCommitter makes transactional connector writes available to the
+// plan's steps
+func WithCommitter(c Committer) Option {
+	return func(p *Plan) {
+		p.committer = c
+	}
+}
+
+// WriteCommitted writes w through a connector, recording the idempotency
+// key of the step's current write along with it in exactly-once flows
+func (sc *StepContext) WriteCommitted(ctx context.Context, connectorID string, w CommittedWrite) error {
+	if sc.committer == nil {
+		return ErrNoCommitter
+	}
+	if err := sc.CountCall(); err != nil {
+		return err
+	}
+	w.Key = sc.idempotencyKey
+	return sc.committer.WriteCommitted(ctx, connectorID, w)
+}
+
+// Committed reports whether a connector recorded a write under key
+func (sc *StepContext) Committed(ctx context.Context, connectorID, key string) (bool, error) {
+	if sc.committer == nil {
+		return false, ErrNoCommitter
+	}
+	return sc.committer.Committed(ctx, connectorID, key)
+}
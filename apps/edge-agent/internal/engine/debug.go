@@ -0,0 +1,23 @@
+package engine
+
+import "context"
+
+// Debugger intercepts a run before each step. Before is called with the
+// step's context and input and may block while the run is paused; it
+// returns the input to use, which may have been modified, or an error that
+// aborts the run.
+type Debugger interface {
+	Before(ctx context.Context, sc *StepContext, in *Message) (*Message, error)
+}
+
+type debuggerKey struct{}
+
+// WithDebugger returns a context whose plan runs are intercepted by d
+func WithDebugger(ctx context.Context, d Debugger) context.Context {
+	return context.WithValue(ctx, debuggerKey{}, d)
+}
+
+func debuggerFrom(ctx context.Context) Debugger {
+	d, _ := ctx.Value(debuggerKey{}).(Debugger)
+	return d
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"context"
+	"strconv"
+)
+
+// HeaderMessageID identifies a source message across redeliveries. Triggers
+// set it from the source's own identity, such as a broker offset or message
+// ID; without it exactly-once flows key the writes of a message by its
+// execution.
+const HeaderMessageID = "message-id"
+
+// Sink is implemented by steps that write to external systems
+type Sink interface {
+	Step
+	// Transactional reports whether the step's writes are committed
+	// atomically together with a record of StepContext.IdempotencyKey, for
+	// example in the same database or Kafka transaction
+	Transactional() bool
+	// Committed reports whether the writes for key were already committed
+	Committed(ctx context.Context, sc *StepContext, key string) (bool, error)
+}
+
+// SupportsExactlyOnce reports whether step can run in an exactly-once
+// flow: it is not a sink, or is a transactional one
+func SupportsExactlyOnce(step Step) bool {
+	sink, ok := step.(Sink)
+	return !ok || sink.Transactional()
+}
+
+// idempotencyKey names the n-th invocation of a step for a source message
+func idempotencyKey(flowID, stepID, msgKey string, n int) string {
+	return flowID + "/" + stepID + "/" + msgKey + "/" + strconv.Itoa(n)
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/fusionflow/edge-agent/internal/egress"
+)
+
+// EgressGuard checks the connections of steps before they are made,
+// auditing the ones it denies
+type EgressGuard interface {
+	Check(ctx context.Context, a egress.Attempt) error
+	// CheckDialled checks a connection to the attempt's host that is about
+	// to be made to ip
+	CheckDialled(ctx context.Context, a egress.Attempt, ip net.IP) error
+}
+
+// WithEgress makes the plan's steps check their connections with g, against
+// the global allowlist as well as the plan's
+func WithEgress(g EgressGuard) Option {
+	return func(p *Plan) {
+		p.egress = g
+	}
+}
+
+// AllowEgress returns an error wrapping ErrEgressDenied unless the egress
+// allowlists let the step connect to host on port, which is zero for the
+// protocol's default
+func (sc *StepContext) AllowEgress(ctx context.Context, host string, port int) error {
+	if sc.egress != nil {
+		return sc.egress.Check(ctx, sc.egressAttempt(host, port))
+	}
+	addr := joinPort(host, port)
+	if sc.policy == nil || sc.policy.AllowsHost(addr) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrEgressDenied, addr)
+}
+
+// AllowDial is AllowEgress for a connection to host that is about to be
+// made to ip. Steps dialling through it check the address they actually
+// connect to, which a host name resolving elsewhere by then or a redirect
+// cannot get past.
+func (sc *StepContext) AllowDial(ctx context.Context, host string, ip net.IP, port int) error {
+	if sc.egress != nil {
+		return sc.egress.CheckDialled(ctx, sc.egressAttempt(host, port), ip)
+	}
+	addr := joinPort(host, port)
+	if sc.policy == nil || sc.policy.AllowsHost(addr) || sc.policy.AllowsHost(joinPort(ip.String(), port)) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrEgressDenied, addr)
+}
+
+func (sc *StepContext) egressAttempt(host string, port int) egress.Attempt {
+	var allow []string
+	if sc.policy != nil {
+		allow = sc.policy.Egress
+	}
+	return egress.Attempt{
+		Host:        host,
+		Port:        port,
+		Allow:       allow,
+		FlowID:      sc.FlowID,
+		ExecutionID: sc.ExecutionID,
+		StepID:      sc.StepID,
+	}
+}
+
+// joinPort returns host with port, or host alone when port is zero
+func joinPort(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// classError is an error marked with its error class
+type classError struct {
+	class string
+	err   error
+}
+
+func (e *classError) Error() string { return e.err.Error() }
+
+func (e *classError) Unwrap() error { return e.err }
+
+// WithErrorClass marks err as being of one of the model error classes,
+// for failures ErrorClass cannot tell apart, such as HTTP statuses
+func WithErrorClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{class: class, err: err}
+}
+
+// ErrorClass returns the model error class of a step failure: the class it
+// was marked with, else timeout or network for such errors, else other
+func ErrorClass(err error) string {
+	var marked *classError
+	if errors.As(err, &marked) {
+		return marked.class
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return model.ErrorClassTimeout
+	case netErr != nil, errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return model.ErrorClassNetwork
+	}
+	return model.ErrorClassOther
+}
+
+// HTTPStatusClass returns the error class of a failed HTTP status
+func HTTPStatusClass(status int) string {
+	switch {
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return model.ErrorClassTimeout
+	case status == http.StatusTooManyRequests:
+		return model.ErrorClassRateLimited
+	case status >= 500:
+		return model.ErrorClassServer
+	}
+	return model.ErrorClassClient
+}
@@ -0,0 +1,758 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotActive is returned when cancelling an execution that is not queued
+// or running on this agent
+var ErrNotActive = errors.New("execution is not active")
+
+// ErrLeaseLost is returned when recording an execution whose owner's lease
+// lapsed since it started, as peers may have failed it as orphaned
+var ErrLeaseLost = errors.New("cluster lease lost")
+
+// Lease is the cluster lease executions are owned under. Epoch changes
+// every time the lease is taken again after lapsing; held is false while it
+// has lapsed.
+type Lease interface {
+	Epoch() (epoch uint64, held bool)
+}
+
+// maxStepAttempts bounds the attempts recorded per step of an execution
+const maxStepAttempts = 20
+
+// Slots limits the executions running at once. Acquire blocks until the
+// tenant may run another execution and returns the function releasing it.
+type Slots interface {
+	Acquire(ctx context.Context, tenant string) (release func(), err error)
+}
+
+// Recorder persists execution state as it changes. event names the
+// lifecycle event to publish with the update, and is empty for step
+// progress. Record must not retain exec.
+type Recorder interface {
+	Record(ctx context.Context, exec *model.Execution, event string) error
+}
+
+// DeadLetters keeps the input of failed executions, to be re-driven. Add
+// is called with the final state of a run that failed after its retries
+// and a copy of its input, which is nil when the input is a stream or the
+// flow's policy does not capture payloads.
+type DeadLetters interface {
+	Add(ctx context.Context, exec *model.Execution, in *Message) error
+}
+
+// Inputs keeps the input of executions queued in the background until their
+// run is over, so that another process can queue them again when the one
+// that queued them stops before starting them
+type Inputs interface {
+	// Keep keeps in, which the run of exec takes after the step after
+	// when set
+	Keep(ctx context.Context, exec *model.Execution, after string, in *Message) error
+	// Drop forgets the input of the execution id
+	Drop(ctx context.Context, id string) error
+}
+
+// Execution lifecycle events
+const (
+	EventExecutionCreated   = "execution.created"
+	EventExecutionStarted   = "execution.started"
+	EventExecutionSucceeded = "execution.succeeded"
+	EventExecutionFailed    = "execution.failed"
+	EventExecutionCancelled = "execution.cancelled"
+	EventExecutionSuspended = "execution.suspended"
+	EventExecutionResumed   = "execution.resumed"
+	// EventExecutionBudgetExceeded is published for executions stopped for
+	// exceeding their flow's budget
+	EventExecutionBudgetExceeded = "execution.budget_exceeded"
+)
+
+// ExecuteOptions configures one execution
+type ExecuteOptions struct {
+	// ID identifies the execution; a new ID is generated when empty
+	ID string
+	// Tenant is the tenant whose execution slots the run takes
+	Tenant string
+	// Cause records why the execution runs
+	Cause *model.Cause
+	// After, when set, runs the input as if this step had emitted it,
+	// rather than from the plan's roots
+	After string
+	// Debugger intercepts the run's steps. Debug runs do not take an
+	// execution slot, so a paused run does not hold up other executions.
+	Debugger Debugger
+	// Debug logs the run at debug level whatever the agent's log level, and
+	// captures its payloads whatever the flow's policy, as for API requests
+	// with an authenticated X-Debug header. It is recorded as the
+	// execution's DebugLog, which resumed runs keep.
+	Debug bool
+	// Done is called with the final state once a run finishes. It owns the
+	// result's outputs, which are released when Done is not set.
+	Done func(exec *model.Execution, result *Result, err error)
+}
+
+// Executor runs plans as tracked executions. Each execution is recorded as
+// queued when submitted, running once it has an execution slot, and
+// succeeded, failed or cancelled when it ends, along with the outcome of
+// each step that ran. A suspended run is recorded as waiting until resumed.
+type Executor struct {
+	slots       Slots
+	recorder    Recorder
+	suspensions Suspensions
+	deadLetters DeadLetters
+	inputs      Inputs
+	logger      *logrus.Logger
+	// owner is stamped on executions in cluster mode, and lease fences
+	// their records
+	owner string
+	lease Lease
+
+	// ctx parents background runs and is cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[string]*execution
+}
+
+// NewExecutor creates an executor taking execution slots from slots and
+// recording state through recorder
+func NewExecutor(slots Slots, recorder Recorder, logger *logrus.Logger) *Executor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Executor{
+		slots:    slots,
+		recorder: recorder,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		active:   make(map[string]*execution),
+	}
+}
+
+// SetOwner stamps executions started from now on with the cluster instance
+// running them. It must be called before the first execution.
+func (e *Executor) SetOwner(instanceID string) {
+	e.owner = instanceID
+}
+
+// SetLease stops the recording of executions, and cancels them, once the
+// lease they started under lapses. It must be called before the first
+// execution.
+func (e *Executor) SetLease(l Lease) {
+	e.lease = l
+}
+
+// SetSuspensions sets where the state of suspended runs is saved. Runs
+// suspending without it fail.
+func (e *Executor) SetSuspensions(s Suspensions) {
+	e.suspensions = s
+}
+
+// SetDeadLetters sets where the input of failed executions is kept. Debug
+// runs and resumed runs, whose input is gone, are not dead-lettered.
+func (e *Executor) SetDeadLetters(d DeadLetters) {
+	e.deadLetters = d
+}
+
+// SetInputs sets where the input of executions queued in the background is
+// kept until their run is over. Debug runs and streamed inputs are not
+// kept.
+func (e *Executor) SetInputs(i Inputs) {
+	e.inputs = i
+}
+
+// Execute runs plan on in and waits for it to finish. Errors acquiring an
+// execution slot are returned wrapped, after recording the execution as
+// failed.
+func (e *Executor) Execute(ctx context.Context, plan *Plan, in *Message, opts ExecuteOptions) (*Result, error) {
+	x, err := e.queue(ctx, plan, opts)
+	if err != nil {
+		in.Release()
+		return nil, err
+	}
+	return e.run(x, plan, in, opts)
+}
+
+// Submit queues plan to run on in in the background and returns the queued
+// execution
+func (e *Executor) Submit(plan *Plan, in *Message, opts ExecuteOptions) (*model.Execution, error) {
+	x, err := e.queue(e.ctx, plan, opts)
+	if err != nil {
+		in.Release()
+		return nil, err
+	}
+	queued := x.snapshot()
+	kept := false
+	if e.inputs != nil && opts.Debugger == nil && !in.IsStream() {
+		if err := e.inputs.Keep(x.ctx, queued, opts.After, in); err != nil {
+			x.logger.Errorf("Failed to keep the input of the queued execution: %v", err)
+		} else {
+			kept = true
+		}
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if kept {
+			defer e.dropInput(x)
+		}
+		result, err := e.run(x, plan, in, opts)
+		if opts.Done == nil && err == nil {
+			for _, out := range result.Outputs {
+				out.Release()
+			}
+		}
+	}()
+	return queued, nil
+}
+
+// Resume continues the suspended execution exec in the background with
+// signal, and returns its state as resumed. The caller must have taken s
+// out of the store of suspensions, so that it is resumed only once.
+func (e *Executor) Resume(plan *Plan, exec *model.Execution, s *Suspension, signal Signal) (*model.Execution, error) {
+	ctx := e.ctx
+	if exec.DebugLog {
+		ctx = logging.WithDebug(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	x := &execution{
+		exec:     *exec,
+		recorder: e.recorder,
+		clock:    plan.clock,
+		ctx:      ctx,
+		cancel:   cancel,
+		steps:    make(map[string]int, len(exec.Steps)),
+		logger:   e.runLogger(plan, exec.ID, exec.DebugLog),
+	}
+	x.exec.Steps = append([]model.ExecutionStep(nil), exec.Steps...)
+	for i, step := range x.exec.Steps {
+		x.steps[step.ID] = i
+	}
+	if len(s.Ran) > 0 {
+		x.ran = make(map[string]bool, len(s.Ran))
+		for _, id := range s.Ran {
+			x.ran[id] = true
+		}
+	}
+	x.exec.Status = model.ExecutionRunning
+	x.exec.Owner = e.owner
+	x.exec.Wait = nil
+	x.fence(e.lease)
+	if err := x.record(EventExecutionResumed); err != nil {
+		cancel()
+		return nil, err
+	}
+	resumed := x.snapshot()
+
+	e.mu.Lock()
+	e.active[exec.ID] = x
+	e.mu.Unlock()
+
+	release := func() {
+		if signal.Message != nil {
+			signal.Message.Release()
+		}
+		s.Message.Release()
+		for _, q := range s.Pending {
+			q.Message.Release()
+		}
+	}
+	opts := ExecuteOptions{ID: exec.ID, Tenant: exec.Tenant, Cause: exec.Cause, Debug: exec.DebugLog}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		result, err := e.runWith(x, plan, opts, release, func(ctx context.Context) (*Result, error) {
+			return plan.Resume(ctx, s, x.logger, signal)
+		})
+		if err == nil {
+			for _, out := range result.Outputs {
+				out.Release()
+			}
+		}
+	}()
+	return resumed, nil
+}
+
+// Get returns the live state of an active execution
+func (e *Executor) Get(id string) (*model.Execution, bool) {
+	e.mu.Lock()
+	x, ok := e.active[id]
+	e.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return x.snapshot(), true
+}
+
+// Active returns the live state of the active executions, oldest first
+func (e *Executor) Active() []*model.Execution {
+	e.mu.Lock()
+	list := make([]*execution, 0, len(e.active))
+	for _, x := range e.active {
+		list = append(list, x)
+	}
+	e.mu.Unlock()
+
+	execs := make([]*model.Execution, len(list))
+	for i, x := range list {
+		execs[i] = x.snapshot()
+	}
+	sort.Slice(execs, func(i, j int) bool { return execs[i].QueuedAt.Before(execs[j].QueuedAt) })
+	return execs
+}
+
+// Cancel stops an active execution. The context of the running step is
+// cancelled, so the run stops once the step returns, or straight away if the
+// step honours cancellation, and is recorded as cancelled along with req;
+// the steps that had not run are recorded as skipped. RequestedAt defaults
+// to now.
+func (e *Executor) Cancel(id string, req model.ExecutionCancellation) error {
+	e.mu.Lock()
+	x, ok := e.active[id]
+	e.mu.Unlock()
+	if !ok {
+		return ErrNotActive
+	}
+	x.mu.Lock()
+	if x.exec.Cancellation == nil {
+		if req.RequestedAt.IsZero() {
+			req.RequestedAt = x.clock.Now().UTC()
+		}
+		x.exec.Cancellation = &req
+	}
+	x.mu.Unlock()
+	x.cancel()
+	return nil
+}
+
+// Stop waits for background executions to finish, cancelling those still
+// running when ctx ends
+func (e *Executor) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// queue registers a new execution and records it as queued
+func (e *Executor) queue(ctx context.Context, plan *Plan, opts ExecuteOptions) (*execution, error) {
+	id := opts.ID
+	if id == "" {
+		id = ids.New("exec")
+	}
+	if opts.Debug {
+		ctx = logging.WithDebug(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	x := &execution{
+		exec: model.Execution{
+			ID:          id,
+			FlowID:      plan.FlowID,
+			FlowVersion: plan.FlowVersion,
+			Tenant:      opts.Tenant,
+			Status:      model.ExecutionQueued,
+			Debug:       opts.Debugger != nil,
+			DebugLog:    opts.Debug,
+			Owner:       e.owner,
+			Cause:       opts.Cause,
+			QueuedAt:    plan.clock.Now().UTC(),
+		},
+		recorder: e.recorder,
+		clock:    plan.clock,
+		ctx:      ctx,
+		cancel:   cancel,
+		steps:    make(map[string]int),
+		logger:   e.runLogger(plan, id, opts.Debug),
+	}
+	x.fence(e.lease)
+	if err := x.record(EventExecutionCreated); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.active[id] = x
+	e.mu.Unlock()
+	return x, nil
+}
+
+// dropInput forgets the kept input of x once its run is over
+func (e *Executor) dropInput(x *execution) {
+	if err := e.inputs.Drop(context.WithoutCancel(x.ctx), x.exec.ID); err != nil {
+		x.logger.Errorf("Failed to drop the input of the execution: %v", err)
+	}
+}
+
+// runLogger returns the log entry of an execution, which logs at debug
+// level for debug runs
+func (e *Executor) runLogger(plan *Plan, id string, debug bool) *logrus.Entry {
+	if !debug {
+		return e.logger.WithFields(logrus.Fields{"flow_id": plan.FlowID, "execution_id": id})
+	}
+	return logging.DebugLogger(e.logger).WithFields(logrus.Fields{"flow_id": plan.FlowID, "execution_id": id, "debug": true})
+}
+
+// captureLevel returns what a run records: payloads for debug runs, and
+// otherwise what the flow's policy captures
+func captureLevel(plan *Plan, opts ExecuteOptions) string {
+	if opts.Debug {
+		return model.CapturePayloads
+	}
+	return plan.policy.CaptureLevel()
+}
+
+// run takes an execution slot, runs the plan and records the outcome
+func (e *Executor) run(x *execution, plan *Plan, in *Message, opts ExecuteOptions) (*Result, error) {
+	if e.deadLetters == nil || opts.Debugger != nil {
+		return e.runWith(x, plan, opts, in.Release, func(ctx context.Context) (*Result, error) {
+			x.start(plan)
+			return runFrom(ctx, x, plan, in, opts)
+		})
+	}
+
+	// The run consumes its input, so a copy is kept for the dead letter
+	var kept *Message
+	if !in.IsStream() && captureLevel(plan, opts) == model.CapturePayloads {
+		kept = in.Clone()
+		defer kept.Release()
+	}
+	result, err := e.runWith(x, plan, opts, in.Release, func(ctx context.Context) (*Result, error) {
+		x.start(plan)
+		return runFrom(ctx, x, plan, in, opts)
+	})
+	if final := x.snapshot(); err != nil && final.Status == model.ExecutionFailed {
+		if dlErr := e.deadLetters.Add(context.WithoutCancel(x.ctx), final, kept); dlErr != nil {
+			x.logger.Errorf("Failed to dead-letter execution: %v", dlErr)
+		}
+	}
+	return result, err
+}
+
+// runFrom runs plan on in for x, from its roots or after opts.After
+func runFrom(ctx context.Context, x *execution, plan *Plan, in *Message, opts ExecuteOptions) (*Result, error) {
+	if opts.After != "" {
+		return plan.RunAfter(ctx, x.exec.ID, x.logger, opts.After, in)
+	}
+	return plan.Run(ctx, x.exec.ID, x.logger, in)
+}
+
+// runWith takes an execution slot and records the outcome of runPlan, or
+// calls release when no slot could be taken. A suspended run is saved and
+// recorded as waiting.
+func (e *Executor) runWith(x *execution, plan *Plan, opts ExecuteOptions, release func(), runPlan func(context.Context) (*Result, error)) (result *Result, err error) {
+	defer func() {
+		x.cancel()
+		e.mu.Lock()
+		delete(e.active, x.exec.ID)
+		e.mu.Unlock()
+	}()
+
+	ctx := x.ctx
+	if opts.Debugger != nil {
+		ctx = WithDebugger(ctx, opts.Debugger)
+	} else {
+		releaseSlot, err := e.slots.Acquire(ctx, opts.Tenant)
+		if err != nil {
+			release()
+			err = fmt.Errorf("failed to acquire execution slot: %w", err)
+			x.finish(plan, err)
+			return nil, err
+		}
+		defer releaseSlot()
+	}
+
+	// Debug runs pause at breakpoints, so only other runs are timed out
+	var timeout time.Duration
+	if opts.Debugger == nil {
+		timeout = plan.policy.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Steps are only recorded when the run captures them; otherwise only
+	// which steps ran is noted
+	if captureLevel(plan, opts) != model.CaptureNone {
+		ctx = WithObserver(ctx, x)
+	} else {
+		ctx = WithObserver(ctx, stepsRan{x})
+	}
+	result, err = runPlan(ctx)
+	if result != nil {
+		x.mu.Lock()
+		usage := result.Usage
+		x.exec.Usage = &usage
+		x.mu.Unlock()
+	}
+	if timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("execution timed out after %s: %w", timeout, err)
+	}
+	if err == nil && result.Suspension != nil {
+		if err = e.suspend(x, result.Suspension); err == nil {
+			final := x.snapshot()
+			if opts.Done != nil {
+				opts.Done(final, result, nil)
+			}
+			return result, nil
+		}
+		// The run will not resume to commit its staged writes
+		if pending, ok := takeHeld(result.Suspension.ExecutionID); ok {
+			if rbErr := rollbackAll(context.WithoutCancel(x.ctx), pending); rbErr != nil {
+				err = errors.Join(err, rbErr)
+			}
+		}
+	}
+	if err != nil {
+		x.logger.Errorf("Flow execution failed: %v", err)
+	}
+	final := x.finish(plan, err)
+	if opts.Done != nil {
+		opts.Done(final, result, err)
+	}
+	return result, err
+}
+
+// suspend records the execution as waiting and saves its suspension. The
+// record comes first so that the execution is waiting by the time it can be
+// resumed.
+func (e *Executor) suspend(x *execution, s *Suspension) error {
+	if e.suspensions == nil {
+		return errors.New("step suspended the execution but suspensions are not enabled")
+	}
+	x.mu.Lock()
+	x.exec.Status = model.ExecutionWaiting
+	x.exec.Wait = s.Wait()
+	s.Ran = s.Ran[:0]
+	for id := range x.ran {
+		s.Ran = append(s.Ran, id)
+	}
+	sort.Strings(s.Ran)
+	x.mu.Unlock()
+	if err := x.record(EventExecutionSuspended); err != nil {
+		return fmt.Errorf("failed to record suspended execution: %w", err)
+	}
+	if err := e.suspensions.Save(context.WithoutCancel(x.ctx), s); err != nil {
+		return fmt.Errorf("failed to save suspended execution: %w", err)
+	}
+	x.logger.Infof("Execution suspended at step %s", s.StepID)
+	return nil
+}
+
+// execution is the tracked state of an active run. It implements Observer.
+type execution struct {
+	recorder Recorder
+	clock    clock.Clock
+	ctx      context.Context
+	cancel   context.CancelFunc
+	logger   *logrus.Entry
+	// lease, when set, must still be held in epoch for the execution to
+	// be recorded
+	lease Lease
+	epoch uint64
+
+	mu   sync.Mutex
+	exec model.Execution
+	// steps indexes exec.Steps by step ID
+	steps map[string]int
+	// ran notes the steps that started when the flow's policy does not
+	// capture them, so that a cancelled run records the others as skipped
+	ran map[string]bool
+}
+
+func (x *execution) start(plan *Plan) {
+	x.mu.Lock()
+	now := plan.clock.Now().UTC()
+	x.exec.Status = model.ExecutionRunning
+	x.exec.StartTime = &now
+	x.mu.Unlock()
+
+	if err := x.record(EventExecutionStarted); err != nil {
+		x.logger.Errorf("Failed to record execution state: %v", err)
+	}
+}
+
+// finish records the outcome of the run and returns the final state
+func (x *execution) finish(plan *Plan, err error) *model.Execution {
+	x.mu.Lock()
+	now := plan.clock.Now().UTC()
+	x.exec.EndTime = &now
+	x.exec.Wait = nil
+	event := EventExecutionSucceeded
+	x.exec.Status = model.ExecutionSucceeded
+	switch {
+	case errors.Is(err, ErrBudgetExceeded):
+		event = EventExecutionBudgetExceeded
+		x.exec.Status = model.ExecutionBudgetExceeded
+		x.exec.Error = err.Error()
+	case errors.Is(err, context.Canceled):
+		event = EventExecutionCancelled
+		x.exec.Status = model.ExecutionCancelled
+		x.exec.Error = err.Error()
+		x.skipRemaining(plan)
+	case err != nil:
+		event = EventExecutionFailed
+		x.exec.Status = model.ExecutionFailed
+		x.exec.Error = err.Error()
+	}
+	if x.exec.Status != model.ExecutionCancelled {
+		// The run ended before the cancellation reached it
+		x.exec.Cancellation = nil
+	}
+	x.mu.Unlock()
+
+	if err := x.record(event); err != nil {
+		x.logger.Errorf("Failed to record execution state: %v", err)
+	}
+	return x.snapshot()
+}
+
+// skipRemaining records the plan's steps that have not run as skipped,
+// whatever the flow's policy captures. The caller must hold x.mu.
+func (x *execution) skipRemaining(plan *Plan) {
+	for _, id := range plan.order {
+		if _, ok := x.steps[id]; ok || x.ran[id] {
+			continue
+		}
+		x.steps[id] = len(x.exec.Steps)
+		x.exec.Steps = append(x.exec.Steps, model.ExecutionStep{ID: id, Status: model.StepSkipped})
+	}
+}
+
+// stepsRan is the Observer of runs whose flow's policy does not capture
+// steps, noting only which steps started
+type stepsRan struct {
+	x *execution
+}
+
+// StepStarted implements Observer
+func (o stepsRan) StepStarted(sc *StepContext) {
+	o.x.mu.Lock()
+	defer o.x.mu.Unlock()
+	if o.x.ran == nil {
+		o.x.ran = make(map[string]bool)
+	}
+	o.x.ran[sc.StepID] = true
+}
+
+// StepAttempted implements Observer
+func (stepsRan) StepAttempted(*StepContext, model.StepAttempt) {}
+
+// StepFinished implements Observer
+func (stepsRan) StepFinished(*StepContext, time.Duration, error) {}
+
+// StepStarted implements Observer
+func (x *execution) StepStarted(sc *StepContext) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i, ok := x.steps[sc.StepID]
+	if !ok {
+		i = len(x.exec.Steps)
+		x.steps[sc.StepID] = i
+		x.exec.Steps = append(x.exec.Steps, model.ExecutionStep{ID: sc.StepID})
+	}
+	step := &x.exec.Steps[i]
+	step.Runs++
+	if step.Status != model.StepFailed {
+		step.Status = model.StepRunning
+	}
+}
+
+// StepAttempted implements Observer, keeping the most recent attempts of
+// the step
+func (x *execution) StepAttempted(sc *StepContext, attempt model.StepAttempt) {
+	x.mu.Lock()
+	step := &x.exec.Steps[x.steps[sc.StepID]]
+	step.Attempts = append(step.Attempts, attempt)
+	if n := len(step.Attempts); n > maxStepAttempts {
+		// Copied, as snapshots being recorded share the array
+		step.Attempts = append([]model.StepAttempt(nil), step.Attempts[n-maxStepAttempts:]...)
+	}
+	x.mu.Unlock()
+
+	if err := x.record(""); err != nil {
+		x.logger.Errorf("Failed to record execution state: %v", err)
+	}
+}
+
+// StepFinished implements Observer. Step progress is recorded without an
+// event, so the store write can be batched.
+func (x *execution) StepFinished(sc *StepContext, elapsed time.Duration, err error) {
+	x.mu.Lock()
+	step := &x.exec.Steps[x.steps[sc.StepID]]
+	step.DurationMs += elapsed.Milliseconds()
+	if metrics := sc.Metrics(); len(metrics) > 0 {
+		step.Metrics = metrics
+	}
+	var susp *Suspend
+	switch {
+	case errors.As(err, &susp):
+		step.Status = model.StepWaiting
+	case errors.Is(err, context.Canceled):
+		step.Status = model.StepCancelled
+	case err != nil:
+		step.Status = model.StepFailed
+		step.Error = err.Error()
+	case step.Status != model.StepFailed:
+		step.Status = model.StepSucceeded
+	}
+	x.mu.Unlock()
+
+	if err := x.record(""); err != nil {
+		x.logger.Errorf("Failed to record execution state: %v", err)
+	}
+}
+
+// record persists the current state. It outlives cancellation of the run so
+// that cancelled executions are recorded as such.
+func (x *execution) record(event string) error {
+	if x.lease != nil {
+		if epoch, held := x.lease.Epoch(); !held || epoch != x.epoch {
+			x.cancel()
+			return ErrLeaseLost
+		}
+	}
+	return x.recorder.Record(context.WithoutCancel(x.ctx), x.snapshot(), event)
+}
+
+// fence ties the execution to the current epoch of lease, if any
+func (x *execution) fence(lease Lease) {
+	if lease != nil {
+		x.lease = lease
+		x.epoch, _ = lease.Epoch()
+	}
+}
+
+// snapshot copies the current state
+func (x *execution) snapshot() *model.Execution {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	exec := x.exec
+	exec.Steps = append([]model.ExecutionStep(nil), x.exec.Steps...)
+	return &exec
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// LogRecorder persists the log entries of executions
+type LogRecorder interface {
+	RecordLog(ctx context.Context, log *model.ExecutionLog) error
+}
+
+// logSubscriberBuffer is how many entries a slow subscriber may lag behind
+// before entries are dropped for it; they remain in the store
+const logSubscriberBuffer = 256
+
+// LogCollector is a logrus hook capturing the entries logged for
+// executions, those carrying an execution_id field such as the loggers of
+// steps, recording them and passing them to live subscribers. Entries below
+// the logger's level are not captured.
+type LogCollector struct {
+	recorder LogRecorder
+	// seq is the last sequence number handed out; sequences are
+	// nanosecond timestamps made strictly increasing, so that they keep
+	// ordering the entries of an execution resumed after a restart
+	seq atomic.Int64
+
+	mu   sync.Mutex
+	subs map[string]map[chan *model.ExecutionLog]struct{}
+}
+
+// NewLogCollector creates a collector recording entries through recorder
+func NewLogCollector(recorder LogRecorder) *LogCollector {
+	return &LogCollector{recorder: recorder, subs: make(map[string]map[chan *model.ExecutionLog]struct{})}
+}
+
+// Levels implements logrus.Hook
+func (c *LogCollector) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (c *LogCollector) Fire(entry *logrus.Entry) error {
+	id, _ := entry.Data["execution_id"].(string)
+	if id == "" {
+		return nil
+	}
+	log := &model.ExecutionLog{
+		ExecutionID: id,
+		Seq:         c.next(entry.Time.UnixNano()),
+		Time:        entry.Time.UTC(),
+		Level:       entry.Level.String(),
+		Message:     entry.Message,
+	}
+	log.StepID, _ = entry.Data["step_id"].(string)
+	for k, v := range entry.Data {
+		if k == "execution_id" || k == "step_id" || k == "flow_id" {
+			continue
+		}
+		if log.Fields == nil {
+			log.Fields = make(map[string]interface{}, len(entry.Data))
+		}
+		log.Fields[k] = logValue(v)
+	}
+
+	c.publish(log)
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return c.recorder.RecordLog(context.WithoutCancel(ctx), log)
+}
+
+// next returns a sequence number after the last one, at least at
+func (c *LogCollector) next(at int64) int64 {
+	for {
+		last := c.seq.Load()
+		seq := max(at, last+1)
+		if c.seq.CompareAndSwap(last, seq) {
+			return seq
+		}
+	}
+}
+
+// Subscribe returns the entries of an execution as they are logged, until
+// cancel is called
+func (c *LogCollector) Subscribe(executionID string) (entries <-chan *model.ExecutionLog, cancel func()) {
+	ch := make(chan *model.ExecutionLog, logSubscriberBuffer)
+	c.mu.Lock()
+	if c.subs[executionID] == nil {
+		c.subs[executionID] = make(map[chan *model.ExecutionLog]struct{})
+	}
+	c.subs[executionID][ch] = struct{}{}
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subs[executionID], ch)
+		if len(c.subs[executionID]) == 0 {
+			delete(c.subs, executionID)
+		}
+	}
+}
+
+// publish passes log to the subscribers of its execution that keep up
+func (c *LogCollector) publish(log *model.ExecutionLog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.subs[log.ExecutionID] {
+		select {
+		case ch <- log:
+		default:
+		}
+	}
+}
+
+// logValue returns a field value that encodes as JSON, formatting errors
+// and other values JSON cannot encode as text
+func logValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+)
+
+// ErrNoLookup is returned by StepContext.Lookup when the plan was compiled
+// without a Lookuper
+var ErrNoLookup = errors.New("no connector lookup available")
+
+// Lookuper resolves reference data through a connector operation. It
+// returns the matching records, which are empty when nothing matches.
+type Lookuper interface {
+	Lookup(ctx context.Context, connectorID, operation string, params map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+// Option configures a compiled plan
+type Option func(*Plan)
+
+// WithLookup makes connector lookups available to the plan's steps
+func WithLookup(l Lookuper) Option {
+	return func(p *Plan) {
+		p.lookup = l
+	}
+}
+
+// WithClock sets the clock the plan's steps wait on
+func WithClock(c clock.Clock) Option {
+	return func(p *Plan) {
+		p.clock = c
+	}
+}
+
+// WithMaxBufferSize bounds the stream bodies buffered for steps that cannot
+// stream
+func WithMaxBufferSize(n int64) Option {
+	return func(p *Plan) {
+		p.maxBuffer = n
+	}
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Headers linking a trigger's message to its origin, recorded in the cause
+// of the execution it starts
+const (
+	// HeaderRequestID identifies the request that delivered the message
+	HeaderRequestID = "request-id"
+	// HeaderParentExecution is the upstream execution the message came from
+	HeaderParentExecution = "parent-execution-id"
+)
+
+// Headers of a flow's reply that shape the response of a synchronous
+// trigger, such as a webhook
+const (
+	// HeaderResponseStatus is the status code to respond with
+	HeaderResponseStatus = "response-status"
+	// HeaderResponseHeaderPrefix prefixes the response headers to set, as in
+	// "response-header.Location"
+	HeaderResponseHeaderPrefix = "response-header."
+)
+
+// Message is the unit of data passed between triggers and steps. Its body
+// is either held in memory in Body or, for large payloads, read from a
+// stream; see NewStreamMessage.
+type Message struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Body        []byte            `json:"body"`
+
+	stream *stream
+}
+
+// NewMessage creates a message with the given body and content type. The
+// header map is allocated by the first SetHeader.
+func NewMessage(body []byte, contentType string) *Message {
+	return &Message{ContentType: contentType, Body: body}
+}
+
+// JSONMessage creates a message holding v encoded as JSON
+func JSONMessage(v interface{}) (*Message, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message body: %w", err)
+	}
+	return NewMessage(body, "application/json"), nil
+}
+
+// Clone returns a copy of m that shares no mutable state with it. Cloning a
+// stream spools it to disk once so each copy can be read independently.
+func (m *Message) Clone() *Message {
+	c := &Message{
+		Headers:     copyHeaders(m.Headers),
+		ContentType: m.ContentType,
+		Body:        append([]byte(nil), m.Body...),
+	}
+	if m.stream != nil {
+		c.stream = m.stream.clone()
+	}
+	return c
+}
+
+// WithBody returns a copy of m's headers carrying a new body and content type
+func (m *Message) WithBody(body []byte, contentType string) *Message {
+	c := NewMessage(body, contentType)
+	c.Headers = copyHeaders(m.Headers)
+	return c
+}
+
+// copyHeaders copies a header map, leaving it nil when there is nothing
+// to copy
+func copyHeaders(h map[string]string) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
+}
+
+// SetHeader sets a header, allocating the header map if needed
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// DecodeJSON unmarshals the message body into v
+func (m *Message) DecodeJSON(v interface{}) error {
+	if err := json.Unmarshal(m.Body, v); err != nil {
+		return fmt.Errorf("failed to decode message body: %w", err)
+	}
+	return nil
+}
+
+// Reader returns the body as a stream. For stream messages it can be
+// called once; closing it releases the stream.
+func (m *Message) Reader() (io.ReadCloser, error) {
+	if m.stream == nil {
+		return io.NopCloser(bytes.NewReader(m.Body)), nil
+	}
+	return m.stream.reader()
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Observer is told as each step of a run starts and finishes, and of each
+// attempt of step runs that were retried, e.g. to track execution state. It
+// is called from the run's goroutine and must not block.
+type Observer interface {
+	StepStarted(sc *StepContext)
+	StepAttempted(sc *StepContext, attempt model.StepAttempt)
+	StepFinished(sc *StepContext, elapsed time.Duration, err error)
+}
+
+type observerKey struct{}
+
+// WithObserver returns a context whose plan runs report their steps to o
+func WithObserver(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, o)
+}
+
+func observerFrom(ctx context.Context) Observer {
+	o, _ := ctx.Value(observerKey{}).(Observer)
+	return o
+}
@@ -0,0 +1,606 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Plan is a flow compiled into step instances and their wiring, ready to run
+type Plan struct {
+	FlowID string
+	// FlowVersion is the version of the definition the plan was compiled from
+	FlowVersion int
+
+	steps map[string]Step
+	order []string
+	roots []string
+	// next maps a step ID and output port to the steps its messages go to
+	next map[string]map[string][]string
+	// retries holds the retry policies of steps that replace the flow's
+	retries map[string]Retry
+
+	lookup     Lookuper
+	clock      clock.Clock
+	bandwidth  Bandwidth
+	egress     EgressGuard
+	batches    Batches
+	quarantine Quarantine
+	committer  Committer
+	maxBuffer  int64
+	delivery   string
+	policies   Policies
+	policy     Policy
+	budget     Budget
+}
+
+// Delivery returns the plan's delivery guarantee
+func (p *Plan) Delivery() string {
+	return p.delivery
+}
+
+// Compile builds the flow's steps and checks that its edges form a DAG. A
+// flow without edges runs its steps in the order they are declared.
+func Compile(flow *model.Flow, opts ...Option) (*Plan, error) {
+	p := &Plan{
+		FlowID:      flow.ID,
+		FlowVersion: flow.Version,
+		steps:       make(map[string]Step, len(flow.Steps)),
+		retries:     make(map[string]Retry),
+		next:        make(map[string]map[string][]string),
+
+		clock:     clock.Real,
+		maxBuffer: DefaultMaxBufferSize,
+		delivery:  flow.Delivery,
+	}
+	if p.delivery == "" {
+		p.delivery = model.DeliveryAtLeastOnce
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	var err error
+	if p.policies != nil {
+		p.policy, err = p.policies.Policy(flow)
+	} else {
+		p.policy, err = ParsePolicy(flow.Policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy of flow %s: %w", flow.ID, err)
+	}
+	if p.budget, err = ParseBudget(flow.Budget); err != nil {
+		return nil, fmt.Errorf("invalid budget of flow %s: %w", flow.ID, err)
+	}
+	for _, def := range flow.Steps {
+		step, err := NewStep(def.Type, def.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build step %s: %w", def.ID, err)
+		}
+		if p.delivery == model.DeliveryExactlyOnce && !SupportsExactlyOnce(step) {
+			return nil, fmt.Errorf("step %s (%s) does not support exactly-once delivery", def.ID, def.Type)
+		}
+		if def.Retry != nil {
+			if p.retries[def.ID], err = ParseRetry(def.Retry); err != nil {
+				return nil, fmt.Errorf("invalid retry of step %s: %w", def.ID, err)
+			}
+		}
+		p.steps[def.ID] = step
+		p.order = append(p.order, def.ID)
+	}
+
+	edges := flow.Edges
+	if len(edges) == 0 {
+		for i := 1; i < len(flow.Steps); i++ {
+			edges = append(edges, model.Edge{From: flow.Steps[i-1].ID, To: flow.Steps[i].ID})
+		}
+	}
+	incoming := make(map[string]int, len(p.order))
+	for _, e := range edges {
+		if _, ok := p.steps[e.From]; !ok {
+			return nil, fmt.Errorf("edge references unknown step %q", e.From)
+		}
+		if _, ok := p.steps[e.To]; !ok {
+			return nil, fmt.Errorf("edge references unknown step %q", e.To)
+		}
+		if p.next[e.From] == nil {
+			p.next[e.From] = make(map[string][]string)
+		}
+		p.next[e.From][e.Port] = append(p.next[e.From][e.Port], e.To)
+		incoming[e.To]++
+	}
+	for _, id := range p.order {
+		if incoming[id] == 0 {
+			p.roots = append(p.roots, id)
+		}
+	}
+	if err := p.checkAcyclic(incoming); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkAcyclic verifies that every step can be reached in topological order
+func (p *Plan) checkAcyclic(incoming map[string]int) error {
+	remaining := make(map[string]int, len(incoming))
+	for k, v := range incoming {
+		remaining[k] = v
+	}
+	queue := append([]string(nil), p.roots...)
+	visited := 0
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, targets := range p.next[id] {
+			for _, to := range targets {
+				remaining[to]--
+				if remaining[to] == 0 {
+					queue = append(queue, to)
+				}
+			}
+		}
+	}
+	if visited != len(p.order) {
+		return fmt.Errorf("flow %s contains a cycle", p.FlowID)
+	}
+	return nil
+}
+
+// Result is the outcome of running a plan on one message
+type Result struct {
+	// Outputs are the messages emitted by steps with no outgoing edge for the port used
+	Outputs []*Message
+	// Metrics holds the metrics reported by each step, keyed by step ID
+	Metrics map[string]map[string]interface{}
+	// Suspension is set when a step suspended the run, which is then to be
+	// resumed with Plan.Resume
+	Suspension *Suspension
+	// Usage is what the execution consumed of its budget, including the
+	// runs before a suspension
+	Usage model.ExecutionUsage
+}
+
+// Run feeds in to the plan's root steps and propagates outputs along the
+// edges until every branch has finished. The first step error aborts the run
+// and rolls back the writes staged by two-phase sinks, which are otherwise
+// committed together at the end.
+// Stream bodies are passed through to streaming steps and buffered, up to
+// the plan's limit, for the others. A Debugger or Observer carried by ctx is
+// called around each step.
+//
+// A step returning Suspend stops the run with the rest of its messages
+// captured in the result's Suspension. Writes staged so far stay pending
+// until the resumed run completes, and cannot be committed by a run resumed
+// in another process.
+// A run exceeding the flow's budget fails with ErrBudgetExceeded.
+func (p *Plan) Run(ctx context.Context, executionID string, logger *logrus.Entry, in *Message) (*Result, error) {
+	return p.run(ctx, executionID, logger, in, p.roots, nil)
+}
+
+// RunAfter runs in as if stepID had emitted it on its default port, so that
+// a message the step held back carries on from there. Without steps
+// following that port, in is the run's only output.
+func (p *Plan) RunAfter(ctx context.Context, executionID string, logger *logrus.Entry, stepID string, in *Message) (*Result, error) {
+	if _, ok := p.steps[stepID]; !ok {
+		in.Release()
+		return nil, fmt.Errorf("step %s no longer exists", stepID)
+	}
+	targets := p.next[stepID][DefaultPort]
+	if len(targets) == 0 {
+		return &Result{Outputs: []*Message{in}, Metrics: make(map[string]map[string]interface{})}, nil
+	}
+	return p.run(ctx, executionID, logger, in, targets, nil)
+}
+
+// Resume continues a suspended run: the suspended step is resumed with
+// signal, then the messages that were pending are run
+func (p *Plan) Resume(ctx context.Context, s *Suspension, logger *logrus.Entry, signal Signal) (*Result, error) {
+	if s.FlowID != p.FlowID {
+		return nil, fmt.Errorf("suspension of flow %s cannot resume on flow %s", s.FlowID, p.FlowID)
+	}
+	if _, ok := p.steps[s.StepID].(Resumable); !ok {
+		return nil, fmt.Errorf("step %s no longer exists or cannot resume", s.StepID)
+	}
+	for _, q := range s.Pending {
+		if _, ok := p.steps[q.StepID]; !ok {
+			return nil, fmt.Errorf("step %s no longer exists", q.StepID)
+		}
+	}
+	signal.Data = s.Data
+	return p.run(ctx, s.ExecutionID, logger, nil, nil, &resumption{s, signal})
+}
+
+// resumption is the suspension a run starts from, and its signal
+type resumption struct {
+	suspension *Suspension
+	signal     Signal
+}
+
+// run runs in from the steps in roots, or else resumes a suspended run
+func (p *Plan) run(ctx context.Context, executionID string, logger *logrus.Entry, in *Message, roots []string, resume *resumption) (result *Result, err error) {
+	st := runStates.Get().(*runState)
+	queue := st.queue
+	if resume != nil {
+		s := resume.suspension
+		queue = append(queue, runItem{stepID: s.StepID, msg: s.Message, resume: resume})
+		for _, q := range s.Pending {
+			queue = append(queue, runItem{stepID: q.StepID, msg: q.Message})
+		}
+	} else {
+		for i, id := range roots {
+			msg := in
+			if i < len(roots)-1 {
+				msg = in.Clone()
+			}
+			queue = append(queue, runItem{stepID: id, msg: msg})
+		}
+	}
+	head := 0
+	defer func() {
+		// Free the stream bodies of branches that never ran
+		for _, it := range queue[head:] {
+			it.msg.Release()
+		}
+		st.queue = queue
+		st.put()
+	}()
+
+	// Writes staged by two-phase sinks, rolled back unless the run succeeds
+	var pending []pendingTx
+	defer func() {
+		if err != nil && len(pending) > 0 {
+			if rbErr := rollbackAll(context.WithoutCancel(ctx), pending); rbErr != nil {
+				err = errors.Join(err, rbErr)
+			}
+		}
+	}()
+	if resume != nil && len(resume.suspension.Staged) > 0 {
+		var ok bool
+		if pending, ok = takeHeld(executionID); !ok {
+			return nil, fmt.Errorf("writes staged by steps %v before the execution suspended were lost", resume.suspension.Staged)
+		}
+	}
+
+	var usage model.ExecutionUsage
+	if resume != nil {
+		usage = resume.suspension.Usage
+	}
+	st.meter.reset(p.budget, usage)
+	if remaining, ok := st.meter.remaining(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, remaining, errOutOfTime)
+		defer cancel()
+	}
+
+	result = &Result{Metrics: make(map[string]map[string]interface{})}
+	defer func() {
+		result.Usage = st.meter.snapshot()
+		if err == nil {
+			return
+		}
+		if context.Cause(ctx) == errOutOfTime {
+			st.meter.exceed()
+		}
+		// Whatever the step made of it, the run failed for its budget
+		if exceeded := st.meter.err(); exceeded != nil && !errors.Is(err, ErrBudgetExceeded) {
+			err = fmt.Errorf("%w: %v", exceeded, err)
+		}
+	}()
+	debugger := debuggerFrom(ctx)
+	observer := observerFrom(ctx)
+	var msgKey string
+	switch {
+	case resume != nil:
+		// Sinks may run again after the resume, so their keys are told apart
+		// from those of the run that suspended
+		if s := resume.suspension; s.MessageKey != "" {
+			msgKey = s.MessageKey + "/" + s.Token
+		}
+	case p.delivery == model.DeliveryExactlyOnce:
+		// Equal content does not make a redelivery, so a message without an
+		// ID is keyed by its delivery: retries of the execution recognize
+		// their writes, redeliveries by the source do not
+		if msgKey = in.Headers[HeaderMessageID]; msgKey == "" {
+			logger.Warnf("Message without a %s header; exactly-once delivery cannot be guaranteed across redeliveries", HeaderMessageID)
+			msgKey = executionID
+		}
+	}
+	for head < len(queue) {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		it := queue[head]
+		head++
+
+		sc := st.context(executionID, p, it.stepID, logger)
+
+		step := p.steps[it.stepID]
+		if s, ok := step.(StreamingStep); !ok || !s.Streaming() {
+			if err := it.msg.Buffer(p.maxBuffer); err != nil {
+				return result, fmt.Errorf("step %s failed: %w", it.stepID, err)
+			}
+		}
+		if debugger != nil {
+			msg, err := debugger.Before(ctx, sc, it.msg)
+			if err != nil {
+				it.msg.Release()
+				return result, err
+			}
+			it.msg = msg
+		}
+		start := time.Now()
+		if observer != nil {
+			observer.StepStarted(sc)
+		}
+		if msgKey != "" {
+			skip, err := p.prepareSink(ctx, sc, step, msgKey)
+			if err != nil {
+				it.msg.Release()
+				err = fmt.Errorf("step %s failed: %w", it.stepID, err)
+				if observer != nil {
+					observer.StepFinished(sc, time.Since(start), err)
+				}
+				return result, err
+			}
+			if skip {
+				it.msg.Release()
+				result.Metrics[it.stepID] = sc.Metrics()
+				if observer != nil {
+					observer.StepFinished(sc, time.Since(start), nil)
+				}
+				continue
+			}
+		}
+		var outputs []Output
+		outputs, err = p.invoke(ctx, sc, step, &it, &pending)
+		if err == nil {
+			// The step may have spent the budget without failing
+			err = st.meter.err()
+		}
+		if metrics := sc.Metrics(); len(metrics) > 0 {
+			result.Metrics[it.stepID] = metrics
+		}
+		var susp *Suspend
+		if errors.As(err, &susp) {
+			result.Suspension, err = p.suspension(executionID, it, queue[head:], susp, msgKey)
+			if err == nil {
+				result.Suspension.Usage = st.meter.snapshot()
+				// The writes commit once the resumed run completes
+				for _, tx := range pending {
+					result.Suspension.Staged = append(result.Suspension.Staged, tx.stepID)
+				}
+				if len(pending) > 0 {
+					hold(executionID, pending)
+					pending = nil
+				}
+			}
+			if err != nil {
+				err = fmt.Errorf("step %s failed to suspend: %w", it.stepID, err)
+				if observer != nil {
+					observer.StepFinished(sc, time.Since(start), err)
+				}
+				it.msg.Release()
+				return result, err
+			}
+			if observer != nil {
+				observer.StepFinished(sc, time.Since(start), susp)
+			}
+			// The suspension now holds the queued messages
+			head = len(queue)
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("step %s failed: %w", it.stepID, err)
+		}
+		if observer != nil {
+			observer.StepFinished(sc, time.Since(start), err)
+		}
+		if err != nil {
+			it.msg.Release()
+			return result, err
+		}
+		if !forwards(outputs, it.msg) {
+			it.msg.Release()
+		}
+
+		for _, out := range outputs {
+			targets := p.next[it.stepID][out.Port]
+			if len(targets) == 0 {
+				result.Outputs = append(result.Outputs, out.Message)
+				continue
+			}
+			for i, to := range targets {
+				msg := out.Message
+				if i < len(targets)-1 {
+					// Each branch gets its own copy
+					msg = msg.Clone()
+				}
+				queue = append(queue, runItem{stepID: to, msg: msg})
+			}
+		}
+	}
+
+	staged := pending
+	pending = nil
+	if len(staged) > 0 {
+		if err := commitAll(context.WithoutCancel(ctx), staged); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// invoke runs step on the item's message. Failures are retried as the
+// step's retry policy, or else the plan's, allows, giving each attempt but
+// the last a copy of the message; suspensions, cancellations, spent budgets
+// and errors of classes the policy does not retry are not. Once a run is
+// retried, each of its attempts is reported to the observer.
+func (p *Plan) invoke(ctx context.Context, sc *StepContext, step Step, it *runItem, pending *[]pendingTx) ([]Output, error) {
+	retry, ok := p.retries[it.stepID]
+	if !ok {
+		retry = p.policy.Retry
+	}
+	observer := observerFrom(ctx)
+	backoff := retry.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		start := sc.Clock().Now()
+		if attempt >= retry.MaxAttempts {
+			outputs, err := p.call(ctx, sc, step, it, it.msg, pending)
+			if attempt > 1 && observer != nil {
+				observer.StepAttempted(sc, stepAttempt(sc, attempt, start, err, 0))
+			}
+			return outputs, err
+		}
+		msg := it.msg.Clone()
+		outputs, err := p.call(ctx, sc, step, it, msg, pending)
+		var susp *Suspend
+		if err == nil || errors.As(err, &susp) || ctx.Err() != nil || sc.meter.err() != nil || !retry.retries(err) {
+			if attempt > 1 && observer != nil {
+				observer.StepAttempted(sc, stepAttempt(sc, attempt, start, err, 0))
+			}
+			// The copy carries on in place of the message
+			it.msg.Release()
+			it.msg = msg
+			return outputs, err
+		}
+		msg.Release()
+		wait := retry.wait(backoff)
+		if observer != nil {
+			observer.StepAttempted(sc, stepAttempt(sc, attempt, start, err, wait))
+		}
+		sc.Logger.Warnf("Step failed on attempt %d of %d, retrying in %s: %v", attempt, retry.MaxAttempts, wait, err)
+		sc.Report("retries", attempt)
+		timer := sc.Clock().NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C():
+		}
+		backoff = retry.next(backoff)
+	}
+}
+
+// stepAttempt describes an attempt that started at start and ended with
+// err, to be followed after wait by another
+func stepAttempt(sc *StepContext, attempt int, start time.Time, err error, wait time.Duration) model.StepAttempt {
+	a := model.StepAttempt{
+		Attempt:    attempt,
+		StartTime:  start.UTC(),
+		DurationMs: sc.Clock().Now().Sub(start).Milliseconds(),
+		BackoffMs:  wait.Milliseconds(),
+	}
+	if err != nil {
+		a.Error = err.Error()
+		a.ErrorClass = ErrorClass(err)
+	}
+	return a
+}
+
+// call runs step once on msg, staging the writes of two-phase sinks
+func (p *Plan) call(ctx context.Context, sc *StepContext, step Step, it *runItem, msg *Message, pending *[]pendingTx) ([]Output, error) {
+	if tp, ok := step.(TwoPhaseSink); ok {
+		tx, outputs, err := tp.Prepare(ctx, sc, msg)
+		if err == nil {
+			*pending = append(*pending, pendingTx{it.stepID, tx})
+		}
+		return outputs, err
+	}
+	if it.resume != nil {
+		return step.(Resumable).Resume(ctx, sc, msg, it.resume.signal)
+	}
+	return step.Run(ctx, sc, msg)
+}
+
+// runItem is a message waiting for a step
+type runItem struct {
+	stepID string
+	msg    *Message
+	// resume is set for the suspended step a resumed run starts with
+	resume *resumption
+}
+
+// runState is the scratch state of one run. It is pooled with its step
+// contexts and their log entries, which otherwise make up most of the
+// engine's own allocations per execution.
+type runState struct {
+	queue    []runItem
+	contexts map[string]*StepContext
+	free     []*StepContext
+	meter    meter
+}
+
+var runStates = sync.Pool{
+	New: func() interface{} {
+		return &runState{contexts: make(map[string]*StepContext)}
+	},
+}
+
+// context returns the run's context for stepID, reusing a pooled one
+func (st *runState) context(executionID string, p *Plan, stepID string, logger *logrus.Entry) *StepContext {
+	if sc, ok := st.contexts[stepID]; ok {
+		return sc
+	}
+	var sc *StepContext
+	if n := len(st.free); n > 0 {
+		sc = st.free[n-1]
+		st.free = st.free[:n-1]
+	} else {
+		sc = &StepContext{}
+	}
+	sc.reset(executionID, p, stepID, logger)
+	sc.meter = &st.meter
+	st.contexts[stepID] = sc
+	return sc
+}
+
+// put returns st to the pool, dropping its references to messages
+func (st *runState) put() {
+	clear(st.queue)
+	st.queue = st.queue[:0]
+	for id, sc := range st.contexts {
+		st.free = append(st.free, sc)
+		delete(st.contexts, id)
+	}
+	runStates.Put(st)
+}
+
+// prepareSink assigns the idempotency key of a transactional sink's next
+// invocation and reports whether its writes were already committed by an
+// earlier delivery of the message, in which case the step is skipped
+func (p *Plan) prepareSink(ctx context.Context, sc *StepContext, step Step, msgKey string) (bool, error) {
+	sink, ok := step.(Sink)
+	if !ok {
+		return false, nil
+	}
+	sc.runs++
+	sc.idempotencyKey = idempotencyKey(p.FlowID, sc.StepID, msgKey, sc.runs)
+	committed, err := sink.Committed(ctx, sc, sc.idempotencyKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to check sink commit: %w", err)
+	}
+	if committed {
+		sc.Logger.Infof("Skipping sink already committed for key %s", sc.idempotencyKey)
+		sc.Report("deduplicated", sc.runs)
+	}
+	return committed, nil
+}
+
+// forwards reports whether a step passed its input message on unchanged
+func forwards(outputs []Output, in *Message) bool {
+	for _, out := range outputs {
+		if out.Message == in {
+			return true
+		}
+	}
+	return false
+}
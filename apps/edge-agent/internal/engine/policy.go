@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// ErrEgressDenied is returned by StepContext.AllowEgress for hosts outside
+// the plan's or the global egress allowlist
+var ErrEgressDenied = egress.ErrDenied
+
+// maxRetryBackoff bounds the doubling wait between step retries by default
+const maxRetryBackoff = time.Minute
+
+// Retry is how a failing step is rerun. The zero value runs it once.
+type Retry struct {
+	// MaxAttempts is how often a failing step runs in total
+	MaxAttempts int
+	// Backoff is the wait before the first retry
+	Backoff time.Duration
+	// MaxBackoff caps the doubling wait; zero is maxRetryBackoff
+	MaxBackoff time.Duration
+	// Jitter is the largest fraction each wait is randomly shortened by
+	Jitter float64
+	// RetryOn, when set, lists the only error classes that are retried
+	RetryOn []string
+}
+
+// Policy is how a plan's runs retry failing steps, how long they may take
+// and what they expose. The zero value runs each step once, without a
+// timeout, exposing everything.
+type Policy struct {
+	// Retry applies to the steps without a retry policy of their own
+	Retry
+	// Timeout bounds each run; zero is unlimited
+	Timeout time.Duration
+	// Capture is one of the model capture levels; empty is CapturePayloads
+	Capture string
+	// Egress, when set, lists the only hosts steps may connect to: names,
+	// *.suffix wildcards or CIDR ranges
+	Egress []string
+}
+
+// Policies resolves the policy of a flow, e.g. from the defaults and
+// limits of its namespace
+type Policies interface {
+	Policy(flow *model.Flow) (Policy, error)
+}
+
+// WithPolicies makes plans run with the policy resolved for their flow, in
+// place of the flow's own policy
+func WithPolicies(p Policies) Option {
+	return func(plan *Plan) {
+		plan.policies = p
+	}
+}
+
+// ParsePolicy converts a flow's policy, which may be nil. Unset fields are
+// left zero.
+func ParsePolicy(p *model.Policy) (Policy, error) {
+	var policy Policy
+	if p == nil {
+		return policy, nil
+	}
+	if p.Retry != nil {
+		retry, err := ParseRetry(p.Retry)
+		if err != nil {
+			return policy, err
+		}
+		policy.Retry = retry
+	}
+	if p.Timeout != "" {
+		d, err := time.ParseDuration(p.Timeout)
+		if err != nil || d <= 0 {
+			return policy, fmt.Errorf("timeout %q is not a positive duration", p.Timeout)
+		}
+		policy.Timeout = d
+	}
+	if p.Capture != "" && model.CaptureRank(p.Capture) < 0 {
+		return policy, fmt.Errorf("capture %q must be %s, %s or %s", p.Capture, model.CaptureNone, model.CaptureSteps, model.CapturePayloads)
+	}
+	policy.Capture = p.Capture
+	return policy, nil
+}
+
+// ParseRetry converts a retry policy, of a flow or of a step
+func ParseRetry(r *model.RetryPolicy) (Retry, error) {
+	var retry Retry
+	if r.MaxAttempts < 1 {
+		return retry, errors.New("retry maxAttempts must be at least 1")
+	}
+	retry.MaxAttempts = r.MaxAttempts
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{{"backoff", r.Backoff, &retry.Backoff}, {"maxBackoff", r.MaxBackoff, &retry.MaxBackoff}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return retry, fmt.Errorf("retry %s %q is not a positive duration", d.name, d.value)
+		}
+		*d.to = parsed
+	}
+	if retry.MaxBackoff > 0 && retry.MaxBackoff < retry.Backoff {
+		return retry, errors.New("retry maxBackoff must not be below backoff")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return retry, fmt.Errorf("retry jitter %v must be between 0 and 1", r.Jitter)
+	}
+	retry.Jitter = r.Jitter
+	for _, class := range r.RetryOn {
+		if !slices.Contains(model.ErrorClasses, class) {
+			return retry, fmt.Errorf("retry retryOn %q must be one of %s", class, strings.Join(model.ErrorClasses, ", "))
+		}
+	}
+	retry.RetryOn = r.RetryOn
+	return retry, nil
+}
+
+// retries reports whether a step failing with err is retried, attempts
+// aside
+func (r Retry) retries(err error) bool {
+	return len(r.RetryOn) == 0 || slices.Contains(r.RetryOn, ErrorClass(err))
+}
+
+// wait returns the jittered wait for backoff
+func (r Retry) wait(backoff time.Duration) time.Duration {
+	if r.Jitter == 0 {
+		return backoff
+	}
+	return backoff - time.Duration(rand.Float64()*r.Jitter*float64(backoff))
+}
+
+// next returns the backoff following backoff
+func (r Retry) next(backoff time.Duration) time.Duration {
+	limit := r.MaxBackoff
+	if limit <= 0 {
+		limit = max(maxRetryBackoff, r.Backoff)
+	}
+	return min(backoff*2, limit)
+}
+
+// CaptureLevel returns the capture level, defaulting to CapturePayloads
+func (p Policy) CaptureLevel() string {
+	if p.Capture == "" {
+		return model.CapturePayloads
+	}
+	return p.Capture
+}
+
+// AllowsHost reports whether the egress allowlist admits host, which may
+// carry a port, without resolving it
+func (p Policy) AllowsHost(host string) bool {
+	if len(p.Egress) == 0 {
+		return true
+	}
+	rules, err := egress.Parse(p.Egress)
+	if err != nil {
+		return false
+	}
+	return rules.Allows(egress.SplitAddress(host))
+}
+
+// Policy returns the policy the plan runs with
+func (p *Plan) Policy() Policy {
+	return p.policy
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoQuarantine is returned by StepContext.Quarantine when the plan was
+// compiled without a Quarantine
+var ErrNoQuarantine = errors.New("no quarantine available")
+
+// Quarantined describes a message a step set aside for review
+type Quarantined struct {
+	FlowID      string
+	FlowVersion int
+	ExecutionID string
+	StepID      string
+	// Reason classifies the rejection, such as duplicate, validation or
+	// quality; Detail explains it
+	Reason string
+	Detail string
+}
+
+// Quarantine holds the messages steps reject until they are reviewed: a
+// released message carries on past the step that held it, as if the step
+// had emitted it on its default port
+type Quarantine interface {
+	Add(ctx context.Context, q Quarantined, msg *Message) error
+}
+
+// WithQuarantine makes the quarantine available to the plan's steps
+func WithQuarantine(q Quarantine) Option {
+	return func(p *Plan) {
+		p.quarantine = q
+	}
+}
+
+// Quarantine sets msg aside for review, returning once it is stored
+func (sc *StepContext) Quarantine(ctx context.Context, reason, detail string, msg *Message) error {
+	if sc.quarantine == nil {
+		return ErrNoQuarantine
+	}
+	return sc.quarantine.Add(ctx, Quarantined{
+		FlowID:      sc.FlowID,
+		FlowVersion: sc.flowVersion,
+		ExecutionID: sc.ExecutionID,
+		StepID:      sc.StepID,
+		Reason:      reason,
+		Detail:      detail,
+	}, msg)
+}
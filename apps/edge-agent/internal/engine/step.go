@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultPort is the output port followed by edges that do not name one
+const DefaultPort = ""
+
+// Step processes one message and emits zero or more messages on its output ports
+type Step interface {
+	Run(ctx context.Context, sc *StepContext, in *Message) ([]Output, error)
+}
+
+// ConnectorUser is implemented by steps that use connectors, so that flows
+// referencing unknown connectors can be rejected when saved
+type ConnectorUser interface {
+	Step
+	// Connectors returns the IDs of the connectors the step uses
+	Connectors() []string
+}
+
+// Output is a message emitted by a step on one of its ports
+type Output struct {
+	Port    string
+	Message *Message
+}
+
+// Emit returns msg as the single output on the default port
+func Emit(msg *Message) []Output {
+	return []Output{{Port: DefaultPort, Message: msg}}
+}
+
+// StepContext carries per-step execution details. It is only valid until
+// the run ends, after which it is reused by later executions.
+type StepContext struct {
+	ExecutionID string
+	FlowID      string
+	StepID      string
+	Logger      *logrus.Entry
+
+	flowVersion int
+
+	lookup     Lookuper
+	clock      clock.Clock
+	bandwidth  Bandwidth
+	egress     EgressGuard
+	batches    Batches
+	quarantine Quarantine
+	committer  Committer
+	policy     *Policy
+	meter      *meter
+	mu         sync.Mutex
+	metrics    map[string]interface{}
+
+	// runs counts the sink's invocations, keying each one
+	runs           int
+	idempotencyKey string
+
+	// entry backs Logger so that it is reused along with the context
+	entry logrus.Entry
+}
+
+// reset prepares a pooled context for stepID of a new run, deriving its
+// logger from logger without allocating once the context has been used
+func (sc *StepContext) reset(executionID string, p *Plan, stepID string, logger *logrus.Entry) {
+	sc.ExecutionID = executionID
+	sc.FlowID = p.FlowID
+	sc.flowVersion = p.FlowVersion
+	sc.StepID = stepID
+	sc.lookup = p.lookup
+	sc.clock = p.clock
+	sc.bandwidth = p.bandwidth
+	sc.egress = p.egress
+	sc.batches = p.batches
+	sc.quarantine = p.quarantine
+	sc.committer = p.committer
+	sc.policy = &p.policy
+	sc.runs = 0
+	sc.idempotencyKey = ""
+	clear(sc.metrics)
+
+	if sc.entry.Data == nil {
+		sc.entry.Data = make(logrus.Fields, len(logger.Data)+1)
+	} else {
+		clear(sc.entry.Data)
+	}
+	for k, v := range logger.Data {
+		sc.entry.Data[k] = v
+	}
+	sc.entry.Data["step_id"] = stepID
+	sc.entry.Logger = logger.Logger
+	sc.entry.Time = logger.Time
+	sc.entry.Context = logger.Context
+	sc.Logger = &sc.entry
+}
+
+// IdempotencyKey identifies the current write of a transactional sink in an
+// exactly-once flow. It is stable across redeliveries of the source message
+// and empty otherwise.
+func (sc *StepContext) IdempotencyKey() string {
+	return sc.idempotencyKey
+}
+
+// Report records a metric for the step, surfaced with the execution
+func (sc *StepContext) Report(name string, value interface{}) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.metrics == nil {
+		sc.metrics = make(map[string]interface{})
+	}
+	sc.metrics[name] = value
+}
+
+// Lookup queries reference data through a connector operation
+func (sc *StepContext) Lookup(ctx context.Context, connectorID, operation string, params map[string]interface{}) ([]map[string]interface{}, error) {
+	if sc.lookup == nil {
+		return nil, ErrNoLookup
+	}
+	if err := sc.CountCall(); err != nil {
+		return nil, err
+	}
+	return sc.lookup.Lookup(ctx, connectorID, operation, params)
+}
+
+// Clock returns the clock steps wait on; it is virtual when the agent runs
+// on simulated time
+func (sc *StepContext) Clock() clock.Clock {
+	if sc.clock == nil {
+		return clock.Real
+	}
+	return sc.clock
+}
+
+// Metrics returns the metrics reported by the step
+func (sc *StepContext) Metrics() map[string]interface{} {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if len(sc.metrics) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(sc.metrics))
+	for k, v := range sc.metrics {
+		out[k] = v
+	}
+	return out
+}
+
+// ErrUnknownStepType is returned for step types that are not registered
+var ErrUnknownStepType = errors.New("unknown step type")
+
+// StepFactory builds a step from its flow configuration
+type StepFactory func(config map[string]interface{}) (Step, error)
+
+var (
+	stepsMu sync.RWMutex
+	steps   = make(map[string]StepFactory)
+)
+
+// RegisterStep makes a step type available to flows. It panics if the
+// type is registered twice.
+func RegisterStep(stepType string, factory StepFactory) {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	if _, dup := steps[stepType]; dup {
+		panic("engine: step type registered twice: " + stepType)
+	}
+	steps[stepType] = factory
+}
+
+// StepTypes returns the registered step types, sorted
+func StepTypes() []string {
+	stepsMu.RLock()
+	defer stepsMu.RUnlock()
+
+	types := make([]string, 0, len(steps))
+	for t := range steps {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewStep builds a step of a registered type
+func NewStep(stepType string, config map[string]interface{}) (Step, error) {
+	stepsMu.RLock()
+	factory, ok := steps[stepType]
+	stepsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownStepType, stepType)
+	}
+	return factory(config)
+}
+
+// DecodeConfig decodes a step or trigger configuration map into out, which
+// should be a pointer to a struct with json tags
+func DecodeConfig(config map[string]interface{}, out interface{}) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultMaxBufferSize bounds the stream bodies buffered in memory for
+// steps that cannot stream
+const DefaultMaxBufferSize = 64 << 20
+
+// ErrBodyTooLarge is returned when a stream body exceeds the buffer limit
+var ErrBodyTooLarge = errors.New("message body is too large to buffer")
+
+// ErrStreamConsumed is returned when a stream body is read twice
+var ErrStreamConsumed = errors.New("message stream was already consumed")
+
+// StreamingStep is implemented by steps that read bodies through
+// Message.Reader. Other steps receive stream bodies buffered into Body.
+type StreamingStep interface {
+	Step
+	Streaming() bool
+}
+
+// NewStreamMessage creates a message whose body is read from r. Size is the
+// body length, or -1 when unknown. The engine closes r once the message is
+// consumed or released.
+func NewStreamMessage(r io.ReadCloser, contentType string, size int64) *Message {
+	return &Message{
+		ContentType: contentType,
+		stream:      &stream{src: r, size: size},
+	}
+}
+
+// WithStream returns a copy of m's headers carrying a stream body
+func (m *Message) WithStream(r io.ReadCloser, contentType string, size int64) *Message {
+	c := NewStreamMessage(r, contentType, size)
+	c.Headers = copyHeaders(m.Headers)
+	return c
+}
+
+// IsStream reports whether the body has not been buffered into Body
+func (m *Message) IsStream() bool {
+	return m.stream != nil
+}
+
+// Size returns the body length, or -1 when a stream's length is unknown
+func (m *Message) Size() int64 {
+	if m.stream == nil {
+		return int64(len(m.Body))
+	}
+	return m.stream.size
+}
+
+// Buffer reads a stream body into Body, failing with ErrBodyTooLarge when it
+// exceeds limit bytes. It is a no-op for in-memory messages.
+func (m *Message) Buffer(limit int64) error {
+	if m.stream == nil {
+		return nil
+	}
+	if m.stream.size > limit {
+		m.Release()
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrBodyTooLarge, m.stream.size, limit)
+	}
+	r, err := m.stream.reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to read message stream: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return fmt.Errorf("%w: exceeds the %d byte limit", ErrBodyTooLarge, limit)
+	}
+	m.Body = body
+	m.stream = nil
+	return nil
+}
+
+// Release frees a stream body that will not be read. It is safe to call
+// more than once and on in-memory messages.
+func (m *Message) Release() {
+	if m.stream != nil {
+		m.stream.release()
+	}
+}
+
+// stream is a single-use body source. Cloning spools the source to a
+// temporary file shared by reference count, so fan-out never buffers the
+// body in memory.
+type stream struct {
+	mu       sync.Mutex
+	src      io.ReadCloser
+	size     int64
+	spool    *spoolFile
+	consumed bool
+	released bool
+}
+
+// spoolFile is a temporary copy of a stream shared by clones
+type spoolFile struct {
+	mu   sync.Mutex
+	path string
+	refs int
+}
+
+func (s *stream) reader() (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.consumed || s.released {
+		return nil, ErrStreamConsumed
+	}
+	s.consumed = true
+	if s.spool == nil {
+		return &streamReader{ReadCloser: s.src, s: s}, nil
+	}
+	f, err := os.Open(s.spool.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spooled body: %w", err)
+	}
+	return &streamReader{ReadCloser: f, s: s}, nil
+}
+
+func (s *stream) clone() *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spool == nil {
+		if s.consumed || s.released {
+			return &stream{src: failing(ErrStreamConsumed), size: s.size}
+		}
+		spool, err := spoolToDisk(s.src)
+		s.src.Close()
+		if err != nil {
+			// Both copies surface the failure when read
+			s.src = failing(err)
+			return &stream{src: failing(err), size: s.size}
+		}
+		s.spool = spool
+	}
+	s.spool.acquire()
+	return &stream{size: s.size, spool: s.spool}
+}
+
+func (s *stream) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	if s.spool != nil {
+		s.spool.releaseRef()
+	} else if !s.consumed {
+		s.src.Close()
+	}
+}
+
+// spoolToDisk copies r into a temporary file with one reference
+func spoolToDisk(r io.Reader) (*spoolFile, error) {
+	f, err := os.CreateTemp("", "fusionflow-spool-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to spool message stream: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to spool message stream: %w", err)
+	}
+	return &spoolFile{path: f.Name(), refs: 1}, nil
+}
+
+func (f *spoolFile) acquire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refs++
+}
+
+func (f *spoolFile) releaseRef() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.refs--
+	if f.refs == 0 {
+		os.Remove(f.path)
+	}
+}
+
+// streamReader releases its stream when closed
+type streamReader struct {
+	io.ReadCloser
+	s    *stream
+	once sync.Once
+}
+
+func (r *streamReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.s.release)
+	return err
+}
+
+// failing returns a reader whose reads fail with err
+func failing(err error) io.ReadCloser {
+	return io.NopCloser(&errReader{err})
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
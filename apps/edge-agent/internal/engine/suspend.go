@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// ErrNotSuspended is returned when resuming an execution that is not
+// waiting, or with the wrong token
+var ErrNotSuspended = errors.New("execution is not waiting to be resumed")
+
+// Suspend is returned by a step's Run to suspend the execution until it is
+// resumed with Token, or Timeout elapses when positive. The step must
+// implement Resumable. Data is recorded with the suspension and passed back
+// on resume. Kind, such as "task", classifies the wait so that waits of a
+// kind can be listed.
+type Suspend struct {
+	Token   string
+	Timeout time.Duration
+	Kind    string
+	Data    map[string]interface{}
+}
+
+func (s *Suspend) Error() string {
+	return "execution suspended"
+}
+
+// Signal resumes a suspended step: either a message delivered with the
+// suspension's token, or the timeout
+type Signal struct {
+	// Message is the resume payload; nil on timeout or when resumed
+	// without one
+	Message  *Message
+	TimedOut bool
+	// Data is the data the step suspended with
+	Data map[string]interface{}
+}
+
+// Resumable is implemented by steps that suspend executions. Resume is
+// called with the message the step suspended on, once signalled, and
+// returns the step's outputs as Run would.
+type Resumable interface {
+	Step
+	Resume(ctx context.Context, sc *StepContext, in *Message, signal Signal) ([]Output, error)
+}
+
+// Suspension is the state of a suspended run, enough to resume it in
+// another process. Stream bodies are buffered into it.
+type Suspension struct {
+	ExecutionID string                 `json:"executionId"`
+	FlowID      string                 `json:"flowId"`
+	StepID      string                 `json:"stepId"`
+	Token       string                 `json:"token"`
+	Kind        string                 `json:"kind,omitempty"`
+	Deadline    *time.Time             `json:"deadline,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+	SuspendedAt time.Time              `json:"suspendedAt"`
+	// Message is the input of the suspended step
+	Message *Message `json:"message"`
+	// Pending are the messages queued for other steps of the run
+	Pending []PendingStep `json:"pending,omitempty"`
+	// MessageKey keys the sinks of exactly-once runs
+	MessageKey string `json:"messageKey,omitempty"`
+	// Staged names the two-phase sinks whose writes are held uncommitted
+	// by the process that suspended, until the resumed run completes
+	Staged []string `json:"staged,omitempty"`
+	// Ran names the steps that ran before the run suspended, when the
+	// flow's policy does not capture them in the execution record
+	Ran []string `json:"ran,omitempty"`
+	// Usage is what the execution consumed of its budget before suspending
+	Usage model.ExecutionUsage `json:"usage"`
+}
+
+// PendingStep is a message queued for a step when the run suspended
+type PendingStep struct {
+	StepID  string   `json:"stepId"`
+	Message *Message `json:"message"`
+}
+
+// Wait summarizes the suspension for the execution record
+func (s *Suspension) Wait() *model.ExecutionWait {
+	return &model.ExecutionWait{StepID: s.StepID, Token: s.Token, Kind: s.Kind, Since: s.SuspendedAt, Deadline: s.Deadline, Data: s.Data}
+}
+
+// Suspensions persists the state of suspended executions
+type Suspensions interface {
+	Save(ctx context.Context, s *Suspension) error
+}
+
+// suspension captures the run at a step that returned susp, buffering the
+// messages it keeps
+func (p *Plan) suspension(executionID string, it runItem, queued []runItem, susp *Suspend, msgKey string) (*Suspension, error) {
+	if _, ok := p.steps[it.stepID].(Resumable); !ok {
+		return nil, fmt.Errorf("step %s cannot suspend executions", it.stepID)
+	}
+	if susp.Token == "" {
+		return nil, fmt.Errorf("step %s suspended without a token", it.stepID)
+	}
+	now := p.clock.Now().UTC()
+	s := &Suspension{
+		ExecutionID: executionID,
+		FlowID:      p.FlowID,
+		StepID:      it.stepID,
+		Token:       susp.Token,
+		Kind:        susp.Kind,
+		Data:        susp.Data,
+		SuspendedAt: now,
+		Message:     it.msg,
+		MessageKey:  msgKey,
+	}
+	if susp.Timeout > 0 {
+		deadline := now.Add(susp.Timeout)
+		s.Deadline = &deadline
+	}
+	if err := it.msg.Buffer(p.maxBuffer); err != nil {
+		return nil, err
+	}
+	for _, q := range queued {
+		if err := q.msg.Buffer(p.maxBuffer); err != nil {
+			return nil, err
+		}
+		s.Pending = append(s.Pending, PendingStep{StepID: q.stepID, Message: q.msg})
+	}
+	return s, nil
+}
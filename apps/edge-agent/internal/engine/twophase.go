@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// TwoPhaseSink is implemented by sinks that can stage their writes. The
+// engine prepares every such sink as the flow runs and commits them together
+// once all steps have succeeded, rolling the staged writes back if any step
+// fails, so writes to several systems land together.
+type TwoPhaseSink interface {
+	Sink
+	// Prepare stages the write of in and returns the step's outputs along
+	// with the pending transaction
+	Prepare(ctx context.Context, sc *StepContext, in *Message) (SinkTx, []Output, error)
+}
+
+// SinkTx is a staged sink write
+type SinkTx interface {
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// pendingTx is a prepared write awaiting the end of the run
+type pendingTx struct {
+	stepID string
+	tx     SinkTx
+}
+
+// held keeps the writes staged by suspended runs, by execution ID, for the
+// run to commit once it resumes and completes. Staged writes cannot be
+// persisted, so they are held only by the process that suspended.
+var held = struct {
+	sync.Mutex
+	txs map[string][]pendingTx
+}{txs: make(map[string][]pendingTx)}
+
+// hold keeps the writes of a suspended run of execution id
+func hold(id string, pending []pendingTx) {
+	held.Lock()
+	held.txs[id] = pending
+	held.Unlock()
+}
+
+// takeHeld returns the writes held for execution id; ok is false when this
+// process does not hold them
+func takeHeld(id string) (pending []pendingTx, ok bool) {
+	held.Lock()
+	defer held.Unlock()
+	pending, ok = held.txs[id]
+	delete(held.txs, id)
+	return pending, ok
+}
+
+// commitAll commits the prepared writes in the order they were staged. When
+// a commit fails the remaining writes are rolled back; writes committed
+// before it cannot be undone and are named in the error.
+func commitAll(ctx context.Context, pending []pendingTx) error {
+	for i, p := range pending {
+		if err := p.tx.Commit(ctx); err != nil {
+			err = fmt.Errorf("failed to commit step %s: %w", p.stepID, err)
+			rollbackErr := rollbackAll(ctx, pending[i+1:])
+			if i > 0 {
+				committed := make([]string, 0, i)
+				for _, done := range pending[:i] {
+					committed = append(committed, done.stepID)
+				}
+				err = fmt.Errorf("%w (already committed: %v)", err, committed)
+			}
+			return errors.Join(err, rollbackErr)
+		}
+	}
+	return nil
+}
+
+// rollbackAll discards prepared writes, newest first
+func rollbackAll(ctx context.Context, pending []pendingTx) error {
+	var errs []error
+	for i := len(pending) - 1; i >= 0; i-- {
+		if err := pending[i].tx.Rollback(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to roll back step %s: %w", pending[i].stepID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Warmer is implemented by steps that can prepare their resources, such as
+// connections or output directories, before the first message arrives
+type Warmer interface {
+	Warm(ctx context.Context) error
+}
+
+// Warm prepares every step implementing Warmer so that the first execution
+// does not pay for it. All steps are attempted; failures are joined.
+func (p *Plan) Warm(ctx context.Context) error {
+	var errs []error
+	for _, id := range p.order {
+		w, ok := p.steps[id].(Warmer)
+		if !ok {
+			continue
+		}
+		if err := w.Warm(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to warm step %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
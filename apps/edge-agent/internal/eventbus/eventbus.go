@@ -0,0 +1,74 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Backends of the event bus
+const (
+	BackendMemory = "memory"
+	BackendNATS   = "nats"
+	BackendRedis  = "redis"
+)
+
+// ErrNoSubscribers is returned by the in-memory bus for events published to
+// a topic nobody subscribes to
+var ErrNoSubscribers = errors.New("no subscribers for topic")
+
+// ErrFull is returned by the in-memory bus for events published while the
+// queue they belong to is full, so that publishers can refuse them
+var ErrFull = errors.New("event bus queue is full")
+
+// Event is a message carried by the bus
+type Event struct {
+	Topic   string
+	Headers map[string]string
+	Data    []byte
+	// Ordered events sharing a Key are handled one at a time, in the order
+	// they were published, on buses whose Ordered reports true. Other
+	// events may be handled concurrently.
+	Ordered bool
+	Key     string
+}
+
+// Handler processes an event delivered to a subscription. Returning an
+// error leaves the event to be redelivered, on backends that can; others
+// log and drop it.
+type Handler func(ctx context.Context, ev *Event) error
+
+// Subscription is a handler receiving the events of a topic
+type Subscription interface {
+	// Close stops the deliveries and waits for the handlers in progress
+	Close() error
+}
+
+// Bus carries events from publishers to subscribers. Subscribers of a topic
+// sharing a group receive each event once between them, so replicas of the
+// agent subscribing under the same group share the work.
+type Bus interface {
+	Publish(ctx context.Context, ev *Event) error
+	Subscribe(ctx context.Context, topic, group string, h Handler) (Subscription, error)
+	// Ordered reports whether the bus keeps the order of ordered events
+	// across all the subscribers of a group
+	Ordered() bool
+	Close() error
+}
+
+// New connects to the bus of the configured backend
+func New(cfg config.EventBusConfig, logger *logrus.Logger) (Bus, error) {
+	switch cfg.Backend {
+	case BackendMemory:
+		return NewMemory(logger), nil
+	case BackendNATS:
+		return NewNATS(cfg, logger)
+	case BackendRedis:
+		return NewRedis(cfg, logger)
+	default:
+		return nil, fmt.Errorf("unsupported event bus backend %q", cfg.Backend)
+	}
+}
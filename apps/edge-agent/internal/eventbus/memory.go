@@ -0,0 +1,212 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// In-memory bus tuning
+const (
+	// memoryPartitions is how many queues each group spreads the events of
+	// a topic over; the queues are handled concurrently
+	memoryPartitions = 16
+	// memoryQueueSize bounds the events waiting in a queue; past it events
+	// are refused with ErrFull
+	memoryQueueSize = 256
+	// memoryRetryMax bounds the wait before a failed event is retried
+	memoryRetryMax = 5 * time.Second
+)
+
+// Memory is a bus within one process. Each group spreads the events of a
+// topic over queues, ordered events by their key and others in turn, and
+// hands the events of each queue one at a time to its subscribers. An event
+// whose handler fails is retried before the rest of its queue, and events
+// are refused while their queue is full. Events are lost if the process
+// exits, or the group's last subscriber leaves, before they are handled.
+type Memory struct {
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	topics map[string]map[string]*memoryGroup
+	closed bool
+}
+
+// memoryGroup is the subscribers of a topic sharing a group
+type memoryGroup struct {
+	subs []*memorySub
+	next int
+	// queues are drained until done is closed, when the last subscriber
+	// leaves
+	queues [memoryPartitions]chan *Event
+	spread int
+	done   chan struct{}
+}
+
+type memorySub struct {
+	bus     *Memory
+	topic   string
+	group   string
+	handler Handler
+	wg      sync.WaitGroup
+}
+
+// NewMemory creates an in-memory bus
+func NewMemory(logger *logrus.Logger) *Memory {
+	return &Memory{logger: logger, topics: make(map[string]map[string]*memoryGroup)}
+}
+
+// Publish implements Bus
+func (b *Memory) Publish(ctx context.Context, ev *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("event bus is closed")
+	}
+	groups := b.topics[ev.Topic]
+	if len(groups) == 0 {
+		return fmt.Errorf("%w %s", ErrNoSubscribers, ev.Topic)
+	}
+	// Queues are only sent to under mu, so each group either takes the
+	// event or none does
+	queues := make([]chan *Event, 0, len(groups))
+	for _, g := range groups {
+		q := g.queue(ev)
+		if len(q) == cap(q) {
+			return fmt.Errorf("%w for %s", ErrFull, ev.Topic)
+		}
+		queues = append(queues, q)
+	}
+	for _, q := range queues {
+		q <- ev
+	}
+	return nil
+}
+
+// queue returns the queue of ev; the caller holds mu
+func (g *memoryGroup) queue(ev *Event) chan *Event {
+	if ev.Ordered {
+		h := fnv.New32a()
+		h.Write([]byte(ev.Key))
+		return g.queues[h.Sum32()%memoryPartitions]
+	}
+	g.spread++
+	return g.queues[g.spread%memoryPartitions]
+}
+
+// drain hands the events of q to the group's subscribers until the group
+// is done
+func (b *Memory) drain(topic string, g *memoryGroup, q chan *Event) {
+	for {
+		select {
+		case <-g.done:
+			return
+		case ev := <-q:
+			b.deliver(topic, g, ev)
+		}
+	}
+}
+
+// deliver runs a subscriber's handler for ev until it succeeds or the
+// group is done
+func (b *Memory) deliver(topic string, g *memoryGroup, ev *Event) {
+	log := b.logger.WithField("topic", topic)
+	wait := 100 * time.Millisecond
+	for {
+		b.mu.Lock()
+		if len(g.subs) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		sub := g.subs[g.next%len(g.subs)]
+		g.next++
+		// Added under mu, so that Close waits for it once sub is removed
+		sub.wg.Add(1)
+		b.mu.Unlock()
+
+		err := sub.handler(context.Background(), ev)
+		sub.wg.Done()
+		if err == nil {
+			return
+		}
+		log.Warnf("Retrying event in %s: %v", wait, err)
+		select {
+		case <-g.done:
+			log.Errorf("Dropping event: %v", err)
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > memoryRetryMax {
+			wait = memoryRetryMax
+		}
+	}
+}
+
+// Subscribe implements Bus
+func (b *Memory) Subscribe(ctx context.Context, topic, group string, h Handler) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, fmt.Errorf("event bus is closed")
+	}
+	groups := b.topics[topic]
+	if groups == nil {
+		groups = make(map[string]*memoryGroup)
+		b.topics[topic] = groups
+	}
+	g := groups[group]
+	if g == nil {
+		g = &memoryGroup{done: make(chan struct{})}
+		for i := range g.queues {
+			g.queues[i] = make(chan *Event, memoryQueueSize)
+			go b.drain(topic, g, g.queues[i])
+		}
+		groups[group] = g
+	}
+	sub := &memorySub{bus: b, topic: topic, group: group, handler: h}
+	g.subs = append(g.subs, sub)
+	return sub, nil
+}
+
+// Close implements Subscription
+func (s *memorySub) Close() error {
+	b := s.bus
+	b.mu.Lock()
+	if g := b.topics[s.topic][s.group]; g != nil {
+		for i, other := range g.subs {
+			if other == s {
+				g.subs = append(g.subs[:i], g.subs[i+1:]...)
+				break
+			}
+		}
+		if len(g.subs) == 0 {
+			close(g.done)
+			delete(b.topics[s.topic], s.group)
+		}
+		if len(b.topics[s.topic]) == 0 {
+			delete(b.topics, s.topic)
+		}
+	}
+	b.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// Ordered implements Bus
+func (b *Memory) Ordered() bool { return true }
+
+// Close implements Bus; queued events are still handled until their
+// subscriptions close
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
@@ -0,0 +1,109 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// NATS is a bus over core NATS. Topics are subjects under the configured
+// prefix and groups are queue groups. Delivery is at most once: events
+// published while no subscriber is connected, or whose handler fails, are
+// lost.
+type NATS struct {
+	conn   *nats.Conn
+	prefix string
+	logger *logrus.Logger
+}
+
+// NewNATS connects to the NATS server at cfg.URL, reconnecting for as long
+// as the agent runs
+func NewNATS(cfg config.EventBusConfig, logger *logrus.Logger) (*NATS, error) {
+	conn, err := nats.Connect(cfg.URL,
+		nats.Name("fusionflow-edge-agent"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warnf("Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			logger.Infof("Reconnected to NATS at %s", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	return &NATS{conn: conn, prefix: cfg.Prefix, logger: logger}, nil
+}
+
+func (b *NATS) subject(topic string) string {
+	return b.prefix + "." + topic
+}
+
+// Publish implements Bus
+func (b *NATS) Publish(ctx context.Context, ev *Event) error {
+	msg := nats.NewMsg(b.subject(ev.Topic))
+	// Headers are set as is, since Set would canonicalize their names
+	for k, v := range ev.Headers {
+		msg.Header[k] = []string{v}
+	}
+	msg.Data = ev.Data
+	if err := b.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	return nil
+}
+
+// Subscribe implements Bus
+func (b *NATS) Subscribe(ctx context.Context, topic, group string, h Handler) (Subscription, error) {
+	s := &natsSub{}
+	// NATS calls back one event at a time per subscription; handle each in
+	// its own goroutine so a flow's executions run side by side
+	sub, err := b.conn.QueueSubscribe(b.subject(topic), group, func(msg *nats.Msg) {
+		ev := &Event{Topic: topic, Headers: make(map[string]string, len(msg.Header)), Data: msg.Data}
+		for k, v := range msg.Header {
+			if len(v) > 0 {
+				ev.Headers[k] = v[0]
+			}
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := h(context.Background(), ev); err != nil {
+				b.logger.WithField("topic", topic).Errorf("Dropping event: %v", err)
+			}
+		}()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to NATS: %w", err)
+	}
+	s.sub = sub
+	return s, nil
+}
+
+type natsSub struct {
+	sub *nats.Subscription
+	wg  sync.WaitGroup
+}
+
+// Close implements Subscription
+func (s *natsSub) Close() error {
+	err := s.sub.Unsubscribe()
+	s.wg.Wait()
+	return err
+}
+
+// Ordered implements Bus. A queue group hands the events of a key to
+// whichever subscriber is free, so the events of a key may be handled
+// concurrently by different replicas.
+func (b *NATS) Ordered() bool { return false }
+
+// Close implements Bus, flushing published events first
+func (b *NATS) Close() error {
+	return b.conn.Drain()
+}
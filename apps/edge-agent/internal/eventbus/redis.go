@@ -0,0 +1,222 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// Redis stream tuning
+const (
+	// redisBlock bounds each blocking read, so closed subscriptions stop
+	redisBlock = 5 * time.Second
+	// redisBatch is the number of entries read at once
+	redisBatch = 16
+)
+
+// Redis is a bus over Redis Streams. Topics are streams under the
+// configured prefix and groups are consumer groups. Entries are
+// acknowledged once handled; entries left pending by a consumer that failed
+// or went away are claimed by another one of the group after ClaimIdle
+// seconds, so delivery is at least once.
+type Redis struct {
+	client    *redis.Client
+	prefix    string
+	maxLen    int64
+	claimIdle time.Duration
+	consumer  string
+	logger    *logrus.Logger
+}
+
+// NewRedis connects to the Redis server at cfg.URL, a redis:// or
+// rediss:// URL
+func NewRedis(cfg config.EventBusConfig, logger *logrus.Logger) (*Redis, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	return &Redis{
+		client:    client,
+		prefix:    cfg.Prefix,
+		maxLen:    cfg.MaxLen,
+		claimIdle: time.Duration(cfg.ClaimIdle) * time.Second,
+		consumer:  ids.New(hostname),
+		logger:    logger,
+	}, nil
+}
+
+func (b *Redis) stream(topic string) string {
+	return b.prefix + ":" + topic
+}
+
+// Publish implements Bus
+func (b *Redis) Publish(ctx context.Context, ev *Event) error {
+	headers, err := json.Marshal(ev.Headers)
+	if err != nil {
+		return err
+	}
+	args := &redis.XAddArgs{
+		Stream: b.stream(ev.Topic),
+		Values: map[string]interface{}{"headers": headers, "data": ev.Data},
+	}
+	if b.maxLen > 0 {
+		args.MaxLen, args.Approx = b.maxLen, true
+	}
+	if err := b.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to publish to redis: %w", err)
+	}
+	return nil
+}
+
+// Subscribe implements Bus
+func (b *Redis) Subscribe(ctx context.Context, topic, group string, h Handler) (Subscription, error) {
+	stream := b.stream(topic)
+	// New groups start at the end of the stream; existing ones carry on
+	// where the group left off
+	err := b.client.XGroupCreateMkStream(ctx, stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("failed to create redis consumer group: %w", err)
+	}
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	s := &redisSub{bus: b, topic: topic, stream: stream, group: group, handler: h, cancel: cancel, done: make(chan struct{})}
+	go s.run(subCtx)
+	return s, nil
+}
+
+type redisSub struct {
+	bus     *Redis
+	topic   string
+	stream  string
+	group   string
+	handler Handler
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// run reads new entries, and claims stale ones, until ctx ends
+func (s *redisSub) run(ctx context.Context) {
+	defer close(s.done)
+	log := s.bus.logger.WithField("topic", s.topic)
+	lastClaim := time.Now()
+	for ctx.Err() == nil {
+		if s.bus.claimIdle > 0 && time.Since(lastClaim) >= s.bus.claimIdle {
+			lastClaim = time.Now()
+			s.claim(ctx)
+		}
+
+		streams, err := s.bus.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.bus.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    redisBatch,
+			Block:    redisBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to read from redis: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, st := range streams {
+			s.handle(st.Messages)
+		}
+	}
+	s.wg.Wait()
+}
+
+// claim takes over the entries other consumers of the group left pending
+// for longer than the claim idle time
+func (s *redisSub) claim(ctx context.Context) {
+	start := "0-0"
+	for {
+		msgs, next, err := s.bus.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			Consumer: s.bus.consumer,
+			MinIdle:  s.bus.claimIdle,
+			Start:    start,
+			Count:    redisBatch,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.bus.logger.WithField("topic", s.topic).Warnf("Failed to claim pending redis entries: %v", err)
+			}
+			return
+		}
+		s.handle(msgs)
+		if next == "0-0" || len(msgs) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// handle runs the handler for each entry in its own goroutine, and
+// acknowledges the entries handled without error
+func (s *redisSub) handle(msgs []redis.XMessage) {
+	for _, msg := range msgs {
+		ev := &Event{Topic: s.topic}
+		if data, ok := msg.Values["data"].(string); ok {
+			ev.Data = []byte(data)
+		}
+		if headers, ok := msg.Values["headers"].(string); ok {
+			json.Unmarshal([]byte(headers), &ev.Headers)
+		}
+		id := msg.ID
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			log := s.bus.logger.WithField("topic", s.topic)
+			if err := s.handler(context.Background(), ev); err != nil {
+				log.Errorf("Leaving event %s for redelivery: %v", id, err)
+				return
+			}
+			if err := s.bus.client.XAck(context.Background(), s.stream, s.group, id).Err(); err != nil {
+				log.Warnf("Failed to acknowledge event %s: %v", id, err)
+			}
+		}()
+	}
+}
+
+// Close implements Subscription
+func (s *redisSub) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// Ordered implements Bus. Consumers of a group read whichever entries are
+// next, and claim those left pending, so the entries of a key may be
+// handled concurrently or out of order.
+func (b *Redis) Ordered() bool { return false }
+
+// Close implements Bus
+func (b *Redis) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,165 @@
+package executions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrIdempotencyConflict is returned when an idempotency key is reused for
+// a different request
+var ErrIdempotencyConflict = errors.New("idempotency key was used for a different request")
+
+// idempotencyPurgeInterval is how often expired idempotency keys are removed
+const idempotencyPurgeInterval = 10 * time.Minute
+
+// Idempotency remembers the execution started for each Idempotency-Key of
+// the API for a TTL, so that a client retrying a request gets the original
+// execution back instead of starting another
+type Idempotency struct {
+	store  store.Store
+	ttl    time.Duration
+	logger *logrus.Logger
+}
+
+// claimGrace is how long the execution a key started may go unrecorded,
+// while the request that claimed the key queues it
+const claimGrace = time.Minute
+
+// idempotencyKey is a stored key: the fingerprint of the request that
+// claimed it and the execution it started
+type idempotencyKey struct {
+	Fingerprint string    `json:"fingerprint"`
+	ExecutionID string    `json:"executionId"`
+	ClaimedAt   time.Time `json:"claimedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// NewIdempotency creates idempotency keys that expire after ttl
+func NewIdempotency(st store.Store, ttl time.Duration, logger *logrus.Logger) *Idempotency {
+	return &Idempotency{store: st, ttl: ttl, logger: logger}
+}
+
+// Claim records that key starts execution id for the request fingerprint,
+// and returns id. When the key is already held it returns the execution it
+// started instead, or ErrIdempotencyConflict for a request with another
+// fingerprint. A key whose execution exists does not report once
+// claimGrace has passed is claimed again, as the request that claimed it
+// failed before queueing it.
+func (i *Idempotency) Claim(ctx context.Context, key, fingerprint, id string, exists func(id string) bool) (string, error) {
+	held, err := i.claim(ctx, key, fingerprint, id, "")
+	if err != nil || held.ExecutionID == id {
+		return held.ExecutionID, err
+	}
+	if time.Since(held.ClaimedAt) < claimGrace || exists(held.ExecutionID) {
+		return held.ExecutionID, nil
+	}
+	i.logger.WithField("execution_id", held.ExecutionID).Warn("Reclaiming idempotency key whose execution was never queued")
+	held, err = i.claim(ctx, key, fingerprint, id, held.ExecutionID)
+	return held.ExecutionID, err
+}
+
+// claim stores key as starting id unless an unexpired claim holds it for
+// another execution than abandoned, and returns the claim holding the key
+func (i *Idempotency) claim(ctx context.Context, key, fingerprint, id, abandoned string) (idempotencyKey, error) {
+	now := time.Now().UTC()
+	held := idempotencyKey{Fingerprint: fingerprint, ExecutionID: id, ClaimedAt: now, ExpiresAt: now.Add(i.ttl)}
+	err := i.store.Update(ctx, func(tx store.Tx) error {
+		rec, err := tx.Get(store.BucketIdempotencyKeys, key)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return fmt.Errorf("failed to get idempotency key: %w", err)
+		default:
+			var claimed idempotencyKey
+			if err := json.Unmarshal(rec.Value, &claimed); err != nil {
+				return fmt.Errorf("failed to decode idempotency key: %w", err)
+			}
+			if now.Before(claimed.ExpiresAt) {
+				if claimed.Fingerprint != fingerprint {
+					return ErrIdempotencyConflict
+				}
+				if claimed.ExecutionID != abandoned {
+					held = claimed
+					return nil
+				}
+			}
+		}
+
+		value, err := json.Marshal(held)
+		if err != nil {
+			return fmt.Errorf("failed to encode idempotency key: %w", err)
+		}
+		if err := tx.Put(store.BucketIdempotencyKeys, &store.Record{Key: key, Value: value, CreatedAt: now}); err != nil {
+			return fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return idempotencyKey{}, err
+	}
+	return held, nil
+}
+
+// Release forgets a key whose execution could not be started, so the
+// client's retry starts it
+func (i *Idempotency) Release(ctx context.Context, key string) error {
+	err := i.store.Delete(ctx, store.BucketIdempotencyKeys, key)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Run removes expired keys until ctx is cancelled
+func (i *Idempotency) Run(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := i.Purge(ctx, now)
+			if err != nil {
+				i.logger.Errorf("Failed to purge idempotency keys: %v", err)
+			} else if n > 0 {
+				i.logger.Debugf("Purged %d expired idempotency keys", n)
+			}
+		}
+	}
+}
+
+// Purge removes the keys expired at now and returns how many it removed
+func (i *Idempotency) Purge(ctx context.Context, now time.Time) (int, error) {
+	records, err := i.store.List(ctx, store.BucketIdempotencyKeys, store.ListOptions{CreatedBefore: now.Add(-i.ttl)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list idempotency keys: %w", err)
+	}
+	removed := 0
+	err = i.store.Update(ctx, func(tx store.Tx) error {
+		removed = 0
+		for _, rec := range records {
+			// A key claimed again since it was listed is kept
+			current, err := tx.Get(store.BucketIdempotencyKeys, rec.Key)
+			if err != nil || !current.CreatedAt.Equal(rec.CreatedAt) {
+				continue
+			}
+			if err := tx.Delete(store.BucketIdempotencyKeys, rec.Key); err != nil {
+				return fmt.Errorf("failed to delete idempotency key: %w", err)
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
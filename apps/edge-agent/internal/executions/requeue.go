@@ -0,0 +1,100 @@
+package executions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/dlq"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// BucketInputs holds the inputs of executions queued in the background
+// until their run is over, keyed by execution ID
+const BucketInputs = "executions.inputs"
+
+// ErrNoInput is returned when requeueing an execution whose input was not
+// kept
+var ErrNoInput = errors.New("execution input was not kept")
+
+// keptInput is the stored input of a queued execution
+type keptInput struct {
+	// After is the step the run takes the input after, if any
+	After   string                   `json:"after,omitempty"`
+	Payload *model.DeadLetterPayload `json:"payload"`
+}
+
+// Requeuer keeps the inputs of executions queued in the background, so that
+// the executions a stopped cluster instance left queued can be queued again
+// by a peer, on the flow's current plan. It implements engine.Inputs.
+type Requeuer struct {
+	store    store.Store
+	executor *engine.Executor
+	plan     PlanFunc
+}
+
+// NewRequeuer creates a requeuer queueing executions through executor
+func NewRequeuer(st store.Store, executor *engine.Executor, plan PlanFunc) *Requeuer {
+	return &Requeuer{store: st, executor: executor, plan: plan}
+}
+
+// Keep implements engine.Inputs
+func (r *Requeuer) Keep(ctx context.Context, exec *model.Execution, after string, in *engine.Message) error {
+	value, err := json.Marshal(keptInput{After: after, Payload: dlq.Render(in)})
+	if err != nil {
+		return fmt.Errorf("failed to encode execution input: %w", err)
+	}
+	rec := &store.Record{Key: exec.ID, Value: value, Labels: map[string]string{"flow_id": exec.FlowID}}
+	if err := r.store.Put(ctx, BucketInputs, rec); err != nil {
+		return fmt.Errorf("failed to keep execution input: %w", err)
+	}
+	return nil
+}
+
+// Drop implements engine.Inputs
+func (r *Requeuer) Drop(ctx context.Context, id string) error {
+	err := r.store.Delete(ctx, BucketInputs, id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("failed to drop execution input: %w", err)
+	}
+	return nil
+}
+
+// Requeue queues exec again in this process, on its kept input and the
+// flow's current plan, keeping its ID, tenant and cause
+func (r *Requeuer) Requeue(ctx context.Context, exec *model.Execution) error {
+	rec, err := r.store.Get(ctx, BucketInputs, exec.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNoInput
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get execution input: %w", err)
+	}
+	var kept keptInput
+	if err := json.Unmarshal(rec.Value, &kept); err != nil {
+		return fmt.Errorf("failed to decode execution input: %w", err)
+	}
+	if kept.Payload == nil {
+		return ErrNoInput
+	}
+	in, err := dlq.Restore(kept.Payload)
+	if err != nil {
+		return err
+	}
+	plan, err := r.plan(ctx, exec.FlowID)
+	if err != nil {
+		in.Release()
+		return err
+	}
+	_, err = r.executor.Submit(plan, in, engine.ExecuteOptions{
+		ID:     exec.ID,
+		Tenant: exec.Tenant,
+		Cause:  exec.Cause,
+		After:  kept.After,
+		Debug:  exec.DebugLog,
+	})
+	return err
+}
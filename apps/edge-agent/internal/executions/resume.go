@@ -0,0 +1,280 @@
+package executions
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// BucketSuspensions holds the state of waiting executions, keyed by
+// execution ID
+const BucketSuspensions = "executions.suspensions"
+
+// rescanInterval is how often the Resumer reloads the deadlines of
+// suspensions, picking up those saved by other cluster replicas
+const rescanInterval = time.Minute
+
+// PlanFunc returns the plan to resume an execution of a flow on
+type PlanFunc func(ctx context.Context, flowID string) (*engine.Plan, error)
+
+// Resumer stores the suspensions of waiting executions and resumes them,
+// when given their token or once their deadline passes. It implements
+// engine.Suspensions. Taking a suspension out of the store is atomic, so an
+// execution resumes once even when replicas race to resume it.
+type Resumer struct {
+	store    store.Store
+	execs    *Service
+	executor *engine.Executor
+	plan     PlanFunc
+	clock    clock.Clock
+	logger   *logrus.Logger
+
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+	wake      chan struct{}
+}
+
+// NewResumer creates a resumer continuing executions through executor
+func NewResumer(st store.Store, execs *Service, executor *engine.Executor, plan PlanFunc, clk clock.Clock, logger *logrus.Logger) *Resumer {
+	return &Resumer{
+		store:     st,
+		execs:     execs,
+		executor:  executor,
+		plan:      plan,
+		clock:     clk,
+		logger:    logger,
+		deadlines: make(map[string]time.Time),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Save implements engine.Suspensions
+func (r *Resumer) Save(ctx context.Context, s *engine.Suspension) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode suspension: %w", err)
+	}
+	rec := &store.Record{Key: s.ExecutionID, Value: value, Labels: map[string]string{"flow_id": s.FlowID}}
+	if s.Kind != "" {
+		rec.Labels["kind"] = s.Kind
+	}
+	if err := r.store.Put(ctx, BucketSuspensions, rec); err != nil {
+		return fmt.Errorf("failed to save suspension: %w", err)
+	}
+	if s.Deadline != nil {
+		r.track(s.ExecutionID, *s.Deadline)
+	}
+	return nil
+}
+
+// Resume resumes the waiting execution id with payload, which must come with
+// the token it waits for. It returns engine.ErrNotSuspended when the
+// execution is not waiting or the token does not match.
+func (r *Resumer) Resume(ctx context.Context, id, token string, payload *engine.Message) (*model.Execution, error) {
+	return r.resume(ctx, id, engine.Signal{Message: payload}, func(s *engine.Suspension) bool {
+		return subtle.ConstantTimeCompare([]byte(s.Token), []byte(token)) == 1
+	})
+}
+
+// List returns the suspensions of waiting executions of a kind, or of all
+// kinds when kind is empty, oldest first
+func (r *Resumer) List(ctx context.Context, kind string) ([]*engine.Suspension, error) {
+	opts := store.ListOptions{}
+	if kind != "" {
+		opts.Labels = map[string]string{"kind": kind}
+	}
+	records, err := r.store.List(ctx, BucketSuspensions, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list suspensions: %w", err)
+	}
+	list := make([]*engine.Suspension, 0, len(records))
+	for _, rec := range records {
+		s, err := decodeSuspension(rec)
+		if err != nil {
+			r.logger.Errorf("Failed to decode suspension %s: %v", rec.Key, err)
+			continue
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Run resumes executions as their deadlines pass until ctx is cancelled
+func (r *Resumer) Run(ctx context.Context) {
+	r.load(ctx)
+	scanned := r.clock.Now()
+	for {
+		now := r.clock.Now()
+		wait := rescanInterval - now.Sub(scanned)
+		if next, ok := r.next(); ok {
+			wait = min(wait, next.Sub(now))
+		}
+		timer := r.clock.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.wake:
+			timer.Stop()
+			continue
+		case <-timer.C():
+		}
+		if now := r.clock.Now(); now.Sub(scanned) >= rescanInterval {
+			r.load(ctx)
+			scanned = now
+		}
+		r.timeouts(ctx)
+	}
+}
+
+// load tracks the deadlines of the stored suspensions. Deadlines already
+// tracked are kept; those of executions resumed elsewhere are dropped once
+// due.
+func (r *Resumer) load(ctx context.Context) {
+	records, err := r.store.List(ctx, BucketSuspensions, store.ListOptions{})
+	if err != nil {
+		r.logger.Errorf("Failed to list suspended executions: %v", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range records {
+		s, err := decodeSuspension(rec)
+		if err != nil {
+			r.logger.Errorf("Failed to decode suspension %s: %v", rec.Key, err)
+			continue
+		}
+		if s.Deadline != nil {
+			r.deadlines[s.ExecutionID] = *s.Deadline
+		}
+	}
+}
+
+// timeouts resumes the executions whose deadline has passed
+func (r *Resumer) timeouts(ctx context.Context) {
+	now := r.clock.Now()
+	var due []string
+	r.mu.Lock()
+	for id, deadline := range r.deadlines {
+		if !deadline.After(now) {
+			due = append(due, id)
+			delete(r.deadlines, id)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, id := range due {
+		_, err := r.resume(ctx, id, engine.Signal{TimedOut: true}, func(s *engine.Suspension) bool {
+			return s.Deadline != nil && !s.Deadline.After(now)
+		})
+		if err != nil && !errors.Is(err, engine.ErrNotSuspended) {
+			r.logger.Errorf("Failed to resume execution %s on timeout: %v", id, err)
+		}
+	}
+}
+
+// resume takes the suspension of execution id out of the store, if match
+// accepts it, and resumes the execution with signal
+func (r *Resumer) resume(ctx context.Context, id string, signal engine.Signal, match func(*engine.Suspension) bool) (*model.Execution, error) {
+	rec, err := r.store.Get(ctx, BucketSuspensions, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, engine.ErrNotSuspended
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suspension: %w", err)
+	}
+	s, err := decodeSuspension(rec)
+	if err != nil {
+		return nil, err
+	}
+	if !match(s) {
+		return nil, engine.ErrNotSuspended
+	}
+	exec, err := r.execs.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if exec.Status != model.ExecutionWaiting {
+		return nil, engine.ErrNotSuspended
+	}
+	plan, err := r.plan(ctx, s.FlowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan of flow %s: %w", s.FlowID, err)
+	}
+
+	// Take the suspension, unless another resume got there first
+	err = r.store.Update(ctx, func(tx store.Tx) error {
+		current, err := tx.Get(BucketSuspensions, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return engine.ErrNotSuspended
+		}
+		if err != nil {
+			return err
+		}
+		if !current.UpdatedAt.Equal(rec.UpdatedAt) {
+			return engine.ErrNotSuspended
+		}
+		return tx.Delete(BucketSuspensions, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	delete(r.deadlines, id)
+	r.mu.Unlock()
+
+	resumed, err := r.executor.Resume(plan, exec, s, signal)
+	if err != nil {
+		// Put the suspension back so the execution can still be resumed
+		if putErr := r.store.Put(context.WithoutCancel(ctx), BucketSuspensions, rec); putErr != nil {
+			r.logger.Errorf("Failed to restore suspension of execution %s: %v", id, putErr)
+		} else if s.Deadline != nil {
+			r.track(id, *s.Deadline)
+		}
+		return nil, fmt.Errorf("failed to resume execution %s: %w", id, err)
+	}
+	return resumed, nil
+}
+
+// track schedules the timeout of execution id
+func (r *Resumer) track(id string, deadline time.Time) {
+	r.mu.Lock()
+	r.deadlines[id] = deadline
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the earliest deadline tracked
+func (r *Resumer) next() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next time.Time
+	for _, deadline := range r.deadlines {
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func decodeSuspension(rec *store.Record) (*engine.Suspension, error) {
+	var s engine.Suspension
+	if err := json.Unmarshal(rec.Value, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode suspension %s: %w", rec.Key, err)
+	}
+	return &s, nil
+}
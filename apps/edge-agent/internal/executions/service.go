@@ -0,0 +1,391 @@
+package executions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned when an execution does not exist
+var ErrNotFound = errors.New("execution not found")
+
+// Journal records the state changes of executions along with them, such
+// as for shipping them to a control plane
+type Journal interface {
+	// Change captures the state of exec now, returning the hook that
+	// journals it within the transaction storing it
+	Change(exec *model.Execution) func(tx store.Tx) error
+}
+
+// Service stores execution records. Updates are buffered through a Batcher;
+// terminal and waiting states are committed before Record returns. It implements
+// engine.Recorder.
+type Service struct {
+	store   store.Store
+	batch   *store.Batcher
+	journal Journal
+	// requeuer, when set, queues orphaned executions again
+	requeuer *Requeuer
+	logger   *logrus.Logger
+}
+
+// NewService creates an execution service writing through batch
+func NewService(st store.Store, batch *store.Batcher, logger *logrus.Logger) *Service {
+	return &Service{store: st, batch: batch, logger: logger}
+}
+
+// SetJournal journals every state change of executions stored from now on
+// in j
+func (s *Service) SetJournal(j Journal) {
+	s.journal = j
+}
+
+// Record stores exec, enqueueing event for it unless event is empty
+func (s *Service) Record(ctx context.Context, exec *model.Execution, event string) error {
+	value, err := json.Marshal(exec)
+	if err != nil {
+		return fmt.Errorf("failed to encode execution: %w", err)
+	}
+	rec := &store.Record{
+		Key:    exec.ID,
+		Value:  value,
+		Labels: map[string]string{"flow_id": exec.FlowID, "status": exec.Status},
+	}
+	if exec.Owner != "" {
+		rec.Labels["owner"] = exec.Owner
+	}
+	if exec.Cause != nil && exec.Cause.ParentID != "" {
+		rec.Labels["parent_id"] = exec.Cause.ParentID
+	}
+	var hook func(store.Tx) error
+	if event != "" {
+		// The hook runs at flush time, so it publishes the encoded state
+		// rather than exec, which may have changed by then
+		id, payload := exec.ID, json.RawMessage(value)
+		hook = func(tx store.Tx) error {
+			return outbox.Enqueue(tx, event, id, payload)
+		}
+	}
+	if s.journal != nil {
+		hook = chain(hook, s.journal.Change(exec))
+	}
+	if exec.EndTime != nil || exec.Status == model.ExecutionWaiting {
+		return s.batch.WriteNow(ctx, store.BucketExecutions, rec, hook)
+	}
+	return s.batch.Write(ctx, store.BucketExecutions, rec, hook)
+}
+
+// chain runs hook, if any, and then next
+func chain(hook, next func(tx store.Tx) error) func(tx store.Tx) error {
+	if hook == nil {
+		return next
+	}
+	return func(tx store.Tx) error {
+		if err := hook(tx); err != nil {
+			return err
+		}
+		return next(tx)
+	}
+}
+
+// Get returns an execution, including updates not yet flushed from the
+// write batch and executions that have been archived
+func (s *Service) Get(ctx context.Context, id string) (*model.Execution, error) {
+	if rec, ok := s.batch.Pending(store.BucketExecutions, id); ok {
+		return decode(rec, false)
+	}
+	rec, archived, err := store.GetWithArchive(ctx, s.store, store.BucketExecutions, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution %s: %w", id, err)
+	}
+	return decode(rec, archived)
+}
+
+// List returns the stored executions, or the archived ones. Records that
+// cannot be decoded are logged and skipped.
+func (s *Service) List(ctx context.Context, archived bool) ([]*model.Execution, error) {
+	return s.list(ctx, archived, store.ListOptions{})
+}
+
+// Page returns the stored or archived executions matching opts, which may
+// filter on the status and flow_id labels, along with how many match it in
+// all
+func (s *Service) Page(ctx context.Context, archived bool, opts store.ListOptions) ([]*model.Execution, int, error) {
+	total, err := s.store.Count(ctx, executionsBucket(archived), opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count executions: %w", err)
+	}
+	list, err := s.list(ctx, archived, opts)
+	return list, total, err
+}
+
+// list returns the stored or archived executions matching opts
+func (s *Service) list(ctx context.Context, archived bool, opts store.ListOptions) ([]*model.Execution, error) {
+	records, err := s.store.List(ctx, executionsBucket(archived), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list executions: %w", err)
+	}
+	list := make([]*model.Execution, 0, len(records))
+	for _, rec := range records {
+		exec, err := decode(rec, archived)
+		if err != nil {
+			s.logger.Errorf("Failed to decode execution %s: %v", rec.Key, err)
+			continue
+		}
+		list = append(list, exec)
+	}
+	return list, nil
+}
+
+// SetRequeuer makes RecoverOrphans queue the executions stopped instances
+// left queued again through r, rather than failing them
+func (s *Service) SetRequeuer(r *Requeuer) {
+	s.requeuer = r
+}
+
+// RecoverOrphans recovers the unfinished executions of cluster instances
+// that alive reports as gone, since nothing will ever finish them, and
+// returns how many it failed and requeued. Queued executions are taken over
+// by owner, this instance, and queued again from their kept input; running
+// ones, and queued ones that cannot be queued again, are failed. Each is
+// re-read in a transaction so that an execution finishing or taken over
+// concurrently is left alone.
+func (s *Service) RecoverOrphans(ctx context.Context, owner string, alive func(owner string) bool) (failed, requeued int, err error) {
+	var orphans []*store.Record
+	for _, status := range []string{model.ExecutionQueued, model.ExecutionRunning} {
+		records, err := s.store.List(ctx, store.BucketExecutions, store.ListOptions{
+			Labels: map[string]string{"status": status},
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list executions: %w", err)
+		}
+		for _, rec := range records {
+			if owner := rec.Labels["owner"]; owner != "" && !alive(owner) {
+				orphans = append(orphans, rec)
+			}
+		}
+	}
+
+	for _, orphan := range orphans {
+		gone := orphan.Labels["owner"]
+		requeue := false
+		exec, err := s.updateOrphan(ctx, orphan.Key, gone, func(tx store.Tx, exec *model.Execution) (string, error) {
+			requeue = exec.Status == model.ExecutionQueued && s.requeuer != nil
+			if requeue {
+				exec.Owner = owner
+				return "", nil
+			}
+			return failOrphan(fmt.Sprintf("orphaned: instance %s stopped before the execution finished", gone))(tx, exec)
+		})
+		if err != nil {
+			return failed, requeued, fmt.Errorf("failed to recover orphaned execution %s: %w", orphan.Key, err)
+		}
+		switch {
+		case exec == nil:
+		case !requeue:
+			failed++
+		default:
+			err := s.requeuer.Requeue(ctx, exec)
+			if err == nil {
+				requeued++
+				break
+			}
+			s.logger.WithField("execution_id", exec.ID).Warnf("Failed to requeue orphaned execution: %v", err)
+			reason := fmt.Sprintf("orphaned: instance %s stopped before the execution started, and it could not be queued again: %v", gone, err)
+			exec, err = s.updateOrphan(ctx, exec.ID, owner, failOrphan(reason))
+			if err != nil {
+				return failed, requeued, fmt.Errorf("failed to fail orphaned execution %s: %w", orphan.Key, err)
+			}
+			if exec != nil {
+				failed++
+			}
+		}
+	}
+	return failed, requeued, nil
+}
+
+// updateOrphan re-reads the orphaned execution key in a transaction and,
+// unless it finished or is no longer owned by owner, stores it as change
+// leaves it, enqueueing the event change returns unless empty. It returns
+// the stored execution, or nil when it was left alone.
+func (s *Service) updateOrphan(ctx context.Context, key, owner string, change func(tx store.Tx, exec *model.Execution) (string, error)) (*model.Execution, error) {
+	var updated *model.Execution
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		updated = nil
+		rec, err := tx.Get(store.BucketExecutions, key)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exec, err := decode(rec, false)
+		if err != nil {
+			return err
+		}
+		if exec.Finished() || exec.Owner != owner {
+			return nil
+		}
+		event, err := change(tx, exec)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(exec)
+		if err != nil {
+			return fmt.Errorf("failed to encode execution: %w", err)
+		}
+		rec.Value, rec.Encoding = value, ""
+		rec.Labels["status"] = exec.Status
+		rec.Labels["owner"] = exec.Owner
+		if err := tx.Put(store.BucketExecutions, rec); err != nil {
+			return err
+		}
+		updated = exec
+		if s.journal != nil {
+			if err := s.journal.Change(exec)(tx); err != nil {
+				return err
+			}
+		}
+		if event == "" {
+			return nil
+		}
+		return outbox.Enqueue(tx, event, exec.ID, json.RawMessage(value))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// failOrphan returns the change failing an orphaned execution with reason,
+// dropping its kept input
+func failOrphan(reason string) func(tx store.Tx, exec *model.Execution) (string, error) {
+	return func(tx store.Tx, exec *model.Execution) (string, error) {
+		now := time.Now().UTC()
+		exec.Status = model.ExecutionFailed
+		exec.EndTime = &now
+		exec.Error = reason
+		if err := tx.Delete(BucketInputs, exec.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return "", err
+		}
+		return engine.EventExecutionFailed, nil
+	}
+}
+
+// Children returns the stored executions, live and archived, whose cause
+// names id as their parent, oldest first. Executions queued within the
+// write batch's flush interval may not be listed yet.
+func (s *Service) Children(ctx context.Context, id string) ([]*model.Execution, error) {
+	opts := store.ListOptions{Labels: map[string]string{"parent_id": id}}
+	var children []*model.Execution
+	for _, archived := range []bool{true, false} {
+		records, err := s.store.List(ctx, executionsBucket(archived), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list executions: %w", err)
+		}
+		for _, rec := range records {
+			exec, err := decode(rec, archived)
+			if err != nil {
+				s.logger.Errorf("Failed to decode execution %s: %v", rec.Key, err)
+				continue
+			}
+			children = append(children, exec)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool { return children[i].QueuedAt.Before(children[j].QueuedAt) })
+	return children, nil
+}
+
+// RecordLog buffers a log entry of an execution, implementing
+// engine.LogRecorder. Entries are committed with the next flush of the
+// write batch, at the latest along with the execution's terminal state.
+func (s *Service) RecordLog(ctx context.Context, log *model.ExecutionLog) error {
+	value, err := json.Marshal(log)
+	if err != nil {
+		return fmt.Errorf("failed to encode execution log: %w", err)
+	}
+	rec := &store.Record{
+		Key:       logKey(log.ExecutionID, log.Seq),
+		Value:     value,
+		Labels:    map[string]string{"execution_id": log.ExecutionID, "level": log.Level},
+		CreatedAt: log.Time,
+	}
+	if log.StepID != "" {
+		rec.Labels["step_id"] = log.StepID
+	}
+	return s.batch.Write(ctx, store.BucketExecutionLogs, rec, nil)
+}
+
+// Logs returns the stored log entries of an execution matching opts, which
+// may filter on the level and step_id labels, in the order they were logged
+// (or its reverse, when descending) along with how many match it in all.
+// The entries of archived executions are read from the archive.
+func (s *Service) Logs(ctx context.Context, id string, opts store.ListOptions) ([]*model.ExecutionLog, int, error) {
+	opts.Prefix = id + "/"
+	opts.Sort = store.SortKey
+	bucket := store.BucketExecutionLogs
+	total, err := s.store.Count(ctx, bucket, opts)
+	if err == nil && total == 0 {
+		bucket = store.ArchiveBucket(bucket)
+		total, err = s.store.Count(ctx, bucket, opts)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count logs of execution %s: %w", id, err)
+	}
+	records, err := s.store.List(ctx, bucket, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list logs of execution %s: %w", id, err)
+	}
+	logs := make([]*model.ExecutionLog, 0, len(records))
+	for _, rec := range records {
+		value, err := store.Decode(rec)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode execution log %s: %w", rec.Key, err)
+		}
+		var log model.ExecutionLog
+		if err := json.Unmarshal(value, &log); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode execution log %s: %w", rec.Key, err)
+		}
+		logs = append(logs, &log)
+	}
+	return logs, total, nil
+}
+
+// logKey is the key of a log entry, ordering the entries of an execution
+func logKey(id string, seq int64) string {
+	return fmt.Sprintf("%s/%019d", id, seq)
+}
+
+// decode unmarshals a stored execution, decompressing archived records
+func decode(rec *store.Record, archived bool) (*model.Execution, error) {
+	value, err := store.Decode(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode execution %s: %w", rec.Key, err)
+	}
+	var exec model.Execution
+	if err := json.Unmarshal(value, &exec); err != nil {
+		return nil, fmt.Errorf("failed to decode execution %s: %w", rec.Key, err)
+	}
+	exec.Archived = archived
+	return &exec, nil
+}
+
+// executionsBucket is the bucket of stored or archived executions
+func executionsBucket(archived bool) string {
+	if archived {
+		return store.ArchiveBucket(store.BucketExecutions)
+	}
+	return store.BucketExecutions
+}
@@ -0,0 +1,207 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/forward"
+	"github.com/sirupsen/logrus"
+)
+
+// Destination stores exported files under slash-separated names
+type Destination interface {
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// newDestination returns the object store of cfg when a bucket is set,
+// otherwise its local directory
+func newDestination(cfg config.ExportConfig) (Destination, error) {
+	if cfg.S3.Bucket != "" {
+		return newObjectStore(cfg.S3), nil
+	}
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("export requires a dir or an s3 bucket")
+	}
+	return localDir(cfg.Dir), nil
+}
+
+// localDir writes files below a directory, such as a mounted share
+type localDir string
+
+// Put writes the file beside its destination and renames it into place, so
+// readers never see a partial file
+func (d localDir) Put(ctx context.Context, name string, data []byte) error {
+	target := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return nil
+}
+
+// objectStore uploads files to an S3-compatible bucket, signing requests
+// with AWS Signature Version 4
+type objectStore struct {
+	cfg    config.ExportS3Config
+	client *http.Client
+}
+
+func newObjectStore(cfg config.ExportS3Config) *objectStore {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &objectStore{cfg: cfg, client: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+// Put uploads the file as one object below the configured prefix
+func (o *objectStore) Put(ctx context.Context, name string, data []byte) error {
+	key := path.Join(o.cfg.Prefix, name)
+	var target string
+	if o.cfg.PathStyle {
+		target = o.cfg.Endpoint + "/" + o.cfg.Bucket + "/" + escapePath(key)
+	} else {
+		scheme, host, _ := strings.Cut(o.cfg.Endpoint, "://")
+		target = scheme + "://" + o.cfg.Bucket + "." + host + "/" + escapePath(key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	o.sign(req, data, time.Now().UTC())
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 authorization of req
+func (o *objectStore) sign(req *http.Request, payload []byte, now time.Time) {
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + o.cfg.Region + "/s3/aws4_request"
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	key := []byte("AWS4" + o.cfg.SecretAccessKey)
+	for _, part := range []string{date, o.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes an object key as Signature Version 4 expects:
+// every byte but unreserved characters and the slashes between segments
+func escapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// KindExport is the kind of buffered export files
+const KindExport = "export"
+
+// exportFile is a buffered export file
+type exportFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// forwardedDestination puts files to next, buffering those it cannot
+// reach. Files queue behind buffered ones so they arrive in order.
+type forwardedDestination struct {
+	buf    *forward.Buffer
+	next   Destination
+	logger *logrus.Logger
+}
+
+func newForwardedDestination(buf *forward.Buffer, next Destination, logger *logrus.Logger) *forwardedDestination {
+	d := &forwardedDestination{buf: buf, next: next, logger: logger}
+	buf.Register(KindExport, func(ctx context.Context, rec forward.Record) error {
+		var f exportFile
+		if err := json.Unmarshal(rec.Data, &f); err != nil {
+			return err
+		}
+		return next.Put(ctx, f.Name, f.Data)
+	})
+	return d
+}
+
+func (d *forwardedDestination) Put(ctx context.Context, name string, data []byte) error {
+	if !d.buf.Pending() {
+		err := d.next.Put(ctx, name, data)
+		if !forward.Unreachable(err) {
+			return err
+		}
+		d.logger.Warnf("Export destination unreachable; buffering %s until it is back: %v", name, err)
+	}
+	return d.buf.Append(KindExport, exportFile{Name: name, Data: data})
+}
@@ -0,0 +1,268 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/forward"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// watermarkKey is the key in store.BucketMeta of the end time up to
+	// which executions have been exported
+	watermarkKey = "export.watermark"
+
+	// claimKey is the cluster claim of the replica that exports
+	claimKey = "export"
+)
+
+// Exporter writes finished executions and their logs to files partitioned
+// by date and flow, for analysis in a warehouse rather than on the agent.
+// Executions are exported once, in the run after they finish, unless a
+// crash between writing files and recording the watermark exports them
+// again.
+type Exporter struct {
+	executions *executions.Service
+	store      store.Store
+	cfg        config.ExportConfig
+	format     Format
+	dest       Destination
+	claimer    Claimer
+	logger     *logrus.Logger
+}
+
+// Claimer decides which replica of a cluster exports
+type Claimer interface {
+	// Claim reports whether this replica holds key
+	Claim(ctx context.Context, key string) (bool, error)
+}
+
+// Result describes one export run
+type Result struct {
+	Executions int      `json:"executions"`
+	Logs       int      `json:"logs"`
+	Files      []string `json:"files,omitempty"`
+}
+
+// watermark is the stored progress of the exporter
+type watermark struct {
+	EndTime time.Time `json:"endTime"`
+}
+
+// NewExporter creates an exporter writing to the destination configured in
+// cfg
+func NewExporter(execs *executions.Service, st store.Store, cfg config.ExportConfig, logger *logrus.Logger) (*Exporter, error) {
+	format, ok := formats[cfg.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format %q", cfg.Format)
+	}
+	dest, err := newDestination(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{executions: execs, store: st, cfg: cfg, format: format, dest: dest, logger: logger}, nil
+}
+
+// SetForward buffers the files whose destination is unreachable in buf,
+// uploading them once it is back
+func (e *Exporter) SetForward(buf *forward.Buffer) {
+	e.dest = newForwardedDestination(buf, e.dest, e.logger)
+}
+
+// SetClaimer makes a single replica of a cluster export, the one holding
+// the export claim
+func (e *Exporter) SetClaimer(c Claimer) {
+	e.claimer = c
+}
+
+// Run exports on the configured interval until ctx is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(e.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			result, err := e.ExportOnce(ctx, now)
+			if err != nil {
+				e.logger.Errorf("Failed to export executions: %v", err)
+			}
+			if result.Executions > 0 {
+				e.logger.WithFields(logrus.Fields{
+					"executions": result.Executions,
+					"logs":       result.Logs,
+					"files":      len(result.Files),
+				}).Info("Exported executions")
+			}
+		}
+	}
+}
+
+// ExportOnce exports the executions that finished since the last run and at
+// least the configured delay before now
+func (e *Exporter) ExportOnce(ctx context.Context, now time.Time) (Result, error) {
+	var result Result
+	if e.claimer != nil {
+		held, err := e.claimer.Claim(ctx, claimKey)
+		if err != nil || !held {
+			return result, err
+		}
+	}
+	since, err := e.watermark(ctx)
+	if err != nil {
+		return result, err
+	}
+	until := now.Add(-time.Duration(e.cfg.Delay) * time.Second).UTC()
+	if !until.After(since) {
+		return result, nil
+	}
+
+	execs, err := e.finished(ctx, since, until)
+	if err != nil {
+		return result, err
+	}
+	if len(execs) == 0 {
+		return result, e.setWatermark(ctx, until)
+	}
+
+	// One file per partition, named after the run so files of earlier runs
+	// in the same partition are kept
+	partitions := make(map[string][]*model.Execution)
+	for _, exec := range execs {
+		p := partition(exec)
+		partitions[p] = append(partitions[p], exec)
+	}
+	names := make([]string, 0, len(partitions))
+	for p := range partitions {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	run := until.Format("20060102T150405Z")
+
+	for _, p := range names {
+		group := partitions[p]
+		name := path.Join("executions", p, fmt.Sprintf("executions-%s.%s", run, e.format.Extension()))
+		if err := e.write(ctx, name, executionTable(group)); err != nil {
+			return result, err
+		}
+		result.Files = append(result.Files, name)
+
+		if e.cfg.Logs {
+			logs, err := e.logs(ctx, group)
+			if err != nil {
+				return result, err
+			}
+			if len(logs.Records) > 0 {
+				name := path.Join("logs", p, fmt.Sprintf("logs-%s.%s", run, e.format.Extension()))
+				if err := e.write(ctx, name, logs); err != nil {
+					return result, err
+				}
+				result.Files = append(result.Files, name)
+				result.Logs += len(logs.Records)
+			}
+		}
+		result.Executions += len(group)
+	}
+	return result, e.setWatermark(ctx, until)
+}
+
+// finished returns the executions, live or archived, that reached a terminal
+// status in (since, until], ordered by end time
+func (e *Exporter) finished(ctx context.Context, since, until time.Time) ([]*model.Execution, error) {
+	var execs []*model.Execution
+	seen := make(map[string]bool)
+	for _, archived := range []bool{false, true} {
+		all, err := e.executions.List(ctx, archived)
+		if err != nil {
+			return nil, err
+		}
+		for _, exec := range all {
+			// A crash while archiving can leave both copies
+			if seen[exec.ID] || !exec.Finished() || exec.EndTime == nil {
+				continue
+			}
+			if !exec.EndTime.After(since) || exec.EndTime.After(until) {
+				continue
+			}
+			seen[exec.ID] = true
+			execs = append(execs, exec)
+		}
+	}
+	sort.Slice(execs, func(i, j int) bool { return execs[i].EndTime.Before(*execs[j].EndTime) })
+	return execs, nil
+}
+
+// logs returns the log entries of execs as a table
+func (e *Exporter) logs(ctx context.Context, execs []*model.Execution) (*Table, error) {
+	t := &Table{Columns: logColumns}
+	for _, exec := range execs {
+		logs, _, err := e.executions.Logs(ctx, exec.ID, store.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			t.Records = append(t.Records, log)
+			t.Rows = append(t.Rows, logRow(exec, log))
+		}
+	}
+	return t, nil
+}
+
+// write encodes t and stores it as name
+func (e *Exporter) write(ctx context.Context, name string, t *Table) error {
+	var buf bytes.Buffer
+	if err := e.format.Encode(&buf, t); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	if err := e.dest.Put(ctx, name, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// watermark returns the end time up to which executions were exported
+func (e *Exporter) watermark(ctx context.Context) (time.Time, error) {
+	rec, err := e.store.Get(ctx, store.BucketMeta, watermarkKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read export watermark: %w", err)
+	}
+	var w watermark
+	if err := json.Unmarshal(rec.Value, &w); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode export watermark: %w", err)
+	}
+	return w.EndTime, nil
+}
+
+// setWatermark records that executions ending up to t were exported
+func (e *Exporter) setWatermark(ctx context.Context, t time.Time) error {
+	value, err := json.Marshal(watermark{EndTime: t})
+	if err != nil {
+		return fmt.Errorf("failed to encode export watermark: %w", err)
+	}
+	if err := e.store.Put(ctx, store.BucketMeta, &store.Record{Key: watermarkKey, Value: value}); err != nil {
+		return fmt.Errorf("failed to store export watermark: %w", err)
+	}
+	return nil
+}
+
+// partition is the Hive-style directory of an execution: the UTC date it
+// ended and its flow
+func partition(exec *model.Execution) string {
+	return path.Join("date="+exec.EndTime.UTC().Format("2006-01-02"), "flow="+exec.FlowID)
+}
@@ -0,0 +1,157 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Column types of a table
+const (
+	typeString    = "string"
+	typeInt64     = "int64"
+	typeTimestamp = "timestamp"
+)
+
+// Column is a column of the flat schema of an exported table
+type Column struct {
+	Name string
+	Type string
+}
+
+// Table is the content of one exported file: the records as they are
+// stored, and the same records flattened to rows of Columns for columnar
+// formats. Timestamps are Unix milliseconds and nested values JSON text.
+type Table struct {
+	Columns []Column
+	Records []interface{}
+	Rows    []map[string]interface{}
+}
+
+// Format encodes tables into files
+type Format interface {
+	// Extension is the file name extension, without the dot
+	Extension() string
+	Encode(w io.Writer, t *Table) error
+}
+
+var formats = map[string]Format{"ndjson": ndjson{}}
+
+// registerFormat makes a format available under name. Formats depending on
+// heavier libraries live in their own files and register from init.
+func registerFormat(name string, f Format) {
+	if _, dup := formats[name]; dup {
+		panic("export: registerFormat called twice for format " + name)
+	}
+	formats[name] = f
+}
+
+// ndjson writes one JSON record per line, keeping every field of the record
+type ndjson struct{}
+
+func (ndjson) Extension() string { return "ndjson" }
+
+func (ndjson) Encode(w io.Writer, t *Table) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range t.Records {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	return nil
+}
+
+var executionColumns = []Column{
+	{"id", typeString},
+	{"flow_id", typeString},
+	{"flow_version", typeInt64},
+	{"tenant", typeString},
+	{"status", typeString},
+	{"owner", typeString},
+	{"parent_id", typeString},
+	{"queued_at", typeTimestamp},
+	{"start_time", typeTimestamp},
+	{"end_time", typeTimestamp},
+	{"duration_ms", typeInt64},
+	{"error", typeString},
+	{"steps", typeString},
+	{"usage", typeString},
+}
+
+var logColumns = []Column{
+	{"execution_id", typeString},
+	{"flow_id", typeString},
+	{"seq", typeInt64},
+	{"time", typeTimestamp},
+	{"level", typeString},
+	{"step_id", typeString},
+	{"message", typeString},
+	{"fields", typeString},
+}
+
+// executionTable returns execs as a table
+func executionTable(execs []*model.Execution) *Table {
+	t := &Table{Columns: executionColumns}
+	for _, exec := range execs {
+		row := map[string]interface{}{
+			"id":           exec.ID,
+			"flow_id":      exec.FlowID,
+			"flow_version": int64(exec.FlowVersion),
+			"tenant":       optional(exec.Tenant),
+			"status":       exec.Status,
+			"owner":        optional(exec.Owner),
+			"queued_at":    exec.QueuedAt.UnixMilli(),
+			"error":        optional(exec.Error),
+			"steps":        jsonText(exec.Steps),
+			"usage":        jsonText(exec.Usage),
+		}
+		if exec.Cause != nil {
+			row["parent_id"] = optional(exec.Cause.ParentID)
+		}
+		if exec.StartTime != nil {
+			row["start_time"] = exec.StartTime.UnixMilli()
+		}
+		if exec.EndTime != nil {
+			row["end_time"] = exec.EndTime.UnixMilli()
+			if exec.StartTime != nil {
+				row["duration_ms"] = exec.EndTime.Sub(*exec.StartTime).Milliseconds()
+			}
+		}
+		t.Records = append(t.Records, exec)
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// logRow flattens a log entry of exec
+func logRow(exec *model.Execution, log *model.ExecutionLog) map[string]interface{} {
+	return map[string]interface{}{
+		"execution_id": log.ExecutionID,
+		"flow_id":      exec.FlowID,
+		"seq":          log.Seq,
+		"time":         log.Time.UnixMilli(),
+		"level":        log.Level,
+		"step_id":      optional(log.StepID),
+		"message":      log.Message,
+		"fields":       jsonText(log.Fields),
+	}
+}
+
+// optional maps an empty string to a null value
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// jsonText encodes a nested value as JSON, or null when it is empty
+func jsonText(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" || string(data) == "[]" || string(data) == "{}" {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,65 @@
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func init() {
+	registerFormat("parquet", parquetFormat{})
+}
+
+// parquetFormat writes the rows of a table with a flat schema of optional
+// columns, compressed with Snappy
+type parquetFormat struct{}
+
+func (parquetFormat) Extension() string { return "parquet" }
+
+func (parquetFormat) Encode(w io.Writer, t *Table) error {
+	group := make(parquet.Group, len(t.Columns))
+	for _, c := range t.Columns {
+		var node parquet.Node
+		switch c.Type {
+		case typeInt64:
+			node = parquet.Int(64)
+		case typeTimestamp:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			node = parquet.String()
+		}
+		group[c.Name] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("row", group)
+	// Group fields are ordered by name, which fixes the column indexes
+	leaves := schema.Columns()
+
+	writer := parquet.NewWriter(w, schema, parquet.Compression(&parquet.Snappy))
+	batch := make([]parquet.Row, 0, 256)
+	for _, row := range t.Rows {
+		values := make(parquet.Row, len(leaves))
+		for col, path := range leaves {
+			v := row[path[0]]
+			if v == nil {
+				values[col] = parquet.Value{}.Level(0, 0, col)
+				continue
+			}
+			values[col] = parquet.ValueOf(v).Level(0, 1, col)
+		}
+		batch = append(batch, values)
+		if len(batch) == cap(batch) {
+			if _, err := writer.WriteRows(batch); err != nil {
+				return fmt.Errorf("failed to write parquet rows: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if _, err := writer.WriteRows(batch); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+	return nil
+}
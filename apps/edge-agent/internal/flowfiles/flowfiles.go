@@ -0,0 +1,318 @@
+package flowfiles
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Bucket holds the state of the flows loaded from files
+const Bucket = "flowfiles"
+
+// stateKey is the key of the state in Bucket
+const stateKey = "state"
+
+// debounce is how long the directory must be left alone before it is read
+// again, since editors and config map updates write files in several steps
+const debounce = time.Second
+
+// state records the file each flow was loaded from and its digest, so that
+// unchanged flows are not re-applied and flows whose file is gone are
+// deleted, across restarts too
+type state struct {
+	Flows map[string]source `json:"flows"`
+}
+
+// source is the file a flow was loaded from
+type source struct {
+	File   string `json:"file"`
+	Digest string `json:"digest"`
+}
+
+// file is a flow file read from the directory
+type file struct {
+	name   string
+	path   string
+	digest string
+	flow   *model.Flow
+}
+
+// Loader keeps the flows of a directory of YAML files applied and active,
+// for agents run from an immutable image without API calls. Each file
+// holds one flow; its ID defaults to the file name without extension.
+// Created and changed files are applied on their own, so an invalid file
+// leaves its flow at its last good definition without holding up others;
+// flows whose file is removed are deleted. Editing such a flow through the
+// API lasts until its file changes.
+type Loader struct {
+	dir    string
+	store  store.Store
+	flows  *flows.Service
+	logger *logrus.Logger
+}
+
+// NewLoader creates a loader of the flow files of dir
+func NewLoader(dir string, st store.Store, flowSvc *flows.Service, logger *logrus.Logger) *Loader {
+	return &Loader{dir: dir, store: st, flows: flowSvc, logger: logger}
+}
+
+// Run loads the directory, then again whenever its files change, until
+// ctx is cancelled
+func (l *Loader) Run(ctx context.Context) {
+	l.sync(ctx)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		l.logger.Errorf("Failed to watch flow directory %s; changes apply after a restart: %v", l.dir, err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(l.dir); err != nil {
+		l.logger.Errorf("Failed to watch flow directory %s; changes apply after a restart: %v", l.dir, err)
+		return
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-watcher.Events:
+			if ev.Op != fsnotify.Chmod {
+				timer.Reset(debounce)
+			}
+		case err := <-watcher.Errors:
+			l.logger.Warnf("Flow directory watch error: %v", err)
+		case <-timer.C:
+			l.sync(ctx)
+		}
+	}
+}
+
+// sync applies the flows of changed files and deletes those of removed
+// ones, logging what fails
+func (l *Loader) sync(ctx context.Context) {
+	prev, err := l.load(ctx)
+	if err != nil {
+		l.logger.Errorf("Failed to load flow files: %v", err)
+		return
+	}
+	files, failed, err := l.read()
+	if err != nil {
+		l.logger.Errorf("Failed to load flow files: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(files))
+	for id, src := range prev.Flows {
+		if failed[src.File] {
+			// Keep the flows of unreadable files at their last definition
+			seen[id] = true
+		}
+	}
+	for _, f := range files {
+		seen[f.flow.ID] = true
+		if prev.Flows[f.flow.ID] == (source{File: f.name, Digest: f.digest}) {
+			continue
+		}
+		if err := l.apply(ctx, f); err != nil {
+			l.logger.Errorf("Failed to apply flow file %s: %v", f.path, err)
+			continue
+		}
+		l.logger.Infof("Applied flow %s from %s", f.flow.ID, f.path)
+	}
+
+	var removed []string
+	for id := range prev.Flows {
+		if !seen[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	sort.Strings(removed)
+	err = l.flows.ApplyBatch(ctx, flows.Batch{
+		Delete: removed,
+		Stage: func(tx store.Tx) error {
+			return update(tx, func(st *state) {
+				for _, id := range removed {
+					delete(st.Flows, id)
+				}
+			})
+		},
+	})
+	if err != nil {
+		l.logger.Errorf("Failed to delete flows of removed files: %v", err)
+		return
+	}
+	l.logger.Infof("Deleted flows of removed files: %s", strings.Join(removed, ", "))
+}
+
+// apply applies and activates the flow of a file, recording its digest in
+// the same transaction
+func (l *Loader) apply(ctx context.Context, f *file) error {
+	return l.flows.ApplyBatch(ctx, flows.Batch{
+		Apply: []*model.Flow{f.flow},
+		Stage: func(tx store.Tx) error {
+			return update(tx, func(st *state) {
+				st.Flows[f.flow.ID] = source{File: f.name, Digest: f.digest}
+			})
+		},
+	})
+}
+
+// read reads the flow files of the directory, sorted by name. Files that
+// cannot be read are logged and returned by name as failed.
+func (l *Loader) read() ([]*file, map[string]bool, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		files  []*file
+		failed = make(map[string]bool)
+		owners = make(map[string]string)
+	)
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(l.dir, name)
+		f, err := readFile(path)
+		if err != nil {
+			l.logger.Errorf("Skipping flow file %s: %v", path, err)
+			failed[name] = true
+			continue
+		}
+		if other, ok := owners[f.flow.ID]; ok {
+			l.logger.Errorf("Skipping flow file %s: flow %s is already defined by %s", path, f.flow.ID, other)
+			failed[name] = true
+			continue
+		}
+		owners[f.flow.ID] = path
+		files = append(files, f)
+	}
+	return files, failed, nil
+}
+
+// readFile decodes the flow of a YAML file, rejecting unknown fields
+func readFile(path string) (*file, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return nil, errors.New("expected a flow definition")
+	}
+	// Flows are defined by their JSON fields
+	encoded, err := json.Marshal(normalize(raw))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.DisallowUnknownFields()
+	var flow model.Flow
+	if err := dec.Decode(&flow); err != nil {
+		return nil, fmt.Errorf("invalid flow: %w", err)
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if flow.ID == "" {
+		flow.ID = stem
+	}
+	if flow.Name == "" {
+		flow.Name = stem
+	}
+	sum := sha256.Sum256(data)
+	return &file{name: filepath.Base(path), path: path, digest: hex.EncodeToString(sum[:]), flow: &flow}, nil
+}
+
+// normalize converts the maps with non-string keys YAML may produce into
+// ones JSON can encode
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[fmt.Sprint(k)] = normalize(child)
+		}
+		return out
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = normalize(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalize(child)
+		}
+	}
+	return v
+}
+
+// load reads the state of the flows loaded from files
+func (l *Loader) load(ctx context.Context) (*state, error) {
+	rec, err := l.store.Get(ctx, Bucket, stateKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return &state{Flows: make(map[string]source)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flow file state: %w", err)
+	}
+	return decode(rec.Value)
+}
+
+// update changes the state within tx
+func update(tx store.Tx, change func(st *state)) error {
+	st := &state{Flows: make(map[string]source)}
+	rec, err := tx.Get(Bucket, stateKey)
+	switch {
+	case err == nil:
+		if st, err = decode(rec.Value); err != nil {
+			return err
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		return fmt.Errorf("failed to read flow file state: %w", err)
+	}
+	change(st)
+	value, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode flow file state: %w", err)
+	}
+	return tx.Put(Bucket, &store.Record{Key: stateKey, Value: value, CreatedAt: time.Now().UTC()})
+}
+
+func decode(value []byte) (*state, error) {
+	var st state
+	if err := json.Unmarshal(value, &st); err != nil {
+		return nil, fmt.Errorf("failed to decode flow file state: %w", err)
+	}
+	if st.Flows == nil {
+		st.Flows = make(map[string]source)
+	}
+	return &st, nil
+}
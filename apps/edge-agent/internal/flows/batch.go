@@ -0,0 +1,162 @@
+package flows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// Batch is a set of flow changes applied atomically by ApplyBatch
+type Batch struct {
+	// Apply are stored and activated as Apply does
+	Apply []*model.Flow
+	// Delete are the IDs of flows to delete; missing ones are skipped
+	Delete []string
+	// Connectors are looked up before the stored ones when validating the
+	// flows, for connectors Stage stores along with them
+	Connectors []*model.Connector
+	// Stage, when set, writes other records within the batch's transaction
+	Stage func(tx store.Tx) error
+}
+
+// ApplyBatch applies a batch of flow changes in one transaction. On any
+// failure no flow is changed and hooks that already ran are rolled back.
+func (s *Service) ApplyBatch(ctx context.Context, b Batch) error {
+	staged := make(map[string]*model.Connector, len(b.Connectors))
+	for _, conn := range b.Connectors {
+		staged[conn.ID] = conn
+	}
+	lookup := func(ctx context.Context, id string) (*model.Connector, error) {
+		if conn, ok := staged[id]; ok {
+			return conn, nil
+		}
+		if s.connectors == nil {
+			return nil, nil
+		}
+		return s.connectors(ctx, id)
+	}
+	for _, flow := range b.Apply {
+		if flow.ID == "" {
+			return fmt.Errorf("flows of a batch need an ID")
+		}
+		if err := s.validateWith(ctx, flow, lookup); err != nil {
+			return fmt.Errorf("flow %s: %w", flow.ID, err)
+		}
+	}
+	versions := make(map[string][]string, len(b.Delete))
+	for _, id := range b.Delete {
+		keys, err := s.versionKeys(ctx, id)
+		if err != nil {
+			return err
+		}
+		versions[id] = keys
+	}
+
+	// The new definitions are stored as versions first and the hooks run
+	// before the batch commits, outside any transaction, as replace explains
+	var previous []*model.Flow
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		previous = nil
+		for _, flow := range b.Apply {
+			existing, err := get(tx, flow.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			flow.Versions, flow.DraftVersion = 0, 0
+			if existing != nil {
+				if err := ensureHistory(tx, existing); err != nil {
+					return err
+				}
+				flow.Versions = existing.Versions
+				flow.CreatedAt = existing.CreatedAt
+			}
+			flow.Versions++
+			flow.Version = flow.Versions
+			if err := saveVersion(tx, flow, model.FlowVersionDraft); err != nil {
+				return err
+			}
+			if existing != nil && existing.Status == model.FlowStatusActive {
+				previous = append(previous, existing)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var activated []*model.Flow
+	rollback := func() {
+		for _, flow := range activated {
+			s.deactivateHooks(ctx, flow, len(s.hooks))
+		}
+		s.restoreHooks(ctx, previous...)
+	}
+	for _, flow := range previous {
+		// Re-register triggers against the new definitions
+		s.deactivateHooks(ctx, flow, len(s.hooks))
+	}
+	for _, flow := range b.Apply {
+		if err := s.activateHooks(ctx, flow); err != nil {
+			rollback()
+			return err
+		}
+		activated = append(activated, flow)
+	}
+
+	var deleted []*model.Flow
+	err = s.store.Update(ctx, func(tx store.Tx) error {
+		deleted = nil
+		if b.Stage != nil {
+			if err := b.Stage(tx); err != nil {
+				return err
+			}
+		}
+		for _, id := range b.Delete {
+			flow, err := get(tx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Delete(store.BucketFlows, id); err != nil {
+				return err
+			}
+			for _, key := range versions[id] {
+				if err := tx.Delete(store.BucketFlowVersions, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+					return err
+				}
+			}
+			if err := outbox.Enqueue(tx, "flow.deleted", id, flow); err != nil {
+				return err
+			}
+			deleted = append(deleted, flow)
+		}
+		for _, flow := range b.Apply {
+			if err := commitActive(tx, flow); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// The hooks succeeded but the batch did not commit
+		rollback()
+		return err
+	}
+	for _, flow := range deleted {
+		if flow.Status == model.FlowStatusActive {
+			s.deactivateHooks(ctx, flow, len(s.hooks))
+		}
+		s.plans.Invalidate(flow.ID)
+	}
+	for _, flow := range activated {
+		s.plans.Invalidate(flow.ID)
+	}
+	return nil
+}
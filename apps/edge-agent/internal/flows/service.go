@@ -0,0 +1,553 @@
+package flows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/importer"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/schema"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// ErrNotFound is returned when a flow does not exist
+var ErrNotFound = errors.New("flow not found")
+
+// ActivationHook is notified when flows are activated or deactivated, e.g.
+// to register their triggers. A failing Activate aborts the activation.
+type ActivationHook interface {
+	Activate(ctx context.Context, flow *model.Flow) error
+	Deactivate(ctx context.Context, flow *model.Flow) error
+}
+
+// Guardrails checks flows against the limits of their namespace
+type Guardrails interface {
+	// Check adds the problems of the flow's namespace and policy to v
+	Check(flow *model.Flow, v *model.ValidationError)
+	// CheckConnector returns why flow may not use conn, or nil
+	CheckConnector(flow *model.Flow, conn *model.Connector) error
+}
+
+// Service manages flow definitions in the store
+type Service struct {
+	store store.Store
+	plans *engine.PlanCache
+	hooks []ActivationHook
+	// connectors, when set, returns a connector or nil when it does not exist
+	connectors func(ctx context.Context, id string) (*model.Connector, error)
+	guardrails Guardrails
+	// unordered, when set, names the event bus that cannot keep the order
+	// of a flow's messages
+	unordered string
+}
+
+// NewService creates a flow service. Plans of updated and deleted flows are
+// evicted from plans.
+func NewService(st store.Store, plans *engine.PlanCache) *Service {
+	return &Service{store: st, plans: plans}
+}
+
+// AddHook registers an activation hook
+func (s *Service) AddHook(hook ActivationHook) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// SetConnectors makes validation reject flows whose steps reference
+// connectors for which lookup returns nil
+func (s *Service) SetConnectors(lookup func(ctx context.Context, id string) (*model.Connector, error)) {
+	s.connectors = lookup
+}
+
+// SetGuardrails makes validation reject flows that exceed the limits of
+// their namespace
+func (s *Service) SetGuardrails(g Guardrails) {
+	s.guardrails = g
+}
+
+// SetUnordered makes validation reject flows with an ordering, as the
+// event bus of the backend named cannot keep the order of their messages
+func (s *Service) SetUnordered(backend string) {
+	s.unordered = backend
+}
+
+// List returns all flows
+func (s *Service) List(ctx context.Context) ([]*model.Flow, error) {
+	return s.list(ctx, store.ListOptions{})
+}
+
+// Page returns the flows matching opts, which may filter on the status and
+// name labels, along with how many flows match it in all
+func (s *Service) Page(ctx context.Context, opts store.ListOptions) ([]*model.Flow, int, error) {
+	total, err := s.store.Count(ctx, store.BucketFlows, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count flows: %w", err)
+	}
+	flows, err := s.list(ctx, opts)
+	return flows, total, err
+}
+
+// list returns the flows matching opts
+func (s *Service) list(ctx context.Context, opts store.ListOptions) ([]*model.Flow, error) {
+	records, err := s.store.List(ctx, store.BucketFlows, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flows: %w", err)
+	}
+	flows := make([]*model.Flow, 0, len(records))
+	for _, rec := range records {
+		flow, err := decode(rec)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+// Get returns the flow with the given ID
+func (s *Service) Get(ctx context.Context, id string) (*model.Flow, error) {
+	rec, err := s.store.Get(ctx, store.BucketFlows, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flow %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// Create validates and stores a new draft flow as its first version
+func (s *Service) Create(ctx context.Context, flow *model.Flow) error {
+	if err := s.validate(ctx, flow); err != nil {
+		return err
+	}
+	flow.ID = ids.New("flow")
+	return s.store.Update(ctx, func(tx store.Tx) error {
+		return create(tx, flow)
+	})
+}
+
+// CreateAll validates and stores several new draft flows atomically
+func (s *Service) CreateAll(ctx context.Context, list []*model.Flow) error {
+	for _, flow := range list {
+		if err := s.validate(ctx, flow); err != nil {
+			return err
+		}
+	}
+	return s.store.Update(ctx, func(tx store.Tx) error {
+		for _, flow := range list {
+			flow.ID = ids.New("flow")
+			if err := create(tx, flow); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Update validates and replaces the definition of an existing flow,
+// keeping its status. The definition of an active flow is stored as its
+// draft version instead, to take effect once published; flow is then left
+// holding the stored flow, with DraftVersion set.
+func (s *Service) Update(ctx context.Context, flow *model.Flow) error {
+	if err := s.validate(ctx, flow); err != nil {
+		return err
+	}
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		existing, err := get(tx, flow.ID)
+		if err != nil {
+			return err
+		}
+		return revise(tx, flow, existing)
+	})
+	if err == nil {
+		s.plans.Invalidate(flow.ID)
+	}
+	return err
+}
+
+// Upsert validates and stores flow under its ID, creating it as a draft or
+// replacing the existing flow like Update does, and reports whether it was
+// created. It does not restart the triggers of an active flow; use Apply or
+// Publish for that.
+func (s *Service) Upsert(ctx context.Context, flow *model.Flow) (bool, error) {
+	if err := s.validate(ctx, flow); err != nil {
+		return false, err
+	}
+	created := false
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		existing, err := get(tx, flow.ID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			created = true
+			return create(tx, flow)
+		case err != nil:
+			return err
+		}
+		return revise(tx, flow, existing)
+	})
+	if err != nil {
+		return false, err
+	}
+	s.plans.Invalidate(flow.ID)
+	return created, nil
+}
+
+// Delete removes a flow and its version history, deactivating it if needed
+func (s *Service) Delete(ctx context.Context, id string) error {
+	versions, err := s.versionKeys(ctx, id)
+	if err != nil {
+		return err
+	}
+	var flow *model.Flow
+	err = s.store.Update(ctx, func(tx store.Tx) error {
+		var err error
+		if flow, err = get(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Delete(store.BucketFlows, id); err != nil {
+			return err
+		}
+		for _, key := range versions {
+			if err := tx.Delete(store.BucketFlowVersions, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+		return outbox.Enqueue(tx, "flow.deleted", id, flow)
+	})
+	if err == nil {
+		if flow.Status == model.FlowStatusActive {
+			s.deactivateHooks(ctx, flow, len(s.hooks))
+		}
+		s.plans.Invalidate(id)
+	}
+	return err
+}
+
+// Activate activates an existing flow
+func (s *Service) Activate(ctx context.Context, id string) (*model.Flow, error) {
+	flow, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	wasActive := flow.Status == model.FlowStatusActive
+	// The hooks run outside the transaction, as replace explains
+	if err := s.activateHooks(ctx, flow); err != nil {
+		return flow, err
+	}
+	err = s.store.Update(ctx, func(tx store.Tx) error {
+		return commitActive(tx, flow)
+	})
+	if err != nil && !wasActive {
+		s.deactivateHooks(ctx, flow, len(s.hooks))
+	}
+	return flow, err
+}
+
+// Deactivate deactivates an existing flow
+func (s *Service) Deactivate(ctx context.Context, id string) (*model.Flow, error) {
+	var (
+		flow      *model.Flow
+		wasActive bool
+	)
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		var err error
+		if flow, err = get(tx, id); err != nil {
+			return err
+		}
+		wasActive = flow.Status == model.FlowStatusActive
+		flow.Status = model.FlowStatusInactive
+		return save(tx, flow, "flow.deactivated")
+	})
+	if err == nil && wasActive {
+		s.deactivateHooks(ctx, flow, len(s.hooks))
+	}
+	return flow, err
+}
+
+// Apply creates the flow (or updates it when it has an ID), validates it,
+// registers its triggers and activates it as one operation, publishing the
+// definition as a new version. On any failure the stored flow is left as it
+// was and hooks that already ran are rolled back.
+func (s *Service) Apply(ctx context.Context, flow *model.Flow) error {
+	if err := s.validate(ctx, flow); err != nil {
+		return err
+	}
+	return s.replace(ctx, flow, func(tx store.Tx, existing *model.Flow) error {
+		flow.Versions, flow.DraftVersion = 0, 0
+		if existing != nil {
+			if err := ensureHistory(tx, existing); err != nil {
+				return err
+			}
+			flow.Versions = existing.Versions
+			flow.CreatedAt = existing.CreatedAt
+		}
+		flow.Versions++
+		flow.Version = flow.Versions
+		return saveVersion(tx, flow, model.FlowVersionDraft)
+	})
+}
+
+// replace activates flow in place of the stored flow with its ID, creating
+// it when it does not exist, once prepare has set up its version within a
+// transaction. The triggers of an active stored flow are re-registered
+// against the new definition. On any failure the stored flow is left as it
+// was and hooks that already ran are rolled back.
+//
+// The hooks run between two transactions rather than within one: stopping
+// a trigger waits for its in-flight executions, which record their results
+// in the store, and would deadlock on the store's lock.
+func (s *Service) replace(ctx context.Context, flow *model.Flow, prepare func(tx store.Tx, existing *model.Flow) error) error {
+	var previous *model.Flow
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		previous = nil
+		var existing *model.Flow
+		if flow.ID == "" {
+			flow.ID = ids.New("flow")
+		} else {
+			var err error
+			existing, err = get(tx, flow.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if err := prepare(tx, existing); err != nil {
+			return err
+		}
+		if existing != nil && existing.Status == model.FlowStatusActive {
+			previous = existing
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		// Re-register triggers against the new definition
+		s.deactivateHooks(ctx, previous, len(s.hooks))
+	}
+	if err := s.activateHooks(ctx, flow); err != nil {
+		s.restoreHooks(ctx, previous)
+		return err
+	}
+	err = s.store.Update(ctx, func(tx store.Tx) error {
+		return commitActive(tx, flow)
+	})
+	if err != nil {
+		// The hooks succeeded but the flow was not stored as active
+		s.deactivateHooks(ctx, flow, len(s.hooks))
+		s.restoreHooks(ctx, previous)
+		return err
+	}
+	s.plans.Invalidate(flow.ID)
+	return nil
+}
+
+// Restore runs the activation hooks of a flow stored as active, e.g. to
+// restart its triggers when the agent starts
+func (s *Service) Restore(ctx context.Context, flow *model.Flow) error {
+	if flow.Status != model.FlowStatusActive {
+		return fmt.Errorf("flow %s is not active", flow.ID)
+	}
+	for i, hook := range s.hooks {
+		if err := hook.Activate(ctx, flow); err != nil {
+			s.deactivateHooks(ctx, flow, i)
+			return fmt.Errorf("failed to restore flow %s: %w", flow.ID, err)
+		}
+	}
+	return nil
+}
+
+// activateHooks runs the activation hooks of flow, rolling back those that
+// already ran when one fails
+func (s *Service) activateHooks(ctx context.Context, flow *model.Flow) error {
+	for i, hook := range s.hooks {
+		if err := hook.Activate(ctx, flow); err != nil {
+			s.deactivateHooks(ctx, flow, i)
+			return fmt.Errorf("failed to activate flow %s: %w", flow.ID, err)
+		}
+	}
+	return nil
+}
+
+// restoreHooks runs the activation hooks of the flows still in the store
+// after a failed replacement; nil flows are skipped
+func (s *Service) restoreHooks(ctx context.Context, flows ...*model.Flow) {
+	for _, flow := range flows {
+		if flow == nil {
+			continue
+		}
+		// Best effort: the caller reports the failure that led here
+		for _, hook := range s.hooks {
+			_ = hook.Activate(ctx, flow)
+		}
+	}
+}
+
+// commitActive saves flow as active within tx, publishing the version of
+// its definition
+func commitActive(tx store.Tx, flow *model.Flow) error {
+	flow.Status = model.FlowStatusActive
+	if err := publishVersion(tx, flow); err != nil {
+		return err
+	}
+	return save(tx, flow, "flow.activated")
+}
+
+// deactivateHooks runs Deactivate on the first n hooks in reverse order
+func (s *Service) deactivateHooks(ctx context.Context, flow *model.Flow, n int) {
+	for i := n - 1; i >= 0; i-- {
+		// Deactivation is best effort; a stale trigger is preferable to a failed rollback
+		_ = s.hooks[i].Deactivate(ctx, flow)
+	}
+}
+
+// validate checks the flow's structure, its schemas and the type and
+// configuration of every step, so unknown step types, invalid step configs
+// and references to unknown connectors are rejected on save. Placeholders
+// left by the importer are accepted until the flow is activated.
+func (s *Service) validate(ctx context.Context, flow *model.Flow) error {
+	return s.validateWith(ctx, flow, s.connectors)
+}
+
+// validateWith validates flow, looking its connectors up with lookup
+func (s *Service) validateWith(ctx context.Context, flow *model.Flow, lookup func(ctx context.Context, id string) (*model.Connector, error)) error {
+	v := &model.ValidationError{}
+	var invalid *model.ValidationError
+	if err := flow.Validate(); errors.As(err, &invalid) {
+		v.Problems = append(v.Problems, invalid.Problems...)
+		v.Details = append(v.Details, invalid.Details...)
+	} else if err != nil {
+		return err
+	}
+	for _, sch := range []struct {
+		field  string
+		schema map[string]interface{}
+	}{{"inputSchema", flow.InputSchema}, {"outputSchema", flow.OutputSchema}} {
+		for _, problem := range schema.Check(sch.schema) {
+			v.Add(sch.field, model.ProblemInvalid, "%s: %s", sch.field, problem)
+		}
+	}
+	if _, err := engine.ParsePolicy(flow.Policy); err != nil {
+		v.Add("policy", model.ProblemInvalid, "policy: %v", err)
+	}
+	if _, err := engine.ParseBudget(flow.Budget); err != nil {
+		v.Add("budget", model.ProblemInvalid, "budget: %v", err)
+	}
+	if s.guardrails != nil {
+		s.guardrails.Check(flow, v)
+	}
+	if flow.Ordering != nil && s.unordered != "" {
+		v.Add("ordering", model.ProblemInvalid, "ordering is not supported by the %s event bus", s.unordered)
+	}
+	for i, step := range flow.Steps {
+		path := fmt.Sprintf("steps[%d]", i)
+		if step.Retry != nil {
+			if _, err := engine.ParseRetry(step.Retry); err != nil {
+				v.Add(path+".retry", model.ProblemInvalid, "%s (%s): %v", path, step.ID, err)
+			}
+		}
+		built, err := engine.NewStep(step.Type, step.Config)
+		switch {
+		case errors.Is(err, engine.ErrUnknownStepType) && step.Type == importer.StepTypeUnsupported:
+			// Imported placeholders are kept in drafts until replaced by
+			// hand; the engine refuses to activate them
+			continue
+		case errors.Is(err, engine.ErrUnknownStepType):
+			v.Add(path+".type", model.ProblemInvalid, "%s (%s): unknown step type %q", path, step.ID, step.Type)
+			continue
+		case err != nil:
+			v.Add(path+".config", model.ProblemInvalid, "%s (%s): %v", path, step.ID, err)
+			continue
+		}
+		if flow.Delivery == model.DeliveryExactlyOnce && !engine.SupportsExactlyOnce(built) {
+			v.Add(path+".type", model.ProblemInvalid, "%s (%s): %s does not support exactly-once delivery", path, step.ID, step.Type)
+		}
+		user, ok := built.(engine.ConnectorUser)
+		if !ok || lookup == nil {
+			continue
+		}
+		for _, id := range user.Connectors() {
+			conn, err := lookup(ctx, id)
+			switch {
+			case err != nil:
+				return fmt.Errorf("failed to look up connector %s: %w", id, err)
+			case conn == nil:
+				v.Add(path+".config.connector", model.ProblemUnknownConnector, "%s (%s): connector %q does not exist", path, step.ID, id)
+			case s.guardrails != nil:
+				if err := s.guardrails.CheckConnector(flow, conn); err != nil {
+					v.Add(path+".config.connector", model.ProblemNotAllowed, "%s (%s): %v", path, step.ID, err)
+				}
+			}
+		}
+	}
+	return v.Err()
+}
+
+// get reads a flow within tx
+func get(tx store.Tx, id string) (*model.Flow, error) {
+	rec, err := tx.Get(store.BucketFlows, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flow %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// create saves flow as a new draft within tx, with its definition as
+// version 1
+func create(tx store.Tx, flow *model.Flow) error {
+	flow.Status = model.FlowStatusDraft
+	flow.CreatedAt = time.Time{}
+	flow.Version, flow.Versions, flow.DraftVersion = 1, 1, 0
+	if err := save(tx, flow, "flow.created"); err != nil {
+		return err
+	}
+	return saveVersion(tx, flow, model.FlowVersionDraft)
+}
+
+// save writes flow and an outbox event of eventType within tx
+func save(tx store.Tx, flow *model.Flow, eventType string) error {
+	now := time.Now().UTC()
+	if flow.CreatedAt.IsZero() {
+		flow.CreatedAt = now
+	}
+	flow.UpdatedAt = now
+	if flow.Delivery == "" {
+		flow.Delivery = model.DeliveryAtLeastOnce
+	}
+
+	value, err := json.Marshal(flow)
+	if err != nil {
+		return fmt.Errorf("failed to encode flow: %w", err)
+	}
+	rec := &store.Record{
+		Key:       flow.ID,
+		Value:     value,
+		Labels:    map[string]string{"status": flow.Status, "name": flow.Name},
+		CreatedAt: flow.CreatedAt,
+	}
+	if err := tx.Put(store.BucketFlows, rec); err != nil {
+		return fmt.Errorf("failed to store flow: %w", err)
+	}
+	return outbox.Enqueue(tx, eventType, flow.ID, flow)
+}
+
+// decode unmarshals a stored flow
+func decode(rec *store.Record) (*model.Flow, error) {
+	var flow model.Flow
+	if err := json.Unmarshal(rec.Value, &flow); err != nil {
+		return nil, fmt.Errorf("failed to decode flow %s: %w", rec.Key, err)
+	}
+	flow.CreatedAt = rec.CreatedAt
+	flow.UpdatedAt = rec.UpdatedAt
+	return &flow, nil
+}
@@ -0,0 +1,329 @@
+package flows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+var (
+	// ErrVersionNotFound is returned when a flow version does not exist
+	ErrVersionNotFound = errors.New("flow version not found")
+
+	// ErrVersionPublished is returned when publishing a version that
+	// already was; roll back to it instead
+	ErrVersionPublished = errors.New("flow version is already published")
+
+	// ErrVersionDraft is returned when rolling back to a version that was
+	// never published; publish it instead
+	ErrVersionDraft = errors.New("flow version is a draft")
+
+	// ErrNoPreviousVersion is returned when rolling back a flow without a
+	// published version before its current one
+	ErrNoPreviousVersion = errors.New("flow has no earlier published version")
+)
+
+// Versions returns the versions of a flow matching opts, which may filter
+// on the status label, along with how many versions match it in all. They
+// are ordered by version unless opts sorts them otherwise.
+func (s *Service) Versions(ctx context.Context, id string, opts store.ListOptions) ([]*model.FlowVersion, int, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, 0, err
+	}
+	opts.Prefix = id + "/"
+	if opts.Sort == "" {
+		opts.Sort = store.SortKey
+	}
+	total, err := s.store.Count(ctx, store.BucketFlowVersions, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count versions of flow %s: %w", id, err)
+	}
+	records, err := s.store.List(ctx, store.BucketFlowVersions, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list versions of flow %s: %w", id, err)
+	}
+	versions := make([]*model.FlowVersion, 0, len(records))
+	for _, rec := range records {
+		v, err := decodeVersion(rec)
+		if err != nil {
+			return nil, 0, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, total, nil
+}
+
+// GetVersion returns a version of a flow
+func (s *Service) GetVersion(ctx context.Context, id string, version int) (*model.FlowVersion, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	rec, err := s.store.Get(ctx, store.BucketFlowVersions, versionKey(id, version))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %d of flow %s: %w", version, id, err)
+	}
+	return decodeVersion(rec)
+}
+
+// Publish makes a draft version the definition of its flow and activates
+// the flow, re-registering its triggers. The version is validated again, as
+// the connectors it uses may have changed since it was saved.
+func (s *Service) Publish(ctx context.Context, id string, version int) (*model.Flow, error) {
+	v, err := s.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if v.Status == model.FlowVersionPublished {
+		return nil, ErrVersionPublished
+	}
+	flow := v.Definition
+	flow.ID = id
+	if err := s.validate(ctx, flow); err != nil {
+		return nil, err
+	}
+	err = s.replace(ctx, flow, func(tx store.Tx, existing *model.Flow) error {
+		if existing == nil {
+			return ErrNotFound
+		}
+		current, err := getVersion(tx, id, version)
+		if err != nil {
+			return err
+		}
+		if current.Status == model.FlowVersionPublished {
+			return ErrVersionPublished
+		}
+		flow.Version = version
+		flow.Versions = existing.Versions
+		flow.DraftVersion = existing.DraftVersion
+		if flow.DraftVersion == version {
+			flow.DraftVersion = 0
+		}
+		flow.CreatedAt = existing.CreatedAt
+		return nil
+	})
+	return flow, err
+}
+
+// Rollback makes an earlier published version the definition of the flow
+// and activates it: the given version, or with version 0 the last one
+// published before the current definition. A pending draft is kept.
+func (s *Service) Rollback(ctx context.Context, id string, version int) (*model.Flow, error) {
+	if version == 0 {
+		flow, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		records, err := s.store.List(ctx, store.BucketFlowVersions, store.ListOptions{
+			Prefix:     id + "/",
+			Labels:     map[string]string{"status": model.FlowVersionPublished},
+			Sort:       store.SortKey,
+			Descending: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of flow %s: %w", id, err)
+		}
+		for _, rec := range records {
+			v, err := decodeVersion(rec)
+			if err != nil {
+				return nil, err
+			}
+			if v.Version < flow.Version {
+				version = v.Version
+				break
+			}
+		}
+		if version == 0 {
+			return nil, ErrNoPreviousVersion
+		}
+	}
+
+	v, err := s.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if v.Status != model.FlowVersionPublished {
+		return nil, ErrVersionDraft
+	}
+	flow := v.Definition
+	flow.ID = id
+	if err := s.validate(ctx, flow); err != nil {
+		return nil, err
+	}
+	err = s.replace(ctx, flow, func(tx store.Tx, existing *model.Flow) error {
+		if existing == nil {
+			return ErrNotFound
+		}
+		flow.Version = version
+		flow.Versions = existing.Versions
+		flow.DraftVersion = existing.DraftVersion
+		flow.CreatedAt = existing.CreatedAt
+		return nil
+	})
+	return flow, err
+}
+
+// revise saves flow as the new definition of existing within tx. A flow
+// that is not active takes the definition at once, as a new draft version
+// or in place of its current one if that is still a draft. An active flow
+// keeps running its published definition: flow becomes its draft version,
+// to be published, and is left holding the stored flow.
+func revise(tx store.Tx, flow, existing *model.Flow) error {
+	if err := ensureHistory(tx, existing); err != nil {
+		return err
+	}
+	flow.Status = existing.Status
+	flow.CreatedAt = existing.CreatedAt
+	flow.Versions = existing.Versions
+
+	version := existing.DraftVersion
+	if version == 0 && existing.Status != model.FlowStatusActive {
+		current, err := getVersion(tx, existing.ID, existing.Version)
+		if err != nil {
+			return err
+		}
+		if current.Status == model.FlowVersionDraft {
+			version = existing.Version
+		}
+	}
+	if version == 0 {
+		flow.Versions++
+		version = flow.Versions
+	}
+	flow.Version = version
+	if err := saveVersion(tx, flow, model.FlowVersionDraft); err != nil {
+		return err
+	}
+
+	if existing.Status == model.FlowStatusActive {
+		existing.Versions = flow.Versions
+		existing.DraftVersion = version
+		*flow = *existing
+		return save(tx, flow, "flow.drafted")
+	}
+	flow.DraftVersion = 0
+	return save(tx, flow, "flow.updated")
+}
+
+// ensureHistory records the definition of a flow saved before it had a
+// version history as its first version
+func ensureHistory(tx store.Tx, flow *model.Flow) error {
+	if flow.Versions > 0 {
+		return nil
+	}
+	flow.Version, flow.Versions = 1, 1
+	status := model.FlowVersionDraft
+	if flow.Status == model.FlowStatusActive {
+		status = model.FlowVersionPublished
+	}
+	return saveVersion(tx, flow, status)
+}
+
+// publishVersion marks the version of flow's definition as published
+// within tx
+func publishVersion(tx store.Tx, flow *model.Flow) error {
+	if err := ensureHistory(tx, flow); err != nil {
+		return err
+	}
+	v, err := getVersion(tx, flow.ID, flow.Version)
+	if err != nil {
+		return err
+	}
+	if v.Status == model.FlowVersionPublished {
+		return nil
+	}
+	now := time.Now().UTC()
+	v.Status = model.FlowVersionPublished
+	v.PublishedAt = &now
+	return putVersion(tx, v)
+}
+
+// saveVersion records the definition of flow as its version flow.Version
+// within tx
+func saveVersion(tx store.Tx, flow *model.Flow, status string) error {
+	now := time.Now().UTC()
+	definition := *flow
+	definition.Status = ""
+	definition.Versions, definition.DraftVersion = 0, 0
+	if definition.CreatedAt.IsZero() {
+		definition.CreatedAt = now
+	}
+	definition.UpdatedAt = now
+
+	v := &model.FlowVersion{
+		FlowID:     flow.ID,
+		Version:    flow.Version,
+		Status:     status,
+		Definition: &definition,
+		CreatedAt:  now,
+	}
+	if status == model.FlowVersionPublished {
+		v.PublishedAt = &now
+	}
+	return putVersion(tx, v)
+}
+
+// getVersion reads a flow version within tx
+func getVersion(tx store.Tx, id string, version int) (*model.FlowVersion, error) {
+	rec, err := tx.Get(store.BucketFlowVersions, versionKey(id, version))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %d of flow %s: %w", version, id, err)
+	}
+	return decodeVersion(rec)
+}
+
+// putVersion writes v within tx
+func putVersion(tx store.Tx, v *model.FlowVersion) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode flow version: %w", err)
+	}
+	rec := &store.Record{
+		Key:       versionKey(v.FlowID, v.Version),
+		Value:     value,
+		Labels:    map[string]string{"flow_id": v.FlowID, "status": v.Status},
+		CreatedAt: v.CreatedAt,
+	}
+	if err := tx.Put(store.BucketFlowVersions, rec); err != nil {
+		return fmt.Errorf("failed to store flow version: %w", err)
+	}
+	return nil
+}
+
+// versionKeys returns the keys of the versions of a flow
+func (s *Service) versionKeys(ctx context.Context, id string) ([]string, error) {
+	records, err := s.store.List(ctx, store.BucketFlowVersions, store.ListOptions{Prefix: id + "/"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of flow %s: %w", id, err)
+	}
+	keys := make([]string, 0, len(records))
+	for _, rec := range records {
+		keys = append(keys, rec.Key)
+	}
+	return keys, nil
+}
+
+// decodeVersion unmarshals a stored flow version
+func decodeVersion(rec *store.Record) (*model.FlowVersion, error) {
+	var v model.FlowVersion
+	if err := json.Unmarshal(rec.Value, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode flow version %s: %w", rec.Key, err)
+	}
+	v.CreatedAt = rec.CreatedAt
+	return &v, nil
+}
+
+// versionKey is the key of a flow version, ordering versions by number
+func versionKey(id string, version int) string {
+	return fmt.Sprintf("%s/%010d", id, version)
+}
@@ -0,0 +1,98 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ContentType is the media type of FHIR JSON resources
+const ContentType = "application/fhir+json"
+
+// Resource is a FHIR R4 resource in its JSON form
+type Resource map[string]interface{}
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9\-.]{1,64}$`)
+
+// Parse decodes a JSON resource. It fails unless the document is an object
+// with a resourceType.
+func Parse(data []byte) (Resource, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var r Resource
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("fhir: invalid JSON resource: %w", err)
+	}
+	if r.Type() == "" {
+		return nil, errors.New("fhir: resourceType is required")
+	}
+	return r, nil
+}
+
+// Type returns the resourceType
+func (r Resource) Type() string {
+	s, _ := r["resourceType"].(string)
+	return s
+}
+
+// ID returns the logical id
+func (r Resource) ID() string {
+	s, _ := r["id"].(string)
+	return s
+}
+
+// Profiles returns the canonical URLs listed in meta.profile
+func (r Resource) Profiles() []string {
+	meta, _ := r["meta"].(map[string]interface{})
+	list, _ := meta["profile"].([]interface{})
+	out := make([]string, 0, len(list))
+	for _, p := range list {
+		if s, ok := p.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Bytes encodes the resource as JSON
+func (r Resource) Bytes() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Validate applies the base resource rules: a valid id and, for bundles,
+// valid entry resources. Problems are reported with FHIRPath-style locations.
+func (r Resource) Validate() []string {
+	return r.validate(r.Type())
+}
+
+func (r Resource) validate(path string) []string {
+	var problems []string
+	if id, ok := r["id"]; ok {
+		s, isString := id.(string)
+		if !isString || !idPattern.MatchString(s) {
+			problems = append(problems, fmt.Sprintf("%s.id: invalid id %v", path, id))
+		}
+	}
+	if r.Type() != "Bundle" {
+		return problems
+	}
+
+	entries, _ := r["entry"].([]interface{})
+	for i, e := range entries {
+		entry, _ := e.(map[string]interface{})
+		res, ok := entry["resource"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		child := Resource(res)
+		loc := fmt.Sprintf("%s.entry[%d].resource", path, i)
+		if child.Type() == "" {
+			problems = append(problems, loc+": resourceType is required")
+			continue
+		}
+		problems = append(problems, child.validate(loc)...)
+	}
+	return problems
+}
@@ -0,0 +1,384 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Profile is the subset of a StructureDefinition used for validation
+type Profile struct {
+	URL      string
+	Type     string
+	elements []element
+}
+
+// element is one ElementDefinition constraint
+type element struct {
+	Path    string
+	Min     int
+	Max     string
+	Types   []string
+	Fixed   interface{}
+	Pattern interface{}
+}
+
+// ParseProfile reads a StructureDefinition. The snapshot is used when
+// present, otherwise the differential. Sliced elements are not evaluated.
+func ParseProfile(data []byte) (*Profile, error) {
+	r, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return profileFrom(r)
+}
+
+func profileFrom(r Resource) (*Profile, error) {
+	if r.Type() != "StructureDefinition" {
+		return nil, fmt.Errorf("fhir: expected a StructureDefinition, got %s", r.Type())
+	}
+	p := &Profile{}
+	p.URL, _ = r["url"].(string)
+	p.Type, _ = r["type"].(string)
+	if p.URL == "" || p.Type == "" {
+		return nil, fmt.Errorf("fhir: StructureDefinition requires url and type")
+	}
+
+	defs, _ := r["snapshot"].(map[string]interface{})
+	if defs == nil {
+		defs, _ = r["differential"].(map[string]interface{})
+	}
+	list, _ := defs["element"].([]interface{})
+	for _, raw := range list {
+		def, _ := raw.(map[string]interface{})
+		path, _ := def["path"].(string)
+		id, _ := def["id"].(string)
+		if path == "" || !strings.Contains(path, ".") || strings.Contains(id, ":") {
+			continue
+		}
+
+		el := element{Path: path, Max: "*"}
+		if n, ok := def["min"].(json.Number); ok {
+			v, _ := n.Int64()
+			el.Min = int(v)
+		}
+		if s, ok := def["max"].(string); ok {
+			el.Max = s
+		}
+		types, _ := def["type"].([]interface{})
+		for _, t := range types {
+			tm, _ := t.(map[string]interface{})
+			if code, ok := tm["code"].(string); ok {
+				el.Types = append(el.Types, code)
+			}
+		}
+		for key, v := range def {
+			switch {
+			case strings.HasPrefix(key, "fixed"):
+				el.Fixed = v
+			case strings.HasPrefix(key, "pattern"):
+				el.Pattern = v
+			}
+		}
+		p.elements = append(p.elements, el)
+	}
+	return p, nil
+}
+
+// Validate checks r against the profile's cardinality, fixed and pattern
+// values and primitive types
+func (p *Profile) Validate(r Resource) []string {
+	if r.Type() != p.Type {
+		return []string{fmt.Sprintf("%s: profile %s applies to %s resources", r.Type(), p.URL, p.Type)}
+	}
+
+	var problems []string
+	for _, el := range p.elements {
+		segments := strings.Split(el.Path, ".")
+		parentPath, name := segments[1:len(segments)-1], segments[len(segments)-1]
+		for _, parent := range walk([]interface{}{map[string]interface{}(r)}, parentPath) {
+			obj, ok := parent.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			values, key := childValues(obj, name)
+			loc := strings.Join(append([]string{r.Type()}, append(parentPath, key)...), ".")
+
+			if len(values) < el.Min {
+				problems = append(problems, fmt.Sprintf("%s: minimum cardinality is %d, found %d", loc, el.Min, len(values)))
+			}
+			if el.Max != "*" {
+				if max, err := strconv.Atoi(el.Max); err == nil && len(values) > max {
+					problems = append(problems, fmt.Sprintf("%s: maximum cardinality is %d, found %d", loc, max, len(values)))
+				}
+			}
+			for _, v := range values {
+				if el.Fixed != nil && !reflect.DeepEqual(v, el.Fixed) {
+					problems = append(problems, fmt.Sprintf("%s: value must be %s", loc, compact(el.Fixed)))
+				}
+				if el.Pattern != nil && !matchesPattern(v, el.Pattern) {
+					problems = append(problems, fmt.Sprintf("%s: value must match pattern %s", loc, compact(el.Pattern)))
+				}
+				typ := choiceType(name, key, el.Types)
+				if msg := checkPrimitive(typ, v); msg != "" {
+					problems = append(problems, fmt.Sprintf("%s: %s", loc, msg))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// walk follows path from the given nodes, flattening arrays along the way
+func walk(nodes []interface{}, path []string) []interface{} {
+	for _, seg := range path {
+		var next []interface{}
+		for _, n := range nodes {
+			obj, ok := n.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			values, _ := childValues(obj, seg)
+			next = append(next, values...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// childValues returns the values of obj's child name, flattening arrays. A
+// choice element such as value[x] matches whichever valueXxx key is present;
+// the matched key is returned for error locations.
+func childValues(obj map[string]interface{}, name string) ([]interface{}, string) {
+	key := name
+	if base, ok := strings.CutSuffix(name, "[x]"); ok {
+		for k := range obj {
+			if strings.HasPrefix(k, base) && len(k) > len(base) && unicode.IsUpper(rune(k[len(base)])) {
+				key = k
+				break
+			}
+		}
+	}
+	v, ok := obj[key]
+	if !ok || v == nil {
+		return nil, key
+	}
+	if list, ok := v.([]interface{}); ok {
+		return list, key
+	}
+	return []interface{}{v}, key
+}
+
+// choiceType returns the data type of a value: the suffix of a choice key,
+// or the element's single declared type
+func choiceType(name, key string, types []string) string {
+	if base, ok := strings.CutSuffix(name, "[x]"); ok && key != name {
+		suffix := key[len(base):]
+		for _, t := range types {
+			if strings.EqualFold(t, suffix) {
+				return t
+			}
+		}
+		return strings.ToLower(suffix[:1]) + suffix[1:]
+	}
+	if len(types) == 1 {
+		return types[0]
+	}
+	return ""
+}
+
+var (
+	datePattern     = regexp.MustCompile(`^\d{4}(-(0[1-9]|1[0-2])(-(0[1-9]|[12]\d|3[01]))?)?$`)
+	dateTimePattern = regexp.MustCompile(`^\d{4}(-(0[1-9]|1[0-2])(-(0[1-9]|[12]\d|3[01])(T([01]\d|2[0-3]):[0-5]\d:([0-5]\d|60)(\.\d+)?(Z|[+-]((0\d|1[0-3]):[0-5]\d|14:00)))?)?)?$`)
+	instantPattern  = regexp.MustCompile(`^\d{4}-(0[1-9]|1[0-2])-(0[1-9]|[12]\d|3[01])T([01]\d|2[0-3]):[0-5]\d:([0-5]\d|60)(\.\d+)?(Z|[+-]((0\d|1[0-3]):[0-5]\d|14:00))$`)
+	timePattern     = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d:([0-5]\d|60)(\.\d+)?$`)
+	codePattern     = regexp.MustCompile(`^[^\s]+( [^\s]+)*$`)
+)
+
+// checkPrimitive checks v against a FHIR primitive type; complex types pass
+func checkPrimitive(typ string, v interface{}) string {
+	str, isString := v.(string)
+	switch typ {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return "expected a boolean"
+		}
+	case "integer", "positiveInt", "unsignedInt":
+		n, ok := v.(json.Number)
+		if !ok {
+			return "expected an integer"
+		}
+		i, err := n.Int64()
+		switch {
+		case err != nil:
+			return "expected an integer"
+		case typ == "positiveInt" && i < 1:
+			return "expected a positive integer"
+		case typ == "unsignedInt" && i < 0:
+			return "expected a non-negative integer"
+		}
+	case "decimal":
+		if _, ok := v.(json.Number); !ok {
+			return "expected a decimal"
+		}
+	case "string", "markdown", "uri", "url", "canonical", "base64Binary", "oid", "uuid":
+		if !isString {
+			return "expected a string"
+		}
+	case "id":
+		if !isString || !idPattern.MatchString(str) {
+			return "expected an id"
+		}
+	case "code":
+		if !isString || !codePattern.MatchString(str) {
+			return "expected a code"
+		}
+	case "date":
+		if !isString || !datePattern.MatchString(str) {
+			return "expected a date (YYYY, YYYY-MM or YYYY-MM-DD)"
+		}
+	case "dateTime":
+		if !isString || !dateTimePattern.MatchString(str) {
+			return "expected a dateTime"
+		}
+	case "instant":
+		if !isString || !instantPattern.MatchString(str) {
+			return "expected an instant"
+		}
+	case "time":
+		if !isString || !timePattern.MatchString(str) {
+			return "expected a time"
+		}
+	}
+	return ""
+}
+
+// matchesPattern reports whether v contains every value in pattern. Arrays
+// in the pattern must each be matched by some element of v's array.
+func matchesPattern(v, pattern interface{}) bool {
+	switch p := pattern.(type) {
+	case map[string]interface{}:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, pv := range p {
+			if !matchesPattern(obj[k], pv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		list, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, pv := range p {
+			found := false
+			for _, item := range list {
+				if matchesPattern(item, pv) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(v, pattern)
+	}
+}
+
+func compact(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// ProfileSet holds profiles by canonical URL
+type ProfileSet struct {
+	profiles map[string]*Profile
+}
+
+// LoadProfiles reads StructureDefinitions from files, each holding a single
+// definition or a Bundle of them
+func LoadProfiles(paths ...string) (*ProfileSet, error) {
+	set := &ProfileSet{profiles: make(map[string]*Profile)}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profile %s: %w", path, err)
+		}
+		if err := set.add(data); err != nil {
+			return nil, fmt.Errorf("failed to load profile %s: %w", path, err)
+		}
+	}
+	return set, nil
+}
+
+func (s *ProfileSet) add(data []byte) error {
+	r, err := Parse(bytes.TrimSpace(data))
+	if err != nil {
+		return err
+	}
+	defs := []Resource{r}
+	if r.Type() == "Bundle" {
+		defs = nil
+		entries, _ := r["entry"].([]interface{})
+		for _, e := range entries {
+			entry, _ := e.(map[string]interface{})
+			if res, ok := entry["resource"].(map[string]interface{}); ok {
+				defs = append(defs, Resource(res))
+			}
+		}
+	}
+	for _, def := range defs {
+		p, err := profileFrom(def)
+		if err != nil {
+			return err
+		}
+		s.profiles[p.URL] = p
+	}
+	return nil
+}
+
+// URLs returns the loaded profile URLs, sorted
+func (s *ProfileSet) URLs() []string {
+	urls := make([]string, 0, len(s.profiles))
+	for u := range s.profiles {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// Validate checks r against the base rules, every loaded profile it
+// declares in meta.profile, and the required profiles. Required profiles
+// that are not loaded are reported as problems; unknown declared ones are
+// ignored.
+func (s *ProfileSet) Validate(r Resource, required ...string) []string {
+	problems := r.Validate()
+	for _, u := range required {
+		if _, ok := s.profiles[u]; !ok {
+			problems = append(problems, fmt.Sprintf("profile %s is not loaded", u))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, u := range append(r.Profiles(), required...) {
+		p, ok := s.profiles[u]
+		if !ok || seen[u] {
+			continue
+		}
+		seen[u] = true
+		problems = append(problems, p.Validate(r)...)
+	}
+	return problems
+}
@@ -0,0 +1,308 @@
+package hl7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/ids"
+)
+
+// ContentType is the media type of HL7v2 messages in ER7 (pipe) encoding
+const ContentType = "application/hl7-v2"
+
+// Acknowledgement codes for MSA-1
+const (
+	AckAccept = "AA"
+	AckError  = "AE"
+	AckReject = "AR"
+)
+
+// Delimiters are the separator characters declared in MSH-1 and MSH-2
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+// DefaultDelimiters are the conventional |^~\& delimiters
+var DefaultDelimiters = Delimiters{Field: '|', Component: '^', Repetition: '~', Escape: '\\', Subcomponent: '&'}
+
+// encoding returns the MSH-2 encoding characters
+func (d Delimiters) encoding() string {
+	return string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+}
+
+// Message is a parsed HL7v2 message
+type Message struct {
+	Delimiters Delimiters
+	Segments   []Segment
+}
+
+// Segment is one segment of a message. Fields hold the raw, still escaped
+// field values: Fields[0] is field 1. For MSH, field 1 is the field separator
+// and field 2 the encoding characters, as in the standard's numbering.
+type Segment struct {
+	Name   string
+	Fields []string
+}
+
+// Parse parses an ER7-encoded message. Segments may be separated by CR, LF or CRLF.
+func Parse(data []byte) (*Message, error) {
+	text := strings.ReplaceAll(string(bytes.TrimSpace(data)), "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	if !strings.HasPrefix(text, "MSH") || len(text) < 8 {
+		return nil, errors.New("hl7: message must start with an MSH segment")
+	}
+
+	d := Delimiters{
+		Field:        text[3],
+		Component:    text[4],
+		Repetition:   text[5],
+		Escape:       text[6],
+		Subcomponent: text[7],
+	}
+	if d.Subcomponent == d.Field {
+		// MSH-2 may omit the subcomponent separator
+		d.Subcomponent = DefaultDelimiters.Subcomponent
+	}
+
+	m := &Message{Delimiters: d}
+	for i, line := range strings.Split(text, "\r") {
+		if line == "" {
+			continue
+		}
+		if len(line) < 3 {
+			return nil, fmt.Errorf("hl7: segment %d is too short", i+1)
+		}
+		seg := Segment{Name: line[:3]}
+		if seg.Name == "MSH" {
+			seg.Fields = append([]string{string(d.Field)}, strings.Split(line[4:], string(d.Field))...)
+		} else if len(line) > 3 {
+			if line[3] != d.Field {
+				return nil, fmt.Errorf("hl7: segment %d has an invalid name %q", i+1, line)
+			}
+			seg.Fields = strings.Split(line[4:], string(d.Field))
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+	return m, nil
+}
+
+// Bytes serializes the message in ER7 encoding with CR segment terminators
+func (m *Message) Bytes() []byte {
+	var buf bytes.Buffer
+	fs := string(m.Delimiters.Field)
+	for _, seg := range m.Segments {
+		buf.WriteString(seg.Name)
+		fields := seg.Fields
+		if seg.Name == "MSH" && len(fields) > 0 {
+			// MSH-1 is the separator itself
+			fields = fields[1:]
+		}
+		for _, f := range fields {
+			buf.WriteString(fs)
+			buf.WriteString(f)
+		}
+		buf.WriteByte('\r')
+	}
+	return buf.Bytes()
+}
+
+// Segment returns the first segment named name
+func (m *Message) Segment(name string) (*Segment, bool) {
+	for i := range m.Segments {
+		if m.Segments[i].Name == name {
+			return &m.Segments[i], true
+		}
+	}
+	return nil, false
+}
+
+// Get returns the unescaped value at a terser-style path such as "PID-5-1",
+// "MSH-9-2" or "OBX(2)-5". Components and subcomponents default to the whole
+// field; only the first field repetition is addressed. Missing values are "".
+func (m *Message) Get(path string) string {
+	parts := strings.Split(path, "-")
+	name, occurrence := parts[0], 1
+	if open := strings.IndexByte(name, '('); open > 0 && strings.HasSuffix(name, ")") {
+		n, err := strconv.Atoi(name[open+1 : len(name)-1])
+		if err != nil || n < 1 {
+			return ""
+		}
+		name, occurrence = name[:open], n
+	}
+
+	var seg *Segment
+	for i := range m.Segments {
+		if m.Segments[i].Name == name {
+			occurrence--
+			if occurrence == 0 {
+				seg = &m.Segments[i]
+				break
+			}
+		}
+	}
+	if seg == nil || len(parts) < 2 {
+		return ""
+	}
+
+	indexes := make([]int, 0, 3)
+	for _, p := range parts[1:] {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return ""
+		}
+		indexes = append(indexes, n)
+	}
+	if indexes[0] > len(seg.Fields) {
+		return ""
+	}
+	value := seg.Fields[indexes[0]-1]
+	if name == "MSH" && indexes[0] <= 2 {
+		return value
+	}
+
+	d := m.Delimiters
+	value, _, _ = strings.Cut(value, string(d.Repetition))
+	if len(indexes) > 1 {
+		value = nth(value, d.Component, indexes[1])
+	}
+	if len(indexes) > 2 {
+		value = nth(value, d.Subcomponent, indexes[2])
+	}
+	return d.UnescapeValue(value)
+}
+
+// nth returns the 1-based nth element of s split on sep
+func nth(s string, sep byte, n int) string {
+	parts := strings.Split(s, string(sep))
+	if n > len(parts) {
+		return ""
+	}
+	return parts[n-1]
+}
+
+// Type returns the message type from MSH-9, e.g. "ADT^A01"
+func (m *Message) Type() string {
+	msh, ok := m.Segment("MSH")
+	if !ok || len(msh.Fields) < 9 {
+		return ""
+	}
+	return msh.Fields[8]
+}
+
+// ControlID returns the message control ID from MSH-10
+func (m *Message) ControlID() string {
+	return m.Get("MSH-10")
+}
+
+// Ack builds an acknowledgement for m with the given MSA-1 code and optional text
+func (m *Message) Ack(code, text string) *Message {
+	d := m.Delimiters
+	msh := func(n int) string {
+		seg, ok := m.Segment("MSH")
+		if !ok || n > len(seg.Fields) {
+			return ""
+		}
+		return seg.Fields[n-1]
+	}
+
+	ackType := "ACK"
+	if trigger := m.Get("MSH-9-2"); trigger != "" {
+		ackType = strings.Join([]string{"ACK", d.EscapeValue(trigger), "ACK"}, string(d.Component))
+	}
+	header := Segment{Name: "MSH", Fields: []string{
+		string(d.Field),
+		d.encoding(),
+		msh(5), msh(6), // sending application and facility are the original receivers
+		msh(3), msh(4),
+		time.Now().UTC().Format("20060102150405"),
+		"",
+		ackType,
+		ids.New("ack"),
+		msh(11),
+		msh(12),
+	}}
+	msa := Segment{Name: "MSA", Fields: []string{code, d.EscapeValue(m.ControlID())}}
+	if text != "" {
+		msa.Fields = append(msa.Fields, d.EscapeValue(text))
+	}
+	return &Message{Delimiters: d, Segments: []Segment{header, msa}}
+}
+
+// EscapeValue escapes delimiter characters in a leaf value
+func (d Delimiters) EscapeValue(s string) string {
+	if !strings.ContainsAny(s, string([]byte{d.Field, d.Component, d.Repetition, d.Escape, d.Subcomponent})) {
+		return s
+	}
+	var b strings.Builder
+	e := string(d.Escape)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case d.Escape:
+			b.WriteString(e + "E" + e)
+		case d.Field:
+			b.WriteString(e + "F" + e)
+		case d.Component:
+			b.WriteString(e + "S" + e)
+		case d.Subcomponent:
+			b.WriteString(e + "T" + e)
+		case d.Repetition:
+			b.WriteString(e + "R" + e)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// UnescapeValue decodes the standard escape sequences in a leaf value. Unknown
+// sequences are kept verbatim.
+func (d Delimiters) UnescapeValue(s string) string {
+	if strings.IndexByte(s, d.Escape) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != d.Escape {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i+1:], d.Escape)
+		if end < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		seq := s[i+1 : i+1+end]
+		switch {
+		case seq == "F":
+			b.WriteByte(d.Field)
+		case seq == "S":
+			b.WriteByte(d.Component)
+		case seq == "T":
+			b.WriteByte(d.Subcomponent)
+		case seq == "R":
+			b.WriteByte(d.Repetition)
+		case seq == "E":
+			b.WriteByte(d.Escape)
+		case seq == ".br":
+			b.WriteByte('\n')
+		case strings.HasPrefix(seq, "X") && len(seq)%2 == 1:
+			for j := 1; j+1 < len(seq); j += 2 {
+				if v, err := strconv.ParseUint(seq[j:j+2], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+				}
+			}
+		default:
+			b.WriteString(s[i : i+end+2])
+		}
+		i += end + 1
+	}
+	return b.String()
+}
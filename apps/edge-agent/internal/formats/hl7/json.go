@@ -0,0 +1,173 @@
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Document is the JSON form of a message used by flow steps. Each field is
+// a string when it has a single value, a list of components (each a string
+// or a list of subcomponents) when it has components, or an object
+// {"repetitions": [...]} holding one such value per repetition.
+type Document struct {
+	Type       string            `json:"type,omitempty"`
+	ControlID  string            `json:"controlId,omitempty"`
+	Delimiters string            `json:"delimiters,omitempty"`
+	Segments   []DocumentSegment `json:"segments"`
+}
+
+// DocumentSegment is one segment of a Document; Fields[0] is field 1
+type DocumentSegment struct {
+	Name   string        `json:"name"`
+	Fields []interface{} `json:"fields,omitempty"`
+}
+
+// ToDocument converts m into its JSON form with values unescaped
+func (m *Message) ToDocument() *Document {
+	d := m.Delimiters
+	doc := &Document{
+		Type:       d.UnescapeValue(m.Type()),
+		ControlID:  m.ControlID(),
+		Delimiters: string(d.Field) + d.encoding(),
+	}
+	for _, seg := range m.Segments {
+		ds := DocumentSegment{Name: seg.Name, Fields: make([]interface{}, len(seg.Fields))}
+		for i, field := range seg.Fields {
+			if seg.Name == "MSH" && i < 2 {
+				ds.Fields[i] = field
+				continue
+			}
+			ds.Fields[i] = d.fieldValue(field)
+		}
+		doc.Segments = append(doc.Segments, ds)
+	}
+	return doc
+}
+
+// fieldValue converts a raw field into its JSON form
+func (d Delimiters) fieldValue(field string) interface{} {
+	reps := strings.Split(field, string(d.Repetition))
+	if len(reps) == 1 {
+		return d.repetitionValue(field)
+	}
+	values := make([]interface{}, len(reps))
+	for i, rep := range reps {
+		values[i] = d.repetitionValue(rep)
+	}
+	return map[string]interface{}{"repetitions": values}
+}
+
+// repetitionValue converts one field repetition into a string or component list
+func (d Delimiters) repetitionValue(rep string) interface{} {
+	components := strings.Split(rep, string(d.Component))
+	if len(components) == 1 && strings.IndexByte(rep, d.Subcomponent) < 0 {
+		return d.UnescapeValue(rep)
+	}
+	values := make([]interface{}, len(components))
+	for i, c := range components {
+		subs := strings.Split(c, string(d.Subcomponent))
+		if len(subs) == 1 {
+			values[i] = d.UnescapeValue(c)
+			continue
+		}
+		parts := make([]interface{}, len(subs))
+		for j, s := range subs {
+			parts[j] = d.UnescapeValue(s)
+		}
+		values[i] = parts
+	}
+	return values
+}
+
+// FromDocument converts a JSON document back into a message, escaping values
+func FromDocument(doc *Document) (*Message, error) {
+	d := DefaultDelimiters
+	if len(doc.Delimiters) >= 4 {
+		d.Field = doc.Delimiters[0]
+		d.Component = doc.Delimiters[1]
+		d.Repetition = doc.Delimiters[2]
+		d.Escape = doc.Delimiters[3]
+		if len(doc.Delimiters) >= 5 {
+			d.Subcomponent = doc.Delimiters[4]
+		}
+	}
+	if len(doc.Segments) == 0 || doc.Segments[0].Name != "MSH" {
+		return nil, errors.New("hl7: document must start with an MSH segment")
+	}
+
+	m := &Message{Delimiters: d}
+	for i, ds := range doc.Segments {
+		if len(ds.Name) != 3 {
+			return nil, fmt.Errorf("hl7: segment %d has an invalid name %q", i+1, ds.Name)
+		}
+		seg := Segment{Name: ds.Name, Fields: make([]string, len(ds.Fields))}
+		for j, value := range ds.Fields {
+			if ds.Name == "MSH" && j < 2 {
+				// The separators always come from the document's delimiters
+				seg.Fields[j] = [2]string{string(d.Field), d.encoding()}[j]
+				continue
+			}
+			field, err := d.encodeField(value)
+			if err != nil {
+				return nil, fmt.Errorf("hl7: %s-%d: %w", ds.Name, j+1, err)
+			}
+			seg.Fields[j] = field
+		}
+		if ds.Name == "MSH" && len(seg.Fields) < 2 {
+			seg.Fields = []string{string(d.Field), d.encoding()}
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+	return m, nil
+}
+
+// encodeField converts a JSON field value into its raw form
+func (d Delimiters) encodeField(value interface{}) (string, error) {
+	if obj, ok := value.(map[string]interface{}); ok {
+		reps, ok := obj["repetitions"].([]interface{})
+		if !ok {
+			return "", errors.New("field object must hold a repetitions list")
+		}
+		out := make([]string, len(reps))
+		for i, rep := range reps {
+			s, err := d.encodeRepetition(rep)
+			if err != nil {
+				return "", err
+			}
+			out[i] = s
+		}
+		return strings.Join(out, string(d.Repetition)), nil
+	}
+	return d.encodeRepetition(value)
+}
+
+// encodeRepetition converts a string or component list into its raw form
+func (d Delimiters) encodeRepetition(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return d.EscapeValue(v), nil
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, component := range v {
+			switch c := component.(type) {
+			case nil:
+			case string:
+				out[i] = d.EscapeValue(c)
+			case []interface{}:
+				subs := make([]string, len(c))
+				for j, sub := range c {
+					subs[j] = d.EscapeValue(fmt.Sprint(sub))
+				}
+				out[i] = strings.Join(subs, string(d.Subcomponent))
+			default:
+				out[i] = d.EscapeValue(fmt.Sprint(c))
+			}
+		}
+		return strings.Join(out, string(d.Component)), nil
+	default:
+		return d.EscapeValue(fmt.Sprint(v)), nil
+	}
+}
@@ -0,0 +1,67 @@
+package hl7
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MLLP framing bytes
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+)
+
+// ErrFrameTooLarge is returned when an MLLP frame exceeds the reader's limit
+var ErrFrameTooLarge = errors.New("hl7: MLLP frame too large")
+
+// ReadFrame reads one MLLP-framed message from r, returning its payload.
+// Bytes before the start block are ignored; maxSize bounds the payload.
+func ReadFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var payload []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == endBlock {
+			next, err := r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if next != carriageReturn {
+				return nil, fmt.Errorf("hl7: MLLP end block followed by 0x%02x instead of CR", next)
+			}
+			return payload, nil
+		}
+		if len(payload) >= maxSize {
+			return nil, ErrFrameTooLarge
+		}
+		payload = append(payload, b)
+	}
+}
+
+// WriteFrame writes payload to w as one MLLP frame
+func WriteFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, payload...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := w.Write(frame)
+	return err
+}
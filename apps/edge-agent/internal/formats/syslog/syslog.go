@@ -0,0 +1,202 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Facility and severity names by code, as in RFC 5424 section 6.2.1
+var (
+	facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+	severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// Message is a parsed syslog message. Version is 1 for RFC 5424 messages
+// and 0 for BSD (RFC 3164) ones, which carry no app name fields or
+// structured data; their tag is reported as AppName and ProcID.
+type Message struct {
+	Facility       int                          `json:"facility"`
+	FacilityName   string                       `json:"facilityName"`
+	Severity       int                          `json:"severity"`
+	SeverityName   string                       `json:"severityName"`
+	Version        int                          `json:"version"`
+	Timestamp      *time.Time                   `json:"timestamp,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	AppName        string                       `json:"appName,omitempty"`
+	ProcID         string                       `json:"procId,omitempty"`
+	MsgID          string                       `json:"msgId,omitempty"`
+	StructuredData map[string]map[string]string `json:"structuredData,omitempty"`
+	Message        string                       `json:"message"`
+}
+
+// Parse parses an RFC 5424 or RFC 3164 message. BSD timestamps have no
+// year, which is taken from now. A BSD message whose header cannot be
+// parsed keeps everything after the priority as its message, as RFC 3164
+// asks of relays.
+func Parse(data []byte, now time.Time) (*Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) < 3 || data[0] != '<' {
+		return nil, errors.New("syslog: message does not start with a priority")
+	}
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return nil, errors.New("syslog: malformed priority")
+	}
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, fmt.Errorf("syslog: invalid priority %q", data[1:end])
+	}
+	m := &Message{Facility: pri / 8, Severity: pri % 8}
+	m.FacilityName, m.SeverityName = facilities[m.Facility], severities[m.Severity]
+
+	rest := string(data[end+1:])
+	if strings.HasPrefix(rest, "1 ") {
+		m.Version = 1
+		if err := m.parse5424(rest[2:]); err != nil {
+			return nil, err
+		}
+	} else {
+		m.parse3164(rest, now)
+	}
+	if !utf8.ValidString(m.Message) {
+		m.Message = strings.ToValidUTF8(m.Message, "\ufffd")
+	}
+	return m, nil
+}
+
+// parse5424 parses what follows "<PRI>1 "
+func (m *Message) parse5424(s string) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 0 {
+			if i < len(fields)-1 {
+				return errors.New("syslog: truncated RFC 5424 header")
+			}
+			sp = len(s)
+		}
+		fields[i], s = s[:sp], s[min(sp+1, len(s)):]
+	}
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("syslog: invalid timestamp %q", fields[0])
+		}
+		m.Timestamp = &t
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = nilValue(fields[1]), nilValue(fields[2]), nilValue(fields[3]), nilValue(fields[4])
+
+	switch {
+	case s == "" || s == "-":
+		s = ""
+	case strings.HasPrefix(s, "- "):
+		s = s[2:]
+	case s[0] == '[':
+		sd, rest, err := parseStructuredData(s)
+		if err != nil {
+			return err
+		}
+		m.StructuredData, s = sd, strings.TrimPrefix(rest, " ")
+	default:
+		return errors.New("syslog: missing structured data")
+	}
+	m.Message = strings.TrimPrefix(s, "\ufeff")
+	return nil
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseStructuredData parses the SD-ELEMENTs at the start of s and returns
+// what follows them
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd <= 0 {
+			return nil, "", errors.New("syslog: malformed structured data")
+		}
+		params := make(map[string]string)
+		sd[s[:idEnd]] = params
+		s = s[idEnd:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return nil, "", errors.New("syslog: malformed structured data parameter")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s, closed = s[i+1:], true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", errors.New("syslog: unterminated structured data value")
+			}
+			params[name] = value.String()
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errors.New("syslog: unterminated structured data element")
+		}
+		s = s[1:]
+	}
+	return sd, s, nil
+}
+
+// parse3164 parses what follows the priority of a BSD message
+func (m *Message) parse3164(s string, now time.Time) {
+	m.Message = s
+	// Mmm dd hh:mm:ss, with days below 10 padded by a space
+	if len(s) < len(time.Stamp)+1 || s[len(time.Stamp)] != ' ' {
+		return
+	}
+	t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], now.Location())
+	if err != nil {
+		return
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.AddDate(0, 0, 1)) {
+		// Sent in December, received in January
+		t = t.AddDate(-1, 0, 0)
+	}
+	m.Timestamp = &t
+	s = s[len(time.Stamp)+1:]
+
+	if sp := strings.IndexByte(s, ' '); sp > 0 {
+		m.Hostname, s = s[:sp], s[sp+1:]
+	}
+	m.Message = s
+	// TAG[PID]: content, the tag being at most 32 alphanumeric characters
+	colon := strings.Index(s, ": ")
+	if colon <= 0 || colon > 48 || strings.ContainsAny(s[:colon], " ") {
+		return
+	}
+	tag := s[:colon]
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		m.ProcID = tag[open+1 : len(tag)-1]
+		tag = tag[:open]
+	}
+	m.AppName, m.Message = tag, s[colon+2:]
+}
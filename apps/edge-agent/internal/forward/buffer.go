@@ -0,0 +1,562 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrClosed is returned for records appended once the buffer is closed
+var ErrClosed = errors.New("forward buffer is closed")
+
+const (
+	// frameHeader is the length and CRC-32 preceding every record
+	frameHeader = 8
+	// maxRetryDelay caps the delay between attempts to drain the buffer
+	maxRetryDelay = 5 * time.Minute
+	// cursorFile records how far the buffer has been delivered
+	cursorFile = "cursor"
+)
+
+// Record is an outbound write held until its target is reachable
+type Record struct {
+	// Kind selects the deliverer of the record
+	Kind      string          `json:"kind"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Deliverer sends a record to its target. Records failing with an error
+// for which Unreachable holds are retried later, in order; other errors
+// drop the record.
+type Deliverer func(ctx context.Context, rec Record) error
+
+// Buffer is a durable store-and-forward buffer: a log of segment files in
+// a directory, appended to and synced before Append returns, and drained
+// in order to the deliverers of each record's kind. A segment is removed
+// once delivered. Past the size limit the oldest segment is dropped, and
+// records older than the age limit are dropped rather than delivered, so
+// a long outage loses the oldest data first.
+type Buffer struct {
+	name         string
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+	maxAge       time.Duration
+	retry        time.Duration
+	logger       *logrus.Entry
+
+	mu         sync.Mutex
+	deliverers map[string]Deliverer
+	segments   []*segment
+	head       *os.File
+	cursor     cursor
+	closed     bool
+	dropped    int64
+	delivered  int64
+
+	// draining serialises deliveries
+	draining sync.Mutex
+	wake     chan struct{}
+}
+
+// segment is one file of the log, named after its sequence number
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+// cursor is the position of the next record to deliver
+type cursor struct {
+	Seq    uint64 `json:"seq"`
+	Offset int64  `json:"offset"`
+}
+
+// Open opens the buffer name, a directory of cfg.Dir, picking up the
+// records a previous run left undelivered. Each target gets a buffer of
+// its own, so that one unreachable target does not hold up the others.
+func Open(cfg config.ForwardConfig, name string, logger *logrus.Logger) (*Buffer, error) {
+	dir := filepath.Join(cfg.Dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create forward buffer directory: %w", err)
+	}
+	b := &Buffer{
+		name:         name,
+		dir:          dir,
+		segmentBytes: cfg.SegmentBytes,
+		maxBytes:     cfg.MaxBytes,
+		maxAge:       time.Duration(cfg.MaxAge) * time.Second,
+		retry:        time.Duration(cfg.RetryInterval) * time.Second,
+		logger:       logger.WithField("buffer", name),
+		deliverers:   make(map[string]Deliverer),
+		wake:         make(chan struct{}, 1),
+	}
+	if err := b.recover(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// recover lists the segments, drops a torn record at the end of the last
+// one, and opens it for appending
+func (b *Buffer) recover() error {
+	names, err := filepath.Glob(filepath.Join(b.dir, "*.seg"))
+	if err != nil {
+		return fmt.Errorf("failed to list forward buffer segments: %w", err)
+	}
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to read forward buffer segment: %w", err)
+		}
+		b.segments = append(b.segments, &segment{seq: seq, size: info.Size()})
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i].seq < b.segments[j].seq })
+
+	if data, err := os.ReadFile(filepath.Join(b.dir, cursorFile)); err == nil {
+		if err := json.Unmarshal(data, &b.cursor); err != nil {
+			b.logger.Errorf("Ignoring unreadable forward buffer cursor: %v", err)
+		}
+	}
+
+	if len(b.segments) == 0 {
+		return b.roll()
+	}
+	last := b.segments[len(b.segments)-1]
+	valid, err := b.validLength(last.seq)
+	if err != nil {
+		return err
+	}
+	if valid < last.size {
+		b.logger.Warnf("Dropping a torn record at the end of forward buffer segment %d", last.seq)
+		if err := os.Truncate(b.segmentPath(last.seq), valid); err != nil {
+			return fmt.Errorf("failed to repair forward buffer segment: %w", err)
+		}
+		last.size = valid
+	}
+	f, err := os.OpenFile(b.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open forward buffer segment: %w", err)
+	}
+	b.head = f
+	if b.cursor.Seq < b.segments[0].seq {
+		b.cursor = cursor{Seq: b.segments[0].seq}
+	}
+	if n := b.pendingBytes(); n > 0 {
+		b.logger.Infof("Recovered %d bytes of undelivered records from the forward buffer", n)
+		b.wake <- struct{}{}
+	}
+	return nil
+}
+
+// validLength is the length of the whole records at the start of a segment
+func (b *Buffer) validLength(seq uint64) (int64, error) {
+	f, err := os.Open(b.segmentPath(seq))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open forward buffer segment: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		payload, err := readFrame(r)
+		if err != nil {
+			return valid, nil
+		}
+		valid += frameHeader + int64(len(payload))
+	}
+}
+
+// Register sets the deliverer of a kind of record; it must be called
+// before Run
+func (b *Buffer) Register(kind string, d Deliverer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliverers[kind] = d
+}
+
+// Pending reports whether records are waiting to be delivered, in which
+// case new writes are appended behind them to keep their order
+func (b *Buffer) Pending() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pendingBytes() > 0
+}
+
+// Append adds a record of kind holding data as JSON, returning once it is
+// on disk
+func (b *Buffer) Append(kind string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s record: %w", kind, err)
+	}
+	payload, err := json.Marshal(Record{Kind: kind, CreatedAt: time.Now().UTC(), Data: raw})
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, frameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[frameHeader:], payload)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	last := b.segments[len(b.segments)-1]
+	if last.size > 0 && last.size+int64(len(frame)) > b.segmentBytes {
+		if err := b.roll(); err != nil {
+			return err
+		}
+		last = b.segments[len(b.segments)-1]
+	}
+	_, err = b.head.Write(frame)
+	if err == nil {
+		err = b.head.Sync()
+	}
+	if err != nil {
+		// Drop what was written of the record so the segment stays whole
+		b.head.Truncate(last.size)
+		return fmt.Errorf("failed to buffer %s record: %w", kind, err)
+	}
+	last.size += int64(len(frame))
+	b.enforceSize()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// roll closes the head segment and starts the next one
+func (b *Buffer) roll() error {
+	seq := uint64(1)
+	if n := len(b.segments); n > 0 {
+		seq = b.segments[n-1].seq + 1
+	}
+	f, err := os.OpenFile(b.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create forward buffer segment: %w", err)
+	}
+	if b.head != nil {
+		b.head.Close()
+	}
+	b.head = f
+	b.segments = append(b.segments, &segment{seq: seq})
+	if len(b.segments) == 1 {
+		b.cursor = cursor{Seq: seq}
+	}
+	return nil
+}
+
+// enforceSize drops the oldest segments, but never the head, while the
+// buffer exceeds its size limit
+func (b *Buffer) enforceSize() {
+	for b.maxBytes > 0 && len(b.segments) > 1 && b.totalBytes() > b.maxBytes {
+		oldest := b.segments[0]
+		b.logger.Warnf("Forward buffer exceeds %d bytes; dropping segment %d of undelivered records", b.maxBytes, oldest.seq)
+		b.dropped += countFrames(b.segmentPath(oldest.seq), b.offsetIn(oldest.seq))
+		os.Remove(b.segmentPath(oldest.seq))
+		b.segments = b.segments[1:]
+		if b.cursor.Seq <= oldest.seq {
+			b.cursor = cursor{Seq: b.segments[0].seq}
+			b.saveCursor()
+		}
+	}
+}
+
+// offsetIn is where delivery stands in segment seq
+func (b *Buffer) offsetIn(seq uint64) int64 {
+	if b.cursor.Seq == seq {
+		return b.cursor.Offset
+	}
+	return 0
+}
+
+func (b *Buffer) totalBytes() int64 {
+	var n int64
+	for _, s := range b.segments {
+		n += s.size
+	}
+	return n
+}
+
+func (b *Buffer) pendingBytes() int64 {
+	var n int64
+	for _, s := range b.segments {
+		if s.seq >= b.cursor.Seq {
+			n += s.size - b.offsetIn(s.seq)
+		}
+	}
+	return n
+}
+
+// Run drains the buffer whenever records are appended and, while their
+// targets are unreachable, retries with backoff until ctx is cancelled
+func (b *Buffer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.wake:
+		}
+		delay := b.retry
+		for {
+			err := b.drain(ctx)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			b.logger.Warnf("Forward buffer target unreachable, retrying in %s: %v", delay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRetryDelay)
+		}
+	}
+}
+
+// drain delivers records from the cursor on, stopping at the first whose
+// target is unreachable
+func (b *Buffer) drain(ctx context.Context) error {
+	b.draining.Lock()
+	defer b.draining.Unlock()
+
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		if b.pendingBytes() == 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		cur := b.cursor
+		last := b.segments[len(b.segments)-1].seq
+		b.mu.Unlock()
+
+		done, err := b.drainSegment(ctx, cur)
+		if err != nil {
+			return err
+		}
+		// A delivered segment that is no longer the head is removed
+		if done && cur.Seq < last {
+			b.mu.Lock()
+			if len(b.segments) > 0 && b.segments[0].seq == cur.Seq {
+				b.segments = b.segments[1:]
+				os.Remove(b.segmentPath(cur.Seq))
+				b.cursor = cursor{Seq: b.segments[0].seq}
+				b.saveCursor()
+			}
+			b.mu.Unlock()
+		}
+	}
+	return ctx.Err()
+}
+
+// drainSegment delivers the records of one segment from cur, reporting
+// whether it reached the segment's end
+func (b *Buffer) drainSegment(ctx context.Context, cur cursor) (bool, error) {
+	f, err := os.Open(b.segmentPath(cur.Seq))
+	if errors.Is(err, os.ErrNotExist) {
+		// Dropped for size while being delivered
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(cur.Offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(f)
+	offset := cur.Offset
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		if b.cursor.Seq != cur.Seq {
+			// The segment was dropped for size
+			b.mu.Unlock()
+			return false, nil
+		}
+		size := b.segmentSize(cur.Seq)
+		b.mu.Unlock()
+		if offset >= size {
+			return true, nil
+		}
+
+		payload, err := readFrame(r)
+		if err != nil {
+			b.logger.Errorf("Skipping the corrupt rest of forward buffer segment %d: %v", cur.Seq, err)
+			return true, nil
+		}
+		if err := b.deliver(ctx, payload); err != nil {
+			return false, err
+		}
+		offset += frameHeader + int64(len(payload))
+
+		b.mu.Lock()
+		if b.cursor.Seq == cur.Seq {
+			b.cursor.Offset = offset
+			b.saveCursor()
+		}
+		b.mu.Unlock()
+	}
+	return false, ctx.Err()
+}
+
+// deliver hands one record to its deliverer, returning an error only when
+// its target is unreachable
+func (b *Buffer) deliver(ctx context.Context, payload []byte) error {
+	var rec Record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		b.logger.Errorf("Dropping unreadable forward buffer record: %v", err)
+		return nil
+	}
+	log := b.logger.WithField("kind", rec.Kind)
+	if b.maxAge > 0 && time.Since(rec.CreatedAt) > b.maxAge {
+		log.Warnf("Dropping buffered record from %s, older than %s", rec.CreatedAt.Format(time.RFC3339), b.maxAge)
+		b.count(&b.dropped)
+		return nil
+	}
+	b.mu.Lock()
+	d, ok := b.deliverers[rec.Kind]
+	b.mu.Unlock()
+	if !ok {
+		log.Error("Dropping buffered record of an unknown kind")
+		b.count(&b.dropped)
+		return nil
+	}
+	if err := d(ctx, rec); err != nil {
+		if Unreachable(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Errorf("Dropping buffered record its target rejected: %v", err)
+		b.count(&b.dropped)
+		return nil
+	}
+	b.count(&b.delivered)
+	return nil
+}
+
+func (b *Buffer) count(n *int64) {
+	b.mu.Lock()
+	*n++
+	b.mu.Unlock()
+}
+
+func (b *Buffer) segmentSize(seq uint64) int64 {
+	for _, s := range b.segments {
+		if s.seq == seq {
+			return s.size
+		}
+	}
+	return 0
+}
+
+// saveCursor records the cursor. It is not synced: after a crash the
+// records since the last save are delivered again, so delivery is at least
+// once.
+func (b *Buffer) saveCursor() {
+	data, _ := json.Marshal(b.cursor)
+	path := filepath.Join(b.dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		b.logger.Warnf("Failed to save forward buffer cursor: %v", err)
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// Stats reports the buffer's backlog
+type Stats struct {
+	Name         string `json:"name"`
+	Segments     int    `json:"segments"`
+	PendingBytes int64  `json:"pendingBytes"`
+	Delivered    int64  `json:"delivered"`
+	Dropped      int64  `json:"dropped"`
+}
+
+// Stats returns a snapshot of the buffer
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stats{Name: b.name, Segments: len(b.segments), PendingBytes: b.pendingBytes(), Delivered: b.delivered, Dropped: b.dropped}
+}
+
+// Close stops accepting records and tries to deliver the pending ones
+// before ctx is done. Records left stay buffered for the next start.
+func (b *Buffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	err := b.drain(ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if closeErr := b.head.Close(); closeErr != nil {
+		return closeErr
+	}
+	if n := b.pendingBytes(); n > 0 {
+		return fmt.Errorf("%d bytes of records could not be delivered and stay buffered: %w", n, err)
+	}
+	return nil
+}
+
+func (b *Buffer) segmentPath(seq uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d.seg", seq))
+}
+
+// readFrame reads one record, failing on a short or corrupt frame
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [frameHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// countFrames counts the records of a segment from offset on
+func countFrames(path string, offset int64) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0
+	}
+	r := bufio.NewReader(f)
+	var n int64
+	for {
+		if _, err := readFrame(r); err != nil {
+			return n
+		}
+		n++
+	}
+}
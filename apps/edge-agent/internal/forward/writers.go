@@ -0,0 +1,108 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/fusionflow/edge-agent/internal/connector"
+	"github.com/sirupsen/logrus"
+)
+
+// KindConnector is the kind of buffered connector writes
+const KindConnector = "connector"
+
+// ErrUnavailable is wrapped by deliverers whose target answered but cannot
+// take writes for now, such as an HTTP 503, so that they are retried
+var ErrUnavailable = errors.New("target unavailable")
+
+// Unreachable reports whether err means the target could not be reached,
+// as opposed to having rejected the write, so that retrying later may
+// succeed
+func Unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.Is(err, ErrUnavailable) ||
+		errors.As(err, &netErr) ||
+		errors.As(err, &opErr) ||
+		errors.As(err, &dnsErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Writer writes requests through connectors
+type Writer interface {
+	Write(ctx context.Context, connectorID string, req connector.Request) error
+}
+
+// connectorWrite is a buffered connector write
+type connectorWrite struct {
+	Connector   string                 `json:"connector"`
+	Operation   string                 `json:"operation,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Body        []byte                 `json:"body"`
+	ContentType string                 `json:"contentType,omitempty"`
+}
+
+// ConnectorWriter writes through next, buffering the writes whose
+// connector is unreachable. Once a write is buffered, later writes queue
+// behind it until the buffer has drained, so they reach their systems in
+// order.
+type ConnectorWriter struct {
+	buf    *Buffer
+	next   Writer
+	logger *logrus.Logger
+}
+
+// NewConnectorWriter creates a writer buffering in buf, and registers it
+// as the deliverer of buffered connector writes
+func NewConnectorWriter(buf *Buffer, next Writer, logger *logrus.Logger) *ConnectorWriter {
+	w := &ConnectorWriter{buf: buf, next: next, logger: logger}
+	buf.Register(KindConnector, w.deliver)
+	return w
+}
+
+// Write implements Writer. It fails only when the connector rejects the
+// write, or the write cannot be buffered.
+func (w *ConnectorWriter) Write(ctx context.Context, connectorID string, req connector.Request) error {
+	if !w.buf.Pending() {
+		err := w.next.Write(ctx, connectorID, req)
+		if !Unreachable(err) {
+			return err
+		}
+		w.logger.WithField("connector", connectorID).Warnf("Connector unreachable; buffering writes until it is back: %v", err)
+	}
+	return w.buf.Append(KindConnector, connectorWrite{
+		Connector:   connectorID,
+		Operation:   req.Operation,
+		Params:      req.Params,
+		Body:        req.Body,
+		ContentType: req.ContentType,
+	})
+}
+
+func (w *ConnectorWriter) deliver(ctx context.Context, rec Record) error {
+	var cw connectorWrite
+	if err := json.Unmarshal(rec.Data, &cw); err != nil {
+		return err
+	}
+	return w.next.Write(ctx, cw.Connector, connector.Request{
+		Operation:   cw.Operation,
+		Params:      cw.Params,
+		Body:        cw.Body,
+		ContentType: cw.ContentType,
+	})
+}
@@ -0,0 +1,226 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Supported output formats
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// ErrUnknownFormat is returned for formats other than dot and mermaid
+var ErrUnknownFormat = errors.New("unknown graph format")
+
+// Step statuses used to colour annotated graphs
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRunning   = "running"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+)
+
+// statusColors are the fill colours of annotated steps
+var statusColors = map[string]string{
+	StatusSucceeded: "#d4edda",
+	StatusFailed:    "#f8d7da",
+	StatusRunning:   "#fff3cd",
+	StatusSkipped:   "#e2e3e5",
+	StatusCancelled: "#d6d8db",
+}
+
+// Annotation is the outcome of one step in an execution
+type Annotation struct {
+	Status   string
+	Duration time.Duration
+}
+
+// Render draws flow in format. Annotations, keyed by step ID, add each
+// step's status and duration to its node; pass nil for a plain flow graph.
+func Render(format string, flow *model.Flow, annotations map[string]Annotation) (string, error) {
+	g := build(flow, annotations)
+	switch format {
+	case FormatDOT:
+		return g.dot(), nil
+	case FormatMermaid:
+		return g.mermaid(), nil
+	}
+	return "", fmt.Errorf("%w %q: must be %s or %s", ErrUnknownFormat, format, FormatDOT, FormatMermaid)
+}
+
+// ContentType returns the media type of a rendered format
+func ContentType(format string) string {
+	if format == FormatMermaid {
+		return "text/vnd.mermaid; charset=utf-8"
+	}
+	return "text/vnd.graphviz; charset=utf-8"
+}
+
+type node struct {
+	id      string
+	label   []string
+	trigger bool
+	status  string
+}
+
+type edge struct {
+	from, to int
+	label    string
+}
+
+type graph struct {
+	name  string
+	nodes []node
+	edges []edge
+}
+
+// build lays out triggers, steps and edges. Triggers feed the root steps,
+// those without incoming edges.
+func build(flow *model.Flow, annotations map[string]Annotation) *graph {
+	g := &graph{name: flow.Name}
+	if g.name == "" {
+		g.name = flow.ID
+	}
+
+	index := make(map[string]int, len(flow.Steps))
+	for _, step := range flow.Steps {
+		n := node{id: step.ID, label: []string{step.ID, "(" + step.Type + ")"}}
+		if a, ok := annotations[step.ID]; ok {
+			n.status = a.Status
+			n.label = append(n.label, annotationLabel(a))
+		}
+		index[step.ID] = len(g.nodes)
+		g.nodes = append(g.nodes, n)
+	}
+
+	hasInput := make(map[string]bool)
+	for _, e := range flow.Edges {
+		from, ok := index[e.From]
+		to, ok2 := index[e.To]
+		if !ok || !ok2 {
+			continue
+		}
+		hasInput[e.To] = true
+		g.edges = append(g.edges, edge{from: from, to: to, label: e.Port})
+	}
+
+	for i, trigger := range flow.Triggers {
+		id := fmt.Sprintf("trigger-%d", i)
+		for _, taken := index[id]; taken; _, taken = index[id] {
+			id = "_" + id
+		}
+		t := len(g.nodes)
+		g.nodes = append(g.nodes, node{
+			id:      id,
+			label:   []string{trigger.Type + " trigger"},
+			trigger: true,
+		})
+		for _, step := range flow.Steps {
+			if !hasInput[step.ID] {
+				g.edges = append(g.edges, edge{from: t, to: index[step.ID]})
+			}
+		}
+	}
+	return g
+}
+
+func annotationLabel(a Annotation) string {
+	status := a.Status
+	if status == "" {
+		status = "unknown"
+	}
+	if a.Duration <= 0 {
+		return status
+	}
+	return status + ", " + formatDuration(a.Duration)
+}
+
+// formatDuration keeps durations short enough for a node label
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+func (g *graph) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+	for _, n := range g.nodes {
+		attrs := []string{"label=" + dotQuote(strings.Join(n.label, "\n"))}
+		switch {
+		case n.trigger:
+			attrs = append(attrs, "shape=oval")
+		case statusColors[n.status] != "":
+			attrs = append(attrs, `style="rounded,filled"`, "fillcolor="+dotQuote(statusColors[n.status]))
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(n.id), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(g.nodes[e.from].id), dotQuote(g.nodes[e.to].id))
+		if e.label != "" {
+			fmt.Fprintf(&b, " [label=%s]", dotQuote(e.label))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g *graph) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	classes := make(map[string][]string)
+	for i, n := range g.nodes {
+		id := fmt.Sprintf("n%d", i)
+		label := mermaidQuote(strings.Join(n.label, "<br/>"))
+		if n.trigger {
+			fmt.Fprintf(&b, "  %s([%s])\n", id, label)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s[%s]\n", id, label)
+		if statusColors[n.status] != "" {
+			classes[n.status] = append(classes[n.status], id)
+		}
+	}
+	for _, e := range g.edges {
+		if e.label != "" {
+			fmt.Fprintf(&b, "  n%d -->|%s| n%d\n", e.from, mermaidQuote(e.label), e.to)
+		} else {
+			fmt.Fprintf(&b, "  n%d --> n%d\n", e.from, e.to)
+		}
+	}
+
+	statuses := make([]string, 0, len(classes))
+	for status := range classes {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "  classDef %s fill:%s\n", status, statusColors[status])
+		fmt.Fprintf(&b, "  class %s %s\n", strings.Join(classes[status], ","), status)
+	}
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// mermaidQuote wraps s in quotes, escaping characters Mermaid would parse
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;").Replace(s) + `"`
+}
@@ -0,0 +1,124 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// checkInterval is how often the health status follows the agent's
+// readiness
+const checkInterval = time.Second
+
+// Readiness reports whether the agent is ready to serve, as /health/ready
+// does
+type Readiness func() (bool, error)
+
+// Server is the agent's gRPC listener. It serves the grpc.health.v1 health
+// service, reporting the agent and its gRPC services SERVING once the agent
+// is ready, and server reflection, so that grpcurl, Kubernetes gRPC probes
+// and load balancers work without custom clients.
+type Server struct {
+	cfg    config.GRPCConfig
+	server *grpc.Server
+	health *health.Server
+	ready  Readiness
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	services []string
+}
+
+// NewServer creates a gRPC server whose health follows ready
+func NewServer(cfg config.GRPCConfig, ready Readiness, logger *logrus.Logger) *Server {
+	s := &Server{
+		cfg:    cfg,
+		server: grpc.NewServer(),
+		health: health.NewServer(),
+		ready:  ready,
+		logger: logger,
+		// The empty name is the health of the agent as a whole
+		services: []string{""},
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	if cfg.Reflection {
+		reflection.Register(s.server)
+	}
+	return s
+}
+
+// Register adds a gRPC service before Serve is called; its health is
+// reported under its full name along with the agent's
+func (s *Server) Register(desc *grpc.ServiceDesc, impl interface{}) {
+	s.server.RegisterService(desc, impl)
+	s.mu.Lock()
+	s.services = append(s.services, desc.ServiceName)
+	s.mu.Unlock()
+	s.health.SetServingStatus(desc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Serve listens on the configured port and serves until Stop is called,
+// updating the health status until ctx ends
+func (s *Server) Serve(ctx context.Context) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on grpc port %d: %w", s.cfg.Port, err)
+	}
+	go s.watch(ctx)
+	return s.server.Serve(lis)
+}
+
+// watch keeps the health status in line with the agent's readiness
+func (s *Server) watch(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		s.update()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update sets the health status of the agent and its services
+func (s *Server) update() {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready, err := s.ready(); err == nil && ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.services {
+		s.health.SetServingStatus(name, status)
+	}
+}
+
+// Stop reports every service NOT_SERVING, so that load balancers drain the
+// agent, and stops the server once in-flight calls finish or ctx ends
+func (s *Server) Stop(ctx context.Context) {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("Stopping gRPC server with calls in flight")
+		s.server.Stop()
+		<-done
+	}
+}
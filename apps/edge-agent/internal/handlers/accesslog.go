@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// accessLogFields are the fields the access log can emit, in output order
+var accessLogFields = []string{
+	"client_ip",
+	"timestamp",
+	"method",
+	"path",
+	"protocol",
+	"status_code",
+	"latency",
+	"user_agent",
+	"bytes",
+	"error",
+}
+
+// accessLogData recycles the field maps of access log entries. Logrus
+// copies the fields into each entry it creates, so a map can be reused as
+// soon as the line is logged.
+var accessLogData = sync.Pool{
+	New: func() interface{} { return make(logrus.Fields, len(accessLogFields)) },
+}
+
+// accessLogMiddleware logs completed requests according to cfg. Failed
+// requests (status >= 400 or gin errors) are always logged; excluded paths
+// and unsampled successful requests are skipped.
+func accessLogMiddleware(cfg config.AccessLogConfig, logger *logrus.Logger) gin.HandlerFunc {
+	fields := accessLogFields
+	if len(cfg.Fields) > 0 {
+		fields = fields[:0:0]
+		for _, name := range cfg.Fields {
+			if !slices.Contains(accessLogFields, name) {
+				logger.Warnf("Ignoring unknown access log field %q", name)
+				continue
+			}
+			fields = append(fields, name)
+		}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		failed := status >= 400 || len(c.Errors) > 0
+		if !failed {
+			if excludedPath(cfg.ExcludePaths, c.Request.URL.Path) {
+				return
+			}
+			if status < 300 && cfg.SuccessSampleRate < 1 && rand.Float64() >= cfg.SuccessSampleRate {
+				return
+			}
+		}
+
+		data := accessLogData.Get().(logrus.Fields)
+		defer func() {
+			clear(data)
+			accessLogData.Put(data)
+		}()
+		for _, name := range fields {
+			switch name {
+			case "client_ip":
+				data[name] = c.ClientIP()
+			case "timestamp":
+				data[name] = start.Format(time.RFC3339)
+			case "method":
+				data[name] = c.Request.Method
+			case "path":
+				data[name] = c.Request.URL.Path
+			case "protocol":
+				data[name] = c.Request.Proto
+			case "status_code":
+				data[name] = status
+			case "latency":
+				data[name] = time.Since(start)
+			case "user_agent":
+				data[name] = c.Request.UserAgent()
+			case "bytes":
+				data[name] = c.Writer.Size()
+			case "error":
+				data[name] = c.Errors.ByType(gin.ErrorTypePrivate).String()
+			}
+		}
+
+		entry := logging.FromContext(c.Request.Context(), logger).WithFields(data)
+		if failed {
+			entry.Warn("HTTP Request")
+			return
+		}
+		entry.Info("HTTP Request")
+	}
+}
+
+// excludedPath reports whether path matches one of the exclusion patterns
+func excludedPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if pattern == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/auth"
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// authMiddleware rejects requests outside the public paths of cfg that do
+// not present a valid API key or JWT, logging each rejection for audit.
+// Requests that pass are logged as their principal.
+func authMiddleware(cfg config.AuthConfig, authn *auth.Authenticator, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if excludedPath(cfg.PublicPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		principal, err := authn.Authenticate(c.Request)
+		if err != nil {
+			entry := logger.WithFields(logrus.Fields{
+				"client_ip": c.ClientIP(),
+				"method":    c.Request.Method,
+				"path":      c.Request.URL.Path,
+			})
+			if !errors.Is(err, auth.ErrNoCredentials) && !errors.Is(err, auth.ErrInvalidCredentials) {
+				entry.Errorf("Failed to authenticate request: %v", err)
+				writeProblem(c, http.StatusServiceUnavailable, "Authentication unavailable", "credentials cannot be verified right now", nil)
+				return
+			}
+			entry.Warnf("Rejected unauthenticated request: %v", err)
+			c.Header("WWW-Authenticate", `Bearer realm="fusionflow-edge-agent"`)
+			writeProblem(c, http.StatusUnauthorized, "Unauthorized", err.Error(), nil)
+			return
+		}
+
+		ctx := c.Request.Context()
+		entry := logging.FromContext(ctx, logger).WithField("principal", principal.Method+":"+principal.Name)
+		c.Request = c.Request.WithContext(logging.NewContext(ctx, entry))
+		c.Next()
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/gin-gonic/gin"
+)
+
+// getClock handles GET /api/v1/clock, reporting the agent's time and, on a
+// virtual clock, the timers waiting to fire
+func (h *api) getClock(c *gin.Context) {
+	if h.svc.Clock == nil {
+		c.JSON(http.StatusOK, gin.H{"virtual": false, "now": time.Now().UTC()})
+		return
+	}
+	c.JSON(http.StatusOK, h.clockState())
+}
+
+// advanceClock handles POST /api/v1/clock/advance. The body sets either a
+// duration such as "24h" or an RFC 3339 time to move the virtual clock to;
+// timers that come due fire in order before it returns.
+func (h *api) advanceClock(c *gin.Context) {
+	if h.svc.Clock == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "the agent is not running on a virtual clock"})
+		return
+	}
+
+	var req struct {
+		Duration string     `json:"duration"`
+		To       *time.Time `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var (
+		fired int
+		err   error
+	)
+	switch {
+	case req.Duration != "" && req.To != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "set either duration or to, not both"})
+		return
+	case req.Duration != "":
+		d, parseErr := time.ParseDuration(req.Duration)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + parseErr.Error()})
+			return
+		}
+		fired, err = h.svc.Clock.Advance(d)
+	case req.To != nil:
+		fired, err = h.svc.Clock.AdvanceTo(*req.To)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration or to is required"})
+		return
+	}
+	if errors.Is(err, clock.ErrBackwards) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.log(c).Infof("Advanced virtual clock to %s, firing %d timer(s)", h.svc.Clock.Now().UTC().Format(time.RFC3339), fired)
+	state := h.clockState()
+	state["fired"] = fired
+	c.JSON(http.StatusOK, state)
+}
+
+func (h *api) clockState() gin.H {
+	pending := h.svc.Clock.Pending()
+	for i := range pending {
+		pending[i] = pending[i].UTC()
+	}
+	return gin.H{"virtual": true, "now": h.svc.Clock.Now().UTC(), "pending": pending}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCluster handles GET /api/v1/cluster, listing the instances of the
+// agent's cluster
+func (h *api) getCluster(c *gin.Context) {
+	if h.svc.Cluster == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	list, err := h.svc.Cluster.Instances(c.Request.Context())
+	if err != nil {
+		h.log(c).Errorf("Failed to list cluster instances: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list cluster instances"})
+		return
+	}
+	now := time.Now().UTC()
+	instances := make([]gin.H, 0, len(list))
+	for _, inst := range list {
+		instances = append(instances, gin.H{
+			"id":             inst.ID,
+			"hostname":       inst.Hostname,
+			"startedAt":      inst.StartedAt,
+			"heartbeatAt":    inst.HeartbeatAt,
+			"leaseExpiresAt": inst.LeaseExpiresAt,
+			"alive":          inst.Alive(now),
+			"self":           inst.ID == h.svc.Cluster.ID(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"self":      h.svc.Cluster.ID(),
+		"instances": instances,
+		"total":     len(instances),
+	})
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/connector"
+	"github.com/fusionflow/edge-agent/internal/connectors"
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/importer"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// listConnectors handles GET /api/v1/connectors, filtered by type and by a
+// part of the name
+func (h *api) listConnectors(c *gin.Context) {
+	q, ok := parseListQuery(c, connectorListFields)
+	if !ok {
+		return
+	}
+	list, total, err := h.svc.Connectors.Page(c.Request.Context(), q.opts)
+	if err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, q.envelope(c, "connectors", connectors.RedactAll(list), total))
+}
+
+// createConnector handles POST /api/v1/connectors
+func (h *api) createConnector(c *gin.Context, req *connectorRequest) {
+	conn := req.connector()
+	if err := h.svc.Connectors.Create(c.Request.Context(), conn); err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, connectors.Redact(conn))
+}
+
+// importConnectors handles POST /api/v1/connectors/import?format=openapi|asyncapi,
+// generating connectors from an API description and storing them. With
+// dryRun=true the generated definitions are returned without storing anything.
+func (h *api) importConnectors(c *gin.Context) {
+	format := c.Query("format")
+	if !slices.Contains(importer.ConnectorFormats(), format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format", "supported": importer.ConnectorFormats()})
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := importer.Import(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if name := c.Query("name"); name != "" && len(result.Connectors) == 1 {
+		result.Connectors[0].Name = name
+	}
+	if c.Query("dryRun") == "true" {
+		c.JSON(http.StatusOK, redactImport(result))
+		return
+	}
+
+	if err := h.svc.Connectors.CreateAll(c.Request.Context(), result.Connectors); err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, redactImport(result))
+}
+
+// redactImport returns result with the secrets of its connectors redacted
+func redactImport(result *importer.Result) *importer.Result {
+	redacted := *result
+	redacted.Connectors = connectors.RedactAll(result.Connectors)
+	return &redacted
+}
+
+// getConnector handles GET /api/v1/connectors/:id
+func (h *api) getConnector(c *gin.Context) {
+	conn, err := h.svc.Connectors.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, connectors.Redact(conn))
+}
+
+// updateConnector handles PUT /api/v1/connectors/:id. With upsert=true a
+// missing connector is created under the ID, for clients that manage
+// connectors declaratively.
+func (h *api) updateConnector(c *gin.Context, req *connectorRequest) {
+	conn := req.connector()
+	conn.ID = c.Param("id")
+	if c.Query("upsert") == "true" {
+		created, err := h.svc.Connectors.Upsert(c.Request.Context(), conn)
+		if err != nil {
+			h.connectorError(c, err)
+			return
+		}
+		c.JSON(upsertStatus(created), connectors.Redact(conn))
+		return
+	}
+	if err := h.svc.Connectors.Update(c.Request.Context(), conn); err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, connectors.Redact(conn))
+}
+
+// deleteConnector handles DELETE /api/v1/connectors/:id
+func (h *api) deleteConnector(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.svc.Connectors.Delete(c.Request.Context(), id); err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Connector deleted successfully",
+		"id":      id,
+	})
+}
+
+// validatePayload handles POST /api/v1/connectors/:id/operations/:op/validate,
+// checking a JSON payload against the operation's message schema
+func (h *api) validatePayload(c *gin.Context) {
+	var payload interface{}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		bindProblem(c, err)
+		return
+	}
+	err := h.svc.Connectors.ValidatePayload(c.Request.Context(), c.Param("id"), c.Param("op"), payload)
+	var invalid *model.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "problems": invalid.Problems})
+		return
+	}
+	if err != nil {
+		h.connectorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true})
+}
+
+// connectionTestTimeout bounds a connection test
+const connectionTestTimeout = 15 * time.Second
+
+// testConnector handles POST /api/v1/connectors/:id/test, connecting to the
+// connector's system. A failed test is reported in the body; connector
+// types without an implementation cannot be tested.
+func (h *api) testConnector(c *gin.Context) {
+	id := c.Param("id")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), connectionTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.svc.Connectors.Test(ctx, id)
+	latency := time.Since(start).Milliseconds()
+	switch {
+	case errors.Is(err, connectors.ErrNotFound):
+		h.connectorError(c, err)
+	case errors.Is(err, connector.ErrUnsupported):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "id": id, "supported": connector.Types()})
+	case errors.Is(err, egress.ErrDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "id": id})
+	case err != nil:
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error(), "id": id, "latencyMs": latency})
+	default:
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Connection test successful", "id": id, "latencyMs": latency})
+	}
+}
+
+// rotateConnector handles POST /api/v1/connectors/:id/rotate, reconnecting
+// the connector with its current credentials. Executions using the old
+// connection finish on it.
+func (h *api) rotateConnector(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), connectionTestTimeout)
+	defer cancel()
+
+	rot, err := h.svc.Rotator.Rotate(ctx, c.Param("id"))
+	switch {
+	case errors.Is(err, connectors.ErrNotFound):
+		h.connectorError(c, err)
+	case errors.Is(err, egress.ErrDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "id": c.Param("id")})
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "rotation": rot})
+	default:
+		c.JSON(http.StatusOK, rot)
+	}
+}
+
+// connectorError maps connector service errors to responses
+func (h *api) connectorError(c *gin.Context, err error) {
+	var invalid *model.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		invalidProblem(c, "Invalid connector", invalid)
+	case errors.Is(err, connectors.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "connector not found", "id": c.Param("id")})
+	case errors.Is(err, connectors.ErrOperationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "operation not found", "id": c.Param("id"), "operation": c.Param("op")})
+	default:
+		h.log(c).Errorf("Connector operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// connectorRequest is the body of the connector write endpoints
+type connectorRequest struct {
+	Name        string                 `json:"name" binding:"required"`
+	Type        string                 `json:"type" binding:"required"`
+	Description string                 `json:"description"`
+	Config      map[string]interface{} `json:"config"`
+	Auth        *authRequest           `json:"auth"`
+	Operations  []operationRequest     `json:"operations" binding:"dive"`
+	Bandwidth   *model.Bandwidth       `json:"bandwidth"`
+}
+
+type authRequest struct {
+	Type     string   `json:"type" binding:"required"`
+	In       string   `json:"in"`
+	Name     string   `json:"name"`
+	TokenURL string   `json:"tokenUrl" binding:"omitempty,url"`
+	Scopes   []string `json:"scopes"`
+}
+
+// operationRequest is an operation of a connector request; its schemas are
+// checked by the connector service
+type operationRequest struct {
+	model.Operation
+	ID     string `json:"id" binding:"required"`
+	Action string `json:"action" binding:"omitempty,oneof=send receive"`
+}
+
+// connector returns the connector definition of the request
+func (r *connectorRequest) connector() *model.Connector {
+	conn := &model.Connector{
+		Name:        r.Name,
+		Type:        r.Type,
+		Description: r.Description,
+		Config:      r.Config,
+		Bandwidth:   r.Bandwidth,
+	}
+	if r.Auth != nil {
+		conn.Auth = &model.ConnectorAuth{Type: r.Auth.Type, In: r.Auth.In, Name: r.Auth.Name, TokenURL: r.Auth.TokenURL, Scopes: r.Auth.Scopes}
+	}
+	for _, op := range r.Operations {
+		operation := op.Operation
+		operation.ID = op.ID
+		operation.Action = op.Action
+		conn.Operations = append(conn.Operations, operation)
+	}
+	return conn
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/dlq"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+var deadLetterListFields = listFields{
+	sorts:  map[string]string{"failedAt": store.SortCreated},
+	labels: map[string]string{"status": "status", "errorClass": "error_class", "stepId": "step_id"},
+}
+
+// deadLettersEnabled rejects the dead-letter endpoints while the queue is
+// disabled
+func (h *api) deadLettersEnabled(c *gin.Context) {
+	if h.svc.DeadLetters == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "dead-letter queue is disabled"})
+	}
+}
+
+// listDeadLetters handles GET /api/v1/flows/:id/dlq, filtered by status,
+// error class and step. Payloads are left out; get a dead letter for its
+// payload.
+func (h *api) listDeadLetters(c *gin.Context) {
+	q, ok := parseListQuery(c, deadLetterListFields)
+	if !ok {
+		return
+	}
+	list, total, err := h.svc.DeadLetters.Page(c.Request.Context(), c.Param("id"), q.opts)
+	if err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	for _, dl := range list {
+		dl.Payload = nil
+	}
+	c.JSON(http.StatusOK, q.envelope(c, "deadLetters", list, total))
+}
+
+// getDeadLetter handles GET /api/v1/flows/:id/dlq/:entry
+func (h *api) getDeadLetter(c *gin.Context) {
+	dl, err := h.svc.DeadLetters.Get(c.Request.Context(), c.Param("id"), c.Param("entry"))
+	if err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dl)
+}
+
+// redriveDeadLetter handles POST /api/v1/flows/:id/dlq/:entry/redrive,
+// running the flow's current version on the kept input
+func (h *api) redriveDeadLetter(c *gin.Context) {
+	exec, err := h.svc.DeadLetters.Redrive(c.Request.Context(), c.Param("id"), c.Param("entry"))
+	if err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, exec)
+}
+
+// redriveDeadLetters handles POST /api/v1/flows/:id/dlq/redrive, re-driving
+// every pending dead letter of the flow that kept its input
+func (h *api) redriveDeadLetters(c *gin.Context) {
+	execs, err := h.svc.DeadLetters.RedriveAll(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"redriven":   len(execs),
+		"executions": execs,
+	})
+}
+
+// deleteDeadLetter handles DELETE /api/v1/flows/:id/dlq/:entry
+func (h *api) deleteDeadLetter(c *gin.Context) {
+	id := c.Param("entry")
+	if err := h.svc.DeadLetters.Delete(c.Request.Context(), c.Param("id"), id); err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Dead letter deleted successfully",
+		"id":      id,
+	})
+}
+
+// purgeDeadLetters handles DELETE /api/v1/flows/:id/dlq, removing the
+// flow's dead letters, or only those of the status query parameter
+func (h *api) purgeDeadLetters(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != model.DeadLetterPending && status != model.DeadLetterRedriven {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending or redriven"})
+		return
+	}
+	n, err := h.svc.DeadLetters.Purge(c.Request.Context(), c.Param("id"), status)
+	if err != nil {
+		h.deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Dead letters purged successfully",
+		"purged":  n,
+	})
+}
+
+// deadLetterError maps dead-letter queue errors to responses
+func (h *api) deadLetterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, dlq.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found", "id": c.Param("entry")})
+	case errors.Is(err, flows.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow not found", "id": c.Param("id")})
+	case errors.Is(err, dlq.ErrNoPayload):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log(c).Errorf("Dead-letter operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
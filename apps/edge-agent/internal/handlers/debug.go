@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	debugHeader      = "X-Debug"
+	debugTokenHeader = "X-Debug-Token"
+)
+
+// debugMiddleware elevates logging to debug level and captures payloads for
+// requests carrying an authenticated X-Debug header. Every request gets a
+// scoped log entry in its context so handlers can log through it.
+func debugMiddleware(cfg config.DebugConfig, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.Enabled || !strings.EqualFold(c.GetHeader(debugHeader), "true") {
+			c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logrus.NewEntry(logger)))
+			c.Next()
+			return
+		}
+
+		if !debugAuthorized(cfg, c) {
+			logger.WithField("client_ip", c.ClientIP()).Warn("Rejected unauthenticated debug request")
+			c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logrus.NewEntry(logger)))
+			c.Next()
+			return
+		}
+
+		entry := logging.DebugLogger(logger).WithFields(logrus.Fields{
+			"debug":  true,
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+		})
+		ctx := logging.WithDebug(logging.NewContext(c.Request.Context(), entry))
+		c.Request = c.Request.WithContext(ctx)
+
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(cfg.MaxPayloadBytes)+1))
+			if err == nil {
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+				entry.WithField("payload", truncate(body, cfg.MaxPayloadBytes)).Debug("Debug request payload")
+			}
+		}
+
+		capture := &captureWriter{ResponseWriter: c.Writer, limit: cfg.MaxPayloadBytes}
+		c.Writer = capture
+		c.Next()
+
+		entry.WithFields(logrus.Fields{
+			"status_code": c.Writer.Status(),
+			"payload":     truncate(capture.body.Bytes(), cfg.MaxPayloadBytes),
+		}).Debug("Debug response payload")
+	}
+}
+
+// debugAuthorized reports whether debugging is enabled and the request
+// carries the debug token
+func debugAuthorized(cfg config.DebugConfig, c *gin.Context) bool {
+	token := c.GetHeader(debugTokenHeader)
+	return cfg.Enabled && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) == 1
+}
+
+// captureWriter tees up to limit bytes of the response body
+type captureWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+// Write implements io.Writer
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if remaining := w.limit + 1 - w.body.Len(); remaining > 0 {
+		if len(b) < remaining {
+			remaining = len(b)
+		}
+		w.body.Write(b[:remaining])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteString implements io.StringWriter
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// truncate renders a captured payload, marking it if it exceeded limit
+func truncate(b []byte, limit int) string {
+	if len(b) > limit {
+		return string(b[:limit]) + "...(truncated)"
+	}
+	return string(b)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/debugger"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// debugFlow starts a debug execution of flow
+func (h *api) debugFlow(c *gin.Context, flow *model.Flow, input json.RawMessage, breakpoints []string) {
+	if h.svc.Debugger == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "debug executions are disabled"})
+		return
+	}
+
+	steps := make(map[string]bool, len(flow.Steps))
+	for _, step := range flow.Steps {
+		steps[step.ID] = true
+	}
+	var unknown []string
+	for _, id := range breakpoints {
+		if !steps[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "breakpoints reference unknown steps", "steps": unknown})
+		return
+	}
+	plan, err := h.svc.Plans.Plan(flow)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid flow", "problems": []string{err.Error()}})
+		return
+	}
+	if capture := plan.Policy().CaptureLevel(); capture != model.CapturePayloads {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the flow's capture policy does not allow debugging", "capture": capture})
+		return
+	}
+
+	id := ids.New("exec")
+	s := h.svc.Debugger.Open(id, flow.ID, breakpoints)
+	exec, err := h.svc.Executor.Submit(plan, inputMessage(input), engine.ExecuteOptions{
+		ID:       id,
+		Tenant:   flow.Tenant,
+		Debugger: s,
+		Done: func(_ *model.Execution, result *engine.Result, err error) {
+			h.svc.Debugger.Close(s, result, err)
+		},
+	})
+	if err != nil {
+		h.svc.Debugger.Close(s, nil, err)
+		h.log(c).Errorf("Failed to queue execution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue execution"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"execution": exec, "debug": s.State()})
+}
+
+// getExecutionDebug handles GET /api/v1/executions/:id/debug, returning the
+// paused step, its payload and variables
+func (h *api) getExecutionDebug(c *gin.Context) {
+	s, ok := h.debugSession(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s.State())
+}
+
+// debugCommand handles POST /api/v1/executions/:id/debug with a resume,
+// step, modify or abort command
+func (h *api) debugCommand(c *gin.Context) {
+	s, ok := h.debugSession(c)
+	if !ok {
+		return
+	}
+	var cmd debugger.Command
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.Command(cmd)
+	switch {
+	case errors.Is(err, debugger.ErrNotPaused):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": s.State().Status})
+		return
+	case errors.Is(err, debugger.ErrUnknownCommand):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "command": cmd.Command})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.State())
+}
+
+// debugSession resolves the session of the execution in the path, writing
+// the error response when there is none
+func (h *api) debugSession(c *gin.Context) (*debugger.Session, bool) {
+	id := c.Param("id")
+	if h.svc.Debugger == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "debug executions are disabled"})
+		return nil, false
+	}
+	s, ok := h.svc.Debugger.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "debug session not found", "id": id})
+		return nil, false
+	}
+	return s, true
+}
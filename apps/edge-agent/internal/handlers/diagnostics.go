@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createDump handles POST /api/v1/diagnostics/dump, writing goroutine stacks
+// and agent state to a file on the agent's host
+func (h *api) createDump(c *gin.Context) {
+	dump, err := h.svc.Diagnostics.Write()
+	if err != nil {
+		h.log(c).Errorf("Failed to write diagnostic dump: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write diagnostic dump"})
+		return
+	}
+	h.log(c).WithField("path", dump.Path).Info("Wrote diagnostic dump")
+	c.JSON(http.StatusCreated, dump)
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// listExecutions handles GET /api/v1/executions, filtered by status and
+// flowId. With archived=true the archived executions are listed.
+func (h *api) listExecutions(c *gin.Context) {
+	q, ok := parseListQuery(c, executionListFields)
+	if !ok {
+		return
+	}
+	archived := c.Query("archived") == "true"
+	list, total, err := h.svc.Executions.Page(c.Request.Context(), archived, q.opts)
+	if err != nil {
+		h.log(c).Errorf("Failed to list executions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list executions"})
+		return
+	}
+	resp := q.envelope(c, "executions", list, total)
+	resp["archived"] = archived
+	c.JSON(http.StatusOK, resp)
+}
+
+// executeRequest is the body of POST /api/v1/executions
+type executeRequest struct {
+	FlowID string          `json:"flowId" binding:"required"`
+	Input  json.RawMessage `json:"input"`
+	Debug  *struct {
+		Breakpoints []string `json:"breakpoints"`
+	} `json:"debug"`
+	// DebugLog logs the execution at debug level with its payloads, as an
+	// authenticated X-Debug header does; it needs the X-Debug-Token header
+	DebugLog bool `json:"debugLog"`
+}
+
+// idempotencyHeader names the key of a client's retries of one execution
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header
+const maxIdempotencyKeyLen = 255
+
+// executeFlow handles POST /api/v1/executions, queueing a run of the flow
+// on the input message. With "debug" set the flow runs under the
+// step-through debugger, pausing before the breakpoint steps. Requests
+// with an Idempotency-Key header seen within its TTL return the execution
+// the first one started, and fail if their flow or input differs; debug
+// runs ignore the header. Requests with an authenticated X-Debug header,
+// or with "debugLog" set and the debug token, log their execution at debug
+// level.
+func (h *api) executeFlow(c *gin.Context, req *executeRequest) {
+	flow, err := h.svc.Flows.Get(c.Request.Context(), req.FlowID)
+	if errors.Is(err, flows.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow not found", "flowId": req.FlowID})
+		return
+	}
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	if req.Debug != nil {
+		h.debugFlow(c, flow, req.Input, req.Debug.Breakpoints)
+		return
+	}
+	plan, err := h.svc.Plans.Plan(flow)
+	if err != nil {
+		writeProblem(c, http.StatusUnprocessableEntity, "Invalid flow", err.Error(), nil)
+		return
+	}
+
+	cause := &model.Cause{
+		Type:      model.CauseAPI,
+		RequestID: c.GetHeader("X-Request-ID"),
+		ParentID:  c.GetHeader("X-FusionFlow-Execution"),
+	}
+	opts := engine.ExecuteOptions{Tenant: flow.Tenant, Cause: cause, Debug: logging.IsDebug(c.Request.Context())}
+	if req.DebugLog && !opts.Debug {
+		if !debugAuthorized(h.cfg.Logging.Debug, c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "debugLog requires debugging to be enabled and the X-Debug-Token header"})
+			return
+		}
+		opts.Debug = true
+	}
+	key := c.GetHeader(idempotencyHeader)
+	if key != "" && h.svc.Idempotency != nil {
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+		opts.ID = ids.New("exec")
+		held, err := h.svc.Idempotency.Claim(c.Request.Context(), key, requestFingerprint(req), opts.ID, func(id string) bool {
+			return h.executionExists(c, id)
+		})
+		switch {
+		case errors.Is(err, executions.ErrIdempotencyConflict):
+			writeProblem(c, http.StatusUnprocessableEntity, "Idempotency key reused", err.Error(), nil)
+			return
+		case err != nil:
+			h.log(c).Errorf("Failed to claim idempotency key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue execution"})
+			return
+		case held != opts.ID:
+			h.replayExecution(c, held)
+			return
+		}
+	}
+	exec, err := h.svc.Executor.Submit(plan, inputMessage(req.Input), opts)
+	if err != nil {
+		if opts.ID != "" {
+			if err := h.svc.Idempotency.Release(c.Request.Context(), key); err != nil {
+				h.log(c).Errorf("Failed to release idempotency key: %v", err)
+			}
+		}
+		h.log(c).Errorf("Failed to queue execution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue execution"})
+		return
+	}
+	c.JSON(http.StatusCreated, exec)
+}
+
+// replayExecution answers a retried request with the execution the first
+// request started, which may not be recorded yet while that request is in
+// flight
+func (h *api) replayExecution(c *gin.Context, id string) {
+	exec, ok := h.svc.Executor.Get(id)
+	if !ok {
+		var err error
+		exec, err = h.svc.Executions.Get(c.Request.Context(), id)
+		if errors.Is(err, executions.ErrNotFound) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusConflict, gin.H{"error": "a request with this idempotency key is in progress", "id": id})
+			return
+		}
+		if err != nil {
+			h.log(c).Errorf("Failed to get execution %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get execution"})
+			return
+		}
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(http.StatusOK, exec)
+}
+
+// executionExists reports whether the execution id is active or recorded.
+// Failing to look it up counts as existing, so its key is not reclaimed.
+func (h *api) executionExists(c *gin.Context, id string) bool {
+	if _, ok := h.svc.Executor.Get(id); ok {
+		return true
+	}
+	_, err := h.svc.Executions.Get(c.Request.Context(), id)
+	return !errors.Is(err, executions.ErrNotFound)
+}
+
+// requestFingerprint identifies what an execution request asks for, so
+// that reusing its idempotency key for another request is detected
+func requestFingerprint(req *executeRequest) string {
+	input := req.Input
+	var compact bytes.Buffer
+	if json.Compact(&compact, input) == nil {
+		input = compact.Bytes()
+	}
+	sum := sha256.Sum256(append([]byte(req.FlowID+"\x00"), input...))
+	return hex.EncodeToString(sum[:])
+}
+
+// inputMessage builds the input message of an API execution
+func inputMessage(input json.RawMessage) *engine.Message {
+	if len(input) == 0 {
+		return engine.NewMessage(nil, "")
+	}
+	return engine.NewMessage(input, "application/json")
+}
+
+// getExecution handles GET /api/v1/executions/:id
+func (h *api) getExecution(c *gin.Context) {
+	exec, ok := h.execution(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, exec)
+}
+
+// execution resolves the execution in the path, preferring the live state
+// of an active run, and writes the error response when there is none
+func (h *api) execution(c *gin.Context) (*model.Execution, bool) {
+	id := c.Param("id")
+	if exec, ok := h.svc.Executor.Get(id); ok {
+		return exec, true
+	}
+	exec, err := h.svc.Executions.Get(c.Request.Context(), id)
+	if errors.Is(err, executions.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "execution not found", "id": id})
+		return nil, false
+	}
+	if err != nil {
+		h.log(c).Errorf("Failed to get execution %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get execution"})
+		return nil, false
+	}
+	return exec, true
+}
+
+// cancelRequest is the optional body of POST /api/v1/executions/:id/cancel
+type cancelRequest struct {
+	Reason string `json:"reason" binding:"max=1024"`
+	By     string `json:"by"`
+}
+
+// cancelExecution handles POST /api/v1/executions/:id/cancel, recording the
+// optional reason and user of the request body. The running step's context
+// is cancelled, so the execution remains running until the step returns. In
+// a cluster, executions of other instances are cancelled by their owner.
+func (h *api) cancelExecution(c *gin.Context) {
+	var req cancelRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			bindProblem(c, err)
+			return
+		}
+	}
+	cancellation := model.ExecutionCancellation{Reason: req.Reason, By: req.By}
+
+	id := c.Param("id")
+	err := h.svc.Executor.Cancel(id, cancellation)
+	if errors.Is(err, engine.ErrNotActive) {
+		exec, ok := h.execution(c)
+		if !ok {
+			return
+		}
+		if h.svc.Cluster != nil && !exec.Finished() && exec.Owner != "" && exec.Owner != h.svc.Cluster.ID() {
+			if err := h.svc.Cluster.RequestCancel(c.Request.Context(), exec, cancellation); err != nil {
+				h.log(c).Errorf("Failed to cancel execution %s: %v", id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel execution"})
+				return
+			}
+			h.log(c).Infof("Asked instance %s to cancel execution %s", exec.Owner, id)
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": "Execution cancellation requested from its owner",
+				"id":      id,
+				"owner":   exec.Owner,
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "execution is not queued or running", "id": id, "status": exec.Status})
+		return
+	}
+	if err != nil {
+		h.log(c).Errorf("Failed to cancel execution %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel execution"})
+		return
+	}
+	h.log(c).Infof("Cancelling execution %s", id)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Execution cancellation requested",
+		"id":      id,
+	})
+}
+
+// resumeRequest is the body of POST /api/v1/executions/:id/resume
+type resumeRequest struct {
+	Token   string          `json:"token" binding:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// resumeExecution handles POST /api/v1/executions/:id/resume, resuming a
+// waiting execution with the token it waits for and an optional payload
+func (h *api) resumeExecution(c *gin.Context, req *resumeRequest) {
+	id := c.Param("id")
+	var payload *engine.Message
+	if len(req.Payload) > 0 && string(req.Payload) != "null" {
+		payload = inputMessage(req.Payload)
+	}
+	exec, err := h.svc.Resumer.Resume(c.Request.Context(), id, req.Token, payload)
+	if errors.Is(err, engine.ErrNotSuspended) {
+		exec, ok := h.execution(c)
+		if !ok {
+			return
+		}
+		if exec.Status == model.ExecutionWaiting {
+			c.JSON(http.StatusConflict, gin.H{"error": "token does not match", "id": id})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "execution is not waiting", "id": id, "status": exec.Status})
+		return
+	}
+	if err != nil {
+		h.log(c).Errorf("Failed to resume execution %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resume execution"})
+		return
+	}
+	h.log(c).Infof("Resuming execution %s", id)
+	c.JSON(http.StatusAccepted, exec)
+}
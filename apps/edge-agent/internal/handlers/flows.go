@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/importer"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// listFlows handles GET /api/v1/flows, filtered by status and by a part of
+// the name
+func (h *api) listFlows(c *gin.Context) {
+	q, ok := parseListQuery(c, flowListFields)
+	if !ok {
+		return
+	}
+	list, total, err := h.svc.Flows.Page(c.Request.Context(), q.opts)
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, q.envelope(c, "flows", list, total))
+}
+
+// createFlow handles POST /api/v1/flows
+func (h *api) createFlow(c *gin.Context, req *flowRequest) {
+	flow := req.flow()
+	if err := h.svc.Flows.Create(c.Request.Context(), flow); err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, flow)
+}
+
+// applyFlow handles POST /api/v1/flows/apply, creating or updating a flow
+// and activating it in a single atomic operation
+func (h *api) applyFlow(c *gin.Context, req *flowRequest) {
+	flow := req.flow()
+	if err := h.svc.Flows.Apply(c.Request.Context(), flow); err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, flow)
+}
+
+// maxImportBytes bounds the size of an uploaded legacy definition
+const maxImportBytes = 10 << 20
+
+// importFlows handles POST /api/v1/flows/import?format=node-red|camel,
+// converting a legacy definition and storing the resulting flows as drafts.
+// With dryRun=true the conversion is returned without storing anything.
+func (h *api) importFlows(c *gin.Context) {
+	format := c.Query("format")
+	if !slices.Contains(importer.FlowFormats(), format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format", "supported": importer.FlowFormats()})
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := importer.Import(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("dryRun") == "true" {
+		c.JSON(http.StatusOK, redactImport(result))
+		return
+	}
+
+	if err := h.svc.Flows.CreateAll(c.Request.Context(), result.Flows); err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, redactImport(result))
+}
+
+// getFlow handles GET /api/v1/flows/:id
+func (h *api) getFlow(c *gin.Context) {
+	flow, err := h.svc.Flows.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, flow)
+}
+
+// updateFlow handles PUT /api/v1/flows/:id. With upsert=true a missing
+// flow is created as a draft under the ID. The definition of an active flow
+// is stored as a draft version, answered with 202 Accepted, until it is
+// published.
+func (h *api) updateFlow(c *gin.Context, req *flowRequest) {
+	flow := req.flow()
+	flow.ID = c.Param("id")
+	if c.Query("upsert") == "true" {
+		created, err := h.svc.Flows.Upsert(c.Request.Context(), flow)
+		if err != nil {
+			h.flowError(c, err)
+			return
+		}
+		if !created && flow.Status == model.FlowStatusActive {
+			draftCreated(c, flow)
+			return
+		}
+		c.JSON(upsertStatus(created), flow)
+		return
+	}
+	if err := h.svc.Flows.Update(c.Request.Context(), flow); err != nil {
+		h.flowError(c, err)
+		return
+	}
+	if flow.Status == model.FlowStatusActive {
+		draftCreated(c, flow)
+		return
+	}
+	c.JSON(http.StatusOK, flow)
+}
+
+// draftCreated responds to the update of an active flow stored as a draft
+// version
+func draftCreated(c *gin.Context, flow *model.Flow) {
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Draft version created; publish it to make it active",
+		"id":           flow.ID,
+		"version":      flow.Version,
+		"draftVersion": flow.DraftVersion,
+	})
+}
+
+// deleteFlow handles DELETE /api/v1/flows/:id
+func (h *api) deleteFlow(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.svc.Flows.Delete(c.Request.Context(), id); err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flow deleted successfully",
+		"id":      id,
+	})
+}
+
+// activateFlow handles POST /api/v1/flows/:id/activate
+func (h *api) activateFlow(c *gin.Context) {
+	flow, err := h.svc.Flows.Activate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flow activated successfully",
+		"id":      flow.ID,
+		"status":  flow.Status,
+	})
+}
+
+// deactivateFlow handles POST /api/v1/flows/:id/deactivate
+func (h *api) deactivateFlow(c *gin.Context) {
+	flow, err := h.svc.Flows.Deactivate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flow deactivated successfully",
+		"id":      flow.ID,
+		"status":  flow.Status,
+	})
+}
+
+// upsertStatus is the response status of an upsert
+func upsertStatus(created bool) int {
+	if created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// flowError maps flow service errors to responses
+func (h *api) flowError(c *gin.Context, err error) {
+	var invalid *model.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		invalidProblem(c, "Invalid flow", invalid)
+	case errors.Is(err, flows.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow not found", "id": c.Param("id")})
+	case errors.Is(err, flows.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow version not found", "id": c.Param("id"), "version": c.Param("version")})
+	case errors.Is(err, flows.ErrVersionPublished), errors.Is(err, flows.ErrVersionDraft), errors.Is(err, flows.ErrNoPreviousVersion):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "id": c.Param("id")})
+	default:
+		h.log(c).Errorf("Flow operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// flowRequest is the body of the flow write endpoints. Its binding rules
+// reject incomplete definitions before the flow service checks the rest.
+type flowRequest struct {
+	// ID is only read by apply, which creates or updates the flow under it
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name" binding:"required"`
+	Description  string                 `json:"description"`
+	Tenant       string                 `json:"tenant"`
+	Triggers     []triggerRequest       `json:"triggers" binding:"dive"`
+	Steps        []stepRequest          `json:"steps" binding:"required,min=1,dive"`
+	Edges        []edgeRequest          `json:"edges" binding:"dive"`
+	Ordering     *orderingRequest       `json:"ordering"`
+	Delivery     string                 `json:"delivery" binding:"omitempty,oneof=at-least-once exactly-once"`
+	Namespace    string                 `json:"namespace"`
+	Policy       *model.Policy          `json:"policy"`
+	Budget       *model.Budget          `json:"budget"`
+	InputSchema  map[string]interface{} `json:"inputSchema"`
+	OutputSchema map[string]interface{} `json:"outputSchema"`
+}
+
+type triggerRequest struct {
+	Type   string                 `json:"type" binding:"required"`
+	Config map[string]interface{} `json:"config"`
+}
+
+type stepRequest struct {
+	ID     string                 `json:"id" binding:"required"`
+	Type   string                 `json:"type" binding:"required"`
+	Config map[string]interface{} `json:"config"`
+}
+
+type edgeRequest struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
+	Port string `json:"port"`
+}
+
+type orderingRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+// flow returns the flow definition of the request
+func (r *flowRequest) flow() *model.Flow {
+	flow := &model.Flow{
+		ID:           r.ID,
+		Name:         r.Name,
+		Description:  r.Description,
+		Tenant:       r.Tenant,
+		Delivery:     r.Delivery,
+		Namespace:    r.Namespace,
+		Policy:       r.Policy,
+		Budget:       r.Budget,
+		InputSchema:  r.InputSchema,
+		OutputSchema: r.OutputSchema,
+	}
+	for _, t := range r.Triggers {
+		flow.Triggers = append(flow.Triggers, model.Trigger{Type: t.Type, Config: t.Config})
+	}
+	for _, st := range r.Steps {
+		flow.Steps = append(flow.Steps, model.Step{ID: st.ID, Type: st.Type, Config: st.Config})
+	}
+	for _, e := range r.Edges {
+		flow.Edges = append(flow.Edges, model.Edge{From: e.From, To: e.To, Port: e.Port})
+	}
+	if r.Ordering != nil {
+		flow.Ordering = &model.Ordering{Key: r.Ordering.Key}
+	}
+	return flow
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listFlowVersions handles GET /api/v1/flows/:id/versions, the version
+// history of a flow, filtered by status
+func (h *api) listFlowVersions(c *gin.Context) {
+	q, ok := parseListQuery(c, flowVersionListFields)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+	versions, total, err := h.svc.Flows.Versions(c.Request.Context(), id, q.opts)
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	envelope := q.envelope(c, "versions", versions, total)
+	envelope["flowId"] = id
+	c.JSON(http.StatusOK, envelope)
+}
+
+// getFlowVersion handles GET /api/v1/flows/:id/versions/:version
+func (h *api) getFlowVersion(c *gin.Context) {
+	version, ok := versionParam(c)
+	if !ok {
+		return
+	}
+	v, err := h.svc.Flows.GetVersion(c.Request.Context(), c.Param("id"), version)
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+// publishFlowVersion handles POST /api/v1/flows/:id/versions/:version/publish,
+// making a draft version the flow's definition and activating the flow
+func (h *api) publishFlowVersion(c *gin.Context) {
+	version, ok := versionParam(c)
+	if !ok {
+		return
+	}
+	flow, err := h.svc.Flows.Publish(c.Request.Context(), c.Param("id"), version)
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flow version published successfully",
+		"id":      flow.ID,
+		"version": flow.Version,
+		"status":  flow.Status,
+	})
+}
+
+// rollbackRequest is the optional body of a rollback
+type rollbackRequest struct {
+	// Version is the published version to revert to; by default the one
+	// published before the current definition
+	Version int `json:"version" binding:"min=0"`
+}
+
+// rollbackFlow handles POST /api/v1/flows/:id/rollback, reverting a flow to
+// an earlier published version and activating it
+func (h *api) rollbackFlow(c *gin.Context) {
+	var req rollbackRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			bindProblem(c, err)
+			return
+		}
+	}
+	flow, err := h.svc.Flows.Rollback(c.Request.Context(), c.Param("id"), req.Version)
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Flow rolled back successfully",
+		"id":      flow.ID,
+		"version": flow.Version,
+		"status":  flow.Status,
+	})
+}
+
+// versionParam reads the :version path parameter, responding with 400 when
+// it is not a version number
+func versionParam(c *gin.Context) (int, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer"})
+		return 0, false
+	}
+	return version, true
+}
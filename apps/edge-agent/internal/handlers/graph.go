@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/graph"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// getFlowGraph handles GET /api/v1/flows/:id/graph
+func (h *api) getFlowGraph(c *gin.Context) {
+	flow, err := h.svc.Flows.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	renderGraph(c, flow, nil)
+}
+
+// getExecutionGraph handles GET /api/v1/executions/:id/graph, drawing the
+// execution's flow with per-step status and duration
+func (h *api) getExecutionGraph(c *gin.Context) {
+	exec, ok := h.execution(c)
+	if !ok {
+		return
+	}
+
+	flow, err := h.svc.Flows.Get(c.Request.Context(), exec.FlowID)
+	if errors.Is(err, flows.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow of execution not found", "flowId": exec.FlowID})
+		return
+	}
+	if err != nil {
+		h.flowError(c, err)
+		return
+	}
+	annotations := make(map[string]graph.Annotation, len(exec.Steps))
+	for _, step := range exec.Steps {
+		annotations[step.ID] = graph.Annotation{
+			Status:   step.Status,
+			Duration: time.Duration(step.DurationMs) * time.Millisecond,
+		}
+	}
+	renderGraph(c, flow, annotations)
+}
+
+// renderGraph writes flow in the format named by the format query parameter
+func renderGraph(c *gin.Context, flow *model.Flow, annotations map[string]graph.Annotation) {
+	format := c.DefaultQuery("format", graph.FormatDOT)
+	out, err := graph.Render(format, flow, annotations)
+	if errors.Is(err, graph.ErrUnknownFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, graph.ContentType(format), []byte(out))
+}
@@ -2,307 +2,309 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/fusionflow/edge-agent/internal/auth"
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/cluster"
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/connectors"
+	"github.com/fusionflow/edge-agent/internal/debugger"
+	"github.com/fusionflow/edge-agent/internal/diag"
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/dlq"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/mocks"
+	"github.com/fusionflow/edge-agent/internal/quarantine"
+	"github.com/fusionflow/edge-agent/internal/respcache"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/fusionflow/edge-agent/internal/tasks"
+	"github.com/fusionflow/edge-agent/internal/triggers"
+	"github.com/fusionflow/edge-agent/internal/warmup"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
+// Services groups the backing services used by the handlers
+type Services struct {
+	Store store.Store
+	// Batch buffers execution state writes to Store
+	Batch      *store.Batcher
+	Flows      *flows.Service
+	Connectors *connectors.Service
+	// Rotator rotates connector credentials
+	Rotator    *connectors.Rotator
+	Executions *executions.Service
+	// Resumer resumes waiting executions
+	Resumer *executions.Resumer
+	// Idempotency holds the Idempotency-Key headers of API executions; nil
+	// when keys are ignored
+	Idempotency *executions.Idempotency
+	// Tasks is the inbox of approval tasks
+	Tasks *tasks.Service
+	Plans *engine.PlanCache
+	// Executor runs API-submitted executions and tracks the active ones
+	Executor *engine.Executor
+	// Logs passes on the log entries of executions as they are logged
+	Logs       *engine.LogCollector
+	Triggers   *triggers.Manager
+	Dispatcher *dispatch.Dispatcher
+	Warmup     *warmup.Warmer
+	// Debugger runs debug executions; nil when the debugger is disabled
+	Debugger *debugger.Manager
+	// Mocks manages and serves mock endpoints; nil when they are disabled
+	Mocks *mocks.Service
+	// DeadLetters keeps failed executions' input; nil when it is disabled
+	DeadLetters *dlq.Service
+	// Quarantine holds messages flows rejected; nil when it is disabled
+	Quarantine *quarantine.Service
+	// Clock is the virtual clock; nil when running on the system clock
+	Clock *clock.Virtual
+	// Cluster is the agent's cluster membership; nil outside cluster mode
+	Cluster *cluster.Cluster
+	// Diagnostics writes diagnostic dumps
+	Diagnostics *diag.Dumper
+	// Cache caches the responses of read endpoints; nil when disabled
+	Cache *respcache.Cache
+	// Auth authenticates requests; nil when authentication is disabled
+	Auth *auth.Authenticator
+}
+
+// api holds the dependencies shared by handlers
+type api struct {
+	logger *logrus.Logger
+	cfg    *config.Config
+	svc    Services
+}
+
+// log returns the scoped log entry of the request, which logs at debug
+// level for requests with an authenticated X-Debug header
+func (h *api) log(c *gin.Context) *logrus.Entry {
+	return logging.FromContext(c.Request.Context(), h.logger)
+}
+
 // RegisterRoutes registers all HTTP routes
-func RegisterRoutes(router *gin.Engine, logger *logrus.Logger) {
+func RegisterRoutes(router *gin.Engine, logger *logrus.Logger, cfg *config.Config, svc Services) {
+	h := &api{logger: logger, cfg: cfg, svc: svc}
+
+	// Name request body fields in validation problems as clients send them
+	registerJSONNames()
+
+	// Request-scoped logging, elevated to debug for authenticated X-Debug requests
+	router.Use(debugMiddleware(cfg.Logging.Debug, logger))
+
+	// Access logging
+	if cfg.Logging.Access.Enabled {
+		router.Use(accessLogMiddleware(cfg.Logging.Access, logger))
+	}
+
+	// Source-IP policies of the management API, webhooks and listed routes
+	router.Use(networkMiddleware(cfg.Network, logger))
+
+	// API keys and JWTs, except on public paths such as the health checks
+	if svc.Auth != nil {
+		router.Use(authMiddleware(cfg.Auth, svc.Auth, logger))
+	}
+
 	// Health check endpoints
 	router.GET("/", healthCheck)
 	router.GET("/health", healthCheck)
 	router.GET("/health/live", livenessCheck)
-	router.GET("/health/ready", readinessCheck)
+	router.GET("/health/ready", h.readinessCheck)
+
+	// Webhook triggers of active flows
+	router.Any(triggers.WebhookPrefix+"/*path", gin.WrapH(triggers.Webhooks))
+
+	// Mock endpoints, when enabled
+	if svc.Mocks != nil {
+		router.Any(mocks.Prefix+"/*path", gin.WrapH(svc.Mocks))
+	}
 
 	// API v1 routes
-	v1 := router.Group("/api/v1")
+	// Cache hot read endpoints, dropping cached responses on changes
+	cached := svc.Cache.Handler
+	v1 := router.Group("/api/v1", svc.Cache.InvalidateOnWrite())
 	{
 		// Connector endpoints
 		connectors := v1.Group("/connectors")
 		{
-			connectors.GET("", listConnectors)
-			connectors.POST("", createConnector)
-			connectors.GET("/:id", getConnector)
-			connectors.PUT("/:id", updateConnector)
-			connectors.DELETE("/:id", deleteConnector)
-			connectors.POST("/:id/test", testConnector)
+			connectors.GET("", cached("connector"), h.listConnectors)
+			connectors.POST("", withBody(h.createConnector))
+			connectors.POST("/import", h.importConnectors)
+			connectors.GET("/:id", cached("connector"), h.getConnector)
+			connectors.PUT("/:id", withBody(h.updateConnector))
+			connectors.DELETE("/:id", h.deleteConnector)
+			connectors.POST("/:id/test", h.testConnector)
+			connectors.POST("/:id/rotate", h.rotateConnector)
+			connectors.POST("/:id/operations/:op/validate", h.validatePayload)
 		}
 
 		// Flow endpoints
 		flows := v1.Group("/flows")
 		{
-			flows.GET("", listFlows)
-			flows.POST("", createFlow)
-			flows.GET("/:id", getFlow)
-			flows.PUT("/:id", updateFlow)
-			flows.DELETE("/:id", deleteFlow)
-			flows.POST("/:id/activate", activateFlow)
-			flows.POST("/:id/deactivate", deactivateFlow)
+			flows.GET("", cached("flow"), h.listFlows)
+			flows.POST("", withBody(h.createFlow))
+			flows.POST("/apply", withBody(h.applyFlow))
+			flows.POST("/import", h.importFlows)
+			flows.GET("/:id", cached("flow"), h.getFlow)
+			flows.PUT("/:id", withBody(h.updateFlow))
+			flows.DELETE("/:id", h.deleteFlow)
+			flows.POST("/:id/activate", h.activateFlow)
+			flows.POST("/:id/deactivate", h.deactivateFlow)
+			flows.POST("/:id/rollback", h.rollbackFlow)
+			flows.GET("/:id/versions", cached("flow"), h.listFlowVersions)
+			flows.GET("/:id/versions/:version", cached("flow"), h.getFlowVersion)
+			flows.POST("/:id/versions/:version/publish", h.publishFlowVersion)
+			flows.GET("/:id/graph", cached("flow", "connector"), h.getFlowGraph)
+			flows.GET("/:id/triggers", cached("flow"), h.getFlowTriggers)
+			flows.POST("/:id/pause", h.pauseFlow)
+			flows.POST("/:id/resume", h.resumeFlow)
 		}
 
-		// Execution endpoints
-		executions := v1.Group("/executions")
+		// Dead letters of a flow, when the queue is enabled
+		deadLetters := v1.Group("/flows/:id/dlq", h.deadLettersEnabled)
 		{
-			executions.GET("", listExecutions)
-			executions.POST("", executeFlow)
-			executions.GET("/:id", getExecution)
-			executions.POST("/:id/cancel", cancelExecution)
-			executions.GET("/:id/logs", getExecutionLogs)
+			deadLetters.GET("", h.listDeadLetters)
+			deadLetters.DELETE("", h.purgeDeadLetters)
+			deadLetters.POST("/redrive", h.redriveDeadLetters)
+			deadLetters.GET("/:entry", h.getDeadLetter)
+			deadLetters.DELETE("/:entry", h.deleteDeadLetter)
+			deadLetters.POST("/:entry/redrive", h.redriveDeadLetter)
 		}
-	}
-
-	// Add middleware for logging
-	router.Use(loggingMiddleware(logger))
-}
 
-// healthCheck handles the main health check endpoint
-func healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"timestamp": time.Now().UTC(),
-		"service":   "fusionflow-edge-agent",
-		"version":   "0.1.0",
-	})
-}
-
-// livenessCheck handles the liveness probe
-func livenessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "alive",
-	})
-}
-
-// readinessCheck handles the readiness probe
-func readinessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "ready",
-	})
-}
-
-// listConnectors handles GET /api/v1/connectors
-func listConnectors(c *gin.Context) {
-	// TODO: Implement actual connector listing
-	c.JSON(http.StatusOK, gin.H{
-		"connectors": []gin.H{},
-		"total":      0,
-		"page":       1,
-		"limit":      10,
-	})
-}
-
-// createConnector handles POST /api/v1/connectors
-func createConnector(c *gin.Context) {
-	// TODO: Implement actual connector creation
-	c.JSON(http.StatusCreated, gin.H{
-		"id":        "conn_123",
-		"name":      "Test Connector",
-		"type":      "postgresql",
-		"status":    "active",
-		"createdAt": time.Now().UTC(),
-	})
-}
-
-// getConnector handles GET /api/v1/connectors/:id
-func getConnector(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual connector retrieval
-	c.JSON(http.StatusOK, gin.H{
-		"id":        id,
-		"name":      "Test Connector",
-		"type":      "postgresql",
-		"status":    "active",
-		"createdAt": time.Now().UTC(),
-	})
-}
+		// Messages quarantined by flows, when the quarantine is enabled
+		quarantined := v1.Group("/quarantine", h.quarantineEnabled)
+		{
+			quarantined.GET("", h.listQuarantine)
+			quarantined.GET("/:id", h.getQuarantined)
+			quarantined.DELETE("/:id", h.deleteQuarantined)
+			quarantined.POST("/:id/release", h.releaseQuarantined)
+			quarantined.POST("/:id/discard", h.discardQuarantined)
+		}
 
-// updateConnector handles PUT /api/v1/connectors/:id
-func updateConnector(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual connector update
-	c.JSON(http.StatusOK, gin.H{
-		"id":        id,
-		"name":      "Updated Connector",
-		"type":      "postgresql",
-		"status":    "active",
-		"updatedAt": time.Now().UTC(),
-	})
-}
+		// Mock endpoint definitions
+		mocks := v1.Group("/mocks", h.mocksEnabled)
+		{
+			mocks.GET("", h.listMocks)
+			mocks.POST("", withBody(h.createMock))
+			mocks.GET("/:id", h.getMock)
+			mocks.PUT("/:id", withBody(h.updateMock))
+			mocks.DELETE("/:id", h.deleteMock)
+		}
 
-// deleteConnector handles DELETE /api/v1/connectors/:id
-func deleteConnector(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual connector deletion
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Connector deleted successfully",
-		"id":      id,
-	})
-}
+		// Trigger endpoints
+		v1.GET("/triggers", cached("flow"), h.listTriggers)
 
-// testConnector handles POST /api/v1/connectors/:id/test
-func testConnector(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual connector testing
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Connection test successful",
-		"id":      id,
-	})
-}
+		// Scheduler endpoints
+		v1.GET("/scheduler", cached("execution"), h.getScheduler)
 
-// listFlows handles GET /api/v1/flows
-func listFlows(c *gin.Context) {
-	// TODO: Implement actual flow listing
-	c.JSON(http.StatusOK, gin.H{
-		"flows": []gin.H{},
-		"total": 0,
-		"page":  1,
-		"limit": 10,
-	})
-}
+		// Startup warm-up of active flows
+		v1.GET("/warmup", h.getWarmup)
 
-// createFlow handles POST /api/v1/flows
-func createFlow(c *gin.Context) {
-	// TODO: Implement actual flow creation
-	c.JSON(http.StatusCreated, gin.H{
-		"id":          "flow_123",
-		"name":        "Test Flow",
-		"description": "A test flow",
-		"status":      "draft",
-		"createdAt":   time.Now().UTC(),
-	})
-}
+		// Cluster membership
+		v1.GET("/cluster", h.getCluster)
 
-// getFlow handles GET /api/v1/flows/:id
-func getFlow(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual flow retrieval
-	c.JSON(http.StatusOK, gin.H{
-		"id":          id,
-		"name":        "Test Flow",
-		"description": "A test flow",
-		"status":      "active",
-		"createdAt":   time.Now().UTC(),
-	})
-}
+		// Diagnostic dumps of goroutines and agent state
+		v1.POST("/diagnostics/dump", h.createDump)
 
-// updateFlow handles PUT /api/v1/flows/:id
-func updateFlow(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual flow update
-	c.JSON(http.StatusOK, gin.H{
-		"id":          id,
-		"name":        "Updated Flow",
-		"description": "An updated test flow",
-		"status":      "active",
-		"updatedAt":   time.Now().UTC(),
-	})
-}
+		// Virtual clock endpoints
+		v1.GET("/clock", h.getClock)
+		v1.POST("/clock/advance", h.advanceClock)
 
-// deleteFlow handles DELETE /api/v1/flows/:id
-func deleteFlow(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual flow deletion
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Flow deleted successfully",
-		"id":      id,
-	})
-}
+		// Snapshot endpoints
+		snapshots := v1.Group("/snapshots")
+		{
+			snapshots.POST("", h.createSnapshot)
+			snapshots.POST("/restore", h.restoreSnapshot)
+		}
 
-// activateFlow handles POST /api/v1/flows/:id/activate
-func activateFlow(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual flow activation
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Flow activated successfully",
-		"id":      id,
-		"status":  "active",
-	})
-}
+		// Schema endpoints
+		schemas := v1.Group("/schemas")
+		{
+			schemas.POST("/infer", h.inferSchema)
+		}
 
-// deactivateFlow handles POST /api/v1/flows/:id/deactivate
-func deactivateFlow(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual flow deactivation
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Flow deactivated successfully",
-		"id":      id,
-		"status":  "inactive",
-	})
-}
+		// Execution endpoints
+		executions := v1.Group("/executions")
+		{
+			executions.GET("", cached("execution"), h.listExecutions)
+			executions.POST("", withBody(h.executeFlow))
+			executions.GET("/:id", h.getExecution)
+			executions.POST("/:id/cancel", h.cancelExecution)
+			executions.POST("/:id/resume", withBody(h.resumeExecution))
+			executions.GET("/:id/logs", h.getExecutionLogs)
+			executions.GET("/:id/logs/stream", h.streamExecutionLogs)
+			executions.GET("/:id/graph", h.getExecutionGraph)
+			executions.GET("/:id/lineage", h.getExecutionLineage)
+			executions.GET("/:id/debug", h.getExecutionDebug)
+			executions.POST("/:id/debug", h.debugCommand)
+		}
 
-// listExecutions handles GET /api/v1/executions
-func listExecutions(c *gin.Context) {
-	// TODO: Implement actual execution listing
-	c.JSON(http.StatusOK, gin.H{
-		"executions": []gin.H{},
-		"total":      0,
-		"page":       1,
-		"limit":      10,
-	})
+		// Approval task endpoints
+		tasks := v1.Group("/tasks")
+		{
+			tasks.GET("", h.listTasks)
+			tasks.GET("/:id", h.getTask)
+			tasks.POST("/:id/approve", h.approveTask)
+			tasks.POST("/:id/reject", h.rejectTask)
+		}
+	}
 }
 
-// executeFlow handles POST /api/v1/executions
-func executeFlow(c *gin.Context) {
-	// TODO: Implement actual flow execution
-	c.JSON(http.StatusCreated, gin.H{
-		"id":        "exec_123",
-		"flowId":    "flow_123",
-		"status":    "running",
-		"startTime": time.Now().UTC(),
-	})
+// Class returns the traffic class of a request path, for listeners that
+// serve only some classes
+func Class(path string) string {
+	switch {
+	case path == "/" || path == "/health" || strings.HasPrefix(path, "/health/"),
+		strings.HasPrefix(path, "/api/v1/diagnostics/"):
+		return config.TrafficAdmin
+	case path == triggers.WebhookPrefix || strings.HasPrefix(path, triggers.WebhookPrefix+"/"),
+		path == mocks.Prefix || strings.HasPrefix(path, mocks.Prefix+"/"):
+		return config.TrafficData
+	default:
+		return config.TrafficManagement
+	}
 }
 
-// getExecution handles GET /api/v1/executions/:id
-func getExecution(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual execution retrieval
+// healthCheck handles the main health check endpoint
+func healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"id":        id,
-		"flowId":    "flow_123",
-		"status":    "completed",
-		"startTime": time.Now().UTC(),
-		"endTime":   time.Now().UTC(),
+		"status":    "healthy",
+		"timestamp": time.Now().UTC(),
+		"service":   "fusionflow-edge-agent",
+		"version":   "0.1.0",
 	})
 }
 
-// cancelExecution handles POST /api/v1/executions/:id/cancel
-func cancelExecution(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual execution cancellation
+// livenessCheck handles the liveness probe
+func livenessCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Execution cancelled successfully",
-		"id":      id,
-		"status":  "cancelled",
+		"status": "alive",
 	})
 }
 
-// getExecutionLogs handles GET /api/v1/executions/:id/logs
-func getExecutionLogs(c *gin.Context) {
-	id := c.Param("id")
-	// TODO: Implement actual log retrieval
-	c.JSON(http.StatusOK, gin.H{
-		"executionId": id,
-		"logs":        []gin.H{},
-		"total":       0,
-	})
+// readinessCheck handles the readiness probe, which fails until the active
+// flows have been warmed up
+func (h *api) readinessCheck(c *gin.Context) {
+	ready, err := h.svc.Warmup.Ready()
+	switch {
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "failed", "error": err.Error()})
+	case !ready:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
 }
 
-// loggingMiddleware adds request logging
-func loggingMiddleware(logger *logrus.Logger) gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.WithFields(logrus.Fields{
-			"client_ip":    param.ClientIP,
-			"timestamp":    param.TimeStamp.Format(time.RFC3339),
-			"method":       param.Method,
-			"path":         param.Path,
-			"protocol":     param.Request.Proto,
-			"status_code":  param.StatusCode,
-			"latency":      param.Latency,
-			"user_agent":   param.Request.UserAgent(),
-			"error":        param.ErrorMessage,
-		}).Info("HTTP Request")
-		return ""
-	})
+// getWarmup handles GET /api/v1/warmup, reporting the warm-up of each
+// active flow
+func (h *api) getWarmup(c *gin.Context) {
+	ready, _ := h.svc.Warmup.Ready()
+	statuses := h.svc.Warmup.Status()
+	c.JSON(http.StatusOK, gin.H{"ready": ready, "flows": statuses, "total": len(statuses)})
 }
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds on the lineage walked for one request
+const (
+	maxLineageAncestors   = 32
+	maxLineageDepth       = 8
+	maxLineageDescendants = 500
+)
+
+// lineageNode is an execution within a lineage. Missing is set for parents
+// that are not stored on this agent, such as upstream executions of another
+// agent.
+type lineageNode struct {
+	ID       string         `json:"id"`
+	FlowID   string         `json:"flowId,omitempty"`
+	Status   string         `json:"status,omitempty"`
+	Cause    *model.Cause   `json:"cause,omitempty"`
+	QueuedAt *time.Time     `json:"queuedAt,omitempty"`
+	EndTime  *time.Time     `json:"endTime,omitempty"`
+	Missing  bool           `json:"missing,omitempty"`
+	Children []*lineageNode `json:"children,omitempty"`
+}
+
+func newLineageNode(exec *model.Execution) *lineageNode {
+	queued := exec.QueuedAt
+	return &lineageNode{
+		ID:       exec.ID,
+		FlowID:   exec.FlowID,
+		Status:   exec.Status,
+		Cause:    exec.Cause,
+		QueuedAt: &queued,
+		EndTime:  exec.EndTime,
+	}
+}
+
+// getExecutionLineage handles GET /api/v1/executions/:id/lineage, returning
+// why the execution ran, the chain of executions that led to it, nearest
+// first, and the tree of executions it caused
+func (h *api) getExecutionLineage(c *gin.Context) {
+	exec, ok := h.execution(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	ancestors := []*lineageNode{}
+	seen := map[string]bool{exec.ID: true}
+	for cause := exec.Cause; cause != nil && cause.ParentID != ""; {
+		if seen[cause.ParentID] || len(ancestors) == maxLineageAncestors {
+			break
+		}
+		seen[cause.ParentID] = true
+		parent, err := h.svc.Executions.Get(ctx, cause.ParentID)
+		if errors.Is(err, executions.ErrNotFound) {
+			ancestors = append(ancestors, &lineageNode{ID: cause.ParentID, Missing: true})
+			break
+		}
+		if err != nil {
+			h.log(c).Errorf("Failed to get execution %s: %v", cause.ParentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get execution lineage"})
+			return
+		}
+		ancestors = append(ancestors, newLineageNode(parent))
+		cause = parent.Cause
+	}
+
+	root := newLineageNode(exec)
+	count := 0
+	truncated, err := h.descendants(ctx, root, seen, 1, &count)
+	if err != nil {
+		h.log(c).Errorf("Failed to list executions caused by %s: %v", exec.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get execution lineage"})
+		return
+	}
+	descendants := root.Children
+	if descendants == nil {
+		descendants = []*lineageNode{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":          exec.ID,
+		"flowId":      exec.FlowID,
+		"status":      exec.Status,
+		"cause":       exec.Cause,
+		"ancestors":   ancestors,
+		"descendants": descendants,
+		"truncated":   truncated,
+	})
+}
+
+// descendants fills in the tree of executions caused by node within the
+// lineage bounds, reporting whether the bounds cut it short
+func (h *api) descendants(ctx context.Context, node *lineageNode, seen map[string]bool, depth int, count *int) (bool, error) {
+	children, err := h.svc.Executions.Children(ctx, node.ID)
+	if err != nil {
+		return false, err
+	}
+	truncated := false
+	for _, child := range children {
+		if seen[child.ID] {
+			continue
+		}
+		if *count == maxLineageDescendants {
+			return true, nil
+		}
+		seen[child.ID] = true
+		*count++
+		node.Children = append(node.Children, newLineageNode(child))
+	}
+	for _, child := range node.Children {
+		if depth == maxLineageDepth {
+			return true, nil
+		}
+		more, err := h.descendants(ctx, child, seen, depth+1, count)
+		if err != nil {
+			return false, err
+		}
+		truncated = truncated || more
+	}
+	return truncated, nil
+}
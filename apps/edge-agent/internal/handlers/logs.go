@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// logTailInterval is how often a live tail reads the store, for the entries
+// of executions running on other cluster instances and to notice the end
+const logTailInterval = time.Second
+
+var executionLogFields = listFields{
+	sorts:  map[string]string{"seq": store.SortKey},
+	labels: map[string]string{"level": "level", "stepId": "step_id"},
+}
+
+// getExecutionLogs handles GET /api/v1/executions/:id/logs, paging through
+// the entries logged for the execution, filtered by level and step
+func (h *api) getExecutionLogs(c *gin.Context) {
+	exec, ok := h.execution(c)
+	if !ok {
+		return
+	}
+	q, ok := parseListQuery(c, executionLogFields)
+	if !ok {
+		return
+	}
+	logs, total, err := h.svc.Executions.Logs(c.Request.Context(), exec.ID, q.opts)
+	if err != nil {
+		h.log(c).Errorf("Failed to get logs of execution %s: %v", exec.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get execution logs"})
+		return
+	}
+	envelope := q.envelope(c, "logs", logs, total)
+	envelope["executionId"] = exec.ID
+	c.JSON(http.StatusOK, envelope)
+}
+
+// streamExecutionLogs handles GET /api/v1/executions/:id/logs/stream, a live
+// tail of the execution's log entries: the stored entries, then new ones as
+// they are logged, until the execution finishes. It is a WebSocket when the
+// request asks to upgrade and Server-Sent Events otherwise. Clients resume
+// after the entry with seq given by the after parameter, or by
+// Last-Event-ID when reconnecting to the event stream.
+func (h *api) streamExecutionLogs(c *gin.Context) {
+	exec, ok := h.execution(c)
+	if !ok {
+		return
+	}
+	after := c.Query("after")
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		after = id
+	}
+	var seq int64
+	if after != "" {
+		var err error
+		if seq, err = strconv.ParseInt(after, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be the seq of a log entry"})
+			return
+		}
+	}
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		h.websocketLogs(c, exec, seq)
+		return
+	}
+	h.eventStreamLogs(c, exec, seq)
+}
+
+// eventStreamLogs tails the logs as Server-Sent Events: a "log" event per
+// entry, with its seq as event ID, and an "end" event with the final status
+func (h *api) eventStreamLogs(c *gin.Context, exec *model.Execution, after int64) {
+	// The tail outlives the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		h.log(c).Warnf("Failed to clear the write deadline of a log stream: %v", err)
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	event := func(name, id string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			_, err = fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", id, name, data)
+		} else {
+			_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, data)
+		}
+		c.Writer.Flush()
+		return err
+	}
+	final, err := h.tailLogs(c.Request.Context(), exec, after, func(log *model.ExecutionLog) error {
+		return event("log", strconv.FormatInt(log.Seq, 10), log)
+	})
+	switch {
+	case final != nil:
+		event("end", "", gin.H{"executionId": final.ID, "status": final.Status})
+	case err != nil && c.Request.Context().Err() == nil:
+		h.log(c).Errorf("Failed to tail logs of execution %s: %v", exec.ID, err)
+		event("error", "", gin.H{"error": "failed to tail execution logs"})
+	}
+}
+
+// logFrame is a WebSocket message of a log tail
+type logFrame struct {
+	Type   string              `json:"type"`
+	Log    *model.ExecutionLog `json:"log,omitempty"`
+	Status string              `json:"status,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// websocketLogs tails the logs over a WebSocket: a "log" frame per entry and
+// an "end" frame with the final status, after which the socket is closed
+func (h *api) websocketLogs(c *gin.Context, exec *model.Execution, after int64) {
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.SetDeadline(time.Time{})
+
+		// The request context does not end with a hijacked connection, so
+		// the tail stops once the client closes the socket
+		ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+		defer cancel()
+		go func() {
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			cancel()
+		}()
+
+		final, err := h.tailLogs(ctx, exec, after, func(log *model.ExecutionLog) error {
+			return websocket.JSON.Send(ws, logFrame{Type: "log", Log: log})
+		})
+		switch {
+		case final != nil:
+			websocket.JSON.Send(ws, logFrame{Type: "end", Status: final.Status})
+		case err != nil && ctx.Err() == nil:
+			h.log(c).Errorf("Failed to tail logs of execution %s: %v", exec.ID, err)
+			websocket.JSON.Send(ws, logFrame{Type: "error", Error: "failed to tail execution logs"})
+		}
+	}).ServeHTTP(c.Writer, c.Request)
+}
+
+// tailLogs sends the log entries of exec after seq after: the stored ones,
+// then those logged on this instance as they come and those of other
+// instances as they are stored. It returns the execution's final state once
+// it has finished and its entries have been sent.
+func (h *api) tailLogs(ctx context.Context, exec *model.Execution, after int64, send func(*model.ExecutionLog) error) (*model.Execution, error) {
+	live, unsubscribe := h.svc.Logs.Subscribe(exec.ID)
+	defer unsubscribe()
+	// Entries logged from now on come live, after the buffered ones
+	if err := h.svc.Batch.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	offset := 0
+	sendStored := func() error {
+		for {
+			logs, _, err := h.svc.Executions.Logs(ctx, exec.ID, store.ListOptions{Offset: offset, Limit: maxPageLimit})
+			if err != nil {
+				return err
+			}
+			offset += len(logs)
+			for _, log := range logs {
+				if log.Seq <= after {
+					continue
+				}
+				if err := send(log); err != nil {
+					return err
+				}
+				after = log.Seq
+			}
+			if len(logs) < maxPageLimit {
+				return nil
+			}
+		}
+	}
+	if err := sendStored(); err != nil {
+		return nil, err
+	}
+	if exec.Finished() {
+		return exec, nil
+	}
+
+	ticker := time.NewTicker(logTailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case log := <-live:
+			if log.Seq <= after {
+				continue
+			}
+			if err := send(log); err != nil {
+				return nil, err
+			}
+			after = log.Seq
+		case <-ticker.C:
+			// The terminal state is stored after the entries logged
+			// before it, so these are sent by the last read
+			current, ok := h.svc.Executor.Get(exec.ID)
+			if !ok {
+				var err error
+				if current, err = h.svc.Executions.Get(ctx, exec.ID); err != nil {
+					return nil, err
+				}
+			}
+			if err := sendStored(); err != nil {
+				return nil, err
+			}
+			if current.Finished() {
+				return current, nil
+			}
+		}
+	}
+}
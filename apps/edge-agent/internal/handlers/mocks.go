@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/mocks"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+var mockListFields = listFields{
+	sorts:    map[string]string{"createdAt": store.SortCreated, "updatedAt": store.SortUpdated, "name": store.SortLabelPrefix + "name", "method": store.SortLabelPrefix + "method"},
+	labels:   map[string]string{"method": "method"},
+	contains: map[string]string{"name": "name"},
+}
+
+// mocksEnabled rejects the mock endpoints while mocks are disabled
+func (h *api) mocksEnabled(c *gin.Context) {
+	if h.svc.Mocks == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "mock endpoints are disabled"})
+	}
+}
+
+// listMocks handles GET /api/v1/mocks, filtered by method and by a part of
+// the name
+func (h *api) listMocks(c *gin.Context) {
+	q, ok := parseListQuery(c, mockListFields)
+	if !ok {
+		return
+	}
+	list, total, err := h.svc.Mocks.Page(c.Request.Context(), q.opts)
+	if err != nil {
+		h.mockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, q.envelope(c, "mocks", list, total))
+}
+
+// createMock handles POST /api/v1/mocks
+func (h *api) createMock(c *gin.Context, req *mockRequest) {
+	mock := req.mock()
+	if err := h.svc.Mocks.Create(c.Request.Context(), mock); err != nil {
+		h.mockError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, mock)
+}
+
+// getMock handles GET /api/v1/mocks/:id
+func (h *api) getMock(c *gin.Context) {
+	mock, err := h.svc.Mocks.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.mockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, mock)
+}
+
+// updateMock handles PUT /api/v1/mocks/:id
+func (h *api) updateMock(c *gin.Context, req *mockRequest) {
+	mock := req.mock()
+	mock.ID = c.Param("id")
+	if err := h.svc.Mocks.Update(c.Request.Context(), mock); err != nil {
+		h.mockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, mock)
+}
+
+// deleteMock handles DELETE /api/v1/mocks/:id
+func (h *api) deleteMock(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.svc.Mocks.Delete(c.Request.Context(), id); err != nil {
+		h.mockError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Mock deleted successfully",
+		"id":      id,
+	})
+}
+
+// mockError maps mock service errors to responses
+func (h *api) mockError(c *gin.Context, err error) {
+	var invalid *model.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		invalidProblem(c, "Invalid mock", invalid)
+	case errors.Is(err, mocks.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "mock not found", "id": c.Param("id")})
+	case errors.Is(err, mocks.ErrRouteTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log(c).Errorf("Mock operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// mockRequest is the body of the mock write endpoints
+type mockRequest struct {
+	Name        string             `json:"name" binding:"required"`
+	Description string             `json:"description"`
+	Method      string             `json:"method" binding:"required"`
+	Path        string             `json:"path" binding:"required"`
+	Response    model.MockResponse `json:"response"`
+	Latency     string             `json:"latency"`
+}
+
+// mock returns the mock definition of the request
+func (r *mockRequest) mock() *model.Mock {
+	return &model.Mock{
+		Name:        r.Name,
+		Description: r.Description,
+		Method:      r.Method,
+		Path:        r.Path,
+		Response:    r.Response,
+		Latency:     r.Latency,
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/triggers"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ipPolicy is a parsed source-IP policy
+type ipPolicy struct {
+	name  string
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// routePolicy is the policy of the paths matching pattern
+type routePolicy struct {
+	pattern string
+	policy  *ipPolicy
+}
+
+// newIPPolicy parses cfg, whose entries were checked when the configuration
+// was loaded
+func newIPPolicy(name string, cfg config.IPPolicyConfig) *ipPolicy {
+	return &ipPolicy{name: name, allow: ipNets(cfg.Allow), deny: ipNets(cfg.Deny)}
+}
+
+// ipNets parses addresses and CIDR ranges, taking an address as the range
+// of itself alone
+func ipNets(entries []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, network)
+		}
+	}
+	return nets
+}
+
+// allows reports whether a request from ip passes the policy
+func (p *ipPolicy) allows(ip net.IP) bool {
+	if ip == nil {
+		return len(p.allow) == 0 && len(p.deny) == 0
+	}
+	for _, network := range p.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, network := range p.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkMiddleware rejects requests from source addresses the network
+// policy of their route does not allow, logging each rejection for audit.
+// Routes outside the management API and webhooks, such as the health
+// checks, are only restricted by the route policies of cfg.
+func networkMiddleware(cfg config.NetworkConfig, logger *logrus.Logger) gin.HandlerFunc {
+	api := newIPPolicy("api", cfg.API)
+	webhooks := newIPPolicy("webhooks", cfg.Webhooks)
+	routes := make([]routePolicy, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		routes = append(routes, routePolicy{pattern: route.Path, policy: newIPPolicy("route "+route.Path, route.IPPolicyConfig)})
+	}
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		var policy *ipPolicy
+		for _, route := range routes {
+			if excludedPath([]string{route.pattern}, path) {
+				policy = route.policy
+				break
+			}
+		}
+		if policy == nil {
+			switch {
+			case path == triggers.WebhookPrefix || strings.HasPrefix(path, triggers.WebhookPrefix+"/"):
+				policy = webhooks
+			case path == "/api" || strings.HasPrefix(path, "/api/"):
+				policy = api
+			}
+		}
+		if policy == nil {
+			c.Next()
+			return
+		}
+
+		clientIP := c.ClientIP()
+		if policy.allows(net.ParseIP(clientIP)) {
+			c.Next()
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"client_ip":   clientIP,
+			"remote_addr": c.Request.RemoteAddr,
+			"method":      c.Request.Method,
+			"path":        path,
+			"policy":      policy.name,
+		}).Warn("Rejected request from disallowed source address")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "source address not allowed"})
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of the list endpoints
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
+// listFields describes what a list endpoint sorts and filters on
+type listFields struct {
+	// sorts maps the values of the sort parameter to store orders; a
+	// leading "-" sorts descending
+	sorts map[string]string
+	// labels maps query parameters to the labels they must equal
+	labels map[string]string
+	// contains maps query parameters to the labels they must be part of
+	contains map[string]string
+}
+
+var (
+	flowListFields = listFields{
+		sorts:    map[string]string{"createdAt": store.SortCreated, "updatedAt": store.SortUpdated, "name": store.SortLabelPrefix + "name", "status": store.SortLabelPrefix + "status"},
+		labels:   map[string]string{"status": "status"},
+		contains: map[string]string{"name": "name"},
+	}
+	flowVersionListFields = listFields{
+		sorts:  map[string]string{"version": store.SortKey, "createdAt": store.SortCreated},
+		labels: map[string]string{"status": "status"},
+	}
+	connectorListFields = listFields{
+		sorts:    map[string]string{"createdAt": store.SortCreated, "updatedAt": store.SortUpdated, "name": store.SortLabelPrefix + "name", "type": store.SortLabelPrefix + "type"},
+		labels:   map[string]string{"type": "type"},
+		contains: map[string]string{"name": "name"},
+	}
+	executionListFields = listFields{
+		sorts:  map[string]string{"createdAt": store.SortCreated, "updatedAt": store.SortUpdated, "status": store.SortLabelPrefix + "status"},
+		labels: map[string]string{"status": "status", "flowId": "flow_id"},
+	}
+)
+
+// listQuery is the page, order and filters of a list request
+type listQuery struct {
+	page  int
+	limit int
+	opts  store.ListOptions
+}
+
+// parseListQuery reads the page, limit, sort, createdAfter and createdBefore
+// parameters and the filters of fields, responding with a problem when any
+// is invalid
+func parseListQuery(c *gin.Context, fields listFields) (*listQuery, bool) {
+	q := &listQuery{page: 1, limit: defaultPageLimit}
+	var errs []model.Problem
+	invalid := func(param, format string, args ...interface{}) {
+		errs = append(errs, model.Problem{Path: param, Code: model.ProblemInvalid, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			invalid("page", "page must be a positive integer")
+		}
+		q.page = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			invalid("limit", "limit must be between 1 and %d", maxPageLimit)
+		}
+		q.limit = n
+	}
+	if v := c.Query("sort"); v != "" {
+		field, desc := strings.CutPrefix(v, "-")
+		order, ok := fields.sorts[field]
+		if !ok {
+			invalid("sort", "sort must be one of %s, optionally prefixed with -", strings.Join(sortedKeys(fields.sorts), ", "))
+		}
+		q.opts.Sort = order
+		q.opts.Descending = desc
+	}
+	for param, dst := range map[string]*time.Time{"createdAfter": &q.opts.CreatedAfter, "createdBefore": &q.opts.CreatedBefore} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				invalid(param, "%s must be an RFC 3339 time", param)
+			}
+			*dst = t
+		}
+	}
+	for param, label := range fields.labels {
+		if v := c.Query(param); v != "" {
+			if q.opts.Labels == nil {
+				q.opts.Labels = make(map[string]string)
+			}
+			q.opts.Labels[label] = v
+		}
+	}
+	for param, label := range fields.contains {
+		if v := c.Query(param); v != "" {
+			if q.opts.Contains == nil {
+				q.opts.Contains = make(map[string]string)
+			}
+			q.opts.Contains[label] = v
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+		writeProblem(c, http.StatusBadRequest, "Invalid query parameters", fmt.Sprintf("%d parameter(s) are invalid", len(errs)), errs)
+		return nil, false
+	}
+	q.opts.Offset = (q.page - 1) * q.limit
+	q.opts.Limit = q.limit
+	return q, true
+}
+
+// envelope returns the list response: the items under key, the total
+// count, the page and links to it and its neighbours
+func (q *listQuery) envelope(c *gin.Context, key string, items interface{}, total int) gin.H {
+	links := gin.H{"self": q.link(c, q.page)}
+	if q.page*q.limit < total {
+		links["next"] = q.link(c, q.page+1)
+	}
+	if q.page > 1 {
+		links["prev"] = q.link(c, min(q.page-1, max(1, (total+q.limit-1)/q.limit)))
+	}
+	return gin.H{
+		key:     items,
+		"total": total,
+		"page":  q.page,
+		"limit": q.limit,
+		"links": links,
+	}
+}
+
+// link returns the request's URL at another page
+func (q *listQuery) link(c *gin.Context, page int) string {
+	u := *c.Request.URL
+	values := u.Query()
+	values.Set("page", strconv.Itoa(page))
+	values.Set("limit", strconv.Itoa(q.limit))
+	u.RawQuery = values.Encode()
+	return u.RequestURI()
+}
+
+// sortedKeys returns the keys of m, sorted
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
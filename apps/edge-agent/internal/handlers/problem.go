@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// problemContentType is the media type of RFC 7807 error responses
+const problemContentType = "application/problem+json"
+
+// problemType is the type of every problem the API reports, which RFC 7807
+// allows when the title and status tell problems apart
+const problemType = "about:blank"
+
+// problem is an RFC 7807 problem details response. Errors locates each
+// problem of an invalid request body, such as "steps[2].id".
+type problem struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Status   int             `json:"status"`
+	Detail   string          `json:"detail,omitempty"`
+	Instance string          `json:"instance,omitempty"`
+	Errors   []model.Problem `json:"errors,omitempty"`
+}
+
+// writeProblem responds with a problem details body
+func writeProblem(c *gin.Context, status int, title, detail string, errs []model.Problem) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(status, problem{
+		Type:     problemType,
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+		Errors:   errs,
+	})
+}
+
+// invalidProblem responds 422 with the problems of an invalid definition.
+// Problems the validator did not locate are reported without a path.
+func invalidProblem(c *gin.Context, title string, invalid *model.ValidationError) {
+	errs := invalid.Details
+	if len(errs) == 0 {
+		for _, p := range invalid.Problems {
+			errs = append(errs, model.Problem{Code: model.ProblemInvalid, Message: p})
+		}
+	}
+	writeProblem(c, http.StatusUnprocessableEntity, title, invalid.Error(), errs)
+}
+
+// withBody is the shared validation middleware of write endpoints: it binds
+// the JSON body into a new T, checks its binding tags and passes it to
+// handle. Malformed bodies are rejected with 400 and bodies breaking the
+// binding rules with 422, both as problem details.
+func withBody[T any](handle func(c *gin.Context, req *T)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := new(T)
+		if err := c.ShouldBindJSON(req); err != nil {
+			bindProblem(c, err)
+			return
+		}
+		handle(c, req)
+	}
+}
+
+// bindProblem responds with the problem of a body that failed to bind
+func bindProblem(c *gin.Context, err error) {
+	var (
+		invalid   validator.ValidationErrors
+		syntax    *json.SyntaxError
+		wrongType *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &invalid):
+		errs := make([]model.Problem, 0, len(invalid))
+		for _, fe := range invalid {
+			errs = append(errs, fieldProblem(fe))
+		}
+		writeProblem(c, http.StatusUnprocessableEntity, "Invalid request body", fmt.Sprintf("%d field(s) are invalid", len(errs)), errs)
+	case errors.Is(err, io.EOF):
+		writeProblem(c, http.StatusBadRequest, "Malformed request body", "the request body is empty", nil)
+	case errors.As(err, &syntax):
+		writeProblem(c, http.StatusBadRequest, "Malformed request body", fmt.Sprintf("invalid JSON at offset %d: %v", syntax.Offset, err), nil)
+	case errors.As(err, &wrongType):
+		msg := fmt.Sprintf("%s must be %s, not %s", wrongType.Field, jsonType(wrongType.Type.Kind()), wrongType.Value)
+		writeProblem(c, http.StatusBadRequest, "Malformed request body", msg, []model.Problem{{Path: wrongType.Field, Code: model.ProblemInvalid, Message: msg}})
+	default:
+		writeProblem(c, http.StatusBadRequest, "Malformed request body", err.Error(), nil)
+	}
+}
+
+// fieldProblem describes a field that broke one of its binding rules
+func fieldProblem(fe validator.FieldError) model.Problem {
+	path := fe.Namespace()
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		path = path[i+1:]
+	}
+	p := model.Problem{Path: path, Code: model.ProblemInvalid}
+	switch fe.Tag() {
+	case "required":
+		p.Code = model.ProblemRequired
+		p.Message = path + " is required"
+	case "min":
+		if fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map {
+			p.Code = model.ProblemRequired
+			p.Message = fmt.Sprintf("%s must have at least %s item(s)", path, fe.Param())
+			break
+		}
+		p.Message = fmt.Sprintf("%s must be at least %s", path, fe.Param())
+	case "max":
+		p.Message = fmt.Sprintf("%s must be at most %s", path, fe.Param())
+	case "url":
+		p.Message = path + " must be a URL"
+	case "oneof":
+		p.Message = fmt.Sprintf("%s must be one of: %s", path, strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		p.Message = fmt.Sprintf("%s failed the %s rule", path, fe.Tag())
+	}
+	return p
+}
+
+// jsonType names the JSON type of a Go kind for error messages
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return "a " + kind.String()
+	}
+}
+
+var useJSONNames sync.Once
+
+// registerJSONNames makes the validator name fields by their JSON names, so
+// that problem paths match the request body
+func registerJSONNames() {
+	useJSONNames.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return f.Name
+			}
+			return name
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/quarantine"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+var quarantineListFields = listFields{
+	sorts:  map[string]string{"quarantinedAt": store.SortCreated, "updatedAt": store.SortUpdated},
+	labels: map[string]string{"flowId": "flow_id", "status": "status", "reason": "reason", "stepId": "step_id"},
+}
+
+// quarantineEnabled rejects the quarantine endpoints while it is disabled
+func (h *api) quarantineEnabled(c *gin.Context) {
+	if h.svc.Quarantine == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "quarantine is disabled"})
+	}
+}
+
+// listQuarantine handles GET /api/v1/quarantine, filtered by flow, status,
+// reason and step. Payloads are left out; get a message for its payload.
+func (h *api) listQuarantine(c *gin.Context) {
+	q, ok := parseListQuery(c, quarantineListFields)
+	if !ok {
+		return
+	}
+	list, total, err := h.svc.Quarantine.Page(c.Request.Context(), q.opts)
+	if err != nil {
+		h.quarantineError(c, err)
+		return
+	}
+	for _, m := range list {
+		m.Payload = nil
+	}
+	c.JSON(http.StatusOK, q.envelope(c, "quarantine", list, total))
+}
+
+// getQuarantined handles GET /api/v1/quarantine/:id
+func (h *api) getQuarantined(c *gin.Context) {
+	m, err := h.svc.Quarantine.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.quarantineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// releaseQuarantined handles POST /api/v1/quarantine/:id/release, running
+// the message through the rest of its flow's current version
+func (h *api) releaseQuarantined(c *gin.Context) {
+	exec, err := h.svc.Quarantine.Release(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.quarantineError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, exec)
+}
+
+// discardQuarantined handles POST /api/v1/quarantine/:id/discard
+func (h *api) discardQuarantined(c *gin.Context) {
+	m, err := h.svc.Quarantine.Discard(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.quarantineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// deleteQuarantined handles DELETE /api/v1/quarantine/:id
+func (h *api) deleteQuarantined(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.svc.Quarantine.Delete(c.Request.Context(), id); err != nil {
+		h.quarantineError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Quarantined message deleted successfully",
+		"id":      id,
+	})
+}
+
+// quarantineError maps quarantine errors to responses
+func (h *api) quarantineError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, quarantine.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "quarantined message not found", "id": c.Param("id")})
+	case errors.Is(err, flows.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "flow not found"})
+	case errors.Is(err, quarantine.ErrReviewed), errors.Is(err, quarantine.ErrNoPayload):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log(c).Errorf("Quarantine operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
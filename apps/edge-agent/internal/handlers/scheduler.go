@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getScheduler handles GET /api/v1/scheduler, reporting running and queued
+// executions and queue wait times per tenant
+func (h *api) getScheduler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.svc.Dispatcher.Stats())
+}
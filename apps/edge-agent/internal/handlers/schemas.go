@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/schema"
+	"github.com/gin-gonic/gin"
+)
+
+// inferRequest is the JSON body of POST /api/v1/schemas/infer
+type inferRequest struct {
+	Samples []interface{} `json:"samples"`
+}
+
+// inferSchema handles POST /api/v1/schemas/infer. Samples are sent as
+// {"samples": [...]} in JSON, one JSON value per line as NDJSON, or as CSV
+// with a header row (one sample per record).
+func (h *api) inferSchema(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var samples []interface{}
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	switch mediaType {
+	case "text/csv":
+		samples, err = schema.ParseCSV(data)
+	case "application/x-ndjson", "application/jsonl":
+		samples, err = parseNDJSON(data)
+	default:
+		var req inferRequest
+		if err = json.Unmarshal(data, &req); err == nil {
+			samples = req.Samples
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(samples) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one sample is required"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":  schema.Infer(samples),
+		"samples": len(samples),
+	})
+}
+
+// parseNDJSON decodes one JSON value per non-empty line
+func parseNDJSON(data []byte) ([]interface{}, error) {
+	var samples []interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxImportBytes)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		samples = append(samples, v)
+	}
+	return samples, scanner.Err()
+}
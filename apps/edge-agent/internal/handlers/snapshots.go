@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/migrate"
+	"github.com/fusionflow/edge-agent/internal/snapshot"
+	"github.com/gin-gonic/gin"
+)
+
+// passphraseHeader carries the passphrase of an uploaded snapshot
+const passphraseHeader = "X-Snapshot-Passphrase"
+
+// createSnapshot handles POST /api/v1/snapshots, streaming an encrypted
+// snapshot of the store and configuration file. An error after streaming has
+// started cuts the connection; the partial archive fails to restore.
+func (h *api) createSnapshot(c *gin.Context) {
+	var req struct {
+		Passphrase string   `json:"passphrase"`
+		Exclude    []string `json:"exclude"`
+		NoConfig   bool     `json:"noConfig"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Passphrase == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passphrase is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.svc.Batch.Flush(ctx); err != nil {
+		h.log(c).Errorf("Failed to flush buffered writes before snapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to flush buffered writes"})
+		return
+	}
+	status, err := migrate.New(h.svc.Store, h.logger).Status(ctx)
+	if err != nil {
+		h.log(c).Errorf("Failed to read store schema: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read store schema"})
+		return
+	}
+
+	opts := snapshot.Options{SchemaVersion: status.Current, Exclude: req.Exclude}
+	if !req.NoConfig && h.cfg.File != "" {
+		if opts.Config, err = os.ReadFile(h.cfg.File); err != nil {
+			h.log(c).Errorf("Failed to read config file for snapshot: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read config file"})
+			return
+		}
+	}
+
+	name := fmt.Sprintf("fusionflow-%s.ffsnap", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Status(http.StatusOK)
+
+	summary, err := snapshot.Create(ctx, h.svc.Store, c.Writer, req.Passphrase, opts)
+	if err != nil {
+		h.log(c).Errorf("Failed to create snapshot: %v", err)
+		// Cut the connection so the client sees the download fail
+		if conn, _, hijackErr := c.Writer.Hijack(); hijackErr == nil {
+			conn.Close()
+		}
+		c.Abort()
+		return
+	}
+	h.log(c).Infof("Created snapshot of %d bucket(s), %d bytes", len(summary.Records), summary.Bytes)
+}
+
+// restoreSnapshot handles POST /api/v1/snapshots/restore. The body is an
+// archive from createSnapshot, the passphrase is sent in the
+// X-Snapshot-Passphrase header and force=true replaces existing data. The
+// archive is verified before the store is touched. Configuration files are
+// not restored over the API, and restored flows take effect after a restart.
+func (h *api) restoreSnapshot(c *gin.Context) {
+	passphrase := c.GetHeader(passphraseHeader)
+	if passphrase == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": passphraseHeader + " header is required"})
+		return
+	}
+
+	// Spool the upload so it can be verified in full before restoring
+	tmp, err := os.CreateTemp("", "fusionflow-snapshot-*")
+	if err != nil {
+		h.log(c).Errorf("Failed to create snapshot spool file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to receive snapshot"})
+		return
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if _, err := io.Copy(tmp, c.Request.Body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read snapshot: " + err.Error()})
+		return
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		h.log(c).Errorf("Failed to read snapshot spool file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to receive snapshot"})
+		return
+	}
+	if _, err := snapshot.Inspect(tmp, passphrase); err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		h.log(c).Errorf("Failed to read snapshot spool file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to receive snapshot"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// Commit buffered writes now so they do not land on top of the restore
+	if err := h.svc.Batch.Flush(ctx); err != nil {
+		h.log(c).Errorf("Failed to flush buffered writes before restore: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to flush buffered writes"})
+		return
+	}
+	restored, err := snapshot.Restore(ctx, h.svc.Store, tmp, passphrase, snapshot.RestoreOptions{
+		Force:            c.Query("force") == "true",
+		MaxSchemaVersion: migrate.New(h.svc.Store, h.logger).Latest(),
+	})
+	if err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+
+	h.log(c).Warnf("Restored snapshot taken %s; restart the agent to activate restored flows", restored.Manifest.CreatedAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{
+		"manifest":        restored.Manifest,
+		"records":         restored.Records,
+		"restartRequired": true,
+	})
+}
+
+// snapshotError responds to a failed snapshot restore
+func (h *api) snapshotError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, snapshot.ErrNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; set force=true to replace its data"})
+	case errors.Is(err, snapshot.ErrDecrypt), errors.Is(err, snapshot.ErrFormat), errors.Is(err, snapshot.ErrTruncated):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.log(c).Errorf("Failed to restore snapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
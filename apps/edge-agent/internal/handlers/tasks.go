@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+// listTasks handles GET /api/v1/tasks, listing the pending approval tasks,
+// or those assigned to the "assignee" query parameter
+func (h *api) listTasks(c *gin.Context) {
+	list, err := h.svc.Tasks.List(c.Request.Context(), c.Query("assignee"))
+	if err != nil {
+		h.log(c).Errorf("Failed to list tasks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tasks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tasks": list, "total": len(list)})
+}
+
+// getTask handles GET /api/v1/tasks/:id
+func (h *api) getTask(c *gin.Context) {
+	id := c.Param("id")
+	task, err := h.svc.Tasks.Get(c.Request.Context(), id)
+	if errors.Is(err, tasks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found", "id": id})
+		return
+	}
+	if err != nil {
+		h.log(c).Errorf("Failed to get task %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+// approveTask handles POST /api/v1/tasks/:id/approve
+func (h *api) approveTask(c *gin.Context) {
+	h.decideTask(c, model.TaskApproved)
+}
+
+// rejectTask handles POST /api/v1/tasks/:id/reject
+func (h *api) rejectTask(c *gin.Context) {
+	h.decideTask(c, model.TaskRejected)
+}
+
+// decideTask records the decision, with the optional user and comment of
+// the request body, and resumes the task's execution
+func (h *api) decideTask(c *gin.Context, decision string) {
+	var req struct {
+		By      string `json:"by"`
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	id := c.Param("id")
+	exec, err := h.svc.Tasks.Decide(c.Request.Context(), id, model.TaskDecision{Decision: decision, By: req.By, Comment: req.Comment})
+	switch {
+	case errors.Is(err, tasks.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found or already decided", "id": id})
+		return
+	case errors.Is(err, tasks.ErrNotAssigned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "task is not assigned to the user", "id": id, "by": req.By})
+		return
+	case err != nil:
+		h.log(c).Errorf("Failed to decide task %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decide task"})
+		return
+	}
+	h.log(c).Infof("Task %s %s, resuming execution %s", id, decision, exec.ID)
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "decision": decision, "execution": exec})
+}
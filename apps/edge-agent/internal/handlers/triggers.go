@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/fusionflow/edge-agent/internal/triggers"
+	"github.com/gin-gonic/gin"
+)
+
+// listTriggers handles GET /api/v1/triggers, listing the registered trigger
+// types and the state and health of every running trigger
+func (h *api) listTriggers(c *gin.Context) {
+	statuses := h.svc.Triggers.Status("")
+	c.JSON(http.StatusOK, gin.H{
+		"types":    triggers.Types(),
+		"triggers": statuses,
+		"total":    len(statuses),
+	})
+}
+
+// getFlowTriggers handles GET /api/v1/flows/:id/triggers
+func (h *api) getFlowTriggers(c *gin.Context) {
+	id := c.Param("id")
+	statuses := h.svc.Triggers.Status(id)
+	c.JSON(http.StatusOK, gin.H{"flowId": id, "triggers": statuses, "total": len(statuses)})
+}
+
+// pauseFlow handles POST /api/v1/flows/:id/pause
+func (h *api) pauseFlow(c *gin.Context) {
+	h.triggerCommand(c, h.svc.Triggers.Pause)
+}
+
+// resumeFlow handles POST /api/v1/flows/:id/resume
+func (h *api) resumeFlow(c *gin.Context) {
+	h.triggerCommand(c, h.svc.Triggers.Resume)
+}
+
+func (h *api) triggerCommand(c *gin.Context, fn func(ctx context.Context, flowID string) error) {
+	id := c.Param("id")
+	err := fn(c.Request.Context(), id)
+	if errors.Is(err, triggers.ErrNotRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "id": id})
+		return
+	}
+	if err != nil {
+		h.log(c).Errorf("Failed to change triggers of flow %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	statuses := h.svc.Triggers.Status(id)
+	c.JSON(http.StatusOK, gin.H{"flowId": id, "triggers": statuses, "total": len(statuses)})
+}
@@ -0,0 +1,15 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// New returns a random identifier with the given prefix, e.g. "exec_3f9a0c1d2b4e5f60"
+func New(prefix string) string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("ids: crypto/rand unavailable: " + err.Error())
+	}
+	return prefix + "_" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,354 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// asyncAPIProtocols maps AsyncAPI server protocols to connector types
+var asyncAPIProtocols = map[string]string{
+	"kafka":        "kafka",
+	"kafka-secure": "kafka",
+	"mqtt":         "mqtt",
+	"mqtts":        "mqtt",
+	"secure-mqtt":  "mqtt",
+	"mqtt5":        "mqtt",
+	"amqp":         "amqp",
+	"amqps":        "amqp",
+}
+
+// asyncAPIOperation is one send or receive operation on a channel, normalized
+// across AsyncAPI 2.x and 3.x documents
+type asyncAPIOperation struct {
+	id       string
+	summary  string
+	action   string
+	address  string
+	servers  []string
+	messages []map[string]interface{}
+	bindings map[string]interface{}
+	channel  map[string]interface{}
+}
+
+// importAsyncAPI generates messaging connectors from an AsyncAPI 2.x or 3.x
+// document, one per supported server, with a send or receive operation per
+// channel whose message schema validates payloads
+func importAsyncAPI(data []byte) (*Result, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse AsyncAPI document: %w", err)
+	}
+	root := asMap(normalizeYAML(raw))
+	version := asString(root["asyncapi"])
+	if !strings.HasPrefix(version, "2.") && !strings.HasPrefix(version, "3.") {
+		return nil, errors.New("only AsyncAPI 2.x and 3.x documents are supported")
+	}
+
+	info := asMap(root["info"])
+	title := asString(info["title"])
+	if title == "" {
+		title = "asyncapi"
+	}
+	result := &Result{}
+	g := &asyncAPIGenerator{name: title, result: result, v3: strings.HasPrefix(version, "3.")}
+	g.resolver = &resolver{root: root, warn: func(ref string) {
+		g.warn(ref, "reference cannot be resolved; left as-is")
+	}}
+
+	var ops []asyncAPIOperation
+	if g.v3 {
+		ops = g.operationsV3(asMap(root["operations"]))
+	} else {
+		ops = g.operationsV2(asMap(root["channels"]))
+	}
+
+	servers := asMap(root["servers"])
+	if len(servers) == 0 {
+		g.warn("", "document declares no servers; no connectors generated")
+		return result, nil
+	}
+	for _, name := range sortedKeys(servers) {
+		server := asMap(g.resolve(servers[name]))
+		protocol := asString(server["protocol"])
+		connType, ok := asyncAPIProtocols[protocol]
+		if !ok {
+			g.warn(name, "server protocol %q is not supported; skipped", protocol)
+			continue
+		}
+
+		conn := &model.Connector{
+			Name:        title,
+			Type:        connType,
+			Description: asString(info["description"]),
+			Config:      map[string]interface{}{"brokers": g.serverAddress(server)},
+			Auth:        g.auth(name, server),
+		}
+		if len(servers) > 1 {
+			conn.Name = fmt.Sprintf("%s (%s)", title, name)
+		}
+		if v := asString(server["protocolVersion"]); v != "" {
+			conn.Config["protocolVersion"] = v
+		}
+		if bindings := asMap(asMap(server["bindings"])[protocol]); bindings != nil {
+			conn.Config["bindings"] = bindings
+		} else if bindings := asMap(asMap(server["bindings"])[connType]); bindings != nil {
+			conn.Config["bindings"] = bindings
+		}
+
+		for _, op := range ops {
+			if len(op.servers) > 0 && !slices.Contains(op.servers, name) {
+				continue
+			}
+			conn.Operations = append(conn.Operations, g.operation(op, connType))
+		}
+		if len(conn.Operations) == 0 {
+			g.warn(name, "server has no channels; connector has no operations")
+		}
+		result.Connectors = append(result.Connectors, conn)
+	}
+	return result, nil
+}
+
+// asyncAPIGenerator builds connectors from one document
+type asyncAPIGenerator struct {
+	*resolver
+	name   string
+	result *Result
+	v3     bool
+}
+
+// warn records a warning about an element of the document
+func (g *asyncAPIGenerator) warn(node, format string, args ...interface{}) {
+	g.result.Warnings = append(g.result.Warnings, Warning{
+		Target:  g.name,
+		Node:    node,
+		Type:    "asyncapi",
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// operationsV2 collects operations from 2.x channels. In 2.x, publish
+// describes messages the application receives and subscribe those it sends.
+func (g *asyncAPIGenerator) operationsV2(channels map[string]interface{}) []asyncAPIOperation {
+	var ops []asyncAPIOperation
+	for _, address := range sortedKeys(channels) {
+		channel := asMap(g.resolve(channels[address]))
+		var servers []string
+		for _, s := range asList(channel["servers"]) {
+			servers = append(servers, asString(s))
+		}
+		for _, kind := range []string{"publish", "subscribe"} {
+			def := asMap(channel[kind])
+			if def == nil {
+				continue
+			}
+			action := model.ActionReceive
+			if kind == "subscribe" {
+				action = model.ActionSend
+			}
+			ops = append(ops, asyncAPIOperation{
+				id:       asString(def["operationId"]),
+				summary:  asString(def["summary"]),
+				action:   action,
+				address:  address,
+				servers:  servers,
+				messages: g.messages(def["message"]),
+				bindings: asMap(def["bindings"]),
+				channel:  channel,
+			})
+		}
+	}
+	return ops
+}
+
+// operationsV3 collects operations from the 3.x operations object
+func (g *asyncAPIGenerator) operationsV3(operations map[string]interface{}) []asyncAPIOperation {
+	var ops []asyncAPIOperation
+	for _, id := range sortedKeys(operations) {
+		def := asMap(g.resolve(operations[id]))
+		channel := asMap(def["channel"])
+		address := asString(channel["address"])
+		if address == "" {
+			g.warn(id, "operation channel has no address; skipped")
+			continue
+		}
+
+		var servers []string
+		for _, s := range asList(channel["servers"]) {
+			// Resolved server refs lose their name; match them back by host
+			servers = append(servers, g.serverName(asMap(s)))
+		}
+
+		var messages []map[string]interface{}
+		list := asList(def["messages"])
+		if len(list) == 0 {
+			// No explicit list means every message of the channel
+			channelMessages := asMap(channel["messages"])
+			for _, name := range sortedKeys(channelMessages) {
+				list = append(list, channelMessages[name])
+			}
+		}
+		for _, msg := range list {
+			messages = append(messages, g.messages(msg)...)
+		}
+
+		action := asString(def["action"])
+		if action != model.ActionSend && action != model.ActionReceive {
+			g.warn(id, "unknown operation action %q; skipped", action)
+			continue
+		}
+		ops = append(ops, asyncAPIOperation{
+			id:       id,
+			summary:  asString(def["summary"]),
+			action:   action,
+			address:  address,
+			servers:  servers,
+			messages: messages,
+			bindings: asMap(def["bindings"]),
+			channel:  channel,
+		})
+	}
+	return ops
+}
+
+// serverName finds the name of a resolved 3.x server definition
+func (g *asyncAPIGenerator) serverName(server map[string]interface{}) string {
+	servers := asMap(g.root["servers"])
+	for _, name := range sortedKeys(servers) {
+		candidate := asMap(servers[name])
+		if asString(candidate["host"]) == asString(server["host"]) &&
+			asString(candidate["protocol"]) == asString(server["protocol"]) {
+			return name
+		}
+	}
+	return ""
+}
+
+// messages returns the message definitions in v, expanding 2.x oneOf lists
+func (g *asyncAPIGenerator) messages(v interface{}) []map[string]interface{} {
+	msg := asMap(g.resolve(v))
+	if msg == nil {
+		return nil
+	}
+	if oneOf := asList(msg["oneOf"]); len(oneOf) > 0 {
+		var out []map[string]interface{}
+		for _, item := range oneOf {
+			out = append(out, g.messages(item)...)
+		}
+		return out
+	}
+	return []map[string]interface{}{msg}
+}
+
+// operation converts a normalized operation for a connector of connType
+func (g *asyncAPIGenerator) operation(op asyncAPIOperation, connType string) model.Operation {
+	id := op.id
+	if id == "" {
+		id = operationID(op.action, op.address)
+	}
+	out := model.Operation{
+		ID:      id,
+		Summary: op.summary,
+		Action:  op.action,
+		Channel: op.address,
+	}
+
+	var payloads []interface{}
+	for _, msg := range op.messages {
+		if ct := asString(msg["contentType"]); ct != "" && out.ContentType == "" {
+			out.ContentType = ct
+		}
+		if format := asString(msg["schemaFormat"]); format != "" && !strings.Contains(format, "json") && !strings.Contains(format, "asyncapi") {
+			g.warn(id, "message schema format %q cannot be used for payload validation", format)
+			continue
+		}
+		if payload := asMap(msg["payload"]); payload != nil {
+			if g.v3 && payload["schema"] != nil && payload["schemaFormat"] != nil {
+				// 3.x multi-format schema object
+				payload = asMap(payload["schema"])
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+	switch len(payloads) {
+	case 0:
+		g.warn(id, "operation has no message payload schema; payloads are not validated")
+	case 1:
+		out.Message = asMap(payloads[0])
+	default:
+		out.Message = map[string]interface{}{"oneOf": payloads}
+	}
+
+	bindings := map[string]interface{}{}
+	if b := asMap(asMap(op.channel["bindings"])[connType]); b != nil {
+		bindings["channel"] = b
+	}
+	if b := asMap(op.bindings[connType]); b != nil {
+		bindings["operation"] = b
+	}
+	for _, msg := range op.messages {
+		if b := asMap(asMap(msg["bindings"])[connType]); b != nil {
+			bindings["message"] = b
+			break
+		}
+	}
+	if len(bindings) > 0 {
+		out.Bindings = bindings
+	}
+	return out
+}
+
+// serverAddress returns the broker address of a 2.x (url) or 3.x (host) server
+func (g *asyncAPIGenerator) serverAddress(server map[string]interface{}) string {
+	address := asString(server["url"])
+	if address == "" {
+		address = asString(server["host"]) + asString(server["pathname"])
+	}
+	for name, v := range asMap(server["variables"]) {
+		address = strings.ReplaceAll(address, "{"+name+"}", asString(asMap(v)["default"]))
+	}
+	return address
+}
+
+// auth maps the server's first security scheme onto connector auth
+func (g *asyncAPIGenerator) auth(name string, server map[string]interface{}) *model.ConnectorAuth {
+	var scheme map[string]interface{}
+	for _, requirement := range asList(server["security"]) {
+		req := asMap(requirement)
+		if g.v3 {
+			// 3.x lists (resolved) security scheme objects directly
+			scheme = req
+		} else {
+			schemes := asMap(asMap(g.root["components"])["securitySchemes"])
+			for _, key := range sortedKeys(req) {
+				scheme = asMap(schemes[key])
+				break
+			}
+		}
+		if scheme != nil {
+			break
+		}
+	}
+	if scheme == nil {
+		return nil
+	}
+
+	switch t := asString(scheme["type"]); t {
+	case "userPassword", "plain":
+		return &model.ConnectorAuth{Type: "basic"}
+	case "scramSha256":
+		return &model.ConnectorAuth{Type: "scram-sha-256"}
+	case "scramSha512":
+		return &model.ConnectorAuth{Type: "scram-sha-512"}
+	case "X509":
+		return &model.ConnectorAuth{Type: "x509"}
+	default:
+		g.warn(name, "security scheme type %q is not supported; configure auth by hand", t)
+		return nil
+	}
+}
@@ -0,0 +1,461 @@
+package importer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// camelLanguages are the expression languages recognised in Camel expressions
+var camelLanguages = []string{"simple", "constant", "jsonpath", "header", "exchangeProperty", "xpath", "jq", "groovy", "js", "method"}
+
+// importCamel converts routes written in the Camel YAML DSL, producing one flow per route
+func importCamel(data []byte) (*Result, error) {
+	var raw []interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse Camel YAML routes: %w", err)
+	}
+
+	result := &Result{}
+	for i, v := range raw {
+		item := asMap(normalizeYAML(v))
+		for _, kind := range sortedKeys(item) {
+			var route, from map[string]interface{}
+			switch kind {
+			case "route":
+				route = asMap(item[kind])
+				from = asMap(route["from"])
+			case "from":
+				route = map[string]interface{}{}
+				from = asMap(item[kind])
+			default:
+				result.Warnings = append(result.Warnings, Warning{
+					Node:    fmt.Sprintf("item %d", i+1),
+					Type:    kind,
+					Message: "top-level element is not supported; skipped",
+				})
+				continue
+			}
+
+			name := asString(route["id"])
+			if name == "" {
+				name = fmt.Sprintf("route-%d", i+1)
+			}
+			c := &camelConverter{
+				flow:   &model.Flow{Name: name, Description: asString(route["description"])},
+				result: result,
+				ids:    make(map[string]bool),
+			}
+			if from == nil {
+				c.warn(name, kind, "route has no from endpoint; skipped")
+				continue
+			}
+			c.from(from)
+			c.steps(asList(from["steps"]), nil)
+
+			if len(c.flow.Steps) == 0 {
+				c.warn(name, kind, "route has no convertible steps; skipped")
+				continue
+			}
+			result.Flows = append(result.Flows, c.flow)
+		}
+	}
+	return result, nil
+}
+
+// camelConverter builds one flow from a Camel route
+type camelConverter struct {
+	flow   *model.Flow
+	result *Result
+	ids    map[string]bool
+}
+
+// warn records a warning about a route element
+func (c *camelConverter) warn(node, kind, format string, args ...interface{}) {
+	c.result.Warnings = append(c.result.Warnings, Warning{
+		Target:  c.flow.Name,
+		Node:    node,
+		Type:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// id returns the element's own ID if it has a usable one, otherwise a generated one
+func (c *camelConverter) id(kind string, def map[string]interface{}) string {
+	if id := asString(def["id"]); id != "" && !c.ids[id] {
+		c.ids[id] = true
+		return id
+	}
+	for n := len(c.flow.Steps) + 1; ; n++ {
+		id := fmt.Sprintf("%s-%d", strings.ToLower(kind), n)
+		if !c.ids[id] {
+			c.ids[id] = true
+			return id
+		}
+	}
+}
+
+// add appends step to the flow with edges from every step in prev
+func (c *camelConverter) add(step model.Step, prev []string) {
+	c.flow.Steps = append(c.flow.Steps, step)
+	for _, from := range prev {
+		c.flow.Edges = append(c.flow.Edges, model.Edge{From: from, To: step.ID})
+	}
+}
+
+// from converts the route's consumer endpoint into a trigger
+func (c *camelConverter) from(def map[string]interface{}) {
+	uri := asString(def["uri"])
+	scheme, path, params := parseCamelURI(uri, asMap(def["parameters"]))
+
+	var trigger model.Trigger
+	switch scheme {
+	case "timer":
+		period := asString(params["period"])
+		if period == "" {
+			period = "1000"
+		}
+		trigger = model.Trigger{Type: "interval", Config: map[string]interface{}{"interval": camelDuration(period)}}
+	case "cron":
+		trigger = model.Trigger{Type: "cron", Config: map[string]interface{}{"schedule": asString(params["schedule"])}}
+	case "quartz":
+		trigger = model.Trigger{Type: "cron", Config: map[string]interface{}{"schedule": asString(params["cron"])}}
+	case "platform-http", "rest", "jetty", "netty-http", "undertow", "servlet":
+		config := map[string]interface{}{"path": httpPath(path)}
+		if method := asString(params["httpMethodRestrict"]); method != "" {
+			config["method"] = method
+		}
+		trigger = model.Trigger{Type: "webhook", Config: config}
+	case "kafka":
+		trigger = model.Trigger{Type: "kafka", Config: endpointConfig(params, map[string]interface{}{"topic": path})}
+	case "sftp", "ftp", "ftps":
+		trigger = model.Trigger{Type: scheme, Config: remoteFileConfig(path, params)}
+	default:
+		c.warn(uri, "from", "consumer endpoint %q is not supported; flow has no trigger", scheme)
+		return
+	}
+	c.flow.Triggers = append(c.flow.Triggers, trigger)
+}
+
+// steps converts a step list in sequence, starting from the steps in prev,
+// and returns the steps the sequence ends on
+func (c *camelConverter) steps(list []interface{}, prev []string) []string {
+	for _, item := range list {
+		def := asMap(item)
+		for _, kind := range sortedKeys(def) {
+			prev = c.step(kind, def[kind], prev)
+		}
+	}
+	return prev
+}
+
+// step converts one step (and any nested steps) and returns the steps it ends on
+func (c *camelConverter) step(kind string, body interface{}, prev []string) []string {
+	def := asMap(body)
+	if def == nil {
+		// Short forms such as `log: ${body}` or `to: kafka:orders`
+		def = map[string]interface{}{}
+	}
+	step := model.Step{ID: c.id(kind, def), Config: map[string]interface{}{}}
+
+	switch kind {
+	case "to", "toD":
+		uri := asString(body)
+		if uri == "" {
+			uri = asString(def["uri"])
+		}
+		if !c.endpoint(&step, uri, asMap(def["parameters"])) {
+			return c.unsupported(step.ID, kind, body, prev)
+		}
+		if kind == "toD" {
+			c.warn(step.ID, kind, "dynamic endpoint URI copied verbatim; review its expressions")
+		}
+	case "log":
+		c.warn(step.ID, kind, "there is no log step; step results are recorded in the execution history")
+		return c.unsupported(step.ID, kind, body, prev)
+	case "setBody", "transform", "setHeader", "setProperty", "marshal", "unmarshal":
+		c.warn(step.ID, kind, "Camel expressions and data formats are not converted; replace it with a map step")
+		return c.unsupported(step.ID, kind, body, prev)
+	case "delay":
+		step.Type = "delay"
+		_, expr := camelExpression(def)
+		step.Config["duration"] = camelDuration(expr)
+	case "filter":
+		c.warn(step.ID, kind, "filters are not converted; its nested steps follow an unsupported placeholder step")
+		c.add(unsupportedStep(step.ID, FormatCamel, kind, body), prev)
+		// Exchanges that do not match skip the nested steps and carry on
+		return appendUnique(c.steps(asList(def["steps"]), []string{step.ID}), step.ID)
+	case "choice":
+		return c.choice(step.ID, body, def, prev)
+	case "split":
+		c.warn(step.ID, kind, "splitters are not converted; its nested steps follow an unsupported placeholder step")
+		c.add(unsupportedStep(step.ID, FormatCamel, kind, body), prev)
+		// Nested steps run once per part; the route continues with the original message
+		c.steps(asList(def["steps"]), []string{step.ID})
+		return []string{step.ID}
+	default:
+		c.warn(step.ID, kind, "step type is not supported; added as an unsupported placeholder step")
+		return c.unsupported(step.ID, kind, body, prev)
+	}
+
+	c.add(step, prev)
+	return []string{step.ID}
+}
+
+// unsupported adds a placeholder step for a step that could not be converted
+func (c *camelConverter) unsupported(id, kind string, body interface{}, prev []string) []string {
+	c.add(unsupportedStep(id, FormatCamel, kind, body), prev)
+	return []string{id}
+}
+
+// choice converts the branches of a content-based router, which has no
+// equivalent step type, after a placeholder step noting the when clause
+// leading to each
+func (c *camelConverter) choice(id string, body interface{}, def map[string]interface{}, prev []string) []string {
+	c.warn(id, "choice", "content-based routers are not converted; its branches follow an unsupported placeholder step")
+	c.add(unsupportedStep(id, FormatCamel, "choice", body), prev)
+	index := len(c.flow.Steps) - 1
+
+	var (
+		tails    []string
+		branches []interface{}
+	)
+	for _, item := range asList(def["when"]) {
+		when := asMap(item)
+		language, expr := camelExpression(when)
+		branch := map[string]interface{}{"language": language, "expression": expr}
+		first := len(c.flow.Steps)
+		tails = appendUnique(tails, c.steps(asList(when["steps"]), []string{id})...)
+		if first < len(c.flow.Steps) {
+			branch["next"] = c.flow.Steps[first].ID
+		}
+		branches = append(branches, branch)
+	}
+	c.flow.Steps[index].Config["branches"] = branches
+
+	otherwise := asMap(def["otherwise"])
+	if otherwise == nil {
+		// Unmatched exchanges continue past the choice
+		return appendUnique(tails, id)
+	}
+	first := len(c.flow.Steps)
+	tails = appendUnique(tails, c.steps(asList(otherwise["steps"]), []string{id})...)
+	if first < len(c.flow.Steps) {
+		c.flow.Steps[index].Config["otherwise"] = c.flow.Steps[first].ID
+	}
+	return tails
+}
+
+// endpoint fills in a producer step from uri, reporting whether the endpoint is supported
+func (c *camelConverter) endpoint(step *model.Step, uri string, extra map[string]interface{}) bool {
+	scheme, path, params := parseCamelURI(uri, extra)
+	switch scheme {
+	case "http", "https":
+		step.Type = "http"
+		method := asString(params["httpMethod"])
+		delete(params, "httpMethod")
+		if method == "" {
+			method = "POST"
+		}
+		step.Config["method"] = method
+		target := scheme + "://" + path
+		if len(params) > 0 {
+			query := url.Values{}
+			for k, v := range params {
+				query.Set(k, asString(v))
+			}
+			target += "?" + query.Encode()
+		}
+		step.Config["url"] = target
+	case "kafka":
+		step.Type = "kafka"
+		step.Config = endpointConfig(params, map[string]interface{}{"topic": path})
+	case "file":
+		step.Type = "file-write"
+		config := map[string]interface{}{"directory": path}
+		if name := asString(params["fileName"]); name != "" {
+			delete(params, "fileName")
+			config["fileName"] = name
+		}
+		step.Config = endpointConfig(params, config)
+	case "sql":
+		c.warn(step.ID, "to", "SQL endpoints are not converted; write through a db-write step with a SQL connector")
+		return false
+	case "direct", "seda", "vm":
+		c.warn(step.ID, "to", "calls to other Camel routes are not converted; inline the target route")
+		return false
+	default:
+		c.warn(step.ID, "to", "producer endpoint %q is not supported; added as an unsupported placeholder step", scheme)
+		return false
+	}
+	return true
+}
+
+// parseCamelURI splits an endpoint URI into its scheme, path and options,
+// merging options given separately as parameters
+func parseCamelURI(uri string, extra map[string]interface{}) (string, string, map[string]interface{}) {
+	scheme, rest, _ := strings.Cut(uri, ":")
+	path, query, _ := strings.Cut(rest, "?")
+	path = strings.TrimPrefix(path, "//")
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+
+	params := make(map[string]interface{})
+	if values, err := url.ParseQuery(query); err == nil {
+		for k := range values {
+			params[k] = values.Get(k)
+		}
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return scheme, path, params
+}
+
+// endpointConfig merges endpoint options under base, keeping base's keys
+func endpointConfig(params, base map[string]interface{}) map[string]interface{} {
+	if len(params) > 0 {
+		base["options"] = params
+	}
+	return base
+}
+
+// remoteFileConfig converts the [user@]host[:port]/directory path and the
+// options of an SFTP or FTP consumer endpoint into a remote file trigger
+// config
+func remoteFileConfig(path string, params map[string]interface{}) map[string]interface{} {
+	authority, directory, _ := strings.Cut(path, "/")
+	user, host, found := strings.Cut(authority, "@")
+	if !found {
+		user, host = "", authority
+	}
+	config := map[string]interface{}{"host": host, "directory": "/" + directory}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(port); err == nil {
+			config["host"], config["port"] = h, n
+		}
+	}
+	if user != "" {
+		config["username"] = user
+	}
+	for option, key := range map[string]string{
+		"username":       "username",
+		"password":       "password",
+		"privateKeyFile": "privateKeyFile",
+		"knownHostsFile": "knownHostsFile",
+		"antInclude":     "pattern",
+		"move":           "moveTo",
+		"moveFailed":     "failedTo",
+	} {
+		if value := asString(params[option]); value != "" {
+			delete(params, option)
+			config[key] = value
+		}
+	}
+	if delay := asString(params["delay"]); delay != "" {
+		delete(params, "delay")
+		config["interval"] = camelDuration(delay)
+	}
+	remove, noop := asString(params["delete"]) == "true", asString(params["noop"]) == "true"
+	delete(params, "delete")
+	delete(params, "noop")
+	switch {
+	case remove:
+		config["after"] = "delete"
+	case config["moveTo"] != nil:
+		config["after"] = "move"
+	case noop:
+		config["after"] = "none"
+	}
+	return endpointConfig(params, config)
+}
+
+// camelExpression extracts the language and text of the expression in def
+func camelExpression(def map[string]interface{}) (string, string) {
+	if wrapped := asMap(def["expression"]); wrapped != nil {
+		def = wrapped
+	}
+	for _, language := range camelLanguages {
+		value, ok := def[language]
+		if !ok {
+			continue
+		}
+		if expr := asString(value); expr != "" {
+			return language, expr
+		}
+		return language, asString(asMap(value)["expression"])
+	}
+	return "", ""
+}
+
+// camelDuration converts a Camel duration (milliseconds unless suffixed) into a duration string
+func camelDuration(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return value
+	}
+	return value + "ms"
+}
+
+// httpPath returns the path of an HTTP consumer endpoint, which may be a full URL
+func httpPath(path string) string {
+	if u, err := url.Parse(path); err == nil && u.Host != "" {
+		path = u.Path
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// appendUnique appends the IDs not already in list
+func appendUnique(list []string, ids ...string) []string {
+	for _, id := range ids {
+		if !slices.Contains(list, id) {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+// asMap returns v as a map, or nil
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// asList returns v as a list, or nil
+func asList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// asString returns v formatted as a string, or "" for non-scalar values
+func asString(v interface{}) string {
+	switch v := v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sortedKeys returns m's keys in order so conversions are deterministic
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Supported source formats. Node-RED and Camel definitions convert into
+// flows; OpenAPI and AsyncAPI documents generate connectors.
+const (
+	FormatNodeRED  = "node-red"
+	FormatCamel    = "camel"
+	FormatOpenAPI  = "openapi"
+	FormatAsyncAPI = "asyncapi"
+)
+
+// StepTypeUnsupported marks a placeholder for a source node that could not be
+// converted. It keeps the flow's shape intact so the node can be replaced by
+// hand before the flow is activated.
+const StepTypeUnsupported = "unsupported"
+
+// ErrUnknownFormat is returned for source formats the importer does not handle
+var ErrUnknownFormat = errors.New("unknown import format")
+
+// Warning flags a source element that was converted partially or not at all.
+// Target names the flow or connector being generated.
+type Warning struct {
+	Target  string `json:"target,omitempty"`
+	Node    string `json:"node,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+// String implements fmt.Stringer
+func (w Warning) String() string {
+	msg := w.Message
+	if w.Node != "" {
+		msg = fmt.Sprintf("%s (%s): %s", w.Node, w.Type, msg)
+	}
+	if w.Target != "" {
+		msg = w.Target + ": " + msg
+	}
+	return msg
+}
+
+// Result holds the converted definitions and the warnings raised while converting them
+type Result struct {
+	Flows      []*model.Flow      `json:"flows,omitempty"`
+	Connectors []*model.Connector `json:"connectors,omitempty"`
+	Warnings   []Warning          `json:"warnings,omitempty"`
+}
+
+// FlowFormats returns the source formats that convert into flows
+func FlowFormats() []string {
+	return []string{FormatNodeRED, FormatCamel}
+}
+
+// ConnectorFormats returns the source formats that generate connectors
+func ConnectorFormats() []string {
+	return []string{FormatOpenAPI, FormatAsyncAPI}
+}
+
+// Formats returns every supported source format
+func Formats() []string {
+	return append(FlowFormats(), ConnectorFormats()...)
+}
+
+// Import converts a definition in the given format into FusionFlow flows or connectors
+func Import(format string, data []byte) (*Result, error) {
+	switch format {
+	case FormatNodeRED:
+		return importNodeRED(data)
+	case FormatCamel:
+		return importCamel(data)
+	case FormatOpenAPI:
+		return importOpenAPI(data)
+	case FormatAsyncAPI:
+		return importAsyncAPI(data)
+	default:
+		return nil, fmt.Errorf("%w %q (supported: %s)", ErrUnknownFormat, format, strings.Join(Formats(), ", "))
+	}
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values YAML produces
+// for non-string keys (such as response codes) into JSON-compatible maps
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[fmt.Sprint(k)] = normalizeYAML(child)
+		}
+		return out
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = normalizeYAML(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeYAML(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// unsupportedStep builds a placeholder step that preserves the original definition
+func unsupportedStep(id, format, sourceType string, definition interface{}) model.Step {
+	return model.Step{
+		ID:   id,
+		Type: StepTypeUnsupported,
+		Config: map[string]interface{}{
+			"format":     format,
+			"sourceType": sourceType,
+			"definition": definition,
+		},
+	}
+}
@@ -0,0 +1,270 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// nodeREDNode is the part of a Node-RED node common to every type. The
+// type-specific properties are read from the raw definition.
+type nodeREDNode struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Z        string     `json:"z"`
+	Name     string     `json:"name"`
+	Label    string     `json:"label"`
+	Info     string     `json:"info"`
+	Disabled bool       `json:"disabled"`
+	D        bool       `json:"d"`
+	Wires    [][]string `json:"wires"`
+
+	raw map[string]interface{}
+}
+
+// str returns a string property of the node's raw definition
+func (n *nodeREDNode) str(key string) string {
+	s, _ := n.raw[key].(string)
+	return s
+}
+
+// displayName returns the node's name, falling back to its ID
+func (n *nodeREDNode) displayName() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	return n.ID
+}
+
+// nodeREDIgnored lists node types with no runtime behaviour that are dropped silently
+var nodeREDIgnored = map[string]bool{
+	"comment":     true,
+	"group":       true,
+	"mqtt-broker": true,
+	"tls-config":  true,
+	"http proxy":  true,
+}
+
+// importNodeRED converts a Node-RED flow export (a JSON array of nodes),
+// producing one flow per tab
+func importNodeRED(data []byte) (*Result, error) {
+	var raws []map[string]interface{}
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("failed to parse Node-RED flows: %w", err)
+	}
+
+	nodes := make([]*nodeREDNode, 0, len(raws))
+	for i, raw := range raws {
+		encoded, _ := json.Marshal(raw)
+		node := &nodeREDNode{raw: raw}
+		if err := json.Unmarshal(encoded, node); err != nil {
+			return nil, fmt.Errorf("failed to parse Node-RED node %d: %w", i, err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	result := &Result{}
+	var tabs []*nodeREDNode
+	members := make(map[string][]*nodeREDNode)
+	for _, node := range nodes {
+		switch {
+		case node.Type == "tab":
+			tabs = append(tabs, node)
+		case node.Z != "":
+			members[node.Z] = append(members[node.Z], node)
+		}
+	}
+
+	for _, tab := range tabs {
+		name := tab.Label
+		if name == "" {
+			name = "Imported flow " + tab.ID
+		}
+		if tab.Disabled {
+			result.Warnings = append(result.Warnings, Warning{Target: name, Message: "tab is disabled in Node-RED; imported as a draft anyway"})
+		}
+
+		c := &nodeREDConverter{flow: &model.Flow{Name: name, Description: tab.Info}, result: result}
+		for _, node := range members[tab.ID] {
+			c.convert(node)
+		}
+		c.wire(members[tab.ID])
+
+		if len(c.flow.Steps) == 0 {
+			result.Warnings = append(result.Warnings, Warning{Target: name, Message: "tab has no convertible steps; skipped"})
+			continue
+		}
+		result.Flows = append(result.Flows, c.flow)
+	}
+	return result, nil
+}
+
+// nodeREDConverter builds one flow from the nodes of a tab
+type nodeREDConverter struct {
+	flow   *model.Flow
+	result *Result
+
+	// steps records which node IDs became steps, for wiring
+	steps map[string]bool
+}
+
+// warn records a warning about node
+func (c *nodeREDConverter) warn(node *nodeREDNode, format string, args ...interface{}) {
+	c.result.Warnings = append(c.result.Warnings, Warning{
+		Target:  c.flow.Name,
+		Node:    node.displayName(),
+		Type:    node.Type,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// convert adds node to the flow as a trigger or step
+func (c *nodeREDConverter) convert(node *nodeREDNode) {
+	if nodeREDIgnored[node.Type] {
+		return
+	}
+	if node.D {
+		c.warn(node, "node is disabled; skipped")
+		return
+	}
+
+	if trigger, ok := c.trigger(node); ok {
+		// Input nodes with no equivalent trigger leave the flow without one
+		if trigger.Type != "" {
+			c.flow.Triggers = append(c.flow.Triggers, trigger)
+		}
+		return
+	}
+	if node.Type == "debug" {
+		c.warn(node, "there is no log step; step results are recorded in the execution history, so the node is skipped")
+		return
+	}
+
+	step, ok := c.step(node)
+	if !ok {
+		c.warn(node, "node type is not supported; added as an unsupported placeholder step")
+		step = unsupportedStep(node.ID, FormatNodeRED, node.Type, node.raw)
+	}
+	if len(node.Wires) > 1 {
+		// Keep per-output wiring for multi-output nodes such as switch
+		step.Config["outputs"] = node.Wires
+	}
+	if c.steps == nil {
+		c.steps = make(map[string]bool)
+	}
+	c.steps[node.ID] = true
+	c.flow.Steps = append(c.flow.Steps, step)
+}
+
+// trigger converts input nodes into flow triggers. Input nodes that cannot
+// be converted are reported and return an empty trigger.
+func (c *nodeREDConverter) trigger(node *nodeREDNode) (model.Trigger, bool) {
+	switch node.Type {
+	case "inject":
+		if crontab := node.str("crontab"); crontab != "" {
+			return model.Trigger{Type: "cron", Config: map[string]interface{}{"schedule": crontab}}, true
+		}
+		if repeat := node.str("repeat"); repeat != "" {
+			return model.Trigger{Type: "interval", Config: map[string]interface{}{"interval": repeat + "s"}}, true
+		}
+		c.warn(node, "inject node has no schedule; the flow has no trigger and runs when started through the API")
+		return model.Trigger{}, true
+	case "http in":
+		return model.Trigger{Type: "webhook", Config: map[string]interface{}{
+			"method": strings.ToUpper(node.str("method")),
+			"path":   node.str("url"),
+		}}, true
+	case "mqtt in":
+		c.warn(node, "there is no MQTT trigger; the flow has no trigger for it")
+		return model.Trigger{}, true
+	case "tcp in":
+		if node.str("server") != "server" {
+			return model.Trigger{}, false
+		}
+		return model.Trigger{Type: "tcp", Config: map[string]interface{}{"port": node.raw["port"]}}, true
+	}
+	return model.Trigger{}, false
+}
+
+// step converts processing and output nodes into flow steps
+func (c *nodeREDConverter) step(node *nodeREDNode) (model.Step, bool) {
+	step := model.Step{ID: node.ID, Config: map[string]interface{}{}}
+	if node.Name != "" {
+		step.Config["name"] = node.Name
+	}
+
+	switch node.Type {
+	case "http request":
+		step.Type = "http"
+		step.Config["method"] = strings.ToUpper(node.str("method"))
+		step.Config["url"] = node.str("url")
+		if ret := node.str("ret"); ret != "" {
+			step.Config["response"] = ret
+		}
+	case "delay":
+		if pause := node.str("pauseType"); pause != "" && pause != "delay" {
+			c.warn(node, "only fixed delays are converted; %q mode added as an unsupported placeholder step", pause)
+			return unsupportedStep(node.ID, FormatNodeRED, node.Type, node.raw), true
+		}
+		step.Type = "delay"
+		step.Config["duration"] = nodeREDDuration(node.str("timeout"), node.str("timeoutUnits"))
+	case "http response":
+		step.Type = "httpResponse"
+		if code := node.str("statusCode"); code != "" {
+			step.Config["status"] = code
+		}
+		if headers, ok := node.raw["headers"].(map[string]interface{}); ok && len(headers) > 0 {
+			step.Config["headers"] = headers
+		}
+	case "function", "change", "switch", "template", "json", "split", "join":
+		c.warn(node, "node type has no equivalent step type; added as an unsupported placeholder step")
+		return unsupportedStep(node.ID, FormatNodeRED, node.Type, node.raw), true
+	case "file":
+		step.Type = "file-write"
+		step.Config["path"] = node.str("filename")
+	case "file in":
+		step.Type = "file-read"
+		step.Config["path"] = node.str("filename")
+	default:
+		return model.Step{}, false
+	}
+	return step, true
+}
+
+// wire adds an edge for every wire between two nodes that became steps
+func (c *nodeREDConverter) wire(nodes []*nodeREDNode) {
+	for _, node := range nodes {
+		if !c.steps[node.ID] {
+			continue
+		}
+		for _, output := range node.Wires {
+			for _, target := range output {
+				if c.steps[target] {
+					c.flow.Edges = append(c.flow.Edges, model.Edge{From: node.ID, To: target})
+				}
+			}
+		}
+	}
+}
+
+// nodeREDDuration converts a Node-RED delay and its units into a duration string
+func nodeREDDuration(timeout, units string) string {
+	switch units {
+	case "milliseconds":
+		return timeout + "ms"
+	case "minutes":
+		return timeout + "m"
+	case "hours":
+		return timeout + "h"
+	case "days":
+		if days, err := strconv.ParseFloat(timeout, 64); err == nil {
+			return strconv.FormatFloat(days*24, 'f', -1, 64) + "h"
+		}
+		return timeout + "h"
+	default:
+		return timeout + "s"
+	}
+}
@@ -0,0 +1,307 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIMethods are the operation keys of a path item, in output order
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIDoc is the subset of an OpenAPI 3 document used to build a connector
+type openAPIDoc struct {
+	OpenAPI string `yaml:"openapi"`
+	Swagger string `yaml:"swagger"`
+	Info    struct {
+		Title       string `yaml:"title"`
+		Description string `yaml:"description"`
+		Version     string `yaml:"version"`
+	} `yaml:"info"`
+	Servers []struct {
+		URL       string `yaml:"url"`
+		Variables map[string]struct {
+			Default string `yaml:"default"`
+		} `yaml:"variables"`
+	} `yaml:"servers"`
+	Security   []map[string][]string `yaml:"security"`
+	Components struct {
+		SecuritySchemes map[string]openAPISecurityScheme `yaml:"securitySchemes"`
+	} `yaml:"components"`
+
+	// root is the raw document, used for paths and to resolve $ref pointers
+	root map[string]interface{}
+}
+
+// openAPISecurityScheme is a security scheme from components.securitySchemes
+type openAPISecurityScheme struct {
+	Type   string `yaml:"type"`
+	Scheme string `yaml:"scheme"`
+	In     string `yaml:"in"`
+	Name   string `yaml:"name"`
+	Flows  map[string]struct {
+		TokenURL string            `yaml:"tokenUrl"`
+		Scopes   map[string]string `yaml:"scopes"`
+	} `yaml:"flows"`
+}
+
+// importOpenAPI generates an HTTP connector from an OpenAPI 3 document in JSON or YAML
+func importOpenAPI(data []byte) (*Result, error) {
+	var doc openAPIDoc
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	var root interface{}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	doc.root = asMap(normalizeYAML(root))
+	if doc.Swagger != "" || !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, errors.New("only OpenAPI 3 documents are supported")
+	}
+
+	result := &Result{}
+	name := doc.Info.Title
+	if name == "" {
+		name = "openapi"
+	}
+	g := &openAPIGenerator{doc: &doc, name: name, result: result}
+	g.resolver = &resolver{root: doc.root, warn: func(ref string) {
+		g.warn(ref, "reference cannot be resolved; left as-is")
+	}}
+
+	conn := &model.Connector{
+		Name:        name,
+		Type:        "http",
+		Description: doc.Info.Description,
+		Config:      map[string]interface{}{},
+		Auth:        g.auth(),
+	}
+	if doc.Info.Version != "" {
+		conn.Config["apiVersion"] = doc.Info.Version
+	}
+	if baseURL := g.baseURL(); baseURL != "" {
+		conn.Config["baseUrl"] = baseURL
+	}
+
+	paths := asMap(doc.root["paths"])
+	seen := make(map[string]bool)
+	for _, path := range sortedKeys(paths) {
+		item := asMap(paths[path])
+		shared := asList(item["parameters"])
+		for _, method := range openAPIMethods {
+			def := asMap(item[method])
+			if def == nil {
+				continue
+			}
+			op := g.operation(method, path, def, shared)
+			if seen[op.ID] {
+				g.warn(op.ID, "operationId is duplicated; renamed")
+				op.ID = operationID(method, path)
+			}
+			seen[op.ID] = true
+			conn.Operations = append(conn.Operations, op)
+		}
+	}
+	if len(conn.Operations) == 0 {
+		g.warn("", "document defines no operations")
+	}
+
+	result.Connectors = append(result.Connectors, conn)
+	return result, nil
+}
+
+// openAPIGenerator builds a connector from one document
+type openAPIGenerator struct {
+	*resolver
+	doc    *openAPIDoc
+	name   string
+	result *Result
+}
+
+// warn records a warning about an element of the document
+func (g *openAPIGenerator) warn(node, format string, args ...interface{}) {
+	g.result.Warnings = append(g.result.Warnings, Warning{
+		Target:  g.name,
+		Node:    node,
+		Type:    "openapi",
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// baseURL returns the first server URL with its variables substituted
+func (g *openAPIGenerator) baseURL() string {
+	if len(g.doc.Servers) == 0 {
+		g.warn("", "document declares no servers; set config.baseUrl before use")
+		return ""
+	}
+	if len(g.doc.Servers) > 1 {
+		g.warn("", "document declares %d servers; using the first", len(g.doc.Servers))
+	}
+	server := g.doc.Servers[0]
+	url := server.URL
+	for name, variable := range server.Variables {
+		url = strings.ReplaceAll(url, "{"+name+"}", variable.Default)
+	}
+	if !strings.Contains(url, "://") {
+		g.warn("", "server URL %q is relative; set config.baseUrl before use", url)
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// auth picks the connector's authentication scheme, preferring the
+// document's global security requirement
+func (g *openAPIGenerator) auth() *model.ConnectorAuth {
+	schemes := g.doc.Components.SecuritySchemes
+	if len(schemes) == 0 {
+		return nil
+	}
+
+	var name string
+	for _, requirement := range g.doc.Security {
+		for _, key := range sortedStringKeys(requirement) {
+			if _, ok := schemes[key]; ok {
+				name = key
+				break
+			}
+		}
+		if name != "" {
+			break
+		}
+	}
+	if name == "" {
+		names := make([]string, 0, len(schemes))
+		for key := range schemes {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		name = names[0]
+	}
+	if len(schemes) > 1 {
+		g.warn(name, "document defines %d security schemes; using %q", len(schemes), name)
+	}
+
+	scheme := schemes[name]
+	switch {
+	case scheme.Type == "http" && strings.EqualFold(scheme.Scheme, "basic"):
+		return &model.ConnectorAuth{Type: "basic"}
+	case scheme.Type == "http" && strings.EqualFold(scheme.Scheme, "bearer"):
+		return &model.ConnectorAuth{Type: "bearer"}
+	case scheme.Type == "apiKey":
+		return &model.ConnectorAuth{Type: "apiKey", In: scheme.In, Name: scheme.Name}
+	case scheme.Type == "oauth2":
+		flow, ok := scheme.Flows["clientCredentials"]
+		if !ok {
+			g.warn(name, "only the oauth2 clientCredentials flow is supported; configure auth by hand")
+			return &model.ConnectorAuth{Type: "oauth2"}
+		}
+		auth := &model.ConnectorAuth{Type: "oauth2", TokenURL: flow.TokenURL}
+		for scope := range flow.Scopes {
+			auth.Scopes = append(auth.Scopes, scope)
+		}
+		sort.Strings(auth.Scopes)
+		return auth
+	default:
+		g.warn(name, "security scheme type %q is not supported; configure auth by hand", scheme.Type)
+		return nil
+	}
+}
+
+// operation converts one OpenAPI operation
+func (g *openAPIGenerator) operation(method, path string, def map[string]interface{}, shared []interface{}) model.Operation {
+	op := model.Operation{
+		ID:      asString(def["operationId"]),
+		Summary: asString(def["summary"]),
+		Method:  strings.ToUpper(method),
+		Path:    path,
+	}
+	if op.ID == "" {
+		op.ID = operationID(method, path)
+	}
+
+	// Operation parameters override path-level ones with the same name and location
+	index := make(map[string]int)
+	for _, raw := range append(append([]interface{}{}, shared...), asList(def["parameters"])...) {
+		param := asMap(g.resolve(raw))
+		p := model.Parameter{
+			Name:     asString(param["name"]),
+			In:       asString(param["in"]),
+			Required: param["required"] == true,
+			Schema:   asMap(g.resolve(param["schema"])),
+		}
+		if p.Name == "" {
+			g.warn(op.ID, "skipping parameter without a name")
+			continue
+		}
+		if p.In == "cookie" {
+			g.warn(op.ID, "cookie parameter %q is not supported", p.Name)
+		}
+		key := p.In + ":" + p.Name
+		if i, ok := index[key]; ok {
+			op.Parameters[i] = p
+			continue
+		}
+		index[key] = len(op.Parameters)
+		op.Parameters = append(op.Parameters, p)
+	}
+
+	if body := asMap(g.resolve(def["requestBody"])); body != nil {
+		op.ContentType, op.RequestBody = g.content(op.ID, asMap(body["content"]))
+	}
+	if responses := asMap(def["responses"]); responses != nil {
+		for _, code := range sortedKeys(responses) {
+			if strings.HasPrefix(code, "2") || code == "default" {
+				response := asMap(g.resolve(responses[code]))
+				_, op.Response = g.content(op.ID, asMap(response["content"]))
+				break
+			}
+		}
+	}
+	return op
+}
+
+// content picks the JSON media type from an already resolved content map,
+// falling back to the first one
+func (g *openAPIGenerator) content(opID string, content map[string]interface{}) (string, map[string]interface{}) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	contentType := ""
+	for _, candidate := range sortedKeys(content) {
+		if candidate == "application/json" || strings.HasSuffix(candidate, "+json") {
+			contentType = candidate
+			break
+		}
+	}
+	if contentType == "" {
+		contentType = sortedKeys(content)[0]
+	}
+	media := asMap(content[contentType])
+	return contentType, asMap(media["schema"])
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// operationID derives an ID for operations without an operationId, e.g. get_orders_id
+func operationID(method, path string) string {
+	slug := strings.Trim(nonIdentifier.ReplaceAllString(path, "_"), "_")
+	if slug == "" {
+		return method
+	}
+	return method + "_" + slug
+}
+
+// sortedStringKeys returns m's keys in order
+func sortedStringKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
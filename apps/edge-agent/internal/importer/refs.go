@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"slices"
+	"strings"
+)
+
+// resolver expands local $ref pointers within a JSON or YAML document
+type resolver struct {
+	root map[string]interface{}
+
+	// warn is called for references that cannot be resolved
+	warn func(ref string)
+}
+
+// resolve returns v with local $ref pointers replaced by what they point at.
+// Recursive references (a ref already being expanded in active) and external
+// refs are left in place.
+func (r *resolver) resolve(v interface{}, active ...string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			if slices.Contains(active, ref) {
+				return v
+			}
+			target, ok := r.lookup(ref)
+			if !ok {
+				r.warn(ref)
+				return v
+			}
+			return r.resolve(target, append(active[:len(active):len(active)], ref)...)
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[k] = r.resolve(child, active...)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			out[i] = r.resolve(child, active...)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// lookup follows a local JSON pointer such as #/components/schemas/Order
+func (r *resolver) lookup(ref string) (interface{}, bool) {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, false
+	}
+	var node interface{} = r.root
+	for _, part := range strings.Split(pointer, "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m := asMap(node)
+		if m == nil {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
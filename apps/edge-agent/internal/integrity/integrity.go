@@ -0,0 +1,208 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// Problems found with a checked file
+const (
+	ProblemModified  = "modified"
+	ProblemMissing   = "missing"
+	ProblemUnlisted  = "unlisted"
+	ProblemSignature = "signature"
+)
+
+// Manifest lists the digests of the agent binary and of the files of its
+// installed bundles and plugins. Paths are relative to the manifest's
+// directory. Every file under Dirs must be listed, so that files planted
+// next to the installed ones are caught too.
+type Manifest struct {
+	Version string `json:"version,omitempty"`
+	// Binary is the SHA-256 digest of the agent executable, in hex
+	Binary string   `json:"binary"`
+	Files  []File   `json:"files,omitempty"`
+	Dirs   []string `json:"dirs,omitempty"`
+}
+
+// File is the digest of an installed file
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// Mismatch is a file that failed the check
+type Mismatch struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Report is the outcome of a check
+type Report struct {
+	Verified   bool       `json:"verified"`
+	Version    string     `json:"version,omitempty"`
+	Checked    int        `json:"checked"`
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+	CheckedAt  time.Time  `json:"checkedAt"`
+}
+
+// Err summarizes the mismatches of r, nil when it verified
+func (r *Report) Err() error {
+	if r.Verified {
+		return nil
+	}
+	problems := make([]string, 0, len(r.Mismatches))
+	for _, m := range r.Mismatches {
+		problems = append(problems, m.Path+": "+m.Problem)
+	}
+	return fmt.Errorf("integrity check failed: %s", strings.Join(problems, ", "))
+}
+
+// Check verifies the signature of the manifest and then the running binary
+// and every file it lists. A manifest that cannot be read or verified fails
+// the check as a whole.
+func Check(cfg config.SecurityConfig) *Report {
+	r := &Report{CheckedAt: time.Now().UTC()}
+	m, err := load(cfg)
+	if err != nil {
+		r.Mismatches = append(r.Mismatches, Mismatch{Path: cfg.Manifest, Problem: ProblemSignature, Detail: err.Error()})
+		return r
+	}
+	r.Version = m.Version
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		r.Mismatches = append(r.Mismatches, Mismatch{Path: "binary", Problem: ProblemMissing, Detail: err.Error()})
+	} else {
+		r.verify(exe, m.Binary)
+	}
+
+	root := filepath.Dir(cfg.Manifest)
+	listed := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		path := filepath.Join(root, filepath.FromSlash(f.Path))
+		listed[path] = true
+		r.verify(path, f.SHA256)
+	}
+	for _, dir := range m.Dirs {
+		err := filepath.WalkDir(filepath.Join(root, filepath.FromSlash(dir)), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && !listed[path] {
+				rel, _ := filepath.Rel(root, path)
+				r.Mismatches = append(r.Mismatches, Mismatch{Path: filepath.ToSlash(rel), Problem: ProblemUnlisted})
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.Mismatches = append(r.Mismatches, Mismatch{Path: dir, Problem: ProblemMissing, Detail: err.Error()})
+		}
+	}
+	r.Verified = len(r.Mismatches) == 0
+	return r
+}
+
+// verify checks the digest of the file at path
+func (r *Report) verify(path, want string) {
+	r.Checked++
+	got, err := digest(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.Mismatches = append(r.Mismatches, Mismatch{Path: path, Problem: ProblemMissing})
+		return
+	}
+	if err != nil {
+		r.Mismatches = append(r.Mismatches, Mismatch{Path: path, Problem: ProblemMissing, Detail: err.Error()})
+		return
+	}
+	if !strings.EqualFold(got, want) {
+		r.Mismatches = append(r.Mismatches, Mismatch{Path: path, Problem: ProblemModified, Detail: "sha256 " + got})
+	}
+}
+
+// digest returns the SHA-256 digest of a file, in hex
+func digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// load reads the manifest and verifies its signature
+func load(cfg config.SecurityConfig) (*Manifest, error) {
+	key, err := publicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(cfg.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	sig, err := os.ReadFile(cfg.Manifest + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest signature: %w", err)
+	}
+	// Signatures are stored raw or base64 encoded
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return nil, fmt.Errorf("invalid manifest signature: %w", err)
+		}
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return nil, errors.New("manifest signature does not match")
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.Binary == "" {
+		return nil, errors.New("manifest has no binary digest")
+	}
+	return &m, nil
+}
+
+// publicKey reads an Ed25519 public key from a PEM file
+func publicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
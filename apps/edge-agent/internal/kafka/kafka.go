@@ -0,0 +1,335 @@
+package kafka
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Config locates a Kafka cluster and the credentials to use with it. It is
+// shared by the kafka trigger, step and connector configurations.
+type Config struct {
+	Brokers  Brokers     `json:"brokers"`
+	ClientID string      `json:"clientId"`
+	TLS      *TLSConfig  `json:"tls"`
+	SASL     *SASLConfig `json:"sasl"`
+	// DialTimeout bounds connecting to a broker, default 10s
+	DialTimeout string `json:"dialTimeout"`
+}
+
+// Brokers lists broker addresses. It decodes from a list or from a
+// comma-separated string, as in connectors imported from AsyncAPI.
+type Brokers []string
+
+func (b *Brokers) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*b = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("brokers must be a list or a comma-separated string")
+	}
+	*b = nil
+	for _, addr := range strings.Split(s, ",") {
+		if i := strings.Index(addr, "://"); i >= 0 {
+			addr = addr[i+len("://"):]
+		}
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*b = append(*b, addr)
+		}
+	}
+	return nil
+}
+
+// TLSConfig enables TLS to the brokers. CAFile replaces the system roots;
+// CertFile and KeyFile present a client certificate.
+type TLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// SASLConfig authenticates with the PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+// mechanism
+type SASLConfig struct {
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// Validate checks that the configuration names brokers and supported
+// security settings
+func (c *Config) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("brokers are required")
+	}
+	if _, err := c.dialTimeout(); err != nil {
+		return err
+	}
+	if _, err := c.mechanism(); err != nil {
+		return err
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls certFile and keyFile must be set together")
+	}
+	return nil
+}
+
+func (c *Config) dialTimeout() (time.Duration, error) {
+	if c.DialTimeout == "" {
+		return 10 * time.Second, nil
+	}
+	d, err := time.ParseDuration(c.DialTimeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("dialTimeout %q is not a positive duration", c.DialTimeout)
+	}
+	return d, nil
+}
+
+// mechanism returns the SASL mechanism, nil without SASL
+func (c *Config) mechanism() (sasl.Mechanism, error) {
+	if c.SASL == nil || c.SASL.Mechanism == "" {
+		return nil, nil
+	}
+	switch strings.ToUpper(c.SASL.Mechanism) {
+	case "PLAIN":
+		return plain.Mechanism{Username: c.SASL.Username, Password: c.SASL.Password}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, c.SASL.Username, c.SASL.Password)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, c.SASL.Username, c.SASL.Password)
+	}
+	return nil, fmt.Errorf("sasl mechanism %q is not supported", c.SASL.Mechanism)
+}
+
+// tlsConfig loads the TLS settings, nil when TLS is disabled
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	if c.TLS == nil || !c.TLS.Enabled {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.TLS.ServerName,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls caFile %s holds no certificates", c.TLS.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Dialer returns a dialer for consumers and broker connections
+func (c *Config) Dialer() (*kafkago.Dialer, error) {
+	timeout, err := c.dialTimeout()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := c.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &kafkago.Dialer{
+		ClientID:      c.ClientID,
+		Timeout:       timeout,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+// transport returns the transport for producers
+func (c *Config) transport() (*kafkago.Transport, error) {
+	timeout, err := c.dialTimeout()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := c.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &kafkago.Transport{
+		ClientID:    c.ClientID,
+		DialTimeout: timeout,
+		TLS:         tlsConfig,
+		SASL:        mechanism,
+	}, nil
+}
+
+// Ping connects to the first reachable broker and checks that topics, when
+// given, exist
+func Ping(ctx context.Context, cfg Config, topics ...string) error {
+	dialer, err := cfg.Dialer()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, broker := range cfg.Brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", broker, err))
+			continue
+		}
+		defer conn.Close()
+		if _, err := conn.Brokers(); err != nil {
+			return fmt.Errorf("failed to read cluster metadata: %w", err)
+		}
+		if len(topics) > 0 {
+			partitions, err := conn.ReadPartitions(topics...)
+			if err != nil {
+				return fmt.Errorf("failed to read topics: %w", err)
+			}
+			found := make(map[string]bool, len(topics))
+			for _, p := range partitions {
+				found[p.Topic] = true
+			}
+			for _, topic := range topics {
+				if !found[topic] {
+					return fmt.Errorf("topic %s does not exist", topic)
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no broker reachable: %w", errors.Join(errs...))
+}
+
+// ProducerOptions set how a producer's writes are acknowledged and
+// compressed. Acks is "all" (default), "one" or "none"; Compression is
+// "gzip", "snappy", "lz4", "zstd" or empty.
+type ProducerOptions struct {
+	Acks        string
+	Compression string
+}
+
+var (
+	producersMu sync.Mutex
+	producers   = make(map[string]*kafkago.Writer)
+)
+
+// Producer returns the shared producer for a cluster and options. Producers
+// write to the topic set on each message and stay open until Close.
+func Producer(cfg Config, opts ProducerOptions) (*kafkago.Writer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	acks := kafkago.RequireAll
+	switch opts.Acks {
+	case "", "all":
+	case "one":
+		acks = kafkago.RequireOne
+	case "none":
+		acks = kafkago.RequireNone
+	default:
+		return nil, fmt.Errorf("acks %q is not supported", opts.Acks)
+	}
+	var compression kafkago.Compression
+	switch opts.Compression {
+	case "":
+	case "gzip":
+		compression = kafkago.Gzip
+	case "snappy":
+		compression = kafkago.Snappy
+	case "lz4":
+		compression = kafkago.Lz4
+	case "zstd":
+		compression = kafkago.Zstd
+	default:
+		return nil, fmt.Errorf("compression %q is not supported", opts.Compression)
+	}
+
+	id, err := configKey(struct {
+		Config
+		ProducerOptions
+	}{cfg, opts})
+	if err != nil {
+		return nil, err
+	}
+
+	producersMu.Lock()
+	defer producersMu.Unlock()
+	if w, ok := producers[id]; ok {
+		return w, nil
+	}
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, err
+	}
+	w := &kafkago.Writer{
+		Addr:         kafkago.TCP(cfg.Brokers...),
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: acks,
+		Compression:  compression,
+		BatchTimeout: 10 * time.Millisecond,
+		Transport:    transport,
+	}
+	producers[id] = w
+	return w, nil
+}
+
+// configKey identifies a configuration of shared producers by its digest
+func configKey(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Close flushes and closes the shared producers
+func Close() error {
+	producersMu.Lock()
+	list := producers
+	producers = make(map[string]*kafkago.Writer)
+	producersMu.Unlock()
+
+	var errs []error
+	for _, w := range list {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,480 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+// transactionTimeout is how long the transaction coordinator waits for a
+// transaction to end before aborting it
+const transactionTimeout = time.Minute
+
+// castagnoli is the CRC table of record batch checksums
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// TxnProducer produces records in Kafka transactions under one
+// transactional ID: the records of each call to Produce become visible to
+// read_committed consumers together, or not at all. Starting a producer
+// fences off earlier ones with the same ID and aborts their open
+// transaction, so the ID must be unique to the agent. Transactions are
+// produced one at a time.
+type TxnProducer struct {
+	client   *kafkago.Client
+	id       string
+	balancer *kafkago.Hash
+
+	mu sync.Mutex
+	// session is the producer ID and epoch, nil until initialized and after
+	// a failed transaction, so that the next one starts with a new epoch
+	session    *kafkago.ProducerSession
+	sequences  map[topicPartition]int32
+	partitions map[string][]int
+}
+
+// topicPartition names a partition of a topic
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+var (
+	txnProducersMu sync.Mutex
+	txnProducers   = make(map[string]*TxnProducer)
+)
+
+// TransactionalProducer returns the shared transactional producer for a
+// cluster and transactional ID, so that the steps of a recompiled flow do
+// not fence each other off
+func TransactionalProducer(cfg Config, transactionalID string) (*TxnProducer, error) {
+	id, err := clientKey(cfg, transactionalID)
+	if err != nil {
+		return nil, err
+	}
+	txnProducersMu.Lock()
+	defer txnProducersMu.Unlock()
+	if p, ok := txnProducers[id]; ok {
+		return p, nil
+	}
+	client, err := cfg.client()
+	if err != nil {
+		return nil, err
+	}
+	p := &TxnProducer{client: client, id: transactionalID, balancer: &kafkago.Hash{}}
+	txnProducers[id] = p
+	return p, nil
+}
+
+// clientKey identifies what uses a client of cfg by name
+func clientKey(cfg Config, name string) (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+	return configKey(struct {
+		Config
+		Name string
+	}{cfg, name})
+}
+
+// client returns a client of the cluster for low-level requests
+func (c *Config) client() (*kafkago.Client, error) {
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := c.dialTimeout()
+	if err != nil {
+		return nil, err
+	}
+	return &kafkago.Client{Addr: kafkago.TCP(c.Brokers...), Timeout: 3 * timeout, Transport: transport}, nil
+}
+
+// Produce writes records in one transaction, partitioned by their keys as
+// the shared producers do. It returns once the transaction is committed,
+// and aborts it on any failure.
+func (p *TxnProducer) Produce(ctx context.Context, records []kafkago.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.session == nil {
+		if err := p.init(ctx); err != nil {
+			return err
+		}
+	}
+	err := p.produce(ctx, records)
+	if err != nil {
+		// Best effort: a new session aborts the transaction anyway
+		_, _ = p.client.EndTxn(ctx, &kafkago.EndTxnRequest{
+			TransactionalID: p.id,
+			ProducerID:      p.session.ProducerID,
+			ProducerEpoch:   p.session.ProducerEpoch,
+			Committed:       false,
+		})
+		p.session, p.partitions = nil, nil
+	}
+	return err
+}
+
+// init starts a producer session, fencing off earlier producers of the ID
+func (p *TxnProducer) init(ctx context.Context) error {
+	res, err := p.client.InitProducerID(ctx, &kafkago.InitProducerIDRequest{
+		TransactionalID:      p.id,
+		TransactionTimeoutMs: int(transactionTimeout / time.Millisecond),
+	})
+	if err == nil {
+		err = res.Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start Kafka transactions as %s: %w", p.id, err)
+	}
+	p.session = res.Producer
+	p.sequences = make(map[topicPartition]int32)
+	return nil
+}
+
+// produce runs the transaction of records within the current session
+func (p *TxnProducer) produce(ctx context.Context, records []kafkago.Message) error {
+	batches := make(map[topicPartition][]kafkago.Message)
+	var order []topicPartition
+	for _, msg := range records {
+		partitions, err := p.topicPartitions(ctx, msg.Topic)
+		if err != nil {
+			return err
+		}
+		tp := topicPartition{msg.Topic, p.balancer.Balance(msg, partitions...)}
+		if _, ok := batches[tp]; !ok {
+			order = append(order, tp)
+		}
+		batches[tp] = append(batches[tp], msg)
+	}
+
+	topics := make(map[string][]kafkago.AddPartitionToTxn)
+	for _, tp := range order {
+		topics[tp.topic] = append(topics[tp.topic], kafkago.AddPartitionToTxn{Partition: tp.partition})
+	}
+	added, err := p.client.AddPartitionsToTxn(ctx, &kafkago.AddPartitionsToTxnRequest{
+		TransactionalID: p.id,
+		ProducerID:      p.session.ProducerID,
+		ProducerEpoch:   p.session.ProducerEpoch,
+		Topics:          topics,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add partitions to transaction: %w", err)
+	}
+	for topic, partitions := range added.Topics {
+		for _, partition := range partitions {
+			if partition.Error != nil {
+				return fmt.Errorf("failed to add %s/%d to transaction: %w", topic, partition.Partition, partition.Error)
+			}
+		}
+	}
+
+	for _, tp := range order {
+		batch := batches[tp]
+		raw, err := recordBatch(batch, p.session, p.sequences[tp])
+		if err != nil {
+			return err
+		}
+		res, err := p.client.RawProduce(ctx, &kafkago.RawProduceRequest{
+			Topic:           tp.topic,
+			Partition:       tp.partition,
+			RequiredAcks:    kafkago.RequireAll,
+			TransactionalID: p.id,
+			RawRecords:      raw,
+		})
+		if err == nil {
+			err = res.Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to produce to %s: %w", tp.topic, err)
+		}
+		p.sequences[tp] += int32(len(batch))
+	}
+
+	res, err := p.client.EndTxn(ctx, &kafkago.EndTxnRequest{
+		TransactionalID: p.id,
+		ProducerID:      p.session.ProducerID,
+		ProducerEpoch:   p.session.ProducerEpoch,
+		Committed:       true,
+	})
+	if err == nil {
+		err = res.Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// topicPartitions returns the partitions of topic, read once per session
+func (p *TxnProducer) topicPartitions(ctx context.Context, topic string) ([]int, error) {
+	if partitions, ok := p.partitions[topic]; ok {
+		return partitions, nil
+	}
+	partitions, err := readPartitions(ctx, p.client, topic)
+	if err != nil {
+		return nil, err
+	}
+	if p.partitions == nil {
+		p.partitions = make(map[string][]int)
+	}
+	p.partitions[topic] = partitions
+	return partitions, nil
+}
+
+// readPartitions returns the partition IDs of topic
+func readPartitions(ctx context.Context, client *kafkago.Client, topic string) ([]int, error) {
+	res, err := client.Metadata(ctx, &kafkago.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", topic, err)
+	}
+	for _, t := range res.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to read metadata of %s: %w", topic, t.Error)
+		}
+		partitions := make([]int, len(t.Partitions))
+		for i, partition := range t.Partitions {
+			partitions[i] = partition.ID
+		}
+		// Keys hash to the same partition wherever they are read
+		sort.Ints(partitions)
+		if len(partitions) > 0 {
+			return partitions, nil
+		}
+	}
+	return nil, fmt.Errorf("topic %s does not exist", topic)
+}
+
+// recordBatch encodes records as a transactional record batch of session,
+// numbered from sequence. kafka-go encodes batches without a producer, so
+// its encoding is patched with the session and checksummed again.
+func recordBatch(records []kafkago.Message, session *kafkago.ProducerSession, sequence int32) (protocol.RawRecordSet, error) {
+	list := make([]protocol.Record, len(records))
+	for i, msg := range records {
+		headers := make([]protocol.Header, len(msg.Headers))
+		for j, h := range msg.Headers {
+			headers[j] = protocol.Header{Key: h.Key, Value: h.Value}
+		}
+		list[i] = protocol.Record{Time: msg.Time, Key: protocol.NewBytes(msg.Key), Value: protocol.NewBytes(msg.Value), Headers: headers}
+	}
+	set := protocol.RecordSet{Version: 2, Attributes: protocol.Transactional, Records: protocol.NewRecordReader(list...)}
+	var buf bytes.Buffer
+	if _, err := set.WriteTo(&buf); err != nil {
+		return protocol.RawRecordSet{}, fmt.Errorf("failed to encode records: %w", err)
+	}
+
+	// Offsets of the v2 batch header, after the size of the record set
+	const (
+		start        = 4
+		checksum     = start + 17
+		checked      = start + 21
+		producerID   = start + 43
+		epoch        = start + 51
+		baseSequence = start + 53
+	)
+	b := buf.Bytes()
+	binary.BigEndian.PutUint64(b[producerID:], uint64(session.ProducerID))
+	binary.BigEndian.PutUint16(b[epoch:], uint16(session.ProducerEpoch))
+	binary.BigEndian.PutUint32(b[baseSequence:], uint32(sequence))
+	binary.BigEndian.PutUint32(b[checksum:], crc32.Checksum(b[checked:], castagnoli))
+	return protocol.RawRecordSet{Reader: bytes.NewReader(b)}, nil
+}
+
+// CommitLog tells which keys were produced to a topic in committed
+// transactions, such as the idempotency keys a transactional sink records
+// with its writes. It reads the partition a key is produced to from the
+// start, following the transaction markers itself, as kafka-go does not
+// drop the records of aborted transactions.
+type CommitLog struct {
+	client   *kafkago.Client
+	topic    string
+	balancer *kafkago.Hash
+
+	mu         sync.Mutex
+	partitions []int
+	logs       map[int]*partitionLog
+}
+
+// partitionLog is what a CommitLog read of a partition so far
+type partitionLog struct {
+	// next is the offset to read from; FirstOffset before the first read
+	next      int64
+	committed map[string]bool
+	// pending holds the keys of open transactions by producer ID
+	pending map[int64][]string
+}
+
+var (
+	commitLogsMu sync.Mutex
+	commitLogs   = make(map[string]*CommitLog)
+)
+
+// Commits returns the shared commit log of a topic of a cluster
+func Commits(cfg Config, topic string) (*CommitLog, error) {
+	id, err := clientKey(cfg, topic)
+	if err != nil {
+		return nil, err
+	}
+	commitLogsMu.Lock()
+	defer commitLogsMu.Unlock()
+	if l, ok := commitLogs[id]; ok {
+		return l, nil
+	}
+	client, err := cfg.client()
+	if err != nil {
+		return nil, err
+	}
+	l := &CommitLog{client: client, topic: topic, balancer: &kafkago.Hash{}, logs: make(map[int]*partitionLog)}
+	commitLogs[id] = l
+	return l, nil
+}
+
+// Record returns the record of key to produce to the log in a transaction
+func (l *CommitLog) Record(key string) kafkago.Message {
+	return kafkago.Message{Topic: l.topic, Key: []byte(key)}
+}
+
+// Committed reports whether key was produced to the log in a committed
+// transaction, reading the records produced since the last call
+func (l *CommitLog) Committed(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.partitions == nil {
+		partitions, err := readPartitions(ctx, l.client, l.topic)
+		if err != nil {
+			return false, err
+		}
+		l.partitions = partitions
+	}
+	partition := l.balancer.Balance(kafkago.Message{Key: []byte(key)}, l.partitions...)
+	log, ok := l.logs[partition]
+	if !ok {
+		log = &partitionLog{next: kafkago.FirstOffset, committed: make(map[string]bool), pending: make(map[int64][]string)}
+		l.logs[partition] = log
+	}
+	if err := l.catchUp(ctx, partition, log); err != nil {
+		return false, err
+	}
+	return log.committed[key], nil
+}
+
+// catchUp reads partition up to its high watermark
+func (l *CommitLog) catchUp(ctx context.Context, partition int, log *partitionLog) error {
+	for {
+		res, err := l.client.Fetch(ctx, &kafkago.FetchRequest{
+			Topic:     l.topic,
+			Partition: partition,
+			Offset:    log.next,
+			MaxBytes:  1 << 20,
+			MaxWait:   100 * time.Millisecond,
+		})
+		if err == nil {
+			err = res.Error
+		}
+		if errors.Is(err, kafkago.OffsetOutOfRange) && log.next != kafkago.FirstOffset {
+			// Retention deleted records not read yet; their keys are gone
+			log.next = kafkago.FirstOffset
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s/%d: %w", l.topic, partition, err)
+		}
+		start := log.next
+		if err := log.read(res.Records); err != nil {
+			return fmt.Errorf("failed to read %s/%d: %w", l.topic, partition, err)
+		}
+		if log.next >= res.HighWatermark || log.next == start {
+			return nil
+		}
+	}
+}
+
+// read applies the batches of records: keys of transactional batches wait
+// for the marker ending their transaction
+func (log *partitionLog) read(records protocol.RecordReader) error {
+	batches := []protocol.RecordReader{records}
+	if stream, ok := records.(*protocol.RecordStream); ok {
+		batches = stream.Records
+	}
+	for _, batch := range batches {
+		switch b := batch.(type) {
+		case *protocol.ControlBatch:
+			for {
+				marker, err := b.ReadControlRecord()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if marker.Offset < log.next {
+					continue
+				}
+				log.next = marker.Offset + 1
+				// Type 1 commits the transaction, 0 aborts it
+				if marker.Type == 1 {
+					for _, key := range log.pending[b.ProducerID] {
+						log.committed[key] = true
+					}
+				}
+				delete(log.pending, b.ProducerID)
+			}
+		case *protocol.RecordBatch:
+			if err := log.readBatch(b, b.Attributes.Transactional(), b.ProducerID); err != nil {
+				return err
+			}
+		default:
+			if err := log.readBatch(b, false, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readBatch applies the records of a batch from producer
+func (log *partitionLog) readBatch(batch protocol.RecordReader, transactional bool, producer int64) error {
+	for {
+		rec, err := batch.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var key []byte
+		if rec.Key != nil {
+			key, err = io.ReadAll(rec.Key)
+			rec.Key.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if rec.Value != nil {
+			rec.Value.Close()
+		}
+		if rec.Offset < log.next {
+			continue
+		}
+		log.next = rec.Offset + 1
+		if transactional {
+			log.pending[producer] = append(log.pending[producer], string(key))
+		} else {
+			log.committed[string(key)] = true
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package lazyjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Kinds of JSON values
+type Kind int
+
+const (
+	Invalid Kind = iota
+	Null
+	Bool
+	Number
+	String
+	Object
+	Array
+)
+
+// ErrSyntax is returned for malformed JSON along the traversed path
+var ErrSyntax = errors.New("invalid JSON")
+
+// Value is a JSON value located by Get. Raw aliases the scanned document;
+// nothing outside the path leading to it is decoded.
+type Value struct {
+	Raw  []byte
+	Kind Kind
+}
+
+// Get returns the value at path within data, where each segment is an
+// object key or an array index. Only the bytes before the value and the
+// value itself are scanned; siblings are skipped without being decoded, so
+// looking up a field of a multi-megabyte document allocates nothing. ok is
+// false when the path does not exist.
+func Get(data []byte, path ...string) (v Value, ok bool, err error) {
+	s := scanner{data: data}
+	s.skipSpace()
+	for _, seg := range path {
+		switch s.peek() {
+		case '{':
+			if ok, err = s.findKey(seg); !ok || err != nil {
+				return Value{}, false, err
+			}
+		case '[':
+			i, convErr := strconv.Atoi(seg)
+			if convErr != nil || i < 0 {
+				return Value{}, false, nil
+			}
+			if ok, err = s.findIndex(i); !ok || err != nil {
+				return Value{}, false, err
+			}
+		default:
+			// Scalars have no children
+			if _, err := s.skipValue(); err != nil {
+				return Value{}, false, err
+			}
+			return Value{}, false, nil
+		}
+	}
+	start := s.pos
+	kind, err := s.skipValue()
+	if err != nil {
+		return Value{}, false, err
+	}
+	return Value{Raw: data[start:s.pos], Kind: kind}, true, nil
+}
+
+// String returns the text of a string value, unescaped, and the literal of
+// other scalars; ok is false for objects, arrays and null
+func (v Value) String() (s string, ok bool) {
+	switch v.Kind {
+	case String:
+		if bytes.IndexByte(v.Raw, '\\') < 0 {
+			return string(v.Raw[1 : len(v.Raw)-1]), true
+		}
+		if err := json.Unmarshal(v.Raw, &s); err != nil {
+			return "", false
+		}
+		return s, true
+	case Bool, Number:
+		return string(v.Raw), true
+	}
+	return "", false
+}
+
+// Decode unmarshals the value into out
+func (v Value) Decode(out interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(v.Raw))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+// scanner walks a JSON document without building values
+type scanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *scanner) peek() byte {
+	if s.pos < len(s.data) {
+		return s.data[s.pos]
+	}
+	return 0
+}
+
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) syntax(what string) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrSyntax, what, s.pos)
+}
+
+// expect consumes c after optional whitespace
+func (s *scanner) expect(c byte) error {
+	s.skipSpace()
+	if s.peek() != c {
+		return s.syntax(fmt.Sprintf("expected %q", c))
+	}
+	s.pos++
+	return nil
+}
+
+// findKey positions the scanner at the value of key in the object at the
+// current position
+func (s *scanner) findKey(key string) (bool, error) {
+	if err := s.expect('{'); err != nil {
+		return false, err
+	}
+	s.skipSpace()
+	if s.peek() == '}' {
+		s.pos++
+		return false, nil
+	}
+	for {
+		s.skipSpace()
+		start := s.pos
+		if _, err := s.skipString(); err != nil {
+			return false, err
+		}
+		match := keyEquals(s.data[start:s.pos], key)
+		if err := s.expect(':'); err != nil {
+			return false, err
+		}
+		s.skipSpace()
+		if match {
+			return true, nil
+		}
+		if _, err := s.skipValue(); err != nil {
+			return false, err
+		}
+		s.skipSpace()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return false, nil
+		default:
+			return false, s.syntax("expected ',' or '}'")
+		}
+	}
+}
+
+// findIndex positions the scanner at element i of the array at the current
+// position
+func (s *scanner) findIndex(i int) (bool, error) {
+	if err := s.expect('['); err != nil {
+		return false, err
+	}
+	s.skipSpace()
+	if s.peek() == ']' {
+		s.pos++
+		return false, nil
+	}
+	for n := 0; ; n++ {
+		s.skipSpace()
+		if n == i {
+			return true, nil
+		}
+		if _, err := s.skipValue(); err != nil {
+			return false, err
+		}
+		s.skipSpace()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return false, nil
+		default:
+			return false, s.syntax("expected ',' or ']'")
+		}
+	}
+}
+
+// skipValue moves past the value at the current position and reports its
+// kind. Containers are skipped by tracking nesting depth.
+func (s *scanner) skipValue() (Kind, error) {
+	s.skipSpace()
+	switch c := s.peek(); {
+	case c == '"':
+		return s.skipString()
+	case c == '{' || c == '[':
+		kind := Object
+		if c == '[' {
+			kind = Array
+		}
+		depth := 0
+		for s.pos < len(s.data) {
+			switch s.data[s.pos] {
+			case '"':
+				if _, err := s.skipString(); err != nil {
+					return Invalid, err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					s.pos++
+					return kind, nil
+				}
+			}
+			s.pos++
+		}
+		return Invalid, s.syntax("unterminated container")
+	case c == 't':
+		return Bool, s.literal("true")
+	case c == 'f':
+		return Bool, s.literal("false")
+	case c == 'n':
+		return Null, s.literal("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := s.pos
+		for s.pos < len(s.data) && isNumberByte(s.data[s.pos]) {
+			s.pos++
+		}
+		if s.pos == start+1 && c == '-' {
+			return Invalid, s.syntax("invalid number")
+		}
+		return Number, nil
+	}
+	return Invalid, s.syntax("unexpected character")
+}
+
+// skipString moves past the string at the current position
+func (s *scanner) skipString() (Kind, error) {
+	if s.peek() != '"' {
+		return Invalid, s.syntax("expected string")
+	}
+	for i := s.pos + 1; i < len(s.data); i++ {
+		switch s.data[i] {
+		case '\\':
+			i++
+		case '"':
+			s.pos = i + 1
+			return String, nil
+		}
+	}
+	return Invalid, s.syntax("unterminated string")
+}
+
+func (s *scanner) literal(lit string) error {
+	if !bytes.HasPrefix(s.data[s.pos:], []byte(lit)) {
+		return s.syntax("invalid literal")
+	}
+	s.pos += len(lit)
+	return nil
+}
+
+func isNumberByte(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E'
+}
+
+// keyEquals compares a quoted JSON key with key, unescaping only when the
+// key contains escapes
+func keyEquals(quoted []byte, key string) bool {
+	raw := quoted[1 : len(quoted)-1]
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw) == key
+	}
+	var s string
+	if err := json.Unmarshal(quoted, &s); err != nil {
+		return false
+	}
+	return s == key
+}
@@ -0,0 +1,114 @@
+package listeners
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// clientCAs are the CAs client certificates must be issued by, such as a
+// SPIFFE trust bundle, read again from their file when it changes like
+// the certificates. A bundle that cannot be read leaves the current CAs in
+// use.
+type clientCAs struct {
+	file string
+	mu   sync.RWMutex
+	pool *x509.CertPool
+}
+
+// loadClientCAs reads the CAs in file
+func loadClientCAs(file string) (*clientCAs, error) {
+	c := &clientCAs{file: file}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the CA file again
+func (c *clientCAs) reload() error {
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.New("no PEM certificates in " + c.file)
+	}
+	c.mu.Lock()
+	c.pool = pool
+	c.mu.Unlock()
+	return nil
+}
+
+// get returns the current CAs
+func (c *clientCAs) get() *x509.CertPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool
+}
+
+// configFor returns a tls.Config.GetConfigForClient verifying client
+// certificates against the current CAs, with the settings of base
+func (c *clientCAs) configFor(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	base = base.Clone()
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		tc := base.Clone()
+		tc.ClientCAs = c.get()
+		return tc, nil
+	}
+}
+
+// name implements tlsFiles
+func (c *clientCAs) name() string { return "client CA bundle " + c.file }
+
+// files implements tlsFiles
+func (c *clientCAs) files() []string { return []string{c.file} }
+
+// clientRoles returns the traffic classes the client certificate cert may
+// call, granted by every client config matching one of its identities
+func clientRoles(cert *x509.Certificate, clients []config.ClientConfig) map[string]bool {
+	ids := clientIDs(cert)
+	roles := make(map[string]bool)
+	for _, c := range clients {
+		for _, id := range ids {
+			if ok, _ := path.Match(c.ID, id); ok {
+				for _, role := range c.Roles {
+					roles[role] = true
+				}
+				break
+			}
+		}
+	}
+	return roles
+}
+
+// clientIDs returns the identities of cert: its URI names, among them a
+// SPIFFE ID, then its DNS names, emails and IP addresses
+func clientIDs(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		ids = append(ids, ip.String())
+	}
+	return ids
+}
+
+// clientName names the client certificate cert in errors, by its first
+// identity or else its subject
+func clientName(cert *x509.Certificate) string {
+	if ids := clientIDs(cert); len(ids) > 0 {
+		return ids[0]
+	}
+	return fmt.Sprintf("%q", cert.Subject.String())
+}
@@ -0,0 +1,152 @@
+package listeners
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Classifier returns the traffic class of a request path
+type Classifier func(path string) string
+
+// Listeners are the HTTP listeners of the agent, each serving the traffic
+// classes it is configured for from the same handler
+type Listeners struct {
+	servers []*http.Server
+	cfgs    []config.ListenerConfig
+	certs   []*certificate
+	cas     []*clientCAs
+	logger  *logrus.Logger
+}
+
+// New creates the listeners of cfg serving handler, reading the
+// certificates of those serving TLS. Without configured listeners, one
+// listener on port serves every class.
+func New(cfg config.ServerConfig, port int, handler http.Handler, classify Classifier, logger *logrus.Logger) (*Listeners, error) {
+	cfgs := cfg.Listeners
+	if len(cfgs) == 0 {
+		cfgs = []config.ListenerConfig{{
+			Name:   "default",
+			Port:   port,
+			Serves: []string{config.TrafficManagement, config.TrafficData, config.TrafficAdmin},
+		}}
+	}
+	l := &Listeners{cfgs: cfgs, logger: logger}
+	for _, lc := range cfgs {
+		srv := &http.Server{
+			Addr:         net.JoinHostPort(lc.Host, strconv.Itoa(lc.Port)),
+			Handler:      restrict(lc, handler, classify),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		cert, err := l.listenerCertificate(cfg.TLS, lc)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			srv.TLSConfig = tlsConfig(cfg.TLS, cert)
+		}
+		if lc.ClientCAFile != "" {
+			cas, err := l.listenerClientCAs(lc)
+			if err != nil {
+				return nil, err
+			}
+			srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			// Each handshake takes the current CAs, so that rotated
+			// bundles apply without a restart
+			srv.TLSConfig.GetConfigForClient = cas.configFor(srv.TLSConfig)
+		}
+		l.servers = append(l.servers, srv)
+	}
+	return l, nil
+}
+
+// Start serves every listener in the background. A listener that cannot
+// start stops the agent.
+func (l *Listeners) Start() {
+	for i, srv := range l.servers {
+		srv, lc := srv, l.cfgs[i]
+		go func() {
+			l.logger.Infof("Starting %s listener on %s serving %s", lc.Name, srv.Addr, strings.Join(lc.Serves, ", "))
+			var err error
+			if srv.TLSConfig != nil {
+				// The certificate comes from TLSConfig, so that renewals
+				// are served without a restart
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				l.logger.Fatalf("Failed to start %s listener: %v", lc.Name, err)
+			}
+		}()
+	}
+}
+
+// Shutdown stops every listener gracefully, returning the first error
+func (l *Listeners) Shutdown(ctx context.Context) error {
+	var first error
+	for i, srv := range l.servers {
+		if err := srv.Shutdown(ctx); err != nil && first == nil {
+			first = fmt.Errorf("%s listener: %w", l.cfgs[i].Name, err)
+		}
+	}
+	return first
+}
+
+// restrict serves the requests of the listener's classes from next,
+// checking its client certificates and tokens first
+func restrict(lc config.ListenerConfig, next http.Handler, classify Classifier) http.Handler {
+	serves := make(map[string]bool, len(lc.Serves))
+	for _, class := range lc.Serves {
+		serves[class] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := classify(r.URL.Path)
+		if !serves[class] {
+			http.NotFound(w, r)
+			return
+		}
+		if len(lc.Clients) > 0 {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				http.Error(w, "client certificate required", http.StatusUnauthorized)
+				return
+			}
+			if cert := r.TLS.PeerCertificates[0]; !clientRoles(cert, lc.Clients)[class] {
+				http.Error(w, "client "+clientName(cert)+" may not call "+class+" endpoints", http.StatusForbidden)
+				return
+			}
+		}
+		if len(lc.Tokens) > 0 && !authorized(r, lc.Tokens) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+lc.Name+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r presents one of tokens as a bearer token
+func authorized(r *http.Request, tokens []string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, want := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package listeners
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// debounce is how long certificate files must be left alone before they
+// are read, since renewals write the certificate and key separately
+const debounce = time.Second
+
+// certificate is the certificate of a TLS listener, read again from its
+// files when they change. A renewal that cannot be read leaves the current
+// certificate in use.
+type certificate struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+// loadCertificate reads the certificate in certFile and keyFile
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the certificate files again
+func (c *certificate) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// get implements tls.Config.GetCertificate
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// name implements tlsFiles
+func (c *certificate) name() string { return "TLS certificate " + c.certFile }
+
+// files implements tlsFiles
+func (c *certificate) files() []string { return []string{c.certFile, c.keyFile} }
+
+// tlsFiles is TLS material read from files, such as a certificate or a
+// client CA bundle, read again when they change
+type tlsFiles interface {
+	reload() error
+	// name names the material in logs
+	name() string
+	files() []string
+}
+
+// touchedBy reports whether ev may have changed the files of f
+func touchedBy(f tlsFiles, ev fsnotify.Event) bool {
+	if ev.Op == fsnotify.Chmod {
+		return false
+	}
+	// Mounted secrets swap a symlinked directory, so changes to its
+	// entries count too
+	name := filepath.Clean(ev.Name)
+	if filepath.Base(name) == "..data" {
+		return true
+	}
+	for _, file := range f.files() {
+		if name == filepath.Clean(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig returns the TLS settings of a listener serving cert
+func tlsConfig(cfg config.TLSConfig, cert *certificate) *tls.Config {
+	tc := &tls.Config{
+		MinVersion:     config.TLSVersions[cfg.MinVersion],
+		GetCertificate: cert.get,
+	}
+	for _, name := range cfg.CipherSuites {
+		id, _ := config.CipherSuite(name)
+		tc.CipherSuites = append(tc.CipherSuites, id)
+	}
+	return tc
+}
+
+// tlsFiles returns the certificates and client CA bundles of the listeners
+func (l *Listeners) tlsFiles() []tlsFiles {
+	var files []tlsFiles
+	for _, c := range l.certs {
+		files = append(files, c)
+	}
+	for _, c := range l.cas {
+		files = append(files, c)
+	}
+	return files
+}
+
+// WatchCertificates reads the certificates and client CA bundles of the
+// TLS listeners again whenever their files change, until ctx is cancelled,
+// so that renewed certificates and rotated CAs are used without a restart
+func (l *Listeners) WatchCertificates(ctx context.Context) {
+	watched := l.tlsFiles()
+	if len(watched) == 0 {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		l.logger.Errorf("Failed to watch TLS certificates; reloading on SIGHUP only: %v", err)
+		return
+	}
+	defer watcher.Close()
+	// Watch the directories, as renewals often replace the files rather
+	// than write them
+	dirs := make(map[string]bool)
+	for _, f := range watched {
+		for _, file := range f.files() {
+			dirs[filepath.Dir(file)] = true
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			l.logger.Errorf("Failed to watch TLS certificates in %s; reloading on SIGHUP only: %v", dir, err)
+			return
+		}
+	}
+
+	changed := make(map[tlsFiles]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-watcher.Events:
+			for _, f := range watched {
+				if touchedBy(f, ev) {
+					changed[f] = true
+					timer.Reset(debounce)
+				}
+			}
+		case err := <-watcher.Errors:
+			l.logger.Warnf("TLS certificate watch error: %v", err)
+		case <-timer.C:
+			for f := range changed {
+				l.reload(f)
+			}
+			clear(changed)
+		}
+	}
+}
+
+// ReloadCertificates reads the certificates and client CA bundles of every
+// TLS listener again
+func (l *Listeners) ReloadCertificates() {
+	for _, f := range l.tlsFiles() {
+		l.reload(f)
+	}
+}
+
+// reload reads f again, logging the outcome
+func (l *Listeners) reload(f tlsFiles) {
+	if err := f.reload(); err != nil {
+		l.logger.Errorf("Failed to reload %s; keeping the current one: %v", f.name(), err)
+		return
+	}
+	l.logger.Infof("Reloaded %s", f.name())
+}
+
+// listenerCertificate returns the certificate lc serves, loading it once
+// for listeners sharing files, or nil when lc serves plaintext
+func (l *Listeners) listenerCertificate(cfg config.TLSConfig, lc config.ListenerConfig) (*certificate, error) {
+	certFile, keyFile := lc.CertFile, lc.KeyFile
+	if certFile == "" {
+		if !cfg.Enabled {
+			return nil, nil
+		}
+		certFile, keyFile = cfg.CertFile, cfg.KeyFile
+	}
+	for _, c := range l.certs {
+		if c.certFile == certFile && c.keyFile == keyFile {
+			return c, nil
+		}
+	}
+	c, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate of %s listener: %w", lc.Name, err)
+	}
+	l.certs = append(l.certs, c)
+	return c, nil
+}
+
+// listenerClientCAs returns the client CAs of lc, loading them once for
+// listeners sharing a bundle
+func (l *Listeners) listenerClientCAs(lc config.ListenerConfig) (*clientCAs, error) {
+	for _, c := range l.cas {
+		if c.file == lc.ClientCAFile {
+			return c, nil
+		}
+	}
+	c, err := loadClientCAs(lc.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client CAs of %s listener: %w", lc.Name, err)
+	}
+	l.cas = append(l.cas, c)
+	return c, nil
+}
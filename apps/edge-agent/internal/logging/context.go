@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+type debugKey struct{}
+
+// NewContext returns a context carrying a request- or execution-scoped log entry
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// FromContext returns the scoped log entry from ctx, or an entry on fallback if none is set
+func FromContext(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(fallback)
+}
+
+// WithDebug marks ctx as belonging to a request or execution with debugging enabled
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+// IsDebug reports whether debugging was enabled for the request or execution in ctx
+func IsDebug(ctx context.Context) bool {
+	debug, _ := ctx.Value(debugKey{}).(bool)
+	return debug
+}
+
+var (
+	debugMu      sync.Mutex
+	debugLoggers = make(map[*logrus.Logger]*logrus.Logger)
+)
+
+// DebugLogger returns the logger sharing base's output, formatter and hooks
+// but logging at debug level, so a single request or execution can be
+// traced in detail without changing the global log level. Every caller
+// gets the same debug logger for base, so that the lines of concurrent
+// debug requests and executions are written under one lock.
+func DebugLogger(base *logrus.Logger) *logrus.Logger {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+	if logger, ok := debugLoggers[base]; ok {
+		return logger
+	}
+	logger := logrus.New()
+	logger.Out = base.Out
+	logger.Formatter = base.Formatter
+	logger.Hooks = base.Hooks
+	logger.ReportCaller = base.ReportCaller
+	logger.ExitFunc = base.ExitFunc
+	logger.SetLevel(logrus.DebugLevel)
+	debugLoggers[base] = logger
+	return logger
+}
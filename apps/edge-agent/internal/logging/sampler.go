@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// summaryField marks summary entries so the sampler never suppresses its own reports
+const summaryField = "log_sampling_summary"
+
+// Sampler is a logrus formatter that suppresses bursts of identical log lines.
+// Entries are keyed by level and message; once a key exceeds its budget for the
+// current window further occurrences are dropped and counted, and a periodic
+// "suppressed N similar messages" summary is emitted in their place.
+type Sampler struct {
+	cfg    config.LogSamplingConfig
+	logger *logrus.Logger
+	next   logrus.Formatter
+
+	mu       sync.Mutex
+	counters map[string]*sampleCounter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type sampleCounter struct {
+	level       logrus.Level
+	message     string
+	windowStart time.Time
+	seen        int
+	suppressed  int
+	lastSeen    time.Time
+}
+
+// NewSampler creates a sampler wrapping the logger's current formatter
+func NewSampler(cfg config.LogSamplingConfig, logger *logrus.Logger) *Sampler {
+	return &Sampler{
+		cfg:      cfg,
+		logger:   logger,
+		next:     logger.Formatter,
+		counters: make(map[string]*sampleCounter),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Install replaces the logger's formatter with the sampler and starts the summary loop
+func (s *Sampler) Install() {
+	s.logger.SetFormatter(s)
+	go s.run()
+}
+
+// Stop stops the summary loop and flushes any pending suppression counts
+func (s *Sampler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Format implements logrus.Formatter
+func (s *Sampler) Format(entry *logrus.Entry) ([]byte, error) {
+	if _, ok := entry.Data[summaryField]; ok {
+		delete(entry.Data, summaryField)
+		return s.next.Format(entry)
+	}
+	if !s.allow(entry.Level, entry.Message, entry.Time) {
+		return nil, nil
+	}
+	return s.next.Format(entry)
+}
+
+// allow records an occurrence of the entry and reports whether it should be written
+func (s *Sampler) allow(level logrus.Level, message string, now time.Time) bool {
+	key := level.String() + "|" + message
+	window := time.Duration(s.cfg.Window) * time.Second
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok {
+		if s.cfg.MaxKeys > 0 && len(s.counters) >= s.cfg.MaxKeys {
+			// Too many distinct messages to track; fail open rather than lose logs
+			return true
+		}
+		counter = &sampleCounter{level: level, message: message, windowStart: now}
+		s.counters[key] = counter
+	}
+
+	if now.Sub(counter.windowStart) >= window {
+		counter.windowStart = now
+		counter.seen = 0
+	}
+	counter.seen++
+	counter.lastSeen = now
+
+	if counter.seen <= s.cfg.Initial {
+		return true
+	}
+	if s.cfg.Thereafter > 0 && (counter.seen-s.cfg.Initial)%s.cfg.Thereafter == 0 {
+		return true
+	}
+	counter.suppressed++
+	return false
+}
+
+// run periodically reports suppressed messages until stopped
+func (s *Sampler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(s.cfg.SummaryInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(time.Now())
+		case <-s.stop:
+			s.flush(time.Now())
+			return
+		}
+	}
+}
+
+// flush emits one summary per suppressed key and forgets idle keys
+func (s *Sampler) flush(now time.Time) {
+	type summary struct {
+		level   logrus.Level
+		message string
+		count   int
+	}
+
+	idle := time.Duration(s.cfg.Window) * time.Second
+
+	s.mu.Lock()
+	var summaries []summary
+	for key, counter := range s.counters {
+		if counter.suppressed > 0 {
+			summaries = append(summaries, summary{counter.level, counter.message, counter.suppressed})
+			counter.suppressed = 0
+		}
+		if now.Sub(counter.lastSeen) > idle {
+			delete(s.counters, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sum := range summaries {
+		s.logger.WithFields(logrus.Fields{
+			summaryField:         true,
+			"suppressed_count":   sum.count,
+			"suppressed_level":   sum.level.String(),
+			"suppressed_message": sum.message,
+		}).Warnf("Suppressed %d similar messages", sum.count)
+	}
+}
@@ -0,0 +1,87 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coerce converts v to the target type
+func coerce(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case TypeString:
+		switch t := v.(type) {
+		case string:
+			return t, nil
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), nil
+		case json.Number:
+			return t.String(), nil
+		case bool:
+			return strconv.FormatBool(t), nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %T to a string", v)
+		}
+		return string(data), nil
+
+	case TypeInteger:
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(f), nil
+
+	case TypeNumber:
+		return toNumber(v)
+
+	case TypeBoolean:
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case float64:
+			return t != 0, nil
+		case json.Number:
+			f, err := t.Float64()
+			return f != 0, err
+		case string:
+			switch strings.ToLower(strings.TrimSpace(t)) {
+			case "true", "yes", "y", "1", "on":
+				return true, nil
+			case "false", "no", "n", "0", "off", "":
+				return false, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot convert %v to a boolean", v)
+	}
+	return v, nil
+}
+
+func toNumber(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case int64:
+		return float64(t), nil
+	case json.Number:
+		return t.Float64()
+	case bool:
+		if t {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to a number", t)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", v)
+}
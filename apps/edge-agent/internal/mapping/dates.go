@@ -0,0 +1,106 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFormat is a parsed from/to format. Formats are "rfc3339" (or
+// "iso8601"), "unix" and "unixms" epoch numbers, strftime patterns such as
+// "%d/%m/%Y %H:%M", or Go reference layouts. An empty from format accepts
+// RFC 3339 timestamps and plain dates.
+type dateFormat struct {
+	epoch  time.Duration
+	layout string
+}
+
+var autoLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+var strftime = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'f': "000000", 'p': "PM",
+	'b': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", '%': "%",
+}
+
+func parseDateFormat(s string) (dateFormat, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return dateFormat{}, nil
+	case "rfc3339", "iso8601":
+		return dateFormat{layout: time.RFC3339Nano}, nil
+	case "unix":
+		return dateFormat{epoch: time.Second}, nil
+	case "unixms":
+		return dateFormat{epoch: time.Millisecond}, nil
+	}
+	if !strings.Contains(s, "%") {
+		return dateFormat{layout: s}, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return dateFormat{}, fmt.Errorf("%q ends with %%", s)
+		}
+		layout, ok := strftime[s[i+1]]
+		if !ok {
+			return dateFormat{}, fmt.Errorf("unsupported directive %%%c", s[i+1])
+		}
+		b.WriteString(layout)
+		i++
+	}
+	return dateFormat{layout: b.String()}, nil
+}
+
+func (f dateFormat) parse(v interface{}, loc *time.Location) (time.Time, error) {
+	if f.epoch != 0 {
+		n, err := toNumber(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(n*float64(f.epoch))), nil
+	}
+
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = strings.TrimSpace(t)
+	case json.Number:
+		s = t.String()
+	case float64:
+		s = strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return time.Time{}, fmt.Errorf("cannot parse %T as a date", v)
+	}
+
+	layouts := autoLayouts
+	if f.layout != "" {
+		layouts = []string{f.layout}
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date", s)
+}
+
+func (f dateFormat) format(t time.Time) interface{} {
+	switch {
+	case f.epoch == time.Second:
+		return t.Unix()
+	case f.epoch == time.Millisecond:
+		return t.UnixMilli()
+	case f.layout == "":
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format(f.layout)
+}
@@ -0,0 +1,291 @@
+package mapping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target types a field can be coerced to
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Modes of a mapping
+const (
+	// ModeReplace builds the output from the mapped fields only
+	ModeReplace = "replace"
+	// ModeMerge applies the mapped fields on top of the input record
+	ModeMerge = "merge"
+)
+
+// Spec is a declarative source-to-target field table
+type Spec struct {
+	Mode   string  `json:"mode,omitempty"`
+	Fields []Field `json:"fields"`
+	// Lookups are named value tables referenced by fields
+	Lookups map[string]map[string]interface{} `json:"lookups,omitempty"`
+}
+
+// Field maps one value. The value comes from the Source dot-path (numeric
+// segments index arrays) or the constant Value, falls back to Default when
+// missing or null, is translated through the Lookup table, converted from
+// the From to the To date format and finally coerced to Type.
+type Field struct {
+	Source   string      `json:"source,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	Target   string      `json:"target"`
+	Default  interface{} `json:"default,omitempty"`
+	Lookup   string      `json:"lookup,omitempty"`
+	From     string      `json:"from,omitempty"`
+	To       string      `json:"to,omitempty"`
+	Timezone string      `json:"timezone,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Required bool        `json:"required,omitempty"`
+}
+
+// Mapping is a compiled spec
+type Mapping struct {
+	mode   string
+	fields []compiledField
+}
+
+type compiledField struct {
+	Field
+	source []string
+	target []string
+	table  map[string]interface{}
+	from   dateFormat
+	to     dateFormat
+	loc    *time.Location
+}
+
+// Compile validates spec and returns every problem found at once
+func Compile(spec Spec) (*Mapping, error) {
+	m := &Mapping{mode: spec.Mode}
+	if m.mode == "" {
+		m.mode = ModeReplace
+	}
+
+	var problems []string
+	fail := func(i int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("fields[%d]: ", i)+fmt.Sprintf(format, args...))
+	}
+	if m.mode != ModeReplace && m.mode != ModeMerge {
+		problems = append(problems, fmt.Sprintf("invalid mode %q: must be %s or %s", spec.Mode, ModeReplace, ModeMerge))
+	}
+	if len(spec.Fields) == 0 {
+		problems = append(problems, "at least one field is required")
+	}
+
+	targets := make(map[string]bool, len(spec.Fields))
+	for i, f := range spec.Fields {
+		cf := compiledField{Field: f, loc: time.UTC}
+		switch {
+		case f.Target == "":
+			fail(i, "target is required")
+		case targets[f.Target]:
+			fail(i, "target %q is mapped twice", f.Target)
+		default:
+			cf.target = strings.Split(f.Target, ".")
+			for _, seg := range cf.target {
+				if seg == "" {
+					fail(i, "target %q has an empty segment", f.Target)
+					break
+				}
+			}
+		}
+		targets[f.Target] = true
+
+		if f.Source != "" && f.Value != nil {
+			fail(i, "source and value are mutually exclusive")
+		}
+		if f.Source == "" && f.Value == nil && f.Default == nil {
+			fail(i, "one of source, value or default is required")
+		}
+		if f.Source != "" {
+			cf.source = strings.Split(f.Source, ".")
+		}
+		if f.Lookup != "" {
+			table, ok := spec.Lookups[f.Lookup]
+			if !ok {
+				fail(i, "unknown lookup table %q", f.Lookup)
+			}
+			cf.table = table
+		}
+		switch f.Type {
+		case "", TypeString, TypeInteger, TypeNumber, TypeBoolean:
+		default:
+			fail(i, "unknown type %q", f.Type)
+		}
+
+		if f.From != "" && f.To == "" {
+			fail(i, "a date conversion requires to")
+		}
+		if f.To != "" {
+			var err error
+			if cf.from, err = parseDateFormat(f.From); err != nil {
+				fail(i, "invalid from format: %v", err)
+			}
+			if cf.to, err = parseDateFormat(f.To); err != nil {
+				fail(i, "invalid to format: %v", err)
+			}
+		}
+		if f.Timezone != "" {
+			loc, err := time.LoadLocation(f.Timezone)
+			if err != nil {
+				fail(i, "invalid timezone %q", f.Timezone)
+			} else {
+				cf.loc = loc
+			}
+		}
+		m.fields = append(m.fields, cf)
+	}
+
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	return m, nil
+}
+
+// Error lists the problems of an invalid mapping spec
+type Error struct {
+	Problems []string
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return "invalid mapping: " + strings.Join(e.Problems, "; ")
+}
+
+// Apply maps one record. Records are decoded JSON objects.
+func (m *Mapping) Apply(record map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	if m.mode == ModeMerge {
+		out = deepCopy(record).(map[string]interface{})
+	}
+
+	for _, f := range m.fields {
+		v, err := f.apply(record)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Target, err)
+		}
+		set(out, f.target, v)
+	}
+	return out, nil
+}
+
+func (f *compiledField) apply(record map[string]interface{}) (interface{}, error) {
+	v := f.Value
+	if f.source != nil {
+		v, _ = get(record, f.source)
+	}
+	if v == nil {
+		v = f.Default
+	}
+	if v != nil && f.table != nil {
+		mapped, ok := f.table[keyString(v)]
+		if !ok {
+			mapped = f.Default
+		}
+		v = mapped
+	}
+	if v != nil && f.To != "" {
+		t, err := f.from.parse(v, f.loc)
+		if err != nil {
+			return nil, err
+		}
+		v = f.to.format(t.In(f.loc))
+	}
+	if v == nil {
+		if f.Required {
+			return nil, errors.New("value is required")
+		}
+		return nil, nil
+	}
+	if f.Type != "" {
+		return coerce(v, f.Type)
+	}
+	return v, nil
+}
+
+// Get returns the value at a dot-path in a decoded JSON value; numeric
+// segments index arrays
+func Get(v interface{}, path string) (interface{}, bool) {
+	return get(v, strings.Split(path, "."))
+}
+
+// get resolves a path in a decoded JSON value
+func get(v interface{}, path []string) (interface{}, bool) {
+	cur := v
+	for _, seg := range path {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			next, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// set assigns v at path, creating intermediate objects
+func set(out map[string]interface{}, path []string, v interface{}) {
+	cur := out
+	for _, seg := range path[:len(path)-1] {
+		next, ok := cur[seg].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[seg] = next
+		}
+		cur = next
+	}
+	cur[path[len(path)-1]] = v
+}
+
+// keyString renders a value as a lookup table key
+func keyString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case json.Number:
+		return t.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			c[k] = deepCopy(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i, item := range t {
+			c[i] = deepCopy(item)
+		}
+		return c
+	}
+	return v
+}
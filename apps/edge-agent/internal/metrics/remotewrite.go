@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/forward"
+	"github.com/klauspost/compress/snappy"
+	"github.com/sirupsen/logrus"
+)
+
+// KindRemoteWrite is the kind of buffered metric samples
+const KindRemoteWrite = "remote_write"
+
+// Sample is the current value of one series
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Source reports the current value of some series
+type Source func() []Sample
+
+// batch is the samples taken at one instant, as buffered
+type batch struct {
+	// Timestamp is in milliseconds since the epoch
+	Timestamp int64    `json:"timestamp"`
+	Samples   []Sample `json:"samples"`
+}
+
+// RemoteWriter samples the agent's metrics on an interval and pushes them
+// with the Prometheus remote-write protocol. Every batch goes through a
+// forward buffer, so that samples taken while the receiver is unreachable
+// are pushed, in order and with their original timestamps, once it is
+// back.
+type RemoteWriter struct {
+	cfg    config.RemoteWriteConfig
+	buf    *forward.Buffer
+	client *http.Client
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	sources []Source
+}
+
+// NewRemoteWriter creates a writer buffering in buf and pushing through
+// transport, and registers it as the deliverer of buffered samples
+func NewRemoteWriter(cfg config.RemoteWriteConfig, buf *forward.Buffer, transport http.RoundTripper, logger *logrus.Logger) *RemoteWriter {
+	w := &RemoteWriter{
+		cfg:    cfg,
+		buf:    buf,
+		client: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		logger: logger,
+	}
+	buf.Register(KindRemoteWrite, w.deliver)
+	return w
+}
+
+// Add adds a source of samples; it must be called before Run
+func (w *RemoteWriter) Add(source Source) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sources = append(w.sources, source)
+}
+
+// Run samples every source each interval until ctx is cancelled
+func (w *RemoteWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(w.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.buf.Append(KindRemoteWrite, batch{Timestamp: now.UnixMilli(), Samples: w.collect()}); err != nil {
+				w.logger.Errorf("Failed to buffer metric samples: %v", err)
+			}
+		}
+	}
+}
+
+// collect takes the current samples of every source
+func (w *RemoteWriter) collect() []Sample {
+	w.mu.Lock()
+	sources := w.sources
+	w.mu.Unlock()
+
+	var samples []Sample
+	for _, source := range sources {
+		samples = append(samples, source()...)
+	}
+	return samples
+}
+
+// deliver pushes a buffered batch. Answers the receiver may accept later,
+// throttling and server errors, keep it buffered; other rejections drop
+// it, as retrying would not help.
+func (w *RemoteWriter) deliver(ctx context.Context, rec forward.Record) error {
+	var b batch
+	if err := json.Unmarshal(rec.Data, &b); err != nil {
+		return err
+	}
+	if len(b.Samples) == 0 {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(snappy.Encode(nil, encode(&b))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.Token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("remote write answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: %v", forward.ErrUnavailable, err)
+	}
+	return err
+}
+
+// encode marshals b as a remote-write WriteRequest protobuf message, one
+// time series per sample:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encode(b *batch) []byte {
+	var out, series, field []byte
+	for _, s := range b.Samples {
+		series = series[:0]
+		for _, l := range labels(s) {
+			field = field[:0]
+			field = appendString(field, 1, l[0])
+			field = appendString(field, 2, l[1])
+			series = appendBytes(series, 1, field)
+		}
+		field = field[:0]
+		field = binary.AppendUvarint(field, 1<<3|1)
+		field = binary.LittleEndian.AppendUint64(field, math.Float64bits(s.Value))
+		field = binary.AppendUvarint(field, 2<<3)
+		field = binary.AppendUvarint(field, uint64(b.Timestamp))
+		series = appendBytes(series, 2, field)
+		out = appendBytes(out, 1, series)
+	}
+	return out
+}
+
+// labels returns the labels of s with its name, sorted by name as the
+// protocol requires
+func labels(s Sample) [][2]string {
+	list := make([][2]string, 0, len(s.Labels)+1)
+	list = append(list, [2]string{"__name__", s.Name})
+	for name, value := range s.Labels {
+		list = append(list, [2]string{name, value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i][0] < list[j][0] })
+	return list
+}
+
+// appendBytes appends a length-delimited field
+func appendBytes(b []byte, num uint64, v []byte) []byte {
+	b = binary.AppendUvarint(b, num<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, num uint64, v string) []byte {
+	return appendBytes(b, num, []byte(v))
+}
+
+// Runtime reports the agent process's goroutines and memory
+func Runtime() []Sample {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return []Sample{
+		{Name: "edge_agent_goroutines", Value: float64(runtime.NumGoroutine())},
+		{Name: "edge_agent_heap_alloc_bytes", Value: float64(m.HeapAlloc)},
+		{Name: "edge_agent_sys_bytes", Value: float64(m.Sys)},
+		{Name: "edge_agent_gc_total", Value: float64(m.NumGC)},
+	}
+}
@@ -0,0 +1,207 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// schemaKey is the key of the schema record in store.BucketMeta
+const schemaKey = "schema"
+
+// Migration is one versioned change to the store's data layout
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, st store.Store) error
+	Down        func(ctx context.Context, st store.Store) error
+}
+
+// Applied records when a migration ran
+type Applied struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"appliedAt"`
+}
+
+// schema is the persisted schema state
+type schema struct {
+	Version int       `json:"version"`
+	History []Applied `json:"history"`
+}
+
+// Status describes the store's schema relative to this binary
+type Status struct {
+	Current int
+	Latest  int
+	Applied []Applied
+	Pending []Migration
+}
+
+// Migrator applies migrations to a store
+type Migrator struct {
+	store      store.Store
+	logger     *logrus.Logger
+	migrations []Migration
+}
+
+// New creates a migrator using the migrations compiled into the binary
+func New(st store.Store, logger *logrus.Logger) *Migrator {
+	return &Migrator{store: st, logger: logger, migrations: migrations}
+}
+
+// Latest returns the newest schema version known to this binary
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reports the current schema version and pending migrations
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	sc, err := m.load(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Current: sc.Version, Latest: m.Latest(), Applied: sc.History}
+	for _, mig := range m.migrations {
+		if mig.Version > sc.Version {
+			status.Pending = append(status.Pending, mig)
+		}
+	}
+	return status, nil
+}
+
+// Up applies pending migrations up to and including target (0 means latest)
+func (m *Migrator) Up(ctx context.Context, target int) (int, error) {
+	if target == 0 {
+		target = m.Latest()
+	}
+	sc, err := m.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if sc.Version > m.Latest() {
+		return 0, fmt.Errorf("store schema version %d is newer than this agent supports (%d)", sc.Version, m.Latest())
+	}
+
+	applied := 0
+	for _, mig := range m.migrations {
+		if mig.Version <= sc.Version || mig.Version > target {
+			continue
+		}
+		m.logger.WithField("version", mig.Version).Infof("Applying migration: %s", mig.Description)
+		if err := mig.Up(ctx, m.store); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Description, err)
+		}
+		sc.Version = mig.Version
+		sc.History = append(sc.History, Applied{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC()})
+		if err := m.save(ctx, sc); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// Down reverts applied migrations until the schema is at target
+func (m *Migrator) Down(ctx context.Context, target int) (int, error) {
+	sc, err := m.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if sc.Version > m.Latest() {
+		return 0, fmt.Errorf("store schema version %d is newer than this agent supports (%d)", sc.Version, m.Latest())
+	}
+
+	reverted := 0
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version > sc.Version || mig.Version <= target {
+			continue
+		}
+		m.logger.WithField("version", mig.Version).Infof("Reverting migration: %s", mig.Description)
+		if err := mig.Down(ctx, m.store); err != nil {
+			return reverted, fmt.Errorf("revert of migration %d (%s) failed: %w", mig.Version, mig.Description, err)
+		}
+		sc.Version = previousVersion(m.migrations, i)
+		if len(sc.History) > 0 {
+			sc.History = sc.History[:len(sc.History)-1]
+		}
+		if err := m.save(ctx, sc); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// EnsureCurrent brings the store up to date at startup. It refuses to run
+// against a store written by a newer agent, and backs up the store before
+// migrating when it supports backups.
+func (m *Migrator) EnsureCurrent(ctx context.Context, backupPath string) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Current > status.Latest {
+		return fmt.Errorf("store schema version %d is newer than this agent supports (%d); upgrade the agent", status.Current, status.Latest)
+	}
+	if len(status.Pending) == 0 {
+		return nil
+	}
+
+	if b, ok := store.As[store.Backuper](m.store); ok && backupPath != "" && status.Current > 0 {
+		path := fmt.Sprintf("%s.v%d.bak", backupPath, status.Current)
+		if err := b.Backup(path); err != nil {
+			return fmt.Errorf("failed to back up store before migrating: %w", err)
+		}
+		m.logger.WithField("path", path).Info("Backed up store before migrating")
+	}
+
+	_, err = m.Up(ctx, 0)
+	return err
+}
+
+// load reads the schema record, treating a missing record as version 0
+func (m *Migrator) load(ctx context.Context) (schema, error) {
+	var sc schema
+	rec, err := m.store.Get(ctx, store.BucketMeta, schemaKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return sc, nil
+	}
+	if err != nil {
+		return sc, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if err := json.Unmarshal(rec.Value, &sc); err != nil {
+		return sc, fmt.Errorf("failed to decode schema version: %w", err)
+	}
+	return sc, nil
+}
+
+// save writes the schema record
+func (m *Migrator) save(ctx context.Context, sc schema) error {
+	value, err := json.Marshal(sc)
+	if err != nil {
+		return fmt.Errorf("failed to encode schema version: %w", err)
+	}
+	if err := m.store.Put(ctx, store.BucketMeta, &store.Record{Key: schemaKey, Value: value}); err != nil {
+		return fmt.Errorf("failed to write schema version: %w", err)
+	}
+	return nil
+}
+
+// previousVersion returns the version preceding migrations[i], or 0
+func previousVersion(migrations []Migration, i int) int {
+	if i == 0 {
+		return 0
+	}
+	return migrations[i-1].Version
+}
@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// migrations is the ordered list compiled into the binary. Versions must be
+// strictly increasing; never edit a released migration, add a new one.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up:          func(ctx context.Context, st store.Store) error { return nil },
+		Down:        func(ctx context.Context, st store.Store) error { return nil },
+	},
+	{
+		Version:     2,
+		Description: "index executions by flow and status",
+		Up:          indexExecutionsUp,
+		Down:        indexExecutionsDown,
+	},
+}
+
+// executionLabels are the fields migration 2 copies from execution documents into labels
+var executionLabels = map[string]string{"flowId": "flow_id", "status": "status"}
+
+// indexExecutionsUp backfills flow_id and status labels on live and archived executions
+func indexExecutionsUp(ctx context.Context, st store.Store) error {
+	return rewriteExecutions(ctx, st, func(rec *store.Record) (bool, error) {
+		value, err := store.Decode(rec)
+		if err != nil {
+			return false, err
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return false, fmt.Errorf("failed to decode execution %s: %w", rec.Key, err)
+		}
+
+		changed := false
+		for field, label := range executionLabels {
+			v, ok := doc[field].(string)
+			if !ok || rec.Labels[label] == v {
+				continue
+			}
+			if rec.Labels == nil {
+				rec.Labels = make(map[string]string)
+			}
+			rec.Labels[label] = v
+			changed = true
+		}
+		return changed, nil
+	})
+}
+
+// indexExecutionsDown removes the labels added by indexExecutionsUp
+func indexExecutionsDown(ctx context.Context, st store.Store) error {
+	return rewriteExecutions(ctx, st, func(rec *store.Record) (bool, error) {
+		changed := false
+		for _, label := range executionLabels {
+			if _, ok := rec.Labels[label]; ok {
+				delete(rec.Labels, label)
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+}
+
+// rewriteExecutions applies fn to every live and archived execution, saving those it changes
+func rewriteExecutions(ctx context.Context, st store.Store, fn func(rec *store.Record) (bool, error)) error {
+	for _, bucket := range []string{store.BucketExecutions, store.ArchiveBucket(store.BucketExecutions)} {
+		records, err := st.List(ctx, bucket, store.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", bucket, err)
+		}
+		for _, rec := range records {
+			changed, err := fn(rec)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+			if err := st.Put(ctx, bucket, rec); err != nil {
+				return fmt.Errorf("failed to update execution %s: %w", rec.Key, err)
+			}
+		}
+	}
+	return nil
+}
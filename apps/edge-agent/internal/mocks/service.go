@@ -0,0 +1,344 @@
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Prefix is where the agent serves mocks; a mock with path "/orders/:id"
+// answers requests to /mocks/orders/42
+const Prefix = "/mocks"
+
+// Bucket holds the mock definitions
+const Bucket = "mocks"
+
+// maxRequestBytes bounds the request body read for templates
+const maxRequestBytes = 1 << 20
+
+var (
+	// ErrNotFound is returned when a mock does not exist
+	ErrNotFound = errors.New("mock not found")
+
+	// ErrRouteTaken is returned when another mock serves the same method
+	// and path
+	ErrRouteTaken = errors.New("route is served by another mock")
+)
+
+// Service manages mock definitions in the store and serves them. Requests
+// are matched against the stored mocks, so changes apply at once and on
+// every replica sharing the store.
+type Service struct {
+	store      store.Store
+	maxLatency time.Duration
+	logger     *logrus.Logger
+}
+
+// NewService creates a mock service whose mocks may delay responses by at
+// most maxLatency
+func NewService(st store.Store, maxLatency time.Duration, logger *logrus.Logger) *Service {
+	return &Service{store: st, maxLatency: maxLatency, logger: logger}
+}
+
+// Page returns the mocks matching opts, which may filter on the method and
+// name labels, along with how many mocks match it in all
+func (s *Service) Page(ctx context.Context, opts store.ListOptions) ([]*model.Mock, int, error) {
+	total, err := s.store.Count(ctx, Bucket, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count mocks: %w", err)
+	}
+	list, err := s.list(ctx, opts)
+	return list, total, err
+}
+
+// list returns the mocks matching opts
+func (s *Service) list(ctx context.Context, opts store.ListOptions) ([]*model.Mock, error) {
+	records, err := s.store.List(ctx, Bucket, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mocks: %w", err)
+	}
+	list := make([]*model.Mock, 0, len(records))
+	for _, rec := range records {
+		mock, err := decode(rec)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, mock)
+	}
+	return list, nil
+}
+
+// Get returns the mock with the given ID
+func (s *Service) Get(ctx context.Context, id string) (*model.Mock, error) {
+	rec, err := s.store.Get(ctx, Bucket, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mock %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// Create validates and stores a new mock
+func (s *Service) Create(ctx context.Context, mock *model.Mock) error {
+	if err := s.validate(ctx, mock); err != nil {
+		return err
+	}
+	mock.ID = ids.New("mock")
+	mock.CreatedAt = time.Time{}
+	return s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, mock)
+	})
+}
+
+// Update validates and replaces an existing mock
+func (s *Service) Update(ctx context.Context, mock *model.Mock) error {
+	if err := s.validate(ctx, mock); err != nil {
+		return err
+	}
+	return s.store.Update(ctx, func(tx store.Tx) error {
+		rec, err := tx.Get(Bucket, mock.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get mock %s: %w", mock.ID, err)
+		}
+		mock.CreatedAt = rec.CreatedAt
+		return save(tx, mock)
+	})
+}
+
+// Delete removes a mock
+func (s *Service) Delete(ctx context.Context, id string) error {
+	err := s.store.Delete(ctx, Bucket, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete mock %s: %w", id, err)
+	}
+	return nil
+}
+
+// validate checks a mock's definition and templates, and that no other mock
+// serves its route. Two mocks saved at once may still claim the same route;
+// requests then go to either.
+func (s *Service) validate(ctx context.Context, mock *model.Mock) error {
+	v := &model.ValidationError{}
+	if err := mock.Validate(); err != nil && !errors.As(err, &v) {
+		return err
+	}
+	if d, err := time.ParseDuration(mock.Latency); err == nil && d > s.maxLatency {
+		v.Add("latency", model.ProblemInvalid, "latency must be at most %s", s.maxLatency)
+	}
+	for name, value := range mock.Response.Headers {
+		if err := checkTemplate(value); err != nil {
+			v.Add("response.headers."+name, model.ProblemInvalid, "response.headers.%s: %v", name, err)
+		}
+	}
+	if err := checkBody(mock.Response.Body); err != nil {
+		v.Add("response.body", model.ProblemInvalid, "response.body: %v", err)
+	}
+	if err := v.Err(); err != nil {
+		return err
+	}
+
+	records, err := s.store.List(ctx, Bucket, store.ListOptions{Labels: map[string]string{"route": mock.Route()}})
+	if err != nil {
+		return fmt.Errorf("failed to list mocks: %w", err)
+	}
+	for _, rec := range records {
+		if rec.Key != mock.ID {
+			return fmt.Errorf("%w: %s", ErrRouteTaken, rec.Key)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler, responding to requests under Prefix
+// with the mock serving their method and path. Literal path segments take
+// precedence over parameters.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+	if path == "" {
+		path = "/"
+	}
+	records, err := s.store.List(r.Context(), Bucket, store.ListOptions{Labels: map[string]string{"method": r.Method}})
+	if err != nil {
+		s.logger.Errorf("Failed to list mocks: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list mocks"})
+		return
+	}
+	var (
+		mock   *model.Mock
+		params map[string]string
+		best   = -1
+	)
+	for _, rec := range records {
+		m, err := decode(rec)
+		if err != nil {
+			s.logger.Errorf("Failed to decode mock %s: %v", rec.Key, err)
+			continue
+		}
+		if p, literals, ok := match(m.Path, path); ok && literals > best {
+			mock, params, best = m, p, literals
+		}
+	}
+	if mock == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no mock for " + r.Method + " " + path})
+		return
+	}
+
+	req := &request{
+		mockID: mock.ID,
+		method: r.Method,
+		params: params,
+		query:  r.URL.Query(),
+		header: r.Header,
+		now:    time.Now().UTC(),
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(body) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if dec.Decode(&req.body) != nil {
+			req.body = string(body)
+		}
+	}
+
+	if d, _ := time.ParseDuration(mock.Latency); d > 0 {
+		timer := time.NewTimer(min(d, s.maxLatency))
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	s.respond(w, mock, req)
+}
+
+// respond writes the mock's response to req
+func (s *Service) respond(w http.ResponseWriter, mock *model.Mock, req *request) {
+	var (
+		out         []byte
+		contentType string
+	)
+	switch body := mock.Response.Body.(type) {
+	case nil:
+	case string:
+		out, contentType = []byte(req.expand(body)), "text/plain"
+	default:
+		encoded, err := json.Marshal(req.render(body))
+		if err != nil {
+			s.logger.Errorf("Failed to encode the response of mock %s: %v", mock.ID, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to encode mock response"})
+			return
+		}
+		out, contentType = encoded, "application/json"
+	}
+	if mock.Response.ContentType != "" {
+		contentType = mock.Response.ContentType
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	for name, value := range mock.Response.Headers {
+		w.Header().Set(name, req.expand(value))
+	}
+	w.Header().Set("X-FusionFlow-Mock", mock.ID)
+	status := mock.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// match matches a request path against a mock's path pattern, returning its
+// parameters and how many literal segments matched
+func match(pattern, path string) (map[string]string, int, bool) {
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, 0, false
+	}
+	params := make(map[string]string)
+	literals := 0
+	for i, segment := range want {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			if got[i] == "" {
+				return nil, 0, false
+			}
+			params[name] = got[i]
+			continue
+		}
+		if segment != got[i] {
+			return nil, 0, false
+		}
+		literals++
+	}
+	return params, literals, true
+}
+
+// save writes mock within tx
+func save(tx store.Tx, mock *model.Mock) error {
+	now := time.Now().UTC()
+	if mock.CreatedAt.IsZero() {
+		mock.CreatedAt = now
+	}
+	mock.UpdatedAt = now
+
+	value, err := json.Marshal(mock)
+	if err != nil {
+		return fmt.Errorf("failed to encode mock: %w", err)
+	}
+	rec := &store.Record{
+		Key:   mock.ID,
+		Value: value,
+		Labels: map[string]string{
+			"name":   mock.Name,
+			"method": mock.Method,
+			"route":  mock.Route(),
+		},
+		CreatedAt: mock.CreatedAt,
+	}
+	if err := tx.Put(Bucket, rec); err != nil {
+		return fmt.Errorf("failed to store mock: %w", err)
+	}
+	return nil
+}
+
+// decode unmarshals a stored mock
+func decode(rec *store.Record) (*model.Mock, error) {
+	var mock model.Mock
+	if err := json.Unmarshal(rec.Value, &mock); err != nil {
+		return nil, fmt.Errorf("failed to decode mock %s: %w", rec.Key, err)
+	}
+	mock.CreatedAt = rec.CreatedAt
+	mock.UpdatedAt = rec.UpdatedAt
+	return &mock, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,149 @@
+package mocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/mapping"
+)
+
+// placeholder matches a template placeholder: {path.<name>}, {query.<name>}
+// and {header.<name>} for the request's path parameters, query and headers,
+// {body} or {body.<path>} for its JSON body or a field of it, and {method},
+// {mockId} and {timestamp}
+var placeholder = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9_-]*(?:\.[A-Za-z0-9_-]+)*)\}`)
+
+// request is what a mock's templates are filled from
+type request struct {
+	mockID string
+	method string
+	params map[string]string
+	query  url.Values
+	header http.Header
+	// body is the decoded JSON body, or the body as text when it is not JSON
+	body interface{}
+	now  time.Time
+}
+
+// checkTemplate reports the first unknown placeholder of s, so that typos
+// fail when the mock is saved
+func checkTemplate(s string) error {
+	for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+		if !known(m[1]) {
+			return fmt.Errorf("unknown placeholder {%s}", m[1])
+		}
+	}
+	return nil
+}
+
+// checkBody checks the templates of a JSON body template
+func checkBody(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		return checkTemplate(v)
+	case map[string]interface{}:
+		for k, item := range v {
+			if err := checkBody(item); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := checkBody(item); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func known(name string) bool {
+	switch {
+	case name == "body", name == "method", name == "mockId", name == "timestamp":
+		return true
+	case strings.HasPrefix(name, "body."), strings.HasPrefix(name, "path."),
+		strings.HasPrefix(name, "query."), strings.HasPrefix(name, "header."):
+		return true
+	}
+	return false
+}
+
+// expand fills the placeholders of s. Values the request lacks are empty.
+func (r *request) expand(s string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[1 : len(m)-1]
+		if !known(name) {
+			return m
+		}
+		return text(r.value(name))
+	})
+}
+
+// render fills a JSON body template. A string that is only a placeholder
+// keeps the type of the value it refers to.
+func (r *request) render(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if m := placeholder.FindStringSubmatch(v); m != nil && m[0] == v && known(m[1]) {
+			return r.value(m[1])
+		}
+		return r.expand(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = r.render(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.render(item)
+		}
+		return out
+	}
+	return v
+}
+
+func (r *request) value(name string) interface{} {
+	switch {
+	case name == "method":
+		return r.method
+	case name == "mockId":
+		return r.mockID
+	case name == "timestamp":
+		return r.now.Format(time.RFC3339)
+	case name == "body":
+		return r.body
+	case strings.HasPrefix(name, "path."):
+		return r.params[strings.TrimPrefix(name, "path.")]
+	case strings.HasPrefix(name, "query."):
+		return r.query.Get(strings.TrimPrefix(name, "query."))
+	case strings.HasPrefix(name, "header."):
+		return r.header.Get(strings.TrimPrefix(name, "header."))
+	}
+	v, _ := mapping.Get(r.body, strings.TrimPrefix(name, "body."))
+	return v
+}
+
+// text formats a value for a text template: strings and numbers as they
+// are, nil as nothing, and objects and arrays as JSON
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
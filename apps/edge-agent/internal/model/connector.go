@@ -0,0 +1,162 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Connector is a configured connection to an external system that flow steps
+// and triggers use
+type Connector struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Description string                 `json:"description,omitempty"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	Auth        *ConnectorAuth         `json:"auth,omitempty"`
+	Operations  []Operation            `json:"operations,omitempty"`
+	Bandwidth   *Bandwidth             `json:"bandwidth,omitempty"`
+	// WAN marks connectors reached over the agent's uplink, so that writes
+	// to them share its upload budget
+	WAN       bool                `json:"wan,omitempty"`
+	Rotation  *CredentialRotation `json:"rotation,omitempty"`
+	CreatedAt time.Time           `json:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt"`
+}
+
+// ConnectorAuth describes how a connector authenticates. Credentials
+// themselves live in the connector config.
+type ConnectorAuth struct {
+	Type     string   `json:"type"`
+	In       string   `json:"in,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	TokenURL string   `json:"tokenUrl,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// CredentialRotation reconnects a connector so that rotated credentials
+// apply, every Interval and whenever one of the Secrets it reads changes.
+// Executions using the old connection finish on it.
+type CredentialRotation struct {
+	// Interval is a duration such as "24h"
+	Interval string `json:"interval,omitempty"`
+	// Secrets are the references of the secrets holding the credentials,
+	// such as file:erp-password
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// MinRotationInterval is the shortest interval of scheduled rotations
+const MinRotationInterval = time.Minute
+
+// Bandwidth caps the traffic of the steps using a connector. Reads and
+// writes are limited separately and a nil rate leaves that direction
+// unlimited.
+type Bandwidth struct {
+	Read  *Rate `json:"read,omitempty"`
+	Write *Rate `json:"write,omitempty"`
+}
+
+// Rate is a sustained throughput with the burst allowed above it, which
+// defaults to one second's worth of traffic
+type Rate struct {
+	BytesPerSecond int64 `json:"bytesPerSecond"`
+	Burst          int64 `json:"burst,omitempty"`
+}
+
+// Operation actions for messaging connectors
+const (
+	ActionSend    = "send"
+	ActionReceive = "receive"
+)
+
+// Operation is a named request a connector can perform. Request/response
+// connectors set Method and Path; messaging connectors set Action and Channel
+// and describe their payload with Message.
+type Operation struct {
+	ID          string                 `json:"id"`
+	Summary     string                 `json:"summary,omitempty"`
+	Method      string                 `json:"method,omitempty"`
+	Path        string                 `json:"path,omitempty"`
+	Action      string                 `json:"action,omitempty"`
+	Channel     string                 `json:"channel,omitempty"`
+	Parameters  []Parameter            `json:"parameters,omitempty"`
+	ContentType string                 `json:"contentType,omitempty"`
+	RequestBody map[string]interface{} `json:"requestBody,omitempty"`
+	Response    map[string]interface{} `json:"response,omitempty"`
+	Message     map[string]interface{} `json:"message,omitempty"`
+	Bindings    map[string]interface{} `json:"bindings,omitempty"`
+}
+
+// Operation returns the operation with the given ID
+func (c *Connector) Operation(id string) (*Operation, bool) {
+	for i := range c.Operations {
+		if c.Operations[i].ID == id {
+			return &c.Operations[i], true
+		}
+	}
+	return nil, false
+}
+
+// Parameter is an input of an operation, described by a JSON schema
+type Parameter struct {
+	Name     string                 `json:"name"`
+	In       string                 `json:"in"`
+	Required bool                   `json:"required,omitempty"`
+	Schema   map[string]interface{} `json:"schema,omitempty"`
+}
+
+// Validate checks the connector's structural rules
+func (c *Connector) Validate() error {
+	v := &ValidationError{}
+	if c.Name == "" {
+		v.Add("name", ProblemRequired, "name is required")
+	}
+	if c.Type == "" {
+		v.Add("type", ProblemRequired, "type is required")
+	}
+
+	seen := make(map[string]bool, len(c.Operations))
+	for i, op := range c.Operations {
+		path := fmt.Sprintf("operations[%d]", i)
+		switch {
+		case op.ID == "":
+			v.Add(path+".id", ProblemRequired, "%s.id is required", path)
+		case seen[op.ID]:
+			v.Add(path+".id", ProblemDuplicate, "%s.id %q is duplicated", path, op.ID)
+		}
+		seen[op.ID] = true
+		if op.Action != "" && op.Action != ActionSend && op.Action != ActionReceive {
+			v.Add(path+".action", ProblemInvalid, "%s.action must be %q or %q", path, ActionSend, ActionReceive)
+		}
+	}
+
+	if c.Bandwidth != nil {
+		c.Bandwidth.Read.validate("read", v)
+		c.Bandwidth.Write.validate("write", v)
+	}
+	if r := c.Rotation; r != nil {
+		if r.Interval == "" && len(r.Secrets) == 0 {
+			v.Add("rotation", ProblemRequired, "rotation needs an interval or secrets to watch")
+		}
+		if r.Interval != "" {
+			if d, err := time.ParseDuration(r.Interval); err != nil || d < MinRotationInterval {
+				v.Add("rotation.interval", ProblemInvalid, "rotation.interval must be a duration of at least %s", MinRotationInterval)
+			}
+		}
+	}
+	return v.Err()
+}
+
+// validate checks a bandwidth rate, which may be nil
+func (r *Rate) validate(direction string, v *ValidationError) {
+	if r == nil {
+		return
+	}
+	path := "bandwidth." + direction
+	if r.BytesPerSecond <= 0 {
+		v.Add(path+".bytesPerSecond", ProblemInvalid, "%s.bytesPerSecond must be positive", path)
+	}
+	if r.Burst < 0 {
+		v.Add(path+".burst", ProblemInvalid, "%s.burst must not be negative", path)
+	}
+}
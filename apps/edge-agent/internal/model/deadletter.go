@@ -0,0 +1,52 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Statuses of dead letters
+const (
+	DeadLetterPending  = "pending"
+	DeadLetterRedriven = "redriven"
+)
+
+// DeadLetter is the input of a failed execution, kept with the failure so
+// it can be inspected and re-driven once the cause is fixed
+type DeadLetter struct {
+	ID          string `json:"id"`
+	FlowID      string `json:"flowId"`
+	FlowVersion int    `json:"flowVersion,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+	ExecutionID string `json:"executionId"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	// StepID is the step that failed, with its last attempt and error class;
+	// empty when the run failed outside a step
+	StepID     string `json:"stepId,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+	// Payload is the execution's input; nil when it was not kept, for
+	// streams, oversized bodies and flows not capturing payloads
+	Payload *DeadLetterPayload `json:"payload,omitempty"`
+	// DebugLog is set when the execution was logged at debug level, as are
+	// its re-drives
+	DebugLog bool `json:"debugLog,omitempty"`
+	// Redrives counts the re-drives, the latest of which ran as
+	// RedriveExecutionID
+	Redrives           int        `json:"redrives,omitempty"`
+	RedriveExecutionID string     `json:"redriveExecutionId,omitempty"`
+	RedrivenAt         *time.Time `json:"redrivenAt,omitempty"`
+	FailedAt           time.Time  `json:"failedAt"`
+}
+
+// DeadLetterPayload is a kept input message. JSON bodies are embedded as
+// is; other text is a string with encoding text, and binary data a string
+// with encoding base64.
+type DeadLetterPayload struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        json.RawMessage   `json:"body,omitempty"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Size        int               `json:"size"`
+}
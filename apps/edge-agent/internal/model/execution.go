@@ -0,0 +1,161 @@
+package model
+
+import "time"
+
+// Execution statuses
+const (
+	ExecutionQueued    = "queued"
+	ExecutionRunning   = "running"
+	ExecutionSucceeded = "succeeded"
+	ExecutionFailed    = "failed"
+	ExecutionCancelled = "cancelled"
+	// ExecutionWaiting is a suspended execution, waiting to be resumed
+	ExecutionWaiting = "waiting"
+	// ExecutionBudgetExceeded is an execution stopped for exceeding its
+	// flow's budget
+	ExecutionBudgetExceeded = "budget_exceeded"
+)
+
+// Step statuses within an execution
+const (
+	StepRunning   = "running"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepCancelled = "cancelled"
+	StepWaiting   = "waiting"
+	// StepSkipped is a step that had not run when its execution was
+	// cancelled
+	StepSkipped = "skipped"
+)
+
+// Causes of an execution
+const (
+	CauseAPI     = "api"
+	CauseTrigger = "trigger"
+	CauseReplay  = "replay"
+	CauseSubflow = "subflow"
+	// CauseDeadLetter marks a re-drive of a dead-lettered message
+	CauseDeadLetter = "dlq"
+	// CauseQuarantine marks the release of a quarantined message
+	CauseQuarantine = "quarantine"
+)
+
+// Cause records why an execution ran. ParentID links it to the execution it
+// came from: an upstream execution whose event triggered it, the execution
+// it replays or re-drives, or the execution of the calling flow.
+type Cause struct {
+	Type string `json:"type"`
+	// Trigger and TriggerIndex identify the flow trigger that fired
+	Trigger      string `json:"trigger,omitempty"`
+	TriggerIndex *int   `json:"triggerIndex,omitempty"`
+	// RequestID identifies the API or webhook request that started it
+	RequestID string `json:"requestId,omitempty"`
+	ParentID  string `json:"parentId,omitempty"`
+}
+
+// Execution is one run of a flow on one input message
+type Execution struct {
+	ID     string `json:"id"`
+	FlowID string `json:"flowId"`
+	Tenant string `json:"tenant,omitempty"`
+	Status string `json:"status"`
+	Debug  bool   `json:"debug,omitempty"`
+	// DebugLog is set for executions logged at debug level with their
+	// payloads captured, as requested with an authenticated X-Debug header
+	DebugLog bool `json:"debugLog,omitempty"`
+	// FlowVersion is the version of the flow's definition the execution ran
+	FlowVersion int `json:"flowVersion,omitempty"`
+	// Owner is the cluster instance running the execution; empty outside
+	// cluster mode
+	Owner string `json:"owner,omitempty"`
+	Cause *Cause `json:"cause,omitempty"`
+	// QueuedAt is when the execution was submitted; StartTime is when it got
+	// an execution slot and began to run
+	QueuedAt  time.Time  `json:"queuedAt"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+	Archived  bool       `json:"archived,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// Wait describes what a waiting execution waits for
+	Wait *ExecutionWait `json:"wait,omitempty"`
+	// Cancellation is the request that cancelled the execution
+	Cancellation *ExecutionCancellation `json:"cancellation,omitempty"`
+	// Usage is what the execution consumed of its flow's budget
+	Usage *ExecutionUsage `json:"usage,omitempty"`
+	// Steps records the outcome of each step that ran, in the order they
+	// first ran, followed by the steps a cancellation skipped
+	Steps []ExecutionStep `json:"steps,omitempty"`
+}
+
+// ExecutionStep is the outcome of one step of an execution. A step that
+// received several messages is reported once, with its total duration.
+type ExecutionStep struct {
+	ID         string                 `json:"id"`
+	Status     string                 `json:"status"`
+	Runs       int                    `json:"runs,omitempty"`
+	DurationMs int64                  `json:"durationMs,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+	// Attempts details the runs of the step that were retried, the most
+	// recent ones when there were many
+	Attempts []StepAttempt `json:"attempts,omitempty"`
+}
+
+// StepAttempt is one attempt of a step run that was retried
+type StepAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StartTime  time.Time `json:"startTime"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"errorClass,omitempty"`
+	// BackoffMs is the wait before the next attempt
+	BackoffMs int64 `json:"backoffMs,omitempty"`
+}
+
+// ExecutionCancellation is a request to cancel an execution, with the
+// optional reason and user given for it
+type ExecutionCancellation struct {
+	Reason      string    `json:"reason,omitempty"`
+	By          string    `json:"by,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// ExecutionLog is a log entry written while an execution ran, by the engine
+// or by one of its steps. Seq orders the entries of an execution.
+type ExecutionLog struct {
+	ExecutionID string                 `json:"executionId"`
+	Seq         int64                  `json:"seq"`
+	Time        time.Time              `json:"time"`
+	Level       string                 `json:"level"`
+	StepID      string                 `json:"stepId,omitempty"`
+	Message     string                 `json:"message"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ExecutionUsage is what an execution consumed, counted like its flow's
+// budget
+type ExecutionUsage struct {
+	ConnectorCalls int64 `json:"connectorCalls"`
+	EgressBytes    int64 `json:"egressBytes"`
+	DurationMs     int64 `json:"durationMs"`
+}
+
+// ExecutionWait is the step a waiting execution is suspended at. It resumes
+// when given Token, or once Deadline passes.
+type ExecutionWait struct {
+	StepID   string                 `json:"stepId"`
+	Token    string                 `json:"token"`
+	Kind     string                 `json:"kind,omitempty"`
+	Since    time.Time              `json:"since"`
+	Deadline *time.Time             `json:"deadline,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// Finished reports whether the execution reached a terminal status
+func (e *Execution) Finished() bool {
+	switch e.Status {
+	case ExecutionSucceeded, ExecutionFailed, ExecutionCancelled, ExecutionBudgetExceeded:
+		return true
+	}
+	return false
+}
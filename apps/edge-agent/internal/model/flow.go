@@ -0,0 +1,259 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Flow statuses
+const (
+	FlowStatusDraft    = "draft"
+	FlowStatusActive   = "active"
+	FlowStatusInactive = "inactive"
+)
+
+// Delivery guarantees of a flow's sinks
+const (
+	// DeliveryAtLeastOnce may repeat sink writes when a message is redelivered
+	DeliveryAtLeastOnce = "at-least-once"
+	// DeliveryExactlyOnce requires every sink to commit transactionally and
+	// skips sink writes already committed for a redelivered message
+	DeliveryExactlyOnce = "exactly-once"
+)
+
+// Flow is an integration flow definition
+type Flow struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Triggers    []Trigger `json:"triggers,omitempty"`
+	Steps       []Step    `json:"steps"`
+	Edges       []Edge    `json:"edges,omitempty"`
+	Ordering    *Ordering `json:"ordering,omitempty"`
+	Delivery    string    `json:"delivery,omitempty"`
+	// Namespace sets the defaults and limits of the flow's policy
+	Namespace string  `json:"namespace,omitempty"`
+	Policy    *Policy `json:"policy,omitempty"`
+	Budget    *Budget `json:"budget,omitempty"`
+	// InputSchema and OutputSchema are JSON Schemas the flow's input and
+	// output messages must match
+	InputSchema  map[string]interface{} `json:"inputSchema,omitempty"`
+	OutputSchema map[string]interface{} `json:"outputSchema,omitempty"`
+	// Version is the number of the version this definition is, Versions how
+	// many versions the flow has, and DraftVersion a version waiting to be
+	// published to replace the definition of the active flow
+	Version      int       `json:"version,omitempty"`
+	Versions     int       `json:"versions,omitempty"`
+	DraftVersion int       `json:"draftVersion,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Flow version statuses
+const (
+	FlowVersionDraft     = "draft"
+	FlowVersionPublished = "published"
+)
+
+// FlowVersion is an entry of a flow's version history. A draft is replaced
+// by later updates until it is published; published versions do not change.
+type FlowVersion struct {
+	FlowID      string     `json:"flowId"`
+	Version     int        `json:"version"`
+	Status      string     `json:"status"`
+	Definition  *Flow      `json:"definition"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+}
+
+// Trigger starts executions of a flow
+type Trigger struct {
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// Step is one unit of work in a flow
+type Step struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config,omitempty"`
+	// Retry replaces the retry policy of the flow for this step
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// Edge connects an output port of one step to the input of another. An
+// empty Port follows the step's default output.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Port string `json:"port,omitempty"`
+}
+
+// Ordering makes executions of messages sharing a key run strictly in
+// arrival order, while messages with different keys run in parallel. Key is
+// "headers.<name>" or a dot-path into the JSON body, optionally prefixed
+// with "body." or "$.". Messages without the key share one queue.
+type Ordering struct {
+	Key string `json:"key"`
+}
+
+// Codes of validation problems
+const (
+	ProblemRequired         = "required"
+	ProblemDuplicate        = "duplicate"
+	ProblemInvalid          = "invalid"
+	ProblemUnknownStep      = "unknown_step"
+	ProblemUnknownConnector = "unknown_connector"
+	ProblemCycle            = "cycle"
+	// ProblemNotAllowed is a setting the flow's namespace forbids
+	ProblemNotAllowed = "not_allowed"
+	// ProblemLimitExceeded is a setting above the flow's namespace limit
+	ProblemLimitExceeded = "limit_exceeded"
+)
+
+// ValidationError lists every problem found in a definition. Details
+// locates each problem, when the validator provides them.
+type ValidationError struct {
+	Problems []string  `json:"problems"`
+	Details  []Problem `json:"details,omitempty"`
+}
+
+// Problem is one problem of a definition: Path locates the offending field,
+// such as "steps[2].id", and Code classifies it
+type Problem struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed: %d problem(s), first: %s", len(e.Problems), e.Problems[0])
+}
+
+// Add records a problem of the field at path
+func (e *ValidationError) Add(path, code, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	e.Problems = append(e.Problems, msg)
+	e.Details = append(e.Details, Problem{Path: path, Code: code, Message: msg})
+}
+
+// Err returns e when it has problems, and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the flow's structural rules, including that its edges
+// connect known steps without forming a cycle
+func (f *Flow) Validate() error {
+	v := &ValidationError{}
+	if f.Name == "" {
+		v.Add("name", ProblemRequired, "name is required")
+	}
+	if len(f.Steps) == 0 {
+		v.Add("steps", ProblemRequired, "at least one step is required")
+	}
+
+	seen := make(map[string]bool, len(f.Steps))
+	for i, step := range f.Steps {
+		path := fmt.Sprintf("steps[%d]", i)
+		switch {
+		case step.ID == "":
+			v.Add(path+".id", ProblemRequired, "%s.id is required", path)
+		case seen[step.ID]:
+			v.Add(path+".id", ProblemDuplicate, "%s.id %q is duplicated", path, step.ID)
+		}
+		seen[step.ID] = true
+		if step.Type == "" {
+			v.Add(path+".type", ProblemRequired, "%s.type is required", path)
+		}
+	}
+	f.validateEdges(v, seen)
+	for i, trigger := range f.Triggers {
+		if trigger.Type == "" {
+			v.Add(fmt.Sprintf("triggers[%d].type", i), ProblemRequired, "triggers[%d].type is required", i)
+		}
+	}
+	switch f.Delivery {
+	case "", DeliveryAtLeastOnce, DeliveryExactlyOnce:
+	default:
+		v.Add("delivery", ProblemInvalid, "delivery %q must be %s or %s", f.Delivery, DeliveryAtLeastOnce, DeliveryExactlyOnce)
+	}
+	if f.Ordering != nil {
+		switch key := f.Ordering.Key; {
+		case key == "":
+			v.Add("ordering.key", ProblemRequired, "ordering.key is required")
+		case strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, ".."):
+			v.Add("ordering.key", ProblemInvalid, "ordering.key %q has an empty segment", key)
+		}
+	}
+	return v.Err()
+}
+
+// validateEdges checks that edges connect the known steps and that they
+// form no cycle, naming the steps of the first cycle found
+func (f *Flow) validateEdges(v *ValidationError, known map[string]bool) {
+	next := make(map[string][]string)
+	for i, e := range f.Edges {
+		path := fmt.Sprintf("edges[%d]", i)
+		ok := true
+		for _, end := range []struct{ field, id string }{{"from", e.From}, {"to", e.To}} {
+			switch {
+			case end.id == "":
+				v.Add(path+"."+end.field, ProblemRequired, "%s.%s is required", path, end.field)
+				ok = false
+			case !known[end.id]:
+				v.Add(path+"."+end.field, ProblemUnknownStep, "%s.%s references unknown step %q", path, end.field, end.id)
+				ok = false
+			}
+		}
+		if ok {
+			next[e.From] = append(next[e.From], e.To)
+		}
+	}
+
+	// Depth-first search, with the steps on the current path in stack
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(f.Steps))
+	var stack []string
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, to := range next[id] {
+			switch state[to] {
+			case visiting:
+				for i, onPath := range stack {
+					if onPath == to {
+						return append(append([]string(nil), stack[i:]...), to)
+					}
+				}
+			case 0:
+				if cycle := visit(to); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = done
+		return nil
+	}
+	for _, step := range f.Steps {
+		if state[step.ID] != 0 {
+			continue
+		}
+		if cycle := visit(step.ID); cycle != nil {
+			v.Add("edges", ProblemCycle, "edges form a cycle: %s", strings.Join(cycle, " -> "))
+			return
+		}
+	}
+}
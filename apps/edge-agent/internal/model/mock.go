@@ -0,0 +1,93 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Mock is a static or templated endpoint the agent serves in place of an
+// API that is not available yet, such as a partner system during flow
+// development. Path segments starting with ":" match any value, which
+// templates read as {path.<name>}.
+type Mock struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Method      string       `json:"method"`
+	Path        string       `json:"path"`
+	Response    MockResponse `json:"response"`
+	// Latency delays each response, e.g. "250ms", to simulate a slow API
+	Latency   string    `json:"latency,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// MockResponse is what a mock responds with. Header values and Body are
+// templates filled from the request; Body is a text template or a JSON
+// value whose strings are templates.
+type MockResponse struct {
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        interface{}       `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+}
+
+// Validate checks the mock's structural rules and normalises its method
+// and path
+func (m *Mock) Validate() error {
+	v := &ValidationError{}
+	if m.Name == "" {
+		v.Add("name", ProblemRequired, "name is required")
+	}
+	m.Method = strings.ToUpper(m.Method)
+	switch m.Method {
+	case "":
+		v.Add("method", ProblemRequired, "method is required")
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		v.Add("method", ProblemInvalid, "method %s is not supported", m.Method)
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		m.Path = "/" + m.Path
+	}
+	if m.Path == "" {
+		v.Add("path", ProblemRequired, "path is required")
+	}
+	seen := make(map[string]bool)
+	for _, segment := range strings.Split(strings.Trim(m.Path, "/"), "/") {
+		name, ok := strings.CutPrefix(segment, ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "":
+			v.Add("path", ProblemInvalid, "path parameters must be named")
+		case seen[name]:
+			v.Add("path", ProblemDuplicate, "path parameter %q is duplicated", name)
+		}
+		seen[name] = true
+	}
+	if s := m.Response.Status; s != 0 && (s < 200 || s > 599) {
+		v.Add("response.status", ProblemInvalid, "response.status %d is not an HTTP status code", s)
+	}
+	if m.Latency != "" {
+		if d, err := time.ParseDuration(m.Latency); err != nil || d < 0 {
+			v.Add("latency", ProblemInvalid, "latency %q is not a valid duration", m.Latency)
+		}
+	}
+	return v.Err()
+}
+
+// Route returns the method and path pattern the mock is served on, with
+// its parameter names dropped so that equivalent paths compare equal
+func (m *Mock) Route() string {
+	segments := strings.Split(strings.Trim(m.Path, "/"), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = ":"
+		}
+	}
+	return fmt.Sprintf("%s /%s", m.Method, strings.Join(segments, "/"))
+}
@@ -0,0 +1,82 @@
+package model
+
+// Capture levels of what a flow's executions expose, from least to most
+const (
+	// CaptureNone records only the status and error of executions, and
+	// the steps a cancelled execution skipped
+	CaptureNone = "none"
+	// CaptureSteps also records the outcome and metrics of every step
+	CaptureSteps = "steps"
+	// CapturePayloads also allows debug executions, which expose the
+	// messages passed between steps
+	CapturePayloads = "payloads"
+)
+
+// CaptureRank orders capture levels, returning -1 for unknown ones
+func CaptureRank(capture string) int {
+	switch capture {
+	case CaptureNone:
+		return 0
+	case CaptureSteps:
+		return 1
+	case CapturePayloads:
+		return 2
+	}
+	return -1
+}
+
+// Policy sets how a flow's executions run. Unset fields take the defaults
+// of the flow's namespace, whose limits the others cannot exceed.
+type Policy struct {
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Timeout bounds each run of an execution, such as "5m"
+	Timeout string `json:"timeout,omitempty"`
+	// Capture is none, steps or payloads
+	Capture string `json:"capture,omitempty"`
+}
+
+// RetryPolicy reruns failing steps. MaxAttempts counts the first run;
+// retries wait Backoff, such as "1s", doubling after each up to MaxBackoff.
+// Jitter, from 0 to 1, shortens each wait by a random fraction of up to
+// that much, so that executions failing together do not retry together.
+// RetryOn limits retries to failures of the listed error classes.
+type RetryPolicy struct {
+	MaxAttempts int      `json:"maxAttempts"`
+	Backoff     string   `json:"backoff,omitempty"`
+	MaxBackoff  string   `json:"maxBackoff,omitempty"`
+	Jitter      float64  `json:"jitter,omitempty"`
+	RetryOn     []string `json:"retryOn,omitempty"`
+}
+
+// Error classes of step failures, which retry policies select
+const (
+	// ErrorClassTimeout is a deadline passing, or an HTTP 408 or 504
+	ErrorClassTimeout = "timeout"
+	// ErrorClassNetwork is a failure to connect or a dropped connection
+	ErrorClassNetwork = "network"
+	// ErrorClassRateLimited is an HTTP 429
+	ErrorClassRateLimited = "rate_limited"
+	// ErrorClassServer is any other HTTP 5xx
+	ErrorClassServer = "server"
+	// ErrorClassClient is any other HTTP 4xx
+	ErrorClassClient = "client"
+	// ErrorClassOther is every other failure
+	ErrorClassOther = "other"
+)
+
+// ErrorClasses lists the error classes
+var ErrorClasses = []string{ErrorClassTimeout, ErrorClassNetwork, ErrorClassRateLimited, ErrorClassServer, ErrorClassClient, ErrorClassOther}
+
+// Budget caps what one execution of a flow may consume. An execution
+// exceeding it is stopped with the budget_exceeded status; zero fields are
+// unlimited.
+type Budget struct {
+	// MaxConnectorCalls counts requests, lookups and messages sent to
+	// external systems
+	MaxConnectorCalls int64 `json:"maxConnectorCalls,omitempty"`
+	// MaxDuration bounds the time the execution runs, such as "10m", not
+	// counting the time it waits suspended
+	MaxDuration string `json:"maxDuration,omitempty"`
+	// MaxEgressBytes counts the bytes sent to external systems
+	MaxEgressBytes int64 `json:"maxEgressBytes,omitempty"`
+}
@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+// Statuses of quarantined messages
+const (
+	QuarantinePending   = "pending"
+	QuarantineReleased  = "released"
+	QuarantineDiscarded = "discarded"
+)
+
+// Reasons messages are quarantined for, though steps may give their own
+const (
+	QuarantineDuplicate  = "duplicate"
+	QuarantineValidation = "validation"
+	QuarantineQuality    = "quality"
+)
+
+// Quarantined is a message a flow rejected as data rather than failed to
+// process, such as a duplicate or one breaking validation or quality
+// rules. It is held for review until released to carry on through the
+// flow, or discarded.
+type Quarantined struct {
+	ID          string `json:"id"`
+	FlowID      string `json:"flowId"`
+	FlowVersion int    `json:"flowVersion,omitempty"`
+	ExecutionID string `json:"executionId"`
+	// StepID is the step that held the message; a released message carries
+	// on from its default port
+	StepID string `json:"stepId"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+	Status string `json:"status"`
+	// Payload is the held message; nil when it was too large to keep, or
+	// once discarded
+	Payload *DeadLetterPayload `json:"payload,omitempty"`
+	// DebugLog is set when the execution was logged at debug level, as is
+	// the execution the message is released into
+	DebugLog bool `json:"debugLog,omitempty"`
+	// ReleaseExecutionID is the execution the message was released into
+	ReleaseExecutionID string     `json:"releaseExecutionId,omitempty"`
+	ReviewedAt         *time.Time `json:"reviewedAt,omitempty"`
+	QuarantinedAt      time.Time  `json:"quarantinedAt"`
+}
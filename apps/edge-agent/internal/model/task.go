@@ -0,0 +1,39 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WaitKindTask is the kind of the waits of approval steps
+const WaitKindTask = "task"
+
+// Task decisions
+const (
+	TaskApproved = "approved"
+	TaskRejected = "rejected"
+)
+
+// Task is a pending approval: an execution waiting at an approval step for
+// someone to approve or reject it. Its ID is the token of the wait.
+type Task struct {
+	ID          string   `json:"id"`
+	ExecutionID string   `json:"executionId"`
+	FlowID      string   `json:"flowId"`
+	StepID      string   `json:"stepId"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Assignees   []string `json:"assignees,omitempty"`
+	// Payload is the JSON message awaiting approval
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Deadline  *time.Time      `json:"deadline,omitempty"`
+}
+
+// TaskDecision is the outcome of a task, passed on by the approval step
+type TaskDecision struct {
+	Decision string    `json:"decision"`
+	By       string    `json:"by,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	At       time.Time `json:"at"`
+}
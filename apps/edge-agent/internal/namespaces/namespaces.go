@@ -0,0 +1,151 @@
+package namespaces
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// DefaultNamespace applies to flows without a namespace
+const DefaultNamespace = "default"
+
+// Guardrails holds the configured namespaces. It resolves the policy flows
+// run with and checks flows against the limits of their namespace.
+type Guardrails struct {
+	namespaces map[string]*namespace
+}
+
+// namespace is a configured namespace: its defaults are also its limits
+type namespace struct {
+	name           string
+	defaults       engine.Policy
+	connectorTypes map[string]bool
+}
+
+// New creates the guardrails of the configured namespaces
+func New(cfgs []config.NamespaceConfig) *Guardrails {
+	g := &Guardrails{namespaces: make(map[string]*namespace, len(cfgs))}
+	for _, cfg := range cfgs {
+		ns := &namespace{
+			name: cfg.Name,
+			defaults: engine.Policy{
+				Retry: engine.Retry{
+					MaxAttempts: cfg.MaxAttempts,
+					Backoff:     time.Duration(cfg.RetryBackoffMs) * time.Millisecond,
+				},
+				Timeout: time.Duration(cfg.Timeout) * time.Second,
+				Capture: cfg.Capture,
+				Egress:  cfg.Egress,
+			},
+		}
+		if len(cfg.ConnectorTypes) > 0 {
+			ns.connectorTypes = make(map[string]bool, len(cfg.ConnectorTypes))
+			for _, t := range cfg.ConnectorTypes {
+				ns.connectorTypes[t] = true
+			}
+		}
+		g.namespaces[cfg.Name] = ns
+	}
+	return g
+}
+
+// namespace returns the namespace of flow: nil for a flow without one when
+// no default namespace is configured, and an error for unknown namespaces
+func (g *Guardrails) namespace(flow *model.Flow) (*namespace, error) {
+	name := flow.Namespace
+	if name == "" {
+		return g.namespaces[DefaultNamespace], nil
+	}
+	ns, ok := g.namespaces[name]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q does not exist", name)
+	}
+	return ns, nil
+}
+
+// Policy implements engine.Policies. Settings the flow leaves unset take
+// the namespace defaults, and the others are capped at its limits, which
+// may have been lowered since the flow was saved.
+func (g *Guardrails) Policy(flow *model.Flow) (engine.Policy, error) {
+	own, err := engine.ParsePolicy(flow.Policy)
+	if err != nil {
+		return own, err
+	}
+	ns, err := g.namespace(flow)
+	if err != nil || ns == nil {
+		return own, err
+	}
+	policy := ns.defaults
+	if own.MaxAttempts > 0 && (policy.MaxAttempts == 0 || own.MaxAttempts < policy.MaxAttempts) {
+		policy.MaxAttempts = own.MaxAttempts
+	}
+	if own.Backoff > 0 {
+		policy.Backoff = own.Backoff
+	}
+	policy.MaxBackoff, policy.Jitter, policy.RetryOn = own.MaxBackoff, own.Jitter, own.RetryOn
+	if own.Timeout > 0 && (policy.Timeout == 0 || own.Timeout < policy.Timeout) {
+		policy.Timeout = own.Timeout
+	}
+	if own.Capture != "" && model.CaptureRank(own.Capture) < model.CaptureRank(policy.CaptureLevel()) {
+		policy.Capture = own.Capture
+	}
+	return policy, nil
+}
+
+// Check adds the problems of the flow's namespace and of a policy above
+// its limits to v. Invalid policies are left to engine.ParsePolicy.
+func (g *Guardrails) Check(flow *model.Flow, v *model.ValidationError) {
+	ns, err := g.namespace(flow)
+	if err != nil {
+		v.Add("namespace", model.ProblemInvalid, "%v", err)
+		return
+	}
+	own, err := engine.ParsePolicy(flow.Policy)
+	if ns == nil || err != nil {
+		return
+	}
+	limits := ns.defaults
+	if limits.MaxAttempts > 0 && own.MaxAttempts > limits.MaxAttempts {
+		v.Add("policy.retry.maxAttempts", model.ProblemLimitExceeded, "policy.retry.maxAttempts %d exceeds the limit of %d in namespace %q", own.MaxAttempts, limits.MaxAttempts, ns.name)
+	}
+	for i, step := range flow.Steps {
+		if step.Retry == nil || limits.MaxAttempts == 0 || step.Retry.MaxAttempts <= limits.MaxAttempts {
+			continue
+		}
+		field := fmt.Sprintf("steps[%d].retry.maxAttempts", i)
+		v.Add(field, model.ProblemLimitExceeded, "%s (%s) %d exceeds the limit of %d in namespace %q", field, step.ID, step.Retry.MaxAttempts, limits.MaxAttempts, ns.name)
+	}
+	if limits.Timeout > 0 && own.Timeout > limits.Timeout {
+		v.Add("policy.timeout", model.ProblemLimitExceeded, "policy.timeout %s exceeds the limit of %s in namespace %q", own.Timeout, limits.Timeout, ns.name)
+	}
+	if own.Capture != "" && model.CaptureRank(own.Capture) > model.CaptureRank(limits.CaptureLevel()) {
+		v.Add("policy.capture", model.ProblemLimitExceeded, "policy.capture %s exceeds the limit of %s in namespace %q", own.Capture, limits.CaptureLevel(), ns.name)
+	}
+}
+
+// CheckConnector returns why flow may not use conn: its type is not allowed
+// in the flow's namespace, or it connects to a host outside the egress
+// allowlist
+func (g *Guardrails) CheckConnector(flow *model.Flow, conn *model.Connector) error {
+	ns, err := g.namespace(flow)
+	if err != nil || ns == nil {
+		return err
+	}
+	if ns.connectorTypes != nil && !ns.connectorTypes[conn.Type] {
+		return fmt.Errorf("connector type %q is not allowed in namespace %q", conn.Type, ns.name)
+	}
+	rules, err := egress.Parse(ns.defaults.Egress)
+	if err != nil {
+		return err
+	}
+	for _, addr := range egress.Hosts(conn) {
+		if !rules.Allows(addr.Host, addr.Port) {
+			return fmt.Errorf("connector host %q is not in the egress allowlist of namespace %q", addr.Host, ns.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package netlimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/throttle"
+)
+
+// Link is the agent's WAN uplink, such as a cellular or satellite link. Its
+// upload budget is one token bucket shared by every upload over it: control
+// plane traffic and writes to connectors marked as WAN. Downloads are left
+// to the connectors' own caps.
+//
+// Link implements engine.Bandwidth on top of the connector caps, and
+// connectors.ChangeHook to follow which connectors are WAN.
+type Link struct {
+	upload *throttle.Limiter
+	next   engine.Bandwidth
+
+	mu  sync.RWMutex
+	wan map[string]bool
+}
+
+// NewLink creates the uplink of cfg, adding its budget to the caps of next
+// for the WAN connectors of list. A link without a rate lets everything
+// through.
+func NewLink(cfg config.UplinkConfig, next engine.Bandwidth, list []*model.Connector) *Link {
+	l := &Link{
+		upload: throttle.NewLimiter(cfg.BytesPerSecond, cfg.Burst),
+		next:   next,
+		wan:    make(map[string]bool),
+	}
+	for _, conn := range list {
+		l.ConnectorSaved(conn)
+	}
+	return l
+}
+
+// SetRate changes the upload budget, taking effect for the next transfer
+func (l *Link) SetRate(cfg config.UplinkConfig) {
+	l.upload.SetRate(cfg.BytesPerSecond, cfg.Burst)
+}
+
+// ConnectorSaved follows whether the connector is WAN
+func (l *Link) ConnectorSaved(conn *model.Connector) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if conn.WAN {
+		l.wan[conn.ID] = true
+	} else {
+		delete(l.wan, conn.ID)
+	}
+}
+
+// ConnectorDeleted forgets the connector
+func (l *Link) ConnectorDeleted(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.wan, id)
+}
+
+// isWAN reports whether the connector is marked as WAN
+func (l *Link) isWAN(connectorID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.wan[connectorID]
+}
+
+// Reader limits r to the connector's read cap
+func (l *Link) Reader(ctx context.Context, connectorID string, r io.Reader) io.Reader {
+	return l.next.Reader(ctx, connectorID, r)
+}
+
+// Writer limits w to the connector's write cap and, for WAN connectors, to
+// the upload budget
+func (l *Link) Writer(ctx context.Context, connectorID string, w io.Writer) io.Writer {
+	w = l.next.Writer(ctx, connectorID, w)
+	if !l.isWAN(connectorID) {
+		return w
+	}
+	return throttle.Writer(ctx, w, l.upload)
+}
+
+// WaitN blocks until n bytes may be uploaded, for uploads that send whole
+// messages rather than streams
+func (l *Link) WaitN(ctx context.Context, n int) error {
+	return l.upload.WaitN(ctx, n)
+}
+
+// Transport limits the request bodies sent through base, or through
+// http.DefaultTransport when base is nil, to the upload budget
+func (l *Link) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{link: l, base: base}
+}
+
+type transport struct {
+	link *Link
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		// Read when used, so that a transport set up later, such as the
+		// relay hub's, applies
+		base = http.DefaultTransport
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return base.RoundTrip(req)
+	}
+	limited := req.Clone(req.Context())
+	limited.Body = &body{Reader: throttle.Reader(req.Context(), req.Body, t.link.upload), Closer: req.Body}
+	return base.RoundTrip(limited)
+}
+
+// body is a throttled request body closing the original one
+type body struct {
+	io.Reader
+	io.Closer
+}
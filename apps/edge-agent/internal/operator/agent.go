@@ -0,0 +1,197 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/triggers"
+)
+
+// APIError is an error response of the agent API
+type APIError struct {
+	StatusCode int
+	Message    string
+	Problems   []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Problems) > 0 {
+		return fmt.Sprintf("%s: %s", e.Message, strings.Join(e.Problems, "; "))
+	}
+	return e.Message
+}
+
+// invalid reports whether err is the agent rejecting a definition, which
+// retrying will not fix
+func invalid(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+// Agent is a client of the edge agent API
+type Agent struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewAgent creates a client of the agent at baseURL, e.g.
+// http://edge-agent:8080
+func NewAgent(baseURL string) *Agent {
+	return &Agent{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetFlow returns a flow, or an error wrapping errNotFound
+func (a *Agent) GetFlow(ctx context.Context, id string) (*model.Flow, error) {
+	var flow model.Flow
+	if err := a.do(ctx, http.MethodGet, "/flows/"+url.PathEscape(id), nil, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// ApplyFlow stores the flow and activates it, re-registering its triggers
+func (a *Agent) ApplyFlow(ctx context.Context, flow *model.Flow) error {
+	return a.do(ctx, http.MethodPost, "/flows/apply", flow, nil)
+}
+
+// PutFlow stores the flow without changing its status, creating it as a
+// draft when missing
+func (a *Agent) PutFlow(ctx context.Context, flow *model.Flow) error {
+	return a.do(ctx, http.MethodPut, "/flows/"+url.PathEscape(flow.ID)+"?upsert=true", flow, nil)
+}
+
+// DeactivateFlow stops the flow's triggers
+func (a *Agent) DeactivateFlow(ctx context.Context, id string) error {
+	return a.do(ctx, http.MethodPost, "/flows/"+url.PathEscape(id)+"/deactivate", nil, nil)
+}
+
+// DeleteFlow removes a flow; a missing flow is not an error
+func (a *Agent) DeleteFlow(ctx context.Context, id string) error {
+	err := a.do(ctx, http.MethodDelete, "/flows/"+url.PathEscape(id), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+// FlowTriggers returns the status of a flow's running triggers
+func (a *Agent) FlowTriggers(ctx context.Context, id string) ([]triggers.Status, error) {
+	var resp struct {
+		Triggers []triggers.Status `json:"triggers"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/flows/"+url.PathEscape(id)+"/triggers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Triggers, nil
+}
+
+// PutConnector stores the connector, creating it when missing
+func (a *Agent) PutConnector(ctx context.Context, conn *model.Connector) error {
+	return a.do(ctx, http.MethodPut, "/connectors/"+url.PathEscape(conn.ID)+"?upsert=true", conn, nil)
+}
+
+// GetConnector returns a connector, or an error wrapping errNotFound
+func (a *Agent) GetConnector(ctx context.Context, id string) (*model.Connector, error) {
+	var conn model.Connector
+	if err := a.do(ctx, http.MethodGet, "/connectors/"+url.PathEscape(id), nil, &conn); err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// DeleteConnector removes a connector; a missing connector is not an error
+func (a *Agent) DeleteConnector(ctx context.Context, id string) error {
+	err := a.do(ctx, http.MethodDelete, "/connectors/"+url.PathEscape(id), nil, nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+// TestResult is the outcome of a connection test
+type TestResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// TestConnector tests the connection of a connector
+func (a *Agent) TestConnector(ctx context.Context, id string) (*TestResult, error) {
+	var result TestResult
+	if err := a.do(ctx, http.MethodPost, "/connectors/"+url.PathEscape(id)+"/test", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// do sends a request and decodes the JSON response into out
+func (a *Agent) do(ctx context.Context, method, p string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+p, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		// Validation failures are RFC 7807 problems, other errors carry
+		// an error message
+		var errResp struct {
+			Error  string `json:"error"`
+			Title  string `json:"title"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error == "" {
+			errResp.Error = errResp.Title
+		}
+		if errResp.Error == "" {
+			errResp.Error = strings.TrimSpace(string(data))
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", errNotFound, errResp.Error)
+		}
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+		for _, e := range errResp.Errors {
+			apiErr.Problems = append(apiErr.Problems, e.Message)
+		}
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode agent response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package operator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// Service account files mounted into pods
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// ErrGone is returned by Watch when the resource version is too old to
+// resume from, so the caller must list again
+var ErrGone = errors.New("resource version expired")
+
+// errNotFound is returned for requests on objects that do not exist
+var errNotFound = errors.New("object not found")
+
+// Resource identifies a custom resource type
+type Resource struct {
+	Group   string
+	Version string
+	Plural  string
+}
+
+// Kube is a minimal client of the Kubernetes API covering what the
+// operator needs: listing and watching custom resources, patching their
+// metadata and writing their status
+type Kube struct {
+	server string
+	// token is read from tokenPath on each request when set, as projected
+	// service account tokens are rotated
+	token     string
+	tokenPath string
+	client    *http.Client
+}
+
+// NewKube creates a client of the API server at server, authenticating with
+// a bearer token when token is not empty, e.g. through kubectl proxy
+func NewKube(server, token string) *Kube {
+	return &Kube{server: server, token: token, client: &http.Client{}}
+}
+
+// InCluster creates a client from the service account of the pod
+func InCluster() (*Kube, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster: KUBERNETES_SERVICE_HOST is not set")
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse cluster CA")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &Kube{
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenPath: tokenFile,
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// PodNamespace returns the namespace of the pod, or "" outside a cluster
+func PodNamespace() string {
+	data, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(data))
+}
+
+// ObjectMeta is the metadata of a custom resource
+type ObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
+	DeletionTimestamp *time.Time        `json:"deletionTimestamp,omitempty"`
+	Finalizers        []string          `json:"finalizers,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+}
+
+// Object is a custom resource with its spec left encoded
+type Object struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   ObjectMeta      `json:"metadata"`
+	Spec       json.RawMessage `json:"spec,omitempty"`
+	Status     *Status         `json:"status,omitempty"`
+}
+
+// Event is a change reported by a watch
+type Event struct {
+	Type   string
+	Object Object
+}
+
+// Watch event types
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+	EventBookmark = "BOOKMARK"
+	EventError    = "ERROR"
+)
+
+// List returns the resources in namespace, or in all namespaces when it is
+// empty, and the resource version to watch from
+func (k *Kube) List(ctx context.Context, res Resource, namespace string) ([]Object, string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []Object `json:"items"`
+	}
+	if err := k.do(ctx, http.MethodGet, k.path(res, namespace, ""), nil, "", nil, &list); err != nil {
+		return nil, "", fmt.Errorf("failed to list %s: %w", res.Plural, err)
+	}
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+// Watch streams changes to the resources from resourceVersion until ctx ends
+// or the server closes the watch, calling fn for each
+func (k *Kube) Watch(ctx context.Context, res Resource, namespace, resourceVersion string, fn func(Event)) error {
+	query := url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+	}
+	resp, err := k.request(ctx, http.MethodGet, k.path(res, namespace, ""), query, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", res.Plural, err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var raw struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read %s watch: %w", res.Plural, err)
+		}
+		if raw.Type == EventError {
+			// The object of an error event is a Status; 410 means the
+			// version has been compacted away
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(raw.Object, &status)
+			if status.Code == http.StatusGone {
+				return ErrGone
+			}
+			return fmt.Errorf("%s watch failed: %s", res.Plural, status.Message)
+		}
+		ev := Event{Type: raw.Type}
+		if err := json.Unmarshal(raw.Object, &ev.Object); err != nil {
+			return fmt.Errorf("failed to decode %s watch event: %w", res.Plural, err)
+		}
+		fn(ev)
+	}
+}
+
+// SetFinalizers replaces the finalizers of a resource
+func (k *Kube) SetFinalizers(ctx context.Context, res Resource, meta ObjectMeta, finalizers []string) error {
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": meta.ResourceVersion,
+		},
+	}
+	err := k.do(ctx, http.MethodPatch, k.path(res, meta.Namespace, meta.Name), nil, "application/merge-patch+json", patch, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update finalizers of %s/%s: %w", meta.Namespace, meta.Name, err)
+	}
+	return nil
+}
+
+// UpdateStatus replaces the status of a resource through its status
+// subresource
+func (k *Kube) UpdateStatus(ctx context.Context, res Resource, meta ObjectMeta, status *Status) error {
+	patch := map[string]interface{}{"status": status}
+	err := k.do(ctx, http.MethodPatch, k.path(res, meta.Namespace, meta.Name)+"/status", nil, "application/merge-patch+json", patch, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update status of %s/%s: %w", meta.Namespace, meta.Name, err)
+	}
+	return nil
+}
+
+// path returns the API path of a resource collection or object
+func (k *Kube) path(res Resource, namespace, name string) string {
+	p := path.Join("/apis", res.Group, res.Version)
+	if namespace != "" {
+		p = path.Join(p, "namespaces", namespace)
+	}
+	p = path.Join(p, res.Plural)
+	if name != "" {
+		p = path.Join(p, name)
+	}
+	return p
+}
+
+// do sends a request and decodes the JSON response into out
+func (k *Kube) do(ctx context.Context, method, p string, query url.Values, contentType string, body, out interface{}) error {
+	resp, err := k.request(ctx, method, p, query, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// request sends a request, turning error statuses into errors
+func (k *Kube) request(ctx context.Context, method, p string, query url.Values, contentType string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	u := k.server + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token := k.token
+	if k.tokenPath != "" {
+		data, err := os.ReadFile(k.tokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		token = string(bytes.TrimSpace(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = string(bytes.TrimSpace(data))
+		}
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("%w: %s", errNotFound, status.Message)
+		case http.StatusGone:
+			return nil, fmt.Errorf("%w: %s", ErrGone, status.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", method, p, resp.Status, status.Message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,223 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backoff bounds for retrying a failed reconcile and for re-establishing a
+// failed watch
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+	relistWait = 5 * time.Second
+)
+
+// Options configures the operator
+type Options struct {
+	// Namespace limits the operator to one namespace; empty watches all
+	Namespace string
+	// Resync is how often every resource is reconciled again, refreshing
+	// health conditions and restoring definitions missing from the agent
+	Resync time.Duration
+}
+
+// Operator reconciles Flow and Connector resources into agent API calls.
+// Resources are cached from list and watch calls and reconciled one at a
+// time, so the agent never sees concurrent changes from the operator.
+type Operator struct {
+	kube   *Kube
+	agent  *Agent
+	logger *logrus.Logger
+	opts   Options
+	queue  *queue
+
+	mu      sync.Mutex
+	objects map[key]*Object
+
+	// failures counts consecutive failed reconciles per resource; it is only
+	// used by the worker
+	failures map[key]int
+}
+
+// New creates an operator
+func New(kube *Kube, agent *Agent, logger *logrus.Logger, opts Options) *Operator {
+	if opts.Resync <= 0 {
+		opts.Resync = 30 * time.Second
+	}
+	return &Operator{
+		kube:     kube,
+		agent:    agent,
+		logger:   logger,
+		opts:     opts,
+		queue:    newQueue(),
+		objects:  make(map[key]*Object),
+		failures: make(map[key]int),
+	}
+}
+
+// Run watches the resources and reconciles them until ctx ends
+func (o *Operator) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, res := range []Resource{ConnectorResource, FlowResource} {
+		wg.Add(1)
+		go func(res Resource) {
+			defer wg.Done()
+			o.watch(ctx, res)
+		}(res)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		o.resync(ctx)
+	}()
+
+	o.logger.Infof("Operator started (namespace %q, resync %s)", o.opts.Namespace, o.opts.Resync)
+	for {
+		k, ok := o.queue.get(ctx)
+		if !ok {
+			break
+		}
+		o.process(ctx, k)
+	}
+	wg.Wait()
+	return nil
+}
+
+// watch keeps the cache of one resource type current, listing it and then
+// following changes, and listing again whenever the watch cannot resume
+func (o *Operator) watch(ctx context.Context, res Resource) {
+	for ctx.Err() == nil {
+		items, version, err := o.kube.List(ctx, res, o.opts.Namespace)
+		if err != nil {
+			o.logger.Errorf("Failed to list %s: %v", res.Plural, err)
+			sleep(ctx, relistWait)
+			continue
+		}
+		o.replace(res, items)
+
+		for ctx.Err() == nil {
+			err = o.kube.Watch(ctx, res, o.opts.Namespace, version, func(ev Event) {
+				version = ev.Object.Metadata.ResourceVersion
+				o.observe(res, ev)
+			})
+			if err != nil {
+				break
+			}
+		}
+		if ctx.Err() == nil && !errors.Is(err, ErrGone) {
+			o.logger.Warnf("Watch of %s failed, relisting: %v", res.Plural, err)
+			sleep(ctx, relistWait)
+		}
+	}
+}
+
+// replace sets the cached resources of a type to a fresh list, queueing
+// each of them
+func (o *Operator) replace(res Resource, items []Object) {
+	o.mu.Lock()
+	for k := range o.objects {
+		if k.res == res {
+			delete(o.objects, k)
+		}
+	}
+	keys := make([]key, 0, len(items))
+	for i := range items {
+		obj := &items[i]
+		k := key{res: res, namespace: obj.Metadata.Namespace, name: obj.Metadata.Name}
+		o.objects[k] = obj
+		keys = append(keys, k)
+	}
+	o.mu.Unlock()
+
+	for _, k := range keys {
+		o.queue.add(k)
+	}
+}
+
+// observe applies a watch event to the cache
+func (o *Operator) observe(res Resource, ev Event) {
+	obj := ev.Object
+	k := key{res: res, namespace: obj.Metadata.Namespace, name: obj.Metadata.Name}
+	switch ev.Type {
+	case EventAdded, EventModified:
+		o.mu.Lock()
+		o.objects[k] = &obj
+		o.mu.Unlock()
+		o.queue.add(k)
+	case EventDeleted:
+		// The finalizer kept the resource until it was removed from the
+		// agent, so there is nothing left to do
+		o.mu.Lock()
+		delete(o.objects, k)
+		o.mu.Unlock()
+	}
+}
+
+// resync periodically queues every cached resource
+func (o *Operator) resync(ctx context.Context) {
+	ticker := time.NewTicker(o.opts.Resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		o.mu.Lock()
+		keys := make([]key, 0, len(o.objects))
+		for k := range o.objects {
+			keys = append(keys, k)
+		}
+		o.mu.Unlock()
+		for _, k := range keys {
+			o.queue.add(k)
+		}
+	}
+}
+
+// process reconciles one resource, retrying with backoff on failure
+func (o *Operator) process(ctx context.Context, k key) {
+	o.mu.Lock()
+	obj, ok := o.objects[k]
+	o.mu.Unlock()
+	if !ok {
+		delete(o.failures, k)
+		return
+	}
+
+	var err error
+	switch k.res {
+	case FlowResource:
+		err = o.reconcileFlow(ctx, obj)
+	case ConnectorResource:
+		err = o.reconcileConnector(ctx, obj)
+	}
+	if err == nil || ctx.Err() != nil {
+		delete(o.failures, k)
+		return
+	}
+
+	n := o.failures[k]
+	o.failures[k] = n + 1
+	delay := maxBackoff
+	if n < 16 {
+		delay = min(minBackoff<<n, maxBackoff)
+	}
+	o.logger.Warnf("Failed to reconcile %s, retrying in %s: %v", k, delay, err)
+	o.queue.addAfter(k, delay)
+}
+
+// sleep waits for d or until ctx ends
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,73 @@
+package operator
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// key identifies a resource to reconcile
+type key struct {
+	res       Resource
+	namespace string
+	name      string
+}
+
+func (k key) String() string {
+	return k.res.Plural + "/" + k.namespace + "/" + k.name
+}
+
+// queue holds the resources waiting to be reconciled, in the order they
+// changed. A resource is queued at most once however often it changes
+// before its turn.
+type queue struct {
+	mu      sync.Mutex
+	pending map[key]bool
+	order   []key
+	ready   chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{pending: make(map[key]bool), ready: make(chan struct{}, 1)}
+}
+
+// add queues k unless it is already waiting
+func (q *queue) add(k key) {
+	q.mu.Lock()
+	if !q.pending[k] {
+		q.pending[k] = true
+		q.order = append(q.order, k)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// addAfter queues k once delay has passed
+func (q *queue) addAfter(k key, delay time.Duration) {
+	time.AfterFunc(delay, func() { q.add(k) })
+}
+
+// get waits for the next resource, returning false once ctx ends
+func (q *queue) get(ctx context.Context) (key, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.order) > 0 {
+			k := q.order[0]
+			q.order = q.order[1:]
+			delete(q.pending, k)
+			q.mu.Unlock()
+			return k, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return key{}, false
+		}
+	}
+}
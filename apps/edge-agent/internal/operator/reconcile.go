@@ -0,0 +1,327 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/triggers"
+)
+
+// Condition reasons
+const (
+	ReasonApplied          = "Applied"
+	ReasonInvalidSpec      = "InvalidSpec"
+	ReasonRejected         = "Rejected"
+	ReasonAgentUnavailable = "AgentUnavailable"
+	ReasonActive           = "Active"
+	ReasonInactive         = "Inactive"
+	ReasonNoTriggers       = "NoTriggers"
+	ReasonTriggersHealthy  = "TriggersHealthy"
+	ReasonTriggerDegraded  = "TriggerDegraded"
+	ReasonTriggerDown      = "TriggerDown"
+	ReasonPaused           = "Paused"
+	ReasonConnected        = "Connected"
+	ReasonConnectionFailed = "ConnectionFailed"
+	ReasonUntestable       = "Untestable"
+	ReasonUnknown          = "Unknown"
+)
+
+// reconcileFlow brings the agent in line with a Flow resource and reports
+// the outcome in its status. A definition is only sent to the agent when
+// the spec changed or the agent lost it, as applying an active flow
+// re-registers its triggers.
+func (o *Operator) reconcileFlow(ctx context.Context, obj *Object) error {
+	meta := obj.Metadata
+	id := agentID("flow", meta.Namespace, meta.Name)
+	if meta.DeletionTimestamp != nil {
+		return o.finalize(ctx, FlowResource, meta, func() error { return o.agent.DeleteFlow(ctx, id) })
+	}
+	if err := o.ensureFinalizer(ctx, FlowResource, meta); err != nil {
+		return err
+	}
+
+	status := newStatus(obj, id)
+	var spec FlowSpec
+	if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+		o.setCondition(status, meta, ConditionSynced, ConditionFalse, ReasonInvalidSpec, err.Error())
+		return o.writeStatus(ctx, FlowResource, obj, status)
+	}
+	flow := flowFromSpec(&spec, id, meta)
+	active := spec.Active == nil || *spec.Active
+
+	current, err := o.agent.GetFlow(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return o.unavailable(ctx, FlowResource, obj, status, err)
+	}
+	changed := current == nil || status.ObservedGeneration != meta.Generation
+	if changed && rejected(status, meta.Generation) {
+		// Wait for the spec to be fixed rather than resubmitting it
+		return o.writeStatus(ctx, FlowResource, obj, status)
+	}
+	wasActive := current != nil && current.Status == model.FlowStatusActive
+
+	if changed || active != wasActive {
+		switch {
+		case active:
+			err = o.agent.ApplyFlow(ctx, flow)
+		case changed:
+			err = o.agent.PutFlow(ctx, flow)
+		}
+		if err == nil && !active && wasActive {
+			err = o.agent.DeactivateFlow(ctx, id)
+		}
+		if err != nil {
+			return o.applyFailed(ctx, FlowResource, obj, status, err)
+		}
+		o.logger.Infof("Applied flow %s/%s to the agent as %s (active: %t)", meta.Namespace, meta.Name, id, active)
+		if current, err = o.agent.GetFlow(ctx, id); err != nil {
+			return o.unavailable(ctx, FlowResource, obj, status, err)
+		}
+	}
+	status.ObservedGeneration = meta.Generation
+	status.FlowStatus = current.Status
+	o.setCondition(status, meta, ConditionSynced, ConditionTrue, ReasonApplied, "Flow is in sync with the agent")
+
+	if current.Status != model.FlowStatusActive {
+		o.setCondition(status, meta, ConditionActive, ConditionFalse, ReasonInactive, "Flow is "+current.Status)
+		o.setCondition(status, meta, ConditionHealthy, ConditionUnknown, ReasonInactive, "Flow is not active")
+		return o.writeStatus(ctx, FlowResource, obj, status)
+	}
+	o.setCondition(status, meta, ConditionActive, ConditionTrue, ReasonActive, "Flow triggers are registered")
+	statuses, err := o.agent.FlowTriggers(ctx, id)
+	if err != nil {
+		return o.unavailable(ctx, FlowResource, obj, status, err)
+	}
+	healthy, reason, message := triggerHealth(statuses)
+	o.setCondition(status, meta, ConditionHealthy, healthy, reason, message)
+	return o.writeStatus(ctx, FlowResource, obj, status)
+}
+
+// flowFromSpec builds the agent definition of a Flow resource, resolving
+// connectorRef step settings to the agent IDs of the named Connector
+// resources
+func flowFromSpec(spec *FlowSpec, id string, meta ObjectMeta) *model.Flow {
+	flow := &model.Flow{
+		ID:          id,
+		Name:        spec.Name,
+		Description: spec.Description,
+		Tenant:      spec.Tenant,
+		Triggers:    spec.Triggers,
+		Steps:       spec.Steps,
+		Edges:       spec.Edges,
+		Ordering:    spec.Ordering,
+		Delivery:    spec.Delivery,
+		Namespace:   spec.Namespace,
+		Policy:      spec.Policy,
+		Budget:      spec.Budget,
+	}
+	if flow.Name == "" {
+		flow.Name = meta.Name
+	}
+	for _, step := range flow.Steps {
+		if ref, ok := step.Config["connectorRef"].(string); ok {
+			delete(step.Config, "connectorRef")
+			step.Config["connector"] = agentID("conn", meta.Namespace, ref)
+		}
+	}
+	return flow
+}
+
+// triggerHealth summarises the health of a flow's triggers as a condition
+func triggerHealth(statuses []triggers.Status) (status, reason, message string) {
+	if len(statuses) == 0 {
+		return ConditionTrue, ReasonNoTriggers, "Flow has no running triggers"
+	}
+	var down, degraded, paused []string
+	for _, s := range statuses {
+		name := fmt.Sprintf("%s trigger %d", s.Type, s.Index)
+		detail := name
+		if s.Health.Detail != "" {
+			detail += ": " + s.Health.Detail
+		}
+		switch {
+		case s.State == triggers.StatePaused:
+			paused = append(paused, name)
+		case s.Health.Status == triggers.HealthDown:
+			down = append(down, detail)
+		case s.Health.Status == triggers.HealthDegraded:
+			degraded = append(degraded, detail)
+		}
+	}
+	switch {
+	case len(down) > 0:
+		return ConditionFalse, ReasonTriggerDown, strings.Join(append(down, degraded...), "; ")
+	case len(degraded) > 0:
+		return ConditionFalse, ReasonTriggerDegraded, strings.Join(degraded, "; ")
+	case len(paused) > 0:
+		return ConditionUnknown, ReasonPaused, "Paused: " + strings.Join(paused, ", ")
+	}
+	return ConditionTrue, ReasonTriggersHealthy, fmt.Sprintf("%d triggers healthy", len(statuses))
+}
+
+// reconcileConnector brings the agent in line with a Connector resource and
+// reports the result of a connection test in its status
+func (o *Operator) reconcileConnector(ctx context.Context, obj *Object) error {
+	meta := obj.Metadata
+	id := agentID("conn", meta.Namespace, meta.Name)
+	if meta.DeletionTimestamp != nil {
+		return o.finalize(ctx, ConnectorResource, meta, func() error { return o.agent.DeleteConnector(ctx, id) })
+	}
+	if err := o.ensureFinalizer(ctx, ConnectorResource, meta); err != nil {
+		return err
+	}
+
+	status := newStatus(obj, id)
+	var spec ConnectorSpec
+	if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+		o.setCondition(status, meta, ConditionSynced, ConditionFalse, ReasonInvalidSpec, err.Error())
+		return o.writeStatus(ctx, ConnectorResource, obj, status)
+	}
+	conn := &model.Connector{
+		ID:          id,
+		Name:        spec.Name,
+		Type:        spec.Type,
+		Description: spec.Description,
+		Config:      spec.Config,
+		Auth:        spec.Auth,
+		Operations:  spec.Operations,
+		Bandwidth:   spec.Bandwidth,
+	}
+	if conn.Name == "" {
+		conn.Name = meta.Name
+	}
+
+	_, err := o.agent.GetConnector(ctx, id)
+	missing := errors.Is(err, errNotFound)
+	if err != nil && !missing {
+		return o.unavailable(ctx, ConnectorResource, obj, status, err)
+	}
+	if missing || status.ObservedGeneration != meta.Generation {
+		if rejected(status, meta.Generation) {
+			return o.writeStatus(ctx, ConnectorResource, obj, status)
+		}
+		if err := o.agent.PutConnector(ctx, conn); err != nil {
+			return o.applyFailed(ctx, ConnectorResource, obj, status, err)
+		}
+		o.logger.Infof("Applied connector %s/%s to the agent as %s", meta.Namespace, meta.Name, id)
+	}
+	status.ObservedGeneration = meta.Generation
+	o.setCondition(status, meta, ConditionSynced, ConditionTrue, ReasonApplied, "Connector is in sync with the agent")
+
+	result, err := o.agent.TestConnector(ctx, id)
+	switch {
+	case invalid(err):
+		// The agent has no implementation of the type to test it with
+		o.setCondition(status, meta, ConditionHealthy, ConditionUnknown, ReasonUntestable, err.Error())
+	case err != nil:
+		return o.unavailable(ctx, ConnectorResource, obj, status, err)
+	case result.Success:
+		o.setCondition(status, meta, ConditionHealthy, ConditionTrue, ReasonConnected, result.Message)
+	default:
+		o.setCondition(status, meta, ConditionHealthy, ConditionFalse, ReasonConnectionFailed, result.Message)
+	}
+	return o.writeStatus(ctx, ConnectorResource, obj, status)
+}
+
+// finalize removes a deleted resource from the agent and then releases it
+func (o *Operator) finalize(ctx context.Context, res Resource, meta ObjectMeta, remove func() error) error {
+	if !slices.Contains(meta.Finalizers, Finalizer) {
+		return nil
+	}
+	if err := remove(); err != nil {
+		return fmt.Errorf("failed to remove %s/%s from the agent: %w", meta.Namespace, meta.Name, err)
+	}
+	finalizers := slices.DeleteFunc(slices.Clone(meta.Finalizers), func(f string) bool { return f == Finalizer })
+	if err := o.kube.SetFinalizers(ctx, res, meta, finalizers); err != nil {
+		return err
+	}
+	o.logger.Infof("Removed %s %s/%s from the agent", res.Plural, meta.Namespace, meta.Name)
+	return nil
+}
+
+// ensureFinalizer adds the operator's finalizer, so that deleting the
+// resource waits for it to be removed from the agent
+func (o *Operator) ensureFinalizer(ctx context.Context, res Resource, meta ObjectMeta) error {
+	if slices.Contains(meta.Finalizers, Finalizer) {
+		return nil
+	}
+	return o.kube.SetFinalizers(ctx, res, meta, append(slices.Clone(meta.Finalizers), Finalizer))
+}
+
+// newStatus starts the new status of a resource from its current one
+func newStatus(obj *Object, id string) *Status {
+	status := &Status{}
+	if obj.Status != nil {
+		status.ObservedGeneration = obj.Status.ObservedGeneration
+		status.FlowStatus = obj.Status.FlowStatus
+		status.Conditions = slices.Clone(obj.Status.Conditions)
+	}
+	status.AgentID = id
+	return status
+}
+
+// setCondition sets a condition for the resource's current generation
+func (o *Operator) setCondition(status *Status, meta ObjectMeta, typ, value, reason, message string) {
+	status.Conditions = setCondition(status.Conditions, Condition{
+		Type:               typ,
+		Status:             value,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: meta.Generation,
+	}, time.Now().UTC().Truncate(time.Second))
+}
+
+// rejected reports whether the agent already rejected this generation
+func rejected(status *Status, generation int64) bool {
+	for _, c := range status.Conditions {
+		if c.Type == ConditionSynced {
+			return c.Reason == ReasonRejected && c.ObservedGeneration == generation
+		}
+	}
+	return false
+}
+
+// applyFailed records a failed apply. A definition rejected by the agent is
+// not retried until the spec changes; other failures are.
+func (o *Operator) applyFailed(ctx context.Context, res Resource, obj *Object, status *Status, err error) error {
+	if !invalid(err) {
+		return o.unavailable(ctx, res, obj, status, err)
+	}
+	o.logger.Warnf("Agent rejected %s %s/%s: %v", res.Plural, obj.Metadata.Namespace, obj.Metadata.Name, err)
+	o.setCondition(status, obj.Metadata, ConditionSynced, ConditionFalse, ReasonRejected, err.Error())
+	return o.writeStatus(ctx, res, obj, status)
+}
+
+// unavailable records that the agent could not be reached or failed, and
+// returns err so that the resource is retried
+func (o *Operator) unavailable(ctx context.Context, res Resource, obj *Object, status *Status, err error) error {
+	o.setCondition(status, obj.Metadata, ConditionSynced, ConditionFalse, ReasonAgentUnavailable, err.Error())
+	for _, typ := range []string{ConditionActive, ConditionHealthy} {
+		for _, c := range status.Conditions {
+			if c.Type == typ {
+				o.setCondition(status, obj.Metadata, typ, ConditionUnknown, ReasonUnknown, "Agent state is unknown")
+			}
+		}
+	}
+	if writeErr := o.writeStatus(ctx, res, obj, status); writeErr != nil {
+		o.logger.Warnf("Failed to record agent failure: %v", writeErr)
+	}
+	return err
+}
+
+// writeStatus updates the status of a resource when it has changed. Status
+// writes produce watch events, so skipping unchanged ones keeps the
+// operator from reconciling in a loop.
+func (o *Operator) writeStatus(ctx context.Context, res Resource, obj *Object, status *Status) error {
+	if obj.Status != nil && reflect.DeepEqual(*obj.Status, *status) {
+		return nil
+	}
+	return o.kube.UpdateStatus(ctx, res, obj.Metadata, status)
+}
@@ -0,0 +1,122 @@
+package operator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// API group and version of the custom resources
+const (
+	Group   = "fusionflow.io"
+	Version = "v1alpha1"
+)
+
+var (
+	// FlowResource is the Flow custom resource
+	FlowResource = Resource{Group: Group, Version: Version, Plural: "flows"}
+	// ConnectorResource is the Connector custom resource
+	ConnectorResource = Resource{Group: Group, Version: Version, Plural: "connectors"}
+)
+
+// Finalizer holds a deleted resource until it has been removed from the agent
+const Finalizer = Group + "/agent-cleanup"
+
+// FlowSpec is the desired state of a Flow resource: a flow definition and
+// whether it runs. Name defaults to the resource name and Active to true.
+// A step config may set connectorRef to the name of a Connector resource in
+// the same namespace in place of its agent ID.
+type FlowSpec struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Tenant      string          `json:"tenant,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Triggers    []model.Trigger `json:"triggers,omitempty"`
+	Steps       []model.Step    `json:"steps"`
+	Edges       []model.Edge    `json:"edges,omitempty"`
+	Ordering    *model.Ordering `json:"ordering,omitempty"`
+	Delivery    string          `json:"delivery,omitempty"`
+	Namespace   string          `json:"namespace,omitempty"`
+	Policy      *model.Policy   `json:"policy,omitempty"`
+	Budget      *model.Budget   `json:"budget,omitempty"`
+}
+
+// ConnectorSpec is the desired state of a Connector resource. Name defaults
+// to the resource name.
+type ConnectorSpec struct {
+	Name        string                 `json:"name,omitempty"`
+	Type        string                 `json:"type"`
+	Description string                 `json:"description,omitempty"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	Auth        *model.ConnectorAuth   `json:"auth,omitempty"`
+	Operations  []model.Operation      `json:"operations,omitempty"`
+	Bandwidth   *model.Bandwidth       `json:"bandwidth,omitempty"`
+}
+
+// Status is the observed state of a Flow or Connector resource
+type Status struct {
+	// ObservedGeneration is the generation last applied to the agent
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// AgentID is the ID of the definition on the agent
+	AgentID string `json:"agentId,omitempty"`
+	// FlowStatus is the status of a flow on the agent
+	FlowStatus string      `json:"flowStatus,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Condition types
+const (
+	// ConditionSynced reports whether the spec has been applied to the agent
+	ConditionSynced = "Synced"
+	// ConditionActive reports whether a flow is active on the agent
+	ConditionActive = "Active"
+	// ConditionHealthy reports the health of a flow's triggers or of a
+	// connector's connection
+	ConditionHealthy = "Healthy"
+)
+
+// Condition statuses
+const (
+	ConditionTrue    = "True"
+	ConditionFalse   = "False"
+	ConditionUnknown = "Unknown"
+)
+
+// Condition is one aspect of a resource's state, in the form kubectl and
+// GitOps tools expect
+type Condition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	ObservedGeneration int64     `json:"observedGeneration,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// setCondition adds or replaces the condition of c's type, keeping its
+// transition time while its status is unchanged
+func setCondition(conditions []Condition, c Condition, now time.Time) []Condition {
+	for i, existing := range conditions {
+		if existing.Type != c.Type {
+			continue
+		}
+		c.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != c.Status || c.LastTransitionTime.IsZero() {
+			c.LastTransitionTime = now
+		}
+		conditions[i] = c
+		return conditions
+	}
+	c.LastTransitionTime = now
+	return append(conditions, c)
+}
+
+// agentID derives the agent ID of a resource from its namespace and name,
+// so that reapplying a resource, e.g. after the agent lost its store or
+// the cluster was rebuilt, updates the same definition
+func agentID(prefix, namespace, name string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return prefix + "_k8s_" + hex.EncodeToString(sum[:8])
+}
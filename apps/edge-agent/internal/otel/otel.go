@@ -3,6 +3,7 @@ package otel
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/fusionflow/edge-agent/internal/config"
@@ -44,11 +45,13 @@ func Initialize(cfg config.OTelConfig) error {
 	}
 
 	// Create trace provider
+	SetSampleRatio(cfg.SampleRatio)
 	traceProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(traceExporter,
 			sdktrace.WithBatchTimeout(5*time.Second),
 		),
 		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
 	)
 
 	// Set global trace provider
@@ -76,6 +79,34 @@ func Initialize(cfg config.OTelConfig) error {
 	return nil
 }
 
+// sampler samples new traces at the ratio last set, so that it can change
+// without replacing the trace provider
+var sampler = &ratioSampler{}
+
+type ratioSampler struct {
+	current atomic.Value
+}
+
+// SetSampleRatio sets the share of new traces recorded, from 0 to 1
+func SetSampleRatio(ratio float64) {
+	sampler.current.Store(sdktrace.TraceIDRatioBased(ratio))
+}
+
+func (s *ratioSampler) get() sdktrace.Sampler {
+	if current, ok := s.current.Load().(sdktrace.Sampler); ok {
+		return current
+	}
+	return sdktrace.AlwaysSample()
+}
+
+func (s *ratioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return s.get().ShouldSample(p)
+}
+
+func (s *ratioSampler) Description() string {
+	return s.get().Description()
+}
+
 // Shutdown gracefully shuts down OpenTelemetry
 func Shutdown(ctx context.Context) error {
 	if tp := otel.GetTracerProvider(); tp != nil {
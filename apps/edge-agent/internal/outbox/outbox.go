@@ -0,0 +1,190 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Bucket holds events awaiting delivery
+	Bucket = "outbox"
+
+	// DeadBucket holds events that exhausted their delivery attempts
+	DeadBucket = "outbox.dead"
+)
+
+// Event is a state change notification awaiting delivery
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Subject       string          `json:"subject"`
+	Data          json.RawMessage `json:"data,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	LastError     string          `json:"lastError,omitempty"`
+	Delivered     []string        `json:"delivered,omitempty"`
+}
+
+// Sink delivers events to one destination. Delivery is at-least-once, so
+// sinks should treat Event.ID as an idempotency key.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event Event) error
+}
+
+// Enqueue records an event in the same transaction as the state change it describes
+func Enqueue(tx store.Tx, eventType, subject string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	now := time.Now().UTC()
+	event := Event{
+		// Keys sort in enqueue order so events are delivered in the order they happened
+		ID:            fmt.Sprintf("%019d-%s", now.UnixNano(), ids.New("evt")),
+		Type:          eventType,
+		Subject:       subject,
+		Data:          payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	return put(tx, Bucket, event)
+}
+
+// Relay delivers outbox events to sinks with retries
+type Relay struct {
+	store  store.Store
+	sinks  []Sink
+	cfg    config.OutboxConfig
+	logger *logrus.Logger
+}
+
+// NewRelay creates a relay delivering to sinks
+func NewRelay(st store.Store, cfg config.OutboxConfig, logger *logrus.Logger, sinks ...Sink) *Relay {
+	return &Relay{store: st, sinks: sinks, cfg: cfg, logger: logger}
+}
+
+// Run delivers pending events on the configured interval until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(r.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := r.DeliverPending(ctx, now); err != nil {
+				r.logger.Errorf("Failed to deliver outbox events: %v", err)
+			}
+		}
+	}
+}
+
+// DeliverPending attempts every due event once and returns how many completed
+func (r *Relay) DeliverPending(ctx context.Context, now time.Time) (int, error) {
+	records, err := r.store.List(ctx, Bucket, store.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list outbox: %w", err)
+	}
+
+	completed := 0
+	for _, rec := range records {
+		var event Event
+		if err := json.Unmarshal(rec.Value, &event); err != nil {
+			r.logger.Errorf("Dropping undecodable outbox event %s: %v", rec.Key, err)
+			r.store.Delete(ctx, Bucket, rec.Key)
+			continue
+		}
+		if now.Before(event.NextAttemptAt) {
+			continue
+		}
+
+		done, err := r.deliver(ctx, &event, now)
+		if err != nil {
+			return completed, err
+		}
+		if done {
+			completed++
+		}
+	}
+	return completed, nil
+}
+
+// deliver sends event to every sink that has not yet acknowledged it and
+// records the outcome, reporting whether the event is finished
+func (r *Relay) deliver(ctx context.Context, event *Event, now time.Time) (bool, error) {
+	var lastErr error
+	for _, sink := range r.sinks {
+		if slices.Contains(event.Delivered, sink.Name()) {
+			continue
+		}
+		if err := sink.Deliver(ctx, *event); err != nil {
+			lastErr = fmt.Errorf("%s: %w", sink.Name(), err)
+			continue
+		}
+		event.Delivered = append(event.Delivered, sink.Name())
+	}
+
+	if lastErr == nil {
+		if err := r.store.Delete(ctx, Bucket, event.ID); err != nil {
+			return false, fmt.Errorf("failed to remove delivered event %s: %w", event.ID, err)
+		}
+		return true, nil
+	}
+
+	event.Attempts++
+	event.LastError = lastErr.Error()
+	fields := logrus.Fields{"event_id": event.ID, "event_type": event.Type, "attempts": event.Attempts}
+
+	if event.Attempts >= r.cfg.MaxAttempts {
+		r.logger.WithFields(fields).Errorf("Outbox event exhausted delivery attempts: %v", lastErr)
+		err := r.store.Update(ctx, func(tx store.Tx) error {
+			if err := put(tx, DeadBucket, *event); err != nil {
+				return err
+			}
+			return tx.Delete(Bucket, event.ID)
+		})
+		return true, err
+	}
+
+	event.NextAttemptAt = now.Add(r.backoff(event.Attempts))
+	r.logger.WithFields(fields).Warnf("Outbox event delivery failed, will retry: %v", lastErr)
+	return false, r.store.Update(ctx, func(tx store.Tx) error {
+		return put(tx, Bucket, *event)
+	})
+}
+
+// backoff returns the exponential delay before the given retry attempt
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := float64(r.cfg.BackoffBase) * math.Pow(2, float64(attempt-1))
+	if limit := float64(r.cfg.BackoffMax); delay > limit {
+		delay = limit
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// put stores event in bucket within tx
+func put(tx store.Tx, bucket string, event Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox event: %w", err)
+	}
+	return tx.Put(bucket, &store.Record{
+		Key:       event.ID,
+		Value:     value,
+		Labels:    map[string]string{"type": event.Type},
+		CreatedAt: event.CreatedAt,
+	})
+}
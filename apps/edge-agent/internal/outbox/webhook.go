@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// WebhookSink POSTs events as JSON to an HTTP endpoint. When a secret is
+// configured the body is signed with HMAC-SHA256 in X-FusionFlow-Signature.
+type WebhookSink struct {
+	cfg    config.WebhookSinkConfig
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink for one configured webhook
+func NewWebhookSink(cfg config.WebhookSinkConfig) *WebhookSink {
+	return &WebhookSink{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Name implements Sink
+func (s *WebhookSink) Name() string {
+	return "webhook:" + s.cfg.Name
+}
+
+// Deliver implements Sink
+func (s *WebhookSink) Deliver(ctx context.Context, event Event) error {
+	if !s.wants(event.Type) {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-FusionFlow-Event", event.Type)
+	req.Header.Set("X-FusionFlow-Delivery", event.ID)
+	if strings.HasPrefix(event.Type, "execution.") {
+		// Executions a receiving agent starts from the event record it as
+		// their parent
+		req.Header.Set("X-FusionFlow-Execution", event.Subject)
+	}
+	if s.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.cfg.Secret))
+		mac.Write(body)
+		req.Header.Set("X-FusionFlow-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// wants reports whether the sink subscribes to eventType
+func (s *WebhookSink) wants(eventType string) bool {
+	if len(s.cfg.Events) == 0 {
+		return true
+	}
+	for _, pattern := range s.cfg.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
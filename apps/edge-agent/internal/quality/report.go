@@ -0,0 +1,77 @@
+package quality
+
+import "sync"
+
+// Report summarizes the checks of one execution
+type Report struct {
+	mu sync.Mutex
+
+	Rows        int                   `json:"rows"`
+	Passed      int                   `json:"passed"`
+	Warned      int                   `json:"warned"`
+	Quarantined int                   `json:"quarantined"`
+	Batches     int                   `json:"batches,omitempty"`
+	Rules       map[string]*RuleStats `json:"rules"`
+}
+
+// RuleStats counts evaluations and failures of one rule
+type RuleStats struct {
+	Checked  int     `json:"checked"`
+	Failed   int     `json:"failed"`
+	PassRate float64 `json:"passRate"`
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{Rules: make(map[string]*RuleStats)}
+}
+
+func (r *Report) record(rule string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.Rules[rule]
+	if !ok {
+		stats = &RuleStats{}
+		r.Rules[rule] = stats
+	}
+	stats.Checked++
+	if failed {
+		stats.Failed++
+	}
+	stats.PassRate = float64(stats.Checked-stats.Failed) / float64(stats.Checked)
+}
+
+func (r *Report) recordRow(violations []Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Rows++
+	switch {
+	case HasErrors(violations):
+		r.Quarantined++
+	case len(violations) > 0:
+		r.Warned++
+		r.Passed++
+	default:
+		r.Passed++
+	}
+}
+
+// Quarantine moves rows that passed their own checks into the quarantined
+// count, e.g. when a batch-level rule rejects the whole batch
+func (r *Report) Quarantine(rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Passed -= rows
+	r.Quarantined += rows
+}
+
+// AddBatch counts one checked batch
+func (r *Report) AddBatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Batches++
+}
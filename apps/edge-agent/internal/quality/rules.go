@@ -0,0 +1,302 @@
+package quality
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule types
+const (
+	RuleNotNull   = "not_null"
+	RuleRange     = "range"
+	RuleRegex     = "regex"
+	RuleReference = "reference"
+	RuleRowCount  = "row_count"
+)
+
+// Severities. Errors quarantine the row; warnings are only counted.
+const (
+	SeverityError = "error"
+	SeverityWarn  = "warn"
+)
+
+// Rule declares one data quality check. Range, regex and reference rules
+// ignore null values; combine them with not_null to require a value.
+type Rule struct {
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type"`
+	Field    string `json:"field,omitempty"`
+	Severity string `json:"severity,omitempty"`
+
+	// Min and Max bound range values and row_count batch sizes, inclusive
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	Pattern string `json:"pattern,omitempty"`
+
+	// A reference rule checks the value against Values, or looks it up
+	// through Operation of Connector passing it as Param (Field by default)
+	Values    []interface{} `json:"values,omitempty"`
+	Connector string        `json:"connector,omitempty"`
+	Operation string        `json:"operation,omitempty"`
+	Param     string        `json:"param,omitempty"`
+}
+
+// Violation is one failed rule
+type Violation struct {
+	Rule     string `json:"rule"`
+	Field    string `json:"field,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// LookupFunc resolves reference data, see engine.Lookuper
+type LookupFunc func(ctx context.Context, connectorID, operation string, params map[string]interface{}) ([]map[string]interface{}, error)
+
+// RuleSet is a validated list of rules
+type RuleSet struct {
+	rules   []Rule
+	regexps map[int]*regexp.Regexp
+	values  map[int]map[string]bool
+}
+
+// Compile validates rules and prepares them for evaluation
+func Compile(rules []Rule) (*RuleSet, error) {
+	rs := &RuleSet{regexps: make(map[int]*regexp.Regexp), values: make(map[int]map[string]bool)}
+	names := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		if r.Severity == "" {
+			r.Severity = SeverityError
+		}
+		if r.Severity != SeverityError && r.Severity != SeverityWarn {
+			return nil, fmt.Errorf("rules[%d]: invalid severity %q", i, r.Severity)
+		}
+		if r.Type != RuleRowCount && r.Field == "" {
+			return nil, fmt.Errorf("rules[%d]: field is required for %s rules", i, r.Type)
+		}
+		switch r.Type {
+		case RuleNotNull:
+		case RuleRange, RuleRowCount:
+			if r.Min == nil && r.Max == nil {
+				return nil, fmt.Errorf("rules[%d]: %s requires min or max", i, r.Type)
+			}
+		case RuleRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d]: invalid pattern: %w", i, err)
+			}
+			rs.regexps[i] = re
+		case RuleReference:
+			switch {
+			case r.Values != nil:
+				set := make(map[string]bool, len(r.Values))
+				for _, v := range r.Values {
+					set[key(v)] = true
+				}
+				rs.values[i] = set
+			case r.Connector == "" || r.Operation == "":
+				return nil, fmt.Errorf("rules[%d]: reference requires values or a connector and operation", i)
+			}
+			if r.Param == "" {
+				r.Param = r.Field
+			}
+		default:
+			return nil, fmt.Errorf("rules[%d]: unknown rule type %q", i, r.Type)
+		}
+		if r.Name == "" {
+			r.Name = r.Type
+			if r.Field != "" {
+				r.Name += ":" + r.Field
+			}
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rules[%d]: rule name %q is duplicated", i, r.Name)
+		}
+		names[r.Name] = true
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// Rules returns the compiled rules with defaults applied
+func (rs *RuleSet) Rules() []Rule {
+	return rs.rules
+}
+
+// Checker evaluates a rule set, caching reference lookups across rows
+type Checker struct {
+	rules  *RuleSet
+	lookup LookupFunc
+	cache  map[string]bool
+	Report *Report
+}
+
+// NewChecker creates a checker that accumulates results into report
+func (rs *RuleSet) NewChecker(lookup LookupFunc, report *Report) *Checker {
+	return &Checker{rules: rs, lookup: lookup, cache: make(map[string]bool), Report: report}
+}
+
+// CheckRow evaluates the row rules against one record. Lookup failures are
+// returned as errors rather than violations.
+func (c *Checker) CheckRow(ctx context.Context, row map[string]interface{}) ([]Violation, error) {
+	var violations []Violation
+	for i, r := range c.rules.rules {
+		if r.Type == RuleRowCount {
+			continue
+		}
+		value, present := Field(row, r.Field)
+		msg, err := c.check(ctx, i, r, value, present)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		c.Report.record(r.Name, msg != "")
+		if msg != "" {
+			violations = append(violations, Violation{Rule: r.Name, Field: r.Field, Severity: r.Severity, Message: msg})
+		}
+	}
+	c.Report.recordRow(violations)
+	return violations, nil
+}
+
+// CheckBatch evaluates the row_count rules against a batch size
+func (c *Checker) CheckBatch(rows int) []Violation {
+	var violations []Violation
+	for _, r := range c.rules.rules {
+		if r.Type != RuleRowCount {
+			continue
+		}
+		n := float64(rows)
+		failed := (r.Min != nil && n < *r.Min) || (r.Max != nil && n > *r.Max)
+		c.Report.record(r.Name, failed)
+		if failed {
+			violations = append(violations, Violation{Rule: r.Name, Severity: r.Severity, Message: fmt.Sprintf("batch has %d rows, expected %s", rows, bounds(r))})
+		}
+	}
+	return violations
+}
+
+func (c *Checker) check(ctx context.Context, i int, r Rule, value interface{}, present bool) (string, error) {
+	if r.Type == RuleNotNull {
+		if !present || value == nil {
+			return "value is required", nil
+		}
+		if s, ok := value.(string); ok && strings.TrimSpace(s) == "" {
+			return "value is blank", nil
+		}
+		return "", nil
+	}
+	if !present || value == nil {
+		return "", nil
+	}
+
+	switch r.Type {
+	case RuleRange:
+		n, ok := number(value)
+		if !ok {
+			return fmt.Sprintf("value %v is not a number", value), nil
+		}
+		if (r.Min != nil && n < *r.Min) || (r.Max != nil && n > *r.Max) {
+			return fmt.Sprintf("value %v is outside %s", value, bounds(r)), nil
+		}
+	case RuleRegex:
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		if !c.rules.regexps[i].MatchString(s) {
+			return fmt.Sprintf("value %q does not match %s", s, r.Pattern), nil
+		}
+	case RuleReference:
+		found, err := c.reference(ctx, i, r, value)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return fmt.Sprintf("value %v has no matching reference", value), nil
+		}
+	}
+	return "", nil
+}
+
+func (c *Checker) reference(ctx context.Context, i int, r Rule, value interface{}) (bool, error) {
+	k := key(value)
+	if set, ok := c.rules.values[i]; ok {
+		return set[k], nil
+	}
+	cacheKey := r.Name + "\x00" + k
+	if found, ok := c.cache[cacheKey]; ok {
+		return found, nil
+	}
+	if c.lookup == nil {
+		return false, fmt.Errorf("connector lookups are not available")
+	}
+	records, err := c.lookup(ctx, r.Connector, r.Operation, map[string]interface{}{r.Param: value})
+	if err != nil {
+		return false, fmt.Errorf("lookup through %s/%s failed: %w", r.Connector, r.Operation, err)
+	}
+	found := len(records) > 0
+	c.cache[cacheKey] = found
+	return found, nil
+}
+
+// Field returns the value at a dotted path such as "customer.address.city"
+func Field(row map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = row
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// HasErrors reports whether any violation has error severity
+func HasErrors(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// key normalizes a value for set membership so 1 and 1.0 compare equal
+func key(v interface{}) string {
+	if n, ok := number(v); ok {
+		return fmt.Sprintf("n:%g", n)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func bounds(r Rule) string {
+	switch {
+	case r.Min != nil && r.Max != nil:
+		return fmt.Sprintf("[%g, %g]", *r.Min, *r.Max)
+	case r.Min != nil:
+		return fmt.Sprintf(">= %g", *r.Min)
+	default:
+		return fmt.Sprintf("<= %g", *r.Max)
+	}
+}
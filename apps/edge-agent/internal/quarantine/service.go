@@ -0,0 +1,319 @@
+package quarantine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/dlq"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Bucket holds the quarantined messages, keyed by ID
+const Bucket = "quarantine"
+
+// purgeInterval is how often messages past their retention are deleted
+const purgeInterval = 10 * time.Minute
+
+var (
+	// ErrNotFound is returned when a quarantined message does not exist
+	ErrNotFound = errors.New("quarantined message not found")
+
+	// ErrReviewed is returned when releasing or discarding a message that
+	// was already released or discarded
+	ErrReviewed = errors.New("quarantined message was already reviewed")
+
+	// ErrNoPayload is returned when releasing a message whose payload was
+	// not kept
+	ErrNoPayload = errors.New("quarantined message has no payload to release")
+
+	// ErrNoExecutor is returned when releasing before SetExecutor
+	ErrNoExecutor = errors.New("quarantine cannot release messages yet")
+)
+
+// Service holds the messages flows reject as data for review, and releases
+// them into new executions of their flow, past the step that held them. It
+// implements engine.Quarantine.
+type Service struct {
+	store    store.Store
+	cfg      config.QuarantineConfig
+	executor *engine.Executor
+	plan     dlq.PlanFunc
+	logger   *logrus.Logger
+}
+
+// NewService creates a quarantine. It cannot release messages until
+// SetExecutor is called, as plans are compiled with it before the executor
+// exists.
+func NewService(st store.Store, cfg config.QuarantineConfig, logger *logrus.Logger) *Service {
+	return &Service{store: st, cfg: cfg, logger: logger}
+}
+
+// SetExecutor sets the executor releases run on, on the plans returned by
+// plan
+func (s *Service) SetExecutor(executor *engine.Executor, plan dlq.PlanFunc) {
+	s.executor = executor
+	s.plan = plan
+}
+
+// Add implements engine.Quarantine
+func (s *Service) Add(ctx context.Context, q engine.Quarantined, msg *engine.Message) error {
+	entry := &model.Quarantined{
+		ID:            ids.New("qtn"),
+		FlowID:        q.FlowID,
+		FlowVersion:   q.FlowVersion,
+		ExecutionID:   q.ExecutionID,
+		StepID:        q.StepID,
+		Reason:        q.Reason,
+		Detail:        q.Detail,
+		Status:        model.QuarantinePending,
+		DebugLog:      logging.IsDebug(ctx),
+		QuarantinedAt: time.Now().UTC(),
+	}
+	if !msg.IsStream() && len(msg.Body) <= s.cfg.MaxPayloadBytes {
+		entry.Payload = dlq.Render(msg)
+	}
+	if err := s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, entry)
+	}); err != nil {
+		return err
+	}
+	s.logger.WithFields(logrus.Fields{"flow_id": entry.FlowID, "execution_id": entry.ExecutionID, "step_id": entry.StepID, "quarantined": entry.ID}).Infof("Quarantined message: %s", entry.Reason)
+	return nil
+}
+
+// Page returns the quarantined messages matching opts, which may filter on
+// the flow_id, status, reason and step_id labels, along with how many match
+// it in all
+func (s *Service) Page(ctx context.Context, opts store.ListOptions) ([]*model.Quarantined, int, error) {
+	total, err := s.store.Count(ctx, Bucket, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count quarantined messages: %w", err)
+	}
+	list, err := s.list(ctx, opts)
+	return list, total, err
+}
+
+// list returns the quarantined messages matching opts
+func (s *Service) list(ctx context.Context, opts store.ListOptions) ([]*model.Quarantined, error) {
+	records, err := s.store.List(ctx, Bucket, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined messages: %w", err)
+	}
+	list := make([]*model.Quarantined, 0, len(records))
+	for _, rec := range records {
+		q, err := decode(rec)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, nil
+}
+
+// Get returns a quarantined message
+func (s *Service) Get(ctx context.Context, id string) (*model.Quarantined, error) {
+	rec, err := s.store.Get(ctx, Bucket, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined message %s: %w", id, err)
+	}
+	return decode(rec)
+}
+
+// Release runs a pending message on the current plan of its flow, as if
+// the step that held it had emitted it on its default port, and marks it
+// as released
+func (s *Service) Release(ctx context.Context, id string) (*model.Execution, error) {
+	q, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if q.Status != model.QuarantinePending {
+		return nil, ErrReviewed
+	}
+	if q.Payload == nil {
+		return nil, ErrNoPayload
+	}
+	if s.executor == nil {
+		return nil, ErrNoExecutor
+	}
+	in, err := dlq.Restore(q.Payload)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := s.plan(ctx, q.FlowID)
+	if err != nil {
+		in.Release()
+		return nil, err
+	}
+	exec, err := s.executor.Submit(plan, in, engine.ExecuteOptions{
+		Cause: &model.Cause{Type: model.CauseQuarantine, ParentID: q.ExecutionID},
+		After: q.StepID,
+		Debug: q.DebugLog || logging.IsDebug(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to release quarantined message %s: %w", q.ID, err)
+	}
+
+	now := time.Now().UTC()
+	q.Status = model.QuarantineReleased
+	q.ReleaseExecutionID = exec.ID
+	q.ReviewedAt = &now
+	if err := s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, q)
+	}); err != nil {
+		return exec, err
+	}
+	return exec, nil
+}
+
+// Discard marks a pending message as discarded, dropping its payload; the
+// entry is kept as a record of the review until its retention ends
+func (s *Service) Discard(ctx context.Context, id string) (*model.Quarantined, error) {
+	q, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if q.Status != model.QuarantinePending {
+		return nil, ErrReviewed
+	}
+	now := time.Now().UTC()
+	q.Status = model.QuarantineDiscarded
+	q.Payload = nil
+	q.ReviewedAt = &now
+	if err := s.store.Update(ctx, func(tx store.Tx) error {
+		return save(tx, q)
+	}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Delete removes a quarantined message
+func (s *Service) Delete(ctx context.Context, id string) error {
+	err := s.store.Delete(ctx, Bucket, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete quarantined message %s: %w", id, err)
+	}
+	return nil
+}
+
+// Run deletes messages past their retention until ctx is cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := s.Purge(ctx, now)
+			if err != nil {
+				s.logger.Errorf("Failed to purge quarantined messages: %v", err)
+			} else if n > 0 {
+				s.logger.Debugf("Purged %d quarantined messages past their retention", n)
+			}
+		}
+	}
+}
+
+// Purge removes the pending messages quarantined longer than the retention
+// before now, and the reviewed ones reviewed longer than the reviewed
+// retention, and returns how many it removed
+func (s *Service) Purge(ctx context.Context, now time.Time) (int, error) {
+	var expired []string
+	if s.cfg.Retention > 0 {
+		records, err := s.store.List(ctx, Bucket, store.ListOptions{
+			Labels:        map[string]string{"status": model.QuarantinePending},
+			CreatedBefore: now.Add(-time.Duration(s.cfg.Retention) * time.Second),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to list quarantined messages: %w", err)
+		}
+		for _, rec := range records {
+			expired = append(expired, rec.Key)
+		}
+	}
+	if s.cfg.ReviewedRetention > 0 {
+		cutoff := now.Add(-time.Duration(s.cfg.ReviewedRetention) * time.Second)
+		for _, status := range []string{model.QuarantineReleased, model.QuarantineDiscarded} {
+			// Entries were reviewed after they were quarantined, so only
+			// those quarantined before the cutoff can have expired
+			reviewed, err := s.list(ctx, store.ListOptions{
+				Labels:        map[string]string{"status": status},
+				CreatedBefore: cutoff,
+			})
+			if err != nil {
+				return 0, err
+			}
+			for _, q := range reviewed {
+				if q.ReviewedAt != nil && q.ReviewedAt.Before(cutoff) {
+					expired = append(expired, q.ID)
+				}
+			}
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	err := s.store.Update(ctx, func(tx store.Tx) error {
+		for _, id := range expired {
+			if err := tx.Delete(Bucket, id); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return fmt.Errorf("failed to delete quarantined message %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// save writes q within tx
+func save(tx store.Tx, q *model.Quarantined) error {
+	value, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("failed to encode quarantined message: %w", err)
+	}
+	rec := &store.Record{
+		Key:   q.ID,
+		Value: value,
+		Labels: map[string]string{
+			"flow_id":      q.FlowID,
+			"status":       q.Status,
+			"reason":       q.Reason,
+			"step_id":      q.StepID,
+			"execution_id": q.ExecutionID,
+		},
+		CreatedAt: q.QuarantinedAt,
+	}
+	if err := tx.Put(Bucket, rec); err != nil {
+		return fmt.Errorf("failed to store quarantined message: %w", err)
+	}
+	return nil
+}
+
+// decode unmarshals a stored quarantined message
+func decode(rec *store.Record) (*model.Quarantined, error) {
+	var q model.Quarantined
+	if err := json.Unmarshal(rec.Value, &q); err != nil {
+		return nil, fmt.Errorf("failed to decode quarantined message %s: %w", rec.Key, err)
+	}
+	return &q, nil
+}
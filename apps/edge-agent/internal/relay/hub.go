@@ -0,0 +1,254 @@
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// dialTimeout bounds connecting to an upstream host
+const dialTimeout = 10 * time.Second
+
+// Hub relays the outbound traffic of the spokes at its site, as an HTTP
+// proxy restricted to the allowed hosts. HTTPS goes through CONNECT tunnels
+// that the hub only copies bytes through, so TLS runs between the spoke and
+// the upstream host and relayed payloads stay encrypted end to end.
+type Hub struct {
+	cfg    config.RelayHubConfig
+	server *http.Server
+	dialer net.Dialer
+	proxy  *httputil.ReverseProxy
+	logger *logrus.Logger
+
+	active    atomic.Int64
+	tunnels   atomic.Int64
+	forwarded atomic.Int64
+	rejected  atomic.Int64
+}
+
+// HubStats counts what the hub relayed
+type HubStats struct {
+	// Active is how many tunnels are open
+	Active    int64 `json:"active"`
+	Tunnels   int64 `json:"tunnels"`
+	Forwarded int64 `json:"forwarded"`
+	Rejected  int64 `json:"rejected"`
+}
+
+// NewHub creates the relay of a hub
+func NewHub(cfg config.RelayHubConfig, logger *logrus.Logger) *Hub {
+	h := &Hub{cfg: cfg, dialer: net.Dialer{Timeout: dialTimeout}, logger: logger}
+	h.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           h,
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		// Tunnels take over HTTP/1.1 connections, so HTTP/2 is not offered
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+	h.proxy = &httputil.ReverseProxy{
+		// Proxy requests already carry the absolute upstream URL
+		Director:  func(*http.Request) {},
+		Transport: &http.Transport{DialContext: h.dialer.DialContext, TLSHandshakeTimeout: dialTimeout},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			h.logger.Warnf("Failed to relay request to %s: %v", r.URL.Host, err)
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		},
+	}
+	return h
+}
+
+// ListenAndServe serves the relay on the configured port until Shutdown,
+// over TLS when a certificate is configured
+func (h *Hub) ListenAndServe() error {
+	var err error
+	if h.cfg.CertFile != "" {
+		err = h.server.ListenAndServeTLS(h.cfg.CertFile, h.cfg.KeyFile)
+	} else {
+		err = h.server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting spokes. Open tunnels are not waited for; they
+// end with the agent.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	return h.server.Shutdown(ctx)
+}
+
+// Stats returns the hub's counters
+func (h *Hub) Stats() HubStats {
+	return HubStats{
+		Active:    h.active.Load(),
+		Tunnels:   h.tunnels.Load(),
+		Forwarded: h.forwarded.Load(),
+		Rejected:  h.rejected.Load(),
+	}
+}
+
+// ServeHTTP implements http.Handler, relaying a spoke's proxy request
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	spoke, ok := h.authenticate(r)
+	if !ok {
+		h.rejected.Add(1)
+		w.Header().Set("Proxy-Authenticate", `Basic realm="fusionflow-relay"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		h.tunnel(w, r, spoke)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		h.rejected.Add(1)
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	if !h.cfg.AllowPlaintext {
+		h.rejected.Add(1)
+		http.Error(w, "plain HTTP is not relayed; use https", http.StatusForbidden)
+		return
+	}
+	if !h.allowed(r.URL.Host, "80") {
+		h.reject(w, spoke, r.URL.Host)
+		return
+	}
+	h.forwarded.Add(1)
+	h.proxy.ServeHTTP(w, r)
+}
+
+// tunnel connects the spoke to the host of a CONNECT request and copies
+// bytes both ways until either side closes
+func (h *Hub) tunnel(w http.ResponseWriter, r *http.Request, spoke string) {
+	if !h.allowed(r.Host, "443") {
+		h.reject(w, spoke, r.Host)
+		return
+	}
+	upstream, err := h.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		h.logger.Warnf("Failed to relay spoke %s to %s: %v", spoke, r.Host, err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		upstream.Close()
+		h.logger.Errorf("Failed to take over relay connection: %v", err)
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	// The connection is the tunnel's now; the server's deadlines do not apply
+	conn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+
+	h.tunnels.Add(1)
+	h.active.Add(1)
+	defer h.active.Add(-1)
+	h.logger.WithFields(logrus.Fields{"spoke": spoke, "host": r.Host}).Debug("Relay tunnel opened")
+
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			upstream.Close()
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		// Bytes the spoke sent after the request are in the buffered reader
+		io.Copy(upstream, buffered)
+		closeBoth()
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	closeBoth()
+	<-done
+}
+
+// reject refuses to relay to a host that is not allowed
+func (h *Hub) reject(w http.ResponseWriter, spoke, host string) {
+	h.rejected.Add(1)
+	h.logger.Warnf("Refused to relay spoke %s to %s: host is not allowed", spoke, host)
+	http.Error(w, fmt.Sprintf("host %s is not allowed", host), http.StatusForbidden)
+}
+
+// authenticate checks the spoke's token, sent as the password of Basic
+// proxy credentials or as a Bearer token, and returns the spoke's name
+func (h *Hub) authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	spoke, token := "spoke", ""
+	if t, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		token = t
+	} else if encoded, ok := strings.CutPrefix(auth, "Basic "); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", false
+		}
+		name, password, _ := strings.Cut(string(decoded), ":")
+		if name != "" {
+			spoke = name
+		}
+		token = password
+	}
+	if token == "" {
+		return "", false
+	}
+	for _, want := range h.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return spoke, true
+		}
+	}
+	return "", false
+}
+
+// allowed reports whether spokes may reach hostport, whose port defaults
+// to defaultPort
+func (h *Hub) allowed(hostport, defaultPort string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, defaultPort
+	}
+	host = strings.ToLower(host)
+	for _, entry := range h.cfg.Allow {
+		allowHost, allowPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			allowHost, allowPort = entry, ""
+		}
+		if allowPort != "" && allowPort != port {
+			continue
+		}
+		allowHost = strings.ToLower(allowHost)
+		if suffix, ok := strings.CutPrefix(allowHost, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowHost {
+			return true
+		}
+	}
+	return false
+}
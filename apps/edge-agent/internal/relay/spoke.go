@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// UseHub routes the agent's outbound HTTP traffic through the relay of its
+// hub, except to the hosts of cfg.Bypass and to loopback addresses. It sets
+// the standard proxy environment, which HTTP clients read on their first
+// request, so it must run before any; this covers the clients that build
+// their own transports, such as the telemetry exporters, as well.
+func UseHub(cfg config.RelaySpokeConfig) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse relay url: %w", err)
+	}
+	name := cfg.Name
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			name = "spoke"
+		}
+	}
+	u.User = url.UserPassword(name, cfg.Token)
+
+	bypass := append([]string(nil), cfg.Bypass...)
+	for _, key := range []string{"NO_PROXY", "no_proxy"} {
+		if existing := os.Getenv(key); existing != "" {
+			bypass = append(bypass, existing)
+			break
+		}
+	}
+	for key, value := range map[string]string{
+		"HTTP_PROXY":  u.String(),
+		"HTTPS_PROXY": u.String(),
+		"NO_PROXY":    strings.Join(bypass, ","),
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/sirupsen/logrus"
+)
+
+// debounce is how long the configuration file must be left alone before it
+// is read, since editors and config map updates write it in several steps
+const debounce = time.Second
+
+// Applier applies the dynamic settings of a reloaded configuration
+type Applier func(cfg *config.Config)
+
+// Reloader reloads the configuration on SIGHUP and whenever its file
+// changes. A reloaded configuration that fails validation, or whose secret
+// references cannot be resolved, is rejected with the one in use left
+// alone; a valid one is handed to every applier. Other settings keep their
+// values until the agent restarts.
+type Reloader struct {
+	file     string
+	logger   *logrus.Logger
+	mu       sync.Mutex
+	appliers []Applier
+}
+
+// NewReloader creates a reloader watching file; without a file only SIGHUP
+// reloads, picking up environment variables
+func NewReloader(file string, logger *logrus.Logger) *Reloader {
+	return &Reloader{file: file, logger: logger}
+}
+
+// Add adds an applier; it must be called before Run
+func (r *Reloader) Add(apply Applier) {
+	r.appliers = append(r.appliers, apply)
+}
+
+// Run reloads on signals and file changes until ctx is cancelled
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if r.file != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			r.logger.Errorf("Failed to watch config file; reloading on SIGHUP only: %v", err)
+		} else {
+			defer watcher.Close()
+			// Watch the directory, as the file is often replaced rather
+			// than written, such as a mounted config map
+			if err := watcher.Add(filepath.Dir(r.file)); err != nil {
+				r.logger.Errorf("Failed to watch config file; reloading on SIGHUP only: %v", err)
+			} else {
+				events, errs = watcher.Events, watcher.Errors
+			}
+		}
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info("Received SIGHUP; reloading configuration")
+			r.Reload()
+		case ev := <-events:
+			if r.touches(ev) {
+				timer.Reset(debounce)
+			}
+		case err := <-errs:
+			r.logger.Warnf("Config file watch error: %v", err)
+		case <-timer.C:
+			r.logger.Info("Config file changed; reloading configuration")
+			r.Reload()
+		}
+	}
+}
+
+// touches reports whether ev may have changed the configuration file
+func (r *Reloader) touches(ev fsnotify.Event) bool {
+	if ev.Op == fsnotify.Chmod {
+		return false
+	}
+	// Config maps swap a symlinked directory, so changes to its entries
+	// count too
+	name := filepath.Base(ev.Name)
+	return filepath.Clean(ev.Name) == filepath.Clean(r.file) || name == "..data"
+}
+
+// Reload reads the configuration again and applies it, reporting whether
+// it was valid
+func (r *Reloader) Reload() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Reload()
+	if err == nil {
+		err = secrets.ExpandConfig(cfg)
+	}
+	if err != nil {
+		r.logger.Errorf("Rejected reloaded configuration; keeping the current one: %v", err)
+		return false
+	}
+	for _, apply := range r.appliers {
+		apply(cfg)
+	}
+	r.logger.Info("Applied reloaded configuration; other settings apply after a restart")
+	return true
+}
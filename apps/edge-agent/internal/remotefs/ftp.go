@@ -0,0 +1,143 @@
+package remotefs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpFS is a remote file system over FTP, or FTPS when TLS is configured
+type ftpFS struct {
+	conn *ftp.ServerConn
+}
+
+func dialFTP(ctx context.Context, protocol string, cfg Config) (FS, error) {
+	timeout, _ := cfg.timeout()
+	options := []ftp.DialOption{ftp.DialWithContext(ctx), ftp.DialWithTimeout(timeout)}
+	if protocol == ProtocolFTPS {
+		tlsConfig, err := ftpsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ImplicitTLS {
+			options = append(options, ftp.DialWithTLS(tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+		}
+	}
+
+	addr := cfg.address(protocol)
+	conn, err := ftp.Dial(addr, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	username := cfg.Username
+	if username == "" {
+		username = "anonymous"
+	}
+	if err := conn.Login(username, cfg.Password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("failed to log in to %s: %w", addr, err)
+	}
+	return &ftpFS{conn: conn}, nil
+}
+
+func ftpsConfig(cfg Config) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("caFile %s holds no certificates", cfg.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func (f *ftpFS) List(ctx context.Context, dir string) ([]File, error) {
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		files = append(files, File{
+			Name:    entry.Name,
+			Path:    path.Join(dir, entry.Name),
+			Size:    int64(entry.Size),
+			ModTime: entry.Time,
+			IsDir:   entry.Type == ftp.EntryTypeFolder,
+		})
+	}
+	return files, nil
+}
+
+func (f *ftpFS) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return f.conn.Retr(name)
+}
+
+func (f *ftpFS) Put(ctx context.Context, name string, r io.Reader) error {
+	tmp := tempName(name)
+	err := f.conn.Stor(tmp, r)
+	if err == nil {
+		// Some servers refuse to rename over an existing file
+		f.conn.Delete(name)
+		err = f.conn.Rename(tmp, name)
+	}
+	if err != nil {
+		f.conn.Delete(tmp)
+		return err
+	}
+	return nil
+}
+
+func (f *ftpFS) Rename(ctx context.Context, from, to string) error {
+	return f.conn.Rename(from, to)
+}
+
+func (f *ftpFS) Remove(ctx context.Context, name string) error {
+	return f.conn.Delete(name)
+}
+
+// MkdirAll creates each missing directory of dir. FTP has no portable way
+// to tell an existing directory from a failure, so errors are ignored until
+// dir is listed at the end.
+func (f *ftpFS) MkdirAll(ctx context.Context, dir string) error {
+	current := ""
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+	for _, part := range strings.Split(dir, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		current = path.Join(current, part)
+		f.conn.MakeDir(current)
+	}
+	if _, err := f.conn.List(dir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+func (f *ftpFS) Close() error {
+	return f.conn.Quit()
+}
@@ -0,0 +1,139 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// Protocols of remote file systems
+const (
+	ProtocolSFTP = "sftp"
+	ProtocolFTP  = "ftp"
+	ProtocolFTPS = "ftps"
+)
+
+// File is an entry of a remote directory
+type File struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// FS is a connection to a remote file system. Paths are slash-separated
+// and relative ones start at the login directory. A connection serves one
+// call at a time; a reader from Open must be closed before the next call.
+type FS interface {
+	List(ctx context.Context, dir string) ([]File, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Put writes r to name, replacing it once the upload is complete so
+	// that pollers on the other side never pick up a partial file
+	Put(ctx context.Context, name string, r io.Reader) error
+	Rename(ctx context.Context, from, to string) error
+	Remove(ctx context.Context, name string) error
+	MkdirAll(ctx context.Context, dir string) error
+	Close() error
+}
+
+// Config locates a remote file system and holds its credentials. Shared by
+// the sftp, ftp and ftps connectors and triggers.
+//
+// SFTP servers authenticate with PrivateKey (PEM, or the PrivateKeyFile
+// holding it) or Password, and are verified against HostKey (an
+// authorized_keys line) or the entries of KnownHostsFile. Skipping the
+// check takes InsecureIgnoreHostKey. FTPS uses explicit TLS unless
+// ImplicitTLS is set.
+type Config struct {
+	Host                  string `json:"host"`
+	Port                  int    `json:"port"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	PrivateKey            string `json:"privateKey"`
+	PrivateKeyFile        string `json:"privateKeyFile"`
+	Passphrase            string `json:"passphrase"`
+	HostKey               string `json:"hostKey"`
+	KnownHostsFile        string `json:"knownHostsFile"`
+	InsecureIgnoreHostKey bool   `json:"insecureIgnoreHostKey"`
+	ImplicitTLS           bool   `json:"implicitTls"`
+	CAFile                string `json:"caFile"`
+	InsecureSkipVerify    bool   `json:"insecureSkipVerify"`
+	// Timeout bounds connecting and logging in, default 30s
+	Timeout string `json:"timeout"`
+}
+
+// Validate checks that cfg is complete for protocol
+func (c *Config) Validate(protocol string) error {
+	if c.Host == "" {
+		return errors.New("host is required")
+	}
+	if _, err := c.timeout(); err != nil {
+		return err
+	}
+	switch protocol {
+	case ProtocolSFTP:
+		if c.Username == "" {
+			return errors.New("username is required")
+		}
+		if c.Password == "" && c.PrivateKey == "" && c.PrivateKeyFile == "" {
+			return errors.New("password, privateKey or privateKeyFile is required")
+		}
+		if c.HostKey == "" && c.KnownHostsFile == "" && !c.InsecureIgnoreHostKey {
+			return errors.New("hostKey or knownHostsFile is required to verify the server; set insecureIgnoreHostKey to skip the check")
+		}
+	case ProtocolFTP, ProtocolFTPS:
+	default:
+		return fmt.Errorf("unsupported protocol %q", protocol)
+	}
+	return nil
+}
+
+func (c *Config) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 30 * time.Second, nil
+	}
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("timeout %q is not a positive duration", c.Timeout)
+	}
+	return d, nil
+}
+
+// address returns host:port, with the protocol's default port
+func (c *Config) address(protocol string) string {
+	port := c.Port
+	if port == 0 {
+		switch {
+		case protocol == ProtocolSFTP:
+			port = 22
+		case protocol == ProtocolFTPS && c.ImplicitTLS:
+			port = 990
+		default:
+			port = 21
+		}
+	}
+	return fmt.Sprintf("%s:%d", c.Host, port)
+}
+
+// Dial connects and logs in to the remote file system
+func Dial(ctx context.Context, protocol string, cfg Config) (FS, error) {
+	if err := cfg.Validate(protocol); err != nil {
+		return nil, err
+	}
+	switch protocol {
+	case ProtocolSFTP:
+		return dialSFTP(ctx, cfg)
+	default:
+		return dialFTP(ctx, protocol, cfg)
+	}
+}
+
+// tempName is where Put uploads name before moving it into place
+func tempName(name string) string {
+	dir, base := path.Split(name)
+	return dir + "." + base + ".part"
+}
@@ -0,0 +1,165 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpFS is a remote file system over SSH
+type sftpFS struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+func dialSFTP(ctx context.Context, cfg Config) (FS, error) {
+	timeout, _ := cfg.timeout()
+	hostKey, err := hostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKey != "" || cfg.PrivateKeyFile != "" {
+		signer, err := privateKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	config := &ssh.ClientConfig{User: cfg.Username, Auth: auth, HostKeyCallback: hostKey, Timeout: timeout}
+
+	addr := cfg.address(ProtocolSFTP)
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	return &sftpFS{ssh: client, client: sc}, nil
+}
+
+// hostKeyCallback verifies the server's host key against the configured
+// key or known_hosts file
+func hostKeyCallback(cfg Config) (ssh.HostKeyCallback, error) {
+	switch {
+	case cfg.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hostKey: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	case cfg.KnownHostsFile != "":
+		callback, err := knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load knownHostsFile: %w", err)
+		}
+		return callback, nil
+	case cfg.InsecureIgnoreHostKey:
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return nil, errors.New("no host key verification configured")
+}
+
+func privateKey(cfg Config) (ssh.Signer, error) {
+	pem := []byte(cfg.PrivateKey)
+	if cfg.PrivateKeyFile != "" {
+		var err error
+		if pem, err = os.ReadFile(cfg.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read privateKeyFile: %w", err)
+		}
+	}
+	var signer ssh.Signer
+	var err error
+	if cfg.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(cfg.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return signer, nil
+}
+
+func (f *sftpFS) List(ctx context.Context, dir string) ([]File, error) {
+	infos, err := f.client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0, len(infos))
+	for _, info := range infos {
+		files = append(files, File{
+			Name:    info.Name(),
+			Path:    path.Join(dir, info.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+	return files, nil
+}
+
+func (f *sftpFS) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return f.client.Open(name)
+}
+
+func (f *sftpFS) Put(ctx context.Context, name string, r io.Reader) error {
+	tmp := tempName(name)
+	w, err := f.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = w.ReadFrom(r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = f.client.PosixRename(tmp, name)
+	}
+	if err != nil {
+		f.client.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (f *sftpFS) Rename(ctx context.Context, from, to string) error {
+	return f.client.PosixRename(from, to)
+}
+
+func (f *sftpFS) Remove(ctx context.Context, name string) error {
+	return f.client.Remove(name)
+}
+
+func (f *sftpFS) MkdirAll(ctx context.Context, dir string) error {
+	return f.client.MkdirAll(dir)
+}
+
+func (f *sftpFS) Close() error {
+	err := f.client.Close()
+	if sshErr := f.ssh.Close(); err == nil {
+		err = sshErr
+	}
+	return err
+}
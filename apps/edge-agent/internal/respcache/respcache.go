@@ -0,0 +1,270 @@
+package respcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/gin-gonic/gin"
+)
+
+// Cache keeps the responses of expensive read endpoints for a short TTL,
+// such as for dashboards polling many agents. Entries carry the tags of
+// what they show, e.g. "flow" or "connector", and are dropped when an
+// outbox event of that kind is delivered; Cache is an outbox.Sink for
+// that. Every response gets an ETag, so unchanged ones are answered with
+// 304 Not Modified.
+//
+// A nil Cache caches nothing.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// gens counts the invalidations of each tag, so that a response built
+	// while its tags were invalidated is not cached
+	gens map[string]uint64
+}
+
+// entry is a cached response
+type entry struct {
+	tags    []string
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// New creates a cache of cfg
+func New(cfg config.ResponseCacheConfig) *Cache {
+	return &Cache{
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[string]*entry),
+		gens:       make(map[string]uint64),
+	}
+}
+
+// Name implements outbox.Sink
+func (c *Cache) Name() string {
+	return "response-cache"
+}
+
+// Deliver implements outbox.Sink, invalidating the tag of the event's
+// kind: "flow" for flow.activated and so on
+func (c *Cache) Deliver(ctx context.Context, event outbox.Event) error {
+	if kind, _, _ := strings.Cut(event.Type, "."); kind != "" {
+		c.Invalidate(kind)
+	}
+	return nil
+}
+
+// Invalidate drops the responses tagged with any of tags
+func (c *Cache) Invalidate(tags ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		c.gens[tag]++
+	}
+	for key, e := range c.entries {
+		for _, tag := range e.tags {
+			if slices.Contains(tags, tag) {
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+// Purge drops every response
+func (c *Cache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The empty tag counts purges
+	c.gens[""]++
+	c.entries = make(map[string]*entry)
+}
+
+// Handler caches the successful responses of a read endpoint under tags
+func (c *Cache) Handler(tags ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if c == nil || (ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead) {
+			ctx.Next()
+			return
+		}
+		key := ctx.Request.URL.RequestURI()
+		if !strings.Contains(ctx.GetHeader("Cache-Control"), "no-cache") {
+			if e := c.get(key); e != nil {
+				serve(ctx, e, "HIT")
+				ctx.Abort()
+				return
+			}
+		}
+
+		gens := c.generations(tags)
+		rec := &recorder{ResponseWriter: ctx.Writer, status: http.StatusOK}
+		ctx.Writer = rec
+		ctx.Next()
+		ctx.Writer = rec.ResponseWriter
+
+		if rec.status != http.StatusOK {
+			ctx.Writer.WriteHeader(rec.status)
+			ctx.Writer.Write(rec.body.Bytes())
+			return
+		}
+		e := &entry{
+			tags:    tags,
+			header:  ctx.Writer.Header().Clone(),
+			body:    rec.body.Bytes(),
+			etag:    etag(rec.body.Bytes()),
+			expires: time.Now().Add(c.ttl),
+		}
+		c.put(key, e, gens)
+		serve(ctx, e, "MISS")
+	}
+}
+
+// InvalidateOnWrite purges the cache after every successful request that
+// may change state, so that clients see their own changes at once rather
+// than once the outbox delivers their events
+func (c *Cache) InvalidateOnWrite() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if ctx.Writer.Status() < 400 {
+			c.Purge()
+		}
+	}
+}
+
+func (c *Cache) get(key string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e
+}
+
+// generations returns the invalidation counts of tags, the purge count
+// first
+func (c *Cache) generations(tags []string) []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gens := make([]uint64, 0, len(tags)+1)
+	gens = append(gens, c.gens[""])
+	for _, tag := range tags {
+		gens = append(gens, c.gens[tag])
+	}
+	return gens
+}
+
+// put caches e unless its tags were invalidated since gens were taken,
+// evicting the entry closest to expiry when the cache is full
+func (c *Cache) put(key string, e *entry, gens []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[""] != gens[0] {
+		return
+	}
+	for i, tag := range e.tags {
+		if c.gens[tag] != gens[i+1] {
+			return
+		}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for k, other := range c.entries {
+			if oldest == "" || other.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = e
+}
+
+// serve writes a response, or 304 Not Modified when the client has it
+func serve(ctx *gin.Context, e *entry, outcome string) {
+	header := ctx.Writer.Header()
+	for name, values := range e.header {
+		header[name] = values
+	}
+	header.Set("ETag", e.etag)
+	header.Set("X-Cache", outcome)
+	if match := ctx.GetHeader("If-None-Match"); match != "" && etagMatches(match, e.etag) {
+		ctx.Status(http.StatusNotModified)
+		ctx.Writer.WriteHeaderNow()
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	ctx.Status(http.StatusOK)
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Writer.WriteHeaderNow()
+		return
+	}
+	ctx.Writer.Write(e.body)
+}
+
+// etag is the strong entity tag of a body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists tag
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder holds back a response so that it can be cached and tagged
+// before it is written
+type recorder struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) { r.status = code }
+
+func (r *recorder) WriteHeaderNow() {}
+
+func (r *recorder) Write(data []byte) (int, error) { return r.body.Write(data) }
+
+func (r *recorder) WriteString(s string) (int, error) { return r.body.WriteString(s) }
+
+func (r *recorder) Status() int { return r.status }
+
+func (r *recorder) Size() int { return r.body.Len() }
+
+func (r *recorder) Written() bool { return r.body.Len() > 0 }
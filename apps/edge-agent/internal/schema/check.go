@@ -0,0 +1,107 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// typeNames are the types a type keyword may name
+var typeNames = map[string]bool{
+	"null": true, "boolean": true, "number": true, "integer": true,
+	"string": true, "array": true, "object": true,
+}
+
+// Check reports the problems of a schema itself, such as unknown type
+// names or patterns that do not compile, prefixed with the JSON path of
+// the offending keyword. It checks the keywords Validate supports.
+func Check(schema map[string]interface{}) []string {
+	var problems []string
+	check(schema, "$", &problems)
+	return problems
+}
+
+// check appends the problems of the schema at path
+func check(schema map[string]interface{}, path string, problems *[]string) {
+	fail := func(keyword, format string, args ...interface{}) {
+		*problems = append(*problems, path+"."+keyword+": "+fmt.Sprintf(format, args...))
+	}
+	sub := func(keyword string, v interface{}, childPath string) {
+		if child, ok := v.(map[string]interface{}); ok {
+			check(child, childPath, problems)
+		} else if _, ok := v.(bool); !ok || keyword != "additionalProperties" {
+			*problems = append(*problems, childPath+": must be a schema object")
+		}
+	}
+
+	if v, ok := schema["type"]; ok {
+		switch t := v.(type) {
+		case string:
+			if !typeNames[t] {
+				fail("type", "unknown type %q", t)
+			}
+		case []interface{}:
+			for _, item := range t {
+				if name, ok := item.(string); !ok || !typeNames[name] {
+					fail("type", "unknown type %v", item)
+				}
+			}
+		default:
+			fail("type", "must be a type name or a list of type names")
+		}
+	}
+	if v, ok := schema["pattern"]; ok {
+		if pattern, ok := v.(string); !ok {
+			fail("pattern", "must be a string")
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			fail("pattern", "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if v, ok := schema["required"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			fail("required", "must be a list of property names")
+		}
+		for _, name := range list {
+			if _, ok := name.(string); !ok {
+				fail("required", "property name %v is not a string", name)
+			}
+		}
+	}
+	if v, ok := schema["enum"]; ok {
+		if _, ok := v.([]interface{}); !ok {
+			fail("enum", "must be a list")
+		}
+	}
+	for _, keyword := range []string{"minLength", "maxLength", "minItems", "maxItems", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+		if v, ok := schema[keyword]; ok {
+			if _, ok := number(v); !ok {
+				fail(keyword, "must be a number")
+			}
+		}
+	}
+	if v, ok := schema["properties"]; ok {
+		properties, ok := v.(map[string]interface{})
+		if !ok {
+			fail("properties", "must be an object")
+		}
+		for _, key := range sortedKeys(properties) {
+			sub("properties", properties[key], path+".properties."+key)
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties"} {
+		if v, ok := schema[keyword]; ok {
+			sub(keyword, v, path+"."+keyword)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		if v, ok := schema[keyword]; ok {
+			list, ok := v.([]interface{})
+			if !ok {
+				fail(keyword, "must be a list of schemas")
+			}
+			for i, item := range list {
+				sub(keyword, item, fmt.Sprintf("%s.%s[%d]", path, keyword, i))
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Draft is the JSON Schema dialect of inferred schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Infer returns a JSON Schema accepting every sample. Types are widened
+// across samples (integer and number become number; otherwise the types are
+// listed), properties missing from some samples are optional, nulls make a
+// value nullable and strings that all share a well-known format get it.
+func Infer(samples []interface{}) map[string]interface{} {
+	root := &node{}
+	for _, s := range samples {
+		root.add(s)
+	}
+	out := root.schema()
+	out["$schema"] = Draft
+	return out
+}
+
+// node accumulates the values observed at one location
+type node struct {
+	types map[string]bool
+	// present counts the objects a property appeared in
+	present int
+	objects int
+	props   map[string]*node
+	order   []string
+	items   *node
+	strings int
+	formats map[string]int
+}
+
+func (n *node) add(v interface{}) {
+	if n.types == nil {
+		n.types = make(map[string]bool)
+	}
+	switch t := v.(type) {
+	case nil:
+		n.types["null"] = true
+	case bool:
+		n.types["boolean"] = true
+	case float64:
+		if t == math.Trunc(t) && !math.IsInf(t, 0) {
+			n.types["integer"] = true
+		} else {
+			n.types["number"] = true
+		}
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			n.types["integer"] = true
+		} else {
+			n.types["number"] = true
+		}
+	case string:
+		n.types["string"] = true
+		n.strings++
+		if f := detectFormat(t); f != "" {
+			if n.formats == nil {
+				n.formats = make(map[string]int)
+			}
+			n.formats[f]++
+		}
+	case []interface{}:
+		n.types["array"] = true
+		if n.items == nil {
+			n.items = &node{}
+		}
+		for _, item := range t {
+			n.items.add(item)
+		}
+	case map[string]interface{}:
+		n.types["object"] = true
+		n.objects++
+		if n.props == nil {
+			n.props = make(map[string]*node)
+		}
+		for _, k := range sortedKeys(t) {
+			child, ok := n.props[k]
+			if !ok {
+				child = &node{}
+				n.props[k] = child
+				n.order = append(n.order, k)
+			}
+			child.add(t[k])
+			child.present++
+		}
+	default:
+		n.types["string"] = true
+	}
+}
+
+func (n *node) schema() map[string]interface{} {
+	out := make(map[string]interface{})
+	if len(n.types) == 0 {
+		return out
+	}
+	if n.types["integer"] && n.types["number"] {
+		delete(n.types, "integer")
+	}
+	types := make([]string, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	if len(types) == 1 {
+		out["type"] = types[0]
+	} else {
+		list := make([]interface{}, len(types))
+		for i, t := range types {
+			list[i] = t
+		}
+		out["type"] = list
+	}
+
+	if n.types["string"] {
+		for f, count := range n.formats {
+			if count == n.strings {
+				out["format"] = f
+			}
+		}
+	}
+	if n.items != nil {
+		if items := n.items.schema(); len(items) > 0 {
+			out["items"] = items
+		}
+	}
+	if n.types["object"] {
+		props := make(map[string]interface{}, len(n.props))
+		var required []interface{}
+		for _, k := range n.order {
+			child := n.props[k]
+			props[k] = child.schema()
+			if child.present == n.objects {
+				required = append(required, k)
+			}
+		}
+		out["properties"] = props
+		if len(required) > 0 {
+			out["required"] = required
+		}
+	}
+	return out
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uriPattern   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://\S+$`)
+)
+
+// detectFormat returns the JSON Schema format of s, if any
+func detectFormat(s string) string {
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return "date-time"
+	}
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return "date"
+	}
+	switch {
+	case uuidPattern.MatchString(s):
+		return "uuid"
+	case emailPattern.MatchString(s):
+		return "email"
+	case uriPattern.MatchString(s):
+		return "uri"
+	}
+	return ""
+}
+
+// ParseCSV reads CSV data with a header row into one object per record.
+// Empty cells become null; numbers and booleans are converted.
+func ParseCSV(data []byte) ([]interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV data has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	var samples []interface{}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV record: %w", err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			row[name] = csvValue(record[i])
+		}
+		samples = append(samples, row)
+	}
+	return samples, nil
+}
+
+func csvValue(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil && len(s) > 1 {
+		return b
+	}
+	return s
+}
@@ -0,0 +1,274 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Validate checks value against a JSON Schema and returns every problem
+// found, prefixed with the JSON path of the offending value. Values are
+// expected in the form produced by encoding/json (float64, string, bool, nil,
+// map[string]interface{} and []interface{}).
+//
+// The common validation keywords are supported: type, enum, const, required,
+// properties, additionalProperties, items, minItems/maxItems,
+// minLength/maxLength, pattern, minimum/maximum (and their exclusive forms),
+// allOf, anyOf, oneOf and OpenAPI's nullable. Unresolved $ref schemas accept
+// any value.
+func Validate(schema map[string]interface{}, value interface{}) []string {
+	var problems []string
+	validate(schema, value, "$", &problems)
+	return problems
+}
+
+// ValidateJSON decodes data and validates it against schema
+func ValidateJSON(schema map[string]interface{}, data []byte) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return Validate(schema, value), nil
+}
+
+// validate appends the problems found in value at path
+func validate(schema map[string]interface{}, value interface{}, path string, problems *[]string) {
+	if len(schema) == 0 {
+		return
+	}
+	if _, ok := schema["$ref"]; ok {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil && schema["nullable"] == true {
+		return
+	}
+	if types := typeList(schema["type"]); len(types) > 0 {
+		actual := typeOf(value)
+		if !matchesType(types, actual, value) {
+			fail("expected %s, got %s", joinTypes(types), actual)
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if equal(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the allowed values")
+		}
+	}
+	if constant, ok := schema["const"]; ok && !equal(constant, value) {
+		fail("value must be %v", constant)
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if limit, ok := number(schema["minLength"]); ok && float64(length) < limit {
+			fail("must be at least %v characters", limit)
+		}
+		if limit, ok := number(schema["maxLength"]); ok && float64(length) > limit {
+			fail("must be at most %v characters", limit)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				fail("schema pattern %q is invalid", pattern)
+			} else if !re.MatchString(v) {
+				fail("does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if limit, ok := number(schema["minimum"]); ok && v < limit {
+			fail("must be >= %v", limit)
+		}
+		if limit, ok := number(schema["maximum"]); ok && v > limit {
+			fail("must be <= %v", limit)
+		}
+		if limit, ok := number(schema["exclusiveMinimum"]); ok && v <= limit {
+			fail("must be > %v", limit)
+		}
+		if limit, ok := number(schema["exclusiveMaximum"]); ok && v >= limit {
+			fail("must be < %v", limit)
+		}
+	case []interface{}:
+		if limit, ok := number(schema["minItems"]); ok && float64(len(v)) < limit {
+			fail("must have at least %v items", limit)
+		}
+		if limit, ok := number(schema["maxItems"]); ok && float64(len(v)) > limit {
+			fail("must have at most %v items", limit)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validate(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, key := range sortedKeys(v) {
+			child := path + "." + key
+			if prop, ok := properties[key].(map[string]interface{}); ok {
+				validate(prop, v[key], child, problems)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("property %q is not allowed", key)
+				}
+			case map[string]interface{}:
+				validate(additional, v[key], child, problems)
+			}
+		}
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		validate(sub, value, path, problems)
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 && countMatches(anyOf, value) == 0 {
+		fail("does not match any of the allowed schemas")
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		if n := countMatches(oneOf, value); n != 1 {
+			fail("must match exactly one schema, matched %d", n)
+		}
+	}
+}
+
+// countMatches returns how many of schemas value satisfies
+func countMatches(schemas []map[string]interface{}, value interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(Validate(sub, value)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// typeOf returns the JSON Schema type name of value
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// matchesType reports whether a value of type actual satisfies one of types
+func matchesType(types []string, actual string, value interface{}) bool {
+	for _, t := range types {
+		switch {
+		case t == actual:
+			return true
+		case t == "integer" && actual == "number":
+			if f := value.(float64); f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeList returns the types allowed by a type keyword, which may be a string or a list
+func typeList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		types := make([]string, 0, len(v))
+		for _, t := range v {
+			if s, ok := t.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// joinTypes formats allowed types for error messages
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", types)
+}
+
+// schemaList returns the subschemas of an allOf/anyOf/oneOf keyword
+func schemaList(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	schemas := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if sub, ok := item.(map[string]interface{}); ok {
+			schemas = append(schemas, sub)
+		}
+	}
+	return schemas
+}
+
+// number converts a numeric keyword value, which may come from JSON or YAML
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// equal compares two decoded values, treating numbers of different Go types as equal
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sortedKeys returns m's keys in order so problems are reported deterministically
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/awssig"
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// awsSecrets reads aws:ID#KEY references from AWS Secrets Manager: the
+// current version of the secret ID, a name or ARN, or its KEY field when
+// the secret is a JSON object. Credentials come from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+type awsSecrets struct {
+	region   string
+	endpoint string
+	client   *http.Client
+}
+
+func newAWS(cfg config.AWSSecretsConfig) (*awsSecrets, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", cfg.Region)
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid secrets aws endpoint: %w", err)
+	}
+	return &awsSecrets{
+		region:   cfg.Region,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: resolveTimeout},
+	}, nil
+}
+
+func (a *awsSecrets) Resolve(ctx context.Context, name string) ([]byte, error) {
+	id, key, _ := strings.Cut(name, "#")
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds, err := awssig.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	awssig.Sign(req, body, creds, a.region, "secretsmanager")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		if strings.HasSuffix(failure.Type, "ResourceNotFoundException") {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("secrets manager returned %s: %s %s", resp.Status, failure.Type, failure.Message)
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary []byte  `json:"SecretBinary"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	if secret.SecretString == nil {
+		if key != "" {
+			return nil, fmt.Errorf("secret %s is binary and has no fields", id)
+		}
+		return secret.SecretBinary, nil
+	}
+	if key == "" {
+		return []byte(*secret.SecretString), nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*secret.SecretString), &fields); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object: %w", id, err)
+	}
+	return field(fields, key)
+}
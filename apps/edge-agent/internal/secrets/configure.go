@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// renewInterval is how often the leases of providers are checked for
+// renewal
+const renewInterval = 10 * time.Second
+
+// renewer is a provider holding leases, such as Vault's
+type renewer interface {
+	renew(ctx context.Context, logger *logrus.Logger)
+}
+
+var (
+	watchMu  sync.Mutex
+	watchers []func(ctx context.Context, ref string)
+)
+
+// Watch calls fn with the reference of every secret a provider replaced,
+// such as dynamic credentials issued when their lease could not be
+// renewed, so that connectors using it reconnect
+func Watch(fn func(ctx context.Context, ref string)) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchers = append(watchers, fn)
+}
+
+func notify(ctx context.Context, ref string) {
+	watchMu.Lock()
+	list := append([]func(context.Context, string){}, watchers...)
+	watchMu.Unlock()
+	for _, fn := range list {
+		fn(ctx, ref)
+	}
+}
+
+// Run renews the leases of the registered providers until ctx is
+// cancelled
+func Run(ctx context.Context, logger *logrus.Logger) {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mu.RLock()
+		var list []renewer
+		for _, p := range providers {
+			if r, ok := p.(renewer); ok {
+				list = append(list, r)
+			}
+		}
+		mu.RUnlock()
+		for _, r := range list {
+			r.renew(ctx, logger)
+		}
+	}
+}
+
+// cacheTTL is how long the values of remote providers are kept, so that
+// connectors reconnecting in a burst do not each make a request
+const cacheTTL = 30 * time.Second
+
+// Configure sets the secrets directory and default provider of cfg and
+// registers the remote providers it configures
+func Configure(cfg config.SecretsConfig) error {
+	SetDir(cfg.Dir)
+	if cfg.Vault.Address != "" {
+		// Keep the provider of an unchanged Vault, and with it the leases
+		// of the credentials in use
+		p, _ := provider("vault")
+		if v, ok := p.(*vault); !ok || !reflect.DeepEqual(v.cfg, cfg.Vault) {
+			Register("vault", newVault(cfg.Vault))
+		}
+	}
+	if cfg.AWS.Region != "" {
+		aws, err := newAWS(cfg.AWS)
+		if err != nil {
+			return err
+		}
+		Register("aws", cached(aws))
+	}
+	if _, ok := provider(cfg.DefaultProvider); !ok {
+		return fmt.Errorf("secrets default_provider %s is not configured", cfg.DefaultProvider)
+	}
+	SetDefault(cfg.DefaultProvider)
+	return nil
+}
+
+// ExpandConfig configures the providers of cfg and expands the
+// ${secret:...} references of its values. References within the secrets
+// section itself, such as the Vault token, are read from the environment
+// or the secrets directory.
+func ExpandConfig(cfg *config.Config) error {
+	SetDir(cfg.Secrets.Dir)
+	if err := ExpandStruct(&cfg.Secrets); err != nil {
+		return fmt.Errorf("secrets.%w", err)
+	}
+	if err := Configure(cfg.Secrets); err != nil {
+		return err
+	}
+	return ExpandStruct(cfg)
+}
+
+// cache keeps the values a provider returned for cacheTTL
+type cache struct {
+	provider Provider
+	mu       sync.Mutex
+	values   map[string]cachedValue
+}
+
+type cachedValue struct {
+	value   []byte
+	expires time.Time
+}
+
+func cached(p Provider) Provider {
+	return &cache{provider: p, values: make(map[string]cachedValue)}
+}
+
+func (c *cache) Resolve(ctx context.Context, name string) ([]byte, error) {
+	c.mu.Lock()
+	v, ok := c.values[name]
+	c.mu.Unlock()
+	if ok && time.Now().Before(v.expires) {
+		return v.value, nil
+	}
+	value, err := c.provider.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.values[name] = cachedValue{value: value, expires: time.Now().Add(cacheTTL)}
+	c.mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,231 @@
+package secrets
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// reference matches the ${secret:REF} references of configuration values
+// and connector definitions. REF is a secret reference such as
+// env:DB_PASSWORD, or a bare name read by the default provider.
+var reference = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+
+// defaultScheme is the scheme of references without one
+var defaultScheme = "env"
+
+// SetDefault sets the provider of references without a scheme
+func SetDefault(scheme string) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultScheme = scheme
+}
+
+// qualify adds the default scheme to a reference without a known one
+func qualify(ref string) string {
+	if scheme, _, ok := strings.Cut(ref, ":"); ok {
+		if _, known := provider(scheme); known {
+			return ref
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultScheme + ":" + ref
+}
+
+// HasReferences reports whether s holds ${secret:...} references
+func HasReferences(s string) bool {
+	return strings.Contains(s, "${secret:")
+}
+
+// OnlyReferences reports whether s consists of ${secret:REF} references
+// alone, so that it reveals no secret
+func OnlyReferences(s string) bool {
+	return HasReferences(s) && strings.TrimSpace(reference.ReplaceAllString(s, "")) == ""
+}
+
+// Expand replaces the ${secret:REF} references of s with the values of
+// their secrets, less a trailing newline as secret files often end with
+func Expand(s string) (string, error) {
+	if !HasReferences(s) {
+		return s, nil
+	}
+	var firstErr error
+	out := reference.ReplaceAllStringFunc(s, func(match string) string {
+		value, err := Resolve(qualify(reference.FindStringSubmatch(match)[1]))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return match
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r")
+	})
+	return out, firstErr
+}
+
+// Check validates the ${secret:REF} references in the strings of v, such
+// as a connector's config, without reading them
+func Check(v interface{}) error {
+	var firstErr error
+	walkStrings(v, func(s string) {
+		for _, m := range reference.FindAllStringSubmatch(s, -1) {
+			if err := Validate(qualify(m[1])); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	})
+	return firstErr
+}
+
+// References returns the references of the ${secret:REF} in the strings
+// of v, with the default scheme added to those without one
+func References(v interface{}) []string {
+	var refs []string
+	walkStrings(v, func(s string) {
+		for _, m := range reference.FindAllStringSubmatch(s, -1) {
+			refs = append(refs, qualify(m[1]))
+		}
+	})
+	return refs
+}
+
+// SameSecret reports whether two references read the same secret, whatever
+// #KEY field of it they select
+func SameSecret(a, b string) bool {
+	a, _, _ = strings.Cut(a, "#")
+	b, _, _ = strings.Cut(b, "#")
+	return a == b
+}
+
+func walkStrings(v interface{}, fn func(string)) {
+	switch v := v.(type) {
+	case string:
+		fn(v)
+	case map[string]interface{}:
+		for _, child := range v {
+			walkStrings(child, fn)
+		}
+	case []interface{}:
+		for _, child := range v {
+			walkStrings(child, fn)
+		}
+	}
+}
+
+// ExpandMap returns a copy of m, such as a connector's config, with the
+// references of its strings expanded. m itself is left alone, so that the
+// resolved values never reach the store.
+func ExpandMap(m map[string]interface{}) (map[string]interface{}, error) {
+	out, err := expandValue(m)
+	if err != nil {
+		return nil, err
+	}
+	copied, _ := out.(map[string]interface{})
+	return copied, nil
+}
+
+func expandValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return Expand(v)
+	case map[string]interface{}:
+		if v == nil {
+			return v, nil
+		}
+		out := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			expanded, err := expandValue(child)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = expanded
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			expanded, err := expandValue(child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = expanded
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// ExpandStruct expands in place the references of the string fields of
+// the struct ptr points to, through nested structs, slices and maps, such
+// as the agent's configuration. Errors name the field holding the
+// reference.
+func ExpandStruct(ptr interface{}) error {
+	return expandField(reflect.ValueOf(ptr).Elem(), "")
+}
+
+func expandField(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !HasReferences(v.String()) {
+			return nil
+		}
+		expanded, err := Expand(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if v.CanSet() {
+			v.SetString(expanded)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return expandField(v.Elem(), path)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("mapstructure"), ",")
+			if name == "" || name == "-" {
+				name = t.Field(i).Name
+			}
+			if err := expandField(v.Field(i), join(path, name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandField(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			s := iter.Value().String()
+			if !HasReferences(s) {
+				continue
+			}
+			expanded, err := Expand(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", join(path, fmt.Sprint(iter.Key())), err)
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(expanded).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,140 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables "env:" references read, so
+// flows cannot reach the rest of the agent's environment
+const EnvPrefix = "FUSIONFLOW_SECRET_"
+
+// ErrNotFound is returned for references to secrets that do not exist
+var ErrNotFound = errors.New("secret not found")
+
+// resolveTimeout bounds the lookups of providers reached over the network
+const resolveTimeout = 10 * time.Second
+
+// Provider reads the secrets of one reference scheme, such as "vault" for
+// vault:NAME references. Implementations are safe for concurrent use.
+type Provider interface {
+	Resolve(ctx context.Context, name string) ([]byte, error)
+}
+
+var (
+	mu  sync.RWMutex
+	dir string
+	// providers are the providers by scheme; env and file are built in
+	providers = map[string]Provider{
+		"env":  envProvider{},
+		"file": fileProvider{},
+	}
+)
+
+// SetDir sets the directory "file:" references are read from, such as a
+// mounted Kubernetes secret
+func SetDir(d string) {
+	mu.Lock()
+	defer mu.Unlock()
+	dir = d
+}
+
+// Register makes a provider available for references of scheme, replacing
+// any provider registered for it before
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// Schemes returns the schemes of the registered providers, sorted
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]string, 0, len(providers))
+	for scheme := range providers {
+		list = append(list, scheme)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func provider(scheme string) (Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[scheme]
+	return p, ok
+}
+
+// Validate checks the syntax of a secret reference and that a provider
+// reads its scheme
+func Validate(ref string) error {
+	scheme, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid secret reference %q: use SCHEME:NAME, with a scheme of %s", ref, strings.Join(Schemes(), ", "))
+	}
+	if _, ok := provider(scheme); !ok {
+		return fmt.Errorf("invalid secret reference %q: no %s secrets provider is configured", ref, scheme)
+	}
+	return nil
+}
+
+// Resolve returns the value of a secret reference. "env:NAME" reads the
+// environment variable EnvPrefix+NAME and "file:NAME" the file NAME of the
+// secrets directory; other schemes are read by their registered provider.
+// Secrets are read on every call, so rotated values apply from their next
+// use.
+func Resolve(ref string) ([]byte, error) {
+	if err := Validate(ref); err != nil {
+		return nil, err
+	}
+	scheme, name, _ := strings.Cut(ref, ":")
+	p, _ := provider(scheme)
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	value, err := p.Resolve(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	return value, nil
+}
+
+// envProvider reads env:NAME references from EnvPrefix+NAME
+type envProvider struct{}
+
+func (envProvider) Resolve(ctx context.Context, name string) ([]byte, error) {
+	value, ok := os.LookupEnv(EnvPrefix + name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(value), nil
+}
+
+// fileProvider reads file:NAME references from the secrets directory
+type fileProvider struct{}
+
+func (fileProvider) Resolve(ctx context.Context, name string) ([]byte, error) {
+	mu.RLock()
+	root := dir
+	mu.RUnlock()
+	if root == "" {
+		return nil, errors.New("no secrets directory configured")
+	}
+	// Cleaning the name as an absolute path drops any leading "..", so it
+	// cannot climb out of the directory
+	value, err := os.ReadFile(filepath.Join(root, filepath.Clean("/"+name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
@@ -0,0 +1,364 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// vault reads vault:PATH#KEY references from HashiCorp Vault. Paths under
+// one of the dynamic mounts, such as database/creds/ROLE, issue leased
+// credentials: every field of a path is read from the same lease, which
+// is renewed until Vault refuses, then replaced by new credentials that
+// watchers are told about. Other paths read the latest version of a KV
+// version 2 secret. Without #KEY all fields are returned as a JSON object.
+type vault struct {
+	cfg    config.VaultConfig
+	client *http.Client
+	kv     Provider
+
+	// issueMu serializes the issue of credentials, so that concurrent
+	// first reads of a path share one lease
+	issueMu sync.Mutex
+	mu      sync.Mutex
+	leases  map[string]*lease
+	// tokenRenewAt is when the token is next renewed; zero until the token
+	// was looked up
+	tokenRenewAt time.Time
+	tokenTTL     time.Duration
+}
+
+// lease is the lease of dynamic credentials
+type lease struct {
+	id        string
+	renewable bool
+	duration  time.Duration
+	data      map[string]json.RawMessage
+	// renewAt is two thirds into the lease, leaving time to replace the
+	// credentials when Vault refuses to renew them
+	renewAt time.Time
+}
+
+// leaseResponse is the lease part of Vault responses
+type leaseResponse struct {
+	LeaseID       string                     `json:"lease_id"`
+	Renewable     bool                       `json:"renewable"`
+	LeaseDuration int                        `json:"lease_duration"`
+	Data          map[string]json.RawMessage `json:"data"`
+}
+
+func newVault(cfg config.VaultConfig) *vault {
+	v := &vault{
+		cfg:    cfg,
+		client: &http.Client{Timeout: resolveTimeout},
+		leases: make(map[string]*lease),
+	}
+	v.kv = cached(kvReader{v})
+	return v
+}
+
+// token is the configured token, or VAULT_TOKEN as Vault's own tools use
+func (v *vault) token() string {
+	if v.cfg.Token != "" {
+		return v.cfg.Token
+	}
+	return os.Getenv("VAULT_TOKEN")
+}
+
+func (v *vault) Resolve(ctx context.Context, name string) ([]byte, error) {
+	path, key, _ := strings.Cut(name, "#")
+	path = strings.Trim(path, "/")
+	if !v.dynamic(path) {
+		return v.kv.Resolve(ctx, name)
+	}
+	l, err := v.lease(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return json.Marshal(l.data)
+	}
+	return field(l.data, key)
+}
+
+// dynamic reports whether path is under one of the dynamic mounts
+func (v *vault) dynamic(path string) bool {
+	for _, mount := range v.cfg.DynamicMounts {
+		if mount = strings.Trim(mount, "/"); mount != "" && strings.HasPrefix(path+"/", mount+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// lease returns the lease of path, issuing credentials on first read
+func (v *vault) lease(ctx context.Context, path string) (*lease, error) {
+	v.mu.Lock()
+	l, ok := v.leases[path]
+	v.mu.Unlock()
+	if ok {
+		return l, nil
+	}
+
+	v.issueMu.Lock()
+	defer v.issueMu.Unlock()
+	v.mu.Lock()
+	l, ok = v.leases[path]
+	v.mu.Unlock()
+	if ok {
+		return l, nil
+	}
+	l, err := v.issue(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	v.leases[path] = l
+	v.mu.Unlock()
+	return l, nil
+}
+
+// issue reads new credentials from path
+func (v *vault) issue(ctx context.Context, path string) (*lease, error) {
+	var resp leaseResponse
+	if err := v.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		return nil, ErrNotFound
+	}
+	l := &lease{id: resp.LeaseID, renewable: resp.Renewable, data: resp.Data}
+	l.extend(time.Duration(resp.LeaseDuration) * time.Second)
+	return l, nil
+}
+
+// extend sets the lease to last d from now
+func (l *lease) extend(d time.Duration) {
+	l.duration = d
+	l.renewAt = time.Now().Add(d * 2 / 3)
+}
+
+// renew renews the token and the leases due for renewal. Leases Vault no
+// longer renews, such as at their max TTL, are replaced by new credentials
+// and watchers told of their reference.
+func (v *vault) renew(ctx context.Context, logger *logrus.Logger) {
+	v.renewToken(ctx, logger)
+
+	now := time.Now()
+	due := make(map[string]*lease)
+	v.mu.Lock()
+	for path, l := range v.leases {
+		if l.duration > 0 && !now.Before(l.renewAt) {
+			due[path] = l
+		}
+	}
+	v.mu.Unlock()
+
+	for path, l := range due {
+		if l.renewable {
+			var resp leaseResponse
+			body := map[string]interface{}{"lease_id": l.id, "increment": int(l.duration.Seconds())}
+			err := v.do(ctx, http.MethodPut, "sys/leases/renew", body, &resp)
+			// A renewal cut short by the max TTL is the last one worth
+			// making
+			if err == nil && time.Duration(resp.LeaseDuration)*time.Second >= l.duration/2 {
+				v.mu.Lock()
+				l.renewAt = time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second * 2 / 3)
+				v.mu.Unlock()
+				continue
+			}
+			if err != nil {
+				logger.Warnf("Failed to renew Vault lease of %s; issuing new credentials: %v", path, err)
+			}
+		}
+
+		next, err := v.issue(ctx, path)
+		if err != nil {
+			// The current credentials stay in use; the next check retries
+			logger.Errorf("Failed to issue new Vault credentials for %s: %v", path, err)
+			continue
+		}
+		v.mu.Lock()
+		v.leases[path] = next
+		v.mu.Unlock()
+		logger.Infof("Issued new Vault credentials for %s", path)
+		notify(ctx, "vault:"+path)
+
+		// The watchers have moved to the new credentials, so the old ones
+		// are revoked rather than left valid until they expire
+		if l.id != "" {
+			body := map[string]interface{}{"lease_id": l.id}
+			if err := v.do(ctx, http.MethodPut, "sys/leases/revoke", body, nil); err != nil {
+				logger.Warnf("Failed to revoke the previous Vault lease of %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// renewToken renews a renewable token two thirds into its TTL
+func (v *vault) renewToken(ctx context.Context, logger *logrus.Logger) {
+	v.mu.Lock()
+	renewAt, ttl := v.tokenRenewAt, v.tokenTTL
+	v.mu.Unlock()
+	if time.Now().Before(renewAt) {
+		return
+	}
+
+	var next time.Duration
+	if renewAt.IsZero() {
+		var resp struct {
+			Data struct {
+				TTL       int  `json:"ttl"`
+				Renewable bool `json:"renewable"`
+			} `json:"data"`
+		}
+		if err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &resp); err != nil {
+			logger.Warnf("Failed to look up Vault token: %v", err)
+			return
+		}
+		if !resp.Data.Renewable || resp.Data.TTL <= 0 {
+			// Tokens without a TTL need no renewal, and others cannot have
+			// one
+			v.mu.Lock()
+			v.tokenRenewAt = time.Now().Add(100 * 365 * 24 * time.Hour)
+			v.mu.Unlock()
+			return
+		}
+		next = time.Duration(resp.Data.TTL) * time.Second
+		ttl = next
+	} else {
+		var resp struct {
+			Auth struct {
+				LeaseDuration int `json:"lease_duration"`
+			} `json:"auth"`
+		}
+		body := map[string]interface{}{"increment": int(ttl.Seconds())}
+		if err := v.do(ctx, http.MethodPut, "auth/token/renew-self", body, &resp); err != nil {
+			logger.Errorf("Failed to renew Vault token: %v", err)
+			return
+		}
+		next = time.Duration(resp.Auth.LeaseDuration) * time.Second
+	}
+
+	v.mu.Lock()
+	v.tokenTTL = ttl
+	v.tokenRenewAt = time.Now().Add(next * 2 / 3)
+	v.mu.Unlock()
+}
+
+// do makes a request to the Vault API at path, decoding the JSON response
+// into out unless it is nil
+func (v *vault) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := strings.TrimRight(v.cfg.Address, "/") + "/v1/" + escapePath(path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token())
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("vault returned %s: %s", resp.Status, vaultErrors(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid vault response: %w", err)
+	}
+	return nil
+}
+
+// kvReader reads the KV version 2 secrets of a vault
+type kvReader struct {
+	v *vault
+}
+
+func (r kvReader) Resolve(ctx context.Context, name string) ([]byte, error) {
+	path, key, _ := strings.Cut(name, "#")
+	var secret struct {
+		Data struct {
+			Data map[string]json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	err := r.v.do(ctx, http.MethodGet, strings.Trim(r.v.cfg.Mount, "/")+"/data/"+strings.Trim(path, "/"), nil, &secret)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Data.Data == nil {
+		// Deleted versions are returned without data
+		return nil, ErrNotFound
+	}
+	if key == "" {
+		return json.Marshal(secret.Data.Data)
+	}
+	return field(secret.Data.Data, key)
+}
+
+// escapePath escapes each segment of a secret path
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// vaultErrors returns the messages of a Vault error response
+func vaultErrors(body []byte) string {
+	var resp struct {
+		Errors []string `json:"errors"`
+	}
+	if json.Unmarshal(body, &resp) == nil && len(resp.Errors) > 0 {
+		return strings.Join(resp.Errors, "; ")
+	}
+	return http.StatusText(http.StatusInternalServerError)
+}
+
+// field returns the key field of a JSON secret: strings as they are, other
+// values as JSON
+func field(fields map[string]json.RawMessage, key string) ([]byte, error) {
+	raw, ok := fields[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s), nil
+	}
+	return raw, nil
+}
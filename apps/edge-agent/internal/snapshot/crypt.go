@@ -0,0 +1,221 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// magic identifies an encrypted snapshot, version 1 of the format
+var magic = []byte("FFSNAP\x00\x01")
+
+const (
+	saltSize   = 16
+	prefixSize = 4
+	headerSize = 8 + saltSize + prefixSize
+	// chunkSize is the plaintext size of every chunk but the last
+	chunkSize = 64 << 10
+	// finalFlag marks the last chunk in its length frame
+	finalFlag = 1 << 31
+
+	// scrypt cost parameters; deriving a key takes about 100ms on a
+	// Raspberry Pi class device
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	// ErrFormat is returned for input that is not a snapshot
+	ErrFormat = errors.New("not a FusionFlow snapshot")
+	// ErrDecrypt is returned when a chunk fails authentication, which means a
+	// wrong passphrase or a modified archive
+	ErrDecrypt = errors.New("failed to decrypt snapshot: wrong passphrase or corrupted archive")
+	// ErrTruncated is returned when the archive ends before its last chunk
+	ErrTruncated = errors.New("snapshot is truncated")
+)
+
+// deriveKey stretches passphrase into an AES-256 key
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("snapshot passphrase is required")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive snapshot key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypter seals its input into AES-256-GCM chunks. Each chunk is framed by
+// its plaintext length, and the frame, the file header and the chunk index
+// are all authenticated, so chunks cannot be reordered, dropped or cut short
+// without Open failing.
+type encrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint64
+	buf    []byte
+	out    []byte
+}
+
+// newEncrypter writes the snapshot header to w and returns a writer that
+// encrypts into it. Close must be called to write the final chunk.
+func newEncrypter(w io.Writer, passphrase string) (*encrypter, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	if _, err := rand.Read(header[len(magic):]); err != nil {
+		return nil, fmt.Errorf("failed to generate snapshot salt: %w", err)
+	}
+	aead, err := deriveKey(passphrase, header[len(magic):len(magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(magic)+saltSize:])
+	return &encrypter{w: w, aead: aead, header: header, nonce: nonce, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		c := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (e *encrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encrypter) seal(final bool) error {
+	frame := uint32(len(e.buf))
+	if final {
+		frame |= finalFlag
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], frame)
+
+	binary.BigEndian.PutUint64(e.nonce[prefixSize:], e.index)
+	e.index++
+	e.out = e.aead.Seal(append(e.out[:0], lenBuf[:]...), e.nonce, e.buf, chunkAAD(e.header, lenBuf[:]))
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// decrypter reverses encrypter
+type decrypter struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	index  uint64
+	in     []byte
+	plain  []byte
+	done   bool
+}
+
+// newDecrypter reads the snapshot header from r and returns a reader of the
+// decrypted archive
+func newDecrypter(r io.Reader, passphrase string) (*decrypter, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return nil, ErrFormat
+	}
+	aead, err := deriveKey(passphrase, header[len(magic):len(magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(magic)+saltSize:])
+	return &decrypter{r: r, aead: aead, header: header, nonce: nonce}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk
+func (d *decrypter) open() error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(d.r, lenBuf[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	frame := binary.BigEndian.Uint32(lenBuf[:])
+	final := frame&finalFlag != 0
+	size := int(frame &^ finalFlag)
+	if size > chunkSize || (!final && size != chunkSize) {
+		return ErrDecrypt
+	}
+
+	if cap(d.in) < size+d.aead.Overhead() {
+		d.in = make([]byte, size+d.aead.Overhead())
+	}
+	d.in = d.in[:size+d.aead.Overhead()]
+	if _, err := io.ReadFull(d.r, d.in); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	binary.BigEndian.PutUint64(d.nonce[prefixSize:], d.index)
+	d.index++
+	plain, err := d.aead.Open(d.in[:0], d.nonce, d.in, chunkAAD(d.header, lenBuf[:]))
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.plain = plain
+	if final {
+		d.done = true
+		// Anything after the final chunk was appended to the archive
+		if n, _ := d.r.Read(lenBuf[:1]); n > 0 {
+			return ErrDecrypt
+		}
+	}
+	return nil
+}
+
+func chunkAAD(header, frame []byte) []byte {
+	aad := make([]byte, 0, len(header)+len(frame))
+	return append(append(aad, header...), frame...)
+}
@@ -0,0 +1,412 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// FormatVersion is the version of the archive layout written by Create
+const FormatVersion = 1
+
+// pageSize is the number of records per archive entry and per restore
+// transaction
+const pageSize = 500
+
+// ErrNotEmpty is returned by Restore when the target store already holds
+// data and Force is not set
+var ErrNotEmpty = errors.New("store is not empty")
+
+// Manifest describes a snapshot. It is the first entry of the archive.
+type Manifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+	SchemaVersion int       `json:"schemaVersion"`
+	Buckets       []string  `json:"buckets"`
+	Config        bool      `json:"config"`
+}
+
+// Options configure Create
+type Options struct {
+	SchemaVersion int
+	// Exclude lists buckets left out of the snapshot, e.g. executions when
+	// cloning a site template; their archive buckets are left out too
+	Exclude []string
+	// Config is the agent's configuration file, stored alongside the data
+	Config []byte
+}
+
+// Summary reports what a snapshot holds
+type Summary struct {
+	Manifest Manifest       `json:"manifest"`
+	Records  map[string]int `json:"records"`
+	Bytes    int64          `json:"bytes"`
+}
+
+// RestoreOptions configure Restore
+type RestoreOptions struct {
+	// Force replaces the data of a store that is not empty
+	Force bool
+	// MaxSchemaVersion rejects snapshots of a newer store schema than this
+	// agent can migrate; 0 disables the check
+	MaxSchemaVersion int
+}
+
+// Restored is the result of Restore
+type Restored struct {
+	Summary
+	// Config is the configuration file from the snapshot, if it has one
+	Config []byte `json:"-"`
+}
+
+// Create writes an encrypted snapshot of every bucket in st, and of the
+// configuration in opts, to w. Each bucket is read in one List call, so a
+// snapshot of a running agent is consistent per bucket but not across
+// buckets; flush buffered writes before calling it.
+func Create(ctx context.Context, st store.Store, w io.Writer, passphrase string, opts Options) (*Summary, error) {
+	lister, ok := store.As[store.BucketLister](st)
+	if !ok {
+		return nil, errors.New("store does not support snapshots")
+	}
+	names, err := lister.Buckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]bool, 2*len(opts.Exclude))
+	for _, name := range opts.Exclude {
+		excluded[name] = true
+		excluded[store.ArchiveBucket(name)] = true
+	}
+	manifest := Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		SchemaVersion: opts.SchemaVersion,
+		Buckets:       []string{},
+		Config:        opts.Config != nil,
+	}
+	for _, name := range names {
+		if !excluded[name] {
+			manifest.Buckets = append(manifest.Buckets, name)
+		}
+	}
+
+	counter := &countingWriter{w: w}
+	enc, err := newEncrypter(counter, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+
+	summary := &Summary{Manifest: manifest, Records: make(map[string]int, len(manifest.Buckets))}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot manifest: %w", err)
+	}
+	if err := writeEntry(tw, "manifest.json", manifest.CreatedAt, manifestJSON); err != nil {
+		return nil, err
+	}
+	if opts.Config != nil {
+		if err := writeEntry(tw, "config.yaml", manifest.CreatedAt, opts.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	var page bytes.Buffer
+	for _, name := range manifest.Buckets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		records, err := st.List(ctx, name, store.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bucket %s: %w", name, err)
+		}
+		for i := 0; i < len(records); i += pageSize {
+			page.Reset()
+			enc := json.NewEncoder(&page)
+			for _, rec := range records[i:min(i+pageSize, len(records))] {
+				if err := enc.Encode(rec); err != nil {
+					return nil, fmt.Errorf("failed to encode record %s/%s: %w", name, rec.Key, err)
+				}
+			}
+			entry := path.Join("buckets", name, fmt.Sprintf("%06d.jsonl", i/pageSize))
+			if err := writeEntry(tw, entry, manifest.CreatedAt, page.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+		summary.Records[name] = len(records)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	summary.Bytes = counter.n
+	return summary, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write snapshot entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write snapshot entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// Inspect reads and authenticates a whole snapshot without restoring it,
+// reporting its manifest and record counts. Restore only detects a damaged
+// or truncated archive when it reaches the damage, after clearing buckets,
+// so callers inspect an archive before restoring it when they can read it
+// twice.
+func Inspect(r io.Reader, passphrase string) (*Restored, error) {
+	return read(r, passphrase, func(m *Manifest) error { return nil }, func(bucket string, records []*store.Record) error { return nil })
+}
+
+// Restore loads an encrypted snapshot from r into st. The buckets in the
+// snapshot replace those in st; other buckets are left alone. Records keep
+// their creation time. A store holding anything but schema metadata is only
+// overwritten with Force.
+//
+// Buckets are loaded in transactions of pageSize records, so a restore that
+// fails part way leaves the store partially restored; run it again to
+// completion before starting the agent.
+func Restore(ctx context.Context, st store.Store, r io.Reader, passphrase string, opts RestoreOptions) (*Restored, error) {
+	begin := func(manifest *Manifest) error {
+		if opts.MaxSchemaVersion > 0 && manifest.SchemaVersion > opts.MaxSchemaVersion {
+			return fmt.Errorf("snapshot schema version %d is newer than this agent supports (%d)", manifest.SchemaVersion, opts.MaxSchemaVersion)
+		}
+		if !opts.Force {
+			if err := checkEmpty(ctx, st); err != nil {
+				return err
+			}
+		}
+		for _, name := range manifest.Buckets {
+			if err := clearBucket(ctx, st, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	load := func(bucket string, records []*store.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := st.Update(ctx, func(tx store.Tx) error {
+			for _, rec := range records {
+				if err := tx.Put(bucket, rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore bucket %s: %w", bucket, err)
+		}
+		return nil
+	}
+	return read(r, passphrase, begin, load)
+}
+
+// read decrypts and walks a snapshot, calling begin with its manifest and
+// then page with the records of every bucket entry
+func read(r io.Reader, passphrase string, begin func(m *Manifest) error, page func(bucket string, records []*store.Record) error) (*Restored, error) {
+	counter := &countingReader{r: r}
+	dec, err := newDecrypter(counter, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported snapshot format version %d", manifest.FormatVersion)
+	}
+	if err := begin(manifest); err != nil {
+		return nil, err
+	}
+
+	restored := &Restored{Summary: Summary{Manifest: *manifest, Records: make(map[string]int, len(manifest.Buckets))}}
+	inManifest := make(map[string]bool, len(manifest.Buckets))
+	for _, name := range manifest.Buckets {
+		inManifest[name] = true
+		restored.Records[name] = 0
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, snapshotError(err)
+		}
+
+		switch {
+		case hdr.Name == "config.yaml":
+			if restored.Config, err = io.ReadAll(tr); err != nil {
+				return nil, snapshotError(err)
+			}
+		case strings.HasPrefix(hdr.Name, "buckets/"):
+			name := path.Dir(strings.TrimPrefix(hdr.Name, "buckets/"))
+			if !inManifest[name] {
+				return nil, fmt.Errorf("snapshot entry %s is not in the manifest", hdr.Name)
+			}
+			records, err := readPage(name, tr)
+			if err != nil {
+				return nil, err
+			}
+			if err := page(name, records); err != nil {
+				return nil, err
+			}
+			restored.Records[name] += len(records)
+		}
+	}
+
+	// Read through the end of the compressed stream so its checksum and
+	// the final encrypted chunk are verified
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, snapshotError(err)
+	}
+	restored.Bytes = counter.n
+	return restored, nil
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	if hdr.Name != "manifest.json" {
+		return nil, ErrFormat
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// checkEmpty fails with ErrNotEmpty when st holds records outside the
+// schema metadata, which even a freshly migrated store has
+func checkEmpty(ctx context.Context, st store.Store) error {
+	lister, ok := store.As[store.BucketLister](st)
+	if !ok {
+		return errors.New("store does not support snapshots")
+	}
+	names, err := lister.Buckets(ctx)
+	if err != nil {
+		return err
+	}
+	var used []string
+	for _, name := range names {
+		if name != store.BucketMeta {
+			used = append(used, name)
+		}
+	}
+	if len(used) > 0 {
+		sort.Strings(used)
+		return fmt.Errorf("%w: it holds %s", ErrNotEmpty, strings.Join(used, ", "))
+	}
+	return nil
+}
+
+// clearBucket deletes every record of a bucket
+func clearBucket(ctx context.Context, st store.Store, bucket string) error {
+	records, err := st.List(ctx, bucket, store.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read bucket %s: %w", bucket, err)
+	}
+	for i := 0; i < len(records); i += pageSize {
+		page := records[i:min(i+pageSize, len(records))]
+		err := st.Update(ctx, func(tx store.Tx) error {
+			for _, rec := range page {
+				if err := tx.Delete(bucket, rec.Key); err != nil && !errors.Is(err, store.ErrNotFound) {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clear bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// readPage decodes the records of one archive entry
+func readPage(bucket string, r io.Reader) ([]*store.Record, error) {
+	// Read the whole entry first so that a damaged archive is reported as
+	// such rather than as a cut-off record
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	var records []*store.Record
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var rec store.Record
+		if err := dec.Decode(&rec); err != nil {
+			return nil, fmt.Errorf("failed to decode record in bucket %s: %w", bucket, err)
+		}
+		records = append(records, &rec)
+	}
+	return records, nil
+}
+
+// snapshotError passes decryption errors through and wraps others, which
+// come from a malformed archive
+func snapshotError(err error) error {
+	if errors.Is(err, ErrDecrypt) || errors.Is(err, ErrTruncated) {
+		return err
+	}
+	return fmt.Errorf("failed to read snapshot: %w", err)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,385 @@
+package snmp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// wellKnown are the names resolved without loading any MIB file: the roots
+// of the OID tree and the objects of SNMPv2-MIB, IF-MIB and the standard
+// notifications most devices report
+var wellKnown = []struct{ name, oid string }{
+	{"iso", "1"},
+	{"org", "1.3"},
+	{"dod", "1.3.6"},
+	{"internet", "1.3.6.1"},
+	{"directory", "1.3.6.1.1"},
+	{"mgmt", "1.3.6.1.2"},
+	{"mib-2", "1.3.6.1.2.1"},
+	{"system", "1.3.6.1.2.1.1"},
+	{"sysDescr", "1.3.6.1.2.1.1.1"},
+	{"sysObjectID", "1.3.6.1.2.1.1.2"},
+	{"sysUpTime", "1.3.6.1.2.1.1.3"},
+	{"sysContact", "1.3.6.1.2.1.1.4"},
+	{"sysName", "1.3.6.1.2.1.1.5"},
+	{"sysLocation", "1.3.6.1.2.1.1.6"},
+	{"sysServices", "1.3.6.1.2.1.1.7"},
+	{"interfaces", "1.3.6.1.2.1.2"},
+	{"ifNumber", "1.3.6.1.2.1.2.1"},
+	{"ifTable", "1.3.6.1.2.1.2.2"},
+	{"ifEntry", "1.3.6.1.2.1.2.2.1"},
+	{"ifIndex", "1.3.6.1.2.1.2.2.1.1"},
+	{"ifDescr", "1.3.6.1.2.1.2.2.1.2"},
+	{"ifType", "1.3.6.1.2.1.2.2.1.3"},
+	{"ifMtu", "1.3.6.1.2.1.2.2.1.4"},
+	{"ifSpeed", "1.3.6.1.2.1.2.2.1.5"},
+	{"ifPhysAddress", "1.3.6.1.2.1.2.2.1.6"},
+	{"ifAdminStatus", "1.3.6.1.2.1.2.2.1.7"},
+	{"ifOperStatus", "1.3.6.1.2.1.2.2.1.8"},
+	{"ifLastChange", "1.3.6.1.2.1.2.2.1.9"},
+	{"ifInOctets", "1.3.6.1.2.1.2.2.1.10"},
+	{"ifInUcastPkts", "1.3.6.1.2.1.2.2.1.11"},
+	{"ifInDiscards", "1.3.6.1.2.1.2.2.1.13"},
+	{"ifInErrors", "1.3.6.1.2.1.2.2.1.14"},
+	{"ifOutOctets", "1.3.6.1.2.1.2.2.1.16"},
+	{"ifOutUcastPkts", "1.3.6.1.2.1.2.2.1.17"},
+	{"ifOutDiscards", "1.3.6.1.2.1.2.2.1.19"},
+	{"ifOutErrors", "1.3.6.1.2.1.2.2.1.20"},
+	{"ip", "1.3.6.1.2.1.4"},
+	{"tcp", "1.3.6.1.2.1.6"},
+	{"udp", "1.3.6.1.2.1.7"},
+	{"snmp", "1.3.6.1.2.1.11"},
+	{"ifXTable", "1.3.6.1.2.1.31.1.1"},
+	{"ifXEntry", "1.3.6.1.2.1.31.1.1.1"},
+	{"ifName", "1.3.6.1.2.1.31.1.1.1.1"},
+	{"ifHCInOctets", "1.3.6.1.2.1.31.1.1.1.6"},
+	{"ifHCOutOctets", "1.3.6.1.2.1.31.1.1.1.10"},
+	{"ifHighSpeed", "1.3.6.1.2.1.31.1.1.1.15"},
+	{"ifAlias", "1.3.6.1.2.1.31.1.1.1.18"},
+	{"experimental", "1.3.6.1.3"},
+	{"private", "1.3.6.1.4"},
+	{"enterprises", "1.3.6.1.4.1"},
+	{"security", "1.3.6.1.5"},
+	{"snmpV2", "1.3.6.1.6"},
+	{"snmpModules", "1.3.6.1.6.3"},
+	{"snmpTrapOID", "1.3.6.1.6.3.1.1.4.1"},
+	{"snmpTrapEnterprise", "1.3.6.1.6.3.1.1.4.3"},
+	{"snmpTraps", "1.3.6.1.6.3.1.1.5"},
+	{"coldStart", "1.3.6.1.6.3.1.1.5.1"},
+	{"warmStart", "1.3.6.1.6.3.1.1.5.2"},
+	{"linkDown", "1.3.6.1.6.3.1.1.5.3"},
+	{"linkUp", "1.3.6.1.6.3.1.1.5.4"},
+	{"authenticationFailure", "1.3.6.1.6.3.1.1.5.5"},
+}
+
+// MIB maps object names to OIDs and back
+type MIB struct {
+	names map[string]string
+	oids  map[string]string
+}
+
+// definition is an object assigned in a MIB module, as a path of arcs under
+// a parent name
+type definition struct {
+	name   string
+	parent string
+	arcs   []string
+}
+
+var (
+	mibsMu sync.Mutex
+	mibs   = make(map[string]*MIB)
+)
+
+// LoadMIB returns the well-known names extended with the definitions of
+// the MIB modules at paths, each a file or a directory of them. MIBs are
+// parsed once per set of paths.
+func LoadMIB(paths []string) (*MIB, error) {
+	key := strings.Join(paths, "\x00")
+	mibsMu.Lock()
+	defer mibsMu.Unlock()
+	if m, ok := mibs[key]; ok {
+		return m, nil
+	}
+
+	m := &MIB{names: make(map[string]string), oids: make(map[string]string)}
+	for _, wk := range wellKnown {
+		m.add(wk.name, wk.oid)
+	}
+	var defs []definition
+	for _, p := range paths {
+		files, err := mibFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read MIB %s: %w", file, err)
+			}
+			defs = append(defs, parseMIB(string(data))...)
+		}
+	}
+	m.resolve(defs)
+	mibs[key] = m
+	return m, nil
+}
+
+func mibFiles(p string) ([]string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MIBs: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MIBs: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(p, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (m *MIB) add(name, oid string) {
+	if _, ok := m.names[name]; !ok {
+		m.names[name] = oid
+	}
+	if _, ok := m.oids[oid]; !ok {
+		m.oids[oid] = name
+	}
+}
+
+// resolve assigns OIDs to the definitions whose parents are known, in as
+// many passes as modules importing each other need
+func (m *MIB) resolve(defs []definition) {
+	for len(defs) > 0 {
+		var pending []definition
+		for _, d := range defs {
+			parent, ok := m.names[d.parent]
+			if !ok {
+				pending = append(pending, d)
+				continue
+			}
+			m.add(d.name, parent+"."+strings.Join(d.arcs, "."))
+		}
+		if len(pending) == len(defs) {
+			// The rest hang off modules that were not loaded
+			return
+		}
+		defs = pending
+	}
+}
+
+// Resolve returns the numeric OID of a name such as "sysDescr.0" or
+// "IF-MIB::ifDescr.3". Numeric OIDs are returned as they are, without a
+// leading dot.
+func (m *MIB) Resolve(name string) (string, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "", fmt.Errorf("empty OID")
+	}
+	if i := strings.Index(name, "::"); i >= 0 {
+		name = name[i+2:]
+	}
+	base, suffix := name, ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		base, suffix = name[:i], name[i:]
+	}
+	if isNumber(base) {
+		if !validOID(name) {
+			return "", fmt.Errorf("invalid OID %q", name)
+		}
+		return name, nil
+	}
+	oid, ok := m.names[base]
+	if !ok {
+		return "", fmt.Errorf("unknown object %q: load the MIB defining it", base)
+	}
+	if suffix != "" && !validOID(suffix[1:]) {
+		return "", fmt.Errorf("invalid OID %q", name)
+	}
+	return oid + suffix, nil
+}
+
+// Name returns oid named after its closest known ancestor, such as
+// "ifDescr.3", or oid itself when none is known
+func (m *MIB) Name(oid string) string {
+	oid = strings.TrimPrefix(oid, ".")
+	for prefix := oid; prefix != ""; {
+		if name, ok := m.oids[prefix]; ok {
+			return name + oid[len(prefix):]
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return oid
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+func validOID(s string) bool {
+	for _, arc := range strings.Split(s, ".") {
+		if !isNumber(arc) {
+			return false
+		}
+	}
+	return true
+}
+
+// macros are the SMI constructs that assign an OID to the name before them
+var macros = map[string]bool{
+	"OBJECT-TYPE":        true,
+	"OBJECT-IDENTITY":    true,
+	"MODULE-IDENTITY":    true,
+	"NOTIFICATION-TYPE":  true,
+	"OBJECT-GROUP":       true,
+	"NOTIFICATION-GROUP": true,
+	"MODULE-COMPLIANCE":  true,
+	"AGENT-CAPABILITIES": true,
+}
+
+// parseMIB extracts the OID assignments of an SMIv1 or SMIv2 module. It
+// reads only as much of the grammar as naming needs: "name OBJECT
+// IDENTIFIER ::= { parent arc }" and the same value closing a macro such as
+// OBJECT-TYPE.
+func parseMIB(src string) []definition {
+	tokens := tokenizeMIB(src)
+	var defs []definition
+	pending := ""
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case macros[tok] && i > 0 && isValueName(tokens[i-1]):
+			pending = tokens[i-1]
+		case tok == "OBJECT" && i > 0 && i+1 < len(tokens) && tokens[i+1] == "IDENTIFIER" && isValueName(tokens[i-1]):
+			pending = tokens[i-1]
+		case tok == "::=":
+			name := pending
+			pending = ""
+			if name == "" || i+1 >= len(tokens) || tokens[i+1] != "{" {
+				continue
+			}
+			var parts []string
+			j := i + 2
+			for ; j < len(tokens) && tokens[j] != "}"; j++ {
+				parts = append(parts, tokens[j])
+			}
+			i = j
+			if d, ok := parseOIDValue(name, parts); ok {
+				defs = append(defs, d)
+			}
+		}
+	}
+	return defs
+}
+
+// parseOIDValue reads the components of "{ parent 1 }" or "{ iso org(3) 6 }"
+func parseOIDValue(name string, parts []string) (definition, bool) {
+	d := definition{name: name}
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		switch {
+		case isNumber(part):
+			if d.parent == "" {
+				return d, false
+			}
+			d.arcs = append(d.arcs, part)
+		case i+1 < len(parts) && parts[i+1] == "(":
+			// name(number)
+			if i+3 >= len(parts) || !isNumber(parts[i+2]) || parts[i+3] != ")" {
+				return d, false
+			}
+			if i == 0 {
+				d.parent = part
+			} else {
+				d.arcs = append(d.arcs, parts[i+2])
+			}
+			i += 3
+		case i == 0 && isValueName(part):
+			d.parent = part
+		default:
+			return d, false
+		}
+	}
+	if d.parent == "" || len(d.arcs) == 0 {
+		return d, false
+	}
+	return d, true
+}
+
+// isValueName reports whether tok is an SMI value name, which starts with a
+// lowercase letter
+func isValueName(tok string) bool {
+	return tok != "" && unicode.IsLower(rune(tok[0]))
+}
+
+// tokenizeMIB splits an SMI module into identifiers, numbers and symbols,
+// dropping comments and quoted strings
+func tokenizeMIB(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			// Comments run to the next "--" or the end of the line
+			end := strings.IndexAny(src[i+2:], "\n")
+			if next := strings.Index(src[i+2:], "--"); next >= 0 && (end < 0 || next < end) {
+				i += next + 4
+				continue
+			}
+			if end < 0 {
+				return tokens
+			}
+			i += end + 3
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 2
+		case strings.HasPrefix(src[i:], "::="):
+			tokens = append(tokens, "::=")
+			i += 3
+		case c == '{' || c == '}' || c == '(' || c == ')' || c == ',' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case unicode.IsSpace(rune(c)):
+			i++
+		default:
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || (src[j] == '-' && !strings.HasPrefix(src[j:], "--"))) {
+				j++
+			}
+			if j == i {
+				// Any other symbol
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,357 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Config describes an SNMP agent and how to authenticate with it. Shared by
+// the snmp connector and triggers.
+//
+// Version is "1", "2c" (the default) or "3". Versions 1 and 2c authenticate
+// with Community; version 3 with Username and, depending on SecurityLevel
+// (noAuthNoPriv, authNoPriv or authPriv), the authentication and privacy
+// protocols and passphrases. MIBs lists the MIB files, or directories of
+// them, whose object names are resolved besides the standard ones.
+type Config struct {
+	Target         string   `json:"target"`
+	Port           int      `json:"port"`
+	Version        string   `json:"version"`
+	Community      string   `json:"community"`
+	Username       string   `json:"username"`
+	SecurityLevel  string   `json:"securityLevel"`
+	AuthProtocol   string   `json:"authProtocol"`
+	AuthPassphrase string   `json:"authPassphrase"`
+	PrivProtocol   string   `json:"privProtocol"`
+	PrivPassphrase string   `json:"privPassphrase"`
+	ContextName    string   `json:"contextName"`
+	MIBs           []string `json:"mibs"`
+	// Timeout bounds each request, default 5s, which is retried Retries
+	// times
+	Timeout string `json:"timeout"`
+	Retries *int   `json:"retries"`
+}
+
+// Variable is a value read from an agent, named after the MIB
+type Variable struct {
+	OID   string      `json:"oid"`
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+var (
+	authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"MD5": gosnmp.MD5, "SHA": gosnmp.SHA, "SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256, "SHA384": gosnmp.SHA384, "SHA512": gosnmp.SHA512,
+	}
+	privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"DES": gosnmp.DES, "AES": gosnmp.AES, "AES192": gosnmp.AES192,
+		"AES256": gosnmp.AES256, "AES192C": gosnmp.AES192C, "AES256C": gosnmp.AES256C,
+	}
+)
+
+// Validate checks the security settings; Target is checked by NewClient,
+// since trap receivers have none
+func (c *Config) Validate() error {
+	if _, err := c.version(); err != nil {
+		return err
+	}
+	if _, err := c.timeout(); err != nil {
+		return err
+	}
+	_, _, err := c.security()
+	return err
+}
+
+func (c *Config) version() (gosnmp.SnmpVersion, error) {
+	switch c.Version {
+	case "1":
+		return gosnmp.Version1, nil
+	case "", "2c":
+		return gosnmp.Version2c, nil
+	case "3":
+		return gosnmp.Version3, nil
+	}
+	return 0, fmt.Errorf("invalid SNMP version %q: must be 1, 2c or 3", c.Version)
+}
+
+func (c *Config) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 5 * time.Second, nil
+	}
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("timeout %q is not a positive duration", c.Timeout)
+	}
+	return d, nil
+}
+
+// security returns the version 3 message flags and user parameters
+func (c *Config) security() (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters, error) {
+	if c.Version != "3" {
+		return gosnmp.NoAuthNoPriv, nil, nil
+	}
+	if c.Username == "" {
+		return 0, nil, errors.New("SNMPv3 requires a username")
+	}
+	params := &gosnmp.UsmSecurityParameters{UserName: c.Username, AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv}
+	level := c.SecurityLevel
+	if level == "" {
+		level = "authPriv"
+	}
+	var flags gosnmp.SnmpV3MsgFlags
+	switch level {
+	case "noAuthNoPriv":
+		return gosnmp.NoAuthNoPriv, params, nil
+	case "authNoPriv":
+		flags = gosnmp.AuthNoPriv
+	case "authPriv":
+		flags = gosnmp.AuthPriv
+	default:
+		return 0, nil, fmt.Errorf("invalid securityLevel %q: must be noAuthNoPriv, authNoPriv or authPriv", c.SecurityLevel)
+	}
+
+	auth, ok := authProtocols[strings.ToUpper(c.AuthProtocol)]
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid authProtocol %q", c.AuthProtocol)
+	}
+	if c.AuthPassphrase == "" {
+		return 0, nil, errors.New("authPassphrase is required")
+	}
+	params.AuthenticationProtocol, params.AuthenticationPassphrase = auth, c.AuthPassphrase
+	if flags == gosnmp.AuthPriv {
+		priv, ok := privProtocols[strings.ToUpper(c.PrivProtocol)]
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid privProtocol %q", c.PrivProtocol)
+		}
+		if c.PrivPassphrase == "" {
+			return 0, nil, errors.New("privPassphrase is required")
+		}
+		params.PrivacyProtocol, params.PrivacyPassphrase = priv, c.PrivPassphrase
+	}
+	return flags, params, nil
+}
+
+// params returns the gosnmp session settings of c, without a target
+func (c *Config) params(ctx context.Context) (*gosnmp.GoSNMP, error) {
+	version, err := c.version()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+	flags, usm, err := c.security()
+	if err != nil {
+		return nil, err
+	}
+	retries := 1
+	if c.Retries != nil {
+		retries = *c.Retries
+	}
+	community := c.Community
+	if community == "" {
+		community = "public"
+	}
+	g := &gosnmp.GoSNMP{
+		Port:           161,
+		Transport:      "udp",
+		Community:      community,
+		Version:        version,
+		Context:        ctx,
+		Timeout:        timeout,
+		Retries:        retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: 25,
+		ContextName:    c.ContextName,
+	}
+	if c.Port != 0 {
+		g.Port = uint16(c.Port)
+	}
+	if usm != nil {
+		g.SecurityModel, g.MsgFlags, g.SecurityParameters = gosnmp.UserSecurityModel, flags, usm
+	}
+	return g, nil
+}
+
+// Client is a session with one agent. It serves one request at a time.
+type Client struct {
+	snmp *gosnmp.GoSNMP
+	mib  *MIB
+}
+
+// NewClient opens a session with the agent at cfg.Target. Requests are
+// cancelled with ctx.
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Target == "" {
+		return nil, errors.New("target is required")
+	}
+	mib, err := LoadMIB(cfg.MIBs)
+	if err != nil {
+		return nil, err
+	}
+	g, err := cfg.params(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.Target = cfg.Target
+	if err := g.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.Target, err)
+	}
+	return &Client{snmp: g, mib: mib}, nil
+}
+
+// MIB returns the names the client resolves
+func (c *Client) MIB() *MIB {
+	return c.mib
+}
+
+// Get reads the given objects, names or numeric OIDs, in as many requests
+// as the agent's limit on variables takes
+func (c *Client) Get(oids []string) ([]Variable, error) {
+	resolved, err := c.resolve(oids)
+	if err != nil {
+		return nil, err
+	}
+	var vars []Variable
+	for start := 0; start < len(resolved); start += c.snmp.MaxOids {
+		end := min(start+c.snmp.MaxOids, len(resolved))
+		packet, err := c.snmp.Get(resolved[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("SNMP get failed: %w", err)
+		}
+		if packet.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("SNMP get failed: agent returned %s for variable %d", packet.Error, packet.ErrorIndex)
+		}
+		for _, pdu := range packet.Variables {
+			vars = append(vars, c.mib.Variable(pdu))
+		}
+	}
+	return vars, nil
+}
+
+// Walk reads the subtree under oid, with GETBULK unless the agent speaks
+// version 1 only
+func (c *Client) Walk(oid string) ([]Variable, error) {
+	resolved, err := c.mib.Resolve(oid)
+	if err != nil {
+		return nil, err
+	}
+	var pdus []gosnmp.SnmpPDU
+	if c.snmp.Version == gosnmp.Version1 {
+		pdus, err = c.snmp.WalkAll(resolved)
+	} else {
+		pdus, err = c.snmp.BulkWalkAll(resolved)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SNMP walk of %s failed: %w", oid, err)
+	}
+	vars := make([]Variable, 0, len(pdus))
+	for _, pdu := range pdus {
+		vars = append(vars, c.mib.Variable(pdu))
+	}
+	return vars, nil
+}
+
+func (c *Client) resolve(oids []string) ([]string, error) {
+	resolved := make([]string, 0, len(oids))
+	for _, oid := range oids {
+		r, err := c.mib.Resolve(oid)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// Close ends the session
+func (c *Client) Close() error {
+	if c.snmp.Conn == nil {
+		return nil
+	}
+	return c.snmp.Conn.Close()
+}
+
+// Variable converts a PDU into JSON-friendly values: printable octet
+// strings become text and others colon-separated hex, as MAC addresses are
+// shown; the exceptions noSuchObject, noSuchInstance and endOfMibView have
+// a nil value.
+func (m *MIB) Variable(pdu gosnmp.SnmpPDU) Variable {
+	oid := strings.TrimPrefix(pdu.Name, ".")
+	v := Variable{OID: oid, Name: m.Name(oid), Type: typeName(pdu.Type)}
+	switch value := pdu.Value.(type) {
+	case []byte:
+		v.Value = octets(value)
+	case string:
+		if pdu.Type == gosnmp.ObjectIdentifier {
+			value = strings.TrimPrefix(value, ".")
+		}
+		v.Value = value
+	default:
+		v.Value = value
+	}
+	switch pdu.Type {
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		v.Value = nil
+	}
+	return v
+}
+
+func octets(b []byte) string {
+	if utf8.Valid(b) {
+		printable := true
+		for _, r := range string(b) {
+			if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return string(b)
+		}
+	}
+	hex := make([]string, len(b))
+	for i, c := range b {
+		hex[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(hex, ":")
+}
+
+// typeNames are the ASN.1 types as the SMI names them
+var typeNames = map[gosnmp.Asn1BER]string{
+	gosnmp.Boolean:          "Boolean",
+	gosnmp.Integer:          "Integer",
+	gosnmp.BitString:        "BitString",
+	gosnmp.OctetString:      "OctetString",
+	gosnmp.Null:             "Null",
+	gosnmp.ObjectIdentifier: "ObjectIdentifier",
+	gosnmp.IPAddress:        "IpAddress",
+	gosnmp.Counter32:        "Counter32",
+	gosnmp.Gauge32:          "Gauge32",
+	gosnmp.TimeTicks:        "TimeTicks",
+	gosnmp.Opaque:           "Opaque",
+	gosnmp.Counter64:        "Counter64",
+	gosnmp.Uinteger32:       "Unsigned32",
+	gosnmp.OpaqueFloat:      "Float",
+	gosnmp.OpaqueDouble:     "Double",
+	gosnmp.NoSuchObject:     "noSuchObject",
+	gosnmp.NoSuchInstance:   "noSuchInstance",
+	gosnmp.EndOfMibView:     "endOfMibView",
+}
+
+func typeName(t gosnmp.Asn1BER) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", byte(t))
+}
@@ -0,0 +1,144 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// OIDs carried by SNMPv2 notifications ahead of their variables
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+	oidSnmpTraps   = "1.3.6.1.6.3.1.1.5"
+)
+
+// Trap is a notification received from an agent. Version 1 traps are given
+// the OID that RFC 3584 translates them to.
+type Trap struct {
+	Source       string     `json:"source"`
+	Version      string     `json:"version"`
+	Community    string     `json:"community,omitempty"`
+	Inform       bool       `json:"inform,omitempty"`
+	TrapOID      string     `json:"trapOid"`
+	TrapName     string     `json:"trapName"`
+	Enterprise   string     `json:"enterprise,omitempty"`
+	GenericTrap  *int       `json:"genericTrap,omitempty"`
+	SpecificTrap *int       `json:"specificTrap,omitempty"`
+	Uptime       uint       `json:"uptime"`
+	Variables    []Variable `json:"variables"`
+}
+
+// TrapReceiver listens for traps and informs on a UDP address. Informs are
+// acknowledged by the receiver; version 3 notifications are authenticated
+// with the user of its config.
+type TrapReceiver struct {
+	cfg      Config
+	mib      *MIB
+	listener *gosnmp.TrapListener
+
+	// Rejected counts notifications with the wrong community
+	Rejected atomic.Int64
+}
+
+// NewTrapReceiver creates a receiver for the notifications cfg describes.
+// A community, when set, must match that of version 1 and 2c traps.
+func NewTrapReceiver(cfg Config) (*TrapReceiver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	mib, err := LoadMIB(cfg.MIBs)
+	if err != nil {
+		return nil, err
+	}
+	return &TrapReceiver{cfg: cfg, mib: mib}, nil
+}
+
+// Listen starts receiving on address, passing each notification to handle
+// from the receiving goroutine, and returns once it is listening
+func (r *TrapReceiver) Listen(address string, handle func(Trap)) error {
+	params, err := r.cfg.params(context.Background())
+	if err != nil {
+		return err
+	}
+	r.listener = gosnmp.NewTrapListener()
+	r.listener.Params = params
+	r.listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, from *net.UDPAddr) {
+		if packet.Version != gosnmp.Version3 && r.cfg.Community != "" && packet.Community != r.cfg.Community {
+			r.Rejected.Add(1)
+			return
+		}
+		handle(r.mib.Trap(packet, from))
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- r.listener.Listen(address)
+	}()
+	select {
+	case <-r.listener.Listening():
+		return nil
+	case err := <-failed:
+		if err == nil {
+			err = fmt.Errorf("listener on %s closed", address)
+		}
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+}
+
+// Close stops receiving
+func (r *TrapReceiver) Close() {
+	if r.listener != nil {
+		r.listener.Close()
+	}
+}
+
+// Trap converts a received notification
+func (m *MIB) Trap(packet *gosnmp.SnmpPacket, from *net.UDPAddr) Trap {
+	t := Trap{Source: from.String(), Inform: packet.IsInform, Variables: []Variable{}}
+	switch packet.Version {
+	case gosnmp.Version1:
+		t.Version, t.Community = "1", packet.Community
+	case gosnmp.Version2c:
+		t.Version, t.Community = "2c", packet.Community
+	default:
+		t.Version = "3"
+	}
+
+	if packet.Version == gosnmp.Version1 {
+		generic, specific := packet.GenericTrap, packet.SpecificTrap
+		t.Enterprise = strings.TrimPrefix(packet.Enterprise, ".")
+		t.GenericTrap, t.SpecificTrap = &generic, &specific
+		t.Uptime = packet.Timestamp
+		if generic < 6 {
+			t.TrapOID = oidSnmpTraps + "." + strconv.Itoa(generic+1)
+		} else {
+			t.TrapOID = t.Enterprise + ".0." + strconv.Itoa(specific)
+		}
+	}
+	for _, pdu := range packet.Variables {
+		v := m.Variable(pdu)
+		switch v.OID {
+		case oidSysUpTime:
+			if ticks, ok := pdu.Value.(uint32); ok {
+				t.Uptime = uint(ticks)
+			} else if ticks, ok := pdu.Value.(uint); ok {
+				t.Uptime = ticks
+			}
+			continue
+		case oidSnmpTrapOID:
+			if oid, ok := v.Value.(string); ok {
+				t.TrapOID = oid
+			}
+			continue
+		}
+		t.Variables = append(t.Variables, v)
+	}
+	t.TrapName = m.Name(t.TrapOID)
+	return t
+}
@@ -0,0 +1,88 @@
+package steps
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+func init() {
+	registerCipher("age", newAge)
+}
+
+// ageCipher encrypts to age recipients and decrypts with the identities of
+// its key secret, streaming bodies of any size
+type ageCipher struct {
+	recipients []age.Recipient
+	key        string
+	armor      bool
+}
+
+func newAge(cfg cryptoConfig, decrypt bool) (payloadCipher, error) {
+	c := &ageCipher{key: cfg.Key, armor: cfg.Armor}
+	if decrypt {
+		if cfg.Key == "" {
+			return nil, errors.New("key is required")
+		}
+		return c, nil
+	}
+	if len(cfg.Recipients) == 0 {
+		return nil, errors.New("recipients are required")
+	}
+	recipients, err := age.ParseRecipients(strings.NewReader(strings.Join(cfg.Recipients, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipients: %w", err)
+	}
+	c.recipients = recipients
+	return c, nil
+}
+
+func (c *ageCipher) extension() string { return ".age" }
+
+func (c *ageCipher) encrypter() (func(w io.Writer) (io.WriteCloser, error), error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		if !c.armor {
+			return age.Encrypt(w, c.recipients...)
+		}
+		aw := armor.NewWriter(w)
+		ew, err := age.Encrypt(aw, c.recipients...)
+		if err != nil {
+			return nil, err
+		}
+		return armoredWriter{WriteCloser: ew, armor: aw}, nil
+	}, nil
+}
+
+func (c *ageCipher) decrypt(r io.Reader) (io.Reader, error) {
+	secret, err := secrets.Resolve(c.key)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid identities: %w", err)
+	}
+	if c.armor {
+		r = armor.NewReader(r)
+	}
+	return age.Decrypt(r, identities...)
+}
+
+// armoredWriter closes the age stream, then the armor around it
+type armoredWriter struct {
+	io.WriteCloser
+	armor io.WriteCloser
+}
+
+func (w armoredWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.armor.Close()
+}
@@ -0,0 +1,122 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	engine.RegisterStep("approval", newApproval)
+}
+
+// PortRejected receives the messages of rejected approval tasks
+const PortRejected = "rejected"
+
+// Headers set by the approval step
+const (
+	HeaderApprovalDecision = "approval-decision"
+	HeaderApprovalBy       = "approval-by"
+	HeaderApprovalComment  = "approval-comment"
+)
+
+// approvalConfig configures the approval step. Title and description are
+// templates filled from the message; assignees, when set, are the only
+// users who may decide the task.
+type approvalConfig struct {
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Assignees   []string    `json:"assignees"`
+	Timeout     interface{} `json:"timeout"`
+}
+
+// approvalStep suspends the execution on a task for someone to approve or
+// reject through the task API. The message passes on unchanged with the
+// decision in its headers: approved to the default port, rejected to the
+// rejected port, and to the timeout port when nobody decided in time.
+type approvalStep struct {
+	title       *template
+	description *template
+	assignees   []string
+	timeout     time.Duration
+}
+
+func newApproval(config map[string]interface{}) (engine.Step, error) {
+	cfg := approvalConfig{Title: "Approval of flow {flowId}"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	s := &approvalStep{assignees: cfg.Assignees}
+	var err error
+	if s.title, err = compileTemplate(cfg.Title); err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+	if s.description, err = compileTemplate(cfg.Description); err != nil {
+		return nil, fmt.Errorf("description: %w", err)
+	}
+	if cfg.Timeout != nil {
+		if s.timeout, err = parseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *approvalStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	data := templateData{sc: sc, in: in}
+	if s.title.usesBody || s.description.usesBody {
+		if err := decodeNumbers(in.Body, &data.body); err != nil {
+			return nil, err
+		}
+	}
+	title, err := s.title.expand(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+	description, err := s.description.expand(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("description: %w", err)
+	}
+	task := map[string]interface{}{"title": title}
+	if description != "" {
+		task["description"] = description
+	}
+	if len(s.assignees) > 0 {
+		task["assignees"] = s.assignees
+	}
+	return nil, &engine.Suspend{Token: ids.New("task"), Timeout: s.timeout, Kind: model.WaitKindTask, Data: task}
+}
+
+// Resume implements engine.Resumable
+func (s *approvalStep) Resume(ctx context.Context, sc *engine.StepContext, in *engine.Message, signal engine.Signal) ([]engine.Output, error) {
+	if signal.TimedOut {
+		in.SetHeader(HeaderApprovalDecision, "timeout")
+		return []engine.Output{{Port: PortTimeout, Message: in}}, nil
+	}
+	if signal.Message == nil {
+		return nil, errors.New("approval resumed without a decision")
+	}
+	var decision model.TaskDecision
+	if err := signal.Message.DecodeJSON(&decision); err != nil {
+		return nil, fmt.Errorf("invalid decision: %w", err)
+	}
+	in.SetHeader(HeaderApprovalDecision, decision.Decision)
+	if decision.By != "" {
+		in.SetHeader(HeaderApprovalBy, decision.By)
+	}
+	if decision.Comment != "" {
+		in.SetHeader(HeaderApprovalComment, decision.Comment)
+	}
+	switch decision.Decision {
+	case model.TaskApproved:
+		return engine.Emit(in), nil
+	case model.TaskRejected:
+		return []engine.Output{{Port: PortRejected, Message: in}}, nil
+	}
+	return nil, fmt.Errorf("invalid decision %q", decision.Decision)
+}
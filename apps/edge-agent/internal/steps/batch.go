@@ -0,0 +1,92 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+func init() {
+	engine.RegisterStep("batch", newBatch)
+}
+
+// batchConfig configures the batch step
+type batchConfig struct {
+	Connector string `json:"connector"`
+	Operation string `json:"operation"`
+	// Path names the file of each batch, expanding {flowId}, {stepId},
+	// {batchId} and {timestamp}
+	Path        string `json:"path"`
+	ContentType string `json:"contentType"`
+	// Separator follows every message, a newline unless set
+	Separator *string `json:"separator"`
+	MaxCount  int     `json:"maxCount"`
+	MaxBytes  int64   `json:"maxBytes"`
+	// Gap closes a batch once no message was added for the duration
+	Gap string `json:"gap"`
+}
+
+// batchStep adds message bodies to a batch that is written to a connector
+// once full, passing each message on once it is durably staged
+type batchStep struct {
+	batch engine.Batch
+}
+
+func newBatch(config map[string]interface{}) (engine.Step, error) {
+	var cfg batchConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Connector == "" {
+		return nil, errors.New("connector is required")
+	}
+	if cfg.Path == "" && cfg.Operation == "" {
+		return nil, errors.New("path or operation is required")
+	}
+	if cfg.MaxCount < 0 || cfg.MaxBytes < 0 {
+		return nil, errors.New("maxCount and maxBytes must not be negative")
+	}
+	b := engine.Batch{
+		Connector:   cfg.Connector,
+		Operation:   cfg.Operation,
+		Path:        cfg.Path,
+		ContentType: cfg.ContentType,
+		Separator:   "\n",
+		MaxCount:    cfg.MaxCount,
+		MaxBytes:    cfg.MaxBytes,
+	}
+	if cfg.Separator != nil {
+		b.Separator = *cfg.Separator
+	}
+	if cfg.Gap != "" {
+		d, err := time.ParseDuration(cfg.Gap)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid gap %q", cfg.Gap)
+		}
+		b.Gap = d
+	}
+	if b.MaxCount == 0 && b.MaxBytes == 0 && b.Gap == 0 {
+		return nil, errors.New("one of maxCount, maxBytes or gap is required")
+	}
+	return &batchStep{batch: b}, nil
+}
+
+// Transactional implements engine.Sink. A message is staged once per run,
+// so a retried execution may add it to a batch again.
+func (s *batchStep) Transactional() bool { return false }
+
+// Committed implements engine.Sink
+func (s *batchStep) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
+
+func (s *batchStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	if err := sc.AddToBatch(ctx, s.batch, in.Body); err != nil {
+		return nil, err
+	}
+	sc.Report("bytesBatched", len(in.Body))
+	return engine.Emit(in), nil
+}
@@ -0,0 +1,153 @@
+package steps
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// PortMismatch receives messages whose checksum does not match the
+// expected one
+const PortMismatch = "mismatch"
+
+// HeaderChecksumPrefix prefixes the headers holding computed checksums, as
+// in "checksum-sha256"
+const HeaderChecksumPrefix = "checksum-"
+
+func init() {
+	engine.RegisterStep("checksum", newChecksum)
+}
+
+// checksumAlgorithms are the supported checksum algorithms
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// checksumConfig configures the checksum step
+type checksumConfig struct {
+	// Algorithm is md5, sha256 (the default) or crc32
+	Algorithm string `json:"algorithm"`
+	// Expected is the checksum the body must have, in hex or base64
+	Expected string `json:"expected"`
+	// ExpectedHeader names the header holding the expected checksum, such
+	// as one set by the sender or read from a sidecar file
+	ExpectedHeader string `json:"expectedHeader"`
+	// OnMismatch is "fail" (the default) to fail the execution or "route"
+	// to emit mismatching messages on the mismatch port
+	OnMismatch string `json:"onMismatch"`
+}
+
+// checksumStep computes the checksum of the body into the
+// checksum-<algorithm> header and, when one is expected, verifies it
+type checksumStep struct {
+	cfg  checksumConfig
+	hash func() hash.Hash
+}
+
+func newChecksum(config map[string]interface{}) (engine.Step, error) {
+	cfg := checksumConfig{Algorithm: "sha256", OnMismatch: "fail"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	cfg.Algorithm = strings.ToLower(cfg.Algorithm)
+	h, ok := checksumAlgorithms[cfg.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q: must be md5, sha256 or crc32", cfg.Algorithm)
+	}
+	if cfg.Expected != "" && cfg.ExpectedHeader != "" {
+		return nil, fmt.Errorf("expected and expectedHeader are mutually exclusive")
+	}
+	if cfg.OnMismatch != "fail" && cfg.OnMismatch != "route" {
+		return nil, fmt.Errorf("invalid onMismatch %q: must be fail or route", cfg.OnMismatch)
+	}
+	return &checksumStep{cfg: cfg, hash: h}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *checksumStep) Streaming() bool { return true }
+
+func (s *checksumStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	sum, err := s.sum(in)
+	if err != nil {
+		return nil, err
+	}
+	in.SetHeader(HeaderChecksumPrefix+s.cfg.Algorithm, hex.EncodeToString(sum))
+
+	expected := s.cfg.Expected
+	if s.cfg.ExpectedHeader != "" {
+		expected = in.Headers[s.cfg.ExpectedHeader]
+		if expected == "" {
+			return s.mismatch(sc, in, fmt.Sprintf("message has no %s header", s.cfg.ExpectedHeader))
+		}
+	}
+	if expected != "" && !checksumEqual(sum, expected) {
+		return s.mismatch(sc, in, fmt.Sprintf("%s checksum %x does not match the expected %s", s.cfg.Algorithm, sum, expected))
+	}
+	return engine.Emit(in), nil
+}
+
+// sum hashes the body, reading a spooled copy of stream bodies so the
+// message passes on unread
+func (s *checksumStep) sum(in *engine.Message) ([]byte, error) {
+	h := s.hash()
+	if !in.IsStream() {
+		h.Write(in.Body)
+		return h.Sum(nil), nil
+	}
+	spool := in.Clone()
+	r, err := spool.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+func (s *checksumStep) mismatch(sc *engine.StepContext, in *engine.Message, problem string) ([]engine.Output, error) {
+	sc.Report("checksumMismatch", problem)
+	if s.cfg.OnMismatch == "fail" {
+		return nil, fmt.Errorf("integrity check failed: %s", problem)
+	}
+	in.SetHeader(HeaderValidationProblems, problem)
+	return []engine.Output{{Port: PortMismatch, Message: in}}, nil
+}
+
+// checksumEqual compares a checksum with its hex or base64 encoding, which
+// may carry an algorithm prefix as in "sha-256=" of Digest headers
+func checksumEqual(sum []byte, expected string) bool {
+	expected = strings.TrimSpace(expected)
+	if name, value, ok := strings.Cut(expected, "="); ok && checksumName(name) {
+		expected = value
+	} else if name, value, ok := strings.Cut(expected, ":"); ok && checksumName(name) {
+		expected = value
+	}
+	if got, err := hex.DecodeString(strings.ToLower(expected)); err == nil {
+		return string(got) == string(sum)
+	}
+	if got, err := base64.StdEncoding.DecodeString(expected); err == nil {
+		return string(got) == string(sum)
+	}
+	return false
+}
+
+// checksumName reports whether name is a checksum algorithm, spelled as in
+// Digest headers or as by the step
+func checksumName(name string) bool {
+	name = strings.ReplaceAll(strings.ToLower(name), "-", "")
+	_, ok := checksumAlgorithms[name]
+	return ok
+}
@@ -0,0 +1,231 @@
+package steps
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+func init() {
+	engine.RegisterStep("compress", newCompress)
+	engine.RegisterStep("decompress", newDecompress)
+	registerCompression("gzip", compression{
+		contentType: "application/gzip",
+		extension:   ".gz",
+		writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+}
+
+// compression is a payload compression format
+type compression struct {
+	contentType string
+	// extension is appended to the file-name header of compressed messages
+	// and removed from decompressed ones
+	extension string
+	// writer compresses to w at level, zero taking the format's default
+	writer func(w io.Writer, level int) (io.WriteCloser, error)
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = map[string]compression{}
+
+func registerCompression(name string, c compression) {
+	compressions[name] = c
+}
+
+func lookupCompression(format string) (compression, error) {
+	if format == "" {
+		format = "gzip"
+	}
+	c, ok := compressions[format]
+	if !ok {
+		return c, fmt.Errorf("unsupported compression format %q", format)
+	}
+	return c, nil
+}
+
+// compressConfig configures the compress and decompress steps
+type compressConfig struct {
+	// Format is gzip (the default) or zstd
+	Format string `json:"format"`
+	// Level is the compression level of the format, its default when zero
+	Level int `json:"level"`
+	// ContentType of decompressed messages defaults to the type registered
+	// for the extension of their file name
+	ContentType string `json:"contentType"`
+	// MaxBytes bounds the decompressed size, guarding against payloads that
+	// expand without limit; zero disables it
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// compressStep streams the body through a compressor
+type compressStep struct {
+	cfg   compressConfig
+	codec compression
+}
+
+func newCompress(config map[string]interface{}) (engine.Step, error) {
+	var cfg compressConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	codec, err := lookupCompression(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	// Fail on an invalid level when the flow is saved rather than at run time
+	w, err := codec.writer(io.Discard, cfg.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression level %d: %w", cfg.Level, err)
+	}
+	w.Close()
+	return &compressStep{cfg: cfg, codec: codec}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *compressStep) Streaming() bool { return true }
+
+func (s *compressStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	out := in.WithStream(pipeThrough(src, func(w io.Writer) (io.WriteCloser, error) {
+		return s.codec.writer(w, s.cfg.Level)
+	}), s.codec.contentType, -1)
+	if name := in.Headers[HeaderFileName]; name != "" {
+		out.SetHeader(HeaderFileName, name+s.codec.extension)
+	}
+	return engine.Emit(out), nil
+}
+
+// decompressStep streams the body through a decompressor
+type decompressStep struct {
+	cfg   compressConfig
+	codec compression
+}
+
+func newDecompress(config map[string]interface{}) (engine.Step, error) {
+	var cfg compressConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("maxBytes must not be negative")
+	}
+	codec, err := lookupCompression(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressStep{cfg: cfg, codec: codec}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *decompressStep) Streaming() bool { return true }
+
+func (s *decompressStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.codec.reader(src)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("failed to decompress message body: %w", err)
+	}
+	body := io.ReadCloser(readCloser{Reader: r, closers: []io.Closer{r, src}})
+	if s.cfg.MaxBytes > 0 {
+		body = &boundedReader{ReadCloser: body, left: s.cfg.MaxBytes}
+	}
+
+	name := strings.TrimSuffix(in.Headers[HeaderFileName], s.codec.extension)
+	out := in.WithStream(body, decodedType(s.cfg.ContentType, name), -1)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name)
+	}
+	return engine.Emit(out), nil
+}
+
+// decodedType is the content type of a decompressed or decrypted body:
+// the configured one, else the one of the file name's extension
+func decodedType(configured, name string) string {
+	if configured != "" {
+		return configured
+	}
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// pipeThrough returns the output of a writer wrapping, such as a
+// compressor, fed with src in the background. Closing the result stops the
+// copy and closes src.
+func pipeThrough(src io.ReadCloser, wrap func(w io.Writer) (io.WriteCloser, error)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer src.Close()
+		w, err := wrap(pw)
+		if err == nil {
+			_, err = io.Copy(w, src)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// readCloser reads from Reader and closes every closer in order
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r readCloser) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// boundedReader fails with engine.ErrBodyTooLarge once more than left
+// bytes have been read
+type boundedReader struct {
+	io.ReadCloser
+	left int64
+}
+
+func (r *boundedReader) Read(p []byte) (int, error) {
+	if r.left >= 0 && int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n := 0
+	var err error
+	if r.left >= 0 {
+		n, err = r.ReadCloser.Read(p)
+		r.left -= int64(n)
+	}
+	if r.left < 0 {
+		return 0, fmt.Errorf("%w: decoded body exceeds the size limit", engine.ErrBodyTooLarge)
+	}
+	return n, err
+}
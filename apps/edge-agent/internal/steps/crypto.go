@@ -0,0 +1,257 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+func init() {
+	engine.RegisterStep("encrypt", newEncrypt)
+	engine.RegisterStep("decrypt", newDecrypt)
+	registerCipher("aes-gcm", newAESGCM)
+}
+
+// cryptoConfig configures the encrypt and decrypt steps
+type cryptoConfig struct {
+	// Algorithm is aes-gcm (the default) or age
+	Algorithm string `json:"algorithm"`
+	// Key references the secret holding the AES key or, to decrypt age,
+	// the age identities, as env:NAME or file:NAME
+	Key string `json:"key"`
+	// Recipients are the age public keys to encrypt to
+	Recipients []string `json:"recipients"`
+	// Armor writes and reads age's ASCII armor
+	Armor bool `json:"armor"`
+	// ContentType of decrypted messages defaults to the type registered for
+	// the extension of their file name
+	ContentType string `json:"contentType"`
+}
+
+// payloadCipher encrypts and decrypts message bodies. Keys are resolved
+// from their secrets on every message, so rotated keys apply at once.
+type payloadCipher interface {
+	// encrypter returns the writers encrypting to their destination
+	encrypter() (func(w io.Writer) (io.WriteCloser, error), error)
+	decrypt(r io.Reader) (io.Reader, error)
+	// extension is appended to the file-name header of encrypted messages
+	// and removed from decrypted ones
+	extension() string
+}
+
+type cipherFactory func(cfg cryptoConfig, decrypt bool) (payloadCipher, error)
+
+var ciphers = map[string]cipherFactory{}
+
+func registerCipher(name string, f cipherFactory) {
+	ciphers[name] = f
+}
+
+func newCipher(config map[string]interface{}, decrypt bool) (cryptoConfig, payloadCipher, error) {
+	var cfg cryptoConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = "aes-gcm"
+	}
+	f, ok := ciphers[cfg.Algorithm]
+	if !ok {
+		return cfg, nil, fmt.Errorf("unsupported encryption algorithm %q", cfg.Algorithm)
+	}
+	if cfg.Key != "" {
+		if err := secrets.Validate(cfg.Key); err != nil {
+			return cfg, nil, err
+		}
+	}
+	c, err := f(cfg, decrypt)
+	return cfg, c, err
+}
+
+// encryptStep streams the body through a cipher
+type encryptStep struct {
+	cipher payloadCipher
+}
+
+func newEncrypt(config map[string]interface{}) (engine.Step, error) {
+	_, c, err := newCipher(config, false)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptStep{cipher: c}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *encryptStep) Streaming() bool { return true }
+
+func (s *encryptStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	encrypt, err := s.cipher.encrypter()
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	out := in.WithStream(pipeThrough(src, encrypt), "application/octet-stream", -1)
+	if name := in.Headers[HeaderFileName]; name != "" {
+		out.SetHeader(HeaderFileName, name+s.cipher.extension())
+	}
+	return engine.Emit(out), nil
+}
+
+// decryptStep streams the body through a cipher
+type decryptStep struct {
+	cfg    cryptoConfig
+	cipher payloadCipher
+}
+
+func newDecrypt(config map[string]interface{}) (engine.Step, error) {
+	cfg, c, err := newCipher(config, true)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptStep{cfg: cfg, cipher: c}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *decryptStep) Streaming() bool { return true }
+
+func (s *decryptStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.cipher.decrypt(src)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("failed to decrypt message body: %w", err)
+	}
+
+	name := strings.TrimSuffix(in.Headers[HeaderFileName], s.cipher.extension())
+	out := in.WithStream(readCloser{Reader: r, closers: []io.Closer{src}}, decodedType(s.cfg.ContentType, name), -1)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name)
+	}
+	return engine.Emit(out), nil
+}
+
+// aesGCM encrypts with AES-GCM under a 128, 192 or 256-bit key. The output
+// is a random 12-byte nonce followed by the sealed body, so bodies are
+// buffered in memory, up to engine.DefaultMaxBufferSize.
+type aesGCM struct {
+	key string
+}
+
+func newAESGCM(cfg cryptoConfig, decrypt bool) (payloadCipher, error) {
+	if cfg.Key == "" {
+		return nil, errors.New("key is required")
+	}
+	return &aesGCM{key: cfg.Key}, nil
+}
+
+func (c *aesGCM) extension() string { return "" }
+
+func (c *aesGCM) aead() (cipher.AEAD, error) {
+	secret, err := secrets.Resolve(c.key)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aesKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *aesGCM) encrypter() (func(w io.Writer) (io.WriteCloser, error), error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return &sealWriter{aead: aead, w: w}, nil
+	}, nil
+}
+
+func (c *aesGCM) decrypt(r io.Reader) (io.Reader, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, engine.DefaultMaxBufferSize+int64(aead.NonceSize()+aead.Overhead())+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	if len(data) > engine.DefaultMaxBufferSize+aead.NonceSize()+aead.Overhead() {
+		return nil, engine.ErrBodyTooLarge
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(sealed[:0], nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.New("message authentication failed")
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// sealWriter buffers a body and writes it sealed on Close
+type sealWriter struct {
+	aead cipher.AEAD
+	w    io.Writer
+	buf  bytes.Buffer
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.buf.Len()+len(p) > engine.DefaultMaxBufferSize {
+		return 0, engine.ErrBodyTooLarge
+	}
+	return s.buf.Write(p)
+}
+
+func (s *sealWriter) Close() error {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+s.buf.Len()+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	_, err := s.w.Write(s.aead.Seal(nonce, nonce, s.buf.Bytes(), nil))
+	return err
+}
+
+// aesKey takes a key from the hex or base64 encoding held by its secret,
+// or else from the secret's raw bytes
+func aesKey(secret []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(secret))
+	if key, err := hex.DecodeString(text); err == nil && validAESKey(len(key)) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validAESKey(len(key)) {
+		return key, nil
+	}
+	if validAESKey(len(secret)) {
+		return secret, nil
+	}
+	return nil, errors.New("key must be 16, 24 or 32 bytes, raw, hex or base64")
+}
+
+func validAESKey(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
@@ -0,0 +1,84 @@
+package steps
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+func init() {
+	engine.RegisterStep("db-write", newDBWrite)
+}
+
+// dbWriteConfig configures the db-write step
+type dbWriteConfig struct {
+	// Connector names the SQL connector written through
+	Connector string `json:"connector"`
+	// Operation names the connector operation writing the records;
+	// alternatively Table names the table they are inserted into
+	Operation string `json:"operation"`
+	Table     string `json:"table"`
+	// Conflict lists the columns whose conflicting rows are updated
+	Conflict []string `json:"conflict"`
+}
+
+// dbWrite inserts the records of the body into a database table. In
+// exactly-once flows the idempotency key of the write is recorded in the
+// same database transaction, so a redelivered message is not written twice.
+type dbWrite struct {
+	cfg dbWriteConfig
+}
+
+func newDBWrite(config map[string]interface{}) (engine.Step, error) {
+	var cfg dbWriteConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Connector == "" {
+		return nil, errors.New("connector is required")
+	}
+	if cfg.Operation == "" && cfg.Table == "" {
+		return nil, errors.New("operation or table is required")
+	}
+	return &dbWrite{cfg: cfg}, nil
+}
+
+// Connectors implements engine.ConnectorUser
+func (s *dbWrite) Connectors() []string { return connectorIDs(s.cfg.Connector) }
+
+// Transactional implements engine.Sink
+func (s *dbWrite) Transactional() bool { return true }
+
+// Committed implements engine.Sink by looking the key up in the database
+func (s *dbWrite) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return sc.Committed(ctx, s.cfg.Connector, key)
+}
+
+func (s *dbWrite) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	params := map[string]interface{}{}
+	if s.cfg.Table != "" {
+		params["table"] = s.cfg.Table
+	}
+	if len(s.cfg.Conflict) > 0 {
+		conflict := make([]interface{}, len(s.cfg.Conflict))
+		for i, c := range s.cfg.Conflict {
+			conflict[i] = c
+		}
+		params["conflict"] = conflict
+	}
+	err := sc.WriteCommitted(ctx, s.cfg.Connector, engine.CommittedWrite{
+		Operation:   s.cfg.Operation,
+		Params:      params,
+		Body:        in.Body,
+		ContentType: in.ContentType,
+	})
+	if err != nil {
+		if errors.Is(err, engine.ErrNoCommitter) {
+			return nil, errors.New("transactional connector writes are not available")
+		}
+		return nil, err
+	}
+	sc.Report("bytesWritten", len(in.Body))
+	return engine.Emit(in), nil
+}
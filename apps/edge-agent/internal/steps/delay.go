@@ -0,0 +1,78 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+func init() {
+	engine.RegisterStep("delay", newDelay)
+}
+
+// delayConfig configures the delay step. Duration is a Go duration such as
+// "24h"; a bare number is taken as seconds.
+type delayConfig struct {
+	Duration interface{} `json:"duration"`
+}
+
+// delayStep holds each message for a fixed time, then passes it on
+// unchanged. The wait is held in memory, so it does not survive a restart,
+// and it follows the agent's clock so virtual-time tests need not wait.
+type delayStep struct {
+	duration time.Duration
+}
+
+func newDelay(config map[string]interface{}) (engine.Step, error) {
+	var cfg delayConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Duration == nil {
+		return nil, errors.New("delay step requires a duration")
+	}
+	d, err := parseDuration(cfg.Duration)
+	if err != nil {
+		return nil, err
+	}
+	return &delayStep{duration: d}, nil
+}
+
+// parseDuration parses a Go duration or a number of seconds
+func parseDuration(v interface{}) (time.Duration, error) {
+	var d time.Duration
+	switch v := v.(type) {
+	case float64:
+		d = time.Duration(v * float64(time.Second))
+	case string:
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			d = time.Duration(n * float64(time.Second))
+		} else if d, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+	default:
+		return 0, fmt.Errorf("invalid duration %v", v)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration must not be negative, got %s", d)
+	}
+	return d, nil
+}
+
+func (s *delayStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	clk := sc.Clock()
+	start := clk.Now()
+	timer := clk.NewTimer(s.duration)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return nil, ctx.Err()
+	case <-timer.C():
+	}
+	sc.Report("delayedMs", clk.Now().Sub(start).Milliseconds())
+	return engine.Emit(in), nil
+}
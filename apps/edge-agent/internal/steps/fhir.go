@@ -0,0 +1,90 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/formats/fhir"
+)
+
+// HeaderFHIRResourceType carries the resourceType on fhir-parse outputs
+const HeaderFHIRResourceType = "fhir-resource-type"
+
+func init() {
+	engine.RegisterStep("fhir-parse", newFHIRParse)
+	engine.RegisterStep("fhir-validate", newFHIRValidate)
+}
+
+// fhirParse checks that the body is a FHIR JSON resource and tags its type
+type fhirParse struct{}
+
+func newFHIRParse(config map[string]interface{}) (engine.Step, error) {
+	return fhirParse{}, nil
+}
+
+func (fhirParse) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	r, err := fhir.Parse(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	out := in.WithBody(in.Body, fhir.ContentType)
+	out.SetHeader(HeaderFHIRResourceType, r.Type())
+	return engine.Emit(out), nil
+}
+
+// fhirValidateConfig configures the fhir-validate step
+type fhirValidateConfig struct {
+	// Profiles are StructureDefinition files to load
+	Profiles []string `json:"profiles"`
+	// Require lists profile URLs every resource must conform to, in
+	// addition to those it declares in meta.profile
+	Require []string `json:"require"`
+	// OnInvalid is "fail" (the default) to fail the execution or "route" to
+	// emit invalid resources on the invalid port
+	OnInvalid string `json:"onInvalid"`
+}
+
+// fhirValidate validates resources against the base rules and profiles
+type fhirValidate struct {
+	cfg      fhirValidateConfig
+	profiles *fhir.ProfileSet
+}
+
+func newFHIRValidate(config map[string]interface{}) (engine.Step, error) {
+	cfg := fhirValidateConfig{OnInvalid: "fail"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.OnInvalid != "fail" && cfg.OnInvalid != "route" {
+		return nil, fmt.Errorf("invalid onInvalid %q: must be fail or route", cfg.OnInvalid)
+	}
+	profiles, err := fhir.LoadProfiles(cfg.Profiles...)
+	if err != nil {
+		return nil, err
+	}
+	return &fhirValidate{cfg: cfg, profiles: profiles}, nil
+}
+
+func (s *fhirValidate) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	var problems []string
+	r, err := fhir.Parse(in.Body)
+	if err != nil {
+		problems = []string{err.Error()}
+	} else {
+		problems = s.profiles.Validate(r, s.cfg.Require...)
+	}
+	if len(problems) == 0 {
+		return engine.Emit(in), nil
+	}
+
+	sc.Report("validationProblems", len(problems))
+	if s.cfg.OnInvalid == "fail" {
+		return nil, errors.New("resource is invalid: " + strings.Join(problems, "; "))
+	}
+	out := in.Clone()
+	out.SetHeader(HeaderValidationProblems, strings.Join(problems, "; "))
+	return []engine.Output{{Port: PortInvalid, Message: out}}, nil
+}
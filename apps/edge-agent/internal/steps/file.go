@@ -0,0 +1,218 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// Headers set by the file steps
+const (
+	HeaderFileName = "file-name"
+	HeaderFilePath = "file-path"
+)
+
+func init() {
+	engine.RegisterStep("file-read", newFileRead)
+	engine.RegisterStep("file-write", newFileWrite)
+}
+
+// fileReadConfig configures the file-read step
+type fileReadConfig struct {
+	// Path is the file to read and may contain {executionId}, {stepId} and
+	// {timestamp}
+	Path string `json:"path"`
+	// ContentType defaults to the type registered for the file extension
+	ContentType string `json:"contentType"`
+	// Connector names the connector whose bandwidth caps apply to the read,
+	// e.g. for files on a network share
+	Connector string `json:"connector"`
+}
+
+// fileRead emits a file as a stream body without loading it into memory
+type fileRead struct {
+	cfg fileReadConfig
+}
+
+func newFileRead(config map[string]interface{}) (engine.Step, error) {
+	var cfg fileReadConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	return &fileRead{cfg: cfg}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *fileRead) Streaming() bool { return true }
+
+// Connectors implements engine.ConnectorUser
+func (s *fileRead) Connectors() []string { return connectorIDs(s.cfg.Connector) }
+
+func (s *fileRead) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	path := expandPath(s.cfg.Path, sc)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	contentType := s.cfg.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	sc.Report("bytesRead", info.Size())
+
+	body := io.ReadCloser(f)
+	if s.cfg.Connector != "" {
+		body = limitedFile{sc.LimitReader(ctx, s.cfg.Connector, f), f}
+	}
+	out := in.WithStream(body, contentType, info.Size())
+	out.SetHeader(HeaderFileName, filepath.Base(path))
+	out.SetHeader(HeaderFilePath, path)
+	return engine.Emit(out), nil
+}
+
+// fileWriteConfig configures the file-write step
+type fileWriteConfig struct {
+	// Path is the file to write and may contain {executionId}, {stepId} and
+	// {timestamp}. Alternatively Directory and FileName name the file.
+	Path      string `json:"path"`
+	Directory string `json:"directory"`
+	// FileName defaults to the file-name header, then to the execution ID
+	FileName string `json:"fileName"`
+	// Connector names the connector whose bandwidth caps apply to the write
+	Connector string `json:"connector"`
+}
+
+// fileWrite streams the body to a file, replacing it atomically
+type fileWrite struct {
+	cfg fileWriteConfig
+}
+
+func newFileWrite(config map[string]interface{}) (engine.Step, error) {
+	var cfg fileWriteConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" && cfg.Directory == "" {
+		return nil, errors.New("path or directory is required")
+	}
+	if cfg.Path != "" && cfg.Directory != "" {
+		return nil, errors.New("path and directory are mutually exclusive")
+	}
+	return &fileWrite{cfg: cfg}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *fileWrite) Streaming() bool { return true }
+
+// Connectors implements engine.ConnectorUser
+func (s *fileWrite) Connectors() []string { return connectorIDs(s.cfg.Connector) }
+
+// Transactional implements engine.Sink. A crash between the rename and the
+// recorded success leaves the file in place, so writes may repeat.
+func (s *fileWrite) Transactional() bool { return false }
+
+// Committed implements engine.Sink
+func (s *fileWrite) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
+
+func (s *fileWrite) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	tx, outputs, err := s.Prepare(ctx, sc, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// Prepare implements engine.TwoPhaseSink; the file is written beside its
+// destination and renamed into place on commit
+func (s *fileWrite) Prepare(ctx context.Context, sc *engine.StepContext, in *engine.Message) (engine.SinkTx, []engine.Output, error) {
+	path := s.path(sc, in)
+	r, err := in.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	staged, err := stageFile(path, r, func(w io.Writer) io.Writer {
+		return sc.LimitWriter(ctx, s.cfg.Connector, w)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sc.Report("bytesWritten", staged.size)
+
+	out, err := engine.JSONMessage(map[string]interface{}{"path": path, "bytes": staged.size})
+	if err != nil {
+		staged.Rollback(ctx)
+		return nil, nil, err
+	}
+	return staged, engine.Emit(withHeaders(out, in)), nil
+}
+
+// Warm implements engine.Warmer by creating the target directory when it
+// does not depend on the execution
+func (s *fileWrite) Warm(ctx context.Context) error {
+	dir := s.cfg.Directory
+	if s.cfg.Path != "" {
+		dir = filepath.Dir(s.cfg.Path)
+	}
+	if strings.Contains(dir, "{") {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// path resolves the target file for in
+func (s *fileWrite) path(sc *engine.StepContext, in *engine.Message) string {
+	if s.cfg.Path != "" {
+		return expandPath(s.cfg.Path, sc)
+	}
+	name := s.cfg.FileName
+	if name == "" {
+		name = filepath.Base(in.Headers[HeaderFileName])
+	}
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "{executionId}"
+	}
+	return filepath.Join(expandPath(s.cfg.Directory, sc), expandPath(name, sc))
+}
+
+// limitedFile reads a file through a bandwidth limiter
+type limitedFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (l limitedFile) Close() error {
+	return l.f.Close()
+}
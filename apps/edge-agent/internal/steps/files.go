@@ -0,0 +1,86 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// expandPath replaces the {executionId}, {stepId} and {timestamp}
+// placeholders in a configured path
+func expandPath(pattern string, sc *engine.StepContext) string {
+	return strings.NewReplacer(
+		"{executionId}", sc.ExecutionID,
+		"{stepId}", sc.StepID,
+		"{timestamp}", sc.Clock().Now().UTC().Format("20060102T150405Z"),
+	).Replace(pattern)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+// so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	staged, err := stageFile(path, bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	return staged.Commit(context.Background())
+}
+
+// stagedFile is a fully written temporary file next to its destination. It
+// implements engine.SinkTx: committing renames it into place.
+type stagedFile struct {
+	path string
+	tmp  string
+	size int64
+}
+
+// stageFile streams r to a temporary file beside path. limit, when not nil,
+// wraps the file writer, e.g. to cap bandwidth.
+func stageFile(path string, r io.Reader, limit func(io.Writer) io.Writer) (*stagedFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	var w io.Writer = tmp
+	if limit != nil {
+		w = limit(tmp)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	return &stagedFile{path: path, tmp: tmp.Name(), size: n}, nil
+}
+
+// Commit moves the file into place
+func (f *stagedFile) Commit(ctx context.Context) error {
+	if err := os.Rename(f.tmp, f.path); err != nil {
+		os.Remove(f.tmp)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the file
+func (f *stagedFile) Rollback(ctx context.Context) error {
+	if err := os.Remove(f.tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove staged file: %w", err)
+	}
+	return nil
+}
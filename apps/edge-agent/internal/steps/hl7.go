@@ -0,0 +1,98 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/formats/hl7"
+)
+
+// HeaderHL7Type carries the message type, e.g. "ADT^A01", on HL7 step outputs
+const HeaderHL7Type = "hl7-message-type"
+
+func init() {
+	engine.RegisterStep("hl7-parse", newHL7Parse)
+	engine.RegisterStep("hl7-serialize", newHL7Serialize)
+	engine.RegisterStep("hl7-ack", newHL7Ack)
+}
+
+// hl7Parse converts an ER7-encoded message into its JSON document form
+type hl7Parse struct{}
+
+func newHL7Parse(config map[string]interface{}) (engine.Step, error) {
+	return hl7Parse{}, nil
+}
+
+func (hl7Parse) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	msg, err := hl7.Parse(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	doc := msg.ToDocument()
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode HL7 document: %w", err)
+	}
+	out := in.WithBody(body, "application/json")
+	out.SetHeader(HeaderHL7Type, doc.Type)
+	return engine.Emit(out), nil
+}
+
+// hl7Serialize converts a JSON document back into ER7 encoding
+type hl7Serialize struct{}
+
+func newHL7Serialize(config map[string]interface{}) (engine.Step, error) {
+	return hl7Serialize{}, nil
+}
+
+func (hl7Serialize) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	var doc hl7.Document
+	if err := in.DecodeJSON(&doc); err != nil {
+		return nil, err
+	}
+	msg, err := hl7.FromDocument(&doc)
+	if err != nil {
+		return nil, err
+	}
+	out := in.WithBody(msg.Bytes(), hl7.ContentType)
+	out.SetHeader(HeaderHL7Type, msg.Type())
+	return engine.Emit(out), nil
+}
+
+// hl7AckConfig configures the hl7-ack step
+type hl7AckConfig struct {
+	// Code is the MSA-1 acknowledgement code, AA by default
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+// hl7Ack replaces an ER7 message with its acknowledgement
+type hl7Ack struct {
+	cfg hl7AckConfig
+}
+
+func newHL7Ack(config map[string]interface{}) (engine.Step, error) {
+	cfg := hl7AckConfig{Code: hl7.AckAccept}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.Code {
+	case hl7.AckAccept, hl7.AckError, hl7.AckReject:
+	default:
+		return nil, fmt.Errorf("invalid acknowledgement code %q", cfg.Code)
+	}
+	return &hl7Ack{cfg: cfg}, nil
+}
+
+func (s *hl7Ack) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	msg, err := hl7.Parse(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	ack := msg.Ack(s.cfg.Code, s.cfg.Text)
+	out := in.WithBody(ack.Bytes(), hl7.ContentType)
+	out.SetHeader(HeaderHL7Type, ack.Type())
+	return engine.Emit(out), nil
+}
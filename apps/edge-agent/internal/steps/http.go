@@ -0,0 +1,563 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/mapping"
+)
+
+// HeaderHTTPStatus is the response status set by the http step
+const HeaderHTTPStatus = "http-status"
+
+// Response modes of the http step
+const (
+	// ResponseBody emits the response body
+	ResponseBody = "body"
+	// ResponseIgnore emits the input message, e.g. for notifications
+	ResponseIgnore = "ignore"
+)
+
+func init() {
+	engine.RegisterStep("http", newHTTP)
+}
+
+// httpConfig configures the http step. URL, header, query and body values
+// are templates filled from the message (see template).
+type httpConfig struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Query   map[string]string `json:"query"`
+	// Body replaces the input as the request body: a text template, or a
+	// JSON value whose strings are templates and where a string that is
+	// only a {body...} placeholder is replaced by the value itself
+	Body        interface{} `json:"body"`
+	ContentType string      `json:"contentType"`
+	Timeout     string      `json:"timeout"`
+	// MaxRetries is how often connection failures, 408, 429 and 5xx
+	// responses are retried, waiting RetryBackoff and doubling up to 30s,
+	// or as long as a Retry-After asks
+	MaxRetries   int    `json:"maxRetries"`
+	RetryBackoff string `json:"retryBackoff"`
+	// Response is body (default) or ignore; the txt, bin and obj modes of
+	// imported Node-RED flows emit the body
+	Response string `json:"response"`
+	// ResponseMapping reshapes a JSON response as the map step does
+	ResponseMapping *mapping.Spec `json:"responseMapping"`
+	// AcceptStatus lists error statuses emitted rather than failing the
+	// step, such as 404 for lookups that may find nothing
+	AcceptStatus []int `json:"acceptStatus"`
+}
+
+// httpStep calls a REST API for each message
+type httpStep struct {
+	cfg     httpConfig
+	method  string
+	url     *template
+	headers map[string]*template
+	query   map[string]*template
+	body    interface{}
+	backoff time.Duration
+	mapping *mapping.Mapping
+	client  *http.Client
+}
+
+// httpSink is the http step with a method that changes the remote system
+type httpSink struct {
+	*httpStep
+}
+
+// maxHTTPRetryWait bounds the wait a Retry-After header can ask for
+const maxHTTPRetryWait = 5 * time.Minute
+
+// maxHTTPRedirects bounds the redirects a request follows
+const maxHTTPRedirects = 10
+
+// stepKey carries the StepContext of a request to its client's dialer
+type stepKey struct{}
+
+// newHTTPClient returns the client of an http step. Every redirect is
+// checked against the egress allowlists of the requesting step before it
+// is followed, and every connection against the address actually dialled.
+// Requests are not sent through an environment proxy, whose address would
+// be the only one dialled.
+func newHTTPClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		sc, ok := ctx.Value(stepKey{}).(*engine.StepContext)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not dialled by a step", engine.ErrEgressDenied, addr)
+		}
+		host, port := egress.SplitAddress(addr)
+		d := *dialer
+		d.Control = func(_, address string, _ syscall.RawConn) error {
+			ip, _ := egress.SplitAddress(address)
+			return sc.AllowDial(ctx, host, net.ParseIP(ip), port)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxHTTPRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxHTTPRedirects)
+			}
+			sc, ok := req.Context().Value(stepKey{}).(*engine.StepContext)
+			if !ok {
+				return fmt.Errorf("%w: redirect to %s outside a step", engine.ErrEgressDenied, req.URL.Host)
+			}
+			return sc.AllowEgress(req.Context(), req.URL.Hostname(), egress.URLPort(req.URL))
+		},
+	}
+}
+
+func newHTTP(config map[string]interface{}) (engine.Step, error) {
+	cfg := httpConfig{Method: http.MethodGet, MaxRetries: 2, Response: ResponseBody}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	s := &httpStep{cfg: cfg, method: strings.ToUpper(cfg.Method), client: newHTTPClient(), backoff: time.Second}
+	if s.method == "" {
+		s.method = http.MethodGet
+	}
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	var err error
+	if s.url, err = compileTemplate(cfg.URL); err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+	if s.headers, err = compileTemplates(cfg.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %w", err)
+	}
+	if s.query, err = compileTemplates(cfg.Query); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	if cfg.Body != nil {
+		if s.body, err = compileBody(cfg.Body); err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout %q is not a positive duration", cfg.Timeout)
+		}
+		s.client.Timeout = d
+	}
+	if cfg.RetryBackoff != "" {
+		d, err := time.ParseDuration(cfg.RetryBackoff)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("retryBackoff %q is not a positive duration", cfg.RetryBackoff)
+		}
+		s.backoff = d
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, errors.New("maxRetries must not be negative")
+	}
+	switch cfg.Response {
+	case ResponseBody, ResponseIgnore:
+	case "txt", "bin", "obj":
+		s.cfg.Response = ResponseBody
+	default:
+		return nil, fmt.Errorf("invalid response %q: must be %s or %s", cfg.Response, ResponseBody, ResponseIgnore)
+	}
+	if cfg.ResponseMapping != nil {
+		if s.mapping, err = mapping.Compile(*cfg.ResponseMapping); err != nil {
+			return nil, err
+		}
+	}
+
+	switch s.method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return s, nil
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return &httpSink{s}, nil
+	}
+	return nil, fmt.Errorf("method %q is not supported", cfg.Method)
+}
+
+// safe reports whether the step's method only reads from the remote system
+func (s *httpStep) safe() bool {
+	return s.method == http.MethodGet || s.method == http.MethodHead || s.method == http.MethodOptions
+}
+
+// Transactional implements engine.Sink. The remote API does not record the
+// idempotency key with the write, so a retried execution may repeat it;
+// the key is sent as an Idempotency-Key header for APIs that honour one.
+func (s *httpSink) Transactional() bool { return false }
+
+// Committed implements engine.Sink
+func (s *httpSink) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
+
+func (s *httpStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	data := templateData{sc: sc, in: in}
+	if s.usesBody() {
+		if err := decodeNumbers(in.Body, &data.body); err != nil {
+			return nil, err
+		}
+	}
+
+	target, err := s.url.expand(data, func(v string, query bool) string {
+		if query {
+			return url.QueryEscape(v)
+		}
+		return url.PathEscape(v)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("url %q is not an absolute URL", target)
+	}
+	if len(s.query) > 0 {
+		q := u.Query()
+		for name, t := range s.query {
+			v, err := t.expand(data, nil)
+			if err != nil {
+				return nil, fmt.Errorf("query %s: %w", name, err)
+			}
+			q.Set(name, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	header := http.Header{}
+	for name, t := range s.headers {
+		v, err := t.expand(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		header.Set(name, v)
+	}
+	if key := sc.IdempotencyKey(); key != "" && header.Get("Idempotency-Key") == "" && !s.safe() {
+		header.Set("Idempotency-Key", key)
+	}
+
+	body, contentType, err := s.requestBody(data, in)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, respBody, attempts, err := s.send(ctx, sc, u, header, body)
+	sc.Report("attempts", attempts)
+	if err != nil {
+		return nil, err
+	}
+	sc.Report("httpStatus", resp.StatusCode)
+	if resp.StatusCode >= 400 && !slices.Contains(s.cfg.AcceptStatus, resp.StatusCode) {
+		class := engine.HTTPStatusClass(resp.StatusCode)
+		detail := strings.TrimSpace(string(respBody[:min(len(respBody), 512)]))
+		if detail != "" {
+			return nil, engine.WithErrorClass(class, fmt.Errorf("%s %s%s: %s: %s", s.method, u.Host, u.Path, resp.Status, detail))
+		}
+		return nil, engine.WithErrorClass(class, fmt.Errorf("%s %s%s: %s", s.method, u.Host, u.Path, resp.Status))
+	}
+
+	if s.cfg.Response == ResponseIgnore {
+		out := in.WithBody(in.Body, in.ContentType)
+		out.SetHeader(HeaderHTTPStatus, strconv.Itoa(resp.StatusCode))
+		return engine.Emit(out), nil
+	}
+	respType := resp.Header.Get("Content-Type")
+	if s.mapping != nil && resp.StatusCode < 400 {
+		var decoded interface{}
+		if err := decodeNumbers(respBody, &decoded); err != nil {
+			return nil, fmt.Errorf("failed to map response: %w", err)
+		}
+		mapped, err := applyMapping(s.mapping, decoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map response: %w", err)
+		}
+		if respBody, err = json.Marshal(mapped); err != nil {
+			return nil, err
+		}
+		respType = "application/json"
+	}
+	out := in.WithBody(respBody, respType)
+	out.SetHeader(HeaderHTTPStatus, strconv.Itoa(resp.StatusCode))
+	return engine.Emit(out), nil
+}
+
+// usesBody reports whether a template needs the decoded message body
+func (s *httpStep) usesBody() bool {
+	if s.url.usesBody || bodyUsesBody(s.body) {
+		return true
+	}
+	for _, t := range s.headers {
+		if t.usesBody {
+			return true
+		}
+	}
+	for _, t := range s.query {
+		if t.usesBody {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody returns the body to send: the rendered body template, the
+// input for methods that carry one, or nothing
+func (s *httpStep) requestBody(data templateData, in *engine.Message) ([]byte, string, error) {
+	switch body := s.body.(type) {
+	case *template:
+		text, err := body.expand(data, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("body: %w", err)
+		}
+		return []byte(text), s.contentType("text/plain"), nil
+	case nil:
+	default:
+		v, err := renderBody(body, data)
+		if err != nil {
+			return nil, "", fmt.Errorf("body: %w", err)
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode body: %w", err)
+		}
+		return encoded, s.contentType("application/json"), nil
+	}
+	if s.safe() {
+		return nil, "", nil
+	}
+	return in.Body, s.contentType(in.ContentType), nil
+}
+
+func (s *httpStep) contentType(fallback string) string {
+	if s.cfg.ContentType != "" {
+		return s.cfg.ContentType
+	}
+	return fallback
+}
+
+// send makes the request, retrying failures that may pass. It returns the
+// final response with its body read, and the number of attempts made.
+// Connections the egress allowlists deny are not retried.
+func (s *httpStep) send(ctx context.Context, sc *engine.StepContext, u *url.URL, header http.Header, body []byte) (*http.Response, []byte, int, error) {
+	if err := sc.AllowEgress(ctx, u.Hostname(), egress.URLPort(u)); err != nil {
+		return nil, nil, 0, err
+	}
+	ctx = context.WithValue(ctx, stepKey{}, sc)
+	wait := s.backoff
+	for attempt := 1; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, s.method, u.String(), r)
+		if err != nil {
+			return nil, nil, attempt, err
+		}
+		if err := sc.CountCall(); err != nil {
+			return nil, nil, attempt, err
+		}
+		if err := sc.CountEgress(int64(len(body))); err != nil {
+			return nil, nil, attempt, err
+		}
+		req.Header = header.Clone()
+
+		resp, err := s.client.Do(req)
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(io.LimitReader(resp.Body, engine.DefaultMaxBufferSize))
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil {
+			return nil, nil, attempt, ctx.Err()
+		}
+		retry := err != nil && !errors.Is(err, engine.ErrEgressDenied) || err == nil && retryableStatus(resp.StatusCode)
+		if !retry || attempt > s.cfg.MaxRetries {
+			if err != nil {
+				return nil, nil, attempt, fmt.Errorf("%s %s%s failed: %w", s.method, u.Host, u.Path, err)
+			}
+			return resp, data, attempt, nil
+		}
+
+		delay := wait
+		if err == nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After"), sc.Clock().Now()); ok {
+				delay = min(after, maxHTTPRetryWait)
+			}
+		}
+		sc.Logger.WithField("attempt", attempt).Warnf("Retrying %s %s%s in %s", s.method, u.Host, u.Path, delay)
+		timer := sc.Clock().NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, attempt, ctx.Err()
+		case <-timer.C():
+		}
+		wait = min(wait*2, 30*time.Second)
+	}
+}
+
+// retryableStatus reports whether a request answered with status may
+// succeed when repeated
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func compileTemplates(values map[string]string) (map[string]*template, error) {
+	templates := make(map[string]*template, len(values))
+	for name, value := range values {
+		t, err := compileTemplate(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// compileBody compiles a body template: a text template for a string, or
+// the JSON value with its strings compiled
+func compileBody(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return compileTemplate(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			compiled, err := compileJSONTemplate(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = compiled
+		}
+		return out, nil
+	default:
+		return compileJSONTemplate(v)
+	}
+}
+
+// jsonTemplate is a string in a JSON body template
+type jsonTemplate struct {
+	*template
+}
+
+func compileJSONTemplate(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		t, err := compileTemplate(v)
+		if err != nil {
+			return nil, err
+		}
+		return jsonTemplate{t}, nil
+	case map[string]interface{}:
+		return compileBody(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			compiled, err := compileJSONTemplate(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			out[i] = compiled
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// bodyUsesBody reports whether a compiled body template uses the message body
+func bodyUsesBody(v interface{}) bool {
+	switch v := v.(type) {
+	case *template:
+		return v.usesBody
+	case jsonTemplate:
+		return v.usesBody
+	case map[string]interface{}:
+		for _, item := range v {
+			if bodyUsesBody(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if bodyUsesBody(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderBody fills a compiled JSON body template. A string that is only a
+// {body...} placeholder keeps the type of the value it refers to.
+func renderBody(v interface{}, data templateData) (interface{}, error) {
+	switch v := v.(type) {
+	case jsonTemplate:
+		if len(v.parts) == 1 && (v.parts[0].name == "body" || strings.HasPrefix(v.parts[0].name, "body.")) {
+			if v.parts[0].name == "body" {
+				return data.body, nil
+			}
+			path := strings.TrimPrefix(v.parts[0].name, "body.")
+			value, ok := mapping.Get(data.body, path)
+			if !ok {
+				return nil, fmt.Errorf("placeholder {%s}: the body has no field %s", v.parts[0].name, path)
+			}
+			return value, nil
+		}
+		return v.expand(data, nil)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			rendered, err := renderBody(item, data)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := renderBody(item, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,153 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/kafka"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+func init() {
+	engine.RegisterStep("kafka", newKafka)
+}
+
+// kafkaConfig configures the kafka step
+type kafkaConfig struct {
+	kafka.Config
+	Topic string `json:"topic"`
+	// Key partitions the records; KeyHeader takes it from a message header
+	// instead. Without either, records are spread over the partitions.
+	Key       string `json:"key"`
+	KeyHeader string `json:"keyHeader"`
+	// Headers lists message headers sent as record headers; the execution
+	// is always sent, as the parent of executions of consuming flows
+	Headers     []string `json:"headers"`
+	Acks        string   `json:"acks"`
+	Compression string   `json:"compression"`
+	// TransactionalID, suffixed with the host name, produces each record in
+	// a Kafka transaction, which makes the step a transactional sink: in
+	// exactly-once flows the transaction also produces the idempotency key
+	// of the write to CommitTopic, where redeliveries find it
+	TransactionalID string `json:"transactionalId"`
+	CommitTopic     string `json:"commitTopic"`
+}
+
+// kafkaStep produces the message body to a topic and passes the message on
+// once the brokers have acknowledged it
+type kafkaStep struct {
+	cfg      kafkaConfig
+	producer *kafkago.Writer
+	// txn and commits are set for transactional steps
+	txn     *kafka.TxnProducer
+	commits *kafka.CommitLog
+}
+
+func newKafka(config map[string]interface{}) (engine.Step, error) {
+	var cfg kafkaConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	if cfg.Key != "" && cfg.KeyHeader != "" {
+		return nil, errors.New("key and keyHeader are mutually exclusive")
+	}
+	if (cfg.TransactionalID == "") != (cfg.CommitTopic == "") {
+		return nil, errors.New("transactionalId and commitTopic must be set together")
+	}
+	if cfg.TransactionalID == "" {
+		producer, err := kafka.Producer(cfg.Config, kafka.ProducerOptions{Acks: cfg.Acks, Compression: cfg.Compression})
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaStep{cfg: cfg, producer: producer}, nil
+	}
+
+	if cfg.Acks != "" && cfg.Acks != "all" || cfg.Compression != "" {
+		return nil, errors.New("transactional records are produced with acks all and without compression")
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to name the transactional producer: %w", err)
+	}
+	txn, err := kafka.TransactionalProducer(cfg.Config, cfg.TransactionalID+"-"+host)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := kafka.Commits(cfg.Config, cfg.CommitTopic)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaStep{cfg: cfg, txn: txn, commits: commits}, nil
+}
+
+// Transactional implements engine.Sink. Without a transactionalId records
+// are produced outside a Kafka transaction, so a retried execution may
+// produce them again.
+func (s *kafkaStep) Transactional() bool { return s.txn != nil }
+
+// Committed implements engine.Sink by reading the commit topic
+func (s *kafkaStep) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	if s.commits == nil {
+		return false, nil
+	}
+	return s.commits.Committed(ctx, key)
+}
+
+// Warm implements engine.Warmer by checking that the topic exists
+func (s *kafkaStep) Warm(ctx context.Context) error {
+	if s.commits != nil {
+		return kafka.Ping(ctx, s.cfg.Config, s.cfg.Topic, s.cfg.CommitTopic)
+	}
+	return kafka.Ping(ctx, s.cfg.Config, s.cfg.Topic)
+}
+
+func (s *kafkaStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	record := kafkago.Message{Topic: s.cfg.Topic, Value: in.Body}
+	switch {
+	case s.cfg.Key != "":
+		record.Key = []byte(s.cfg.Key)
+	case s.cfg.KeyHeader != "":
+		if key := in.Headers[s.cfg.KeyHeader]; key != "" {
+			record.Key = []byte(key)
+		}
+	}
+	for _, name := range s.cfg.Headers {
+		if v, ok := in.Headers[name]; ok {
+			record.Headers = append(record.Headers, kafkago.Header{Key: name, Value: []byte(v)})
+		}
+	}
+	record.Headers = append(record.Headers, kafkago.Header{Key: engine.HeaderParentExecution, Value: []byte(sc.ExecutionID)})
+
+	for _, broker := range s.cfg.Brokers {
+		host, port := egress.SplitAddress(broker)
+		if err := sc.AllowEgress(ctx, host, port); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.CountCall(); err != nil {
+		return nil, err
+	}
+	if err := sc.CountEgress(int64(len(record.Key) + len(record.Value))); err != nil {
+		return nil, err
+	}
+	if s.txn != nil {
+		records := []kafkago.Message{record}
+		if key := sc.IdempotencyKey(); key != "" {
+			records = append(records, s.commits.Record(key))
+		}
+		if err := s.txn.Produce(ctx, records); err != nil {
+			return nil, err
+		}
+	} else if err := s.producer.WriteMessages(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to produce to %s: %w", s.cfg.Topic, err)
+	}
+	sc.Report("bytesWritten", len(in.Body))
+	return engine.Emit(in), nil
+}
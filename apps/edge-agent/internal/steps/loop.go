@@ -0,0 +1,325 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/quality"
+)
+
+// Modes of the loop step
+const (
+	// LoopWhile repeats its steps until the condition fails and emits the
+	// last result, e.g. to poll until a job is ready
+	LoopWhile = "while"
+	// LoopForEachPage emits the result of every iteration, e.g. each page
+	// of a paginated API, until the condition fails
+	LoopForEachPage = "forEachPage"
+)
+
+// PortExhausted receives the last result of a loop that reached its bounds
+// while its condition still held, when onExhausted is "emit"
+const PortExhausted = "exhausted"
+
+// HeaderLoopIteration numbers the iterations of a loop from 1, so that the
+// steps of the loop can address pages with {header.loop-iteration}
+const HeaderLoopIteration = "loop-iteration"
+
+// maxLoopIterations caps the maxIterations a loop may set
+const maxLoopIterations = 10000
+
+func init() {
+	engine.RegisterStep("loop", newLoop)
+}
+
+// loopConfig configures the loop step. Steps run in sequence each
+// iteration, the first on the loop's input and the others on the message
+// the previous one emitted; each iteration continues from the result of
+// the one before. While is checked after every iteration on its result.
+type loopConfig struct {
+	Mode  string          `json:"mode"`
+	Steps []model.Step    `json:"steps"`
+	While []loopCondition `json:"while"`
+	// MaxIterations and MaxDuration bound the loop and are required.
+	// MaxDuration is checked before each iteration.
+	MaxIterations int    `json:"maxIterations"`
+	MaxDuration   string `json:"maxDuration"`
+	// Interval is waited between iterations
+	Interval string `json:"interval"`
+	// OnExhausted is "fail" (the default) to fail the execution when the
+	// bounds are reached or "emit" to route the last result to the
+	// exhausted port
+	OnExhausted string `json:"onExhausted"`
+}
+
+// loopCondition is one condition of a loop; conditions are ANDed. Field is
+// "headers.<name>" or a dot-path into the JSON body, optionally prefixed
+// with "body." or "$.", and Op is one of the parquet-read filter operators.
+type loopCondition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// loopStep repeats a sequence of steps on a message within fixed bounds
+type loopStep struct {
+	cfg         loopConfig
+	steps       []loopBodyStep
+	maxDuration time.Duration
+	interval    time.Duration
+}
+
+// loopBodyStep is a step run by the loop
+type loopBodyStep struct {
+	id   string
+	step engine.Step
+}
+
+// loopSink is the loop step with a sink among its steps. It is never
+// transactional, since the sink writes once per iteration.
+type loopSink struct {
+	*loopStep
+}
+
+func newLoop(config map[string]interface{}) (engine.Step, error) {
+	cfg := loopConfig{Mode: LoopWhile, OnExhausted: "fail"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Mode != LoopWhile && cfg.Mode != LoopForEachPage {
+		return nil, fmt.Errorf("invalid mode %q: must be %s or %s", cfg.Mode, LoopWhile, LoopForEachPage)
+	}
+	if cfg.OnExhausted != "fail" && cfg.OnExhausted != "emit" {
+		return nil, fmt.Errorf("invalid onExhausted %q: must be fail or emit", cfg.OnExhausted)
+	}
+	if cfg.MaxIterations < 1 || cfg.MaxIterations > maxLoopIterations {
+		return nil, fmt.Errorf("maxIterations must be between 1 and %d", maxLoopIterations)
+	}
+	if cfg.MaxDuration == "" {
+		return nil, errors.New("maxDuration is required")
+	}
+	s := &loopStep{cfg: cfg}
+	d, err := time.ParseDuration(cfg.MaxDuration)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("maxDuration %q is not a positive duration", cfg.MaxDuration)
+	}
+	s.maxDuration = d
+	if cfg.Interval != "" {
+		if s.interval, err = time.ParseDuration(cfg.Interval); err != nil || s.interval < 0 {
+			return nil, fmt.Errorf("interval %q is not a valid duration", cfg.Interval)
+		}
+	}
+	if len(cfg.While) == 0 {
+		return nil, errors.New("at least one while condition is required")
+	}
+	for i, c := range cfg.While {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("while[%d]: %w", i, err)
+		}
+	}
+
+	if len(cfg.Steps) == 0 {
+		return nil, errors.New("at least one step is required")
+	}
+	sink := false
+	seen := make(map[string]bool, len(cfg.Steps))
+	for i, st := range cfg.Steps {
+		if st.ID == "" {
+			return nil, fmt.Errorf("steps[%d].id is required", i)
+		}
+		if seen[st.ID] {
+			return nil, fmt.Errorf("steps[%d].id %q is duplicated", i, st.ID)
+		}
+		seen[st.ID] = true
+		step, err := engine.NewStep(st.Type, st.Config)
+		if err != nil {
+			return nil, fmt.Errorf("steps[%d] (%s): %w", i, st.ID, err)
+		}
+		if _, ok := step.(engine.Resumable); ok {
+			return nil, fmt.Errorf("steps[%d] (%s): %s steps cannot run in a loop", i, st.ID, st.Type)
+		}
+		if _, ok := step.(engine.Sink); ok {
+			sink = true
+		}
+		s.steps = append(s.steps, loopBodyStep{id: st.ID, step: step})
+	}
+	if sink {
+		return &loopSink{s}, nil
+	}
+	return s, nil
+}
+
+// validate checks the condition's operator and value
+func (c loopCondition) validate() error {
+	if c.Field == "" {
+		return errors.New("field is required")
+	}
+	if !parquetOps[c.Op] {
+		return fmt.Errorf("invalid op %q for field %s", c.Op, c.Field)
+	}
+	if _, ok := c.Value.([]interface{}); c.Op == "in" && !ok {
+		return fmt.Errorf("op in on field %s requires a list value", c.Field)
+	}
+	return nil
+}
+
+// Connectors implements engine.ConnectorUser, listing the connectors of the
+// loop's steps
+func (s *loopStep) Connectors() []string {
+	var ids []string
+	for _, st := range s.steps {
+		if user, ok := st.step.(engine.ConnectorUser); ok {
+			ids = append(ids, user.Connectors()...)
+		}
+	}
+	return ids
+}
+
+func (s *loopStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	clk := sc.Clock()
+	deadline := clk.Now().Add(s.maxDuration)
+	var pages []engine.Output
+	msg := in
+	for i := 1; ; i++ {
+		if i > s.cfg.MaxIterations || !clk.Now().Before(deadline) {
+			sc.Report("iterations", i-1)
+			return s.exhausted(msg, pages, i-1)
+		}
+		if i > 1 && s.interval > 0 {
+			if err := s.wait(ctx, clk, min(s.interval, deadline.Sub(clk.Now()))); err != nil {
+				return nil, err
+			}
+		}
+
+		next := msg.Clone()
+		next.SetHeader(HeaderLoopIteration, strconv.Itoa(i))
+		result, err := s.iterate(ctx, sc, next)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		msg = result
+		if s.cfg.Mode == LoopForEachPage {
+			pages = append(pages, engine.Output{Port: engine.DefaultPort, Message: result})
+		}
+
+		more, err := s.holds(result)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		if !more {
+			sc.Report("iterations", i)
+			if s.cfg.Mode == LoopForEachPage {
+				return pages, nil
+			}
+			return engine.Emit(result), nil
+		}
+	}
+}
+
+// iterate runs the loop's steps in sequence on msg, each of which must emit
+// exactly one message on its default port
+func (s *loopStep) iterate(ctx context.Context, sc *engine.StepContext, msg *engine.Message) (*engine.Message, error) {
+	for _, st := range s.steps {
+		outputs, err := st.step.Run(ctx, sc, msg)
+		if err != nil {
+			return nil, fmt.Errorf("step %s failed: %w", st.id, err)
+		}
+		var next *engine.Message
+		n := 0
+		for _, out := range outputs {
+			if out.Port == engine.DefaultPort {
+				next = out.Message
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("step %s emitted %d messages on its default port, but steps in a loop must emit one", st.id, n)
+		}
+		msg = next
+	}
+	return msg, nil
+}
+
+// exhausted ends a loop that reached its bounds after n iterations
+func (s *loopStep) exhausted(last *engine.Message, pages []engine.Output, n int) ([]engine.Output, error) {
+	if s.cfg.OnExhausted != "emit" {
+		return nil, fmt.Errorf("loop stopped after %d iteration(s) with its condition still holding (maxIterations %d, maxDuration %s)", n, s.cfg.MaxIterations, s.maxDuration)
+	}
+	if s.cfg.Mode == LoopForEachPage {
+		return append(pages, engine.Output{Port: PortExhausted, Message: last.Clone()}), nil
+	}
+	return []engine.Output{{Port: PortExhausted, Message: last}}, nil
+}
+
+// wait blocks for d on the step's clock
+func (s *loopStep) wait(ctx context.Context, clk clock.Clock, d time.Duration) error {
+	timer := clk.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// holds reports whether every condition holds on msg
+func (s *loopStep) holds(msg *engine.Message) (bool, error) {
+	var body interface{}
+	decoded := false
+	for _, c := range s.cfg.While {
+		var v interface{}
+		if name, ok := strings.CutPrefix(c.Field, "headers."); ok {
+			if h, ok := msg.Headers[name]; ok {
+				v = h
+			}
+		} else {
+			if !decoded {
+				if err := msg.Buffer(engine.DefaultMaxBufferSize); err != nil {
+					return false, err
+				}
+				if len(msg.Body) > 0 {
+					if err := decodeNumbers(msg.Body, &body); err != nil {
+						return false, err
+					}
+				}
+				decoded = true
+			}
+			v = bodyField(body, c.Field)
+		}
+		if !(parquetPredicate{Op: c.Op, Value: c.Value}).holds(v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// bodyField returns the value at a condition's body path, or nil
+func bodyField(body interface{}, path string) interface{} {
+	path = strings.TrimPrefix(path, "$.")
+	path = strings.TrimPrefix(path, "body.")
+	if path == "body" || path == "$" {
+		return body
+	}
+	obj, ok := body.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	v, _ := quality.Field(obj, path)
+	return v
+}
+
+// Transactional implements engine.Sink
+func (s *loopSink) Transactional() bool { return false }
+
+// Committed implements engine.Sink
+func (s *loopSink) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
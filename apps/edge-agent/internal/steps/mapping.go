@@ -0,0 +1,71 @@
+package steps
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/mapping"
+)
+
+func init() {
+	engine.RegisterStep("map", newMap)
+}
+
+// mapStep reshapes JSON records with a declarative field table. An array
+// body is mapped element by element.
+type mapStep struct {
+	mapping *mapping.Mapping
+}
+
+func newMap(config map[string]interface{}) (engine.Step, error) {
+	var spec mapping.Spec
+	if err := engine.DecodeConfig(config, &spec); err != nil {
+		return nil, err
+	}
+	m, err := mapping.Compile(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &mapStep{mapping: m}, nil
+}
+
+func (s *mapStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	var body interface{}
+	if err := decodeNumbers(in.Body, &body); err != nil {
+		return nil, err
+	}
+
+	result, err := applyMapping(s.mapping, body)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := engine.JSONMessage(result)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Emit(withHeaders(msg, in)), nil
+}
+
+// applyMapping maps a decoded JSON object, or each object of an array
+func applyMapping(m *mapping.Mapping, body interface{}) (interface{}, error) {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		return m.Apply(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			record, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record %d is not an object", i)
+			}
+			out, err := m.Apply(record)
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+			list[i] = out
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected a JSON object or array body")
+}
@@ -0,0 +1,45 @@
+package steps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// PortInvalid receives messages that fail validation when a step routes them
+const PortInvalid = "invalid"
+
+// HeaderValidationProblems lists the problems of a message routed to PortInvalid
+const HeaderValidationProblems = "validation-problems"
+
+// decodeNumbers unmarshals a JSON body into v keeping numbers as json.Number
+// so integers survive unchanged
+func decodeNumbers(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode message body: %w", err)
+	}
+	return nil
+}
+
+// withHeaders copies the headers of from onto msg and returns msg
+func withHeaders(msg, from *engine.Message) *engine.Message {
+	for k, v := range from.Headers {
+		msg.SetHeader(k, v)
+	}
+	return msg
+}
+
+// connectorIDs lists the connectors named by ids, skipping empty ones
+func connectorIDs(ids ...string) []string {
+	var list []string
+	for _, id := range ids {
+		if id != "" {
+			list = append(list, id)
+		}
+	}
+	return list
+}
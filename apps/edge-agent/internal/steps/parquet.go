@@ -0,0 +1,542 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func init() {
+	engine.RegisterStep("parquet-read", newParquetRead)
+	engine.RegisterStep("parquet-write", newParquetWrite)
+}
+
+// Headers set on parquet-read outputs
+const (
+	HeaderParquetRowGroup = "parquet-row-group"
+	HeaderParquetBatch    = "parquet-batch"
+)
+
+// parquetReadConfig configures the parquet-read step
+type parquetReadConfig struct {
+	// Path is the file to read; when empty the message body is read
+	Path string `json:"path"`
+	// Columns projects the listed top-level columns; all columns by default
+	Columns []string `json:"columns"`
+	// Filter conditions are ANDed. Row groups whose statistics rule out a
+	// match are skipped without being read.
+	Filter []parquetPredicate `json:"filter"`
+	// BatchSize is the number of rows per emitted message
+	BatchSize int `json:"batchSize"`
+}
+
+// parquetRead reads a Parquet file into JSON row batches
+type parquetRead struct {
+	cfg parquetReadConfig
+}
+
+func newParquetRead(config map[string]interface{}) (engine.Step, error) {
+	cfg := parquetReadConfig{BatchSize: 1000}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, errors.New("batchSize must be positive")
+	}
+	for _, p := range cfg.Filter {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &parquetRead{cfg: cfg}, nil
+}
+
+func (s *parquetRead) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	var (
+		r    io.ReaderAt
+		size int64
+	)
+	if s.cfg.Path != "" {
+		f, err := os.Open(s.cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open parquet file: %w", err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat parquet file: %w", err)
+		}
+		r, size = f, info.Size()
+	} else {
+		r, size = bytes.NewReader(in.Body), int64(len(in.Body))
+	}
+
+	file, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet data: %w", err)
+	}
+
+	columns := file.Schema().Columns()
+	selected := s.projection(columns)
+	md := file.Metadata()
+
+	var (
+		outputs []engine.Output
+		batch   []map[string]interface{}
+		skipped int
+		read    int64
+	)
+	flush := func(rowGroup int) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msg, err := engine.JSONMessage(batch)
+		if err != nil {
+			return err
+		}
+		withHeaders(msg, in)
+		msg.SetHeader(HeaderParquetRowGroup, strconv.Itoa(rowGroup))
+		msg.SetHeader(HeaderParquetBatch, strconv.Itoa(len(outputs)))
+		outputs = append(outputs, engine.Output{Port: engine.DefaultPort, Message: msg})
+		batch = nil
+		return nil
+	}
+
+	for i, rg := range file.RowGroups() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !s.mayMatch(md, i, columns) {
+			skipped++
+			continue
+		}
+		rows, err := readRowGroup(rg, columns, selected, file.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row group %d: %w", i, err)
+		}
+		read += rg.NumRows()
+		for _, row := range rows {
+			if !s.match(row) {
+				continue
+			}
+			for _, name := range s.cfg.Columns {
+				if _, ok := row[name]; !ok {
+					row[name] = nil
+				}
+			}
+			s.project(row)
+			batch = append(batch, row)
+			if len(batch) == s.cfg.BatchSize {
+				if err := flush(i); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := flush(i); err != nil {
+			return nil, err
+		}
+	}
+
+	sc.Report("rowGroupsSkipped", skipped)
+	sc.Report("rowsScanned", read)
+	return outputs, nil
+}
+
+// projection returns the leaf column indexes needed for the projected and
+// filtered columns
+func (s *parquetRead) projection(columns [][]string) []bool {
+	selected := make([]bool, len(columns))
+	if len(s.cfg.Columns) == 0 {
+		for i := range selected {
+			selected[i] = true
+		}
+		return selected
+	}
+	want := make(map[string]bool)
+	for _, c := range s.cfg.Columns {
+		want[c] = true
+	}
+	for _, p := range s.cfg.Filter {
+		want[p.Column] = true
+	}
+	for i, path := range columns {
+		selected[i] = want[path[0]]
+	}
+	return selected
+}
+
+// project drops columns read only for filtering
+func (s *parquetRead) project(row map[string]interface{}) {
+	if len(s.cfg.Columns) == 0 {
+		return
+	}
+	keep := make(map[string]bool, len(s.cfg.Columns))
+	for _, c := range s.cfg.Columns {
+		keep[c] = true
+	}
+	for k := range row {
+		// Nested columns are keyed by their dotted path
+		if top, _, _ := strings.Cut(k, "."); !keep[top] {
+			delete(row, k)
+		}
+	}
+}
+
+func (s *parquetRead) match(row map[string]interface{}) bool {
+	for _, p := range s.cfg.Filter {
+		if !p.match(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatch checks the filter against the row group's column statistics
+func (s *parquetRead) mayMatch(md *format.FileMetaData, rowGroup int, columns [][]string) bool {
+	if md == nil || rowGroup >= len(md.RowGroups) {
+		return true
+	}
+	chunks := md.RowGroups[rowGroup].Columns
+	for _, p := range s.cfg.Filter {
+		for i, path := range columns {
+			if i >= len(chunks) || len(path) != 1 || path[0] != p.Column {
+				continue
+			}
+			min, max, ok := chunkBounds(&chunks[i].MetaData)
+			if !p.mayMatch(min, max, ok) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// chunkBounds decodes the min and max statistics of a column chunk
+func chunkBounds(meta *format.ColumnMetaData) (interface{}, interface{}, bool) {
+	stats := meta.Statistics
+	lo, hi := stats.MinValue, stats.MaxValue
+	if lo == nil || hi == nil {
+		return nil, nil, false
+	}
+	decode := func(b []byte) (interface{}, bool) {
+		switch meta.Type {
+		case format.Boolean:
+			return len(b) == 1 && b[0] != 0, len(b) == 1
+		case format.Int32:
+			if len(b) != 4 {
+				return nil, false
+			}
+			return int64(int32(binary.LittleEndian.Uint32(b))), true
+		case format.Int64:
+			if len(b) != 8 {
+				return nil, false
+			}
+			return int64(binary.LittleEndian.Uint64(b)), true
+		case format.Float:
+			if len(b) != 4 {
+				return nil, false
+			}
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), true
+		case format.Double:
+			if len(b) != 8 {
+				return nil, false
+			}
+			return math.Float64frombits(binary.LittleEndian.Uint64(b)), true
+		case format.ByteArray:
+			return string(b), true
+		}
+		return nil, false
+	}
+	min, okMin := decode(lo)
+	max, okMax := decode(hi)
+	return min, max, okMin && okMax
+}
+
+// readRowGroup decodes the selected columns of a row group into rows. Only
+// the selected column chunks are read. Repeated leaves become lists and
+// nested columns are keyed by their dotted path.
+func readRowGroup(rg parquet.RowGroup, columns [][]string, selected []bool, schema *parquet.Schema) ([]map[string]interface{}, error) {
+	n := int(rg.NumRows())
+	rows := make([]map[string]interface{}, n)
+	for i := range rows {
+		rows[i] = make(map[string]interface{})
+	}
+
+	for col, chunk := range rg.ColumnChunks() {
+		if col >= len(columns) || !selected[col] {
+			continue
+		}
+		name := strings.Join(columns[col], ".")
+		convert := leafConverter(schema, columns[col])
+
+		pages := chunk.Pages()
+		row := -1
+		err := func() error {
+			defer pages.Close()
+			for {
+				page, err := pages.ReadPage()
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				values := make([]parquet.Value, page.NumValues())
+				if _, err := page.Values().ReadValues(values); err != nil && !errors.Is(err, io.EOF) {
+					return err
+				}
+				for _, v := range values {
+					if v.RepetitionLevel() == 0 {
+						row++
+					}
+					if row < 0 || row >= n {
+						return fmt.Errorf("column %s has more values than rows", name)
+					}
+					if v.IsNull() {
+						if _, ok := rows[row][name]; !ok {
+							rows[row][name] = nil
+						}
+						continue
+					}
+					value := convert(v)
+					if v.RepetitionLevel() == 0 && !isRepeated(schema, columns[col]) {
+						rows[row][name] = value
+						continue
+					}
+					list, _ := rows[row][name].([]interface{})
+					rows[row][name] = append(list, value)
+				}
+			}
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read column %s: %w", name, err)
+		}
+	}
+	return rows, nil
+}
+
+// leafConverter returns a function converting values of a leaf column to
+// JSON-friendly Go values; timestamps become RFC 3339 strings
+func leafConverter(schema *parquet.Schema, path []string) func(parquet.Value) interface{} {
+	var unit time.Duration
+	if leaf, ok := schema.Lookup(path...); ok {
+		if lt := leaf.Node.Type().LogicalType(); lt != nil && lt.Timestamp != nil {
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				unit = time.Millisecond
+			case lt.Timestamp.Unit.Micros != nil:
+				unit = time.Microsecond
+			case lt.Timestamp.Unit.Nanos != nil:
+				unit = time.Nanosecond
+			}
+		}
+	}
+
+	return func(v parquet.Value) interface{} {
+		switch v.Kind() {
+		case parquet.Boolean:
+			return v.Boolean()
+		case parquet.Int32:
+			return int64(v.Int32())
+		case parquet.Int64:
+			if unit != 0 {
+				return time.Unix(0, v.Int64()*int64(unit)).UTC().Format(time.RFC3339Nano)
+			}
+			return v.Int64()
+		case parquet.Float:
+			return float64(v.Float())
+		case parquet.Double:
+			return v.Double()
+		case parquet.ByteArray, parquet.FixedLenByteArray:
+			return string(v.ByteArray())
+		}
+		return v.String()
+	}
+}
+
+// isRepeated reports whether any node on the path is repeated
+func isRepeated(schema *parquet.Schema, path []string) bool {
+	leaf, ok := schema.Lookup(path...)
+	return ok && leaf.MaxRepetitionLevel > 0
+}
+
+// parquetWriteConfig configures the parquet-write step
+type parquetWriteConfig struct {
+	// Path is the file to write and may contain {executionId}, {stepId} and
+	// {timestamp}; when empty the Parquet data replaces the message body
+	Path string `json:"path"`
+	// Columns declares the schema; by default it is inferred from the rows
+	Columns []parquetColumn `json:"columns"`
+	// Compression is one of snappy (the default), gzip, zstd, lz4, brotli or none
+	Compression string `json:"compression"`
+	// RowGroupSize is the maximum number of rows per row group
+	RowGroupSize int64 `json:"rowGroupSize"`
+	// PageSize is the target page size in bytes
+	PageSize int `json:"pageSize"`
+}
+
+// parquetWrite writes JSON rows as a Parquet file
+type parquetWrite struct {
+	cfg   parquetWriteConfig
+	codec compress.Codec
+}
+
+func newParquetWrite(config map[string]interface{}) (engine.Step, error) {
+	cfg := parquetWriteConfig{Compression: "snappy", RowGroupSize: 128 * 1024, PageSize: 1 << 20}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	codec, err := parquetCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RowGroupSize <= 0 || cfg.PageSize <= 0 {
+		return nil, errors.New("rowGroupSize and pageSize must be positive")
+	}
+	for _, c := range cfg.Columns {
+		switch c.Type {
+		case parquetString, parquetInt64, parquetDouble, parquetBoolean, parquetTimestamp:
+		default:
+			return nil, fmt.Errorf("column %s has unsupported type %q", c.Name, c.Type)
+		}
+	}
+	return &parquetWrite{cfg: cfg, codec: codec}, nil
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch name {
+	case "snappy":
+		return &parquet.Snappy, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "lz4":
+		return &parquet.Lz4Raw, nil
+	case "brotli":
+		return &parquet.Brotli, nil
+	case "none":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", name)
+}
+
+// Transactional implements engine.Sink. Writing to the message body has no
+// external effect; files may be written again after a crash.
+func (s *parquetWrite) Transactional() bool { return s.cfg.Path == "" }
+
+// Committed implements engine.Sink
+func (s *parquetWrite) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
+
+func (s *parquetWrite) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	rows, err := decodeRows(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	columns := s.cfg.Columns
+	if len(columns) == 0 {
+		columns = inferColumns(rows)
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("cannot write parquet without columns")
+	}
+
+	var buf bytes.Buffer
+	if err := s.write(&buf, columns, rows); err != nil {
+		return nil, err
+	}
+	sc.Report("rowsWritten", len(rows))
+
+	if s.cfg.Path == "" {
+		return engine.Emit(in.WithBody(buf.Bytes(), ContentTypeParquet)), nil
+	}
+	path := expandPath(s.cfg.Path, sc)
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	out, err := engine.JSONMessage(map[string]interface{}{"path": path, "rows": len(rows), "bytes": buf.Len()})
+	if err != nil {
+		return nil, err
+	}
+	return engine.Emit(withHeaders(out, in)), nil
+}
+
+// write encodes rows with a flat schema of optional columns
+func (s *parquetWrite) write(w io.Writer, columns []parquetColumn, rows []map[string]interface{}) error {
+	group := make(parquet.Group, len(columns))
+	for _, c := range columns {
+		var node parquet.Node
+		switch c.Type {
+		case parquetInt64:
+			node = parquet.Int(64)
+		case parquetDouble:
+			node = parquet.Leaf(parquet.DoubleType)
+		case parquetBoolean:
+			node = parquet.Leaf(parquet.BooleanType)
+		case parquetTimestamp:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			node = parquet.String()
+		}
+		group[c.Name] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("row", group)
+
+	// Group fields are ordered by name, which fixes the column indexes
+	types := make(map[string]string, len(columns))
+	for _, c := range columns {
+		types[c.Name] = c.Type
+	}
+	leaves := schema.Columns()
+
+	writer := parquet.NewWriter(w, schema,
+		parquet.Compression(s.codec),
+		parquet.MaxRowsPerRowGroup(s.cfg.RowGroupSize),
+		parquet.PageBufferSize(s.cfg.PageSize),
+	)
+	batch := make([]parquet.Row, 0, 256)
+	for i, row := range rows {
+		values := make(parquet.Row, len(leaves))
+		for col, path := range leaves {
+			name := path[0]
+			v, err := convertValue(types[name], row[name])
+			if err != nil {
+				return fmt.Errorf("row %d column %s: %w", i, name, err)
+			}
+			if v == nil {
+				values[col] = parquet.Value{}.Level(0, 0, col)
+				continue
+			}
+			values[col] = parquet.ValueOf(v).Level(0, 1, col)
+		}
+		batch = append(batch, values)
+		if len(batch) == cap(batch) {
+			if _, err := writer.WriteRows(batch); err != nil {
+				return fmt.Errorf("failed to write parquet rows: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if _, err := writer.WriteRows(batch); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+	return nil
+}
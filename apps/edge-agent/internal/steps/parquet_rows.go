@@ -0,0 +1,321 @@
+package steps
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContentTypeParquet is the media type of Parquet file bodies
+const ContentTypeParquet = "application/vnd.apache.parquet"
+
+// parquetPredicate is one condition of a parquet-read filter; conditions are ANDed
+type parquetPredicate struct {
+	Column string      `json:"column"`
+	Op     string      `json:"op"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+var parquetOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"in": true, "null": true, "notnull": true,
+}
+
+func (p parquetPredicate) validate() error {
+	if p.Column == "" {
+		return fmt.Errorf("filter column is required")
+	}
+	if !parquetOps[p.Op] {
+		return fmt.Errorf("invalid filter op %q for column %s", p.Op, p.Column)
+	}
+	if p.Op == "in" {
+		if _, ok := p.Value.([]interface{}); !ok {
+			return fmt.Errorf("filter op in on column %s requires a list value", p.Column)
+		}
+	}
+	return nil
+}
+
+// match evaluates the predicate on a decoded row
+func (p parquetPredicate) match(row map[string]interface{}) bool {
+	return p.holds(row[p.Column])
+}
+
+// holds evaluates the predicate's operator on v, ignoring its column
+func (p parquetPredicate) holds(v interface{}) bool {
+	switch p.Op {
+	case "null":
+		return v == nil
+	case "notnull":
+		return v != nil
+	case "in":
+		for _, candidate := range p.Value.([]interface{}) {
+			if c, ok := compareValues(v, candidate); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	c, ok := compareValues(v, p.Value)
+	if !ok {
+		// Incomparable values, including nulls, only satisfy "ne"
+		return p.Op == "ne"
+	}
+	return opHolds(p.Op, c)
+}
+
+// mayMatch reports whether a row group whose column values lie within
+// [min, max] could hold a matching row. Unknown bounds always may match.
+func (p parquetPredicate) mayMatch(min, max interface{}, hasStats bool) bool {
+	if !hasStats {
+		return true
+	}
+	lo, okLo := compareValues(p.Value, min)
+	hi, okHi := compareValues(p.Value, max)
+	if !okLo || !okHi {
+		return true
+	}
+	switch p.Op {
+	case "eq":
+		return lo >= 0 && hi <= 0
+	case "lt":
+		return lo > 0
+	case "le":
+		return lo >= 0
+	case "gt":
+		return hi < 0
+	case "ge":
+		return hi <= 0
+	}
+	return true
+}
+
+func opHolds(op string, c int) bool {
+	switch op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	}
+	return false
+}
+
+// compareValues orders a and b when both are numbers, strings or booleans
+func compareValues(a, b interface{}) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	case bool:
+		bv, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case av == bv:
+			return 0, true
+		case !av:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Column types supported by parquet-write
+const (
+	parquetString    = "string"
+	parquetInt64     = "int64"
+	parquetDouble    = "double"
+	parquetBoolean   = "boolean"
+	parquetTimestamp = "timestamp"
+)
+
+// parquetColumn declares one column written by parquet-write
+type parquetColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// inferColumns derives a column per key from the rows, sorted by name.
+// Integral numbers become int64 unless any value in the column has a
+// fraction; objects and lists are written as JSON strings.
+func inferColumns(rows []map[string]interface{}) []parquetColumn {
+	types := make(map[string]string)
+	for _, row := range rows {
+		for k, v := range row {
+			t := valueType(v)
+			switch prev, seen := types[k]; {
+			case t == "":
+				if !seen {
+					types[k] = ""
+				}
+			case !seen || prev == "":
+				types[k] = t
+			case prev == parquetInt64 && t == parquetDouble:
+				types[k] = parquetDouble
+			case prev != t && !(prev == parquetDouble && t == parquetInt64):
+				types[k] = parquetString
+			}
+		}
+	}
+
+	columns := make([]parquetColumn, 0, len(types))
+	for name, t := range types {
+		if t == "" {
+			// Only nulls were seen
+			t = parquetString
+		}
+		columns = append(columns, parquetColumn{Name: name, Type: t})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+func valueType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return parquetBoolean
+	case string:
+		return parquetString
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return parquetInt64
+		}
+		return parquetDouble
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return parquetInt64
+		}
+		return parquetDouble
+	}
+	return parquetString
+}
+
+// convertValue converts a decoded JSON value into the Go value written for
+// a column of type t
+func convertValue(t string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t {
+	case parquetBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean, got %T", v)
+		}
+		return b, nil
+	case parquetInt64:
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("expected an integer, got %s", n)
+			}
+			return i, nil
+		}
+		f, ok := toFloat(v)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("expected an integer, got %v", v)
+		}
+		return int64(f), nil
+	case parquetDouble:
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("expected a number, got %T", v)
+		}
+		return f, nil
+	case parquetTimestamp:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an RFC 3339 timestamp, got %T", v)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC 3339 timestamp: %w", err)
+		}
+		return ts.UnixMilli(), nil
+	default:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(s)
+			if err != nil {
+				return nil, err
+			}
+			return string(data), nil
+		}
+		return fmt.Sprint(v), nil
+	}
+}
+
+// decodeRows reads a JSON array of objects, or a single object, from body
+func decodeRows(body []byte) ([]map[string]interface{}, error) {
+	var v interface{}
+	if err := decodeNumbers(body, &v); err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{t}, nil
+	case []interface{}:
+		rows := make([]map[string]interface{}, 0, len(t))
+		for i, item := range t {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("row %d is not an object", i)
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("expected an object or an array of objects")
+}
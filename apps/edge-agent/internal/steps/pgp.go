@@ -0,0 +1,464 @@
+package steps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+// Headers set by the pgp step
+const (
+	// HeaderPGPSignature holds the armored detached signature of the body
+	HeaderPGPSignature = "pgp-signature"
+	// HeaderPGPSigner is the fingerprint of the key a verified signature
+	// was made with
+	HeaderPGPSigner = "pgp-signer"
+)
+
+// Operations of the pgp step
+const (
+	pgpEncrypt = "encrypt"
+	pgpDecrypt = "decrypt"
+	pgpSign    = "sign"
+	pgpVerify  = "verify"
+)
+
+func init() {
+	engine.RegisterStep("pgp", newPGP)
+}
+
+// pgpConfig configures the pgp step. Keys are armored keyrings read from
+// the secrets they reference, as env:NAME or file:NAME, on every message,
+// so a partner's rotated key applies once its secret is updated.
+type pgpConfig struct {
+	// Operation is encrypt, decrypt, sign or verify
+	Operation string `json:"operation"`
+	// PublicKeys references the public keys to encrypt to, or whose
+	// signatures are accepted
+	PublicKeys string `json:"publicKeys"`
+	// PrivateKey references the private key to decrypt or sign with;
+	// encryption also signs when it is set
+	PrivateKey string `json:"privateKey"`
+	// Passphrase references the passphrase protecting PrivateKey
+	Passphrase string `json:"passphrase"`
+	// Armor writes ASCII-armored messages; detached signatures always are
+	Armor bool `json:"armor"`
+	// Detached signs into the pgp-signature header, leaving the body as
+	// is, and verifies the signature held by that header
+	Detached bool `json:"detached"`
+	// RequireSignature fails the decryption of messages not signed with one
+	// of PublicKeys
+	RequireSignature bool `json:"requireSignature"`
+	// OnInvalid is "fail" (the default) to fail the execution on a bad
+	// signature or "route" to emit the message on the invalid port
+	OnInvalid string `json:"onInvalid"`
+	// ContentType of decrypted and verified messages defaults to the type
+	// registered for the extension of their file name
+	ContentType string `json:"contentType"`
+}
+
+// pgpStep encrypts, decrypts, signs or verifies OpenPGP messages, streaming
+// their bodies
+type pgpStep struct {
+	cfg pgpConfig
+}
+
+func newPGP(config map[string]interface{}) (engine.Step, error) {
+	cfg := pgpConfig{OnInvalid: "fail"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	for _, ref := range []string{cfg.PublicKeys, cfg.PrivateKey, cfg.Passphrase} {
+		if ref == "" {
+			continue
+		}
+		if err := secrets.Validate(ref); err != nil {
+			return nil, err
+		}
+	}
+	switch cfg.Operation {
+	case pgpEncrypt, pgpVerify:
+		if cfg.PublicKeys == "" {
+			return nil, fmt.Errorf("publicKeys is required to %s", cfg.Operation)
+		}
+	case pgpDecrypt, pgpSign:
+		if cfg.PrivateKey == "" {
+			return nil, fmt.Errorf("privateKey is required to %s", cfg.Operation)
+		}
+		if cfg.RequireSignature && cfg.PublicKeys == "" {
+			return nil, errors.New("publicKeys is required with requireSignature")
+		}
+	default:
+		return nil, fmt.Errorf("invalid operation %q: must be encrypt, decrypt, sign or verify", cfg.Operation)
+	}
+	if cfg.OnInvalid != "fail" && cfg.OnInvalid != "route" {
+		return nil, fmt.Errorf("invalid onInvalid %q: must be fail or route", cfg.OnInvalid)
+	}
+	return &pgpStep{cfg: cfg}, nil
+}
+
+// Streaming implements engine.StreamingStep
+func (s *pgpStep) Streaming() bool { return true }
+
+func (s *pgpStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	switch s.cfg.Operation {
+	case pgpEncrypt:
+		return s.encrypt(in)
+	case pgpDecrypt:
+		return s.decrypt(in)
+	case pgpSign:
+		return s.sign(in)
+	default:
+		return s.verify(sc, in)
+	}
+}
+
+func (s *pgpStep) encrypt(in *engine.Message) ([]engine.Output, error) {
+	to, err := s.publicKeys()
+	if err != nil {
+		return nil, err
+	}
+	var signer *openpgp.Entity
+	if s.cfg.PrivateKey != "" {
+		if signer, err = s.signer(); err != nil {
+			return nil, err
+		}
+	}
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	name := in.Headers[HeaderFileName]
+	out := in.WithStream(pipeThrough(src, func(w io.Writer) (io.WriteCloser, error) {
+		return s.armored(w, "PGP MESSAGE", func(w io.Writer) (io.WriteCloser, error) {
+			return openpgp.Encrypt(w, to, signer, &openpgp.FileHints{IsBinary: true, FileName: name}, nil)
+		})
+	}), pgpContentType(s.cfg.Armor), -1)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name+pgpExtension(s.cfg.Armor))
+	}
+	return engine.Emit(out), nil
+}
+
+func (s *pgpStep) decrypt(in *engine.Message) ([]engine.Output, error) {
+	keys, err := s.privateKeys()
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.RequireSignature {
+		signers, err := s.publicKeys()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signers...)
+	}
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	r, err := dearmor(src)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("failed to decrypt message body: %w", err)
+	}
+	if s.cfg.RequireSignature && !md.IsSigned {
+		src.Close()
+		return nil, errors.New("message is not signed")
+	}
+
+	body := io.Reader(md.UnverifiedBody)
+	if s.cfg.RequireSignature {
+		body = &signedReader{md: md}
+	}
+	name := trimPGPExtension(in.Headers[HeaderFileName])
+	out := in.WithStream(readCloser{Reader: body, closers: []io.Closer{src}}, decodedType(s.cfg.ContentType, name), -1)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name)
+	}
+	return engine.Emit(out), nil
+}
+
+func (s *pgpStep) sign(in *engine.Message) ([]engine.Output, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.Detached {
+		// Sign a spooled copy so the body passes on unread
+		spool := in.Clone()
+		src, err := spool.Reader()
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, signer, src, nil); err != nil {
+			return nil, fmt.Errorf("failed to sign message body: %w", err)
+		}
+		in.SetHeader(HeaderPGPSignature, sig.String())
+		return engine.Emit(in), nil
+	}
+
+	src, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	name := in.Headers[HeaderFileName]
+	out := in.WithStream(pipeThrough(src, func(w io.Writer) (io.WriteCloser, error) {
+		return s.armored(w, "PGP MESSAGE", func(w io.Writer) (io.WriteCloser, error) {
+			return openpgp.Sign(w, signer, &openpgp.FileHints{IsBinary: true, FileName: name}, nil)
+		})
+	}), pgpContentType(s.cfg.Armor), -1)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name+pgpExtension(s.cfg.Armor))
+	}
+	return engine.Emit(out), nil
+}
+
+// verify checks the signature of a spooled copy of the body before passing
+// the message on, so that a bad signature can be routed
+func (s *pgpStep) verify(sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	keys, err := s.publicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	spool := in.Clone()
+	src, err := spool.Reader()
+	if err != nil {
+		return nil, err
+	}
+	var signer *openpgp.Entity
+	if s.cfg.Detached {
+		signer, err = verifyDetached(keys, src, in.Headers[HeaderPGPSignature])
+	} else {
+		signer, err = verifyInline(keys, src)
+	}
+	src.Close()
+	if err != nil {
+		sc.Report("pgpInvalid", err.Error())
+		if s.cfg.OnInvalid == "fail" {
+			return nil, fmt.Errorf("signature verification failed: %w", err)
+		}
+		in.SetHeader(HeaderValidationProblems, err.Error())
+		return []engine.Output{{Port: PortInvalid, Message: in}}, nil
+	}
+
+	fingerprint := fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+	if s.cfg.Detached {
+		in.SetHeader(HeaderPGPSigner, fingerprint)
+		return engine.Emit(in), nil
+	}
+
+	// Pass on the signed content, read again from the message
+	body, err := in.Reader()
+	if err != nil {
+		return nil, err
+	}
+	r, err := dearmor(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	name := trimPGPExtension(in.Headers[HeaderFileName])
+	out := in.WithStream(readCloser{Reader: md.UnverifiedBody, closers: []io.Closer{body}}, decodedType(s.cfg.ContentType, name), -1)
+	out.SetHeader(HeaderPGPSigner, fingerprint)
+	if name != "" {
+		out.SetHeader(HeaderFileName, name)
+	}
+	return engine.Emit(out), nil
+}
+
+// verifyDetached checks an armored detached signature of r
+func verifyDetached(keys openpgp.EntityList, r io.Reader, signature string) (*openpgp.Entity, error) {
+	if signature == "" {
+		return nil, fmt.Errorf("message has no %s header", HeaderPGPSignature)
+	}
+	return openpgp.CheckArmoredDetachedSignature(keys, r, strings.NewReader(signature), nil)
+}
+
+// verifyInline reads a signed message through and checks its signature
+func verifyInline(keys openpgp.EntityList, r io.Reader) (*openpgp.Entity, error) {
+	r, err := dearmor(r)
+	if err != nil {
+		return nil, err
+	}
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !md.IsSigned {
+		return nil, errors.New("message is not signed")
+	}
+	if _, err := io.Copy(io.Discard, md.UnverifiedBody); err != nil {
+		return nil, err
+	}
+	if md.SignatureError != nil {
+		return nil, md.SignatureError
+	}
+	if md.SignedBy == nil {
+		return nil, errors.New("message is signed by an unknown key")
+	}
+	return md.SignedBy.Entity, nil
+}
+
+// signedReader fails at the end of a body whose signature does not verify
+type signedReader struct {
+	md *openpgp.MessageDetails
+}
+
+func (r *signedReader) Read(p []byte) (int, error) {
+	n, err := r.md.UnverifiedBody.Read(p)
+	if err == io.EOF {
+		if r.md.SignatureError != nil {
+			return n, fmt.Errorf("signature verification failed: %w", r.md.SignatureError)
+		}
+		if r.md.SignedBy == nil {
+			return n, errors.New("message is signed by an unknown key")
+		}
+	}
+	return n, err
+}
+
+// armored wraps the writers of open in ASCII armor when configured
+func (s *pgpStep) armored(w io.Writer, blockType string, open func(w io.Writer) (io.WriteCloser, error)) (io.WriteCloser, error) {
+	if !s.cfg.Armor {
+		return open(w)
+	}
+	aw, err := armor.Encode(w, blockType, nil)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := open(aw)
+	if err != nil {
+		return nil, err
+	}
+	return armoredWriter{WriteCloser: pw, armor: aw}, nil
+}
+
+// dearmor decodes r when it starts with ASCII armor, and returns it as is
+// otherwise
+func dearmor(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len("-----BEGIN PGP"))
+	if string(head) != "-----BEGIN PGP" {
+		return br, nil
+	}
+	block, err := armor.Decode(br)
+	if err != nil {
+		return nil, fmt.Errorf("invalid armored message: %w", err)
+	}
+	return block.Body, nil
+}
+
+func (s *pgpStep) publicKeys() (openpgp.EntityList, error) {
+	return readKeyRing(s.cfg.PublicKeys)
+}
+
+// privateKeys reads the private keyring, decrypting its keys with the
+// passphrase when one is configured
+func (s *pgpStep) privateKeys() (openpgp.EntityList, error) {
+	keys, err := readKeyRing(s.cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Passphrase == "" {
+		return keys, nil
+	}
+	passphrase, err := secrets.Resolve(s.cfg.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	passphrase = bytes.TrimRight(passphrase, "\r\n")
+	for _, e := range keys {
+		if e.PrivateKey != nil && e.PrivateKey.Encrypted {
+			if err := e.PrivateKey.Decrypt(passphrase); err != nil {
+				return nil, fmt.Errorf("failed to unlock private key: %w", err)
+			}
+		}
+		for _, sub := range e.Subkeys {
+			if sub.PrivateKey != nil && sub.PrivateKey.Encrypted {
+				if err := sub.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, fmt.Errorf("failed to unlock private subkey: %w", err)
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// signer is the first key of the private keyring
+func (s *pgpStep) signer() (*openpgp.Entity, error) {
+	keys, err := s.privateKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range keys {
+		if e.PrivateKey != nil {
+			return e, nil
+		}
+	}
+	return nil, errors.New("private key secret holds no private key")
+}
+
+// readKeyRing parses the armored or binary keyring held by a secret
+func readKeyRing(ref string) (openpgp.EntityList, error) {
+	secret, err := secrets.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	var keys openpgp.EntityList
+	if bytes.Contains(secret, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(secret))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(secret))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring in %s: %w", ref, err)
+	}
+	return keys, nil
+}
+
+func pgpContentType(armored bool) string {
+	if armored {
+		return "application/pgp-encrypted"
+	}
+	return "application/octet-stream"
+}
+
+func pgpExtension(armored bool) string {
+	if armored {
+		return ".asc"
+	}
+	return ".pgp"
+}
+
+// trimPGPExtension removes the extensions OpenPGP files are given
+func trimPGPExtension(name string) string {
+	for _, ext := range []string{".pgp", ".gpg", ".asc"} {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			return trimmed
+		}
+	}
+	return name
+}
@@ -0,0 +1,217 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/quality"
+)
+
+// Ports of the data-quality step
+const (
+	PortQuarantine = "quarantine"
+	PortReport     = "report"
+)
+
+// HeaderQualityViolations carries the JSON-encoded violations of a message
+const HeaderQualityViolations = "dq-violations"
+
+func init() {
+	engine.RegisterStep("data-quality", newDataQuality)
+}
+
+// dataQualityConfig configures the data-quality step
+type dataQualityConfig struct {
+	// Mode is "message" to check the body as one record or "batch" to check
+	// each element of a JSON array body
+	Mode  string         `json:"mode"`
+	Rules []quality.Rule `json:"rules"`
+	// OnFailure is "quarantine" (the default) to route failing records to
+	// the quarantine port or "fail" to fail the execution
+	OnFailure string `json:"onFailure"`
+	// Report emits the execution's quality report on the report port after
+	// each message
+	Report bool `json:"report"`
+}
+
+// dataQuality evaluates declarative rules and routes failing records
+type dataQuality struct {
+	cfg   dataQualityConfig
+	rules *quality.RuleSet
+}
+
+// quarantinedRecord is one failing record of a batch on the quarantine port
+type quarantinedRecord struct {
+	Record     interface{}         `json:"record"`
+	Violations []quality.Violation `json:"violations"`
+}
+
+func newDataQuality(config map[string]interface{}) (engine.Step, error) {
+	cfg := dataQualityConfig{Mode: "message", OnFailure: "quarantine"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Mode != "message" && cfg.Mode != "batch" {
+		return nil, fmt.Errorf("invalid mode %q: must be message or batch", cfg.Mode)
+	}
+	if cfg.OnFailure != "quarantine" && cfg.OnFailure != "fail" {
+		return nil, fmt.Errorf("invalid onFailure %q: must be quarantine or fail", cfg.OnFailure)
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, errors.New("at least one rule is required")
+	}
+	rules, err := quality.Compile(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return &dataQuality{cfg: cfg, rules: rules}, nil
+}
+
+// Connectors implements engine.ConnectorUser, listing the connectors of
+// reference rules
+func (s *dataQuality) Connectors() []string {
+	ids := make([]string, len(s.cfg.Rules))
+	for i, rule := range s.cfg.Rules {
+		ids[i] = rule.Connector
+	}
+	return connectorIDs(ids...)
+}
+
+func (s *dataQuality) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	// Batches of one execution share a report
+	report, ok := sc.Metrics()["quality"].(*quality.Report)
+	if !ok {
+		report = quality.NewReport()
+		sc.Report("quality", report)
+	}
+	checker := s.rules.NewChecker(sc.Lookup, report)
+
+	var (
+		outputs []engine.Output
+		err     error
+	)
+	if s.cfg.Mode == "batch" {
+		outputs, err = s.runBatch(ctx, checker, in)
+	} else {
+		outputs, err = s.runMessage(ctx, checker, in)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.Report {
+		msg, err := engine.JSONMessage(report)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, engine.Output{Port: PortReport, Message: msg})
+	}
+	return outputs, nil
+}
+
+func (s *dataQuality) runMessage(ctx context.Context, checker *quality.Checker, in *engine.Message) ([]engine.Output, error) {
+	var record map[string]interface{}
+	if err := decodeNumbers(in.Body, &record); err != nil {
+		return nil, err
+	}
+	violations, err := checker.CheckRow(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	violations = append(violations, checker.CheckBatch(1)...)
+
+	out := in.Clone()
+	if len(violations) > 0 {
+		encoded, err := json.Marshal(violations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode violations: %w", err)
+		}
+		out.SetHeader(HeaderQualityViolations, string(encoded))
+	}
+	if !quality.HasErrors(violations) {
+		return engine.Emit(out), nil
+	}
+	if s.cfg.OnFailure == "fail" {
+		return nil, failure(violations)
+	}
+	return []engine.Output{{Port: PortQuarantine, Message: out}}, nil
+}
+
+func (s *dataQuality) runBatch(ctx context.Context, checker *quality.Checker, in *engine.Message) ([]engine.Output, error) {
+	var records []interface{}
+	if err := decodeNumbers(in.Body, &records); err != nil {
+		return nil, err
+	}
+	checker.Report.AddBatch()
+
+	var (
+		passed      []interface{}
+		quarantined []quarantinedRecord
+		all         []quality.Violation
+	)
+	for i, rec := range records {
+		row, ok := rec.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %d is not an object", i)
+		}
+		violations, err := checker.CheckRow(ctx, row)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, violations...)
+		if quality.HasErrors(violations) {
+			quarantined = append(quarantined, quarantinedRecord{Record: row, Violations: violations})
+		} else {
+			passed = append(passed, row)
+		}
+	}
+
+	// A failed batch expectation rejects every record
+	if batch := checker.CheckBatch(len(records)); quality.HasErrors(batch) {
+		all = append(all, batch...)
+		checker.Report.Quarantine(len(passed))
+		for i := range quarantined {
+			quarantined[i].Violations = append(quarantined[i].Violations, batch...)
+		}
+		for _, row := range passed {
+			quarantined = append(quarantined, quarantinedRecord{Record: row, Violations: batch})
+		}
+		passed = nil
+	}
+
+	if len(quarantined) > 0 && s.cfg.OnFailure == "fail" {
+		return nil, failure(all)
+	}
+
+	var outputs []engine.Output
+	if len(passed) > 0 {
+		msg, err := engine.JSONMessage(passed)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, engine.Output{Port: engine.DefaultPort, Message: withHeaders(msg, in)})
+	}
+	if len(quarantined) > 0 {
+		msg, err := engine.JSONMessage(quarantined)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, engine.Output{Port: PortQuarantine, Message: withHeaders(msg, in)})
+	}
+	return outputs, nil
+}
+
+// failure summarizes error violations for a failed execution
+func failure(violations []quality.Violation) error {
+	var msgs []string
+	for _, v := range violations {
+		if v.Severity == quality.SeverityError {
+			msgs = append(msgs, v.Rule+": "+v.Message)
+		}
+	}
+	return fmt.Errorf("data quality check failed: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,72 @@
+package steps
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+func init() {
+	engine.RegisterStep("quarantine", newQuarantine)
+}
+
+// quarantineConfig configures the quarantine step
+type quarantineConfig struct {
+	// Reason classifies the messages, such as duplicate; when empty it is
+	// validation or quality for messages carrying the problems of a
+	// validating or data-quality step, and rejected otherwise
+	Reason string `json:"reason"`
+}
+
+// quarantineStep holds the messages it receives for review, typically from
+// the invalid or quarantine port of another step. It emits nothing: a
+// released message runs anew from its default port.
+type quarantineStep struct {
+	cfg quarantineConfig
+}
+
+func newQuarantine(config map[string]interface{}) (engine.Step, error) {
+	var cfg quarantineConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	return &quarantineStep{cfg: cfg}, nil
+}
+
+// Transactional implements engine.Sink. A message is held once per run, so
+// a retried execution may quarantine it again.
+func (s *quarantineStep) Transactional() bool { return false }
+
+// Committed implements engine.Sink
+func (s *quarantineStep) Committed(ctx context.Context, sc *engine.StepContext, key string) (bool, error) {
+	return false, nil
+}
+
+func (s *quarantineStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	reason, detail := s.cfg.Reason, ""
+	switch {
+	case in.Headers[HeaderQualityViolations] != "":
+		detail = in.Headers[HeaderQualityViolations]
+		if reason == "" {
+			reason = model.QuarantineQuality
+		}
+	case in.Headers[HeaderValidationProblems] != "":
+		detail = in.Headers[HeaderValidationProblems]
+		if reason == "" {
+			reason = model.QuarantineValidation
+		}
+	}
+	if reason == "" {
+		reason = "rejected"
+	}
+	if err := sc.Quarantine(ctx, reason, detail, in); err != nil {
+		if errors.Is(err, engine.ErrNoQuarantine) {
+			return nil, errors.New("quarantine is disabled")
+		}
+		return nil, err
+	}
+	sc.Report("quarantined", reason)
+	return nil, nil
+}
@@ -0,0 +1,155 @@
+package steps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+func init() {
+	engine.RegisterStep("httpResponse", newHTTPResponse)
+}
+
+// httpResponseConfig configures the httpResponse step, which shapes the
+// reply of a flow run synchronously by a webhook trigger. Status, header
+// and body values are templates filled from the message (see template).
+type httpResponseConfig struct {
+	// Status is a status code, or a template expanding to one such as
+	// "{body.status}"; the webhook responds 200 when it is empty
+	Status  interface{}       `json:"status"`
+	Headers map[string]string `json:"headers"`
+	// Body replaces the message body as the http step's body does; the
+	// message body is kept when it is not set
+	Body        interface{} `json:"body"`
+	ContentType string      `json:"contentType"`
+}
+
+// httpResponseStep renders the response of a synchronous trigger
+type httpResponseStep struct {
+	cfg     httpResponseConfig
+	status  *template
+	headers map[string]*template
+	body    interface{}
+}
+
+func newHTTPResponse(config map[string]interface{}) (engine.Step, error) {
+	var cfg httpResponseConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	s := &httpResponseStep{cfg: cfg}
+	var err error
+	switch status := cfg.Status.(type) {
+	case nil:
+	case float64:
+		if status != float64(int(status)) || !validStatus(int(status)) {
+			return nil, fmt.Errorf("status %v is not an HTTP status code", status)
+		}
+		s.status, _ = compileTemplate(strconv.Itoa(int(status)))
+	case string:
+		if s.status, err = compileTemplate(status); err != nil {
+			return nil, fmt.Errorf("status: %w", err)
+		}
+	default:
+		return nil, errors.New("status must be a number or a template")
+	}
+	for name := range cfg.Headers {
+		if name == "" {
+			return nil, errors.New("headers: names must not be empty")
+		}
+	}
+	if s.headers, err = compileTemplates(cfg.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %w", err)
+	}
+	if cfg.Body != nil {
+		if s.body, err = compileBody(cfg.Body); err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *httpResponseStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	data := templateData{sc: sc, in: in}
+	if s.usesBody() {
+		if err := in.Buffer(engine.DefaultMaxBufferSize); err != nil {
+			return nil, err
+		}
+		if err := decodeNumbers(in.Body, &data.body); err != nil {
+			return nil, err
+		}
+	}
+
+	var out *engine.Message
+	switch body := s.body.(type) {
+	case nil:
+		out = in.Clone()
+		out.ContentType = s.contentType(in.ContentType)
+	case *template:
+		text, err := body.expand(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+		out = in.WithBody([]byte(text), s.contentType("text/plain"))
+	default:
+		v, err := renderBody(body, data)
+		if err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode body: %w", err)
+		}
+		out = in.WithBody(encoded, s.contentType("application/json"))
+	}
+
+	if s.status != nil {
+		v, err := s.status.expand(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("status: %w", err)
+		}
+		status, err := strconv.Atoi(v)
+		if err != nil || !validStatus(status) {
+			return nil, fmt.Errorf("status %q is not an HTTP status code", v)
+		}
+		out.SetHeader(engine.HeaderResponseStatus, v)
+	}
+	for name, t := range s.headers {
+		v, err := t.expand(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		out.SetHeader(engine.HeaderResponseHeaderPrefix+name, v)
+	}
+	return engine.Emit(out), nil
+}
+
+// usesBody reports whether a template needs the decoded message body
+func (s *httpResponseStep) usesBody() bool {
+	if (s.status != nil && s.status.usesBody) || bodyUsesBody(s.body) {
+		return true
+	}
+	for _, t := range s.headers {
+		if t.usesBody {
+			return true
+		}
+	}
+	return false
+}
+
+// contentType returns the configured content type, or def
+func (s *httpResponseStep) contentType(def string) string {
+	if s.cfg.ContentType != "" {
+		return s.cfg.ContentType
+	}
+	return def
+}
+
+// validStatus reports whether code is a status a server can respond with
+func validStatus(code int) bool {
+	return code >= 200 && code <= 599
+}
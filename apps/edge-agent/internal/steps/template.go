@@ -0,0 +1,140 @@
+package steps
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/mapping"
+)
+
+// template is text with placeholders filled from each message: {body} or
+// {body.<path>} for the JSON body or a field of it, {header.<name>} for a
+// header, and {executionId}, {flowId}, {stepId} and {timestamp}
+type template struct {
+	parts []templatePart
+	// usesBody is set when the body must be decoded to expand the template
+	usesBody bool
+}
+
+// templatePart is literal text or, when name is set, a placeholder
+type templatePart struct {
+	text string
+	name string
+	// query is set for placeholders in the query string of a URL template
+	query bool
+}
+
+// compileTemplate parses the placeholders of s. Braces around text that is
+// not a name, such as those of a JSON body, are kept as they are; unknown
+// names are an error, so that typos fail when the flow is deployed.
+func compileTemplate(s string) (*template, error) {
+	t := &template{}
+	var text strings.Builder
+	inQuery := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '?' {
+			inQuery = true
+		}
+		end := strings.IndexByte(s[i+1:], '}')
+		if c != '{' || end < 0 || !placeholderName.MatchString(s[i+1:i+1+end]) {
+			text.WriteByte(c)
+			continue
+		}
+		name := s[i+1 : i+1+end]
+		switch {
+		case name == "body", strings.HasPrefix(name, "body."):
+			t.usesBody = true
+		case strings.HasPrefix(name, "header."):
+		case name == "executionId", name == "flowId", name == "stepId", name == "timestamp":
+		default:
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		if text.Len() > 0 {
+			t.parts = append(t.parts, templatePart{text: text.String()})
+			text.Reset()
+		}
+		t.parts = append(t.parts, templatePart{name: name, query: inQuery})
+		i += end + 1
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	return t, nil
+}
+
+// placeholderName matches the text between braces that is a placeholder
+var placeholderName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*(\.[A-Za-z0-9_-]+)*$`)
+
+// templateData is what a template is expanded with; body is the decoded JSON
+// body, nil when no template uses it
+type templateData struct {
+	sc   *engine.StepContext
+	in   *engine.Message
+	body interface{}
+}
+
+// expand fills the placeholders, passing each value through escape when it
+// is not nil. escape is given whether the placeholder is in a query string.
+func (t *template) expand(data templateData, escape func(value string, query bool) string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.name == "" {
+			b.WriteString(part.text)
+			continue
+		}
+		value, err := data.value(part.name)
+		if err != nil {
+			return "", err
+		}
+		if escape != nil {
+			value = escape(value, part.query)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+func (d templateData) value(name string) (string, error) {
+	switch {
+	case name == "executionId":
+		return d.sc.ExecutionID, nil
+	case name == "flowId":
+		return d.sc.FlowID, nil
+	case name == "stepId":
+		return d.sc.StepID, nil
+	case name == "timestamp":
+		return d.sc.Clock().Now().UTC().Format("20060102T150405Z"), nil
+	case strings.HasPrefix(name, "header."):
+		return d.in.Headers[strings.TrimPrefix(name, "header.")], nil
+	case name == "body":
+		return templateString(d.body)
+	}
+	path := strings.TrimPrefix(name, "body.")
+	v, ok := mapping.Get(d.body, path)
+	if !ok || v == nil {
+		return "", fmt.Errorf("placeholder {%s}: the body has no field %s", name, path)
+	}
+	return templateString(v)
+}
+
+// templateString formats a JSON value: strings and numbers as they are,
+// objects and arrays as JSON
+func templateString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
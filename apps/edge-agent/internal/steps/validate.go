@@ -0,0 +1,61 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/schema"
+)
+
+func init() {
+	engine.RegisterStep("schema-validate", newSchemaValidate)
+}
+
+// schemaValidateConfig configures the schema-validate step
+type schemaValidateConfig struct {
+	// Schema is a JSON Schema, e.g. one returned by /api/v1/schemas/infer
+	Schema map[string]interface{} `json:"schema"`
+	// OnInvalid is "fail" (the default) to fail the execution or "route" to
+	// emit invalid messages on the invalid port
+	OnInvalid string `json:"onInvalid"`
+}
+
+// schemaValidate checks JSON bodies against a JSON Schema
+type schemaValidate struct {
+	cfg schemaValidateConfig
+}
+
+func newSchemaValidate(config map[string]interface{}) (engine.Step, error) {
+	cfg := schemaValidateConfig{OnInvalid: "fail"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Schema) == 0 {
+		return nil, errors.New("schema is required")
+	}
+	if cfg.OnInvalid != "fail" && cfg.OnInvalid != "route" {
+		return nil, fmt.Errorf("invalid onInvalid %q: must be fail or route", cfg.OnInvalid)
+	}
+	return &schemaValidate{cfg: cfg}, nil
+}
+
+func (s *schemaValidate) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	problems, err := schema.ValidateJSON(s.cfg.Schema, in.Body)
+	if err != nil {
+		problems = []string{err.Error()}
+	}
+	if len(problems) == 0 {
+		return engine.Emit(in), nil
+	}
+
+	sc.Report("validationProblems", len(problems))
+	if s.cfg.OnInvalid == "fail" {
+		return nil, errors.New("payload is invalid: " + strings.Join(problems, "; "))
+	}
+	out := in.Clone()
+	out.SetHeader(HeaderValidationProblems, strings.Join(problems, "; "))
+	return []engine.Output{{Port: PortInvalid, Message: out}}, nil
+}
@@ -0,0 +1,98 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+)
+
+func init() {
+	engine.RegisterStep("wait", newWait)
+}
+
+// PortTimeout receives the messages of wait steps that timed out
+const PortTimeout = "timeout"
+
+// HeaderWaitOutcome is set by the wait step to "resumed" or "timeout"
+const HeaderWaitOutcome = "wait-outcome"
+
+// waitConfig configures the wait step. Token is a template, such as
+// "{body.orderId}", for the correlation token the execution is resumed with;
+// a random one is generated when empty. Timeout, a Go duration or seconds,
+// bounds the wait when set.
+type waitConfig struct {
+	Token   string      `json:"token"`
+	Timeout interface{} `json:"timeout"`
+}
+
+// waitStep suspends the execution until it is resumed through the API with
+// its token, or times out. The execution's state is stored meanwhile, so
+// waits outlive restarts. A resume passes on its payload, when given, with
+// the headers of the waiting message; a timeout passes the waiting message
+// to the timeout port.
+type waitStep struct {
+	token   *template
+	timeout time.Duration
+}
+
+func newWait(config map[string]interface{}) (engine.Step, error) {
+	var cfg waitConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	s := &waitStep{}
+	if cfg.Token != "" {
+		t, err := compileTemplate(cfg.Token)
+		if err != nil {
+			return nil, fmt.Errorf("token: %w", err)
+		}
+		s.token = t
+	}
+	if cfg.Timeout != nil {
+		d, err := parseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+		s.timeout = d
+	}
+	return s, nil
+}
+
+func (s *waitStep) Run(ctx context.Context, sc *engine.StepContext, in *engine.Message) ([]engine.Output, error) {
+	token := ids.New("wait")
+	if s.token != nil {
+		data := templateData{sc: sc, in: in}
+		if s.token.usesBody {
+			if err := decodeNumbers(in.Body, &data.body); err != nil {
+				return nil, err
+			}
+		}
+		t, err := s.token.expand(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("token: %w", err)
+		}
+		if t == "" {
+			return nil, errors.New("token expanded to an empty string")
+		}
+		token = t
+	}
+	return nil, &engine.Suspend{Token: token, Timeout: s.timeout}
+}
+
+// Resume implements engine.Resumable
+func (s *waitStep) Resume(ctx context.Context, sc *engine.StepContext, in *engine.Message, signal engine.Signal) ([]engine.Output, error) {
+	if signal.TimedOut {
+		in.SetHeader(HeaderWaitOutcome, "timeout")
+		return []engine.Output{{Port: PortTimeout, Message: in}}, nil
+	}
+	out := in
+	if signal.Message != nil {
+		out = in.WithBody(signal.Message.Body, signal.Message.ContentType)
+	}
+	out.SetHeader(HeaderWaitOutcome, "resumed")
+	return engine.Emit(out), nil
+}
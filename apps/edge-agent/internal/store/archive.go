@@ -0,0 +1,236 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EncodingGzip marks a record whose Value is gzip-compressed
+	EncodingGzip = "gzip"
+
+	// LabelArchived is set to "true" on archived records
+	LabelArchived = "archived"
+)
+
+// ArchivedBuckets lists the buckets whose historical records are archived
+var ArchivedBuckets = []string{BucketExecutions, BucketExecutionLogs, BucketPayloads}
+
+// ArchiveBucket returns the bucket holding archived records of bucket
+func ArchiveBucket(bucket string) string {
+	return bucket + ".archive"
+}
+
+// Archiver moves records that have not changed for a configured age into
+// compressed archive buckets, keeping the live buckets small
+type Archiver struct {
+	store  Store
+	cfg    config.ArchiveConfig
+	logger *logrus.Logger
+}
+
+// NewArchiver creates an archiver for st
+func NewArchiver(st Store, cfg config.ArchiveConfig, logger *logrus.Logger) *Archiver {
+	return &Archiver{store: st, cfg: cfg, logger: logger}
+}
+
+// Run archives on the configured interval until ctx is cancelled
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(a.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			count, err := a.ArchiveOnce(ctx, now)
+			if err != nil {
+				a.logger.Errorf("Failed to archive historical records: %v", err)
+			}
+			if count > 0 {
+				a.logger.WithField("count", count).Info("Archived historical records")
+			}
+		}
+	}
+}
+
+// ArchiveOnce archives the finished executions that have not changed
+// since the configured age, along with their logs and payloads, and
+// returns how many records were moved. Executions still queued, running or
+// waiting stay live, whatever their age.
+func (a *Archiver) ArchiveOnce(ctx context.Context, now time.Time) (int, error) {
+	cutoff := now.Add(-time.Duration(a.cfg.AfterDays) * 24 * time.Hour)
+
+	records, err := a.store.List(ctx, BucketExecutions, ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %s: %w", BucketExecutions, err)
+	}
+	archived := 0
+	for _, rec := range records {
+		if !rec.UpdatedAt.Before(cutoff) || !finished(rec) {
+			continue
+		}
+		n, err := a.archiveExecution(ctx, rec)
+		if err != nil {
+			return archived, err
+		}
+		archived += n
+	}
+	return archived, nil
+}
+
+// finished reports whether rec holds an execution that has finished
+func finished(rec *Record) bool {
+	value, err := Decode(rec)
+	if err != nil {
+		return false
+	}
+	var exec model.Execution
+	return json.Unmarshal(value, &exec) == nil && exec.Finished()
+}
+
+// archiveExecution moves the execution rec, its logs and its payloads into
+// the archive buckets in one transaction, unless the execution changed
+// since rec was read. It returns how many records were moved.
+func (a *Archiver) archiveExecution(ctx context.Context, rec *Record) (int, error) {
+	// Logs and payloads are keyed under the execution's ID, and complete
+	// once it has finished
+	related := make(map[string][]string)
+	for _, bucket := range []string{BucketExecutionLogs, BucketPayloads} {
+		records, err := a.store.List(ctx, bucket, ListOptions{Prefix: rec.Key + "/"})
+		if err != nil {
+			return 0, fmt.Errorf("failed to list %s of execution %s: %w", bucket, rec.Key, err)
+		}
+		for _, r := range records {
+			related[bucket] = append(related[bucket], r.Key)
+		}
+	}
+
+	moved := 0
+	err := a.store.Update(ctx, func(tx Tx) error {
+		moved = 0
+		current, err := tx.Get(BucketExecutions, rec.Key)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !current.UpdatedAt.Equal(rec.UpdatedAt) {
+			// Changed since it was listed; a later pass reconsiders it
+			return nil
+		}
+		if err := archive(tx, BucketExecutions, current); err != nil {
+			return err
+		}
+		moved++
+		for bucket, keys := range related {
+			for _, key := range keys {
+				current, err := tx.Get(bucket, key)
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := archive(tx, bucket, current); err != nil {
+					return err
+				}
+				moved++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive execution %s: %w", rec.Key, err)
+	}
+	return moved, nil
+}
+
+// archive compresses rec into the archive bucket and removes the live copy
+// within tx
+func archive(tx Tx, bucket string, rec *Record) error {
+	value, err := Decode(rec)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(value); err != nil {
+		return fmt.Errorf("failed to compress record %s: %w", rec.Key, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress record %s: %w", rec.Key, err)
+	}
+
+	labels := make(map[string]string, len(rec.Labels)+1)
+	for k, v := range rec.Labels {
+		labels[k] = v
+	}
+	labels[LabelArchived] = "true"
+
+	archivedRec := &Record{
+		Key:       rec.Key,
+		Value:     buf.Bytes(),
+		Encoding:  EncodingGzip,
+		Labels:    labels,
+		CreatedAt: rec.CreatedAt,
+	}
+	if err := tx.Put(ArchiveBucket(bucket), archivedRec); err != nil {
+		return fmt.Errorf("failed to archive record %s: %w", rec.Key, err)
+	}
+	if err := tx.Delete(bucket, rec.Key); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to remove archived record %s: %w", rec.Key, err)
+	}
+	return nil
+}
+
+// Decode returns rec's value, decompressing it if necessary
+func Decode(rec *Record) ([]byte, error) {
+	switch rec.Encoding {
+	case "":
+		return rec.Value, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(rec.Value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress record %s: %w", rec.Key, err)
+		}
+		defer zr.Close()
+		value, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress record %s: %w", rec.Key, err)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q on record %s", rec.Encoding, rec.Key)
+	}
+}
+
+// GetWithArchive looks up key in bucket, falling back to its archive bucket.
+// It reports whether the record came from the archive.
+func GetWithArchive(ctx context.Context, st Store, bucket, key string) (*Record, bool, error) {
+	rec, err := st.Get(ctx, bucket, key)
+	if err == nil {
+		return rec, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+	rec, err = st.Get(ctx, ArchiveBucket(bucket), key)
+	if err != nil {
+		return nil, false, err
+	}
+	return rec, true, nil
+}
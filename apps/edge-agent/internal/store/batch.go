@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Batcher buffers frequent writes, such as execution status updates, and
+// commits them together in one transaction per batch. Writes to the same
+// record within a batch are coalesced so only the latest is stored, which
+// cuts both transactions and flash wear on small devices. A batch is
+// flushed when it reaches MaxSize, every FlushIntervalMs, or at once by
+// WriteNow for writes that must not be lost, such as terminal states.
+//
+// Buffered records can be read through Pending; List does not see them, so
+// listings may lag by up to the flush interval.
+type Batcher struct {
+	store  Store
+	cfg    config.BatchConfig
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	pending map[batchKey]*Record
+	order   []batchKey
+	// flushing holds the batch being committed, still visible to Pending
+	flushing map[batchKey]*Record
+	// hooks run in the batch's transaction, e.g. to enqueue outbox events;
+	// unlike records they are never coalesced
+	hooks []func(tx Tx) error
+	full  chan struct{}
+
+	// flushMu serializes flushes so batches commit in order
+	flushMu sync.Mutex
+}
+
+type batchKey struct {
+	bucket, key string
+}
+
+// NewBatcher creates a batcher writing to st
+func NewBatcher(st Store, cfg config.BatchConfig, logger *logrus.Logger) *Batcher {
+	return &Batcher{
+		store:   st,
+		cfg:     cfg,
+		logger:  logger,
+		pending: make(map[batchKey]*Record),
+		full:    make(chan struct{}, 1),
+	}
+}
+
+// Write buffers rec for bucket. Hook, when not nil, runs in the same
+// transaction as the batch. With batching disabled the write is flushed
+// immediately.
+func (b *Batcher) Write(ctx context.Context, bucket string, rec *Record, hook func(tx Tx) error) error {
+	if !b.cfg.Enabled {
+		return b.WriteNow(ctx, bucket, rec, hook)
+	}
+	if b.add(bucket, rec, hook) >= b.cfg.MaxSize {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// WriteNow buffers rec like Write and flushes the batch, returning once it
+// is committed
+func (b *Batcher) WriteNow(ctx context.Context, bucket string, rec *Record, hook func(tx Tx) error) error {
+	b.add(bucket, rec, hook)
+	return b.Flush(ctx)
+}
+
+// add buffers a write and returns the number of buffered records
+func (b *Batcher) add(bucket string, rec *Record, hook func(tx Tx) error) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := batchKey{bucket, rec.Key}
+	if _, ok := b.pending[k]; !ok {
+		b.order = append(b.order, k)
+	}
+	b.pending[k] = rec
+	if hook != nil {
+		b.hooks = append(b.hooks, hook)
+	}
+	return len(b.order)
+}
+
+// Pending returns the latest write to key that is not yet committed
+func (b *Batcher) Pending(bucket, key string) (*Record, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := batchKey{bucket, key}
+	if rec, ok := b.pending[k]; ok {
+		return rec, true
+	}
+	rec, ok := b.flushing[k]
+	return rec, ok
+}
+
+// BatchStats describes the writes a batcher holds
+type BatchStats struct {
+	Pending  int `json:"pending"`
+	Hooks    int `json:"hooks"`
+	Flushing int `json:"flushing"`
+}
+
+// Stats returns the number of buffered writes and of writes being committed
+func (b *Batcher) Stats() BatchStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BatchStats{Pending: len(b.pending), Hooks: len(b.hooks), Flushing: len(b.flushing)}
+}
+
+// Flush commits the buffered writes. When the commit fails they are kept,
+// behind any newer writes to the same records, for the next flush.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	pending, order, hooks := b.pending, b.order, b.hooks
+	b.pending = make(map[batchKey]*Record, len(pending))
+	b.order, b.hooks = nil, nil
+	b.flushing = pending
+	b.mu.Unlock()
+
+	if len(order) == 0 && len(hooks) == 0 {
+		return nil
+	}
+	err := b.store.Update(ctx, func(tx Tx) error {
+		for _, k := range order {
+			if err := tx.Put(k.bucket, pending[k]); err != nil {
+				return err
+			}
+		}
+		for _, hook := range hooks {
+			if err := hook(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing = nil
+	if err == nil {
+		return nil
+	}
+
+	// Put the batch back in front of writes buffered since
+	requeued := make([]batchKey, 0, len(order)+len(b.order))
+	seen := make(map[batchKey]bool, len(order))
+	for _, k := range order {
+		if _, newer := b.pending[k]; !newer {
+			b.pending[k] = pending[k]
+		}
+		requeued = append(requeued, k)
+		seen[k] = true
+	}
+	for _, k := range b.order {
+		if !seen[k] {
+			requeued = append(requeued, k)
+		}
+	}
+	b.order = requeued
+	b.hooks = append(hooks, b.hooks...)
+	return fmt.Errorf("failed to flush %d buffered write(s): %w", len(order), err)
+}
+
+// Run flushes on the configured interval, and whenever a batch fills up,
+// until ctx is cancelled. Callers flush once more after Run returns.
+func (b *Batcher) Run(ctx context.Context) {
+	if !b.cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(b.cfg.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		if err := b.Flush(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			b.logger.Errorf("Failed to write batch: %v", err)
+		}
+	}
+}
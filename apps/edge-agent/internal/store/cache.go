@@ -0,0 +1,196 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// CachedStore is a read-through cache in front of another Store. Get results
+// for buckets with a configured TTL are cached in a bounded LRU; writes and
+// deletes through the cache invalidate the affected key.
+type CachedStore struct {
+	Store
+
+	ttls       map[string]time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	// fills tracks the keys Gets are reading from the store, so that a
+	// read racing a write does not cache what it read
+	fills map[cacheKey]*cacheFill
+}
+
+// cacheFill is the generation of a key being read, bumped by every
+// invalidation; reads cache their record only while it is unchanged
+type cacheFill struct {
+	gen     uint64
+	readers int
+}
+
+type cacheKey struct {
+	bucket string
+	key    string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	rec     *Record
+	expires time.Time
+}
+
+// NewCachedStore wraps st with a cache configured by cfg
+func NewCachedStore(st Store, cfg config.CacheConfig) *CachedStore {
+	ttls := make(map[string]time.Duration, len(cfg.TTLs))
+	for bucket, seconds := range cfg.TTLs {
+		if seconds > 0 {
+			ttls[bucket] = time.Duration(seconds) * time.Second
+		}
+	}
+	return &CachedStore{
+		Store:      st,
+		ttls:       ttls,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+		fills:      make(map[cacheKey]*cacheFill),
+	}
+}
+
+// Get implements Store
+func (s *CachedStore) Get(ctx context.Context, bucket, key string) (*Record, error) {
+	ttl, cacheable := s.ttls[bucket]
+	if !cacheable {
+		return s.Store.Get(ctx, bucket, key)
+	}
+
+	k := cacheKey{bucket, key}
+	now := time.Now()
+
+	s.mu.Lock()
+	if el, ok := s.entries[k]; ok {
+		entry := el.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			s.lru.MoveToFront(el)
+			rec := entry.rec.clone()
+			s.mu.Unlock()
+			return rec, nil
+		}
+		s.remove(el)
+	}
+	fill := s.fills[k]
+	if fill == nil {
+		fill = &cacheFill{}
+		s.fills[k] = fill
+	}
+	fill.readers++
+	gen := fill.gen
+	s.mu.Unlock()
+
+	rec, err := s.Store.Get(ctx, bucket, key)
+
+	s.mu.Lock()
+	// A write invalidated the key meanwhile, so rec may predate it
+	if err == nil && fill.gen == gen {
+		s.add(&cacheEntry{key: k, rec: rec.clone(), expires: now.Add(ttl)})
+	}
+	if fill.readers--; fill.readers == 0 {
+		delete(s.fills, k)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Put implements Store
+func (s *CachedStore) Put(ctx context.Context, bucket string, rec *Record) error {
+	s.Invalidate(bucket, rec.Key)
+	err := s.Store.Put(ctx, bucket, rec)
+	// Invalidate again, so that Gets that read the old value mid-write do
+	// not cache it
+	s.Invalidate(bucket, rec.Key)
+	return err
+}
+
+// Delete implements Store
+func (s *CachedStore) Delete(ctx context.Context, bucket, key string) error {
+	s.Invalidate(bucket, key)
+	err := s.Store.Delete(ctx, bucket, key)
+	s.Invalidate(bucket, key)
+	return err
+}
+
+// Update implements Store, invalidating every key the transaction writes
+func (s *CachedStore) Update(ctx context.Context, fn func(tx Tx) error) error {
+	var written []cacheKey
+	err := s.Store.Update(ctx, func(tx Tx) error {
+		written = written[:0]
+		return fn(&recordingTx{Tx: tx, written: &written})
+	})
+	for _, k := range written {
+		s.Invalidate(k.bucket, k.key)
+	}
+	return err
+}
+
+// recordingTx notes the keys written through it
+type recordingTx struct {
+	Tx
+	written *[]cacheKey
+}
+
+// Put implements Tx
+func (tx *recordingTx) Put(bucket string, rec *Record) error {
+	*tx.written = append(*tx.written, cacheKey{bucket, rec.Key})
+	return tx.Tx.Put(bucket, rec)
+}
+
+// Delete implements Tx
+func (tx *recordingTx) Delete(bucket, key string) error {
+	*tx.written = append(*tx.written, cacheKey{bucket, key})
+	return tx.Tx.Delete(bucket, key)
+}
+
+// Invalidate drops a cached record, e.g. when it was changed by another
+// replica, and keeps Gets reading it meanwhile from caching it
+func (s *CachedStore) Invalidate(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := cacheKey{bucket, key}
+	if el, ok := s.entries[k]; ok {
+		s.remove(el)
+	}
+	if fill := s.fills[k]; fill != nil {
+		fill.gen++
+	}
+}
+
+// Unwrap returns the underlying store
+func (s *CachedStore) Unwrap() Store {
+	return s.Store
+}
+
+// add inserts an entry, evicting the least recently used if full. Callers must hold s.mu.
+func (s *CachedStore) add(entry *cacheEntry) {
+	if el, ok := s.entries[entry.key]; ok {
+		s.remove(el)
+	}
+	s.entries[entry.key] = s.lru.PushFront(entry)
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove drops an entry. Callers must hold s.mu.
+func (s *CachedStore) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*cacheEntry).key)
+}
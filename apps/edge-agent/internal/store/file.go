@@ -0,0 +1,301 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// compactThreshold is the minimum number of logged operations before the log is rewritten
+const compactThreshold = 10000
+
+// FileStore is an embedded Store that keeps records in memory and persists
+// every mutation to an append-only log file. The log is replayed on open and
+// periodically compacted into a snapshot of the live records.
+type FileStore struct {
+	mem  *MemoryStore
+	path string
+	file *os.File
+	w    *bufio.Writer
+
+	// ops counts entries in the log; it is compacted once ops reaches nextCompact
+	ops         int
+	nextCompact int
+}
+
+// logEntry is one line of the append-only log
+type logEntry struct {
+	Op     string  `json:"op"`
+	Bucket string  `json:"bucket"`
+	Key    string  `json:"key,omitempty"`
+	Record *Record `json:"record,omitempty"`
+
+	// Batch holds the writes of a transaction, logged as one line so a torn write drops all of them
+	Batch []logEntry `json:"batch,omitempty"`
+}
+
+// OpenFileStore opens (or creates) the store persisted at path
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	s := &FileStore{mem: NewMemoryStore(), path: path, nextCompact: compactThreshold}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store file: %w", err)
+	}
+	s.file = file
+	s.w = bufio.NewWriter(file)
+	return s, nil
+}
+
+// replay loads the log into memory
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open store file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				// An unterminated final line is a torn write from a crash;
+				// drop it so new entries start on a clean line
+				if err := os.Truncate(s.path, offset); err != nil {
+					return fmt.Errorf("failed to truncate torn store entry: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read store file: %w", err)
+		}
+		offset += int64(len(data))
+
+		var entry logEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("corrupt store file at line %d: %w", line, err)
+		}
+		s.apply(entry)
+		s.ops++
+	}
+}
+
+// apply replays one log entry into memory
+func (s *FileStore) apply(entry logEntry) {
+	switch entry.Op {
+	case "put":
+		s.mem.load(entry.Bucket, entry.Record)
+	case "delete":
+		delete(s.mem.buckets[entry.Bucket], entry.Key)
+	case "batch":
+		for _, op := range entry.Batch {
+			s.apply(op)
+		}
+	}
+}
+
+// Get implements Store
+func (s *FileStore) Get(ctx context.Context, bucket, key string) (*Record, error) {
+	return s.mem.Get(ctx, bucket, key)
+}
+
+// List implements Store
+func (s *FileStore) List(ctx context.Context, bucket string, opts ListOptions) ([]*Record, error) {
+	return s.mem.List(ctx, bucket, opts)
+}
+
+// Count implements Store
+func (s *FileStore) Count(ctx context.Context, bucket string, opts ListOptions) (int, error) {
+	return s.mem.Count(ctx, bucket, opts)
+}
+
+// Buckets implements BucketLister
+func (s *FileStore) Buckets(ctx context.Context) ([]string, error) {
+	return s.mem.Buckets(ctx)
+}
+
+// Put implements Store
+func (s *FileStore) Put(ctx context.Context, bucket string, rec *Record) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	s.mem.put(bucket, rec, time.Now().UTC())
+	return s.append(logEntry{Op: "put", Bucket: bucket, Record: rec})
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(ctx context.Context, bucket, key string) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	if err := s.mem.delete(bucket, key); err != nil {
+		return err
+	}
+	return s.append(logEntry{Op: "delete", Bucket: bucket, Key: key})
+}
+
+// Update implements Store
+func (s *FileStore) Update(ctx context.Context, fn func(tx Tx) error) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	tx := newMemTx(s.mem)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	tx.commit(time.Now().UTC())
+
+	batch := make([]logEntry, len(tx.ops))
+	for i, op := range tx.ops {
+		if op.rec == nil {
+			batch[i] = logEntry{Op: "delete", Bucket: op.bucket, Key: op.key}
+		} else {
+			batch[i] = logEntry{Op: "put", Bucket: op.bucket, Record: op.rec}
+		}
+	}
+	return s.append(logEntry{Op: "batch", Batch: batch})
+}
+
+// append writes an entry to the log. Callers must hold s.mem.mu.
+func (s *FileStore) append(entry logEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode store entry: %w", err)
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+
+	s.ops++
+	if s.ops >= s.nextCompact {
+		return s.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the log as a snapshot of the live records
+func (s *FileStore) Compact() error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	return s.compact()
+}
+
+// compact rewrites the log. Callers must hold s.mem.mu.
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted store file: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	ops := 0
+	for bucket, records := range s.mem.buckets {
+		for _, rec := range records {
+			if err := enc.Encode(logEntry{Op: "put", Bucket: bucket, Record: rec}); err != nil {
+				tmp.Close()
+				return fmt.Errorf("failed to write compacted store file: %w", err)
+			}
+			ops++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write compacted store file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync compacted store file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close compacted store file: %w", err)
+	}
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close store file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace store file: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen store file: %w", err)
+	}
+	s.file = file
+	s.w = bufio.NewWriter(file)
+	s.ops = ops
+	s.nextCompact = 2*ops + compactThreshold
+	return nil
+}
+
+// Backup implements Backuper by compacting the log and copying it to path
+func (s *FileStore) Backup(path string) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	if err := s.compact(); err != nil {
+		return err
+	}
+
+	src, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open store file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync backup file: %w", err)
+	}
+	return dst.Close()
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush store file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store file: %w", err)
+	}
+	return s.file.Close()
+}
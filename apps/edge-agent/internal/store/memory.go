@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store held entirely in memory
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]*Record
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string]*Record)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, bucket, key string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec.clone(), nil
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(ctx context.Context, bucket string, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(bucket, rec, time.Now().UTC())
+	return nil
+}
+
+// put stamps rec's timestamps and stores a copy of it. Callers must hold s.mu.
+func (s *MemoryStore) put(bucket string, rec *Record, now time.Time) {
+	if rec.CreatedAt.IsZero() {
+		if existing, ok := s.buckets[bucket][rec.Key]; ok {
+			rec.CreatedAt = existing.CreatedAt
+		} else {
+			rec.CreatedAt = now
+		}
+	}
+	rec.UpdatedAt = now
+	s.load(bucket, rec)
+}
+
+// load stores a copy of rec as-is. Callers must hold s.mu.
+func (s *MemoryStore) load(bucket string, rec *Record) {
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = make(map[string]*Record)
+		s.buckets[bucket] = b
+	}
+	b[rec.Key] = rec.clone()
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(ctx context.Context, bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(bucket, key)
+}
+
+// delete removes a record. Callers must hold s.mu.
+func (s *MemoryStore) delete(bucket, key string) error {
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(ctx context.Context, bucket string, opts ListOptions) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []*Record
+	for _, rec := range s.buckets[bucket] {
+		if opts.matches(rec) {
+			records = append(records, rec.clone())
+		}
+	}
+	return opts.page(records), nil
+}
+
+// Count implements Store
+func (s *MemoryStore) Count(ctx context.Context, bucket string, opts ListOptions) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, rec := range s.buckets[bucket] {
+		if opts.matches(rec) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Buckets implements BucketLister
+func (s *MemoryStore) Buckets(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.buckets))
+	for name, b := range s.buckets {
+		if len(b) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Update implements Store. Transactions are serialized.
+func (s *MemoryStore) Update(ctx context.Context, fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := newMemTx(s)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.commit(time.Now().UTC())
+	return nil
+}
+
+// txOp is a buffered transaction write; a nil rec is a delete
+type txOp struct {
+	bucket string
+	key    string
+	rec    *Record
+	orig   *Record
+}
+
+// memTx buffers writes against a MemoryStore whose lock is held
+type memTx struct {
+	s       *MemoryStore
+	ops     []txOp
+	pending map[cacheKey]*Record
+}
+
+func newMemTx(s *MemoryStore) *memTx {
+	return &memTx{s: s, pending: make(map[cacheKey]*Record)}
+}
+
+// Get implements Tx
+func (tx *memTx) Get(bucket, key string) (*Record, error) {
+	if rec, ok := tx.pending[cacheKey{bucket, key}]; ok {
+		if rec == nil {
+			return nil, ErrNotFound
+		}
+		return rec.clone(), nil
+	}
+	rec, ok := tx.s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec.clone(), nil
+}
+
+// Put implements Tx
+func (tx *memTx) Put(bucket string, rec *Record) error {
+	snapshot := rec.clone()
+	tx.pending[cacheKey{bucket, rec.Key}] = snapshot
+	tx.ops = append(tx.ops, txOp{bucket: bucket, key: rec.Key, rec: snapshot, orig: rec})
+	return nil
+}
+
+// Delete implements Tx
+func (tx *memTx) Delete(bucket, key string) error {
+	if _, err := tx.Get(bucket, key); err != nil {
+		return err
+	}
+	tx.pending[cacheKey{bucket, key}] = nil
+	tx.ops = append(tx.ops, txOp{bucket: bucket, key: key})
+	return nil
+}
+
+// commit replays the buffered writes in order, stamping timestamps back onto the caller's records
+func (tx *memTx) commit(now time.Time) {
+	for _, op := range tx.ops {
+		if op.rec == nil {
+			delete(tx.s.buckets[op.bucket], op.key)
+			continue
+		}
+		tx.s.put(op.bucket, op.rec, now)
+		op.orig.CreatedAt = op.rec.CreatedAt
+		op.orig.UpdatedAt = op.rec.UpdatedAt
+	}
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
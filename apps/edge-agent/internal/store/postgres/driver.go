@@ -0,0 +1,4 @@
+package postgres
+
+// Register the pgx database/sql driver used by this backend
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,398 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+func init() {
+	store.Register("postgres", func(cfg config.StorageConfig) (store.Store, error) {
+		return Open(cfg.DSN)
+	})
+}
+
+// driverName is the database/sql driver name registered by pgx
+const driverName = "pgx"
+
+// Retries of transactions that fail to serialize
+const (
+	maxAttempts = 5
+	retryWait   = 20 * time.Millisecond
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS fusionflow_records (
+	bucket     TEXT NOT NULL,
+	key        TEXT NOT NULL,
+	value      BYTEA NOT NULL,
+	encoding   TEXT NOT NULL DEFAULT '',
+	labels     JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (bucket, key)
+);
+CREATE INDEX IF NOT EXISTS fusionflow_records_created_idx ON fusionflow_records (bucket, created_at, key);
+CREATE INDEX IF NOT EXISTS fusionflow_records_labels_idx ON fusionflow_records USING GIN (labels);
+`
+
+// Store is a store.Store backed by a shared PostgreSQL database
+type Store struct {
+	db *sql.DB
+}
+
+// Open connects to the database at dsn and ensures the records table exists
+func Open(dsn string) (*Store, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres store: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres store: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres store schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Get implements store.Store
+func (s *Store) Get(ctx context.Context, bucket, key string) (*store.Record, error) {
+	return get(ctx, s.db, bucket, key)
+}
+
+// Put implements store.Store
+func (s *Store) Put(ctx context.Context, bucket string, rec *store.Record) error {
+	return put(ctx, s.db, bucket, rec)
+}
+
+// Delete implements store.Store
+func (s *Store) Delete(ctx context.Context, bucket, key string) error {
+	return del(ctx, s.db, bucket, key)
+}
+
+// Update implements store.Store using a serializable database transaction.
+// Transactions failing to serialize with concurrent ones are run again, up
+// to maxAttempts times.
+func (s *Store) Update(ctx context.Context, fn func(tx store.Tx) error) error {
+	wait := retryWait
+	for attempt := 1; ; attempt++ {
+		err := s.update(ctx, fn)
+		if attempt == maxAttempts || !retryable(err) {
+			return err
+		}
+		// Jitter keeps the conflicting transactions from meeting again
+		timer := time.NewTimer(wait/2 + time.Duration(rand.Int63n(int64(wait))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+// update runs fn in one database transaction
+func (s *Store) update(ctx context.Context, fn func(tx store.Tx) error) error {
+	sqlTx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(&pgTx{ctx: ctx, tx: sqlTx}); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether err is a serialization failure or deadlock,
+// after which the transaction succeeds when run again
+func retryable(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.SQLState() {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
+
+// pgTx adapts a database transaction to store.Tx
+type pgTx struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+// Get implements store.Tx
+func (t *pgTx) Get(bucket, key string) (*store.Record, error) {
+	return get(t.ctx, t.tx, bucket, key)
+}
+
+// Put implements store.Tx
+func (t *pgTx) Put(bucket string, rec *store.Record) error {
+	return put(t.ctx, t.tx, bucket, rec)
+}
+
+// Delete implements store.Tx
+func (t *pgTx) Delete(bucket, key string) error {
+	return del(t.ctx, t.tx, bucket, key)
+}
+
+// get reads one record
+func get(ctx context.Context, q querier, bucket, key string) (*store.Record, error) {
+	row := q.QueryRowContext(ctx,
+		`SELECT key, value, encoding, labels, created_at, updated_at
+		 FROM fusionflow_records WHERE bucket = $1 AND key = $2`, bucket, key)
+
+	rec, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return rec, err
+}
+
+// put upserts one record, preserving its creation time on update
+func put(ctx context.Context, q querier, bucket string, rec *store.Record) error {
+	labels, err := encodeLabels(rec.Labels)
+	if err != nil {
+		return err
+	}
+
+	createdAt := sql.NullTime{Time: rec.CreatedAt, Valid: !rec.CreatedAt.IsZero()}
+	now := time.Now().UTC()
+
+	row := q.QueryRowContext(ctx,
+		`INSERT INTO fusionflow_records (bucket, key, value, encoding, labels, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, COALESCE($6::timestamptz, $7::timestamptz), $7)
+		 ON CONFLICT (bucket, key) DO UPDATE SET
+			value = EXCLUDED.value,
+			encoding = EXCLUDED.encoding,
+			labels = EXCLUDED.labels,
+			created_at = COALESCE($6::timestamptz, fusionflow_records.created_at),
+			updated_at = EXCLUDED.updated_at
+		 RETURNING created_at`,
+		bucket, rec.Key, rec.Value, rec.Encoding, labels, createdAt, now)
+
+	if err := row.Scan(&rec.CreatedAt); err != nil {
+		return fmt.Errorf("failed to put record %s: %w", rec.Key, err)
+	}
+	rec.UpdatedAt = now
+	return nil
+}
+
+// del removes one record
+func del(ctx context.Context, q querier, bucket, key string) error {
+	res, err := q.ExecContext(ctx, `DELETE FROM fusionflow_records WHERE bucket = $1 AND key = $2`, bucket, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete record %s: %w", key, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete record %s: %w", key, err)
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// List implements store.Store
+func (s *Store) List(ctx context.Context, bucket string, opts store.ListOptions) ([]*store.Record, error) {
+	q, err := newQuery(bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT key, value, encoding, labels, created_at, updated_at FROM fusionflow_records WHERE ` +
+		q.where() + ` ORDER BY ` + q.order(opts)
+	if opts.Limit > 0 {
+		query += " LIMIT " + q.arg(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query += " OFFSET " + q.arg(opts.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", bucket, err)
+	}
+	defer rows.Close()
+
+	var records []*store.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", bucket, err)
+	}
+	return records, nil
+}
+
+// Count implements store.Store
+func (s *Store) Count(ctx context.Context, bucket string, opts store.ListOptions) (int, error) {
+	q, err := newQuery(bucket, opts)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = s.db.QueryRowContext(ctx, `SELECT count(*) FROM fusionflow_records WHERE `+q.where(), q.args...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s: %w", bucket, err)
+	}
+	return n, nil
+}
+
+// query builds the conditions of a List or Count and their arguments
+type query struct {
+	conds []string
+	args  []interface{}
+}
+
+// newQuery translates the filters of opts into conditions
+func newQuery(bucket string, opts store.ListOptions) (*query, error) {
+	q := &query{}
+	q.conds = append(q.conds, "bucket = "+q.arg(bucket))
+	if opts.Prefix != "" {
+		q.conds = append(q.conds, "starts_with(key, "+q.arg(opts.Prefix)+")")
+	}
+	if len(opts.Labels) > 0 {
+		labels, err := encodeLabels(opts.Labels)
+		if err != nil {
+			return nil, err
+		}
+		q.conds = append(q.conds, "labels @> "+q.arg(labels)+"::jsonb")
+	}
+	for k, v := range opts.Contains {
+		q.conds = append(q.conds, "strpos(lower(coalesce(labels->>"+q.arg(k)+", '')), lower("+q.arg(v)+")) > 0")
+	}
+	if !opts.CreatedAfter.IsZero() {
+		q.conds = append(q.conds, "created_at > "+q.arg(opts.CreatedAfter))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		q.conds = append(q.conds, "created_at < "+q.arg(opts.CreatedBefore))
+	}
+	return q, nil
+}
+
+// arg adds an argument and returns its placeholder
+func (q *query) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *query) where() string {
+	return strings.Join(q.conds, " AND ")
+}
+
+// order returns the ORDER BY clause of opts.Sort
+func (q *query) order(opts store.ListOptions) string {
+	dir := ""
+	if opts.Descending {
+		dir = " DESC"
+	}
+	switch {
+	case opts.Sort == store.SortUpdated:
+		return "updated_at" + dir + ", key" + dir
+	case opts.Sort == store.SortKey:
+		return "key" + dir
+	case strings.HasPrefix(opts.Sort, store.SortLabelPrefix):
+		// A missing label sorts as an empty one, as in the other stores
+		label := q.arg(strings.TrimPrefix(opts.Sort, store.SortLabelPrefix))
+		return "coalesce(labels->>" + label + ", '')" + dir + ", key" + dir
+	}
+	return "created_at" + dir + ", key" + dir
+}
+
+// Buckets implements store.BucketLister
+func (s *Store) Buckets(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT bucket FROM fusionflow_records ORDER BY bucket`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to list buckets: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	return names, nil
+}
+
+// Close implements store.Store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecord reads one record row
+func scanRecord(row scanner) (*store.Record, error) {
+	var (
+		rec    store.Record
+		labels []byte
+	)
+	if err := row.Scan(&rec.Key, &rec.Value, &rec.Encoding, &labels, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	if err := json.Unmarshal(labels, &rec.Labels); err != nil {
+		return nil, fmt.Errorf("failed to decode labels of record %s: %w", rec.Key, err)
+	}
+	if len(rec.Labels) == 0 {
+		rec.Labels = nil
+	}
+	return &rec, nil
+}
+
+// encodeLabels renders labels as a JSON object
+func encodeLabels(labels map[string]string) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode labels: %w", err)
+	}
+	return string(data), nil
+}
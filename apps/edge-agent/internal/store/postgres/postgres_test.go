@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/fusionflow/edge-agent/internal/store/storetest"
+)
+
+// dsnEnv names the variable holding the DSN of a database the tests may
+// empty; they are skipped when it is not set
+const dsnEnv = "FUSIONFLOW_EDGE_AGENT_TEST_POSTGRES_DSN"
+
+func TestStore(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", dsnEnv)
+	}
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := Open(dsn)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if _, err := st.db.ExecContext(context.Background(), "TRUNCATE fusionflow_records"); err != nil {
+			st.Close()
+			t.Fatalf("failed to empty the records table: %v", err)
+		}
+		return st
+	})
+}
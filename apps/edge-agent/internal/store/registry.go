@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+)
+
+// Driver opens a Store from configuration
+type Driver func(cfg config.StorageConfig) (Store, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+func init() {
+	Register("memory", func(cfg config.StorageConfig) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+	Register("file", func(cfg config.StorageConfig) (Store, error) {
+		return OpenFileStore(cfg.Path)
+	})
+}
+
+// Register makes a backend available under name. Backends living in their own
+// packages call it from init, and are enabled by importing the package.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if _, dup := drivers[name]; dup {
+		panic("store: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the names of the registered backends
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the backend selected by cfg.Driver
+func Open(cfg config.StorageConfig) (Store, error) {
+	driversMu.RLock()
+	driver, ok := drivers[cfg.Driver]
+	driversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q (available: %v)", cfg.Driver, Drivers())
+	}
+	return driver(cfg)
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Well-known buckets
+const (
+	BucketFlows      = "flows"
+	BucketConnectors = "connectors"
+	BucketExecutions = "executions"
+	BucketPayloads   = "payloads"
+	// BucketFlowVersions holds the version history of flows, keyed by flow
+	// ID and version
+	BucketFlowVersions = "flows.versions"
+	// BucketExecutionLogs holds the log entries of executions, keyed by
+	// execution ID and sequence
+	BucketExecutionLogs = "executions.logs"
+	// BucketIdempotencyKeys holds the Idempotency-Key headers of API
+	// executions, keyed by the header value
+	BucketIdempotencyKeys = "executions.idempotency"
+
+	// BucketMeta holds store metadata such as the schema version
+	BucketMeta = "_meta"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Record is a document stored under a key within a bucket. Labels hold
+// indexed attributes (status, flow ID, ...) that List can filter on without
+// decoding Value.
+type Record struct {
+	Key       string            `json:"key"`
+	Value     []byte            `json:"value"`
+	Encoding  string            `json:"encoding,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Orders of List results
+const (
+	SortCreated = "createdAt"
+	SortUpdated = "updatedAt"
+	SortKey     = "key"
+	// SortLabelPrefix prefixes the label to sort by, as in "labels.name"
+	SortLabelPrefix = "labels."
+)
+
+// ListOptions filters, sorts and pages List results
+type ListOptions struct {
+	Prefix string
+	Labels map[string]string
+	// Contains matches labels that contain the given text, ignoring case
+	Contains      map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Sort is SortCreated (the default), SortUpdated, SortKey or a label
+	// prefixed with SortLabelPrefix; ties are ordered by key
+	Sort       string
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+// Store persists records for the agent
+type Store interface {
+	// Get returns the record stored under key, or ErrNotFound
+	Get(ctx context.Context, bucket, key string) (*Record, error)
+	// Put creates or replaces a record
+	Put(ctx context.Context, bucket string, rec *Record) error
+	// Delete removes a record, returning ErrNotFound if it does not exist
+	Delete(ctx context.Context, bucket, key string) error
+	// List returns matching records in the order of opts.Sort
+	List(ctx context.Context, bucket string, opts ListOptions) ([]*Record, error)
+	// Count returns the number of records List matches, ignoring the
+	// offset and limit
+	Count(ctx context.Context, bucket string, opts ListOptions) (int, error)
+	// Update runs fn in a transaction; its writes are applied atomically if
+	// fn returns nil and discarded otherwise. fn may run more than once, as
+	// transactions conflicting with others are retried.
+	Update(ctx context.Context, fn func(tx Tx) error) error
+	// Close releases resources held by the store
+	Close() error
+}
+
+// Tx reads and writes records within a transaction. Reads observe the
+// transaction's own uncommitted writes.
+type Tx interface {
+	Get(bucket, key string) (*Record, error)
+	Put(bucket string, rec *Record) error
+	Delete(bucket, key string) error
+}
+
+// Backuper is implemented by stores that can write a consistent copy of themselves to a file
+type Backuper interface {
+	Backup(path string) error
+}
+
+// BucketLister is implemented by stores that can enumerate their buckets
+type BucketLister interface {
+	// Buckets returns the names of the buckets holding records, sorted
+	Buckets(ctx context.Context) ([]string, error)
+}
+
+// Wrapper is implemented by stores layered over another store, such as caches
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// As finds the first store in st's wrapper chain that implements T
+func As[T any](st Store) (T, bool) {
+	for st != nil {
+		if t, ok := st.(T); ok {
+			return t, true
+		}
+		w, ok := st.(Wrapper)
+		if !ok {
+			break
+		}
+		st = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// clone returns a deep copy of rec so callers never share mutable state with the store
+func (r *Record) clone() *Record {
+	c := *r
+	c.Value = append([]byte(nil), r.Value...)
+	if r.Labels != nil {
+		c.Labels = make(map[string]string, len(r.Labels))
+		for k, v := range r.Labels {
+			c.Labels[k] = v
+		}
+	}
+	return &c
+}
+
+// matches reports whether rec satisfies the filters in opts
+func (o ListOptions) matches(rec *Record) bool {
+	if o.Prefix != "" && !strings.HasPrefix(rec.Key, o.Prefix) {
+		return false
+	}
+	if !o.CreatedAfter.IsZero() && !rec.CreatedAt.After(o.CreatedAfter) {
+		return false
+	}
+	if !o.CreatedBefore.IsZero() && !rec.CreatedAt.Before(o.CreatedBefore) {
+		return false
+	}
+	for k, v := range o.Labels {
+		if rec.Labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range o.Contains {
+		if !strings.Contains(strings.ToLower(rec.Labels[k]), strings.ToLower(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders a before b when negative, following opts.Sort ascending
+func (o ListOptions) compare(a, b *Record) int {
+	switch {
+	case o.Sort == SortUpdated:
+		if c := a.UpdatedAt.Compare(b.UpdatedAt); c != 0 {
+			return c
+		}
+	case strings.HasPrefix(o.Sort, SortLabelPrefix):
+		label := strings.TrimPrefix(o.Sort, SortLabelPrefix)
+		if c := strings.Compare(a.Labels[label], b.Labels[label]); c != 0 {
+			return c
+		}
+	case o.Sort != SortKey:
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.Key, b.Key)
+}
+
+// page sorts records and applies the offset and limit from opts
+func (o ListOptions) page(records []*Record) []*Record {
+	sort.Slice(records, func(i, j int) bool {
+		if o.Descending {
+			return o.compare(records[i], records[j]) > 0
+		}
+		return o.compare(records[i], records[j]) < 0
+	})
+	if o.Offset > 0 {
+		if o.Offset >= len(records) {
+			return nil
+		}
+		records = records[o.Offset:]
+	}
+	if o.Limit > 0 && len(records) > o.Limit {
+		records = records[:o.Limit]
+	}
+	return records
+}
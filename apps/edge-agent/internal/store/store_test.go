@@ -0,0 +1,25 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/fusionflow/edge-agent/internal/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMemoryStore()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := store.OpenFileStore(filepath.Join(t.TempDir(), "store.log"))
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		return st
+	})
+}
@@ -0,0 +1,307 @@
+// Package storetest is a compliance suite for store.Store backends. A backend
+// package runs it from its own tests:
+//
+//	func TestCompliance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store { return openTestStore(t) })
+//	}
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/store"
+)
+
+// Factory returns a new, empty store for one subtest
+type Factory func(t *testing.T) store.Store
+
+// Run exercises the behaviour every store.Store backend must provide
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st store.Store)
+	}{
+		{"GetMissing", testGetMissing},
+		{"PutGet", testPutGet},
+		{"PutPreservesCreatedAt", testPutPreservesCreatedAt},
+		{"Delete", testDelete},
+		{"BucketsAreIsolated", testBucketsAreIsolated},
+		{"ListOrdering", testListOrdering},
+		{"ListFilters", testListFilters},
+		{"ListPaging", testListPaging},
+		{"ListSorting", testListSorting},
+		{"Count", testCount},
+		{"ReturnedRecordsAreCopies", testReturnedRecordsAreCopies},
+		{"UpdateCommits", testUpdateCommits},
+		{"UpdateRollsBack", testUpdateRollsBack},
+		{"Buckets", testBuckets},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStore(t)
+			t.Cleanup(func() {
+				if err := st.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			})
+			tt.fn(t, st)
+		})
+	}
+}
+
+func put(t *testing.T, st store.Store, bucket string, rec *store.Record) {
+	t.Helper()
+	if err := st.Put(context.Background(), bucket, rec); err != nil {
+		t.Fatalf("Put(%s, %s): %v", bucket, rec.Key, err)
+	}
+}
+
+func list(t *testing.T, st store.Store, bucket string, opts store.ListOptions) []string {
+	t.Helper()
+	records, err := st.List(context.Background(), bucket, opts)
+	if err != nil {
+		t.Fatalf("List(%s): %v", bucket, err)
+	}
+	keys := make([]string, len(records))
+	for i, rec := range records {
+		keys[i] = rec.Key
+	}
+	return keys
+}
+
+func equalKeys(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("keys = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("keys = %v, want %v", got, want)
+		}
+	}
+}
+
+func testGetMissing(t *testing.T, st store.Store) {
+	if _, err := st.Get(context.Background(), "b", "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := st.Delete(context.Background(), "b", "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Delete(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testPutGet(t *testing.T, st store.Store) {
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte(`{"a":1}`), Encoding: "gzip", Labels: map[string]string{"status": "ok"}})
+
+	rec, err := st.Get(context.Background(), "b", "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(rec.Value, []byte(`{"a":1}`)) || rec.Encoding != "gzip" || rec.Labels["status"] != "ok" {
+		t.Fatalf("Get returned %+v", rec)
+	}
+	if rec.CreatedAt.IsZero() || rec.UpdatedAt.IsZero() {
+		t.Fatalf("timestamps not set: %+v", rec)
+	}
+}
+
+func testPutPreservesCreatedAt(t *testing.T, st store.Store) {
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte("1")})
+	first, err := st.Get(context.Background(), "b", "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte("2")})
+	second, err := st.Get(context.Background(), "b", "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Fatalf("CreatedAt changed on update: %v -> %v", first.CreatedAt, second.CreatedAt)
+	}
+	if !second.UpdatedAt.After(first.UpdatedAt) {
+		t.Fatalf("UpdatedAt not advanced on update: %v -> %v", first.UpdatedAt, second.UpdatedAt)
+	}
+	if string(second.Value) != "2" {
+		t.Fatalf("Value = %q, want 2", second.Value)
+	}
+}
+
+func testDelete(t *testing.T, st store.Store) {
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte("1")})
+	if err := st.Delete(context.Background(), "b", "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := st.Get(context.Background(), "b", "k"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func testBucketsAreIsolated(t *testing.T, st store.Store) {
+	put(t, st, "a", &store.Record{Key: "k", Value: []byte("a")})
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte("b")})
+
+	rec, err := st.Get(context.Background(), "a", "k")
+	if err != nil || string(rec.Value) != "a" {
+		t.Fatalf("Get(a, k) = %v, %v", rec, err)
+	}
+	equalKeys(t, list(t, st, "b", store.ListOptions{}), []string{"k"})
+}
+
+func testListOrdering(t *testing.T, st store.Store) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	put(t, st, "b", &store.Record{Key: "c", Value: []byte("1"), CreatedAt: base.Add(2 * time.Hour)})
+	put(t, st, "b", &store.Record{Key: "b", Value: []byte("1"), CreatedAt: base})
+	put(t, st, "b", &store.Record{Key: "a", Value: []byte("1"), CreatedAt: base})
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{}), []string{"a", "b", "c"})
+}
+
+func testListFilters(t *testing.T, st store.Store) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	put(t, st, "b", &store.Record{Key: "exec_1", Value: []byte("1"), CreatedAt: base, Labels: map[string]string{"status": "failed", "flow_id": "f1"}})
+	put(t, st, "b", &store.Record{Key: "exec_2", Value: []byte("1"), CreatedAt: base.Add(time.Hour), Labels: map[string]string{"status": "succeeded", "flow_id": "f1"}})
+	put(t, st, "b", &store.Record{Key: "other", Value: []byte("1"), CreatedAt: base.Add(2 * time.Hour), Labels: map[string]string{"status": "failed"}})
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{Prefix: "exec_"}), []string{"exec_1", "exec_2"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Labels: map[string]string{"status": "failed"}}), []string{"exec_1", "other"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Labels: map[string]string{"status": "failed", "flow_id": "f1"}}), []string{"exec_1"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{CreatedAfter: base}), []string{"exec_2", "other"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{CreatedBefore: base.Add(2 * time.Hour)}), []string{"exec_1", "exec_2"})
+}
+
+func testListPaging(t *testing.T, st store.Store) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		put(t, st, "b", &store.Record{Key: key, Value: []byte("1"), CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{Limit: 2}), []string{"a", "b"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Offset: 2, Limit: 1}), []string{"c"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Offset: 10}), []string{})
+}
+
+func testListSorting(t *testing.T, st store.Store) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	put(t, st, "b", &store.Record{Key: "a", Value: []byte("1"), CreatedAt: base.Add(time.Hour), Labels: map[string]string{"name": "orders"}})
+	put(t, st, "b", &store.Record{Key: "b", Value: []byte("1"), CreatedAt: base, Labels: map[string]string{"name": "customers"}})
+	put(t, st, "b", &store.Record{Key: "c", Value: []byte("1"), CreatedAt: base.Add(2 * time.Hour), Labels: map[string]string{"name": "reorders"}})
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{Descending: true}), []string{"c", "a", "b"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Sort: store.SortKey, Descending: true, Limit: 2}), []string{"c", "b"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Sort: store.SortLabelPrefix + "name"}), []string{"b", "a", "c"})
+	equalKeys(t, list(t, st, "b", store.ListOptions{Contains: map[string]string{"name": "ORDER"}}), []string{"a", "c"})
+}
+
+func testCount(t *testing.T, st store.Store) {
+	for i, key := range []string{"a", "b", "c"} {
+		status := "failed"
+		if i == 0 {
+			status = "succeeded"
+		}
+		put(t, st, "b", &store.Record{Key: key, Value: []byte("1"), Labels: map[string]string{"status": status}})
+	}
+
+	n, err := st.Count(context.Background(), "b", store.ListOptions{Labels: map[string]string{"status": "failed"}, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Count = %d, want 2", n)
+	}
+}
+
+func testReturnedRecordsAreCopies(t *testing.T, st store.Store) {
+	rec := &store.Record{Key: "k", Value: []byte("1"), Labels: map[string]string{"x": "1"}}
+	put(t, st, "b", rec)
+	rec.Value[0] = '9'
+	rec.Labels["x"] = "9"
+
+	got, err := st.Get(context.Background(), "b", "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got.Labels["x"] = "8"
+
+	again, err := st.Get(context.Background(), "b", "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(again.Value) != "1" || again.Labels["x"] != "1" {
+		t.Fatalf("store shares state with callers: %+v", again)
+	}
+}
+
+func testUpdateCommits(t *testing.T, st store.Store) {
+	put(t, st, "b", &store.Record{Key: "old", Value: []byte("1")})
+
+	err := st.Update(context.Background(), func(tx store.Tx) error {
+		if err := tx.Put("b", &store.Record{Key: "new", Value: []byte("2")}); err != nil {
+			return err
+		}
+		rec, err := tx.Get("b", "new")
+		if err != nil {
+			t.Errorf("Get of uncommitted write: %v", err)
+		} else if string(rec.Value) != "2" {
+			t.Errorf("uncommitted Value = %q, want 2", rec.Value)
+		}
+		if err := tx.Put("other", &store.Record{Key: "k", Value: []byte("3")}); err != nil {
+			return err
+		}
+		return tx.Delete("b", "old")
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{}), []string{"new"})
+	equalKeys(t, list(t, st, "other", store.ListOptions{}), []string{"k"})
+}
+
+func testUpdateRollsBack(t *testing.T, st store.Store) {
+	put(t, st, "b", &store.Record{Key: "old", Value: []byte("1")})
+
+	boom := errors.New("boom")
+	err := st.Update(context.Background(), func(tx store.Tx) error {
+		if err := tx.Put("b", &store.Record{Key: "new", Value: []byte("2")}); err != nil {
+			return err
+		}
+		if err := tx.Delete("b", "old"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Update error = %v, want %v", err, boom)
+	}
+
+	equalKeys(t, list(t, st, "b", store.ListOptions{}), []string{"old"})
+}
+
+func testBuckets(t *testing.T, st store.Store) {
+	lister, ok := st.(store.BucketLister)
+	if !ok {
+		t.Skip("store does not implement store.BucketLister")
+	}
+	put(t, st, "b", &store.Record{Key: "k", Value: []byte("1")})
+	put(t, st, "a", &store.Record{Key: "k", Value: []byte("1")})
+	put(t, st, "c", &store.Record{Key: "k", Value: []byte("1")})
+	if err := st.Delete(context.Background(), "c", "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	names, err := lister.Buckets(context.Background())
+	if err != nil {
+		t.Fatalf("Buckets: %v", err)
+	}
+	equalKeys(t, names, []string{"a", "b"})
+}
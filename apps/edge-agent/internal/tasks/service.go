@@ -0,0 +1,139 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// ErrNotFound is returned when a task does not exist or was already decided
+var ErrNotFound = errors.New("task not found")
+
+// ErrNotAssigned is returned when a task with assignees is decided by
+// someone else
+var ErrNotAssigned = errors.New("task is not assigned to the user")
+
+// Service is the inbox of approval tasks. Tasks are the waits of executions
+// suspended at approval steps; deciding one resumes its execution.
+type Service struct {
+	resumer *executions.Resumer
+	clock   clock.Clock
+}
+
+// NewService creates a task service over the suspensions of resumer
+func NewService(resumer *executions.Resumer, clk clock.Clock) *Service {
+	return &Service{resumer: resumer, clock: clk}
+}
+
+// List returns the pending tasks, oldest first, only those assigned to
+// assignee when it is not empty
+func (s *Service) List(ctx context.Context, assignee string) ([]*model.Task, error) {
+	suspensions, err := s.resumer.List(ctx, model.WaitKindTask)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*model.Task, 0, len(suspensions))
+	for _, susp := range suspensions {
+		task, err := toTask(susp)
+		if err != nil {
+			return nil, err
+		}
+		if assignee == "" || assigned(task, assignee) {
+			list = append(list, task)
+		}
+	}
+	return list, nil
+}
+
+// Get returns a pending task
+func (s *Service) Get(ctx context.Context, id string) (*model.Task, error) {
+	susp, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toTask(susp)
+}
+
+// Decide approves or rejects a task and resumes its execution, returning
+// the execution as resumed
+func (s *Service) Decide(ctx context.Context, id string, decision model.TaskDecision) (*model.Execution, error) {
+	switch decision.Decision {
+	case model.TaskApproved, model.TaskRejected:
+	default:
+		return nil, fmt.Errorf("invalid decision %q", decision.Decision)
+	}
+	susp, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	task, err := toTask(susp)
+	if err != nil {
+		return nil, err
+	}
+	if len(task.Assignees) > 0 && !assigned(task, decision.By) {
+		return nil, ErrNotAssigned
+	}
+
+	decision.At = s.clock.Now().UTC()
+	payload, err := engine.JSONMessage(decision)
+	if err != nil {
+		return nil, err
+	}
+	exec, err := s.resumer.Resume(ctx, susp.ExecutionID, susp.Token, payload)
+	if errors.Is(err, engine.ErrNotSuspended) {
+		return nil, ErrNotFound
+	}
+	return exec, err
+}
+
+// find returns the suspension of the pending task id
+func (s *Service) find(ctx context.Context, id string) (*engine.Suspension, error) {
+	suspensions, err := s.resumer.List(ctx, model.WaitKindTask)
+	if err != nil {
+		return nil, err
+	}
+	for _, susp := range suspensions {
+		if susp.Token == id {
+			return susp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// toTask describes a suspension at an approval step, whose data holds the
+// task's title, description and assignees
+func toTask(susp *engine.Suspension) (*model.Task, error) {
+	data, err := json.Marshal(susp.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode task %s: %w", susp.Token, err)
+	}
+	var task model.Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, fmt.Errorf("failed to decode task %s: %w", susp.Token, err)
+	}
+	task.ID = susp.Token
+	task.ExecutionID = susp.ExecutionID
+	task.FlowID = susp.FlowID
+	task.StepID = susp.StepID
+	task.CreatedAt = susp.SuspendedAt
+	task.Deadline = susp.Deadline
+	if msg := susp.Message; msg != nil && json.Valid(msg.Body) {
+		task.Payload = msg.Body
+	}
+	return &task, nil
+}
+
+func assigned(task *model.Task, user string) bool {
+	for _, a := range task.Assignees {
+		if a == user {
+			return true
+		}
+	}
+	return false
+}
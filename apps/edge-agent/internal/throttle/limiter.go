@@ -0,0 +1,186 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk bounds the bytes a throttled stream moves per wait, keeping
+// transfers smooth when the burst is large
+const maxChunk = 32 << 10
+
+// Limiter is a token bucket metering bytes. Tokens accrue at the rate up to
+// the burst; a transfer larger than the available tokens waits for the
+// shortfall. A limiter without a rate lets everything through. Limiters are
+// safe for concurrent use and their rate can change while in use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter passing bytesPerSecond with bursts of up to
+// burst bytes. A non-positive burst allows one second's worth of traffic.
+func NewLimiter(bytesPerSecond, burst int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(bytesPerSecond, burst)
+	return l
+}
+
+// SetRate changes the rate and burst, taking effect for the next transfer.
+// A non-positive rate removes the limit.
+func (l *Limiter) SetRate(bytesPerSecond, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bytesPerSecond <= 0 {
+		l.rate, l.burst, l.tokens = 0, 0, 0
+		return
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	wasLimited := l.rate > 0
+	l.advance(time.Now())
+	l.rate = float64(bytesPerSecond)
+	l.burst = float64(burst)
+	if !wasLimited || l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// advance adds the tokens accrued since the last update. Callers must hold
+// l.mu.
+func (l *Limiter) advance(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// chunk returns the largest transfer to meter at once, or 0 when unlimited
+func (l *Limiter) chunk() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	return int(min(l.burst, maxChunk))
+}
+
+// WaitN blocks until n bytes may pass, returning early with ctx's error.
+// Concurrent transfers are served in the order they ask.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 || n <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.advance(time.Now())
+	// Reserve the tokens now, borrowing against future ones, so that later
+	// transfers queue behind this one
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// AllowN takes n tokens if they are available, reporting whether it did.
+// It never waits, for sources that drop what they cannot pass on.
+func (l *Limiter) AllowN(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 || n <= 0 {
+		return true
+	}
+	l.advance(time.Now())
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// Reader limits reads from r to l's rate. Bytes are charged once read, so a
+// stream starts with a burst.
+func Reader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if chunk := r.l.chunk(); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if waitErr := r.l.WaitN(r.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
+
+// Writer limits writes to w to l's rate
+func Writer(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, l: l}
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if chunk := w.l.chunk(); chunk > 0 && n > chunk {
+			n = chunk
+		}
+		if err := w.l.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,97 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// Registry holds the bandwidth limiters of each connector, shared by every
+// execution using it. It implements engine.Bandwidth and
+// connectors.ChangeHook, so edited caps apply to transfers in progress.
+type Registry struct {
+	mu         sync.Mutex
+	connectors map[string]*limits
+}
+
+// limits are the limiters of one connector
+type limits struct {
+	read  *Limiter
+	write *Limiter
+}
+
+// NewRegistry creates a registry enforcing the caps of list
+func NewRegistry(list []*model.Connector) *Registry {
+	r := &Registry{connectors: make(map[string]*limits)}
+	for _, conn := range list {
+		r.ConnectorSaved(conn)
+	}
+	return r
+}
+
+// ConnectorSaved applies the connector's bandwidth caps
+func (r *Registry) ConnectorSaved(conn *model.Connector) {
+	var read, write *model.Rate
+	if conn.Bandwidth != nil {
+		read, write = conn.Bandwidth.Read, conn.Bandwidth.Write
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.connectors[conn.ID]
+	if !ok {
+		if read == nil && write == nil {
+			return
+		}
+		l = &limits{read: &Limiter{}, write: &Limiter{}}
+		r.connectors[conn.ID] = l
+	}
+	setRate(l.read, read)
+	setRate(l.write, write)
+}
+
+// ConnectorDeleted lifts the connector's caps
+func (r *Registry) ConnectorDeleted(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.connectors[id]; ok {
+		l.read.SetRate(0, 0)
+		l.write.SetRate(0, 0)
+	}
+}
+
+func setRate(l *Limiter, rate *model.Rate) {
+	if rate == nil {
+		l.SetRate(0, 0)
+		return
+	}
+	l.SetRate(rate.BytesPerSecond, rate.Burst)
+}
+
+// Reader limits src to the read cap of the connector
+func (r *Registry) Reader(ctx context.Context, connectorID string, src io.Reader) io.Reader {
+	return Reader(ctx, src, r.get(connectorID).read)
+}
+
+// Writer limits dst to the write cap of the connector
+func (r *Registry) Writer(ctx context.Context, connectorID string, dst io.Writer) io.Writer {
+	return Writer(ctx, dst, r.get(connectorID).write)
+}
+
+// get returns the connector's limiters, adding unlimited ones so that a cap
+// set later applies to the streams already using them
+func (r *Registry) get(connectorID string) *limits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.connectors[connectorID]
+	if !ok {
+		l = &limits{read: &Limiter{}, write: &Limiter{}}
+		r.connectors[connectorID] = l
+	}
+	return l
+}
@@ -0,0 +1,92 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/eventbus"
+)
+
+// Event headers carrying the trigger that fired across the bus
+const (
+	busHeaderTrigger      = "trigger-type"
+	busHeaderTriggerIndex = "trigger-index"
+)
+
+// SetBus decouples triggers from execution: the messages of a flow's
+// triggers are published to the flow's topic on bus, and executed by
+// whichever subscriber of group receives them, here or on another replica.
+// Triggers then get no reply from the flow. It must be called before any
+// flow is activated.
+func (m *Manager) SetBus(bus eventbus.Bus, group string) {
+	m.bus = bus
+	m.busGroup = group
+}
+
+// flowTopic is the bus topic of a flow's messages
+func flowTopic(flowID string) string {
+	return "flows." + flowID
+}
+
+// published returns the handler publishing each message of a flow's
+// triggers to its topic, as an ordered event when key is not nil. Messages
+// the bus has no room for are refused with dispatch.ErrQueueFull, like those
+// of a full tenant queue, rather than accepted and lost.
+func (m *Manager) published(flowID string, key keyFunc) Handler {
+	topic := flowTopic(flowID)
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		defer msg.Release()
+		if err := msg.Buffer(engine.DefaultMaxBufferSize); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message: %w", err)
+		}
+		ev := &eventbus.Event{Topic: topic, Data: data}
+		if key != nil {
+			// Messages without the key share one queue, see model.Ordering
+			ev.Ordered = true
+			ev.Key, _ = key(msg)
+		}
+		if t, ok := ctx.Value(firedKey{}).(fired); ok {
+			ev.Headers = map[string]string{busHeaderTrigger: t.triggerType, busHeaderTriggerIndex: strconv.Itoa(t.index)}
+		}
+		if err := m.bus.Publish(ctx, ev); errors.Is(err, eventbus.ErrFull) {
+			return nil, fmt.Errorf("%w: %v", dispatch.ErrQueueFull, err)
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+// subscribe runs h for the messages published to the flow's topic
+func (m *Manager) subscribe(ctx context.Context, flowID string, h Handler) (eventbus.Subscription, error) {
+	return m.bus.Subscribe(ctx, flowTopic(flowID), m.busGroup, func(ctx context.Context, ev *eventbus.Event) error {
+		var msg engine.Message
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			// Redelivery would not help; drop it
+			m.logger.WithField("flow_id", flowID).Errorf("Dropping undecodable event: %v", err)
+			return nil
+		}
+		if index, err := strconv.Atoi(ev.Headers[busHeaderTriggerIndex]); err == nil {
+			ctx = context.WithValue(ctx, firedKey{}, fired{triggerType: ev.Headers[busHeaderTrigger], index: index})
+		}
+		reply, err := h(ctx, &msg)
+		if reply != nil {
+			reply.Release()
+		}
+		// Failed executions are recorded as such; only a message refused by
+		// a full tenant queue is left for redelivery
+		if errors.Is(err, dispatch.ErrQueueFull) {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,179 @@
+package triggers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. Five fields (minute, hour, day
+// of month, month, day of week) or six with leading seconds, as written by
+// Quartz, are accepted, as are the @hourly style descriptors.
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow []bool
+	// domAny and dowAny record day fields starting with a wildcard; when
+	// both are restricted a day matching either one fires
+	domAny, dowAny bool
+	loc            *time.Location
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses expr, evaluated in loc
+func parseCron(expr string, loc *time.Location) (*cronSchedule, error) {
+	if d, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	case 7:
+		// Quartz allows a trailing year, which must be unrestricted
+		if fields[6] != "*" {
+			return nil, fmt.Errorf("invalid cron expression %q: the year field is not supported", expr)
+		}
+		fields = fields[:6]
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 or 6 fields", expr)
+	}
+
+	s := &cronSchedule{loc: loc}
+	var err error
+	parse := func(field string, min, max int, names map[string]int) []bool {
+		if err != nil {
+			return nil
+		}
+		var set []bool
+		set, err = parseCronField(field, min, max, names)
+		return set
+	}
+	s.second = parse(fields[0], 0, 59, nil)
+	s.minute = parse(fields[1], 0, 59, nil)
+	s.hour = parse(fields[2], 0, 23, nil)
+	s.dom = parse(fields[3], 1, 31, nil)
+	s.month = parse(fields[4], 1, 12, monthNames)
+	s.dow = parse(fields[5], 0, 7, dayNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+	// Sunday may be written as 0 or 7
+	s.dow[0] = s.dow[0] || s.dow[7]
+	s.domAny = strings.HasPrefix(fields[3], "*") || fields[3] == "?"
+	s.dowAny = strings.HasPrefix(fields[5], "*") || fields[5] == "?"
+	return s, nil
+}
+
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseCronField expands a comma-separated list of values, ranges and
+// steps into a set indexed by value
+func parseCronField(field string, min, max int, names map[string]int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case isWildcard(rng):
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(a, names); err != nil {
+				return nil, err
+			}
+			if hi, err = cronValue(b, names); err != nil {
+				return nil, err
+			}
+		default:
+			v, err := cronValue(rng, names)
+			if err != nil {
+				return nil, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// next returns the first matching time after t, or the zero time when
+// nothing matches within five years (e.g. 30 February)
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case !s.minute[t.Minute()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.loc)
+		case !s.second[t.Second()]:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,174 @@
+package triggers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// Lifecycle states of a running trigger
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateStopped = "stopped"
+)
+
+// Status is the state, health and activity of one trigger of a flow
+type Status struct {
+	FlowID        string     `json:"flowId"`
+	Index         int        `json:"index"`
+	Type          string     `json:"type"`
+	State         string     `json:"state"`
+	Health        Health     `json:"health"`
+	Messages      int64      `json:"messages"`
+	Errors        int64      `json:"errors"`
+	LastMessageAt *time.Time `json:"lastMessageAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	StartedAt     time.Time  `json:"startedAt"`
+}
+
+// instance tracks a started trigger. Its handler wrapper counts messages and
+// holds them while a trigger that cannot pause itself is paused.
+type instance struct {
+	flowID      string
+	index       int
+	triggerType string
+	trigger     Trigger
+	startedAt   time.Time
+
+	mu       sync.Mutex
+	state    string
+	resumed  chan struct{}
+	messages int64
+	errors   int64
+	lastAt   time.Time
+	lastErr  string
+}
+
+func newInstance(flowID string, index int, triggerType string, trigger Trigger) *instance {
+	return &instance{
+		flowID:      flowID,
+		index:       index,
+		triggerType: triggerType,
+		trigger:     trigger,
+		startedAt:   time.Now().UTC(),
+		state:       StateRunning,
+	}
+}
+
+// wrap returns h instrumented with the instance's pause gate and counters
+func (i *instance) wrap(h Handler) Handler {
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		if err := i.wait(ctx); err != nil {
+			msg.Release()
+			return nil, err
+		}
+		reply, err := h(ctx, msg)
+
+		i.mu.Lock()
+		i.messages++
+		i.lastAt = time.Now().UTC()
+		if err != nil {
+			i.errors++
+			i.lastErr = err.Error()
+		}
+		i.mu.Unlock()
+		return reply, err
+	}
+}
+
+// wait blocks while the instance is paused
+func (i *instance) wait(ctx context.Context) error {
+	i.mu.Lock()
+	resumed := i.resumed
+	i.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (i *instance) pause(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.state != StateRunning {
+		return nil
+	}
+	if p, ok := i.trigger.(Pauser); ok {
+		if err := p.Pause(ctx); err != nil {
+			return err
+		}
+	} else {
+		i.resumed = make(chan struct{})
+	}
+	i.state = StatePaused
+	return nil
+}
+
+func (i *instance) resume(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.state != StatePaused {
+		return nil
+	}
+	if p, ok := i.trigger.(Pauser); ok {
+		if err := p.Resume(ctx); err != nil {
+			return err
+		}
+	}
+	i.release()
+	i.state = StateRunning
+	return nil
+}
+
+func (i *instance) stop(ctx context.Context) error {
+	i.mu.Lock()
+	// Let held messages through so the trigger can drain
+	i.release()
+	i.state = StateStopped
+	i.mu.Unlock()
+
+	return i.trigger.Stop(ctx)
+}
+
+// release opens the pause gate; i.mu must be held
+func (i *instance) release() {
+	if i.resumed != nil {
+		close(i.resumed)
+		i.resumed = nil
+	}
+}
+
+func (i *instance) status() Status {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	st := Status{
+		FlowID:    i.flowID,
+		Index:     i.index,
+		Type:      i.triggerType,
+		State:     i.state,
+		Health:    Health{Status: HealthOK},
+		Messages:  i.messages,
+		Errors:    i.errors,
+		LastError: i.lastErr,
+		StartedAt: i.startedAt,
+	}
+	if r, ok := i.trigger.(HealthReporter); ok {
+		st.Health = r.Health()
+	}
+	if !i.lastAt.IsZero() {
+		lastAt := i.lastAt
+		st.LastMessageAt = &lastAt
+	}
+	return st
+}
@@ -0,0 +1,307 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/kafka"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+func init() {
+	Register("kafka", newKafka)
+}
+
+// Headers set by the kafka trigger
+const (
+	HeaderKafkaTopic     = "kafka-topic"
+	HeaderKafkaPartition = "kafka-partition"
+	HeaderKafkaOffset    = "kafka-offset"
+	HeaderKafkaKey       = "kafka-key"
+)
+
+// Offset commit strategies of the kafka trigger
+const (
+	// CommitAfter commits a message once its flow has finished, so messages
+	// in flight during a crash are consumed again
+	CommitAfter = "after"
+	// CommitBefore commits a message before running its flow, so messages in
+	// flight during a crash are lost rather than repeated
+	CommitBefore = "before"
+	// CommitInterval commits finished messages in batches every
+	// commitInterval seconds, repeating up to that much work after a crash
+	CommitInterval = "interval"
+)
+
+// kafkaConfig configures the kafka trigger
+type kafkaConfig struct {
+	kafka.Config
+	Topic  string   `json:"topic"`
+	Topics []string `json:"topics"`
+	// GroupID is the consumer group; the partitions of the topics are shared
+	// among its members, including other agents
+	GroupID string `json:"groupId"`
+	// StartOffset is where a group without committed offsets starts,
+	// earliest or latest (default)
+	StartOffset    string `json:"startOffset"`
+	Commit         string `json:"commit"`
+	CommitInterval int    `json:"commitInterval"`
+	// Consumers is the number of group members run by this trigger, each
+	// consuming its partitions in order
+	Consumers int `json:"consumers"`
+	// MaxRetries is how often a failed flow is retried before the message is
+	// skipped
+	MaxRetries  int    `json:"maxRetries"`
+	ContentType string `json:"contentType"`
+}
+
+// kafkaTrigger consumes topics as a member of a consumer group, running the
+// flow for each message. Each consumer handles one message at a time, so a
+// slow flow slows consumption; a full execution queue holds the message
+// until it is accepted, without counting as a failure.
+type kafkaTrigger struct {
+	cfg    kafkaConfig
+	topics []string
+
+	readers []*kafkago.Reader
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	lastErr error
+	skipped int64
+}
+
+func newKafka(config map[string]interface{}) (Trigger, error) {
+	cfg := kafkaConfig{Commit: CommitAfter, StartOffset: "latest", CommitInterval: 5, Consumers: 1, MaxRetries: 3, ContentType: "application/json"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("kafka trigger: %w", err)
+	}
+	topics := cfg.Topics
+	if cfg.Topic != "" {
+		topics = append([]string{cfg.Topic}, topics...)
+	}
+	if len(topics) == 0 {
+		return nil, errors.New("kafka trigger requires a topic")
+	}
+	if cfg.GroupID == "" {
+		return nil, errors.New("kafka trigger requires a groupId")
+	}
+	if cfg.StartOffset != "earliest" && cfg.StartOffset != "latest" {
+		return nil, fmt.Errorf("invalid startOffset %q: must be earliest or latest", cfg.StartOffset)
+	}
+	switch cfg.Commit {
+	case CommitAfter, CommitBefore:
+	case CommitInterval:
+		if cfg.CommitInterval <= 0 {
+			return nil, errors.New("commitInterval must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("invalid commit strategy %q: must be %s, %s or %s", cfg.Commit, CommitAfter, CommitBefore, CommitInterval)
+	}
+	if cfg.Consumers <= 0 {
+		return nil, errors.New("consumers must be positive")
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, errors.New("maxRetries must not be negative")
+	}
+	return &kafkaTrigger{cfg: cfg, topics: topics}, nil
+}
+
+func (t *kafkaTrigger) Start(ctx context.Context, h Handler) error {
+	dialer, err := t.cfg.Dialer()
+	if err != nil {
+		return err
+	}
+	rc := kafkago.ReaderConfig{
+		Brokers:     t.cfg.Brokers,
+		GroupID:     t.cfg.GroupID,
+		GroupTopics: t.topics,
+		Dialer:      dialer,
+		StartOffset: kafkago.LastOffset,
+		MaxWait:     time.Second,
+	}
+	if t.cfg.StartOffset == "earliest" {
+		rc.StartOffset = kafkago.FirstOffset
+	}
+	if t.cfg.Commit == CommitInterval {
+		rc.CommitInterval = time.Duration(t.cfg.CommitInterval) * time.Second
+	}
+
+	ctx, t.cancel = context.WithCancel(ctx)
+	for i := 0; i < t.cfg.Consumers; i++ {
+		r := kafkago.NewReader(rc)
+		t.readers = append(t.readers, r)
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.consume(ctx, r, h)
+		}()
+	}
+	return nil
+}
+
+func (t *kafkaTrigger) Stop(ctx context.Context) error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Closing flushes pending interval commits and leaves the group
+	var errs []error
+	for _, r := range t.readers {
+		if err := r.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// consume runs the flow for each message r fetches until ctx is cancelled
+func (t *kafkaTrigger) consume(ctx context.Context, r *kafkago.Reader, h Handler) {
+	failures := 0
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			t.setErr(fmt.Errorf("failed to fetch: %w", err))
+			failures++
+			if !sleep(ctx, backoff(failures)) {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		if t.cfg.Commit == CommitBefore && !t.commit(ctx, r, m) {
+			continue
+		}
+		if !t.deliver(ctx, m, h) {
+			// Stopped mid-message: leave it uncommitted for the next member
+			return
+		}
+		if t.cfg.Commit != CommitBefore {
+			t.commit(ctx, r, m)
+		}
+	}
+}
+
+// commit commits the offset of m, reporting whether it succeeded
+func (t *kafkaTrigger) commit(ctx context.Context, r *kafkago.Reader, m kafkago.Message) bool {
+	if err := r.CommitMessages(ctx, m); err != nil {
+		if ctx.Err() == nil {
+			t.setErr(fmt.Errorf("failed to commit offset %d of %s/%d: %w", m.Offset, m.Topic, m.Partition, err))
+		}
+		return false
+	}
+	t.setErr(nil)
+	return true
+}
+
+// deliver runs the flow for m, retrying failures up to MaxRetries times and
+// waiting while the execution queue is full. It returns false when ctx was
+// cancelled before the message was handled.
+func (t *kafkaTrigger) deliver(ctx context.Context, m kafkago.Message, h Handler) bool {
+	attempts, waits := 0, 0
+	for {
+		_, err := h(ctx, t.message(m))
+		switch {
+		case err == nil:
+			return true
+		case ctx.Err() != nil:
+			return false
+		case errors.Is(err, dispatch.ErrQueueFull):
+			waits++
+			if !sleep(ctx, backoff(waits)) {
+				return false
+			}
+			continue
+		}
+		attempts++
+		if attempts > t.cfg.MaxRetries {
+			t.mu.Lock()
+			t.skipped++
+			t.mu.Unlock()
+			return true
+		}
+		if !sleep(ctx, backoff(attempts)) {
+			return false
+		}
+	}
+}
+
+// message builds the flow's input from m. Record headers are passed on, so
+// that a request-id or parent-execution-id set by the producer carries over.
+func (t *kafkaTrigger) message(m kafkago.Message) *engine.Message {
+	msg := engine.NewMessage(m.Value, t.cfg.ContentType)
+	for _, header := range m.Headers {
+		msg.SetHeader(strings.ToLower(header.Key), string(header.Value))
+	}
+	partition, offset := strconv.Itoa(m.Partition), strconv.FormatInt(m.Offset, 10)
+	msg.SetHeader(HeaderKafkaTopic, m.Topic)
+	msg.SetHeader(HeaderKafkaPartition, partition)
+	msg.SetHeader(HeaderKafkaOffset, offset)
+	if len(m.Key) > 0 {
+		msg.SetHeader(HeaderKafkaKey, string(m.Key))
+	}
+	msg.SetHeader(engine.HeaderMessageID, m.Topic+"/"+partition+"/"+offset)
+	return msg
+}
+
+func (t *kafkaTrigger) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastErr = err
+}
+
+// Health implements HealthReporter
+func (t *kafkaTrigger) Health() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	detail := fmt.Sprintf("group %s on %s, %d consumer(s), %d message(s) skipped after failing", t.cfg.GroupID, strings.Join(t.topics, ","), t.cfg.Consumers, t.skipped)
+	if t.lastErr != nil {
+		return Health{Status: HealthDegraded, Detail: t.lastErr.Error()}
+	}
+	return Health{Status: HealthOK, Detail: detail}
+}
+
+// backoff is the wait before the n-th retry, doubling from 1s up to 30s
+func backoff(n int) time.Duration {
+	d := time.Second << min(n-1, 5)
+	return min(d, 30*time.Second)
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
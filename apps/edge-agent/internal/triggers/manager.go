@@ -0,0 +1,310 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/eventbus"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotRunning is returned when pausing or resuming a flow without
+// running triggers
+var ErrNotRunning = errors.New("flow has no running triggers")
+
+// ErrNotClaimed is returned to time-based triggers for messages dropped
+// because another replica holds the trigger's claim
+var ErrNotClaimed = errors.New("trigger is claimed by another replica")
+
+// Manager starts the triggers of active flows and runs their plans. It is
+// registered with the flow service as an activation hook.
+type Manager struct {
+	logger   *logrus.Logger
+	executor *engine.Executor
+	plans    *engine.PlanCache
+	clock    clock.Clock
+	claimer  Claimer
+	bus      eventbus.Bus
+	busGroup string
+
+	mu      sync.Mutex
+	running map[string][]*instance
+	// subs are the bus subscriptions executing the messages of each flow
+	subs map[string]eventbus.Subscription
+}
+
+// NewManager creates a trigger manager whose executions are run by executor
+// with their plans from plans. Time-based triggers follow clk.
+func NewManager(logger *logrus.Logger, executor *engine.Executor, plans *engine.PlanCache, clk clock.Clock) *Manager {
+	return &Manager{logger: logger, executor: executor, plans: plans, clock: clk, running: make(map[string][]*instance), subs: make(map[string]eventbus.Subscription)}
+}
+
+// Claimer decides which replica of a cluster runs a time-based trigger
+type Claimer interface {
+	// Claim reports whether this replica holds key
+	Claim(ctx context.Context, key string) (bool, error)
+}
+
+// SetClaimer makes schedules fire on a single replica of a cluster: each
+// firing runs only on the replica holding the trigger's claim. It must be
+// called before any flow is activated.
+func (m *Manager) SetClaimer(c Claimer) {
+	m.claimer = c
+}
+
+// Activate compiles the flow and starts its triggers. Flows without any
+// trigger of a registered type are left alone.
+func (m *Manager) Activate(ctx context.Context, flow *model.Flow) error {
+	var defs []model.Trigger
+	for _, t := range flow.Triggers {
+		if _, ok := lookup(t.Type); ok {
+			defs = append(defs, t)
+		} else {
+			m.logger.WithField("flow_id", flow.ID).Warnf("Trigger type %q is not supported by this agent; skipping", t.Type)
+		}
+	}
+	if len(defs) == 0 {
+		return nil
+	}
+	if flow.Ordering != nil && m.bus != nil && !m.bus.Ordered() {
+		return errors.New("the event bus cannot keep the order of the flow's messages")
+	}
+
+	plan, err := m.plans.Plan(flow)
+	if err != nil {
+		return fmt.Errorf("failed to compile flow: %w", err)
+	}
+
+	// Replace the triggers of a previously active version
+	if err := m.Deactivate(ctx, flow); err != nil {
+		return err
+	}
+
+	handler := m.handler(plan, flow.Tenant)
+	var key keyFunc
+	if flow.Ordering != nil {
+		key = parseKey(flow.Ordering.Key)
+		handler = ordered(key, handler)
+	}
+	var sub eventbus.Subscription
+	if m.bus != nil {
+		sub, err = m.subscribe(ctx, flow.ID, handler)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to the event bus: %w", err)
+		}
+		handler = m.published(flow.ID, key)
+	}
+	started := make([]*instance, 0, len(defs))
+	for i, def := range defs {
+		trigger, err := New(def.Type, def.Config)
+		if err != nil {
+			stopAll(ctx, started)
+			closeSub(sub)
+			return fmt.Errorf("failed to start trigger %d (%s): %w", i, def.Type, err)
+		}
+		inst := newInstance(flow.ID, i, def.Type, trigger)
+		h := inst.wrap(firedBy(def.Type, i, handler))
+		if c, ok := trigger.(Clocked); ok {
+			c.SetClock(m.clock)
+			if m.claimer != nil {
+				h = m.claimed(fmt.Sprintf("schedule/%s/%d", flow.ID, i), h)
+			}
+		}
+		if err := trigger.Start(context.Background(), h); err != nil {
+			stopAll(ctx, started)
+			closeSub(sub)
+			return fmt.Errorf("failed to start trigger %d (%s): %w", i, def.Type, err)
+		}
+		started = append(started, inst)
+	}
+
+	m.mu.Lock()
+	m.running[flow.ID] = started
+	if sub != nil {
+		m.subs[flow.ID] = sub
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Deactivate stops the flow's triggers
+func (m *Manager) Deactivate(ctx context.Context, flow *model.Flow) error {
+	m.mu.Lock()
+	running := m.running[flow.ID]
+	delete(m.running, flow.ID)
+	sub := m.subs[flow.ID]
+	delete(m.subs, flow.ID)
+	m.mu.Unlock()
+
+	err := stopAll(ctx, running)
+	closeSub(sub)
+	return err
+}
+
+// Pause stops the flow's triggers from starting executions until Resume.
+// The triggers keep their resources, so resuming is immediate.
+func (m *Manager) Pause(ctx context.Context, flowID string) error {
+	return m.each(flowID, func(inst *instance) error { return inst.pause(ctx) })
+}
+
+// Resume restarts the delivery of messages from a paused flow's triggers
+func (m *Manager) Resume(ctx context.Context, flowID string) error {
+	return m.each(flowID, func(inst *instance) error { return inst.resume(ctx) })
+}
+
+func (m *Manager) each(flowID string, fn func(*instance) error) error {
+	m.mu.Lock()
+	running := m.running[flowID]
+	m.mu.Unlock()
+
+	if len(running) == 0 {
+		return ErrNotRunning
+	}
+	var errs []error
+	for _, inst := range running {
+		if err := fn(inst); err != nil {
+			errs = append(errs, fmt.Errorf("trigger %d (%s): %w", inst.index, inst.triggerType, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports the state and health of every running trigger, ordered by
+// flow. An empty flowID reports all flows.
+func (m *Manager) Status(flowID string) []Status {
+	m.mu.Lock()
+	var list []*instance
+	for id, running := range m.running {
+		if flowID == "" || id == flowID {
+			list = append(list, running...)
+		}
+	}
+	m.mu.Unlock()
+
+	statuses := make([]Status, 0, len(list))
+	for _, inst := range list {
+		statuses = append(statuses, inst.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].FlowID != statuses[j].FlowID {
+			return statuses[i].FlowID < statuses[j].FlowID
+		}
+		return statuses[i].Index < statuses[j].Index
+	})
+	return statuses
+}
+
+// Stop stops every running trigger, e.g. at shutdown
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	running := m.running
+	m.running = make(map[string][]*instance)
+	subs := m.subs
+	m.subs = make(map[string]eventbus.Subscription)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, list := range running {
+		if err := stopAll(ctx, list); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, sub := range subs {
+		closeSub(sub)
+	}
+	return errors.Join(errs...)
+}
+
+// handler runs plan for each message, once the tenant's turn comes, and
+// replies with its first output
+func (m *Manager) handler(plan *engine.Plan, tenant string) Handler {
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		cause := &model.Cause{
+			Type:      model.CauseTrigger,
+			RequestID: msg.Headers[engine.HeaderRequestID],
+			ParentID:  msg.Headers[engine.HeaderParentExecution],
+		}
+		if t, ok := ctx.Value(firedKey{}).(fired); ok {
+			cause.Trigger, cause.TriggerIndex = t.triggerType, &t.index
+		}
+		result, err := m.executor.Execute(ctx, plan, msg, engine.ExecuteOptions{Tenant: tenant, Cause: cause, Debug: logging.IsDebug(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Outputs) == 0 {
+			return nil, nil
+		}
+		for _, out := range result.Outputs[1:] {
+			out.Release()
+		}
+		reply := result.Outputs[0]
+		if err := reply.Buffer(engine.DefaultMaxBufferSize); err != nil {
+			m.logger.WithField("flow_id", plan.FlowID).Errorf("Failed to buffer flow reply: %v", err)
+			return nil, err
+		}
+		return reply, nil
+	}
+}
+
+// firedKey is the context key of the trigger that fired
+type firedKey struct{}
+
+// fired identifies a flow's trigger
+type fired struct {
+	triggerType string
+	index       int
+}
+
+// firedBy wraps h to pass on which trigger of the flow fired, for the
+// execution's cause
+func firedBy(triggerType string, index int, h Handler) Handler {
+	t := fired{triggerType: triggerType, index: index}
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		return h(context.WithValue(ctx, firedKey{}, t), msg)
+	}
+}
+
+// claimed runs h only while this replica holds key. Firings on other
+// replicas are dropped with ErrNotClaimed, so that pollers leave what they
+// found for the holder.
+func (m *Manager) claimed(key string, h Handler) Handler {
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		held, err := m.claimer.Claim(ctx, key)
+		if err != nil {
+			m.logger.Warnf("Skipping trigger firing: %v", err)
+		}
+		if !held {
+			msg.Release()
+			return nil, ErrNotClaimed
+		}
+		return h(ctx, msg)
+	}
+}
+
+// closeSub closes a flow's bus subscription, if any, once its triggers
+// have stopped publishing
+func closeSub(sub eventbus.Subscription) {
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+func stopAll(ctx context.Context, list []*instance) error {
+	var errs []error
+	for i := len(list) - 1; i >= 0; i-- {
+		if err := list[i].stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to stop triggers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/formats/hl7"
+)
+
+func init() {
+	Register("mllp", newMLLP)
+}
+
+// Acknowledgement modes of the MLLP trigger
+const (
+	// AckAuto replies AA when the flow succeeds and AE when it fails
+	AckAuto = "auto"
+	// AckFlow replies with the flow's output, which should be an HL7 ACK
+	AckFlow = "flow"
+)
+
+// mllpConfig configures the mllp trigger
+type mllpConfig struct {
+	Address         string `json:"address"`
+	Ack             string `json:"ack"`
+	MaxMessageBytes int    `json:"maxMessageBytes"`
+	// IdleTimeout closes connections with no traffic, in seconds
+	IdleTimeout int `json:"idleTimeout"`
+}
+
+// mllpTrigger is a TCP listener receiving MLLP-framed HL7v2 messages
+type mllpTrigger struct {
+	cfg      mllpConfig
+	listener *tcpListener
+}
+
+func newMLLP(config map[string]interface{}) (Trigger, error) {
+	cfg := mllpConfig{Ack: AckAuto, MaxMessageBytes: 1 << 20, IdleTimeout: 300}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Address == "" {
+		return nil, errors.New("mllp trigger requires an address")
+	}
+	if cfg.Ack != AckAuto && cfg.Ack != AckFlow {
+		return nil, fmt.Errorf("invalid ack mode %q: must be %s or %s", cfg.Ack, AckAuto, AckFlow)
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		return nil, errors.New("maxMessageBytes must be positive")
+	}
+	return &mllpTrigger{cfg: cfg, listener: newTCPListener(cfg.Address, mllpFramer{}, cfg.MaxMessageBytes, cfg.IdleTimeout)}, nil
+}
+
+func (t *mllpTrigger) Start(ctx context.Context, h Handler) error {
+	return t.listener.start(ctx, func(ctx context.Context, conn net.Conn, frame []byte) ([]byte, error) {
+		return t.handle(ctx, frame, h)
+	})
+}
+
+func (t *mllpTrigger) Stop(ctx context.Context) error {
+	return t.listener.stop(ctx)
+}
+
+// handle runs the flow for one frame and builds the reply. Frames that are
+// not HL7 messages cannot be acknowledged and are dropped.
+func (t *mllpTrigger) handle(ctx context.Context, frame []byte, h Handler) ([]byte, error) {
+	msg, err := hl7.Parse(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	in := engine.NewMessage(frame, hl7.ContentType)
+	in.SetHeader("hl7-message-type", msg.Type())
+	in.SetHeader("hl7-control-id", msg.ControlID())
+	out, err := h(ctx, in)
+	switch {
+	case err != nil:
+		return msg.Ack(hl7.AckError, truncate(err.Error(), 80)).Bytes(), nil
+	case t.cfg.Ack == AckFlow && out != nil:
+		return out.Body, nil
+	case t.cfg.Ack == AckFlow:
+		// The flow chose not to reply
+		return nil, nil
+	default:
+		return msg.Ack(hl7.AckAccept, "").Bytes(), nil
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// Health implements HealthReporter
+func (t *mllpTrigger) Health() Health {
+	return t.listener.health()
+}
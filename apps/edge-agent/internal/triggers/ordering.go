@@ -0,0 +1,115 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/lazyjson"
+)
+
+// keyFunc extracts the ordering key of a message; ok is false when the
+// message does not carry it
+type keyFunc func(msg *engine.Message) (key string, ok bool)
+
+// parseKey compiles an ordering key expression, see model.Ordering
+func parseKey(expr string) keyFunc {
+	if name, ok := strings.CutPrefix(expr, "headers."); ok {
+		return func(msg *engine.Message) (string, bool) {
+			v, ok := msg.Headers[name]
+			return v, ok
+		}
+	}
+	expr = strings.TrimPrefix(expr, "$.")
+	expr = strings.TrimPrefix(expr, "body.")
+	path := strings.Split(expr, ".")
+	return func(msg *engine.Message) (string, bool) {
+		if err := msg.Buffer(engine.DefaultMaxBufferSize); err != nil {
+			return "", false
+		}
+		// Only the path to the key is scanned, not the whole body
+		v, ok, err := lazyjson.Get(msg.Body, path...)
+		if !ok || err != nil {
+			return "", false
+		}
+		switch v.Kind {
+		case lazyjson.Object, lazyjson.Array:
+			// Composite keys compare by their canonical JSON
+			var composite interface{}
+			if err := v.Decode(&composite); err != nil {
+				return "", false
+			}
+			b, _ := json.Marshal(composite)
+			return string(b), true
+		}
+		return v.String()
+	}
+}
+
+// keyedQueues serializes handler calls per key. Each call waits for the
+// previous call with the same key, in arrival order; calls with different
+// keys run concurrently.
+type keyedQueues struct {
+	mu sync.Mutex
+	// tails holds, per key, the done channel of the last call to arrive
+	tails map[string]chan struct{}
+}
+
+func newKeyedQueues() *keyedQueues {
+	return &keyedQueues{tails: make(map[string]chan struct{})}
+}
+
+// ordered wraps h so that messages with the same key are handled one at a
+// time in the order they arrive
+func ordered(key keyFunc, h Handler) Handler {
+	q := newKeyedQueues()
+	return func(ctx context.Context, msg *engine.Message) (*engine.Message, error) {
+		k, ok := key(msg)
+		if !ok {
+			k = ""
+		}
+		release, err := q.acquire(ctx, k)
+		if err != nil {
+			msg.Release()
+			return nil, err
+		}
+		defer release()
+		return h(ctx, msg)
+	}
+}
+
+// acquire waits for the key's previous call and returns the function that
+// lets the next one proceed
+func (q *keyedQueues) acquire(ctx context.Context, key string) (func(), error) {
+	q.mu.Lock()
+	prev := q.tails[key]
+	own := make(chan struct{})
+	q.tails[key] = own
+	q.mu.Unlock()
+
+	release := func() {
+		close(own)
+		q.mu.Lock()
+		if q.tails[key] == own {
+			delete(q.tails, key)
+		}
+		q.mu.Unlock()
+	}
+	if prev == nil {
+		return release, nil
+	}
+
+	select {
+	case <-prev:
+		return release, nil
+	case <-ctx.Done():
+		// Keep the queue intact: the next call must still wait for prev
+		go func() {
+			<-prev
+			release()
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,337 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/remotefs"
+)
+
+func init() {
+	for _, protocol := range []string{remotefs.ProtocolSFTP, remotefs.ProtocolFTP, remotefs.ProtocolFTPS} {
+		protocol := protocol
+		Register(protocol, func(config map[string]interface{}) (Trigger, error) {
+			return newRemoteFile(protocol, config)
+		})
+	}
+}
+
+// Headers set by the remote file triggers, named as those of the file step
+const (
+	HeaderFileName = "file-name"
+	HeaderFilePath = "file-path"
+	HeaderFileSize = "file-size"
+)
+
+// What the remote file triggers do with a file once its flow succeeded
+const (
+	AfterDelete = "delete"
+	AfterMove   = "move"
+	// AfterNone leaves the file, which is picked up again only once its
+	// size or modification time changes
+	AfterNone = "none"
+)
+
+// remoteFileConfig configures the sftp, ftp and ftps triggers
+type remoteFileConfig struct {
+	remotefs.Config
+	Directory string `json:"directory"`
+	// Pattern selects files by name, as path.Match; hidden files, including
+	// partial uploads, are always skipped
+	Pattern  string      `json:"pattern"`
+	Interval interface{} `json:"interval"`
+	// MinAge skips files modified more recently, for senders that write in
+	// place rather than renaming complete uploads
+	MinAge string `json:"minAge"`
+	After  string `json:"after"`
+	MoveTo string `json:"moveTo"`
+	// FailedTo is where files whose flow failed are moved; they are retried
+	// on every poll when empty
+	FailedTo    string `json:"failedTo"`
+	MaxFiles    int    `json:"maxFiles"`
+	ContentType string `json:"contentType"`
+}
+
+// remoteFileTrigger polls a directory of an SFTP or FTP server and runs the
+// flow with each new file, streamed rather than buffered. Files are run one
+// at a time, oldest first, over a connection dialed for each poll.
+type remoteFileTrigger struct {
+	protocol string
+	cfg      remoteFileConfig
+	interval time.Duration
+	minAge   time.Duration
+	clock    clock.Clock
+
+	// seen holds the size and modification time of files left in place
+	seen map[string]string
+
+	paused atomic.Bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	lastPoll  time.Time
+	lastErr   error
+	processed int64
+	failed    int64
+}
+
+func newRemoteFile(protocol string, config map[string]interface{}) (Trigger, error) {
+	cfg := remoteFileConfig{Directory: ".", Interval: "60s", After: AfterDelete, MaxFiles: 100, ContentType: "application/octet-stream"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Config.Validate(protocol); err != nil {
+		return nil, err
+	}
+	interval, err := parseInterval(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	t := &remoteFileTrigger{protocol: protocol, cfg: cfg, interval: interval, clock: clock.Real, seen: make(map[string]string)}
+	if cfg.MinAge != "" {
+		if t.minAge, err = time.ParseDuration(cfg.MinAge); err != nil || t.minAge < 0 {
+			return nil, fmt.Errorf("invalid minAge %q", cfg.MinAge)
+		}
+	}
+	if cfg.Pattern != "" {
+		if _, err := path.Match(cfg.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", cfg.Pattern)
+		}
+	}
+	switch cfg.After {
+	case AfterDelete, AfterNone:
+	case AfterMove:
+		if cfg.MoveTo == "" {
+			return nil, errors.New("after move requires moveTo")
+		}
+	default:
+		return nil, fmt.Errorf("invalid after %q: must be %s, %s or %s", cfg.After, AfterDelete, AfterMove, AfterNone)
+	}
+	if cfg.MaxFiles <= 0 {
+		return nil, errors.New("maxFiles must be positive")
+	}
+	return t, nil
+}
+
+// SetClock implements Clocked, which also has polls claimed by a single
+// replica of a cluster
+func (t *remoteFileTrigger) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+func (t *remoteFileTrigger) Start(ctx context.Context, h Handler) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			if !t.paused.Load() {
+				err := t.poll(ctx, h)
+				t.mu.Lock()
+				t.lastPoll, t.lastErr = t.clock.Now(), err
+				t.mu.Unlock()
+			}
+			timer := t.clock.NewTimer(t.interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *remoteFileTrigger) Stop(ctx context.Context) error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause implements Pauser; polls are skipped until Resume
+func (t *remoteFileTrigger) Pause(ctx context.Context) error {
+	t.paused.Store(true)
+	return nil
+}
+
+// Resume implements Pauser
+func (t *remoteFileTrigger) Resume(ctx context.Context) error {
+	t.paused.Store(false)
+	return nil
+}
+
+// poll runs the flow with the new files of the directory
+func (t *remoteFileTrigger) poll(ctx context.Context, h Handler) error {
+	fs, err := remotefs.Dial(ctx, t.protocol, t.cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	files, err := fs.List(ctx, t.cfg.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", t.cfg.Directory, err)
+	}
+	files = t.pending(files)
+	for _, f := range files {
+		if ctx.Err() != nil || t.paused.Load() {
+			return nil
+		}
+		err := t.run(ctx, fs, f, h)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNotClaimed), errors.Is(err, dispatch.ErrQueueFull):
+			// Left for the replica holding the claim, or the next poll
+			return nil
+		case ctx.Err() != nil:
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// pending filters the listing down to the files to run, oldest first
+func (t *remoteFileTrigger) pending(files []remotefs.File) []remotefs.File {
+	now := t.clock.Now()
+	listed := make(map[string]bool, len(files))
+	var out []remotefs.File
+	for _, f := range files {
+		if f.IsDir || strings.HasPrefix(f.Name, ".") {
+			continue
+		}
+		if t.cfg.Pattern != "" {
+			if ok, _ := path.Match(t.cfg.Pattern, f.Name); !ok {
+				continue
+			}
+		}
+		listed[f.Path] = true
+		if t.minAge > 0 && now.Sub(f.ModTime) < t.minAge {
+			continue
+		}
+		if t.cfg.After == AfterNone && t.seen[f.Path] == fileVersion(f) {
+			continue
+		}
+		out = append(out, f)
+	}
+	// Forget files that are gone, so that one uploaded again is run
+	for p := range t.seen {
+		if !listed[p] {
+			delete(t.seen, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].ModTime.Equal(out[j].ModTime) {
+			return out[i].ModTime.Before(out[j].ModTime)
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > t.cfg.MaxFiles {
+		out = out[:t.cfg.MaxFiles]
+	}
+	return out
+}
+
+// run streams f into the flow, then disposes of it. A failed flow is only
+// an error of the poll when the file cannot be moved aside.
+func (t *remoteFileTrigger) run(ctx context.Context, fs remotefs.FS, f remotefs.File, h Handler) error {
+	r, err := fs.Open(ctx, f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.Path, err)
+	}
+	msg := engine.NewStreamMessage(r, t.cfg.ContentType, f.Size)
+	msg.SetHeader(HeaderFileName, f.Name)
+	msg.SetHeader(HeaderFilePath, f.Path)
+	msg.SetHeader(HeaderFileSize, strconv.FormatInt(f.Size, 10))
+	_, err = h(ctx, msg)
+	// The connection serves one call at a time, so close what the flow left
+	msg.Release()
+	if errors.Is(err, ErrNotClaimed) || errors.Is(err, dispatch.ErrQueueFull) || ctx.Err() != nil {
+		return err
+	}
+
+	if err != nil {
+		t.mu.Lock()
+		t.failed++
+		t.mu.Unlock()
+		if t.cfg.FailedTo == "" {
+			return nil
+		}
+		if err := t.move(ctx, fs, f, t.cfg.FailedTo); err != nil {
+			return fmt.Errorf("failed to move failed file %s: %w", f.Path, err)
+		}
+		return nil
+	}
+
+	t.mu.Lock()
+	t.processed++
+	t.mu.Unlock()
+	switch t.cfg.After {
+	case AfterDelete:
+		if err := fs.Remove(ctx, f.Path); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", f.Path, err)
+		}
+	case AfterMove:
+		if err := t.move(ctx, fs, f, t.cfg.MoveTo); err != nil {
+			return fmt.Errorf("failed to move %s: %w", f.Path, err)
+		}
+	case AfterNone:
+		t.seen[f.Path] = fileVersion(f)
+	}
+	return nil
+}
+
+// move renames f into dir, relative to the polled directory unless absolute
+func (t *remoteFileTrigger) move(ctx context.Context, fs remotefs.FS, f remotefs.File, dir string) error {
+	if !path.IsAbs(dir) {
+		dir = path.Join(t.cfg.Directory, dir)
+	}
+	if err := fs.MkdirAll(ctx, dir); err != nil {
+		return err
+	}
+	return fs.Rename(ctx, f.Path, path.Join(dir, f.Name))
+}
+
+// Health implements HealthReporter
+func (t *remoteFileTrigger) Health() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastErr != nil {
+		return Health{Status: HealthDegraded, Detail: t.lastErr.Error()}
+	}
+	if t.lastPoll.IsZero() {
+		return Health{Status: HealthOK, Detail: "not polled yet"}
+	}
+	return Health{Status: HealthOK, Detail: fmt.Sprintf("last polled %s at %s, %d file(s) processed, %d failed", t.cfg.Directory, t.lastPoll.UTC().Format(time.RFC3339), t.processed, t.failed)}
+}
+
+// fileVersion identifies the content of f for files left in place
+func fileVersion(f remotefs.File) string {
+	return fmt.Sprintf("%d/%d", f.Size, f.ModTime.UnixNano())
+}
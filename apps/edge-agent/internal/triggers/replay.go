@@ -0,0 +1,75 @@
+package triggers
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNonces bounds the nonces a replay guard remembers; past it the oldest
+// are forgotten early rather than growing without limit under a flood
+const maxNonces = 100000
+
+// replayGuard remembers the nonces of webhook requests for a window, so
+// that a request delivered again within it is refused
+type replayGuard struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// queue holds the nonces of seen in the order they expire, as the
+	// window is the same for all
+	queue []seenNonce
+}
+
+// seenNonce is a remembered nonce and when it is forgotten
+type seenNonce struct {
+	nonce   string
+	expires time.Time
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{window: window, seen: make(map[string]time.Time)}
+}
+
+// claim records nonce at now, reporting false when it was already seen
+// within the window
+func (g *replayGuard) claim(nonce string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.expire(now)
+	if _, ok := g.seen[nonce]; ok {
+		return false
+	}
+	expires := now.Add(g.window)
+	g.seen[nonce] = expires
+	g.queue = append(g.queue, seenNonce{nonce: nonce, expires: expires})
+	return true
+}
+
+// release forgets nonce, so that a delivery the flow failed to handle can
+// be retried by its sender
+func (g *replayGuard) release(nonce string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Its queue entry is skipped when it expires, as seen no longer
+	// matches it
+	delete(g.seen, nonce)
+}
+
+// expire forgets the nonces whose window has passed at now, and the
+// oldest beyond maxNonces; the caller holds mu
+func (g *replayGuard) expire(now time.Time) {
+	i := 0
+	for ; i < len(g.queue); i++ {
+		n := g.queue[i]
+		if now.Before(n.expires) && len(g.queue)-i < maxNonces {
+			break
+		}
+		if g.seen[n.nonce].Equal(n.expires) {
+			delete(g.seen, n.nonce)
+		}
+	}
+	// Appending reallocates the queue in time, dropping the expired head
+	g.queue = g.queue[i:]
+}
@@ -0,0 +1,181 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// HeaderFiredAt carries the scheduled time on interval and cron messages
+const HeaderFiredAt = "trigger-fired-at"
+
+func init() {
+	Register("interval", newInterval)
+	Register("cron", newCron)
+}
+
+// intervalConfig configures the interval trigger. Interval is a Go duration
+// such as "30s"; a bare number is taken as seconds.
+type intervalConfig struct {
+	Interval   interface{} `json:"interval"`
+	RunOnStart bool        `json:"runOnStart"`
+}
+
+// cronConfig configures the cron trigger
+type cronConfig struct {
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone"`
+}
+
+func newInterval(config map[string]interface{}) (Trigger, error) {
+	var cfg intervalConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	every, err := parseInterval(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	next := func(t time.Time) time.Time { return t.Add(every) }
+	return &scheduleTrigger{next: next, runOnStart: cfg.RunOnStart, clock: clock.Real}, nil
+}
+
+func newCron(config map[string]interface{}) (Trigger, error) {
+	var cfg cronConfig
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Schedule == "" {
+		return nil, errors.New("cron trigger requires a schedule")
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", cfg.Timezone)
+		}
+	}
+	schedule, err := parseCron(cfg.Schedule, loc)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron schedule %q never fires", cfg.Schedule)
+	}
+	return &scheduleTrigger{next: schedule.next, clock: clock.Real}, nil
+}
+
+func parseInterval(v interface{}) (time.Duration, error) {
+	var d time.Duration
+	switch t := v.(type) {
+	case nil:
+		return 0, errors.New("interval trigger requires an interval")
+	case float64:
+		d = time.Duration(t * float64(time.Second))
+	case string:
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			d = time.Duration(n * float64(time.Second))
+		} else if d, err = time.ParseDuration(t); err != nil {
+			return 0, fmt.Errorf("invalid interval %q", t)
+		}
+	default:
+		return 0, fmt.Errorf("invalid interval %v", v)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("interval must be at least 1s, got %s", d)
+	}
+	return d, nil
+}
+
+// scheduleTrigger starts an execution at each time returned by next. A fire
+// that comes due while the previous execution runs is skipped.
+type scheduleTrigger struct {
+	next       func(time.Time) time.Time
+	runOnStart bool
+	clock      clock.Clock
+
+	paused atomic.Bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// SetClock implements Clocked
+func (t *scheduleTrigger) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+func (t *scheduleTrigger) Start(ctx context.Context, h Handler) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		if t.runOnStart {
+			t.fire(ctx, h, t.clock.Now())
+		}
+		for {
+			now := t.clock.Now()
+			at := t.next(now)
+			if at.IsZero() {
+				return
+			}
+			timer := t.clock.NewTimer(at.Sub(now))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+				t.fire(ctx, h, at)
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *scheduleTrigger) fire(ctx context.Context, h Handler, at time.Time) {
+	if t.paused.Load() {
+		return
+	}
+	msg, err := engine.JSONMessage(map[string]interface{}{"firedAt": at.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return
+	}
+	msg.SetHeader(HeaderFiredAt, at.UTC().Format(time.RFC3339))
+	// Failures are logged by the handler; the schedule carries on
+	h(ctx, msg)
+}
+
+// Pause implements Pauser; fires are skipped rather than queued
+func (t *scheduleTrigger) Pause(ctx context.Context) error {
+	t.paused.Store(true)
+	return nil
+}
+
+// Resume implements Pauser
+func (t *scheduleTrigger) Resume(ctx context.Context) error {
+	t.paused.Store(false)
+	return nil
+}
+
+func (t *scheduleTrigger) Stop(ctx context.Context) error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,295 @@
+package triggers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/secrets"
+)
+
+// Signature schemes of webhook triggers
+const (
+	// SignatureGitHub is an X-Hub-Signature-256 header of "sha256=" and the
+	// hex HMAC-SHA256 of the body
+	SignatureGitHub = "github"
+	// SignatureStripe is a Stripe-Signature header of "t=<unix time>" and
+	// one or more "v1=" hex HMAC-SHA256 of "<time>.<body>"
+	SignatureStripe = "stripe"
+	// SignatureHex is a header holding the bare hex HMAC-SHA256 of the body
+	SignatureHex = "hex"
+	// SignatureHMAC is a header holding the hex HMAC-SHA256, optionally
+	// prefixed "sha256=", of "<time>.<body>", with the unix time in a
+	// timestamp header, X-Signature and X-Timestamp by default
+	SignatureHMAC = "hmac"
+	// SignatureTwilio is an X-Twilio-Signature header of the base64
+	// HMAC-SHA1 of the request URL followed by the sorted names and values
+	// of its form parameters
+	SignatureTwilio = "twilio"
+	// SignatureBasic is HTTP basic authentication with username and the
+	// password held by the secret
+	SignatureBasic = "basic"
+)
+
+// errSignature is returned for requests whose signature does not verify
+var errSignature = errors.New("invalid webhook signature")
+
+// signatureVerifier checks the signature or credentials of webhook
+// requests with the key held by a secret, read on every request so rotated
+// keys apply at once
+type signatureVerifier struct {
+	scheme          string
+	header          string
+	timestampHeader string
+	username        string
+	url             string
+	secret          string
+	tolerance       time.Duration
+}
+
+func newSignatureVerifier(cfg webhookConfig) (*signatureVerifier, error) {
+	if err := secrets.Validate(cfg.Secret); err != nil {
+		return nil, err
+	}
+	v := &signatureVerifier{scheme: cfg.Signature, header: cfg.SignatureHeader, secret: cfg.Secret}
+	if v.scheme == "" {
+		v.scheme = SignatureGitHub
+	}
+	switch v.scheme {
+	case SignatureGitHub:
+		if v.header == "" {
+			v.header = "X-Hub-Signature-256"
+		}
+	case SignatureStripe, SignatureHMAC:
+		if v.scheme == SignatureStripe {
+			if v.header == "" {
+				v.header = "Stripe-Signature"
+			}
+		} else {
+			if v.header == "" {
+				v.header = "X-Signature"
+			}
+			v.timestampHeader = cfg.TimestampHeader
+			if v.timestampHeader == "" {
+				v.timestampHeader = "X-Timestamp"
+			}
+		}
+		if cfg.ToleranceSeconds < 0 {
+			return nil, errors.New("toleranceSeconds must not be negative")
+		}
+		v.tolerance = time.Duration(cfg.ToleranceSeconds) * time.Second
+		if v.tolerance == 0 {
+			v.tolerance = 5 * time.Minute
+		}
+	case SignatureHex:
+		if v.header == "" {
+			return nil, errors.New("signatureHeader is required for hex signatures")
+		}
+	case SignatureTwilio:
+		if v.header == "" {
+			v.header = "X-Twilio-Signature"
+		}
+		if cfg.URL != "" {
+			u, err := url.Parse(cfg.URL)
+			// The query is taken from each request, as Twilio adds to it
+			if err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+				return nil, fmt.Errorf("invalid url %q: must be the absolute URL Twilio requests, without a query", cfg.URL)
+			}
+			v.url = strings.TrimSuffix(cfg.URL, "?")
+		}
+	case SignatureBasic:
+		if cfg.Username == "" {
+			return nil, errors.New("username is required for basic authentication")
+		}
+		v.header = "Authorization"
+		v.username = cfg.Username
+	default:
+		return nil, fmt.Errorf("unsupported signature scheme %q", v.scheme)
+	}
+	return v, nil
+}
+
+// challenge returns the WWW-Authenticate header of requests failing
+// verification, empty for signature schemes
+func (v *signatureVerifier) challenge(path string) string {
+	if v.scheme != SignatureBasic {
+		return ""
+	}
+	return `Basic realm="` + WebhookPrefix + path + `"`
+}
+
+// verify checks the signature or credentials of r against body
+func (v *signatureVerifier) verify(r *http.Request, body []byte, now time.Time) error {
+	value := strings.TrimSpace(r.Header.Get(v.header))
+	if value == "" {
+		return fmt.Errorf("%w: missing %s header", errSignature, v.header)
+	}
+	key, err := secrets.Resolve(v.secret)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook secret: %w", err)
+	}
+	// Secret files often end with a newline the sender's key does not have
+	key = bytes.TrimSpace(key)
+
+	switch v.scheme {
+	case SignatureGitHub:
+		sig, ok := strings.CutPrefix(value, "sha256=")
+		if !ok || !validMAC(key, body, sig) {
+			return errSignature
+		}
+	case SignatureHMAC:
+		timestamp := strings.TrimSpace(r.Header.Get(v.timestampHeader))
+		if err := v.checkTimestamp(timestamp, now); err != nil {
+			return err
+		}
+		sig := strings.TrimPrefix(value, "sha256=")
+		if !validMAC(key, append([]byte(timestamp+"."), body...), sig) {
+			return errSignature
+		}
+	case SignatureStripe:
+		var timestamp string
+		var sigs []string
+		for _, part := range strings.Split(value, ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				timestamp = val
+			case "v1":
+				sigs = append(sigs, val)
+			}
+		}
+		if err := v.checkTimestamp(timestamp, now); err != nil {
+			return err
+		}
+		signed := append([]byte(timestamp+"."), body...)
+		for _, sig := range sigs {
+			if validMAC(key, signed, sig) {
+				return nil
+			}
+		}
+		return errSignature
+	case SignatureHex:
+		if !validMAC(key, body, value) {
+			return errSignature
+		}
+	case SignatureTwilio:
+		if !v.validTwilio(r, body, key, value) {
+			return errSignature
+		}
+	case SignatureBasic:
+		username, password, ok := r.BasicAuth()
+		// Compare both, so that the time taken does not tell which is wrong
+		userOK := subtle.ConstantTimeCompare([]byte(username), []byte(v.username))
+		passOK := subtle.ConstantTimeCompare([]byte(password), key)
+		if !ok || userOK&passOK != 1 {
+			return fmt.Errorf("%w: invalid credentials", errSignature)
+		}
+	}
+	return nil
+}
+
+// checkTimestamp checks that the unix time a request was signed at is
+// within the tolerance of now, so that captured requests cannot be
+// replayed later
+func (v *signatureVerifier) checkTimestamp(timestamp string, now time.Time) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", errSignature)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > v.tolerance || age < -v.tolerance {
+		return fmt.Errorf("%w: timestamp outside the tolerance", errSignature)
+	}
+	return nil
+}
+
+// validTwilio reports whether sig is Twilio's signature of r. Form posts
+// sign their parameters; JSON posts sign the URL alone, which carries the
+// hex SHA-256 of the body in its bodySHA256 parameter.
+func (v *signatureVerifier) validTwilio(r *http.Request, body, key []byte, sig string) bool {
+	signed := v.requestURL(r)
+	if want := r.URL.Query().Get("bodySHA256"); want != "" {
+		sum := sha256.Sum256(body)
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(want)), []byte(hex.EncodeToString(sum[:]))) != 1 {
+			return false
+		}
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return false
+		}
+		names := make([]string, 0, len(form))
+		for name := range form {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString(signed)
+		for _, name := range names {
+			for _, value := range form[name] {
+				b.WriteString(name)
+				b.WriteString(value)
+			}
+		}
+		signed = b.String()
+	}
+	got, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, macSum(sha1.New, key, []byte(signed)))
+}
+
+// requestURL returns the URL the sender requested r at: the configured
+// url, or the one rebuilt from the request and the X-Forwarded headers of
+// proxies, followed by the query
+func (v *signatureVerifier) requestURL(r *http.Request) string {
+	base := v.url
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+		}
+		host := r.Host
+		if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+			host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		base = scheme + "://" + host + r.URL.EscapedPath()
+	}
+	if r.URL.RawQuery != "" {
+		return base + "?" + r.URL.RawQuery
+	}
+	return base
+}
+
+// validMAC reports whether sig is the hex HMAC-SHA256 of data under key,
+// in constant time
+func validMAC(key, data []byte, sig string) bool {
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, macSum(sha256.New, key, data))
+}
+
+// macSum returns the HMAC of data under key with the hash h
+func macSum(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,294 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/snmp"
+)
+
+func init() {
+	Register("snmp", newSNMPPoll)
+	Register("snmp-trap", newSNMPTrap)
+}
+
+// Headers set by the snmp triggers
+const (
+	HeaderSNMPTarget   = "snmp-target"
+	HeaderSNMPTrapOID  = "snmp-trap-oid"
+	HeaderSNMPTrapName = "snmp-trap-name"
+)
+
+// snmpPollConfig configures the snmp trigger. Get and Walk list objects by
+// name or OID; each poll reads all of them into one message.
+type snmpPollConfig struct {
+	snmp.Config
+	Get      []string    `json:"get"`
+	Walk     []string    `json:"walk"`
+	Interval interface{} `json:"interval"`
+}
+
+// snmpPollTrigger reads objects of an SNMP agent on an interval and runs
+// the flow with their values. A poll that fails is skipped and reported
+// through Health.
+type snmpPollTrigger struct {
+	cfg      snmpPollConfig
+	interval time.Duration
+	clock    clock.Clock
+
+	paused atomic.Bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	lastPoll time.Time
+	lastErr  error
+}
+
+func newSNMPPoll(config map[string]interface{}) (Trigger, error) {
+	cfg := snmpPollConfig{Interval: "60s"}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Target == "" {
+		return nil, errors.New("snmp trigger requires a target")
+	}
+	if len(cfg.Get) == 0 && len(cfg.Walk) == 0 {
+		return nil, errors.New("snmp trigger requires objects to get or walk")
+	}
+	if err := cfg.Config.Validate(); err != nil {
+		return nil, err
+	}
+	mib, err := snmp.LoadMIB(cfg.MIBs)
+	if err != nil {
+		return nil, err
+	}
+	for _, oid := range append(append([]string(nil), cfg.Get...), cfg.Walk...) {
+		if _, err := mib.Resolve(oid); err != nil {
+			return nil, err
+		}
+	}
+	interval, err := parseInterval(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	return &snmpPollTrigger{cfg: cfg, interval: interval, clock: clock.Real}, nil
+}
+
+// SetClock implements Clocked, which also has polls claimed by a single
+// replica of a cluster
+func (t *snmpPollTrigger) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+func (t *snmpPollTrigger) Start(ctx context.Context, h Handler) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			timer := t.clock.NewTimer(t.interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+			if t.paused.Load() {
+				continue
+			}
+			err := t.poll(ctx, h)
+			t.mu.Lock()
+			t.lastPoll, t.lastErr = t.clock.Now(), err
+			t.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (t *snmpPollTrigger) poll(ctx context.Context, h Handler) error {
+	client, err := snmp.NewClient(ctx, t.cfg.Config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	vars := []snmp.Variable{}
+	if len(t.cfg.Get) > 0 {
+		got, err := client.Get(t.cfg.Get)
+		if err != nil {
+			return err
+		}
+		vars = append(vars, got...)
+	}
+	for _, oid := range t.cfg.Walk {
+		subtree, err := client.Walk(oid)
+		if err != nil {
+			return err
+		}
+		vars = append(vars, subtree...)
+	}
+	values := make(map[string]interface{}, len(vars))
+	for _, v := range vars {
+		values[v.Name] = v.Value
+	}
+	msg, err := engine.JSONMessage(map[string]interface{}{
+		"target":    t.cfg.Target,
+		"polledAt":  t.clock.Now().UTC().Format(time.RFC3339),
+		"variables": vars,
+		"values":    values,
+	})
+	if err != nil {
+		return err
+	}
+	msg.SetHeader(HeaderSNMPTarget, t.cfg.Target)
+	// Failures are logged by the handler; polling carries on
+	h(ctx, msg)
+	return nil
+}
+
+func (t *snmpPollTrigger) Stop(ctx context.Context) error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause implements Pauser; polls are skipped until Resume
+func (t *snmpPollTrigger) Pause(ctx context.Context) error {
+	t.paused.Store(true)
+	return nil
+}
+
+// Resume implements Pauser
+func (t *snmpPollTrigger) Resume(ctx context.Context) error {
+	t.paused.Store(false)
+	return nil
+}
+
+// Health implements HealthReporter
+func (t *snmpPollTrigger) Health() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastErr != nil {
+		return Health{Status: HealthDegraded, Detail: t.lastErr.Error()}
+	}
+	if t.lastPoll.IsZero() {
+		return Health{Status: HealthOK, Detail: "not polled yet"}
+	}
+	return Health{Status: HealthOK, Detail: fmt.Sprintf("last polled %s at %s", t.cfg.Target, t.lastPoll.UTC().Format(time.RFC3339))}
+}
+
+// snmpTrapConfig configures the snmp-trap trigger
+type snmpTrapConfig struct {
+	snmp.Config
+	Address string `json:"address"`
+	// MaxInFlight bounds the traps being run at once; more are dropped,
+	// since agents do not wait for traps to be handled
+	MaxInFlight int `json:"maxInFlight"`
+}
+
+// snmpTrapTrigger runs the flow with each SNMP trap or inform received,
+// decoded into a JSON object with its variables named after the MIB
+type snmpTrapTrigger struct {
+	cfg      snmpTrapConfig
+	receiver *snmp.TrapReceiver
+
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	inFlight chan struct{}
+
+	received, dropped atomic.Int64
+}
+
+func newSNMPTrap(config map[string]interface{}) (Trigger, error) {
+	cfg := snmpTrapConfig{Address: ":162", MaxInFlight: 16}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxInFlight <= 0 {
+		return nil, errors.New("maxInFlight must be positive")
+	}
+	receiver, err := snmp.NewTrapReceiver(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &snmpTrapTrigger{cfg: cfg, receiver: receiver, inFlight: make(chan struct{}, cfg.MaxInFlight)}, nil
+}
+
+func (t *snmpTrapTrigger) Start(ctx context.Context, h Handler) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	return t.receiver.Listen(t.cfg.Address, func(trap snmp.Trap) {
+		t.received.Add(1)
+		select {
+		case t.inFlight <- struct{}{}:
+		default:
+			t.dropped.Add(1)
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			defer func() { <-t.inFlight }()
+			t.handle(ctx, trap, h)
+		}()
+	})
+}
+
+func (t *snmpTrapTrigger) handle(ctx context.Context, trap snmp.Trap, h Handler) {
+	body, err := json.Marshal(trap)
+	if err != nil {
+		return
+	}
+	msg := engine.NewMessage(body, "application/json")
+	msg.SetHeader(HeaderRemoteAddr, trap.Source)
+	msg.SetHeader(HeaderSNMPTrapOID, trap.TrapOID)
+	msg.SetHeader(HeaderSNMPTrapName, trap.TrapName)
+	h(ctx, msg)
+}
+
+func (t *snmpTrapTrigger) Stop(ctx context.Context) error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	t.receiver.Close()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Health implements HealthReporter
+func (t *snmpTrapTrigger) Health() Health {
+	if t.cancel == nil {
+		return Health{Status: HealthDown, Detail: "not listening"}
+	}
+	return Health{Status: HealthOK, Detail: fmt.Sprintf("listening on udp %s; %d received, %d dropped by the in-flight limit, %d with the wrong community", t.cfg.Address, t.received.Load(), t.dropped.Load(), t.receiver.Rejected.Load())}
+}
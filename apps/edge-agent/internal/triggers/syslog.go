@@ -0,0 +1,286 @@
+package triggers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/formats/syslog"
+	"github.com/fusionflow/edge-agent/internal/throttle"
+)
+
+func init() {
+	Register("syslog", newSyslog)
+}
+
+// Headers set by the syslog trigger
+const (
+	HeaderSyslogFacility = "syslog-facility"
+	HeaderSyslogSeverity = "syslog-severity"
+)
+
+// syslogConfig configures the syslog trigger
+type syslogConfig struct {
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+	// Allow lists the IP addresses and CIDR ranges accepted; other senders
+	// are ignored. Empty accepts everyone.
+	Allow []string `json:"allow"`
+	// RateLimit caps the messages accepted per second, with bursts of up to
+	// Burst; messages over it are dropped
+	RateLimit int64 `json:"rateLimit"`
+	Burst     int64 `json:"burst"`
+	// MaxInFlight bounds the UDP messages being run at once; more are
+	// dropped, since UDP senders cannot be slowed down
+	MaxInFlight     int `json:"maxInFlight"`
+	MaxMessageBytes int `json:"maxMessageBytes"`
+	// IdleTimeout closes TCP connections with no traffic, in seconds
+	IdleTimeout int `json:"idleTimeout"`
+}
+
+// syslogTrigger receives syslog messages over UDP, or TCP with octet
+// counting or newline framing (RFC 6587), and runs the flow with each one
+// parsed into a JSON object
+type syslogTrigger struct {
+	cfg     syslogConfig
+	allow   []*net.IPNet
+	limiter *throttle.Limiter
+
+	conn     net.PacketConn
+	listener *tcpListener
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	inFlight chan struct{}
+
+	received, filtered, limited, invalid atomic.Int64
+}
+
+func newSyslog(config map[string]interface{}) (Trigger, error) {
+	cfg := syslogConfig{Protocol: "udp", MaxInFlight: 16, MaxMessageBytes: 64 << 10, IdleTimeout: 300}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Address == "" {
+		return nil, errors.New("syslog trigger requires an address")
+	}
+	if cfg.Protocol != "udp" && cfg.Protocol != "tcp" {
+		return nil, fmt.Errorf("invalid protocol %q: must be udp or tcp", cfg.Protocol)
+	}
+	if cfg.MaxInFlight <= 0 || cfg.MaxMessageBytes <= 0 {
+		return nil, errors.New("maxInFlight and maxMessageBytes must be positive")
+	}
+	if cfg.RateLimit < 0 {
+		return nil, errors.New("rateLimit must not be negative")
+	}
+	t := &syslogTrigger{cfg: cfg, limiter: throttle.NewLimiter(cfg.RateLimit, cfg.Burst)}
+	for _, entry := range cfg.Allow {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow entry %q", entry)
+		}
+		t.allow = append(t.allow, network)
+	}
+	return t, nil
+}
+
+func (t *syslogTrigger) Start(ctx context.Context, h Handler) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	if t.cfg.Protocol == "tcp" {
+		t.listener = newTCPListener(t.cfg.Address, syslogFramer{}, t.cfg.MaxMessageBytes, t.cfg.IdleTimeout)
+		t.listener.allow = func(addr net.Addr) bool {
+			if !t.allowed(addr) {
+				t.filtered.Add(1)
+				return false
+			}
+			return true
+		}
+		return t.listener.start(ctx, func(ctx context.Context, conn net.Conn, frame []byte) ([]byte, error) {
+			t.received.Add(1)
+			if !t.limiter.AllowN(1) {
+				t.limited.Add(1)
+				return nil, nil
+			}
+			t.handle(ctx, frame, conn.RemoteAddr(), h)
+			return nil, nil
+		})
+	}
+
+	conn, err := net.ListenPacket("udp", t.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", t.cfg.Address, err)
+	}
+	t.conn = conn
+	t.inFlight = make(chan struct{}, t.cfg.MaxInFlight)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		buf := make([]byte, t.cfg.MaxMessageBytes)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			t.received.Add(1)
+			if !t.allowed(addr) {
+				t.filtered.Add(1)
+				continue
+			}
+			if !t.limiter.AllowN(1) {
+				t.limited.Add(1)
+				continue
+			}
+			select {
+			case t.inFlight <- struct{}{}:
+			default:
+				t.limited.Add(1)
+				continue
+			}
+			data := append([]byte(nil), buf[:n]...)
+			t.wg.Add(1)
+			go func() {
+				defer t.wg.Done()
+				defer func() { <-t.inFlight }()
+				t.handle(ctx, data, addr, h)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (t *syslogTrigger) Stop(ctx context.Context) error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	if t.listener != nil {
+		return t.listener.stop(ctx)
+	}
+	err := t.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// allowed reports whether addr may send messages
+func (t *syslogTrigger) allowed(addr net.Addr) bool {
+	if len(t.allow) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	}
+	for _, network := range t.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle parses one message and runs the flow with it. Messages that are
+// not syslog are dropped, as there is no one to tell.
+func (t *syslogTrigger) handle(ctx context.Context, data []byte, from net.Addr, h Handler) {
+	parsed, err := syslog.Parse(data, time.Now())
+	if err != nil {
+		t.invalid.Add(1)
+		return
+	}
+	body, err := json.Marshal(parsed)
+	if err != nil {
+		t.invalid.Add(1)
+		return
+	}
+	msg := engine.NewMessage(body, "application/json")
+	msg.SetHeader(HeaderRemoteAddr, from.String())
+	msg.SetHeader(HeaderSyslogFacility, parsed.FacilityName)
+	msg.SetHeader(HeaderSyslogSeverity, parsed.SeverityName)
+	h(ctx, msg)
+}
+
+// Health implements HealthReporter
+func (t *syslogTrigger) Health() Health {
+	if t.listener == nil && t.conn == nil {
+		return Health{Status: HealthDown, Detail: "not listening"}
+	}
+	detail := fmt.Sprintf("%d received, %d filtered, %d dropped by the rate or in-flight limits, %d invalid", t.received.Load(), t.filtered.Load(), t.limited.Load(), t.invalid.Load())
+	if t.listener != nil {
+		detail = t.listener.health().Detail + "; " + detail
+	} else {
+		detail = fmt.Sprintf("listening on udp %s; %s", t.conn.LocalAddr(), detail)
+	}
+	return Health{Status: HealthOK, Detail: detail}
+}
+
+// syslogFramer reads syslog over TCP as RFC 6587 describes: a frame
+// starting with a digit is octet counted ("LEN SP MSG"), others end with LF
+type syslogFramer struct{}
+
+func (syslogFramer) read(r *bufio.Reader, maxSize int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		return newlineFramer{}.read(r, maxSize)
+	}
+	var prefix []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' {
+			break
+		}
+		if b < '0' || b > '9' || len(prefix) >= 10 {
+			return nil, errors.New("invalid octet count")
+		}
+		prefix = append(prefix, b)
+	}
+	n, err := strconv.Atoi(string(prefix))
+	if err != nil || n <= 0 || n > maxSize {
+		return nil, fmt.Errorf("invalid octet count %s", prefix)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (syslogFramer) write(w io.Writer, payload []byte) error {
+	return errors.New("syslog connections are receive-only")
+}
@@ -0,0 +1,335 @@
+package triggers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/formats/hl7"
+)
+
+func init() {
+	Register("tcp", newTCP)
+}
+
+// HeaderRemoteAddr is the address of the peer that sent a TCP message
+const HeaderRemoteAddr = "remote-addr"
+
+// Framings of the tcp trigger
+const (
+	// FramingNewline ends each message with LF, dropping a CR before it
+	FramingNewline = "newline"
+	// FramingLength prefixes each message with its length as a big-endian
+	// unsigned integer of lengthBytes bytes
+	FramingLength = "length"
+	// FramingMLLP wraps each message in MLLP start and end blocks
+	FramingMLLP = "mllp"
+)
+
+// Reply modes of the tcp trigger
+const (
+	// ReplyFlow sends the flow's output back as a framed message, or the
+	// error when the flow fails
+	ReplyFlow = "flow"
+	// ReplyNone receives messages without replying
+	ReplyNone = "none"
+)
+
+// framer reads and writes the messages of a TCP stream
+type framer interface {
+	read(r *bufio.Reader, maxSize int) ([]byte, error)
+	write(w io.Writer, payload []byte) error
+}
+
+// tcpConfig configures the tcp trigger. Address is host:port; Port alone
+// listens on all interfaces, as in imported Node-RED flows.
+type tcpConfig struct {
+	Address         string      `json:"address"`
+	Port            interface{} `json:"port"`
+	Framing         string      `json:"framing"`
+	LengthBytes     int         `json:"lengthBytes"`
+	Reply           string      `json:"reply"`
+	ContentType     string      `json:"contentType"`
+	MaxMessageBytes int         `json:"maxMessageBytes"`
+	// IdleTimeout closes connections with no traffic, in seconds
+	IdleTimeout int `json:"idleTimeout"`
+}
+
+// tcpTrigger is a TCP listener for devices that send framed messages over a
+// raw socket. Messages of a connection are run in order, each reply written
+// before the next message is read.
+type tcpTrigger struct {
+	cfg      tcpConfig
+	listener *tcpListener
+}
+
+func newTCP(config map[string]interface{}) (Trigger, error) {
+	cfg := tcpConfig{Framing: FramingNewline, LengthBytes: 4, Reply: ReplyFlow, ContentType: "text/plain", MaxMessageBytes: 1 << 20, IdleTimeout: 300}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Address == "" && cfg.Port != nil {
+		port, err := strconv.Atoi(fmt.Sprint(cfg.Port))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %v", cfg.Port)
+		}
+		cfg.Address = ":" + strconv.Itoa(port)
+	}
+	if cfg.Address == "" {
+		return nil, errors.New("tcp trigger requires an address")
+	}
+	var f framer
+	switch cfg.Framing {
+	case FramingNewline:
+		f = newlineFramer{}
+	case FramingLength:
+		if cfg.LengthBytes != 1 && cfg.LengthBytes != 2 && cfg.LengthBytes != 4 {
+			return nil, fmt.Errorf("invalid lengthBytes %d: must be 1, 2 or 4", cfg.LengthBytes)
+		}
+		f = lengthFramer{size: cfg.LengthBytes}
+	case FramingMLLP:
+		f = mllpFramer{}
+	default:
+		return nil, fmt.Errorf("invalid framing %q: must be %s, %s or %s", cfg.Framing, FramingNewline, FramingLength, FramingMLLP)
+	}
+	if cfg.Reply != ReplyFlow && cfg.Reply != ReplyNone {
+		return nil, fmt.Errorf("invalid reply mode %q: must be %s or %s", cfg.Reply, ReplyFlow, ReplyNone)
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		return nil, errors.New("maxMessageBytes must be positive")
+	}
+	return &tcpTrigger{cfg: cfg, listener: newTCPListener(cfg.Address, f, cfg.MaxMessageBytes, cfg.IdleTimeout)}, nil
+}
+
+func (t *tcpTrigger) Start(ctx context.Context, h Handler) error {
+	return t.listener.start(ctx, func(ctx context.Context, conn net.Conn, frame []byte) ([]byte, error) {
+		msg := engine.NewMessage(frame, t.cfg.ContentType)
+		msg.SetHeader(HeaderRemoteAddr, conn.RemoteAddr().String())
+		out, err := h(ctx, msg)
+		switch {
+		case t.cfg.Reply == ReplyNone:
+			return nil, nil
+		case err != nil:
+			return []byte("error: " + err.Error()), nil
+		case out == nil:
+			return nil, nil
+		}
+		return out.Body, nil
+	})
+}
+
+func (t *tcpTrigger) Stop(ctx context.Context) error {
+	return t.listener.stop(ctx)
+}
+
+// Health implements HealthReporter
+func (t *tcpTrigger) Health() Health {
+	return t.listener.health()
+}
+
+// frameHandler runs the flow for a frame received on conn and returns the
+// reply to frame back, nil for none
+type frameHandler func(ctx context.Context, conn net.Conn, frame []byte) ([]byte, error)
+
+// tcpListener accepts connections and passes the frames read from each to
+// a frameHandler. It is shared by the TCP-based triggers.
+type tcpListener struct {
+	address         string
+	framer          framer
+	maxMessageBytes int
+	// idleTimeout closes connections with no traffic, in seconds
+	idleTimeout int
+	// allow, when set, refuses connections from the peers it rejects
+	allow func(addr net.Addr) bool
+
+	listener net.Listener
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newTCPListener(address string, f framer, maxMessageBytes, idleTimeout int) *tcpListener {
+	return &tcpListener{address: address, framer: f, maxMessageBytes: maxMessageBytes, idleTimeout: idleTimeout, conns: make(map[net.Conn]struct{})}
+}
+
+func (l *tcpListener) start(ctx context.Context, handle frameHandler) error {
+	ln, err := net.Listen("tcp", l.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", l.address, err)
+	}
+	l.listener = ln
+	ctx, l.cancel = context.WithCancel(ctx)
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if l.allow != nil && !l.allow(conn.RemoteAddr()) {
+				conn.Close()
+				continue
+			}
+			l.track(conn, true)
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				defer l.track(conn, false)
+				l.serve(ctx, conn, handle)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (l *tcpListener) stop(ctx context.Context) error {
+	if l.listener == nil {
+		return nil
+	}
+	l.cancel()
+	err := l.listener.Close()
+
+	l.mu.Lock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+func (l *tcpListener) track(conn net.Conn, add bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if add {
+		l.conns[conn] = struct{}{}
+	} else {
+		delete(l.conns, conn)
+		conn.Close()
+	}
+}
+
+// serve handles the frames of one connection in order, replying to each
+func (l *tcpListener) serve(ctx context.Context, conn net.Conn, handle frameHandler) {
+	r := bufio.NewReader(conn)
+	for {
+		if l.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(l.idleTimeout) * time.Second))
+		}
+		frame, err := l.framer.read(r, l.maxMessageBytes)
+		if err != nil {
+			// Framing errors leave the stream unsynchronized, so drop the connection
+			return
+		}
+
+		reply, err := handle(ctx, conn, frame)
+		if err != nil || reply == nil {
+			continue
+		}
+		if err := l.framer.write(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (l *tcpListener) health() Health {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.listener == nil {
+		return Health{Status: HealthDown, Detail: "not listening"}
+	}
+	return Health{Status: HealthOK, Detail: fmt.Sprintf("listening on %s, %d connection(s)", l.listener.Addr(), len(l.conns))}
+}
+
+// newlineFramer frames messages as lines
+type newlineFramer struct{}
+
+func (newlineFramer) read(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxSize+2 {
+			return nil, fmt.Errorf("line exceeds %d bytes", maxSize)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+func (newlineFramer) write(w io.Writer, payload []byte) error {
+	_, err := w.Write(append(bytes.TrimRight(payload, "\r\n"), '\n'))
+	return err
+}
+
+// lengthFramer frames messages with a length prefix of size bytes
+type lengthFramer struct {
+	size int
+}
+
+func (f lengthFramer) read(r *bufio.Reader, maxSize int) ([]byte, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(r, prefix[4-f.size:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix)
+	if n > uint32(maxSize) {
+		return nil, fmt.Errorf("message of %d bytes exceeds %d", n, maxSize)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (f lengthFramer) write(w io.Writer, payload []byte) error {
+	if uint64(len(payload)) >= 1<<(8*f.size) {
+		return fmt.Errorf("reply of %d bytes does not fit a %d-byte length", len(payload), f.size)
+	}
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(len(payload)))
+	_, err := w.Write(append(prefix[4-f.size:], payload...))
+	return err
+}
+
+// mllpFramer frames messages with the minimal lower layer protocol
+type mllpFramer struct{}
+
+func (mllpFramer) read(r *bufio.Reader, maxSize int) ([]byte, error) {
+	return hl7.ReadFrame(r, maxSize)
+}
+
+func (mllpFramer) write(w io.Writer, payload []byte) error {
+	return hl7.WriteFrame(w, payload)
+}
@@ -0,0 +1,109 @@
+package triggers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/engine"
+)
+
+// Handler runs the flow for one inbound message and returns its reply, which
+// may be nil when the flow produced no output
+type Handler func(ctx context.Context, msg *engine.Message) (*engine.Message, error)
+
+// Trigger receives messages from an external source and passes them to a handler
+type Trigger interface {
+	// Start begins delivering messages to h; it must not block
+	Start(ctx context.Context, h Handler) error
+	// Stop releases the trigger's resources and waits for in-flight messages
+	Stop(ctx context.Context) error
+}
+
+// Pauser is implemented by triggers that can stop accepting messages at the
+// source while paused. Other triggers are paused by holding their messages
+// until they are resumed.
+type Pauser interface {
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+}
+
+// Clocked is implemented by time-based triggers. The manager sets the
+// agent's clock before starting them, so that they run on virtual time in
+// tests; triggers not given a clock use the system clock.
+type Clocked interface {
+	SetClock(c clock.Clock)
+}
+
+// Health statuses reported by triggers
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// Health is a trigger's view of its source
+type Health struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthReporter is implemented by triggers that can report on their source,
+// such as a listener or a broker connection. Triggers without it are
+// reported healthy while running.
+type HealthReporter interface {
+	Health() Health
+}
+
+// Factory builds a trigger from its flow configuration
+type Factory func(config map[string]interface{}) (Trigger, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a trigger type available to flows. Plugins register their
+// types from an init function and are compiled in with a blank import. It
+// panics if the type is registered twice.
+func Register(triggerType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[triggerType]; dup {
+		panic("triggers: trigger type registered twice: " + triggerType)
+	}
+	registry[triggerType] = factory
+}
+
+// Types returns the registered trigger types, sorted
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New builds a trigger of a registered type
+func New(triggerType string, config map[string]interface{}) (Trigger, error) {
+	factory, ok := lookup(triggerType)
+	if !ok {
+		return nil, fmt.Errorf("unknown trigger type %q", triggerType)
+	}
+	return factory(config)
+}
+
+func lookup(triggerType string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[triggerType]
+	return factory, ok
+}
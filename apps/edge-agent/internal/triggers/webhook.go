@@ -0,0 +1,314 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/ids"
+)
+
+// WebhookPrefix is where the agent serves webhook triggers; a trigger with
+// path "/orders" receives requests to /hooks/orders
+const WebhookPrefix = "/hooks"
+
+// Headers set on webhook messages
+const (
+	HeaderHTTPMethod = "http-method"
+	HeaderHTTPPath   = "http-path"
+	HeaderHTTPQuery  = "http-query"
+)
+
+func init() {
+	Register("webhook", newWebhook)
+}
+
+// Webhooks dispatches requests under WebhookPrefix to the started webhook
+// triggers
+var Webhooks = &webhookMux{routes: make(map[string]*webhookTrigger)}
+
+// webhookConfig configures the webhook trigger
+type webhookConfig struct {
+	Path         string `json:"path"`
+	Method       string `json:"method"`
+	MaxBodyBytes int64  `json:"maxBodyBytes"`
+	// Secret references the key requests must be signed with, or the
+	// password of basic authentication, as env:NAME or file:NAME; requests
+	// are not verified without one
+	Secret string `json:"secret"`
+	// Signature is the verification scheme: github (the default), stripe,
+	// twilio, hmac, hex or basic
+	Signature string `json:"signature"`
+	// SignatureHeader overrides the header the scheme reads its signature from
+	SignatureHeader string `json:"signatureHeader"`
+	// TimestampHeader overrides the header hmac signatures read their
+	// time from, X-Timestamp by default
+	TimestampHeader string `json:"timestampHeader"`
+	// ToleranceSeconds bounds the age of stripe and hmac signature
+	// timestamps, 300 by default
+	ToleranceSeconds int `json:"toleranceSeconds"`
+	// URL is the URL Twilio is configured to request, without its query,
+	// when proxies in front of the agent change it; it is rebuilt from the
+	// request and its X-Forwarded headers otherwise. The query is always
+	// that of the request.
+	URL string `json:"url"`
+	// Username is the user of basic authentication
+	Username string `json:"username"`
+	// ReplayWindowSeconds refuses with 409 a request whose nonce was seen
+	// in the last window seconds; 0 turns replay protection off
+	ReplayWindowSeconds int `json:"replayWindowSeconds"`
+	// NonceHeader is the header holding the unique ID of each delivery,
+	// such as X-GitHub-Delivery. Without it the signature is the nonce, so
+	// signatures without a timestamp refuse identical payloads too.
+	NonceHeader string `json:"nonceHeader"`
+}
+
+// webhookTrigger starts an execution per HTTP request and replies with the
+// flow's output, whose status and headers a flow may set with the
+// response-status and response-header.<name> headers
+type webhookTrigger struct {
+	cfg    webhookConfig
+	key    string
+	paused atomic.Bool
+	// verifier checks request signatures, nil when the trigger has no secret
+	verifier *signatureVerifier
+	// replays refuses deliveries seen before, nil when the trigger has no
+	// replay window
+	replays *replayGuard
+
+	mu      sync.Mutex
+	handler Handler
+}
+
+func newWebhook(config map[string]interface{}) (Trigger, error) {
+	cfg := webhookConfig{Method: http.MethodPost, MaxBodyBytes: 10 << 20}
+	if err := engine.DecodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("webhook trigger requires a path")
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		cfg.Path = "/" + cfg.Path
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	cfg.Method = strings.ToUpper(cfg.Method)
+	if cfg.MaxBodyBytes <= 0 {
+		return nil, errors.New("maxBodyBytes must be positive")
+	}
+	t := &webhookTrigger{cfg: cfg, key: cfg.Method + " " + cfg.Path}
+	if cfg.Secret != "" {
+		v, err := newSignatureVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		t.verifier = v
+	}
+	if cfg.ReplayWindowSeconds < 0 {
+		return nil, errors.New("replayWindowSeconds must not be negative")
+	}
+	if cfg.ReplayWindowSeconds > 0 {
+		if cfg.NonceHeader == "" && (t.verifier == nil || t.verifier.scheme == SignatureBasic) {
+			return nil, errors.New("nonceHeader is required for replay protection without a signature")
+		}
+		// A replayed request is accepted until its timestamp leaves the
+		// tolerance on either side, so the window must span both
+		if t.verifier != nil && t.verifier.tolerance > 0 && time.Duration(cfg.ReplayWindowSeconds)*time.Second < 2*t.verifier.tolerance {
+			return nil, fmt.Errorf("replayWindowSeconds must be at least twice the timestamp tolerance, %d", int(2*t.verifier.tolerance/time.Second))
+		}
+		t.replays = newReplayGuard(time.Duration(cfg.ReplayWindowSeconds) * time.Second)
+	}
+	return t, nil
+}
+
+func (t *webhookTrigger) Start(ctx context.Context, h Handler) error {
+	t.mu.Lock()
+	t.handler = h
+	t.mu.Unlock()
+	return Webhooks.add(t)
+}
+
+func (t *webhookTrigger) Stop(ctx context.Context) error {
+	Webhooks.remove(t)
+	return nil
+}
+
+// Pause implements Pauser; requests are refused with 503 while paused
+func (t *webhookTrigger) Pause(ctx context.Context) error {
+	t.paused.Store(true)
+	return nil
+}
+
+// Resume implements Pauser
+func (t *webhookTrigger) Resume(ctx context.Context) error {
+	t.paused.Store(false)
+	return nil
+}
+
+// serve runs the flow for one request to path
+func (t *webhookTrigger) serve(w http.ResponseWriter, r *http.Request, path string) {
+	if t.paused.Load() {
+		w.Header().Set("Retry-After", "30")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "flow is paused"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, t.cfg.MaxBodyBytes))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return
+	}
+	if t.verifier != nil {
+		if err := t.verifier.verify(r, body, time.Now()); err != nil {
+			status := http.StatusUnauthorized
+			if !errors.Is(err, errSignature) {
+				status = http.StatusInternalServerError
+			} else if challenge := t.verifier.challenge(path); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	// Only verified requests claim nonces, so that forged ones cannot
+	// block genuine deliveries
+	var nonce string
+	if t.replays != nil {
+		if nonce = t.nonce(r); nonce == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing " + t.cfg.NonceHeader + " header"})
+			return
+		}
+		if !t.replays.claim(nonce, time.Now()) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "webhook delivery was already received"})
+			return
+		}
+	}
+	msg := engine.NewMessage(body, r.Header.Get("Content-Type"))
+	msg.SetHeader(HeaderHTTPMethod, r.Method)
+	msg.SetHeader(HeaderHTTPPath, path)
+	if r.URL.RawQuery != "" {
+		msg.SetHeader(HeaderHTTPQuery, r.URL.RawQuery)
+	}
+	// Lets exactly-once flows recognize a client's retries
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		msg.SetHeader(engine.HeaderMessageID, key)
+	}
+	// Links the execution to the request, and to the upstream execution when
+	// the request is an outbox event
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = ids.New("req")
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	msg.SetHeader(engine.HeaderRequestID, requestID)
+	if parent := r.Header.Get("X-FusionFlow-Execution"); parent != "" {
+		msg.SetHeader(engine.HeaderParentExecution, parent)
+	}
+
+	t.mu.Lock()
+	h := t.handler
+	t.mu.Unlock()
+	out, err := h(r.Context(), msg)
+	if err != nil && nonce != "" {
+		t.replays.release(nonce)
+	}
+	switch {
+	case errors.Is(err, dispatch.ErrQueueFull):
+		w.Header().Set("Retry-After", "5")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	case out == nil:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		if out.ContentType != "" {
+			w.Header().Set("Content-Type", out.ContentType)
+		}
+		w.WriteHeader(responseHeaders(w.Header(), out))
+		w.Write(out.Body)
+	}
+}
+
+// nonce returns the nonce of r: its nonce header, or else its signature
+func (t *webhookTrigger) nonce(r *http.Request) string {
+	if t.cfg.NonceHeader != "" {
+		return r.Header.Get(t.cfg.NonceHeader)
+	}
+	return r.Header.Get(t.verifier.header)
+}
+
+// responseHeaders sets the response headers a flow's reply asks for, such as
+// those of an httpResponse step, and returns its status, 200 by default
+func responseHeaders(header http.Header, out *engine.Message) int {
+	for k, v := range out.Headers {
+		if name, ok := strings.CutPrefix(k, engine.HeaderResponseHeaderPrefix); ok && name != "" {
+			header.Set(name, v)
+		}
+	}
+	status, err := strconv.Atoi(out.Headers[engine.HeaderResponseStatus])
+	if err != nil || status < 200 || status > 599 {
+		return http.StatusOK
+	}
+	return status
+}
+
+// webhookMux routes webhook requests by method and path
+type webhookMux struct {
+	mu     sync.RWMutex
+	routes map[string]*webhookTrigger
+}
+
+func (m *webhookMux) add(t *webhookTrigger) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, taken := m.routes[t.key]; taken {
+		return fmt.Errorf("webhook %s is already served by another flow", t.key)
+	}
+	m.routes[t.key] = t
+	return nil
+}
+
+func (m *webhookMux) remove(t *webhookTrigger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.routes[t.key] == t {
+		delete(m.routes, t.key)
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (m *webhookMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, WebhookPrefix)
+	if path == "" {
+		path = "/"
+	}
+
+	m.mu.RLock()
+	t, ok := m.routes[r.Method+" "+path]
+	m.mu.RUnlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no webhook trigger for " + r.Method + " " + path})
+		return
+	}
+	t.serve(w, r, path)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,267 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/netlimit"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+)
+
+// method is the bidirectional streaming method the control plane serves:
+//
+//	service Tunnel {
+//	  rpc Connect(stream Response) returns (stream Request);
+//	}
+//
+// Messages are JSON, so the control plane needs no generated code either.
+const method = "/fusionflow.tunnel.v1.Tunnel/Connect"
+
+// maxFrameBytes bounds the messages of the stream, and so the bodies of
+// tunnelled calls
+const maxFrameBytes = 16 << 20
+
+// Request is a management API call the control plane sends down the
+// tunnel. A request with Cancel set cancels the call with its ID, such as
+// a log tail that is no longer watched.
+type Request struct {
+	ID      string              `json:"id"`
+	Method  string              `json:"method,omitempty"`
+	Path    string              `json:"path,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    []byte              `json:"body,omitempty"`
+	Cancel  bool                `json:"cancel,omitempty"`
+}
+
+// Response answers the Request with the same ID
+type Response struct {
+	ID      string              `json:"id"`
+	Status  int                 `json:"status"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    []byte              `json:"body,omitempty"`
+}
+
+// Client keeps a tunnel open to the control plane, serving the calls it
+// receives with the agent's HTTP handler, several at a time
+type Client struct {
+	cfg     config.TunnelConfig
+	token   string
+	agentID string
+	handler http.Handler
+	uplink  *netlimit.Link
+	logger  *logrus.Logger
+}
+
+// NewClient creates a tunnel client for the agent agentID, serving calls
+// with handler and sending their responses within the budget of uplink
+func NewClient(cfg config.TunnelConfig, token, agentID string, handler http.Handler, uplink *netlimit.Link, logger *logrus.Logger) *Client {
+	return &Client{cfg: cfg, token: token, agentID: agentID, handler: handler, uplink: uplink, logger: logger}
+}
+
+// Run keeps the tunnel open until ctx is cancelled, reopening it after it
+// drops
+func (c *Client) Run(ctx context.Context) {
+	retry := time.Duration(c.cfg.ReconnectInterval) * time.Second
+	for {
+		err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.logger.Warnf("Control plane tunnel closed: %v; reconnecting in %s", err, retry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// connect opens the tunnel and serves it until it fails
+func (c *Client) connect(ctx context.Context) error {
+	creds := insecure.NewCredentials()
+	if !c.cfg.Insecure {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.DialContext(ctx, c.cfg.Address,
+		grpc.WithTransportCredentials(creds),
+		// Keep NAT mappings alive and notice dead peers on idle tunnels
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 10 * time.Second, PermitWithoutStream: true}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{}), grpc.MaxCallRecvMsgSize(maxFrameBytes), grpc.MaxCallSendMsgSize(maxFrameBytes)),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	md := metadata.Pairs("x-agent-id", c.agentID)
+	if c.token != "" {
+		md.Set("authorization", "Bearer "+c.token)
+	}
+	stream, err := conn.NewStream(metadata.NewOutgoingContext(ctx, md), &grpc.StreamDesc{
+		StreamName:    "Connect",
+		ServerStreams: true,
+		ClientStreams: true,
+	}, method)
+	if err != nil {
+		return err
+	}
+	c.logger.Infof("Opened control plane tunnel to %s", c.cfg.Address)
+
+	s := &session{stream: stream, handler: c.handler, uplink: c.uplink, logger: c.logger, calls: make(map[string]context.CancelFunc)}
+	defer s.cancelAll()
+	for {
+		var req Request
+		if err := stream.RecvMsg(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("closed by the control plane")
+			}
+			return err
+		}
+		if req.Cancel {
+			s.cancel(req.ID)
+			continue
+		}
+		s.serve(ctx, &req)
+	}
+}
+
+// session is an open tunnel and its calls in progress
+type session struct {
+	stream  grpc.ClientStream
+	handler http.Handler
+	uplink  *netlimit.Link
+	logger  *logrus.Logger
+
+	// sendMu serializes sends, which a stream does not allow concurrently
+	sendMu sync.Mutex
+
+	mu    sync.Mutex
+	calls map[string]context.CancelFunc
+}
+
+// serve runs a call in the background and sends its response
+func (s *session) serve(ctx context.Context, req *Request) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.calls[req.ID] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer s.cancel(req.ID)
+		resp := s.call(ctx, req)
+		err := s.uplink.WaitN(ctx, len(resp.Body))
+		if err == nil {
+			s.sendMu.Lock()
+			err = s.stream.SendMsg(resp)
+			s.sendMu.Unlock()
+		}
+		if err != nil {
+			s.logger.WithField("call_id", req.ID).Warnf("Failed to answer tunnelled call: %v", err)
+		}
+	}()
+}
+
+// call runs req against the agent's HTTP handler
+func (s *session) call(ctx context.Context, req *Request) *Response {
+	if !strings.HasPrefix(req.Path, "/") {
+		return errorResponse(req.ID, http.StatusBadRequest, "path must start with /")
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return errorResponse(req.ID, http.StatusBadRequest, err.Error())
+	}
+	for name, values := range req.Headers {
+		for _, v := range values {
+			httpReq.Header.Add(name, v)
+		}
+	}
+	httpReq.RemoteAddr = "tunnel"
+	rec := &recorder{header: make(http.Header)}
+	s.handler.ServeHTTP(rec, httpReq)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return &Response{ID: req.ID, Status: rec.status, Headers: rec.header, Body: rec.body.Bytes()}
+}
+
+// cancel cancels a call in progress and forgets it
+func (s *session) cancel(id string) {
+	s.mu.Lock()
+	cancel, ok := s.calls[id]
+	delete(s.calls, id)
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// cancelAll cancels every call in progress
+func (s *session) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, cancel := range s.calls {
+		cancel()
+		delete(s.calls, id)
+	}
+}
+
+// errorResponse answers a call that could not be made
+func errorResponse(id string, status int, message string) *Response {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	return &Response{ID: id, Status: status, Headers: map[string][]string{"Content-Type": {"application/json"}}, Body: body}
+}
+
+// recorder is the http.ResponseWriter of a tunnelled call, buffering the
+// response to send it as one message
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.body.Len()+len(p) > maxFrameBytes-64<<10 {
+		return 0, fmt.Errorf("response exceeds the %d byte limit of the tunnel", maxFrameBytes)
+	}
+	return r.body.Write(p)
+}
+
+// Flush implements http.Flusher; the response is sent once complete
+func (r *recorder) Flush() {}
+
+// codec encodes the messages of the tunnel as JSON
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (codec) Name() string { return "json" }
@@ -0,0 +1,178 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Warm-up states of a flow
+const (
+	StatePending = "pending"
+	StateWarming = "warming"
+	StateReady   = "ready"
+	StateFailed  = "failed"
+)
+
+// FlowStatus reports the warm-up of one active flow
+type FlowStatus struct {
+	FlowID     string     `json:"flowId"`
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	DurationMs int64      `json:"durationMs,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Warmer preloads the active flows when the agent starts: it compiles each
+// flow's plan, lets its steps prepare their resources and restarts its
+// triggers. The agent is ready once every flow has been attempted; flows
+// that fail are reported but do not hold readiness back.
+type Warmer struct {
+	flows  *flows.Service
+	plans  *engine.PlanCache
+	cfg    config.WarmupConfig
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	done     bool
+	err      error
+	statuses map[string]*FlowStatus
+}
+
+// NewWarmer creates a warmer for the flows of svc, compiling them into plans
+func NewWarmer(svc *flows.Service, plans *engine.PlanCache, cfg config.WarmupConfig, logger *logrus.Logger) *Warmer {
+	return &Warmer{flows: svc, plans: plans, cfg: cfg, logger: logger, statuses: make(map[string]*FlowStatus)}
+}
+
+// Run warms up the active flows, Concurrency at a time, and marks the agent
+// ready when they are done
+func (w *Warmer) Run(ctx context.Context) {
+	start := time.Now()
+	list, err := w.flows.List(ctx)
+	if err != nil {
+		w.logger.Errorf("Failed to preload flows: %v", err)
+		w.finish(err)
+		return
+	}
+
+	var active []*model.Flow
+	w.mu.Lock()
+	for _, flow := range list {
+		if flow.Status == model.FlowStatusActive {
+			active = append(active, flow)
+			w.statuses[flow.ID] = &FlowStatus{FlowID: flow.ID, Name: flow.Name, State: StatePending}
+		}
+	}
+	w.mu.Unlock()
+
+	sem := make(chan struct{}, w.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, flow := range active {
+		flow := flow
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			w.warm(ctx, flow)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, st := range w.Status() {
+		if st.State == StateFailed {
+			failed++
+		}
+	}
+	w.logger.Infof("Warmed up %d active flow(s) in %s, %d failed", len(active), time.Since(start).Round(time.Millisecond), failed)
+	w.finish(nil)
+}
+
+// warm compiles and warms one flow, then restarts its triggers
+func (w *Warmer) warm(ctx context.Context, flow *model.Flow) {
+	start := time.Now()
+	w.update(flow.ID, StateWarming, nil, 0)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(w.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	err := func() error {
+		plan, err := w.plans.Plan(flow)
+		if err != nil {
+			return fmt.Errorf("failed to compile flow: %w", err)
+		}
+		if err := plan.Warm(ctx); err != nil {
+			return err
+		}
+		return w.flows.Restore(ctx, flow)
+	}()
+
+	state := StateReady
+	if err != nil {
+		state = StateFailed
+		w.logger.WithField("flow_id", flow.ID).Errorf("Failed to warm up flow: %v", err)
+	}
+	w.update(flow.ID, state, err, time.Since(start))
+}
+
+func (w *Warmer) update(flowID, state string, err error, took time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	st := w.statuses[flowID]
+	st.State = state
+	if err != nil {
+		st.Error = err.Error()
+	}
+	if state == StateReady || state == StateFailed {
+		now := time.Now().UTC()
+		st.FinishedAt = &now
+		st.DurationMs = took.Milliseconds()
+	}
+}
+
+func (w *Warmer) finish(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	w.err = err
+}
+
+// Hold finishes the warm-up without warming any flow, so that the agent
+// stays unready with err and the triggers of its flows are not restarted
+func (w *Warmer) Hold(err error) {
+	w.finish(err)
+}
+
+// Ready reports whether the warm-up has finished, and the error that
+// prevented it from listing the flows
+func (w *Warmer) Ready() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done, w.err
+}
+
+// Status returns the warm-up status of every active flow, ordered by ID
+func (w *Warmer) Status() []FlowStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := make([]FlowStatus, 0, len(w.statuses))
+	for _, st := range w.statuses {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FlowID < list[j].FlowID })
+	return list
+}
@@ -2,24 +2,69 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/fusionflow/edge-agent/internal/auth"
+	"github.com/fusionflow/edge-agent/internal/batches"
+	"github.com/fusionflow/edge-agent/internal/clock"
+	"github.com/fusionflow/edge-agent/internal/cluster"
 	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/connector"
+	"github.com/fusionflow/edge-agent/internal/connectors"
+	"github.com/fusionflow/edge-agent/internal/controlplane"
+	"github.com/fusionflow/edge-agent/internal/debugger"
+	"github.com/fusionflow/edge-agent/internal/diag"
+	"github.com/fusionflow/edge-agent/internal/dispatch"
+	"github.com/fusionflow/edge-agent/internal/dlq"
+	"github.com/fusionflow/edge-agent/internal/egress"
+	"github.com/fusionflow/edge-agent/internal/engine"
+	"github.com/fusionflow/edge-agent/internal/eventbus"
+	"github.com/fusionflow/edge-agent/internal/executions"
+	"github.com/fusionflow/edge-agent/internal/export"
+	"github.com/fusionflow/edge-agent/internal/flowfiles"
+	"github.com/fusionflow/edge-agent/internal/flows"
+	"github.com/fusionflow/edge-agent/internal/forward"
+	"github.com/fusionflow/edge-agent/internal/grpcapi"
 	"github.com/fusionflow/edge-agent/internal/handlers"
+	"github.com/fusionflow/edge-agent/internal/integrity"
+	"github.com/fusionflow/edge-agent/internal/kafka"
+	"github.com/fusionflow/edge-agent/internal/listeners"
+	"github.com/fusionflow/edge-agent/internal/logging"
+	"github.com/fusionflow/edge-agent/internal/metrics"
+	"github.com/fusionflow/edge-agent/internal/migrate"
+	"github.com/fusionflow/edge-agent/internal/mocks"
+	"github.com/fusionflow/edge-agent/internal/model"
+	"github.com/fusionflow/edge-agent/internal/namespaces"
+	"github.com/fusionflow/edge-agent/internal/netlimit"
 	"github.com/fusionflow/edge-agent/internal/otel"
+	"github.com/fusionflow/edge-agent/internal/outbox"
+	"github.com/fusionflow/edge-agent/internal/quarantine"
+	"github.com/fusionflow/edge-agent/internal/relay"
+	"github.com/fusionflow/edge-agent/internal/reload"
+	"github.com/fusionflow/edge-agent/internal/respcache"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	_ "github.com/fusionflow/edge-agent/internal/steps"
+	"github.com/fusionflow/edge-agent/internal/store"
+	_ "github.com/fusionflow/edge-agent/internal/store/postgres"
+	"github.com/fusionflow/edge-agent/internal/tasks"
+	"github.com/fusionflow/edge-agent/internal/throttle"
+	"github.com/fusionflow/edge-agent/internal/triggers"
+	"github.com/fusionflow/edge-agent/internal/tunnel"
+	"github.com/fusionflow/edge-agent/internal/warmup"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
-	cfgFile string
-	port    int
+	cfgFile        string
+	port           int
+	validateConfig bool
 )
 
 func main() {
@@ -30,8 +75,15 @@ func main() {
 		RunE:  run,
 	}
 
-	rootCmd.Flags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
 	rootCmd.Flags().IntVar(&port, "port", 8080, "port to listen on")
+	rootCmd.Flags().BoolVar(&validateConfig, "validate-config", false, "validate the configuration and exit, non-zero when invalid")
+
+	_ = rootCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
+
+	// The completion command is ours, documenting how to install it
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(newMigrateCmd(), newImportCmd(), newSnapshotCmd(), newEncryptionCmd(), newCtlCmd(), newCompletionCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -45,17 +97,114 @@ func run(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := secrets.ExpandConfig(cfg); err != nil {
+		return fmt.Errorf("failed to resolve config secrets: %w", err)
+	}
+	if validateConfig {
+		source := cfg.File
+		if source == "" {
+			source = "defaults and environment"
+		}
+		fmt.Printf("Configuration is valid (%s)\n", source)
+		return nil
+	}
 
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetLevel(cfg.LogLevel)
 	logger.SetFormatter(&logrus.JSONFormatter{})
 
+	// Verify the binary and installed bundles before running any of them
+	var integrityReport *integrity.Report
+	if cfg.Security.IntegrityCheck {
+		integrityReport = integrity.Check(cfg.Security)
+		if err := integrityReport.Err(); err != nil {
+			if cfg.Security.OnMismatch != "quarantine" {
+				return err
+			}
+			logger.Errorf("Starting in quarantine mode with flows inactive: %v", err)
+		} else {
+			logger.Infof("Verified %d files against integrity manifest %s", integrityReport.Checked, cfg.Security.Manifest)
+		}
+	}
+
+	// Send outbound traffic through the site's hub, before any HTTP client
+	// has run
+	if cfg.Relay.Mode == config.RelayModeSpoke {
+		if err := relay.UseHub(cfg.Relay.Spoke); err != nil {
+			return err
+		}
+		logger.Infof("Relaying outbound traffic through hub %s", cfg.Relay.Spoke.URL)
+	}
+
+	// Suppress bursts of repetitive log lines
+	if cfg.Logging.Sampling.Enabled {
+		sampler := logging.NewSampler(cfg.Logging.Sampling, logger)
+		sampler.Install()
+		defer sampler.Stop()
+	}
+
 	// Initialize OpenTelemetry
 	if err := otel.Initialize(cfg.OTel); err != nil {
 		logger.Warnf("Failed to initialize OpenTelemetry: %v", err)
 	}
 
+	// Open the local store
+	st, err := openStore(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := st.Close(); err != nil {
+			logger.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	// Cache hot reads such as flow definitions and connector configs. The
+	// replicas of a cluster would not see each other's writes through it.
+	if cfg.Storage.Cache.Enabled && cfg.Cluster.Enabled {
+		logger.Info("Storage cache disabled, as peers of the cluster change records")
+	} else if cfg.Storage.Cache.Enabled {
+		st = store.NewCachedStore(st, cfg.Storage.Cache)
+	}
+
+	// Bring the store schema up to date
+	migrator := migrate.New(st, logger)
+	if cfg.Storage.AutoMigrate {
+		if err := migrator.EnsureCurrent(context.Background(), cfg.Storage.Path); err != nil {
+			return fmt.Errorf("failed to migrate store: %w", err)
+		}
+	} else if status, err := migrator.Status(context.Background()); err != nil {
+		return fmt.Errorf("failed to read store schema: %w", err)
+	} else if status.Current != status.Latest {
+		return fmt.Errorf("store schema version %d does not match agent version %d; run 'edge-agent migrate up'", status.Current, status.Latest)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// Compress historical execution data in the background
+	if cfg.Storage.Archive.Enabled {
+		go store.NewArchiver(st, cfg.Storage.Archive, logger).Run(ctx)
+	}
+
+	// Buffer execution state updates and write them in batches
+	batcher := store.NewBatcher(st, cfg.Storage.Batch, logger)
+	go batcher.Run(ctx)
+
+	// Deliver outbox events asynchronously
+	sinks := make([]outbox.Sink, 0, len(cfg.Outbox.Webhooks)+1)
+	for _, hook := range cfg.Outbox.Webhooks {
+		sinks = append(sinks, outbox.NewWebhookSink(hook))
+	}
+	// Cached API responses are dropped as the events of changes come in
+	var respCache *respcache.Cache
+	if cfg.Server.Cache.Enabled {
+		respCache = respcache.New(cfg.Server.Cache)
+		sinks = append(sinks, respCache)
+	}
+	go outbox.NewRelay(st, cfg.Outbox, logger, sinks...).Run(ctx)
+
 	// Set Gin mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -64,28 +213,446 @@ func run(cmd *cobra.Command, args []string) error {
 	// Create router
 	router := gin.New()
 	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
+	// Only trusted proxies may set the client address of requests, which
+	// the network policies check
+	if err := router.SetTrustedProxies(cfg.Network.TrustedProxies); err != nil {
+		return fmt.Errorf("failed to set trusted proxies: %w", err)
+	}
 
-	// Register routes
-	handlers.RegisterRoutes(router, logger)
+	// Share execution slots fairly between tenants
+	dispatcher := dispatch.NewDispatcher(cfg.Scheduler)
 
-	// Create HTTP server
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	// Schedule triggers and delay steps follow the agent's clock, which is
+	// virtual when testing time-based flows
+	var (
+		clk     = clock.Real
+		virtual *clock.Virtual
+	)
+	if cfg.Clock.Virtual {
+		start := time.Now().UTC()
+		if cfg.Clock.Start != "" {
+			start, _ = time.Parse(time.RFC3339, cfg.Clock.Start)
+		}
+		virtual = clock.NewVirtual(start)
+		clk = virtual
+		logger.Warnf("Running on a virtual clock starting at %s; do not use in production", start.Format(time.RFC3339))
 	}
 
-	// Start server in goroutine
+	// Enforce connector bandwidth caps, following connector edits
+	connectorSvc := connectors.NewService(st)
+	conns, err := connectorSvc.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to load connectors: %w", err)
+	}
+	bandwidth := throttle.NewRegistry(conns)
+	connectorSvc.AddHook(bandwidth)
+	// and share the uplink's upload budget between WAN connectors and the
+	// control plane
+	uplink := netlimit.NewLink(cfg.Network.Uplink, bandwidth, conns)
+	connectorSvc.AddHook(uplink)
+
+	// Check the hosts steps and connectors connect to against the egress
+	// allowlists, auditing denials
+	egressGuard, err := egress.NewGuard(cfg.Egress.Allow, st, logger)
+	if err != nil {
+		return fmt.Errorf("invalid egress allowlist: %w", err)
+	}
+	connectorSvc.SetEgress(egressGuard)
+
+	// Run plugin connectors in limited child processes
+	connector.SetSandbox(pluginSandbox(cfg.Plugins))
+
+	// Connect to connectors on first use for the lookups of flow steps
+	connPool := connector.NewPool(connectorSvc)
+	connPool.SetEgress(egressGuard)
+	connectorSvc.AddHook(connPool)
+	// Reconnect connectors with rotated credentials, on schedule or when the
+	// secrets holding them change, auditing each rotation
+	rotator := connectors.NewRotator(connectorSvc, connPool, st, logger)
+	go rotator.Run(ctx)
+	// Renew the leases of dynamic credentials, reconnecting the connectors
+	// using them when they are reissued
+	secrets.Watch(rotator.Notify)
+	go secrets.Run(ctx, logger)
+
+	// Run flows with the defaults and within the limits of their namespace
+	guardrails := namespaces.New(cfg.Namespaces)
+
+	// Buffer the connector writes of sites that lost their uplink, and
+	// deliver them once their connectors are back
+	var batchWriter batches.Writer = connPool
+	var buffers []*forward.Buffer
+	if cfg.Forward.Enabled {
+		connBuf, err := forward.Open(cfg.Forward, "connectors", logger)
+		if err != nil {
+			return err
+		}
+		batchWriter = forward.NewConnectorWriter(connBuf, connPool, logger)
+		buffers = append(buffers, connBuf)
+	}
+
+	// Stage the messages of batch steps until each batch is written
+	batchMgr, err := batches.NewManager(cfg.Batches.Dir, time.Duration(cfg.Batches.RetryInterval)*time.Second, batchWriter, logger)
+	if err != nil {
+		return err
+	}
+	go batchMgr.Run(ctx)
+
+	// Hold the messages quarantine steps reject for review, deleting them
+	// once past their retention
+	planOpts := []engine.Option{engine.WithClock(clk), engine.WithBandwidth(uplink), engine.WithLookup(connPool), engine.WithCommitter(connPool), engine.WithEgress(egressGuard), engine.WithPolicies(guardrails), engine.WithBatches(batchMgr)}
+	var quarantineSvc *quarantine.Service
+	if cfg.Quarantine.Enabled {
+		quarantineSvc = quarantine.NewService(st, cfg.Quarantine, logger)
+		planOpts = append(planOpts, engine.WithQuarantine(quarantineSvc))
+		go quarantineSvc.Run(ctx)
+	}
+
+	// Compile each flow version once and reuse the plan across executions
+	plans := engine.NewPlanCache(planOpts...)
+
+	// The control plane knows the agent by its ID, the host name unless set
+	cpCfg := cfg.ControlPlane
+	if cpCfg.AgentID == "" && (cpCfg.URL != "" || cpCfg.Tunnel.Enabled) {
+		if cpCfg.AgentID, err = os.Hostname(); err != nil {
+			return fmt.Errorf("control_plane agent_id is required: %w", err)
+		}
+	}
+
+	// Run flows as tracked executions, recording their state as they go
+	executionSvc := executions.NewService(st, batcher, logger)
+	// and journal their changes for the control plane
+	if cpCfg.History.Enabled {
+		history := controlplane.NewHistory(cpCfg, st, uplink, logger)
+		executionSvc.SetJournal(history)
+		go history.Run(ctx)
+	}
+	executor := engine.NewExecutor(dispatcher, executionSvc, logger)
+	// Store what executions and their steps log, for the logs API and live
+	// tails
+	logCollector := engine.NewLogCollector(executionSvc)
+	logger.AddHook(logCollector)
+
+	// Start the triggers of flows as they are activated
+	triggerMgr := triggers.NewManager(logger, executor, plans, clk)
+	// Hand trigger messages to the executions of any replica through the
+	// event bus
+	var bus eventbus.Bus
+	if cfg.EventBus.Backend != "" {
+		bus, err = eventbus.New(cfg.EventBus, logger)
+		if err != nil {
+			return err
+		}
+		triggerMgr.SetBus(bus, cfg.EventBus.Group)
+	}
+	flowSvc := flows.NewService(st, plans)
+	flowSvc.AddHook(triggerMgr)
+	// Reject flows whose steps reference connectors that do not exist
+	flowSvc.SetConnectors(func(ctx context.Context, id string) (*model.Connector, error) {
+		conn, err := connectorSvc.Get(ctx, id)
+		if errors.Is(err, connectors.ErrNotFound) {
+			return nil, nil
+		}
+		return conn, err
+	})
+	flowSvc.SetGuardrails(guardrails)
+	if bus != nil && !bus.Ordered() {
+		flowSvc.SetUnordered(cfg.EventBus.Backend)
+	}
+
+	// currentPlan returns the plan of a flow's current version
+	currentPlan := func(ctx context.Context, flowID string) (*engine.Plan, error) {
+		flow, err := flowSvc.Get(ctx, flowID)
+		if err != nil {
+			return nil, err
+		}
+		return plans.Plan(flow)
+	}
+
+	// Save suspended executions and resume them when signalled or timed out,
+	// on the flow's current plan
+	resumer := executions.NewResumer(st, executionSvc, executor, currentPlan, clk, logger)
+	executor.SetSuspensions(resumer)
+	taskSvc := tasks.NewService(resumer, clk)
+
+	// Remember the Idempotency-Key of API executions so client retries do
+	// not run them twice
+	var idempotency *executions.Idempotency
+	if cfg.Idempotency.Enabled {
+		idempotency = executions.NewIdempotency(st, time.Duration(cfg.Idempotency.TTL)*time.Second, logger)
+		go idempotency.Run(ctx)
+	}
+
+	// Keep the input of failed executions, to be re-driven on the flow's
+	// current plan
+	var deadLetters *dlq.Service
+	if cfg.DLQ.Enabled {
+		deadLetters = dlq.NewService(st, cfg.DLQ, executor, currentPlan, logger)
+		executor.SetDeadLetters(deadLetters)
+	}
+	// Release quarantined messages on the flow's current plan
+	if quarantineSvc != nil {
+		quarantineSvc.SetExecutor(executor, currentPlan)
+	}
+
+	// Share the store with the other replicas of a cluster: executions are
+	// owned by this instance, and schedules fire on one replica only
+	var cl *cluster.Cluster
+	if cfg.Cluster.Enabled {
+		cl = cluster.New(st, cfg.Cluster, executionSvc, executor, logger)
+		if err := cl.Join(context.Background()); err != nil {
+			return err
+		}
+		executor.SetOwner(cl.ID())
+		executor.SetLease(cl)
+		// Keep the input of queued executions, so that peers can queue
+		// them again should this instance stop before running them
+		requeuer := executions.NewRequeuer(st, executor, currentPlan)
+		executor.SetInputs(requeuer)
+		executionSvc.SetRequeuer(requeuer)
+		triggerMgr.SetClaimer(cl)
+		go cl.Run(ctx)
+	}
+
+	// Export finished executions for the warehouse, from one replica
+	if cfg.Export.Enabled {
+		exporter, err := export.NewExporter(executionSvc, st, cfg.Export, logger)
+		if err != nil {
+			return fmt.Errorf("failed to set up export: %w", err)
+		}
+		if cl != nil {
+			exporter.SetClaimer(cl)
+		}
+		if cfg.Forward.Enabled {
+			exportBuf, err := forward.Open(cfg.Forward, "export", logger)
+			if err != nil {
+				return err
+			}
+			exporter.SetForward(exportBuf)
+			buffers = append(buffers, exportBuf)
+		}
+		go exporter.Run(ctx)
+	}
+
+	// Push the agent's metrics, buffering them through outages
+	if cfg.Metrics.RemoteWrite.Enabled {
+		metricsBuf, err := forward.Open(cfg.Forward, "metrics", logger)
+		if err != nil {
+			return err
+		}
+		buffers = append(buffers, metricsBuf)
+		remoteWriter := metrics.NewRemoteWriter(cfg.Metrics.RemoteWrite, metricsBuf, uplink.Transport(nil), logger)
+		remoteWriter.Add(metrics.Runtime)
+		remoteWriter.Add(func() []metrics.Sample {
+			stats := dispatcher.Stats()
+			return []metrics.Sample{
+				{Name: "edge_agent_executions_running", Value: float64(stats.Running)},
+				{Name: "edge_agent_executions_queued", Value: float64(stats.Queued)},
+				{Name: "edge_agent_executions_max_concurrent", Value: float64(stats.MaxConcurrent)},
+			}
+		})
+		forwarded := buffers
+		remoteWriter.Add(func() []metrics.Sample {
+			samples := make([]metrics.Sample, 0, 3*len(forwarded))
+			for _, buf := range forwarded {
+				stats := buf.Stats()
+				labels := map[string]string{"buffer": stats.Name}
+				samples = append(samples,
+					metrics.Sample{Name: "edge_agent_forward_pending_bytes", Labels: labels, Value: float64(stats.PendingBytes)},
+					metrics.Sample{Name: "edge_agent_forward_delivered_total", Labels: labels, Value: float64(stats.Delivered)},
+					metrics.Sample{Name: "edge_agent_forward_dropped_total", Labels: labels, Value: float64(stats.Dropped)},
+				)
+			}
+			return samples
+		})
+		go remoteWriter.Run(ctx)
+		logger.Infof("Pushing metrics to %s every %ds", cfg.Metrics.RemoteWrite.URL, cfg.Metrics.RemoteWrite.Interval)
+	}
+
+	for _, buf := range buffers {
+		go buf.Run(ctx)
+	}
+
+	// Time out waiting executions, once the executor knows its owner
+	go resumer.Run(ctx)
+
+	// Step-through debug executions, when enabled
+	var debugMgr *debugger.Manager
+	if cfg.Debugger.Enabled {
+		debugMgr = debugger.NewManager(
+			time.Duration(cfg.Debugger.PauseTimeout)*time.Second,
+			time.Duration(cfg.Debugger.Retention)*time.Second)
+		logger.Warn("Execution debugger is enabled; do not use in production")
+	}
+
+	// Mock endpoints standing in for partner APIs, when enabled
+	var mockSvc *mocks.Service
+	if cfg.Mocks.Enabled {
+		mockSvc = mocks.NewService(st, time.Duration(cfg.Mocks.MaxLatency)*time.Millisecond, logger)
+		logger.Warnf("Mock endpoints are served under %s; do not use in production", mocks.Prefix)
+	}
+
+	// Preload active flows and restart their triggers; /health/ready
+	// reports ready once done
+	warmer := warmup.NewWarmer(flowSvc, plans, cfg.Warmup, logger)
+	// Then pull the flows and connectors the control plane assigns the
+	// agent, so deployments do not race the restart of triggers
+	var syncer *controlplane.Syncer
+	if cpCfg.URL != "" {
+		syncer = controlplane.NewSyncer(cpCfg, st, flowSvc, connectorSvc, uplink, logger)
+		logger.Infof("Syncing deployments of agent %s from %s", cpCfg.AgentID, cpCfg.URL)
+	}
+	// and load the flows of the flow directory, for file-driven agents
+	var flowLoader *flowfiles.Loader
+	if cfg.Flows.Dir != "" {
+		flowLoader = flowfiles.NewLoader(cfg.Flows.Dir, st, flowSvc, logger)
+		logger.Infof("Loading flows from %s", cfg.Flows.Dir)
+	}
 	go func() {
-		logger.Infof("Starting edge agent on port %d", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
+		if integrityReport != nil && !integrityReport.Verified {
+			// Quarantine mode: keep the flows' triggers stopped and the
+			// deployments unapplied until a verified install restarts
+			warmer.Hold(integrityReport.Err())
+			return
+		}
+		warmer.Run(ctx)
+		if flowLoader != nil {
+			go flowLoader.Run(ctx)
+		}
+		if syncer != nil {
+			syncer.Run(ctx)
 		}
 	}()
 
+	// gRPC health checking and reflection, when enabled; the health service
+	// follows /health/ready
+	var grpcSrv *grpcapi.Server
+	if cfg.GRPC.Enabled {
+		grpcSrv = grpcapi.NewServer(cfg.GRPC, warmer.Ready, logger)
+		go func() {
+			logger.Infof("Starting gRPC server on port %d", cfg.GRPC.Port)
+			if err := grpcSrv.Serve(ctx); err != nil {
+				logger.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
+
+	// Dump goroutines and agent state on request, and on SIGQUIT, when the
+	// agent appears hung
+	dumper := diag.NewDumper(cfg.Diagnostics, logger)
+	dumper.Add("executions", func() interface{} { return executor.Active() })
+	dumper.Add("scheduler", func() interface{} { return dispatcher.Stats() })
+	dumper.Add("batch", func() interface{} { return batcher.Stats() })
+	dumper.Add("triggers", func() interface{} { return triggerMgr.Status("") })
+	dumper.Add("warmup", func() interface{} { return warmer.Status() })
+	dumper.Add("connectors", func() interface{} { return connPool.Status() })
+	if integrityReport != nil {
+		dumper.Add("integrity", func() interface{} { return integrityReport })
+	}
+	if len(buffers) > 0 {
+		dumper.Add("forward", func() interface{} {
+			stats := make([]forward.Stats, 0, len(buffers))
+			for _, buf := range buffers {
+				stats = append(stats, buf.Stats())
+			}
+			return stats
+		})
+	}
+	if cl != nil {
+		dumper.Add("cluster", func() interface{} {
+			list, err := cl.Instances(ctx)
+			if err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			return map[string]interface{}{"self": cl.ID(), "instances": list}
+		})
+	}
+	if cfg.Diagnostics.Signal {
+		go dumper.Watch(ctx)
+	}
+
+	// Relay the outbound traffic of the spokes at the site
+	var hub *relay.Hub
+	if cfg.Relay.Mode == config.RelayModeHub {
+		hub = relay.NewHub(cfg.Relay.Hub, logger)
+		dumper.Add("relay", func() interface{} { return hub.Stats() })
+		go func() {
+			logger.Infof("Starting relay hub on port %d", cfg.Relay.Hub.Port)
+			if err := hub.ListenAndServe(); err != nil {
+				logger.Fatalf("Failed to start relay hub: %v", err)
+			}
+		}()
+	}
+
+	// Authenticate API requests with API keys and JWTs, keeping the keys of
+	// the JWKS URL fresh
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authn = auth.New(cfg.Auth, logger)
+		go authn.Refresh(ctx)
+	}
+
+	// Register routes
+	handlers.RegisterRoutes(router, logger, cfg, handlers.Services{
+		Store:       st,
+		Batch:       batcher,
+		Flows:       flowSvc,
+		Connectors:  connectorSvc,
+		Rotator:     rotator,
+		Executions:  executionSvc,
+		Resumer:     resumer,
+		Idempotency: idempotency,
+		Tasks:       taskSvc,
+		Plans:       plans,
+		Executor:    executor,
+		Logs:        logCollector,
+		Triggers:    triggerMgr,
+		Dispatcher:  dispatcher,
+		Warmup:      warmer,
+		Debugger:    debugMgr,
+		Mocks:       mockSvc,
+		DeadLetters: deadLetters,
+		Quarantine:  quarantineSvc,
+		Clock:       virtual,
+		Cluster:     cl,
+		Diagnostics: dumper,
+		Cache:       respCache,
+		Auth:        authn,
+	})
+
+	// Serve the same API to the control plane over a tunnel the agent
+	// opens, for agents it cannot reach behind NAT
+	if cpCfg.Tunnel.Enabled {
+		go tunnel.NewClient(cpCfg.Tunnel, cpCfg.Token, cpCfg.AgentID, router, uplink, logger).Run(ctx)
+	}
+
+	// Serve the router on the listeners of each traffic class, or on port
+	srv, err := listeners.New(cfg.Server, port, router, handlers.Class, logger)
+	if err != nil {
+		return err
+	}
+	srv.Start()
+	go srv.WatchCertificates(ctx)
+
+	// Apply the dynamic settings of the config file on SIGHUP and when it
+	// changes, leaving listeners and executions in flight alone
+	reloader := reload.NewReloader(cfg.File, logger)
+	reloader.Add(func(next *config.Config) {
+		logger.SetLevel(next.LogLevel)
+		uplink.SetRate(next.Network.Uplink)
+		otel.SetSampleRatio(next.OTel.SampleRatio)
+		// Connectors reading secrets that changed with the directory or
+		// providers reconnect with them
+		rotator.Check(ctx)
+		// Plugins started from now on, such as after a crash, get the new
+		// limits
+		connector.SetSandbox(pluginSandbox(next.Plugins))
+		// SIGHUP also picks up certificates renewed where the files
+		// cannot be watched
+		srv.ReloadCertificates()
+	})
+	go reloader.Run(ctx)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -94,13 +661,66 @@ func run(cmd *cobra.Command, args []string) error {
 	logger.Info("Shutting down edge agent...")
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Errorf("Server forced to shutdown: %v", err)
 	}
+	if grpcSrv != nil {
+		grpcSrv.Stop(shutdownCtx)
+	}
+	if hub != nil {
+		if err := hub.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("Failed to stop relay hub: %v", err)
+		}
+	}
+	if err := triggerMgr.Stop(shutdownCtx); err != nil {
+		logger.Errorf("Failed to stop triggers: %v", err)
+	}
+	if bus != nil {
+		if err := bus.Close(); err != nil {
+			logger.Errorf("Failed to close event bus: %v", err)
+		}
+	}
+	if err := executor.Stop(shutdownCtx); err != nil {
+		logger.Errorf("Cancelled executions still running at shutdown: %v", err)
+	}
+	if err := batchMgr.Close(shutdownCtx); err != nil {
+		logger.Errorf("Failed to write batches at shutdown: %v", err)
+	}
+	for _, buf := range buffers {
+		if err := buf.Close(shutdownCtx); err != nil {
+			logger.Errorf("Failed to deliver buffered records at shutdown: %v", err)
+		}
+	}
+	if err := connPool.Close(); err != nil {
+		logger.Errorf("Failed to close connectors: %v", err)
+	}
+	if err := kafka.Close(); err != nil {
+		logger.Errorf("Failed to close Kafka producers: %v", err)
+	}
+	if err := batcher.Flush(shutdownCtx); err != nil {
+		logger.Errorf("Failed to write buffered execution state: %v", err)
+	}
+	if cl != nil {
+		if err := cl.Leave(shutdownCtx); err != nil {
+			logger.Errorf("Failed to leave cluster: %v", err)
+		}
+	}
 
 	logger.Info("Edge agent stopped")
 	return nil
 }
+
+// pluginSandbox is the sandbox of plugin connectors configured by cfg
+func pluginSandbox(cfg config.PluginsConfig) connector.Sandbox {
+	return connector.Sandbox{
+		Dir:           cfg.Dir,
+		CPU:           cfg.CPU,
+		MemoryBytes:   int64(cfg.MemoryMB) << 20,
+		MaxRestarts:   cfg.MaxRestarts,
+		RestartWindow: time.Duration(cfg.RestartWindow) * time.Second,
+		CgroupRoot:    cfg.CgroupRoot,
+	}
+}
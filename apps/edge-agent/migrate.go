@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/migrate"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// newMigrateCmd builds the `migrate` command tree for managing the store schema
+func newMigrateCmd() *cobra.Command {
+	var target int
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the local store schema",
+	}
+	out := addOutputFlag(migrateCmd)
+
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+				n, err := m.Up(ctx, target)
+				if printErr := out.print(map[string]int{"applied": n}, func(w io.Writer) {
+					fmt.Fprintf(w, "Applied %d migration(s)\n", n)
+				}); err == nil {
+					err = printErr
+				}
+				return err
+			})
+		},
+	}
+	upCmd.Flags().IntVar(&target, "to", 0, "target version (default is latest)")
+
+	downCmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert migrations down to a target version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+				if !cmd.Flags().Changed("to") {
+					status, err := m.Status(ctx)
+					if err != nil {
+						return err
+					}
+					// Revert a single migration by default
+					target = status.Current - 1
+					if len(status.Applied) > 1 {
+						target = status.Applied[len(status.Applied)-2].Version
+					}
+				}
+				n, err := m.Down(ctx, target)
+				if printErr := out.print(map[string]int{"reverted": n}, func(w io.Writer) {
+					fmt.Fprintf(w, "Reverted %d migration(s)\n", n)
+				}); err == nil {
+					err = printErr
+				}
+				return err
+			})
+		},
+	}
+	downCmd.Flags().IntVar(&target, "to", 0, "target version (default reverts one migration)")
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the current schema version and pending migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrator(func(ctx context.Context, m *migrate.Migrator) error {
+				status, err := m.Status(ctx)
+				if err != nil {
+					return err
+				}
+
+				report := schemaReport{Current: status.Current, Latest: status.Latest}
+				for _, a := range status.Applied {
+					appliedAt := a.AppliedAt
+					report.Migrations = append(report.Migrations, migrationState{Version: a.Version, State: "applied", Description: a.Description, AppliedAt: &appliedAt})
+				}
+				for _, p := range status.Pending {
+					report.Migrations = append(report.Migrations, migrationState{Version: p.Version, State: "pending", Description: p.Description})
+				}
+				return out.print(report, func(w io.Writer) {
+					fmt.Fprintf(w, "Current version: %d\nLatest version:  %d\n\n", report.Current, report.Latest)
+					fmt.Fprintln(w, "VERSION\tSTATE\tDESCRIPTION\tAPPLIED AT")
+					for _, m := range report.Migrations {
+						appliedAt := "-"
+						if m.AppliedAt != nil {
+							appliedAt = m.AppliedAt.Format(time.RFC3339)
+						}
+						fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.Version, m.State, m.Description, appliedAt)
+					}
+				})
+			})
+		},
+	}
+
+	migrateCmd.AddCommand(upCmd, downCmd, statusCmd)
+	return migrateCmd
+}
+
+// schemaReport is the output of `migrate status`
+type schemaReport struct {
+	Current    int              `json:"current"`
+	Latest     int              `json:"latest"`
+	Migrations []migrationState `json:"migrations"`
+}
+
+// migrationState is a migration in a schemaReport
+type migrationState struct {
+	Version     int        `json:"version"`
+	State       string     `json:"state"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+}
+
+// withMigrator opens the configured store and runs fn with a migrator for it
+func withMigrator(fn func(ctx context.Context, m *migrate.Migrator) error) error {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := secrets.ExpandConfig(cfg); err != nil {
+		return fmt.Errorf("failed to resolve config secrets: %w", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(cfg.LogLevel)
+
+	st, err := openStore(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	return fn(context.Background(), migrate.New(st, logger))
+}
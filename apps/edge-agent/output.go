@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of --output
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML}
+
+// outputFormat is the --output flag of the commands that print results:
+// tables and messages for people, or JSON or YAML for scripts. Progress
+// and warnings go to stderr in every format.
+type outputFormat string
+
+// addOutputFlag adds --output to cmd and its subcommands
+func addOutputFlag(cmd *cobra.Command) *outputFormat {
+	out := outputFormat(outputTable)
+	cmd.PersistentFlags().VarP(&out, "output", "o", "output format ("+strings.Join(outputFormats, "|")+")")
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(outputFormats, cobra.ShellCompDirectiveNoFileComp))
+	return &out
+}
+
+// String implements pflag.Value
+func (o *outputFormat) String() string {
+	return string(*o)
+}
+
+// Set implements pflag.Value
+func (o *outputFormat) Set(v string) error {
+	if !slices.Contains(outputFormats, v) {
+		return fmt.Errorf("must be one of %s", strings.Join(outputFormats, "|"))
+	}
+	*o = outputFormat(v)
+	return nil
+}
+
+// Type implements pflag.Value
+func (o *outputFormat) Type() string {
+	return "format"
+}
+
+// print writes v as JSON or YAML, or has table write it as a table. Field
+// names follow the JSON encoding of v in both formats.
+func (o outputFormat) print(v interface{}, table func(w io.Writer)) error {
+	switch o {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		doc, err := yamlDocument(v)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// printItem writes one item of a stream, such as a log entry: a line of
+// JSON, a YAML document, or the line that line formats
+func (o outputFormat) printItem(v interface{}, line func() string) error {
+	switch o {
+	case outputJSON:
+		return json.NewEncoder(os.Stdout).Encode(v)
+	case outputYAML:
+		doc, err := yamlDocument(v)
+		if err != nil {
+			return err
+		}
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "---\n%s", data)
+		return err
+	}
+	_, err := fmt.Fprintln(os.Stdout, line())
+	return err
+}
+
+// yamlDocument converts v through JSON, so that YAML output has the field
+// names and omissions of the API
+func yamlDocument(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,329 @@
+// Package client is a Go client of the edge agent's management API, for
+// services and tools that drive agents. It follows the routes and bodies
+// of internal/handlers and shares the agent's model types, so a client
+// built from the same tree always matches its agent.
+//
+// Requests that are safe to repeat are retried on network errors, 429 and
+// 502-504 responses with exponential backoff, honouring Retry-After. List
+// endpoints are read through an Iterator that fetches pages as it goes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry defaults
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Client calls the management API of one agent. It is safe for concurrent
+// use.
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	token      func(ctx context.Context) (string, error)
+	userAgent  string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc, such as one with mTLS transport
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithToken authenticates requests with a bearer token, as listeners with
+// tokens require
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = func(context.Context) (string, error) { return token, nil }
+	}
+}
+
+// WithTokenSource authenticates each request with the bearer token fn
+// returns, for tokens that are refreshed while the client is in use
+func WithTokenSource(fn func(ctx context.Context) (string, error)) Option {
+	return func(c *Client) { c.token = fn }
+}
+
+// WithRetries retries requests that are safe to repeat up to max times,
+// backing off from min and doubling up to the cap of the defaults; 0
+// disables retries
+func WithRetries(max int, min time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		if min > 0 {
+			c.minBackoff = min
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent of requests
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New creates a client of the agent at baseURL, such as
+// "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		base:       base,
+		http:       &http.Client{Timeout: 60 * time.Second},
+		userAgent:  "fusionflow-edge-client",
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is an error response of the API. Problems locates each problem of
+// an invalid request body, such as "steps[2].id".
+type Error struct {
+	StatusCode int
+	Title      string
+	Detail     string
+	Problems   []Problem
+}
+
+func (e *Error) Error() string {
+	msg := e.Title
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("edge agent API returned %d: %s", e.StatusCode, msg)
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// request is an API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	header http.Header
+	// retry allows retrying a request that is not idempotent by method,
+	// such as a POST with an Idempotency-Key
+	retry bool
+}
+
+// do sends req, decoding a successful JSON response into out unless it is
+// nil, and returns the response status
+func (c *Client) do(ctx context.Context, req request, out interface{}) (int, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return 0, fmt.Errorf("failed to encode request body: %w", err)
+		}
+	}
+	retry := req.retry
+	switch req.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		retry = true
+	}
+
+	for attempt := 0; ; attempt++ {
+		status, wait, err := c.send(ctx, req, body, out)
+		if err == nil || !retry || attempt >= c.maxRetries || !retryable(status, err) {
+			return status, err
+		}
+		if wait <= 0 {
+			wait = c.backoff(attempt)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return status, err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// send makes one attempt of req, returning the Retry-After delay of a
+// response asking for one
+func (c *Client) send(ctx context.Context, req request, body []byte, out interface{}) (int, time.Duration, error) {
+	httpReq, err := c.newRequest(ctx, req, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, 0, err
+	}
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, retryAfter(resp.Header), decodeError(resp.StatusCode, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, 0, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, 0, nil
+}
+
+// newRequest builds the HTTP request of req, with its headers and token
+func (c *Client) newRequest(ctx context.Context, req request, body []byte) (*http.Request, error) {
+	u := *c.base
+	u.Path = c.base.Path + req.path
+	u.RawQuery = req.query.Encode()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if httpReq.Header.Get("Accept") == "" {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API token: %w", err)
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return httpReq, nil
+}
+
+// decodeError reads the problem details or {"error": ...} body of an
+// error response
+func decodeError(status int, data []byte) error {
+	var body struct {
+		Title  string    `json:"title"`
+		Detail string    `json:"detail"`
+		Errors []Problem `json:"errors"`
+		Error  string    `json:"error"`
+	}
+	_ = json.Unmarshal(data, &body)
+	e := &Error{StatusCode: status, Title: body.Title, Detail: body.Detail, Problems: body.Errors}
+	if e.Title == "" {
+		e.Title = body.Error
+	}
+	return e
+}
+
+// retryable reports whether an attempt that failed with status or err may
+// succeed when repeated
+func retryable(status int, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	switch status {
+	case 0:
+		// No response: the request may not have reached the agent
+		return true
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is the full-jitter delay before retry attempt+1
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(d)))
+	if err != nil {
+		return d
+	}
+	return time.Duration(n.Int64()) + c.minBackoff/2
+}
+
+// retryAfter reads a Retry-After header in seconds
+func retryAfter(h http.Header) time.Duration {
+	secs, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// newIdempotencyKey returns a random key making retries of a request safe
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Health is the state of the agent
+type Health struct {
+	Status    string    `json:"status"`
+	Service   string    `json:"service"`
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Health checks that the agent is up
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var h Health
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/health"}, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ListConnectors lists connectors, filtered by "type" and by a part of
+// "name"
+func (c *Client) ListConnectors(opts *ListOptions) *Iterator[Connector] {
+	return newIterator[Connector](c, "/api/v1/connectors", "connectors", opts.query())
+}
+
+// GetConnector gets a connector
+func (c *Client) GetConnector(ctx context.Context, id string) (*Connector, error) {
+	var conn Connector
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/connectors/" + url.PathEscape(id)}, &conn); err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// CreateConnector creates a connector, returning it with its ID
+func (c *Client) CreateConnector(ctx context.Context, conn *Connector) (*Connector, error) {
+	var created Connector
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/connectors", body: conn}, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateConnector replaces the definition of the connector conn.ID
+func (c *Client) UpdateConnector(ctx context.Context, conn *Connector) (*Connector, error) {
+	return c.putConnector(ctx, conn, nil)
+}
+
+// ApplyConnector creates or replaces the connector conn.ID, for clients
+// managing connectors declaratively
+func (c *Client) ApplyConnector(ctx context.Context, conn *Connector) (*Connector, error) {
+	return c.putConnector(ctx, conn, url.Values{"upsert": {"true"}})
+}
+
+func (c *Client) putConnector(ctx context.Context, conn *Connector, query url.Values) (*Connector, error) {
+	var updated Connector
+	req := request{method: http.MethodPut, path: "/api/v1/connectors/" + url.PathEscape(conn.ID), query: query, body: conn}
+	if _, err := c.do(ctx, req, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteConnector deletes a connector
+func (c *Client) DeleteConnector(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api/v1/connectors/" + url.PathEscape(id)}, nil)
+	return err
+}
+
+// TestConnector tests the connection of a connector. A failed test is
+// reported in the result rather than as an error.
+func (c *Client) TestConnector(ctx context.Context, id string) (*ConnectionTest, error) {
+	var test ConnectionTest
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/connectors/" + url.PathEscape(id) + "/test", retry: true}, &test); err != nil {
+		return nil, err
+	}
+	return &test, nil
+}
+
+// RotateConnector rotates the credentials of a connector, reconnecting it
+// when in use
+func (c *Client) RotateConnector(ctx context.Context, id string) (*Rotation, error) {
+	var rot Rotation
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/connectors/" + url.PathEscape(id) + "/rotate"}, &rot); err != nil {
+		return nil, err
+	}
+	return &rot, nil
+}
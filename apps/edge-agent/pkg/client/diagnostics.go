@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Dump describes a diagnostic dump written on the agent's host
+type Dump struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	Goroutines int       `json:"goroutines"`
+	Time       time.Time `json:"time"`
+}
+
+// WriteDiagnosticDump has the agent write its goroutine stacks and state
+// to a file on its host, for support bundles
+func (c *Client) WriteDiagnosticDump(ctx context.Context) (*Dump, error) {
+	var dump Dump
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/diagnostics/dump"}, &dump); err != nil {
+		return nil, err
+	}
+	return &dump, nil
+}
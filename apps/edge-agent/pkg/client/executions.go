@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// ExecuteOptions are the optional settings of an execution. A request
+// with an IdempotencyKey seen within its TTL returns the execution the
+// first one started; without one a key is generated, so that retries
+// never start the flow twice.
+type ExecuteOptions struct {
+	IdempotencyKey string
+	// Breakpoints runs the flow under the step-through debugger, pausing
+	// before each of these steps
+	Breakpoints []string
+}
+
+// Execute queues a run of a flow on input, which is encoded as JSON
+func (c *Client) Execute(ctx context.Context, flowID string, input interface{}, opts *ExecuteOptions) (*Execution, error) {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		FlowID string          `json:"flowId"`
+		Input  json.RawMessage `json:"input"`
+		Debug  *struct {
+			Breakpoints []string `json:"breakpoints"`
+		} `json:"debug,omitempty"`
+	}{FlowID: flowID, Input: raw}
+
+	key := newIdempotencyKey()
+	if opts != nil {
+		if opts.IdempotencyKey != "" {
+			key = opts.IdempotencyKey
+		}
+		if opts.Breakpoints != nil {
+			body.Debug = &struct {
+				Breakpoints []string `json:"breakpoints"`
+			}{Breakpoints: opts.Breakpoints}
+		}
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   "/api/v1/executions",
+		body:   body,
+		header: http.Header{"Idempotency-Key": {key}},
+		// Debug runs ignore the key, so only others are safe to repeat
+		retry: body.Debug == nil,
+	}
+	var exec Execution
+	if _, err := c.do(ctx, req, &exec); err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+// ListExecutions lists executions, filtered by "status" and "flowId"; with
+// the filter "archived" set to "true" the archived ones are listed
+func (c *Client) ListExecutions(opts *ListOptions) *Iterator[Execution] {
+	return newIterator[Execution](c, "/api/v1/executions", "executions", opts.query())
+}
+
+// GetExecution gets an execution
+func (c *Client) GetExecution(ctx context.Context, id string) (*Execution, error) {
+	var exec Execution
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: executionPath(id)}, &exec); err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+// CancelExecution asks for an execution to be cancelled, recording the
+// reason and user; it stops once its running step returns
+func (c *Client) CancelExecution(ctx context.Context, id, reason, by string) (*Cancellation, error) {
+	var cancellation Cancellation
+	body := map[string]string{"reason": reason, "by": by}
+	req := request{method: http.MethodPost, path: executionPath(id) + "/cancel", body: body, retry: true}
+	if _, err := c.do(ctx, req, &cancellation); err != nil {
+		return nil, err
+	}
+	return &cancellation, nil
+}
+
+// ResumeExecution resumes an execution waiting for token, with an optional
+// payload encoded as JSON
+func (c *Client) ResumeExecution(ctx context.Context, id, token string, payload interface{}) (*Execution, error) {
+	body := struct {
+		Token   string          `json:"token"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}{Token: token}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body.Payload = raw
+	}
+	var exec Execution
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: executionPath(id) + "/resume", body: body}, &exec); err != nil {
+		return nil, err
+	}
+	return &exec, nil
+}
+
+// ExecutionLogs lists the log entries of an execution, filtered by
+// "level" and "stepId"
+func (c *Client) ExecutionLogs(id string, opts *ListOptions) *Iterator[ExecutionLog] {
+	return newIterator[ExecutionLog](c, executionPath(id)+"/logs", "logs", opts.query())
+}
+
+func executionPath(id string) string {
+	return "/api/v1/executions/" + url.PathEscape(id)
+}
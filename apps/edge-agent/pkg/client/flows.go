@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListFlows lists flows, filtered by "status" and by a part of "name"
+func (c *Client) ListFlows(opts *ListOptions) *Iterator[Flow] {
+	return newIterator[Flow](c, "/api/v1/flows", "flows", opts.query())
+}
+
+// GetFlow gets a flow
+func (c *Client) GetFlow(ctx context.Context, id string) (*Flow, error) {
+	var flow Flow
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: flowPath(id)}, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// CreateFlow creates a flow as a draft, returning it with its ID
+func (c *Client) CreateFlow(ctx context.Context, flow *Flow) (*Flow, error) {
+	var created Flow
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/api/v1/flows", body: flow}, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ApplyFlow creates or updates the flow flow.ID and activates it in one
+// step
+func (c *Client) ApplyFlow(ctx context.Context, flow *Flow) (*Flow, error) {
+	var applied Flow
+	req := request{method: http.MethodPost, path: "/api/v1/flows/apply", body: flow, retry: true}
+	if _, err := c.do(ctx, req, &applied); err != nil {
+		return nil, err
+	}
+	return &applied, nil
+}
+
+// UpdateFlow replaces the definition of the flow flow.ID. The definition
+// of an active flow is stored as a draft version instead, returned as
+// Draft until it is published with PublishFlowVersion.
+func (c *Client) UpdateFlow(ctx context.Context, flow *Flow) (*Flow, *Draft, error) {
+	// Flows carry their draft version too
+	var resp struct {
+		Flow
+		Message string `json:"message"`
+	}
+	status, err := c.do(ctx, request{method: http.MethodPut, path: flowPath(flow.ID), body: flow}, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status == http.StatusAccepted {
+		return nil, &Draft{ID: resp.ID, Version: resp.Version, DraftVersion: resp.DraftVersion, Message: resp.Message}, nil
+	}
+	return &resp.Flow, nil, nil
+}
+
+// DeleteFlow deletes a flow
+func (c *Client) DeleteFlow(ctx context.Context, id string) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: flowPath(id)}, nil)
+	return err
+}
+
+// ActivateFlow activates a flow, starting its triggers
+func (c *Client) ActivateFlow(ctx context.Context, id string) (*FlowState, error) {
+	return c.flowAction(ctx, id, "/activate", nil, true)
+}
+
+// DeactivateFlow deactivates a flow, stopping its triggers
+func (c *Client) DeactivateFlow(ctx context.Context, id string) (*FlowState, error) {
+	return c.flowAction(ctx, id, "/deactivate", nil, true)
+}
+
+// RollbackFlow reverts a flow to an earlier published version and
+// activates it; version 0 is the one published before the current one
+func (c *Client) RollbackFlow(ctx context.Context, id string, version int) (*FlowState, error) {
+	return c.flowAction(ctx, id, "/rollback", map[string]int{"version": version}, version != 0)
+}
+
+// PublishFlowVersion makes a draft version the flow's definition and
+// activates the flow
+func (c *Client) PublishFlowVersion(ctx context.Context, id string, version int) (*FlowState, error) {
+	return c.flowAction(ctx, id, "/versions/"+strconv.Itoa(version)+"/publish", nil, true)
+}
+
+// flowAction posts an action of a flow; retry is whether repeating it
+// leaves the flow as doing it once would
+func (c *Client) flowAction(ctx context.Context, id, action string, body interface{}, retry bool) (*FlowState, error) {
+	var state FlowState
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: flowPath(id) + action, body: body, retry: retry}, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// ListFlowVersions lists the version history of a flow, filtered by
+// "status"
+func (c *Client) ListFlowVersions(id string, opts *ListOptions) *Iterator[FlowVersion] {
+	return newIterator[FlowVersion](c, flowPath(id)+"/versions", "versions", opts.query())
+}
+
+// GetFlowVersion gets a version of a flow
+func (c *Client) GetFlowVersion(ctx context.Context, id string, version int) (*FlowVersion, error) {
+	var v FlowVersion
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: flowPath(id) + "/versions/" + strconv.Itoa(version)}, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func flowPath(id string) string {
+	return "/api/v1/flows/" + url.PathEscape(id)
+}
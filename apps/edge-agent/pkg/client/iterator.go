@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListOptions filters and orders a list. Sort names a sort field of the
+// endpoint, such as "createdAt" or "-name" for descending; Filter holds its
+// filter parameters, such as "status" or "flowId".
+type ListOptions struct {
+	// PageSize is the number of items fetched per request, up to 100
+	PageSize      int
+	Sort          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Filter        map[string]string
+}
+
+func (o *ListOptions) query() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	if o.PageSize > 0 {
+		q.Set("limit", strconv.Itoa(o.PageSize))
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if !o.CreatedAfter.IsZero() {
+		q.Set("createdAfter", o.CreatedAfter.Format(time.RFC3339))
+	}
+	if !o.CreatedBefore.IsZero() {
+		q.Set("createdBefore", o.CreatedBefore.Format(time.RFC3339))
+	}
+	for k, v := range o.Filter {
+		q.Set(k, v)
+	}
+	return q
+}
+
+// Iterator reads the items of a list endpoint, fetching each page when
+// the previous one is used up:
+//
+//	it := c.ListFlows(&client.ListOptions{Filter: map[string]string{"status": "active"}})
+//	for it.Next(ctx) {
+//		flow := it.Item()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator[T any] struct {
+	c     *Client
+	path  string
+	key   string
+	query url.Values
+
+	page  int
+	items []T
+	item  T
+	more  bool
+	total int
+	err   error
+}
+
+func newIterator[T any](c *Client, path, key string, query url.Values) *Iterator[T] {
+	return &Iterator[T]{c: c, path: path, key: key, query: query, more: true}
+}
+
+// Next advances to the next item, fetching the next page when needed. It
+// returns false at the end of the list or on an error.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	for len(it.items) == 0 {
+		if !it.more {
+			return false
+		}
+		if err := it.fetch(ctx); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	it.item, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// fetch reads the next page
+func (it *Iterator[T]) fetch(ctx context.Context) error {
+	it.page++
+	query := url.Values{}
+	for k, v := range it.query {
+		query[k] = v
+	}
+	query.Set("page", strconv.Itoa(it.page))
+
+	var resp map[string]json.RawMessage
+	if _, err := it.c.do(ctx, request{method: http.MethodGet, path: it.path, query: query}, &resp); err != nil {
+		return err
+	}
+	var items []T
+	if raw, ok := resp[it.key]; ok {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+	}
+	var links struct {
+		Next string `json:"next"`
+	}
+	if raw, ok := resp["links"]; ok {
+		_ = json.Unmarshal(raw, &links)
+	}
+	if raw, ok := resp["total"]; ok {
+		_ = json.Unmarshal(raw, &it.total)
+	}
+	it.items = items
+	// Pages are numbered rather than followed by link, so that a base URL
+	// behind a path-rewriting proxy keeps working
+	it.more = links.Next != "" && len(items) > 0
+	return nil
+}
+
+// Item returns the current item
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Total returns the number of items of the list as of the last page read
+func (it *Iterator[T]) Total() int {
+	return it.total
+}
+
+// All reads the remaining items
+func (it *Iterator[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for it.Next(ctx) {
+		all = append(all, it.Item())
+	}
+	return all, it.Err()
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TailExecutionLogs follows the log entries of an execution after the one
+// with seq after, 0 for all of them, calling fn for each until the
+// execution finishes, and returns its final status. A dropped stream is
+// resumed after the last entry received, with the client's retries. An
+// error returned by fn stops the tail and is returned as is.
+func (c *Client) TailExecutionLogs(ctx context.Context, id string, after int64, fn func(*ExecutionLog) error) (string, error) {
+	for attempt := 0; ; attempt++ {
+		seq := after
+		status, code, err := c.tail(ctx, id, &after, fn)
+		var stop *stopError
+		if errors.As(err, &stop) {
+			return "", stop.err
+		}
+		if err == nil {
+			return status, nil
+		}
+		if after != seq {
+			// The stream made progress before it dropped
+			attempt = 0
+		}
+		if attempt >= c.maxRetries || !retryable(code, err) {
+			return "", err
+		}
+		if err := sleep(ctx, c.backoff(attempt)); err != nil {
+			return "", err
+		}
+	}
+}
+
+// stopError is an error of the callback of a tail
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	return e.err.Error()
+}
+
+// tail reads the log event stream once, advancing after past each entry
+// passed to fn. It returns the final status from the "end" event, or the
+// response status along with an error.
+func (c *Client) tail(ctx context.Context, id string, after *int64, fn func(*ExecutionLog) error) (string, int, error) {
+	req := request{
+		method: http.MethodGet,
+		path:   executionPath(id) + "/logs/stream",
+		query:  url.Values{"after": {strconv.FormatInt(*after, 10)}},
+		header: http.Header{"Accept": {"text/event-stream"}},
+	}
+	httpReq, err := c.newRequest(ctx, req, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	// The stream lasts as long as the execution, past any client timeout
+	hc := *c.http
+	hc.Timeout = 0
+	resp, err := hc.Do(httpReq)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(resp.Body)
+		return "", resp.StatusCode, decodeError(resp.StatusCode, data)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			status, done, err := dispatchLogEvent(event, data.Bytes(), after, fn)
+			if done || err != nil {
+				return status, resp.StatusCode, err
+			}
+			event = ""
+			data.Reset()
+			continue
+		}
+		// Fields drop the space after their colon
+		if v, ok := strings.CutPrefix(line, "event:"); ok {
+			event = strings.TrimPrefix(v, " ")
+		} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(v, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, err
+	}
+	// Without an "end" event the stream was cut short
+	return "", 0, io.ErrUnexpectedEOF
+}
+
+// dispatchLogEvent handles one event of a log stream, reporting whether it
+// ended the stream
+func dispatchLogEvent(event string, data []byte, after *int64, fn func(*ExecutionLog) error) (string, bool, error) {
+	switch event {
+	case "log":
+		var log ExecutionLog
+		if err := json.Unmarshal(data, &log); err != nil {
+			return "", true, fmt.Errorf("failed to decode log entry: %w", err)
+		}
+		if err := fn(&log); err != nil {
+			return "", true, &stopError{err}
+		}
+		*after = log.Seq
+	case "end":
+		var end struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(data, &end); err != nil {
+			return "", true, fmt.Errorf("failed to decode end of log stream: %w", err)
+		}
+		return end.Status, true, nil
+	case "error":
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		return "", true, fmt.Errorf("log stream failed: %s", failure.Error)
+	}
+	return "", false, nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ListTasks lists the pending approval tasks, or those assigned to
+// assignee when set. The endpoint is not paged.
+func (c *Client) ListTasks(ctx context.Context, assignee string) ([]Task, error) {
+	query := url.Values{}
+	if assignee != "" {
+		query.Set("assignee", assignee)
+	}
+	var resp struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/tasks", query: query}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tasks, nil
+}
+
+// GetTask gets a pending approval task
+func (c *Client) GetTask(ctx context.Context, id string) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: taskPath(id)}, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ApproveTask approves a task as user by, resuming its execution
+func (c *Client) ApproveTask(ctx context.Context, id, by, comment string) (*Decision, error) {
+	return c.decide(ctx, id, "/approve", by, comment)
+}
+
+// RejectTask rejects a task as user by, resuming its execution
+func (c *Client) RejectTask(ctx context.Context, id, by, comment string) (*Decision, error) {
+	return c.decide(ctx, id, "/reject", by, comment)
+}
+
+func (c *Client) decide(ctx context.Context, id, action, by, comment string) (*Decision, error) {
+	var decision Decision
+	body := map[string]string{"by": by, "comment": comment}
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: taskPath(id) + action, body: body}, &decision); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+func taskPath(id string) string {
+	return "/api/v1/tasks/" + url.PathEscape(id)
+}
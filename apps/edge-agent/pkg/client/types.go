@@ -0,0 +1,79 @@
+package client
+
+import (
+	"time"
+
+	"github.com/fusionflow/edge-agent/internal/model"
+)
+
+// The resources of the API are the agent's own model types
+type (
+	Connector          = model.Connector
+	ConnectorAuth      = model.ConnectorAuth
+	CredentialRotation = model.CredentialRotation
+	Operation          = model.Operation
+	Bandwidth          = model.Bandwidth
+	Flow               = model.Flow
+	FlowVersion        = model.FlowVersion
+	Trigger            = model.Trigger
+	Step               = model.Step
+	Edge               = model.Edge
+	Policy             = model.Policy
+	Budget             = model.Budget
+	Execution          = model.Execution
+	ExecutionLog       = model.ExecutionLog
+	Task               = model.Task
+	Problem            = model.Problem
+)
+
+// FlowState is the state of a flow after a change of its status or
+// version
+type FlowState struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Draft is the draft version an update of an active flow was stored as,
+// until it is published
+type Draft struct {
+	ID           string `json:"id"`
+	Version      int    `json:"version"`
+	DraftVersion int    `json:"draftVersion"`
+	Message      string `json:"message"`
+}
+
+// ConnectionTest is the outcome of a connector's connection test
+type ConnectionTest struct {
+	ID        string `json:"id"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// Rotation is the record of a connector's credential rotation
+type Rotation struct {
+	ConnectorID string    `json:"connectorId"`
+	Name        string    `json:"name"`
+	Cause       string    `json:"cause"`
+	Secret      string    `json:"secret,omitempty"`
+	Reconnected bool      `json:"reconnected"`
+	Error       string    `json:"error,omitempty"`
+	RotatedAt   time.Time `json:"rotatedAt"`
+}
+
+// Cancellation is the outcome of an execution's cancellation request.
+// Owner is the cluster instance asked to cancel an execution it runs.
+type Cancellation struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// Decision is the outcome of an approval task's decision
+type Decision struct {
+	ID        string     `json:"id"`
+	Decision  string     `json:"decision"`
+	Execution *Execution `json:"execution"`
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fusionflow/edge-agent/internal/config"
+	"github.com/fusionflow/edge-agent/internal/migrate"
+	"github.com/fusionflow/edge-agent/internal/secrets"
+	"github.com/fusionflow/edge-agent/internal/snapshot"
+	"github.com/fusionflow/edge-agent/internal/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// passphraseEnv supplies the snapshot passphrase when --passphrase is not set
+const passphraseEnv = "FUSIONFLOW_EDGE_AGENT_SNAPSHOT_PASSPHRASE"
+
+// newSnapshotCmd builds the `snapshot` command tree for moving an agent's
+// state between machines
+func newSnapshotCmd() *cobra.Command {
+	var (
+		passphrase string
+		exclude    []string
+		noConfig   bool
+		force      bool
+		configOut  string
+	)
+
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create and restore encrypted snapshots of the agent's state",
+		Long: `Snapshots hold the store (flows, connectors, executions, outbox and dead
+letters, and all other agent state) and the configuration file in a single
+encrypted archive, for moving an agent to replacement hardware or cloning a
+site template. The passphrase is read from --passphrase or from
+` + passphraseEnv + `.
+
+Records of an encrypted store stay encrypted in snapshots, so restoring
+them needs the same storage encryption keys.
+
+Stop the agent before restoring; a running agent does not see restored
+flows until it restarts.`,
+	}
+	snapshotCmd.PersistentFlags().StringVar(&passphrase, "passphrase", "", "passphrase protecting the snapshot")
+	out := addOutputFlag(snapshotCmd)
+
+	createCmd := &cobra.Command{
+		Use:   "create FILE",
+		Short: "Write a snapshot of the store and configuration",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pass, err := snapshotPassphrase(passphrase)
+			if err != nil {
+				return err
+			}
+			return withSnapshotStore(func(ctx context.Context, cfg *config.Config, st store.Store, schema migrate.Status) error {
+				opts := snapshot.Options{SchemaVersion: schema.Current, Exclude: exclude}
+				if !noConfig && cfg.File != "" {
+					if opts.Config, err = os.ReadFile(cfg.File); err != nil {
+						return fmt.Errorf("failed to read config file: %w", err)
+					}
+				}
+
+				f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", args[0], err)
+				}
+				summary, err := snapshot.Create(ctx, st, f, pass, opts)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					os.Remove(args[0])
+					return err
+				}
+				return out.print(summary, func(w io.Writer) {
+					fmt.Fprintf(w, "Wrote snapshot of %s to %s (%d bytes)\n", describeRecords(summary.Records), args[0], summary.Bytes)
+				})
+			})
+		},
+	}
+	createCmd.Flags().StringSliceVar(&exclude, "exclude", nil, "buckets to leave out, e.g. executions,payloads for a site template")
+	createCmd.Flags().BoolVar(&noConfig, "no-config", false, "leave the configuration file out")
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Replace the store's data with a snapshot",
+		Long: `Restore the buckets in a snapshot into the configured store, replacing
+their current records. The archive is verified in full before anything is
+written. The snapshot's configuration is only written with --config-out.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pass, err := snapshotPassphrase(passphrase)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[0], err)
+			}
+			defer f.Close()
+
+			inspected, err := snapshot.Inspect(f, pass)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read %s: %w", args[0], err)
+			}
+
+			return withSnapshotStore(func(ctx context.Context, cfg *config.Config, st store.Store, schema migrate.Status) error {
+				restored, err := snapshot.Restore(ctx, st, f, pass, snapshot.RestoreOptions{Force: force, MaxSchemaVersion: schema.Latest})
+				if errors.Is(err, snapshot.ErrNotEmpty) {
+					return fmt.Errorf("%w; use --force to replace its data", err)
+				}
+				if err != nil {
+					return err
+				}
+				if err := out.print(restored, func(w io.Writer) {
+					fmt.Fprintf(w, "Restored %s from snapshot taken %s\n", describeRecords(restored.Records), inspected.Manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+				}); err != nil {
+					return err
+				}
+
+				if configOut != "" {
+					if restored.Config == nil {
+						return errors.New("snapshot has no configuration file")
+					}
+					if err := os.WriteFile(configOut, restored.Config, 0o600); err != nil {
+						return fmt.Errorf("failed to write %s: %w", configOut, err)
+					}
+					fmt.Fprintf(os.Stderr, "Wrote configuration to %s\n", configOut)
+				}
+				if restored.Manifest.SchemaVersion < schema.Latest {
+					fmt.Fprintln(os.Stderr, "The snapshot predates this agent's schema; run 'edge-agent migrate up' or start with auto_migrate")
+				}
+				return nil
+			})
+		},
+	}
+	restoreCmd.Flags().BoolVar(&force, "force", false, "replace the data of a store that is not empty")
+	restoreCmd.Flags().StringVar(&configOut, "config-out", "", "write the snapshot's configuration file to this path")
+
+	inspectCmd := &cobra.Command{
+		Use:   "inspect FILE",
+		Short: "Verify a snapshot and show what it holds",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pass, err := snapshotPassphrase(passphrase)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[0], err)
+			}
+			defer f.Close()
+
+			inspected, err := snapshot.Inspect(f, pass)
+			if err != nil {
+				return err
+			}
+			return out.print(inspected, func(w io.Writer) {
+				fmt.Fprintf(w, "Created:\t%s\nSchema version:\t%d\nConfiguration:\t%t\nRecords:\t%s\n",
+					inspected.Manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"), inspected.Manifest.SchemaVersion,
+					inspected.Manifest.Config, describeRecords(inspected.Records))
+			})
+		},
+	}
+
+	snapshotCmd.AddCommand(createCmd, restoreCmd, inspectCmd)
+	return snapshotCmd
+}
+
+// snapshotPassphrase returns the passphrase from the flag or the environment
+func snapshotPassphrase(flag string) (string, error) {
+	if flag != "" {
+		return flag, nil
+	}
+	if env := os.Getenv(passphraseEnv); env != "" {
+		return env, nil
+	}
+	return "", fmt.Errorf("a passphrase is required; set --passphrase or %s", passphraseEnv)
+}
+
+// withSnapshotStore opens the configured store and runs fn with it and its
+// schema status
+func withSnapshotStore(fn func(ctx context.Context, cfg *config.Config, st store.Store, schema migrate.Status) error) error {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := secrets.ExpandConfig(cfg); err != nil {
+		return fmt.Errorf("failed to resolve config secrets: %w", err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(cfg.LogLevel)
+
+	st, err := store.Open(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to open store: %w", err)
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	status, err := migrate.New(st, logger).Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read store schema: %w", err)
+	}
+	return fn(ctx, cfg, st, status)
+}
+
+// describeRecords summarizes per-bucket record counts
+func describeRecords(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	total := 0
+	for name, n := range counts {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return fmt.Sprintf("%d record(s) in %d bucket(s) [%s]", total, len(names), strings.Join(parts, " "))
+}